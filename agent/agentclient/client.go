@@ -33,6 +33,7 @@ var (
 type Client interface {
 	GetTag(tag string) (core.Digest, error)
 	Download(namespace string, d core.Digest) (io.ReadCloser, error)
+	DownloadBundle(namespace string, manifest core.Digest) error
 }
 
 // HTTPClient provides a wrapper for HTTP operations on an agent.
@@ -80,3 +81,17 @@ func (c *HTTPClient) Download(namespace string, d core.Digest) (io.ReadCloser, e
 	}
 	return resp.Body, nil
 }
+
+// DownloadBundle downloads all blobs referenced by the image manifest as a
+// single bundle. Returns once every blob is available on the agent.
+func (c *HTTPClient) DownloadBundle(namespace string, manifest core.Digest) error {
+	resp, err := httputil.Get(
+		fmt.Sprintf(
+			"http://%s/namespace/%s/bundles/%s",
+			c.addr, url.PathEscape(namespace), manifest))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -28,7 +28,9 @@ import (
 	"github.com/uber/kraken/lib/dockerdaemon"
 	"github.com/uber/kraken/lib/middleware"
 	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler"
+	"github.com/uber/kraken/utils/dockerutil"
 	"github.com/uber/kraken/utils/handler"
 	"github.com/uber/kraken/utils/httputil"
 
@@ -47,6 +49,7 @@ type Server struct {
 	sched     scheduler.ReloadableScheduler
 	tags      tagclient.Client
 	dockerCli dockerdaemon.DockerClient
+	netevents *networkevent.Stream
 }
 
 // New creates a new Server.
@@ -56,13 +59,14 @@ func New(
 	cads *store.CADownloadStore,
 	sched scheduler.ReloadableScheduler,
 	tags tagclient.Client,
-	dockerCli dockerdaemon.DockerClient) *Server {
+	dockerCli dockerdaemon.DockerClient,
+	netevents *networkevent.Stream) *Server {
 
 	stats = stats.Tagged(map[string]string{
 		"module": "agentserver",
 	})
 
-	return &Server{config, stats, cads, sched, tags, dockerCli}
+	return &Server{config, stats, cads, sched, tags, dockerCli, netevents}
 }
 
 // Handler returns the HTTP handler.
@@ -79,6 +83,8 @@ func (s *Server) Handler() http.Handler {
 	// GET /v2/<name>/blobs/<digest>
 	r.Get("/namespace/{namespace}/blobs/{digest}", handler.Wrap(s.downloadBlobHandler))
 
+	r.Get("/namespace/{namespace}/bundles/{digest}", handler.Wrap(s.downloadBundleHandler))
+
 	r.Delete("/blobs/{digest}", handler.Wrap(s.deleteBlobHandler))
 
 	// Preheat/preload endpoints.
@@ -89,6 +95,15 @@ func (s *Server) Handler() http.Handler {
 
 	r.Get("/x/blacklist", handler.Wrap(s.getBlacklistHandler))
 
+	r.Get("/x/bandwidth", handler.Wrap(s.getBandwidthHandler))
+
+	r.Get("/x/debug/scheduler/dump", handler.Wrap(s.getSchedulerDumpHandler))
+
+	// Streams network events, if enabled.
+	if s.netevents != nil {
+		r.Get("/x/networkevents", s.netevents.ServeHTTP)
+	}
+
 	// Serves /debug/pprof endpoints.
 	r.Mount("/", http.DefaultServeMux)
 
@@ -123,31 +138,88 @@ func (s *Server) downloadBlobHandler(w http.ResponseWriter, r *http.Request) err
 	if err != nil {
 		return err
 	}
+	priority, err := scheduler.ParsePriority(httputil.GetQueryArg(r, "priority", ""))
+	if err != nil {
+		return handler.Errorf("%s", err).Status(http.StatusBadRequest)
+	}
+	f, err := s.getOrDownload(r.Context(), namespace, d, priority)
+	if err != nil {
+		return err
+	}
+	// todo 整个文件下载完成后才返回？
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("copy file: %s", err)
+	}
+	return nil
+}
+
+// downloadBundleHandler downloads all blobs referenced by an image manifest as a
+// single bundle, so the blobs share peers and connections.
+func (s *Server) downloadBundleHandler(w http.ResponseWriter, r *http.Request) error {
+	namespace, err := httputil.ParseParam(r, "namespace")
+	if err != nil {
+		return err
+	}
+	d, err := parseDigest(r)
+	if err != nil {
+		return err
+	}
+	priority, err := scheduler.ParsePriority(httputil.GetQueryArg(r, "priority", ""))
+	if err != nil {
+		return handler.Errorf("%s", err).Status(http.StatusBadRequest)
+	}
+	f, err := s.getOrDownload(r.Context(), namespace, d, priority)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	manifest, _, err := dockerutil.ParseManifestV2(f)
+	if err != nil {
+		return handler.Errorf("parse manifest: %s", err).Status(http.StatusBadRequest)
+	}
+	layers, err := dockerutil.GetManifestReferences(manifest)
+	if err != nil {
+		return handler.Errorf("get manifest references: %s", err).Status(http.StatusBadRequest)
+	}
+	if err := s.sched.DownloadBundle(
+		r.Context(), namespace, d, layers, scheduler.WithPriority(priority)); err != nil {
+		if err == scheduler.ErrTorrentNotFound {
+			return handler.ErrorStatus(http.StatusNotFound)
+		}
+		return handler.Errorf("download bundle: %s", err)
+	}
+	return nil
+}
+
+// getOrDownload returns a reader of d, downloading d first if it is not cached.
+func (s *Server) getOrDownload(
+	ctx context.Context,
+	namespace string,
+	d core.Digest,
+	priority scheduler.Priority) (store.FileReader, error) {
+
 	// 查询缓存是否已经存在下载文件
 	f, err := s.cads.Cache().GetFileReader(d.Hex())
 	if err != nil {
 		if os.IsNotExist(err) || s.cads.InDownloadError(err) {
 			// 如果本地没有或者查询失败，则调用了 traker metaData 接口 获取
-			if err := s.sched.Download(namespace, d); err != nil {
+			err := s.sched.Download(ctx, namespace, d, scheduler.WithPriority(priority))
+			if err != nil {
 				if err == scheduler.ErrTorrentNotFound {
-					return handler.ErrorStatus(http.StatusNotFound)
+					return nil, handler.ErrorStatus(http.StatusNotFound)
 				}
-				return handler.Errorf("download torrent: %s", err)
+				return nil, handler.Errorf("download torrent: %s", err)
 			}
 			// 获取下载文件
 			f, err = s.cads.Cache().GetFileReader(d.Hex())
 			if err != nil {
-				return handler.Errorf("store: %s", err)
+				return nil, handler.Errorf("store: %s", err)
 			}
 		} else {
-			return handler.Errorf("store: %s", err)
+			return nil, handler.Errorf("store: %s", err)
 		}
 	}
-	// todo 整个文件下载完成后才返回？
-	if _, err := io.Copy(w, f); err != nil {
-		return fmt.Errorf("copy file: %s", err)
-	}
-	return nil
+	return f, nil
 }
 
 func (s *Server) deleteBlobHandler(w http.ResponseWriter, r *http.Request) error {
@@ -212,6 +284,25 @@ func (s *Server) getBlacklistHandler(w http.ResponseWriter, r *http.Request) err
 	return nil
 }
 
+func (s *Server) getBandwidthHandler(w http.ResponseWriter, r *http.Request) error {
+	snapshot := s.sched.BandwidthSnapshot()
+	if err := json.NewEncoder(w).Encode(&snapshot); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
+func (s *Server) getSchedulerDumpHandler(w http.ResponseWriter, r *http.Request) error {
+	dump, err := s.sched.Dump()
+	if err != nil {
+		return handler.Errorf("scheduler dump: %s", err)
+	}
+	if err := json.NewEncoder(w).Encode(dump); err != nil {
+		return handler.Errorf("json encode: %s", err)
+	}
+	return nil
+}
+
 // 解析 sha256 签名
 func parseDigest(r *http.Request) (core.Digest, error) {
 	raw, err := httputil.ParseParam(r, "digest")
@@ -28,11 +28,15 @@ import (
 	"github.com/uber/kraken/build-index/tagclient"
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler"
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
+	"github.com/uber/kraken/lib/torrent/scheduler/dispatch"
 	mocktagclient "github.com/uber/kraken/mocks/build-index/tagclient"
 	mockdockerdaemon "github.com/uber/kraken/mocks/lib/dockerdaemon"
 	mockscheduler "github.com/uber/kraken/mocks/lib/torrent/scheduler"
+	"github.com/uber/kraken/utils/bandwidth"
+	"github.com/uber/kraken/utils/dockerutil"
 	"github.com/uber/kraken/utils/httputil"
 	"github.com/uber/kraken/utils/testutil"
 
@@ -46,6 +50,7 @@ type serverMocks struct {
 	sched     *mockscheduler.MockReloadableScheduler
 	tags      *mocktagclient.MockClient
 	dockerCli *mockdockerdaemon.MockDockerClient
+	netevents *networkevent.Stream
 	cleanup   *testutil.Cleanup
 }
 
@@ -64,11 +69,14 @@ func newServerMocks(t *testing.T) (*serverMocks, func()) {
 
 	dockerCli := mockdockerdaemon.NewMockDockerClient(ctrl)
 
-	return &serverMocks{cads, sched, tags, dockerCli, &cleanup}, cleanup.Run
+	netevents := networkevent.NewStream(networkevent.StreamConfig{})
+	cleanup.Add(func() { netevents.Close() })
+
+	return &serverMocks{cads, sched, tags, dockerCli, netevents, &cleanup}, cleanup.Run
 }
 
 func (m *serverMocks) startServer() string {
-	s := New(Config{}, tally.NoopScope, m.cads, m.sched, m.tags, m.dockerCli)
+	s := New(Config{}, tally.NoopScope, m.cads, m.sched, m.tags, m.dockerCli, m.netevents)
 	addr, stop := testutil.StartServer(s.Handler())
 	m.cleanup.Add(stop)
 	return addr
@@ -118,8 +126,8 @@ func TestDownload(t *testing.T) {
 	namespace := core.TagFixture()
 	blob := core.NewBlobFixture()
 
-	mocks.sched.EXPECT().Download(namespace, blob.Digest).DoAndReturn(
-		func(namespace string, d core.Digest) error {
+	mocks.sched.EXPECT().Download(gomock.Any(), namespace, blob.Digest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, namespace string, d core.Digest, opts ...scheduler.DownloadOption) error {
 			return store.RunDownload(mocks.cads, d, blob.Content)
 		})
 
@@ -142,7 +150,7 @@ func TestDownloadNotFound(t *testing.T) {
 	namespace := core.TagFixture()
 	blob := core.NewBlobFixture()
 
-	mocks.sched.EXPECT().Download(namespace, blob.Digest).Return(scheduler.ErrTorrentNotFound)
+	mocks.sched.EXPECT().Download(gomock.Any(), namespace, blob.Digest, gomock.Any()).Return(scheduler.ErrTorrentNotFound)
 
 	addr := mocks.startServer()
 	c := agentclient.New(addr)
@@ -161,7 +169,7 @@ func TestDownloadUnknownError(t *testing.T) {
 	namespace := core.TagFixture()
 	blob := core.NewBlobFixture()
 
-	mocks.sched.EXPECT().Download(namespace, blob.Digest).Return(fmt.Errorf("test error"))
+	mocks.sched.EXPECT().Download(gomock.Any(), namespace, blob.Digest, gomock.Any()).Return(fmt.Errorf("test error"))
 
 	addr := mocks.startServer()
 	c := agentclient.New(addr)
@@ -171,6 +179,117 @@ func TestDownloadUnknownError(t *testing.T) {
 	require.True(httputil.IsStatus(err, 500))
 }
 
+func TestDownloadWithPriority(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.NewBlobFixture()
+
+	mocks.sched.EXPECT().Download(gomock.Any(), namespace, blob.Digest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, namespace string, d core.Digest, opts ...scheduler.DownloadOption) error {
+			return store.RunDownload(mocks.cads, d, blob.Content)
+		})
+
+	addr := mocks.startServer()
+
+	resp, err := httputil.Get(fmt.Sprintf(
+		"http://%s/namespace/%s/blobs/%s?priority=high",
+		addr, url.PathEscape(namespace), blob.Digest))
+	require.NoError(err)
+	defer resp.Body.Close()
+	result, err := ioutil.ReadAll(resp.Body)
+	require.NoError(err)
+	require.Equal(string(blob.Content), string(result))
+}
+
+func TestDownloadInvalidPriority(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.NewBlobFixture()
+
+	addr := mocks.startServer()
+
+	_, err := httputil.Get(fmt.Sprintf(
+		"http://%s/namespace/%s/blobs/%s?priority=urgent",
+		addr, url.PathEscape(namespace), blob.Digest))
+	require.Error(err)
+	require.True(httputil.IsStatus(err, 400))
+}
+
+func TestDownloadBundle(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	config := core.DigestFixture()
+	layer1 := core.DigestFixture()
+	layer2 := core.DigestFixture()
+	manifest, raw := dockerutil.ManifestFixture(config, layer1, layer2)
+
+	mocks.sched.EXPECT().Download(gomock.Any(), namespace, manifest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, namespace string, d core.Digest, opts ...scheduler.DownloadOption) error {
+			return store.RunDownload(mocks.cads, d, raw)
+		})
+	mocks.sched.EXPECT().DownloadBundle(
+		gomock.Any(), namespace, manifest, []core.Digest{config, layer1, layer2}, gomock.Any()).Return(nil)
+
+	addr := mocks.startServer()
+	c := agentclient.New(addr)
+
+	require.NoError(c.DownloadBundle(namespace, manifest))
+}
+
+func TestDownloadBundleNotFound(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	manifest, raw := dockerutil.ManifestFixture(
+		core.DigestFixture(), core.DigestFixture(), core.DigestFixture())
+
+	require.NoError(store.RunDownload(mocks.cads, manifest, raw))
+
+	mocks.sched.EXPECT().DownloadBundle(
+		gomock.Any(), namespace, manifest, gomock.Any(), gomock.Any()).Return(scheduler.ErrTorrentNotFound)
+
+	addr := mocks.startServer()
+	c := agentclient.New(addr)
+
+	err := c.DownloadBundle(namespace, manifest)
+	require.Error(err)
+	require.True(httputil.IsNotFound(err))
+}
+
+func TestDownloadBundleInvalidManifest(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.NewBlobFixture()
+
+	require.NoError(store.RunDownload(mocks.cads, blob.Digest, blob.Content))
+
+	addr := mocks.startServer()
+	c := agentclient.New(addr)
+
+	err := c.DownloadBundle(namespace, blob.Digest)
+	require.Error(err)
+	require.True(httputil.IsStatus(err, 400))
+}
+
 func TestHealthHandler(t *testing.T) {
 	tests := []struct {
 		desc     string
@@ -222,6 +341,34 @@ func TestPatchSchedulerConfigHandler(t *testing.T) {
 	require.NoError(err)
 }
 
+func TestPatchSchedulerConfigHandlerBandwidthClasses(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr := mocks.startServer()
+
+	var config scheduler.Config
+	config.Conn.Bandwidth = bandwidth.Config{
+		Enable:            true,
+		EgressBitsPerSec:  800,
+		IngressBitsPerSec: 800,
+		Classes: []bandwidth.ClassConfig{
+			{Name: "models", Namespaces: []string{"^ml/.*"}, Weight: 0.5},
+		},
+	}
+	b, err := json.Marshal(config)
+	require.NoError(err)
+
+	mocks.sched.EXPECT().Reload(config)
+
+	_, err = httputil.Patch(
+		fmt.Sprintf("http://%s/x/config/scheduler", addr),
+		httputil.SendBody(bytes.NewReader(b)))
+	require.NoError(err)
+}
+
 func TestGetBlacklistHandler(t *testing.T) {
 	require := require.New(t)
 
@@ -245,6 +392,112 @@ func TestGetBlacklistHandler(t *testing.T) {
 	require.Equal(blacklist, result)
 }
 
+func TestGetSchedulerDumpHandler(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	peerID := core.PeerIDFixture()
+	h := core.InfoHashFixture()
+	dump := &scheduler.StateDump{
+		PeerID: peerID,
+		Torrents: []scheduler.TorrentDump{{
+			Dump: dispatch.Dump{
+				InfoHash: h,
+				Digest:   core.DigestFixture(),
+				Bitfield: "101",
+				Peers:    []dispatch.PeerDump{{PeerID: core.PeerIDFixture(), Bitfield: "111"}},
+			},
+			Namespace: "foo",
+			Priority:  "normal",
+		}},
+		Blacklist: []connstate.BlacklistedConn{{
+			PeerID:    core.PeerIDFixture(),
+			InfoHash:  h,
+			Remaining: time.Second,
+		}},
+	}
+	mocks.sched.EXPECT().Dump().Return(dump, nil)
+
+	addr := mocks.startServer()
+
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/x/debug/scheduler/dump", addr))
+	require.NoError(err)
+
+	var result scheduler.StateDump
+	require.NoError(json.NewDecoder(resp.Body).Decode(&result))
+	require.Equal(dump, &result)
+}
+
+func TestNetworkEventsStream(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	addr := mocks.startServer()
+
+	h := core.InfoHashFixture()
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/x/networkevents?torrent=%s", addr, h))
+	require.NoError(err)
+	defer resp.Body.Close()
+
+	e := networkevent.TorrentCompleteEvent(h, core.PeerIDFixture())
+
+	// Events produced before the request subscribes are not streamed, so keep
+	// producing until one arrives.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				mocks.netevents.Write(networkevent.TorrentCompleteEvent(
+					core.InfoHashFixture(), core.PeerIDFixture()))
+				mocks.netevents.Write(e)
+			}
+		}
+	}()
+
+	var result networkevent.Event
+	require.NoError(json.NewDecoder(resp.Body).Decode(&result))
+	require.Equal(e.Name, result.Name)
+	require.Equal(e.Torrent, result.Torrent)
+	require.Equal(e.Self, result.Self)
+}
+
+func TestGetBandwidthHandler(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t)
+	defer cleanup()
+
+	snapshot := bandwidth.Snapshot{
+		Enable:            true,
+		EgressBitsPerSec:  800,
+		IngressBitsPerSec: 1600,
+		Adaptive: &bandwidth.AdaptiveSnapshot{
+			HostEgressBitsPerSec:   1000,
+			KrakenEgressBitsPerSec: 600,
+			OtherEgressBitsPerSec:  400,
+			Congested:              true,
+		},
+	}
+	mocks.sched.EXPECT().BandwidthSnapshot().Return(snapshot)
+
+	addr := mocks.startServer()
+
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/x/bandwidth", addr))
+	require.NoError(err)
+
+	var result bandwidth.Snapshot
+	require.NoError(json.NewDecoder(resp.Body).Decode(&result))
+	require.Equal(snapshot, result)
+}
+
 func TestDeleteBlobHandler(t *testing.T) {
 	require := require.New(t)
 
@@ -24,15 +24,18 @@ import (
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/dockerdaemon"
 	"github.com/uber/kraken/lib/dockerregistry/transfer"
+	"github.com/uber/kraken/lib/peertoken"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler"
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
+	"github.com/uber/kraken/tracker/tokenclient"
 	"github.com/uber/kraken/utils/configutil"
 	"github.com/uber/kraken/utils/log"
 	"github.com/uber/kraken/utils/netutil"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/uber-go/tally"
 	"go.uber.org/zap"
 )
@@ -167,7 +170,13 @@ func Run(flags *Flags, opts ...Option) {
 		log.Fatalf("Failed to create local store: %s", err)
 	}
 
-	netevents, err := networkevent.NewProducer(config.NetworkEvent)
+	var stream *networkevent.Stream
+	var sinks []networkevent.Sink
+	if config.NetworkEvent.Stream.Enable {
+		stream = networkevent.NewStream(config.NetworkEvent.Stream)
+		sinks = append(sinks, stream)
+	}
+	netevents, err := networkevent.NewProducer(config.NetworkEvent, sinks...)
 	if err != nil {
 		log.Fatalf("Failed to create network event producer: %s", err)
 	}
@@ -183,9 +192,19 @@ func Run(flags *Flags, opts ...Option) {
 		log.Fatalf("Error building client tls config: %s", err)
 	}
 
+	tokens, err := peertoken.NewIssuer(
+		config.PeerToken, pctx, clock.New(), tokenclient.New(trackers, tls))
+	if err != nil {
+		log.Fatalf("Error creating peer token issuer: %s", err)
+	}
+	tokenVerifier, err := peertoken.NewVerifier(config.PeerToken, clock.New(), stats)
+	if err != nil {
+		log.Fatalf("Error creating peer token verifier: %s", err)
+	}
+
 	// 创建 agent scheduler
 	sched, err := scheduler.NewAgentScheduler(
-		config.Scheduler, stats, pctx, cads, netevents, trackers, tls)
+		config.Scheduler, stats, pctx, cads, netevents, trackers, tls, tokens, tokenVerifier)
 	if err != nil {
 		log.Fatalf("Error creating scheduler: %s", err)
 	}
@@ -211,7 +230,7 @@ func Run(flags *Flags, opts ...Option) {
 	}
 
 	agentServer := agentserver.New(
-		config.AgentServer, stats, cads, sched, tagClient, dockerCli)
+		config.AgentServer, stats, cads, sched, tagClient, dockerCli, stream)
 	addr := fmt.Sprintf(":%d", flags.AgentServerPort)
 	log.Infof("Starting agent server on %s", addr)
 	go func() {
@@ -18,6 +18,7 @@ import (
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/dockerdaemon"
 	"github.com/uber/kraken/lib/dockerregistry"
+	"github.com/uber/kraken/lib/peertoken"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler"
@@ -46,4 +47,5 @@ type Config struct {
 	TLS             httputil.TLSConfig             `yaml:"tls"`
 	AllowedCidrs    []string                       `yaml:"allowed_cidrs"`
 	DockerDaemon    dockerdaemon.Config            `yaml:"docker_daemon"`
+	PeerToken       peertoken.Config               `yaml:"peer_token"`
 }
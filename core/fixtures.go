@@ -43,13 +43,13 @@ func CustomBlobFixture(content []byte, digest Digest, mi *MetaInfo) *BlobFixture
 }
 
 // SizedBlobFixture creates a randomly generated BlobFixture of given size with given piece lengths.
-func SizedBlobFixture(size uint64, pieceLength uint64) *BlobFixture {
+func SizedBlobFixture(size uint64, pieceLength uint64, opts ...MetaInfoOption) *BlobFixture {
 	b := randutil.Text(size)
 	d, err := NewDigester().FromBytes(b)
 	if err != nil {
 		panic(err)
 	}
-	mi, err := NewMetaInfo(d, bytes.NewReader(b), int64(pieceLength))
+	mi, err := NewMetaInfo(d, bytes.NewReader(b), int64(pieceLength), opts...)
 	if err != nil {
 		panic(err)
 	}
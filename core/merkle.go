@@ -0,0 +1,41 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package core
+
+import "crypto/sha256"
+
+// merkleRoot computes the root of a binary SHA-256 merkle tree whose leaves are
+// the concatenated piece hashes in layer. Leaves are padded with zero hashes up
+// to the next power of two, and each parent is the hash of its two children
+// concatenated. The root of a single leaf is the leaf itself. Returns a zero
+// hash for an empty layer.
+func merkleRoot(layer []byte) []byte {
+	n := len(layer) / sha256.Size
+	if n == 0 {
+		return make([]byte, sha256.Size)
+	}
+	width := 1
+	for width < n {
+		width *= 2
+	}
+	nodes := make([]byte, width*sha256.Size)
+	copy(nodes, layer)
+	for ; width > 1; width /= 2 {
+		for i := 0; i < width/2; i++ {
+			sum := sha256.Sum256(nodes[2*i*sha256.Size : (2*i+2)*sha256.Size])
+			copy(nodes[i*sha256.Size:], sum[:])
+		}
+	}
+	return nodes[:sha256.Size]
+}
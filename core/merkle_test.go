@@ -0,0 +1,54 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package core
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	a := sha256.Sum256([]byte("a"))
+	b := sha256.Sum256([]byte("b"))
+	c := sha256.Sum256([]byte("c"))
+	var zero [sha256.Size]byte
+
+	join := func(hs ...[sha256.Size]byte) []byte {
+		var out []byte
+		for _, h := range hs {
+			out = append(out, h[:]...)
+		}
+		return out
+	}
+	ab := sha256.Sum256(join(a, b))
+	c0 := sha256.Sum256(join(c, zero))
+
+	tests := []struct {
+		desc     string
+		layer    []byte
+		expected []byte
+	}{
+		{"empty", nil, zero[:]},
+		{"single leaf", join(a), a[:]},
+		{"two leaves", join(a, b), ab[:]},
+		{"padded leaves", join(a, b, c), join(sha256.Sum256(join(ab, c0)))},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require.Equal(t, test.expected, merkleRoot(test.layer))
+		})
+	}
+}
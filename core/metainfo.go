@@ -15,14 +15,28 @@ package core
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 
 	"github.com/jackpal/bencode-go"
 )
 
+// MetaInfo versions.
+const (
+	// MetaInfoV1 verifies pieces with CRC32 checksums. Checksums catch
+	// accidental corruption, but are trivial to collide on purpose.
+	MetaInfoV1 = 1
+
+	// MetaInfoV2 verifies pieces with SHA-256 hashes, optionally committed to
+	// through a merkle root.
+	MetaInfoV2 = 2
+)
+
 // info contains the "instructions" for how to download / seed a torrent,
 // primarily describing how a blob is broken up into pieces and how to verify
 // those pieces (i.e. the piece sums).
@@ -47,16 +61,89 @@ func (info *info) Hash() (InfoHash, error) {
 	return NewInfoHashFromBytes(b.Bytes()), nil
 }
 
+// infoV2 is the version 2 equivalent of info. The MetaVersion key keeps the
+// bencoded dict, and therefore the InfoHash, distinct from any version 1 info.
+// Exactly one of PieceHashes and MerkleRoot is set.
+type infoV2 struct {
+	// Exported for bencoding.
+	MetaVersion int
+	PieceLength int64
+	// Concatenated SHA-256 hashes of every piece.
+	PieceHashes []byte `bencode:"PieceHashes,omitempty" json:",omitempty"`
+	// Root of the merkle tree over the piece hashes. The piece hashes are then
+	// carried outside of the info and checked against the root.
+	MerkleRoot []byte `bencode:"MerkleRoot,omitempty" json:",omitempty"`
+	Name       string
+	Length     int64
+}
+
+// Hash computes the InfoHash of info.
+func (info *infoV2) Hash() (InfoHash, error) {
+	var b bytes.Buffer
+	if err := bencode.Marshal(&b, *info); err != nil {
+		return InfoHash{}, fmt.Errorf("bencode: %s", err)
+	}
+	return NewInfoHashFromBytes(b.Bytes()), nil
+}
+
 // MetaInfo contains torrent metadata.
 type MetaInfo struct {
-	info     info
+	version  int
+	info     info   // Version 1.
+	infoV2   infoV2 // Version 2.
 	infoHash InfoHash
 	digest   Digest
+
+	// pieceHashes holds the concatenated SHA-256 piece hashes of version 2
+	// metainfo, whether or not they are part of infoV2.
+	pieceHashes []byte
+}
+
+type metaInfoOptions struct {
+	version int
+	merkle  bool
+}
+
+// MetaInfoOption allows setting optional MetaInfo parameters.
+type MetaInfoOption func(*metaInfoOptions)
+
+// WithMetaInfoVersion sets the version of the generated MetaInfo. Defaults
+// to MetaInfoV1.
+func WithMetaInfoVersion(version int) MetaInfoOption {
+	return func(o *metaInfoOptions) { o.version = version }
+}
+
+// WithMerkleRoot commits version 2 MetaInfo to the merkle root of its piece
+// hashes rather than the piece hashes themselves. The full piece layer is still
+// shipped with the MetaInfo and checked against the root as a whole when
+// deserialized; there are no per-piece proofs. Implies MetaInfoV2.
+func WithMerkleRoot() MetaInfoOption {
+	return func(o *metaInfoOptions) {
+		o.version = MetaInfoV2
+		o.merkle = true
+	}
 }
 
 // NewMetaInfo creates a new MetaInfo. Assumes that d is the valid digest for
 // blob (re-computing it is expensive).
-func NewMetaInfo(d Digest, blob io.Reader, pieceLength int64) (*MetaInfo, error) {
+func NewMetaInfo(
+	d Digest, blob io.Reader, pieceLength int64, opts ...MetaInfoOption) (*MetaInfo, error) {
+
+	o := metaInfoOptions{version: MetaInfoV1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	switch o.version {
+	case MetaInfoV1:
+		return newMetaInfoV1(d, blob, pieceLength)
+	case MetaInfoV2:
+		return newMetaInfoV2(d, blob, pieceLength, o.merkle)
+	default:
+		return nil, fmt.Errorf("unsupported metainfo version: %d", o.version)
+	}
+}
+
+func newMetaInfoV1(d Digest, blob io.Reader, pieceLength int64) (*MetaInfo, error) {
 	// 计算分片sum
 	length, pieceSums, err := calcPieceSums(blob, pieceLength)
 	if err != nil {
@@ -73,12 +160,58 @@ func NewMetaInfo(d Digest, blob io.Reader, pieceLength int64) (*MetaInfo, error)
 		return nil, fmt.Errorf("compute info hash: %s", err)
 	}
 	return &MetaInfo{
+		version:  MetaInfoV1,
 		info:     info,
 		infoHash: h,
 		digest:   d,
 	}, nil
 }
 
+func newMetaInfoV2(
+	d Digest, blob io.Reader, pieceLength int64, merkle bool) (*MetaInfo, error) {
+
+	length, pieceHashes, err := calcPieceHashes(blob, pieceLength)
+	if err != nil {
+		return nil, err
+	}
+	info := infoV2{
+		MetaVersion: MetaInfoV2,
+		PieceLength: pieceLength,
+		Name:        d.Hex(),
+		Length:      length,
+	}
+	if merkle {
+		info.MerkleRoot = merkleRoot(pieceHashes)
+	} else {
+		info.PieceHashes = pieceHashes
+	}
+	h, err := info.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("compute info hash: %s", err)
+	}
+	return &MetaInfo{
+		version:     MetaInfoV2,
+		infoV2:      info,
+		infoHash:    h,
+		digest:      d,
+		pieceHashes: pieceHashes,
+	}, nil
+}
+
+// Version returns the MetaInfo version.
+func (mi *MetaInfo) Version() int {
+	if mi.version == MetaInfoV2 {
+		return MetaInfoV2
+	}
+	return MetaInfoV1
+}
+
+// HasMerkleRoot returns true if mi commits to the merkle root of its piece
+// hashes. See WithMerkleRoot.
+func (mi *MetaInfo) HasMerkleRoot() bool {
+	return mi.Version() == MetaInfoV2 && mi.infoV2.MerkleRoot != nil
+}
+
 // InfoHash returns the torrent InfoHash.
 func (mi *MetaInfo) InfoHash() InfoHash {
 	return mi.infoHash
@@ -91,11 +224,17 @@ func (mi *MetaInfo) Digest() Digest {
 
 // Length returns the length of the original blob.
 func (mi *MetaInfo) Length() int64 {
+	if mi.Version() == MetaInfoV2 {
+		return mi.infoV2.Length
+	}
 	return mi.info.Length
 }
 
 // NumPieces returns the number of pieces in the torrent.
 func (mi *MetaInfo) NumPieces() int {
+	if mi.Version() == MetaInfoV2 {
+		return len(mi.pieceHashes) / sha256.Size
+	}
 	return len(mi.info.PieceSums)
 }
 
@@ -103,24 +242,43 @@ func (mi *MetaInfo) NumPieces() int {
 // the final piece may be shorter than this. Use GetPieceLength for the true
 // lengths of each piece.
 func (mi *MetaInfo) PieceLength() int64 {
+	if mi.Version() == MetaInfoV2 {
+		return mi.infoV2.PieceLength
+	}
 	return mi.info.PieceLength
 }
 
 // GetPieceLength returns the length of piece i.
 func (mi *MetaInfo) GetPieceLength(i int) int64 {
-	if i < 0 || i >= len(mi.info.PieceSums) {
+	n := mi.NumPieces()
+	if i < 0 || i >= n {
 		return 0
 	}
-	if i == len(mi.info.PieceSums)-1 {
+	if i == n-1 {
 		// Last piece. 最后一个分片的计算，文件总长度 - 之前的分片总长
-		return mi.info.Length - mi.info.PieceLength*int64(i)
+		return mi.Length() - mi.PieceLength()*int64(i)
 	}
-	return mi.info.PieceLength
+	return mi.PieceLength()
 }
 
-// GetPieceSum returns the checksum of piece i. Does not check bounds.
-func (mi *MetaInfo) GetPieceSum(i int) uint32 {
-	return mi.info.PieceSums[i]
+// NewPieceHash returns the hash used to sum pieces of mi.
+func (mi *MetaInfo) NewPieceHash() hash.Hash {
+	if mi.Version() == MetaInfoV2 {
+		return sha256.New()
+	}
+	return PieceHash()
+}
+
+// VerifyPiece returns whether sum, as computed by a NewPieceHash, matches the
+// expected sum of piece i.
+func (mi *MetaInfo) VerifyPiece(i int, sum []byte) bool {
+	if i < 0 || i >= mi.NumPieces() {
+		return false
+	}
+	if mi.Version() == MetaInfoV2 {
+		return bytes.Equal(sum, mi.pieceHashes[i*sha256.Size:(i+1)*sha256.Size])
+	}
+	return len(sum) == 4 && binary.BigEndian.Uint32(sum) == mi.info.PieceSums[i]
 }
 
 // metaInfoJSON is used for serializing / deserializing MetaInfo.
@@ -129,13 +287,48 @@ type metaInfoJSON struct {
 	Info info `json:"Info"`
 }
 
+// metaInfoV2JSON is used for serializing / deserializing version 2 MetaInfo.
+type metaInfoV2JSON struct {
+	Version int    `json:"Version"`
+	Info    infoV2 `json:"Info"`
+	// Every piece hash, when Info only has their merkle root. The full layer is
+	// always shipped and checked against the root on deserialization.
+	PieceLayer []byte `json:"PieceLayer,omitempty"`
+}
+
 // Serialize converts mi to a json blob.
 func (mi *MetaInfo) Serialize() ([]byte, error) {
+	if mi.Version() == MetaInfoV2 {
+		j := metaInfoV2JSON{Version: MetaInfoV2, Info: mi.infoV2}
+		if mi.infoV2.MerkleRoot != nil {
+			j.PieceLayer = mi.pieceHashes
+		}
+		return json.Marshal(&j)
+	}
 	return json.Marshal(&metaInfoJSON{mi.info})
 }
 
-// DeserializeMetaInfo reconstructs a MetaInfo from a json blob.
+// DeserializeMetaInfo reconstructs a MetaInfo from a json blob. Both version 1
+// and version 2 blobs are accepted.
 func DeserializeMetaInfo(data []byte) (*MetaInfo, error) {
+	var v struct {
+		Version int `json:"Version"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("json: %s", err)
+	}
+	switch v.Version {
+	case 0, MetaInfoV1:
+		// Version 1 blobs predate the version field.
+		return deserializeMetaInfoV1(data)
+	case MetaInfoV2:
+		return deserializeMetaInfoV2(data)
+	default:
+		return nil, fmt.Errorf("unsupported metainfo version: %d", v.Version)
+	}
+}
+
+func deserializeMetaInfoV1(data []byte) (*MetaInfo, error) {
 	var j metaInfoJSON
 	if err := json.Unmarshal(data, &j); err != nil {
 		return nil, fmt.Errorf("json: %s", err)
@@ -149,12 +342,58 @@ func DeserializeMetaInfo(data []byte) (*MetaInfo, error) {
 		return nil, fmt.Errorf("parse name: %s", err)
 	}
 	return &MetaInfo{
+		version:  MetaInfoV1,
 		info:     j.Info,
 		infoHash: h,
 		digest:   d,
 	}, nil
 }
 
+func deserializeMetaInfoV2(data []byte) (*MetaInfo, error) {
+	var j metaInfoV2JSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("json: %s", err)
+	}
+	if j.Info.MetaVersion != MetaInfoV2 {
+		return nil, fmt.Errorf("info has version %d, expected %d", j.Info.MetaVersion, MetaInfoV2)
+	}
+	if j.Info.PieceLength <= 0 {
+		return nil, errors.New("piece length must be positive")
+	}
+	pieceHashes := j.Info.PieceHashes
+	if j.Info.MerkleRoot != nil {
+		if j.Info.PieceHashes != nil {
+			return nil, errors.New("info has both piece hashes and merkle root")
+		}
+		pieceHashes = j.PieceLayer
+	}
+	if len(pieceHashes)%sha256.Size != 0 {
+		return nil, fmt.Errorf("piece hashes length %d is not a multiple of %d", len(pieceHashes), sha256.Size)
+	}
+	numPieces := int64(len(pieceHashes) / sha256.Size)
+	if expected := (j.Info.Length + j.Info.PieceLength - 1) / j.Info.PieceLength; numPieces != expected {
+		return nil, fmt.Errorf("got %d piece hashes, expected %d", numPieces, expected)
+	}
+	if j.Info.MerkleRoot != nil && !bytes.Equal(merkleRoot(pieceHashes), j.Info.MerkleRoot) {
+		return nil, errors.New("piece layer does not match merkle root")
+	}
+	h, err := j.Info.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("compute info hash: %s", err)
+	}
+	d, err := NewSHA256DigestFromHex(j.Info.Name)
+	if err != nil {
+		return nil, fmt.Errorf("parse name: %s", err)
+	}
+	return &MetaInfo{
+		version:     MetaInfoV2,
+		infoV2:      j.Info,
+		infoHash:    h,
+		digest:      d,
+		pieceHashes: pieceHashes,
+	}, nil
+}
+
 // calcPieceSums hashes blob content in pieceLength chunks.
 // 读取 blob数据，并且计算对应的文件长度和分片md5值
 func calcPieceSums(blob io.Reader, pieceLength int64) (length int64, pieceSums []uint32, err error) {
@@ -179,3 +418,27 @@ func calcPieceSums(blob io.Reader, pieceLength int64) (length int64, pieceSums [
 	}
 	return length, pieceSums, nil
 }
+
+// calcPieceHashes is the SHA-256 equivalent of calcPieceSums, returning the
+// concatenated hashes of each piece.
+func calcPieceHashes(blob io.Reader, pieceLength int64) (length int64, pieceHashes []byte, err error) {
+	if pieceLength <= 0 {
+		return 0, nil, errors.New("piece length must be positive")
+	}
+	for {
+		h := sha256.New()
+		n, err := io.CopyN(h, blob, pieceLength)
+		if err != nil && err != io.EOF {
+			return 0, nil, fmt.Errorf("read blob: %s", err)
+		}
+		length += n
+		if n == 0 {
+			break
+		}
+		pieceHashes = h.Sum(pieceHashes)
+		if n < pieceLength {
+			break
+		}
+	}
+	return length, pieceHashes, nil
+}
@@ -14,12 +14,17 @@
 package core
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
 	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/uber/kraken/utils/memsize"
+	"github.com/uber/kraken/utils/randutil"
 )
 
 func TestMetaInfoGetPieceLength(t *testing.T) {
@@ -75,6 +80,123 @@ func TestMetaInfoBackwardsCompatibility(t *testing.T) {
 	require.Equal(expectedInfoHash, result.InfoHash())
 }
 
+func TestMetaInfoV2Serialization(t *testing.T) {
+	tests := []struct {
+		desc string
+		opts []MetaInfoOption
+	}{
+		{"piece hashes", []MetaInfoOption{WithMetaInfoVersion(MetaInfoV2)}},
+		{"merkle root", []MetaInfoOption{WithMerkleRoot()}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			content := randutil.Text(100)
+			d, err := NewDigester().FromBytes(content)
+			require.NoError(err)
+
+			mi, err := NewMetaInfo(d, bytes.NewReader(content), 16, test.opts...)
+			require.NoError(err)
+			require.Equal(MetaInfoV2, mi.Version())
+			require.Equal(7, mi.NumPieces())
+			require.Equal(int64(4), mi.GetPieceLength(6))
+
+			b, err := mi.Serialize()
+			require.NoError(err)
+			result, err := DeserializeMetaInfo(b)
+			require.NoError(err)
+			require.Equal(MetaInfoV2, result.Version())
+			require.Equal(d, result.Digest())
+			require.Equal(mi.InfoHash(), result.InfoHash())
+			require.Equal(mi.NumPieces(), result.NumPieces())
+			require.Equal(mi.Length(), result.Length())
+		})
+	}
+}
+
+func TestMetaInfoVersionsHaveDistinctInfoHashes(t *testing.T) {
+	require := require.New(t)
+
+	content := randutil.Text(100)
+	d, err := NewDigester().FromBytes(content)
+	require.NoError(err)
+
+	hashes := make(map[InfoHash]bool)
+	for _, opts := range [][]MetaInfoOption{
+		nil,
+		{WithMetaInfoVersion(MetaInfoV2)},
+		{WithMerkleRoot()},
+	} {
+		mi, err := NewMetaInfo(d, bytes.NewReader(content), 16, opts...)
+		require.NoError(err)
+		hashes[mi.InfoHash()] = true
+	}
+	require.Len(hashes, 3)
+}
+
+func TestNewMetaInfoUnsupportedVersion(t *testing.T) {
+	blob := NewBlobFixture()
+	_, err := NewMetaInfo(blob.Digest, bytes.NewReader(blob.Content), 4, WithMetaInfoVersion(3))
+	require.Error(t, err)
+}
+
+func TestMetaInfoVerifyPiece(t *testing.T) {
+	for _, version := range []int{MetaInfoV1, MetaInfoV2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			require := require.New(t)
+
+			content := randutil.Text(32)
+			d, err := NewDigester().FromBytes(content)
+			require.NoError(err)
+
+			mi, err := NewMetaInfo(
+				d, bytes.NewReader(content), 16, WithMetaInfoVersion(version))
+			require.NoError(err)
+
+			for i := 0; i < mi.NumPieces(); i++ {
+				h := mi.NewPieceHash()
+				h.Write(content[i*16 : (i+1)*16])
+				require.True(mi.VerifyPiece(i, h.Sum(nil)))
+			}
+
+			h := mi.NewPieceHash()
+			h.Write(randutil.Text(16))
+			require.False(mi.VerifyPiece(0, h.Sum(nil)))
+			require.False(mi.VerifyPiece(2, h.Sum(nil)))
+		})
+	}
+}
+
+func TestDeserializeMetaInfoV2RejectsTamperedPieceLayer(t *testing.T) {
+	require := require.New(t)
+
+	content := randutil.Text(100)
+	d, err := NewDigester().FromBytes(content)
+	require.NoError(err)
+
+	mi, err := NewMetaInfo(d, bytes.NewReader(content), 16, WithMerkleRoot())
+	require.NoError(err)
+
+	b, err := mi.Serialize()
+	require.NoError(err)
+
+	var j metaInfoV2JSON
+	require.NoError(json.Unmarshal(b, &j))
+	forged := sha256.Sum256([]byte("forged"))
+	copy(j.PieceLayer[sha256.Size:], forged[:])
+	b, err = json.Marshal(j)
+	require.NoError(err)
+
+	_, err = DeserializeMetaInfo(b)
+	require.Error(err)
+}
+
+func TestDeserializeMetaInfoUnsupportedVersion(t *testing.T) {
+	_, err := DeserializeMetaInfo([]byte(`{"Version":3,"Info":{}}`))
+	require.Error(t, err)
+}
+
 func TestMetaInfoSerializationLimit(t *testing.T) {
 
 	// MetaInfo is stored as raw bytes as a Redis value, and should stay
@@ -71,3 +71,24 @@ func SortedByPeerID(peers []*PeerInfo) []*PeerInfo {
 	sort.Sort(PeersByPeerID{PeerInfos(c)})
 	return c
 }
+
+// PeerReputation is the reputation a peer has assigned to another peer, based
+// on the pieces it has received from it. Scores range from 0 (always serves
+// invalid or stalled pieces) to 1 (no known misbehavior).
+type PeerReputation struct {
+	PeerID PeerID  `json:"peer_id"`
+	Score  float64 `json:"score"`
+}
+
+// PeerLoad is the upload load a peer reports in its announces.
+type PeerLoad struct {
+	// EgressBitsPerSec is the measured egress rate of the peer.
+	EgressBitsPerSec uint64 `json:"egress_bits_per_sec"`
+
+	// EgressUtilization is the fraction of the egress limit of the peer in use,
+	// or zero if the peer is not limited.
+	EgressUtilization float64 `json:"egress_utilization"`
+
+	// ActiveUploads is the number of conns the peer recently sent pieces over.
+	ActiveUploads int `json:"active_uploads"`
+}
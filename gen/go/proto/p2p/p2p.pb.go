@@ -48,6 +48,29 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+type PiecePayloadMessage_Compression int32
+
+const (
+	PiecePayloadMessage_NONE PiecePayloadMessage_Compression = 0
+	PiecePayloadMessage_ZSTD PiecePayloadMessage_Compression = 1
+)
+
+var PiecePayloadMessage_Compression_name = map[int32]string{
+	0: "NONE",
+	1: "ZSTD",
+}
+var PiecePayloadMessage_Compression_value = map[string]int32{
+	"NONE": 0,
+	"ZSTD": 1,
+}
+
+func (x PiecePayloadMessage_Compression) String() string {
+	return proto.EnumName(PiecePayloadMessage_Compression_name, int32(x))
+}
+func (PiecePayloadMessage_Compression) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{2, 0}
+}
+
 type ErrorMessage_ErrorCode int32
 
 const (
@@ -122,6 +145,13 @@ type BitfieldMessage struct {
 	// remoteBitfieldBytes contains the binary sets of pieces downloaded of
 	// all peers that the sender is currently connected to.
 	RemoteBitfieldBytes map[string][]byte `protobuf:"bytes,7,rep,name=remoteBitfieldBytes" json:"remoteBitfieldBytes,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// capabilities lists the optional protocol features supported by the
+	// sender. Peers which predate capability negotiation never set this field,
+	// and receivers must ignore capabilities they do not recognize.
+	Capabilities []string `protobuf:"bytes,8,rep,name=capabilities" json:"capabilities,omitempty"`
+	// token proves the sender is enrolled in the cluster. Only set if peer
+	// tokens are enabled.
+	Token string `protobuf:"bytes,9,opt,name=token" json:"token,omitempty"`
 }
 
 func (m *BitfieldMessage) Reset()                    { *m = BitfieldMessage{} }
@@ -136,6 +166,20 @@ func (m *BitfieldMessage) GetRemoteBitfieldBytes() map[string][]byte {
 	return nil
 }
 
+func (m *BitfieldMessage) GetCapabilities() []string {
+	if m != nil {
+		return m.Capabilities
+	}
+	return nil
+}
+
+func (m *BitfieldMessage) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
 // Requests a piece of the given index. Note: offset and length are unused fields
 // and if set, will be rejected.
 type PieceRequestMessage struct {
@@ -157,6 +201,12 @@ type PiecePayloadMessage struct {
 	Offset int32  `protobuf:"varint,3,opt,name=offset" json:"offset,omitempty"`
 	Length int32  `protobuf:"varint,4,opt,name=length" json:"length,omitempty"`
 	Digest string `protobuf:"bytes,5,opt,name=digest" json:"digest,omitempty"`
+	// compression is the encoding of the blob which follows the message. Only
+	// set if both peers negotiated the compression capability.
+	Compression PiecePayloadMessage_Compression `protobuf:"varint,6,opt,name=compression,enum=p2p.PiecePayloadMessage_Compression" json:"compression,omitempty"`
+	// compressedLength is the size of the blob which follows the message when
+	// compression is set. length is always the size of the decompressed piece.
+	CompressedLength int32 `protobuf:"varint,7,opt,name=compressedLength" json:"compressedLength,omitempty"`
 }
 
 func (m *PiecePayloadMessage) Reset()                    { *m = PiecePayloadMessage{} }
@@ -207,6 +257,8 @@ func (*CompleteMessage) ProtoMessage()               {}
 func (*CompleteMessage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
 
 type Message struct {
+	// version is the p2p protocol version of the sender. Only set on bitfield
+	// messages. Empty for peers which predate protocol versioning.
 	Version       string                `protobuf:"bytes,1,opt,name=version" json:"version,omitempty"`
 	Type          Message_Type          `protobuf:"varint,2,opt,name=type,enum=p2p.Message_Type" json:"type,omitempty"`
 	Bitfield      *BitfieldMessage      `protobuf:"bytes,3,opt,name=bitfield" json:"bitfield,omitempty"`
@@ -281,6 +333,7 @@ func init() {
 	proto.RegisterType((*ErrorMessage)(nil), "p2p.ErrorMessage")
 	proto.RegisterType((*CompleteMessage)(nil), "p2p.CompleteMessage")
 	proto.RegisterType((*Message)(nil), "p2p.Message")
+	proto.RegisterEnum("p2p.PiecePayloadMessage_Compression", PiecePayloadMessage_Compression_name, PiecePayloadMessage_Compression_value)
 	proto.RegisterEnum("p2p.ErrorMessage_ErrorCode", ErrorMessage_ErrorCode_name, ErrorMessage_ErrorCode_value)
 	proto.RegisterEnum("p2p.Message_Type", Message_Type_name, Message_Type_value)
 }
@@ -288,46 +341,52 @@ func init() {
 func init() { proto.RegisterFile("proto/p2p/p2p.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 647 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xac, 0x54, 0x4f, 0x6f, 0xd3, 0x4e,
-	0x10, 0x6d, 0x12, 0x3b, 0x7f, 0x26, 0x69, 0xeb, 0x6c, 0xa3, 0xdf, 0xcf, 0x14, 0x0e, 0x95, 0x45,
-	0x45, 0x85, 0xa0, 0xad, 0xcc, 0x05, 0x10, 0x12, 0x4a, 0x1c, 0x57, 0x44, 0x4a, 0x9b, 0xb0, 0xa4,
-	0x07, 0xc4, 0xa1, 0x72, 0x9d, 0x49, 0x6b, 0x91, 0x7a, 0x8d, 0xed, 0x56, 0xcd, 0xd7, 0xe0, 0x43,
-	0x21, 0xf1, 0xad, 0xd0, 0x4e, 0xec, 0xc4, 0x6e, 0x02, 0xe2, 0xc0, 0x21, 0x92, 0xdf, 0xf3, 0x7b,
-	0xb3, 0xb3, 0x33, 0x2f, 0x86, 0x9d, 0x20, 0x14, 0xb1, 0x38, 0x0a, 0xcc, 0x40, 0xfe, 0x0e, 0x09,
-	0xb1, 0x52, 0x60, 0x06, 0xc6, 0x8f, 0x22, 0x6c, 0x77, 0xbc, 0x78, 0xe2, 0xe1, 0x74, 0x7c, 0x8a,
-	0x51, 0xe4, 0x5c, 0x21, 0xdb, 0x85, 0xaa, 0xe7, 0x4f, 0xc4, 0x07, 0x27, 0xba, 0xd6, 0x8b, 0x7b,
-	0x85, 0x83, 0x1a, 0x5f, 0x60, 0xc6, 0x40, 0xf1, 0x9d, 0x1b, 0xd4, 0x4b, 0xc4, 0xd3, 0x33, 0xfb,
-	0x0f, 0xca, 0x01, 0x62, 0xd8, 0xeb, 0xea, 0x0a, 0xb1, 0x09, 0x62, 0x4f, 0x61, 0xf3, 0x32, 0x29,
-	0xdd, 0x99, 0xc5, 0x18, 0xe9, 0xea, 0x5e, 0xe1, 0xa0, 0xc1, 0xf3, 0x24, 0x7b, 0x02, 0x35, 0x59,
-	0x25, 0x0a, 0x1c, 0x17, 0xf5, 0x32, 0x15, 0x58, 0x12, 0xec, 0x02, 0x76, 0x42, 0xbc, 0x11, 0x31,
-	0x76, 0x72, 0x95, 0x2a, 0x7b, 0xa5, 0x83, 0xba, 0xf9, 0xf2, 0x50, 0xde, 0xe6, 0x41, 0xfb, 0x87,
-	0x7c, 0x55, 0x6f, 0xfb, 0x71, 0x38, 0xe3, 0xeb, 0x2a, 0xed, 0x9e, 0x80, 0xfe, 0x3b, 0x03, 0xd3,
-	0xa0, 0xf4, 0x15, 0x67, 0x7a, 0x81, 0x9a, 0x92, 0x8f, 0xac, 0x05, 0xea, 0x9d, 0x33, 0xbd, 0x45,
-	0x9a, 0x4b, 0x83, 0xcf, 0xc1, 0xdb, 0xe2, 0xeb, 0x82, 0xf1, 0x05, 0x76, 0x86, 0x1e, 0xba, 0xc8,
-	0xf1, 0xdb, 0x2d, 0x46, 0x71, 0x3a, 0xcb, 0x16, 0xa8, 0x9e, 0x3f, 0xc6, 0x7b, 0x32, 0xa8, 0x7c,
-	0x0e, 0xe4, 0xc4, 0xc4, 0x64, 0x12, 0x61, 0x4c, 0x73, 0x54, 0x79, 0x82, 0x24, 0x3f, 0x45, 0xff,
-	0x2a, 0xbe, 0xa6, 0x49, 0xaa, 0x3c, 0x41, 0x46, 0x94, 0x14, 0x1f, 0x3a, 0xb3, 0xa9, 0x70, 0xc6,
-	0xff, 0xb4, 0xb8, 0xe4, 0xc7, 0xde, 0x15, 0x46, 0x31, 0xed, 0xa7, 0xc6, 0x13, 0x64, 0xbc, 0x80,
-	0x56, 0xdb, 0xf7, 0xc5, 0xad, 0xef, 0x22, 0x1d, 0xfe, 0xc7, 0x53, 0x8d, 0xe7, 0xc0, 0x2c, 0xc7,
-	0x77, 0x71, 0xfa, 0x17, 0xda, 0xef, 0x05, 0x68, 0xd8, 0x61, 0x28, 0xc2, 0x8c, 0x0c, 0x25, 0x4e,
-	0xe2, 0x36, 0x07, 0x4b, 0x73, 0x29, 0x7b, 0xbd, 0x23, 0x50, 0x5c, 0x31, 0x46, 0xba, 0xc4, 0x96,
-	0xf9, 0x98, 0x22, 0x90, 0x2d, 0x36, 0x07, 0x96, 0x18, 0x23, 0x27, 0xa1, 0xb1, 0x0f, 0xb5, 0x05,
-	0xc5, 0x74, 0x68, 0x0d, 0x7b, 0xb6, 0x65, 0x5f, 0x70, 0xfb, 0xe3, 0xb9, 0xfd, 0x69, 0x74, 0x71,
-	0xd2, 0xee, 0xf5, 0xed, 0xae, 0xb6, 0x61, 0x34, 0x61, 0xdb, 0x12, 0x37, 0xc1, 0x14, 0xe3, 0xb4,
-	0x7b, 0xe3, 0xa7, 0x02, 0x95, 0xb4, 0x45, 0x1d, 0x2a, 0x77, 0x18, 0x46, 0x9e, 0xf0, 0x93, 0x3c,
-	0xa4, 0x90, 0xed, 0x83, 0x12, 0xcf, 0x82, 0x79, 0x24, 0xb6, 0xcc, 0x26, 0x35, 0x94, 0xf6, 0x32,
-	0x9a, 0x05, 0xc8, 0xe9, 0x35, 0x3b, 0x86, 0x6a, 0x1a, 0x7c, 0xba, 0x50, 0xdd, 0x6c, 0xad, 0x8b,
-	0x2f, 0x5f, 0xa8, 0xd8, 0x3b, 0x68, 0x04, 0x99, 0x48, 0xd1, 0x8d, 0xeb, 0xa6, 0x4e, 0xae, 0x35,
-	0x59, 0xe3, 0x39, 0xf5, 0xc2, 0x9d, 0x64, 0x86, 0x96, 0x9b, 0x73, 0xe7, 0xc3, 0xc4, 0x73, 0x6a,
-	0xf6, 0x1e, 0x36, 0x9d, 0xec, 0xf2, 0xe9, 0x9f, 0x59, 0x37, 0x1f, 0x91, 0x7d, 0x5d, 0x2c, 0x78,
-	0x5e, 0xcf, 0xde, 0x40, 0xdd, 0x5d, 0xe6, 0x41, 0xaf, 0x90, 0xfd, 0x7f, 0xb2, 0xaf, 0xe6, 0x84,
-	0x67, 0xb5, 0xec, 0x59, 0x9a, 0x86, 0x2a, 0x99, 0x9a, 0x2b, 0x2b, 0x4e, 0x03, 0x72, 0x0c, 0x55,
-	0x37, 0x59, 0x99, 0x5e, 0xcb, 0x8c, 0xf4, 0xc1, 0x1e, 0xf9, 0x42, 0x65, 0xdc, 0x83, 0x22, 0x57,
-	0xc2, 0x1a, 0x50, 0xed, 0xf4, 0x46, 0x27, 0x3d, 0xbb, 0xdf, 0xd5, 0x36, 0x58, 0x13, 0x36, 0x73,
-	0xa1, 0xd0, 0x0a, 0x4b, 0x6a, 0xd8, 0xfe, 0xdc, 0x1f, 0xb4, 0xbb, 0x5a, 0x51, 0x52, 0xed, 0xb3,
-	0xb3, 0xc1, 0xb9, 0x24, 0xe5, 0x2b, 0xad, 0xc4, 0x34, 0x68, 0x58, 0xed, 0x33, 0xcb, 0xee, 0x27,
-	0x8c, 0xc2, 0x6a, 0xa0, 0xda, 0x9c, 0x0f, 0xb8, 0xa6, 0xca, 0x33, 0xac, 0xc1, 0xe9, 0xb0, 0x6f,
-	0x8f, 0x6c, 0xad, 0x7c, 0x59, 0xa6, 0x8f, 0xee, 0xab, 0x5f, 0x01, 0x00, 0x00, 0xff, 0xff, 0xfd,
-	0x11, 0xd8, 0xe6, 0x8b, 0x05, 0x00, 0x00,
+	// 737 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x55, 0x4d, 0x6f, 0xda, 0x58,
+	0x14, 0x0d, 0xd8, 0x06, 0x7c, 0x4d, 0x12, 0xf3, 0x82, 0x66, 0x3c, 0x99, 0x59, 0x30, 0x56, 0xa2,
+	0x41, 0xd1, 0x0c, 0x89, 0x3c, 0x9b, 0x99, 0xaa, 0x52, 0xc5, 0x87, 0x51, 0x91, 0x08, 0xd0, 0x17,
+	0xb2, 0x68, 0xbb, 0x88, 0x1c, 0x73, 0x49, 0xac, 0x10, 0xdb, 0xb5, 0x9d, 0x28, 0xec, 0xba, 0xee,
+	0xb2, 0xff, 0xaa, 0xff, 0xaa, 0x7a, 0xcf, 0x36, 0xd8, 0x81, 0x56, 0x5d, 0x74, 0x81, 0xe4, 0x73,
+	0x7c, 0xee, 0xf5, 0x7d, 0xf7, 0x9c, 0x27, 0xe0, 0xc0, 0x0f, 0xbc, 0xc8, 0x3b, 0xf5, 0x0d, 0x9f,
+	0xfd, 0x5a, 0x1c, 0x11, 0xc1, 0x37, 0x7c, 0xfd, 0xa3, 0x00, 0xfb, 0x1d, 0x27, 0x9a, 0x3b, 0xb8,
+	0x98, 0x9d, 0x63, 0x18, 0x5a, 0x37, 0x48, 0x0e, 0xa1, 0xe2, 0xb8, 0x73, 0xef, 0xb5, 0x15, 0xde,
+	0x6a, 0xc5, 0x46, 0xa1, 0x29, 0xd3, 0x15, 0x26, 0x04, 0x44, 0xd7, 0xba, 0x47, 0x4d, 0xe0, 0x3c,
+	0x7f, 0x26, 0xbf, 0x40, 0xc9, 0x47, 0x0c, 0x06, 0x3d, 0x4d, 0xe4, 0x6c, 0x82, 0xc8, 0x11, 0xec,
+	0x5e, 0x27, 0xad, 0x3b, 0xcb, 0x08, 0x43, 0x4d, 0x6a, 0x14, 0x9a, 0x55, 0x9a, 0x27, 0xc9, 0x1f,
+	0x20, 0xb3, 0x2e, 0xa1, 0x6f, 0xd9, 0xa8, 0x95, 0x78, 0x83, 0x35, 0x41, 0xae, 0xe0, 0x20, 0xc0,
+	0x7b, 0x2f, 0xc2, 0x4e, 0xae, 0x53, 0xb9, 0x21, 0x34, 0x15, 0xe3, 0x9f, 0x16, 0x3b, 0xcd, 0xb3,
+	0xf1, 0x5b, 0x74, 0x53, 0x6f, 0xba, 0x51, 0xb0, 0xa4, 0xdb, 0x3a, 0x11, 0x1d, 0xaa, 0xb6, 0xe5,
+	0x5b, 0xd7, 0xce, 0xc2, 0x89, 0x1c, 0x0c, 0xb5, 0x4a, 0x43, 0x68, 0xca, 0x34, 0xc7, 0x91, 0x3a,
+	0x48, 0x91, 0x77, 0x87, 0xae, 0x26, 0xf3, 0xf1, 0x62, 0x70, 0xd8, 0x07, 0xed, 0x5b, 0x9f, 0x22,
+	0x2a, 0x08, 0x77, 0xb8, 0xd4, 0x0a, 0x5c, 0xcf, 0x1e, 0x59, 0x8f, 0x47, 0x6b, 0xf1, 0x80, 0x7c,
+	0xa3, 0x55, 0x1a, 0x83, 0x17, 0xc5, 0xff, 0x0a, 0xfa, 0x7b, 0x38, 0x98, 0x38, 0x68, 0x23, 0xc5,
+	0x0f, 0x0f, 0x18, 0x46, 0xa9, 0x0b, 0x75, 0x90, 0x1c, 0x77, 0x86, 0x4f, 0xbc, 0x40, 0xa2, 0x31,
+	0x60, 0xbb, 0xf6, 0xe6, 0xf3, 0x10, 0x23, 0xee, 0x80, 0x44, 0x13, 0xc4, 0xf8, 0x05, 0xba, 0x37,
+	0xd1, 0x2d, 0xf7, 0x40, 0xa2, 0x09, 0xd2, 0x3f, 0x15, 0x93, 0xee, 0x13, 0x6b, 0xb9, 0xf0, 0xac,
+	0xd9, 0x4f, 0xed, 0xce, 0xf8, 0x99, 0x73, 0x83, 0x61, 0xc4, 0xad, 0x95, 0x69, 0x82, 0x48, 0x1f,
+	0x14, 0xdb, 0xbb, 0xf7, 0x03, 0x0c, 0x43, 0xc7, 0x73, 0xb9, 0xab, 0x7b, 0xc6, 0x11, 0x77, 0x6b,
+	0xcb, 0x30, 0xad, 0xee, 0x5a, 0x4b, 0xb3, 0x85, 0xe4, 0x04, 0xd4, 0x14, 0xe2, 0x6c, 0x18, 0x4f,
+	0x50, 0xe6, 0x13, 0x6c, 0xf0, 0xfa, 0x9f, 0xa0, 0x64, 0xfa, 0x90, 0x0a, 0x88, 0xa3, 0xf1, 0xc8,
+	0x54, 0x77, 0xd8, 0xd3, 0xbb, 0x8b, 0x69, 0x4f, 0x2d, 0xe8, 0x7f, 0x43, 0xbd, 0xed, 0xba, 0xde,
+	0x83, 0x6b, 0x23, 0x1f, 0xe3, 0xbb, 0xcb, 0xd0, 0x4f, 0x80, 0x74, 0x2d, 0xd7, 0xc6, 0xc5, 0x0f,
+	0x68, 0x3f, 0x17, 0xa0, 0x6a, 0x06, 0x81, 0x17, 0x64, 0x64, 0xc8, 0x70, 0x72, 0x81, 0x62, 0xb0,
+	0x2e, 0x16, 0xb2, 0x5b, 0x3f, 0x05, 0xd1, 0xf6, 0x66, 0xc8, 0x77, 0xbb, 0x67, 0xfc, 0xce, 0xd7,
+	0x94, 0x6d, 0x16, 0x83, 0xae, 0x37, 0x43, 0xca, 0x85, 0xfa, 0x31, 0xc8, 0x2b, 0x8a, 0x68, 0x50,
+	0x9f, 0x0c, 0xcc, 0xae, 0x79, 0x45, 0xcd, 0x37, 0x97, 0xe6, 0xc5, 0xf4, 0xaa, 0xdf, 0x1e, 0x0c,
+	0xcd, 0x9e, 0xba, 0xa3, 0xd7, 0x60, 0x9f, 0x6d, 0x64, 0x81, 0x51, 0x3a, 0xbd, 0xfe, 0x45, 0x84,
+	0x72, 0x3a, 0xa2, 0x06, 0xe5, 0x47, 0x0c, 0xb8, 0x41, 0x71, 0x4e, 0x53, 0x48, 0x8e, 0x41, 0x8c,
+	0x96, 0x7e, 0x1c, 0xd5, 0x3d, 0xa3, 0xc6, 0x07, 0x4a, 0x67, 0x99, 0x2e, 0x7d, 0xa4, 0xfc, 0x35,
+	0x39, 0x83, 0x4a, 0x7a, 0x95, 0xf9, 0x81, 0x14, 0xa3, 0xbe, 0xed, 0x42, 0xd2, 0x95, 0x8a, 0xbc,
+	0x84, 0xaa, 0x9f, 0x89, 0x3a, 0x3f, 0xb1, 0x62, 0x68, 0xeb, 0x60, 0xe4, 0xef, 0x00, 0xcd, 0xa9,
+	0x57, 0xd5, 0x49, 0x7a, 0x34, 0xe9, 0x79, 0x75, 0x3e, 0x56, 0x34, 0xa7, 0x26, 0xaf, 0x60, 0xd7,
+	0xca, 0x9a, 0xcf, 0x53, 0xa9, 0x18, 0xbf, 0xf1, 0xf2, 0x6d, 0xb1, 0xa0, 0x79, 0x3d, 0xf9, 0x1f,
+	0x14, 0x7b, 0x9d, 0x07, 0x9e, 0x43, 0xc5, 0xf8, 0x95, 0x97, 0x6f, 0xe6, 0x84, 0x66, 0xb5, 0xe4,
+	0xaf, 0x34, 0x0d, 0x15, 0x5e, 0x54, 0xdb, 0xb0, 0x38, 0x0d, 0xc8, 0x19, 0x54, 0xec, 0xc4, 0x32,
+	0x4d, 0xce, 0xac, 0xf4, 0x99, 0x8f, 0x74, 0xa5, 0xd2, 0x9f, 0x40, 0x64, 0x96, 0x90, 0x2a, 0x54,
+	0x3a, 0x83, 0x69, 0x7f, 0x60, 0x0e, 0x7b, 0xea, 0x0e, 0xa9, 0xc1, 0x6e, 0x2e, 0x14, 0x6a, 0x61,
+	0x4d, 0x4d, 0xda, 0x6f, 0x87, 0xe3, 0x76, 0x4f, 0x2d, 0x32, 0xaa, 0x3d, 0x1a, 0x8d, 0x2f, 0x19,
+	0xc9, 0x5e, 0xa9, 0x02, 0x51, 0xa1, 0xda, 0x6d, 0x8f, 0xba, 0xe6, 0x30, 0x61, 0x44, 0x22, 0x83,
+	0x64, 0x52, 0x3a, 0xa6, 0xaa, 0xc4, 0xbe, 0xd1, 0x1d, 0x9f, 0x4f, 0x86, 0xe6, 0xd4, 0x54, 0x4b,
+	0xd7, 0x25, 0xfe, 0x37, 0xf2, 0xef, 0xd7, 0x01, 0x00, 0xf4, 0xca, 0xa7, 0x8f, 0x5d, 0x06, 0x00,
+	0x00,
 }
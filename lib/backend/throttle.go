@@ -44,7 +44,7 @@ var _ sizer = (store.FileReader)(nil)
 func (c *ThrottledClient) Upload(namespace, name string, src io.Reader) error {
 	if s, ok := src.(sizer); ok {
 		// Only throttle if the src implements a Size method.
-		if err := c.bandwidth.ReserveEgress(s.Size()); err != nil {
+		if err := c.bandwidth.ReserveEgressFor(namespace, name, s.Size()); err != nil {
 			log.With("name", name).Errorf("Error reserving egress: %s", err)
 			// Ignore error.
 		}
@@ -58,7 +58,7 @@ func (c *ThrottledClient) Download(namespace, name string, dst io.Writer) error
 	if err != nil {
 		return err
 	}
-	if err := c.bandwidth.ReserveIngress(info.Size); err != nil {
+	if err := c.bandwidth.ReserveIngressFor(namespace, name, info.Size); err != nil {
 		log.With("name", name).Errorf("Error reserving ingress: %s", err)
 		// Ignore error.
 	}
@@ -114,7 +114,7 @@ func (r *Refresher) Refresh(namespace string, d core.Digest, hooks ...PostHook)
 			"download_time", t).Info("Downloaded remote blob")
 
 		// 生成任务元信息（下载完成后才会计算）
-		if err := r.metaInfoGenerator.Generate(d); err != nil {
+		if err := r.metaInfoGenerator.Generate(namespace, d); err != nil {
 			return fmt.Errorf("generate metainfo: %s", err)
 		}
 		r.stats.Counter("downloads").Inc(1)
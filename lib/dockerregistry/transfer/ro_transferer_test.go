@@ -15,6 +15,7 @@ package transfer
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"io/ioutil"
 	"os"
@@ -25,6 +26,7 @@ import (
 	"github.com/uber/kraken/build-index/tagclient"
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/torrent/scheduler"
 	"github.com/uber/kraken/mocks/build-index/tagclient"
 	"github.com/uber/kraken/mocks/lib/torrent/scheduler"
 	"github.com/uber/kraken/utils/testutil"
@@ -72,7 +74,8 @@ func TestReadOnlyTransfererDownloadCachesBlob(t *testing.T) {
 	blob := core.NewBlobFixture()
 
 	mocks.sched.EXPECT().Download(
-		namespace, blob.Digest).DoAndReturn(func(namespace string, d core.Digest) error {
+		gomock.Any(), namespace, blob.Digest).DoAndReturn(func(
+		ctx context.Context, namespace string, d core.Digest, opts ...scheduler.DownloadOption) error {
 
 		return store.RunDownload(mocks.cads, d, blob.Content)
 	})
@@ -99,7 +102,8 @@ func TestReadOnlyTransfererStat(t *testing.T) {
 	blob := core.NewBlobFixture()
 
 	mocks.sched.EXPECT().Download(
-		namespace, blob.Digest).DoAndReturn(func(namespace string, d core.Digest) error {
+		gomock.Any(), namespace, blob.Digest).DoAndReturn(func(
+		ctx context.Context, namespace string, d core.Digest, opts ...scheduler.DownloadOption) error {
 
 		return store.RunDownload(mocks.cads, d, blob.Content)
 	})
@@ -169,7 +173,8 @@ func TestReadOnlyTransfererMultipleDownloadsOfSameBlob(t *testing.T) {
 	commit := make(chan struct{})
 
 	mocks.sched.EXPECT().Download(
-		namespace, blob.Digest).DoAndReturn(func(namespace string, d core.Digest) error {
+		gomock.Any(), namespace, blob.Digest).DoAndReturn(func(
+		ctx context.Context, namespace string, d core.Digest, opts ...scheduler.DownloadOption) error {
 
 		<-commit
 
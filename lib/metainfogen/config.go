@@ -15,14 +15,115 @@ package metainfogen
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
 	"sort"
 
+	"github.com/uber/kraken/core"
+
 	"github.com/c2h5oh/datasize"
 )
 
+// Piece hashes of generated metainfo.
+const (
+	// HashCRC32 generates version 1 metainfo.
+	HashCRC32 = "crc32"
+
+	// HashSHA256 generates version 2 metainfo.
+	HashSHA256 = "sha256"
+
+	// HashSHA256Merkle generates version 2 metainfo which commits to the
+	// merkle root of its piece hashes.
+	HashSHA256Merkle = "sha256_merkle"
+)
+
 // Config defines Generator configuration.
 type Config struct {
 	PieceLengths map[datasize.ByteSize]datasize.ByteSize `yaml:"piece_lengths"`
+
+	// Hash is the piece hash of generated metainfo. Defaults to HashCRC32.
+	Hash string `yaml:"hash"`
+
+	// Rules override the configuration above for blobs of matching namespaces.
+	// Rules are matched in order, and blobs of namespaces which match no rule
+	// fall back to the configuration above.
+	Rules []RuleConfig `yaml:"rules"`
+}
+
+// RuleConfig defines metainfo generation for namespaces matching a regexp.
+type RuleConfig struct {
+	Namespace string `yaml:"namespace"`
+
+	// Defaults to Config.PieceLengths.
+	PieceLengths map[datasize.ByteSize]datasize.ByteSize `yaml:"piece_lengths"`
+
+	// Defaults to Config.Hash.
+	Hash string `yaml:"hash"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.Hash == "" {
+		c.Hash = HashCRC32
+	}
+	return c
+}
+
+// rule generates metainfo for blobs of matching namespaces.
+type rule struct {
+	regexp            *regexp.Regexp
+	pieceLengthConfig *pieceLengthConfig
+	hash              string
+	opts              []core.MetaInfoOption
+}
+
+func newRule(
+	namespace string,
+	pieceLengths map[datasize.ByteSize]datasize.ByteSize,
+	hash string) (*rule, error) {
+
+	re, err := regexp.Compile(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("regexp: %s", err)
+	}
+	plConfig, err := newPieceLengthConfig(pieceLengths)
+	if err != nil {
+		return nil, fmt.Errorf("piece length config: %s", err)
+	}
+	opts, err := metaInfoOptions(hash)
+	if err != nil {
+		return nil, err
+	}
+	return &rule{re, plConfig, hash, opts}, nil
+}
+
+// generates returns true if r generates mi for its blob, i.e. if regenerating
+// mi with r would keep its info hash. Does not read the blob.
+func (r *rule) generates(mi *core.MetaInfo) bool {
+	return r.pieceLengthConfig.get(mi.Length()) == mi.PieceLength() && r.hash == metaInfoHash(mi)
+}
+
+func metaInfoHash(mi *core.MetaInfo) string {
+	switch {
+	case mi.Version() == core.MetaInfoV1:
+		return HashCRC32
+	case mi.HasMerkleRoot():
+		return HashSHA256Merkle
+	default:
+		return HashSHA256
+	}
+}
+
+func metaInfoOptions(hash string) ([]core.MetaInfoOption, error) {
+	switch hash {
+	case HashCRC32:
+		return []core.MetaInfoOption{core.WithMetaInfoVersion(core.MetaInfoV1)}, nil
+	case HashSHA256:
+		return []core.MetaInfoOption{core.WithMetaInfoVersion(core.MetaInfoV2)}, nil
+	case HashSHA256Merkle:
+		return []core.MetaInfoOption{core.WithMerkleRoot()}, nil
+	default:
+		return nil, fmt.Errorf("unknown hash: %q", hash)
+	}
 }
 
 type rangeConfig struct {
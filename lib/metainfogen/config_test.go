@@ -36,3 +36,29 @@ func TestPieceLengthConfig(t *testing.T) {
 	require.Equal(int64(8*datasize.MB), plConfig.get(int64(4*datasize.GB)))
 	require.Equal(int64(8*datasize.MB), plConfig.get(int64(8*datasize.GB)))
 }
+
+func TestNewInvalidConfig(t *testing.T) {
+	pieceLengths := map[datasize.ByteSize]datasize.ByteSize{0: datasize.MB}
+
+	tests := []struct {
+		desc   string
+		config Config
+	}{
+		{"no piece lengths", Config{}},
+		{"unknown hash", Config{PieceLengths: pieceLengths, Hash: "md5"}},
+		{"invalid rule regexp", Config{
+			PieceLengths: pieceLengths,
+			Rules:        []RuleConfig{{Namespace: "("}},
+		}},
+		{"unknown rule hash", Config{
+			PieceLengths: pieceLengths,
+			Rules:        []RuleConfig{{Namespace: ".*", Hash: "md5"}},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := New(test.config, nil)
+			require.Error(t, err)
+		})
+	}
+}
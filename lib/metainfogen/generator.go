@@ -14,33 +14,104 @@
 package metainfogen
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/store/metadata"
+	"github.com/uber/kraken/utils/log"
 )
 
 // Generator wraps static piece length configuration in order to deterministically
 // generate metainfo.
 // 生成分片元数据信息
 type Generator struct {
-	pieceLengthConfig *pieceLengthConfig
-	cas               *store.CAStore
+	defaultRule *rule
+	rules       []*rule
+	cas         *store.CAStore
 }
 
 // New creates a new Generator.
 func New(config Config, cas *store.CAStore) (*Generator, error) {
-	plConfig, err := newPieceLengthConfig(config.PieceLengths)
+	config = config.applyDefaults()
+
+	defaultRule, err := newRule("", config.PieceLengths, config.Hash)
 	if err != nil {
-		return nil, fmt.Errorf("piece length config: %s", err)
+		return nil, err
+	}
+	var rules []*rule
+	for _, rc := range config.Rules {
+		pieceLengths := rc.PieceLengths
+		if len(pieceLengths) == 0 {
+			pieceLengths = config.PieceLengths
+		}
+		hash := rc.Hash
+		if hash == "" {
+			hash = config.Hash
+		}
+		r, err := newRule(rc.Namespace, pieceLengths, hash)
+		if err != nil {
+			return nil, fmt.Errorf("rule for namespace %s: %s", rc.Namespace, err)
+		}
+		rules = append(rules, r)
 	}
-	return &Generator{plConfig, cas}, nil
+	return &Generator{defaultRule, rules, cas}, nil
 }
 
-// Generate generates metainfo for the blob of d and writes it to disk.
+// getRule returns the first rule matching namespace. Blobs of unknown namespace,
+// i.e. an empty namespace, always use the default rule.
+func (g *Generator) getRule(namespace string) *rule {
+	if namespace == "" {
+		return g.defaultRule
+	}
+	for _, r := range g.rules {
+		if r.regexp.MatchString(namespace) {
+			return r
+		}
+	}
+	return g.defaultRule
+}
+
+// ErrNamespaceConflict is returned when regenerating the metainfo of a blob
+// whose current metainfo was generated by the rule of another namespace, and
+// the rules disagree.
+var ErrNamespaceConflict = errors.New("metainfo was generated for another namespace")
+
+// Generate generates metainfo for the blob of d, using the rule of namespace,
+// and writes it to disk. If the blob already has metainfo generated for another
+// namespace whose rule disagrees, e.g. since the same blob was pushed to two
+// namespaces, the existing metainfo is kept, such that the first namespace wins.
 // 生成 metainfo 并写到磁盘
-func (g *Generator) Generate(d core.Digest) error {
+func (g *Generator) Generate(namespace string, d core.Digest) error {
+	err := g.generate(namespace, d, false)
+	if err == ErrNamespaceConflict {
+		log.With("blob", d.Hex(), "namespace", namespace).Warn(
+			"Keeping metainfo generated for another namespace")
+		return nil
+	}
+	return err
+}
+
+// Regenerate regenerates the metainfo of the blob of d using the rule of
+// namespace. Unless force is set, returns ErrNamespaceConflict instead of
+// overwriting metainfo generated for another namespace whose rule disagrees.
+func (g *Generator) Regenerate(namespace string, d core.Digest, force bool) error {
+	return g.generate(namespace, d, force)
+}
+
+func (g *Generator) generate(namespace string, d core.Digest, force bool) error {
+	r := g.getRule(namespace)
+	if !force {
+		// Metainfo without a namespace, e.g. generated before namespaces were
+		// recorded, has an unknown owner and is only overwritten if r agrees.
+		var cur metadata.TorrentMeta
+		if g.cas.GetCacheFileMetadata(d.Hex(), &cur) == nil &&
+			cur.Namespace != namespace &&
+			!r.generates(cur.MetaInfo) {
+			return ErrNamespaceConflict
+		}
+	}
 	// 根据 digest 获取文件
 	info, err := g.cas.GetCacheFileStat(d.Hex())
 	if err != nil {
@@ -51,12 +122,13 @@ func (g *Generator) Generate(d core.Digest) error {
 		return fmt.Errorf("get cache file: %s", err)
 	}
 	// 根据文件大小计算分片信息
-	pieceLength := g.pieceLengthConfig.get(info.Size())
-	mi, err := core.NewMetaInfo(d, f, pieceLength)
+	pieceLength := r.pieceLengthConfig.get(info.Size())
+	mi, err := core.NewMetaInfo(d, f, pieceLength, r.opts...)
 	if err != nil {
 		return fmt.Errorf("create metainfo: %s", err)
 	}
-	if _, err := g.cas.SetCacheFileMetadata(d.Hex(), metadata.NewTorrentMeta(mi)); err != nil {
+	tm := &metadata.TorrentMeta{MetaInfo: mi, Namespace: namespace}
+	if _, err := g.cas.SetCacheFileMetadata(d.Hex(), tm); err != nil {
 		return fmt.Errorf("set metainfo: %s", err)
 	}
 	return nil
@@ -44,9 +44,124 @@ func TestGenerate(t *testing.T) {
 
 	require.NoError(cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
 
-	require.NoError(generator.Generate(blob.Digest))
+	require.NoError(generator.Generate(core.TagFixture(), blob.Digest))
 
 	var tm metadata.TorrentMeta
 	require.NoError(cas.GetCacheFileMetadata(blob.Digest.Hex(), &tm))
 	require.Equal(blob.MetaInfo, tm.MetaInfo)
 }
+
+func TestGenerateNamespaceRules(t *testing.T) {
+	cas, cleanup := store.CAStoreFixture()
+	defer cleanup()
+
+	generator, err := New(Config{
+		PieceLengths: map[datasize.ByteSize]datasize.ByteSize{0: 10},
+		Rules: []RuleConfig{{
+			Namespace:    "models/.*",
+			PieceLengths: map[datasize.ByteSize]datasize.ByteSize{0: 20, 50: 40},
+			Hash:         HashSHA256Merkle,
+		}, {
+			Namespace: "configs/.*",
+			Hash:      HashSHA256,
+		}, {
+			Namespace:    ".*",
+			PieceLengths: map[datasize.ByteSize]datasize.ByteSize{0: 5},
+		}},
+	}, cas)
+	require.NoError(t, err)
+
+	tests := []struct {
+		namespace           string
+		expectedPieceLength int64
+		expectedVersion     int
+	}{
+		{"models/resnet", 40, core.MetaInfoV2},
+		{"configs/app", 10, core.MetaInfoV2},
+		{"layers/app", 5, core.MetaInfoV1},
+		{"", 10, core.MetaInfoV1},
+	}
+	for _, test := range tests {
+		t.Run(test.namespace, func(t *testing.T) {
+			require := require.New(t)
+
+			blob := core.SizedBlobFixture(100, 10)
+			require.NoError(cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+
+			require.NoError(generator.Generate(test.namespace, blob.Digest))
+
+			var tm metadata.TorrentMeta
+			require.NoError(cas.GetCacheFileMetadata(blob.Digest.Hex(), &tm))
+			require.Equal(test.expectedPieceLength, tm.MetaInfo.PieceLength())
+			require.Equal(test.expectedVersion, tm.MetaInfo.Version())
+		})
+	}
+}
+
+func TestGenerateNamespaceConflict(t *testing.T) {
+	require := require.New(t)
+
+	cas, cleanup := store.CAStoreFixture()
+	defer cleanup()
+
+	generator, err := New(Config{
+		PieceLengths: map[datasize.ByteSize]datasize.ByteSize{0: 10},
+		Rules: []RuleConfig{{
+			Namespace:    "models/.*",
+			PieceLengths: map[datasize.ByteSize]datasize.ByteSize{0: 20},
+		}, {
+			Namespace:    "checkpoints/.*",
+			PieceLengths: map[datasize.ByteSize]datasize.ByteSize{0: 20},
+		}},
+	}, cas)
+	require.NoError(err)
+
+	blob := core.SizedBlobFixture(100, 10)
+	require.NoError(cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+
+	pieceLength := func() int64 {
+		var tm metadata.TorrentMeta
+		require.NoError(cas.GetCacheFileMetadata(blob.Digest.Hex(), &tm))
+		return tm.MetaInfo.PieceLength()
+	}
+
+	require.NoError(generator.Generate("models/resnet", blob.Digest))
+	require.Equal(int64(20), pieceLength())
+
+	// The blob keeps the metainfo of the first namespace.
+	require.NoError(generator.Generate("layers/app", blob.Digest))
+	require.Equal(int64(20), pieceLength())
+
+	require.Equal(ErrNamespaceConflict, generator.Regenerate("layers/app", blob.Digest, false))
+	require.Equal(int64(20), pieceLength())
+
+	// Namespaces whose rules agree do not conflict.
+	require.NoError(generator.Regenerate("checkpoints/resnet", blob.Digest, false))
+
+	require.NoError(generator.Regenerate("layers/app", blob.Digest, true))
+	require.Equal(int64(10), pieceLength())
+}
+
+func TestGenerateKeepsMetaInfoOfUnknownNamespace(t *testing.T) {
+	require := require.New(t)
+
+	cas, cleanup := store.CAStoreFixture()
+	defer cleanup()
+
+	generator, err := New(Config{
+		PieceLengths: map[datasize.ByteSize]datasize.ByteSize{0: 10},
+	}, cas)
+	require.NoError(err)
+
+	blob := core.SizedBlobFixture(100, 20)
+	require.NoError(cas.CreateCacheFile(blob.Digest.Hex(), bytes.NewReader(blob.Content)))
+	_, err = cas.SetCacheFileMetadata(blob.Digest.Hex(), metadata.NewTorrentMeta(blob.MetaInfo))
+	require.NoError(err)
+
+	require.Equal(ErrNamespaceConflict, generator.Regenerate("layers/app", blob.Digest, false))
+
+	var tm metadata.TorrentMeta
+	require.NoError(cas.GetCacheFileMetadata(blob.Digest.Hex(), &tm))
+	require.Equal(blob.MetaInfo.InfoHash(), tm.MetaInfo.InfoHash())
+	require.Empty(tm.Namespace)
+}
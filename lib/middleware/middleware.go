@@ -89,6 +89,13 @@ func (w *recordStatusWriter) Write(b []byte) (int, error) {
 	return w.ResponseWriter.Write(b)
 }
 
+// Flush implements http.Flusher, such that streaming endpoints may be counted.
+func (w *recordStatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // StatusCounter measures endpoint status count.
 func StatusCounter(stats tally.Scope) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
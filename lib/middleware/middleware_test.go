@@ -155,3 +155,22 @@ func TestStatusCounter(t *testing.T) {
 		})
 	}
 }
+
+func TestStatusCounterPreservesFlusher(t *testing.T) {
+	require := require.New(t)
+
+	stats := tally.NewTestScope("", nil)
+
+	var flushable bool
+	r := chi.NewRouter()
+	r.Use(StatusCounter(stats))
+	r.Get("/stream", func(w http.ResponseWriter, r *http.Request) {
+		_, flushable = w.(http.Flusher)
+	})
+	addr, stop := testutil.StartServer(r)
+	defer stop()
+
+	_, err := httputil.Get(fmt.Sprintf("http://%s/stream", addr))
+	require.NoError(err)
+	require.True(flushable)
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peertoken
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Config defines how peer tokens are issued and verified. Tokens are signed by
+// central hosts, i.e. trackers and origins, which hold the private SigningKey.
+// Agents obtain their tokens from trackers, which bind them to the address the
+// request was received from. All hosts verify tokens with PublicKeys only, such
+// that no agent can mint a token for any other peer.
+//
+// Keys are rotated in three steps: first, the new public key is appended to
+// PublicKeys everywhere, such that all hosts accept it. Second, trackers and
+// origins switch their SigningKey to the new key. Finally, once all tokens
+// signed with the old key have expired, the old public key is removed.
+type Config struct {
+	Enable bool `yaml:"enable"`
+
+	// Enforce rejects announces and handshakes without a valid token. If false,
+	// invalid tokens are only logged and counted, which allows rolling out
+	// tokens to a fleet before enforcing them.
+	Enforce bool `yaml:"enforce"`
+
+	// Cluster is the cluster tokens are bound to. Tokens issued for other
+	// clusters are rejected, even if they are signed with a shared key.
+	Cluster string `yaml:"cluster"`
+
+	// PublicKeys are the Ed25519 public keys tokens are verified with.
+	PublicKeys []Key `yaml:"public_keys"`
+
+	// SigningKey is the Ed25519 private key tokens are signed with. Only set on
+	// trackers and origins.
+	SigningKey Key `yaml:"signing_key"`
+
+	// TTL is the duration issued tokens are valid for. Tokens are renewed once
+	// half of their TTL has elapsed.
+	TTL time.Duration `yaml:"ttl"`
+
+	// ClockSkew is the duration tokens are still accepted after they expire, to
+	// tolerate clock drift between hosts.
+	ClockSkew time.Duration `yaml:"clock_skew"`
+}
+
+// Key is a named Ed25519 key, stored in a PEM file: PKIX for public keys and
+// PKCS #8 for private keys, as generated by "openssl genpkey -algorithm ed25519".
+type Key struct {
+	ID   string `yaml:"id"`
+	Path string `yaml:"path"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.TTL == 0 {
+		c.TTL = 10 * time.Minute
+	}
+	if c.ClockSkew == 0 {
+		c.ClockSkew = 30 * time.Second
+	}
+	return c
+}
+
+func validateKey(k Key) error {
+	if k.ID == "" || strings.Contains(k.ID, ".") {
+		return fmt.Errorf("invalid key id %q", k.ID)
+	}
+	if k.Path == "" {
+		return fmt.Errorf("key %s: empty path", k.ID)
+	}
+	return nil
+}
+
+func (c Config) validate() error {
+	if !c.Enable {
+		return nil
+	}
+	if len(c.PublicKeys) == 0 {
+		return errors.New("no public keys configured")
+	}
+	ids := make(map[string]bool)
+	for _, k := range c.PublicKeys {
+		if err := validateKey(k); err != nil {
+			return err
+		}
+		if ids[k.ID] {
+			return fmt.Errorf("duplicate key id %s", k.ID)
+		}
+		ids[k.ID] = true
+	}
+	return nil
+}
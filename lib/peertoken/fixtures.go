@@ -0,0 +1,87 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peertoken
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+)
+
+// KeyPairFixture writes a random Ed25519 key pair with id to temporary files,
+// returning the public and private keys.
+func KeyPairFixture(id string) (public Key, private Key) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	dir, err := ioutil.TempDir("", "peertoken")
+	if err != nil {
+		panic(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		panic(err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		panic(err)
+	}
+	public = Key{ID: id, Path: filepath.Join(dir, "public.pem")}
+	writePEMFixture(public.Path, "PUBLIC KEY", pubDER)
+	private = Key{ID: id, Path: filepath.Join(dir, "private.pem")}
+	writePEMFixture(private.Path, "PRIVATE KEY", privDER)
+	return public, private
+}
+
+func writePEMFixture(path, typ string, der []byte) {
+	b := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		panic(err)
+	}
+}
+
+// ConfigFixture returns an enforced Config with a single random key pair for
+// testing.
+func ConfigFixture() Config {
+	public, private := KeyPairFixture("test-key")
+	return Config{
+		Enable:     true,
+		Enforce:    true,
+		Cluster:    "test-cluster",
+		PublicKeys: []Key{public},
+		SigningKey: private,
+	}
+}
+
+// IssuerFixture returns an Issuer which signs its own tokens for the peer of
+// pctx, bound to ip, with the signing key of config.
+func IssuerFixture(config Config, pctx core.PeerContext, ip string) *Issuer {
+	s, err := NewSigner(config, clock.New())
+	if err != nil {
+		panic(err)
+	}
+	i, err := NewIssuer(config, pctx, clock.New(), s.Source(ip))
+	if err != nil {
+		panic(err)
+	}
+	return i
+}
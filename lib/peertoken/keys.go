@@ -0,0 +1,67 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peertoken
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+func readPEM(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no pem block found")
+	}
+	return block.Bytes, nil
+}
+
+func loadPublicKey(k Key) (ed25519.PublicKey, error) {
+	der, err := readPEM(k.Path)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %s", k.ID, err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: parse public key: %s", k.ID, err)
+	}
+	edPub, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key %s: not an ed25519 public key", k.ID)
+	}
+	return edPub, nil
+}
+
+func loadPrivateKey(k Key) (ed25519.PrivateKey, error) {
+	der, err := readPEM(k.Path)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %s", k.ID, err)
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: parse private key: %s", k.ID, err)
+	}
+	edPriv, ok := priv.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key %s: not an ed25519 private key", k.ID)
+	}
+	return edPriv, nil
+}
@@ -0,0 +1,299 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peertoken
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+)
+
+// Token errors.
+var (
+	ErrMissing      = errors.New("missing token")
+	ErrMalformed    = errors.New("malformed token")
+	ErrUnknownKey   = errors.New("token signed with unknown key")
+	ErrBadSignature = errors.New("invalid token signature")
+	ErrExpired      = errors.New("token expired")
+)
+
+// Claims are the claims a token is bound to.
+type Claims struct {
+	PeerID    core.PeerID `json:"peer_id"`
+	IP        string      `json:"ip"`
+	Cluster   string      `json:"cluster"`
+	ExpiresAt int64       `json:"exp"` // Unix seconds.
+}
+
+var encoding = base64.RawURLEncoding
+
+// sign returns a token of the form "<key id>.<payload>.<signature>".
+func sign(id string, key ed25519.PrivateKey, c Claims) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("marshal claims: %s", err)
+	}
+	signed := id + "." + encoding.EncodeToString(b)
+	return signed + "." + encoding.EncodeToString(ed25519.Sign(key, []byte(signed))), nil
+}
+
+// parse splits token into its key id, signed portion, claims and signature,
+// without verifying the signature.
+func parse(token string) (id, signed string, c *Claims, sig []byte, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", "", nil, nil, ErrMalformed
+	}
+	sig, err = encoding.DecodeString(parts[2])
+	if err != nil {
+		return "", "", nil, nil, ErrMalformed
+	}
+	b, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", nil, nil, ErrMalformed
+	}
+	c = new(Claims)
+	if err := json.Unmarshal(b, c); err != nil {
+		return "", "", nil, nil, ErrMalformed
+	}
+	return parts[0], parts[0] + "." + parts[1], c, sig, nil
+}
+
+// Signer signs tokens on behalf of peers. Only central hosts, which hold the
+// private signing key, create Signers.
+type Signer struct {
+	config Config
+	clk    clock.Clock
+	key    ed25519.PrivateKey
+}
+
+// NewSigner creates a new Signer from the SigningKey of config.
+func NewSigner(config Config, clk clock.Clock) (*Signer, error) {
+	config = config.applyDefaults()
+	if err := validateKey(config.SigningKey); err != nil {
+		return nil, fmt.Errorf("signing key: %s", err)
+	}
+	key, err := loadPrivateKey(config.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{config, clk, key}, nil
+}
+
+// Sign returns a token bound to peerID and ip. Callers are responsible for
+// verifying that the requesting peer holds ip.
+func (s *Signer) Sign(peerID core.PeerID, ip string) (string, error) {
+	return sign(s.config.SigningKey.ID, s.key, Claims{
+		PeerID:    peerID,
+		IP:        ip,
+		Cluster:   s.config.Cluster,
+		ExpiresAt: s.clk.Now().Add(s.config.TTL).Unix(),
+	})
+}
+
+// Source fetches tokens for the local peer.
+type Source interface {
+	FetchToken(peerID core.PeerID) (string, error)
+}
+
+type signerSource struct {
+	signer *Signer
+	ip     string
+}
+
+// Source returns a Source which signs tokens for the local peer at ip, for
+// central hosts which issue their own tokens.
+func (s *Signer) Source(ip string) Source {
+	return &signerSource{s, ip}
+}
+
+func (s *signerSource) FetchToken(peerID core.PeerID) (string, error) {
+	return s.signer.Sign(peerID, s.ip)
+}
+
+// Issuer provides tokens for the local peer, fetched from a Source.
+type Issuer struct {
+	enable bool
+	clk    clock.Clock
+	peerID core.PeerID
+	source Source
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	renewAt   time.Time
+}
+
+// NewIssuer creates a new Issuer which fetches tokens for pctx from source.
+func NewIssuer(config Config, pctx core.PeerContext, clk clock.Clock, source Source) (*Issuer, error) {
+	config = config.applyDefaults()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if config.Enable && source == nil {
+		return nil, errors.New("no token source")
+	}
+	return &Issuer{
+		enable: config.Enable,
+		clk:    clk,
+		peerID: pctx.PeerID,
+		source: source,
+	}, nil
+}
+
+// DisabledIssuer returns an Issuer which issues empty tokens.
+func DisabledIssuer() *Issuer {
+	return &Issuer{}
+}
+
+// Token returns a token for the local peer, fetching a new one once half of the
+// lifetime of the current token has elapsed. The current token is still used
+// while fetches fail, until it expires. Returns an empty token if tokens are
+// disabled.
+func (i *Issuer) Token() (string, error) {
+	if !i.enable {
+		return "", nil
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := i.clk.Now()
+	if i.token != "" && now.Before(i.renewAt) {
+		return i.token, nil
+	}
+	var c *Claims
+	token, err := i.source.FetchToken(i.peerID)
+	if err == nil {
+		_, _, c, _, err = parse(token)
+	}
+	if err != nil {
+		if i.token != "" && now.Before(i.expiresAt) {
+			log.Warnf("Error renewing peer token, using current token: %s", err)
+			return i.token, nil
+		}
+		return "", fmt.Errorf("fetch token: %s", err)
+	}
+	i.token = token
+	i.expiresAt = time.Unix(c.ExpiresAt, 0)
+	i.renewAt = now.Add(i.expiresAt.Sub(now) / 2)
+	return token, nil
+}
+
+// Verifier verifies tokens of remote peers.
+type Verifier struct {
+	config Config
+	clk    clock.Clock
+	stats  tally.Scope
+	keys   map[string]ed25519.PublicKey
+}
+
+// NewVerifier creates a new Verifier.
+func NewVerifier(config Config, clk clock.Clock, stats tally.Scope) (*Verifier, error) {
+	config = config.applyDefaults()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]ed25519.PublicKey)
+	if config.Enable {
+		for _, k := range config.PublicKeys {
+			pub, err := loadPublicKey(k)
+			if err != nil {
+				return nil, err
+			}
+			keys[k.ID] = pub
+		}
+	}
+	stats = stats.Tagged(map[string]string{
+		"module": "peertoken",
+	})
+	return &Verifier{config, clk, stats, keys}, nil
+}
+
+// DisabledVerifier returns a Verifier which accepts all peers.
+func DisabledVerifier() *Verifier {
+	return &Verifier{stats: tally.NoopScope}
+}
+
+// Verify parses token and returns its claims, if token is signed by a known key
+// and has not expired.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	if token == "" {
+		return nil, ErrMissing
+	}
+	id, signed, c, sig, err := parse(token)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := v.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if !ed25519.Verify(k, []byte(signed), sig) {
+		return nil, ErrBadSignature
+	}
+	if v.clk.Now().After(time.Unix(c.ExpiresAt, 0).Add(v.config.ClockSkew)) {
+		return nil, ErrExpired
+	}
+	return c, nil
+}
+
+// Check verifies that token was issued to the peer identified by peerID and ip
+// within the local cluster. ip must be the address the token was presented
+// from, such that tokens cannot be replayed by other hosts. If tokens are not
+// enforced, invalid tokens are logged and accepted.
+func (v *Verifier) Check(token string, peerID core.PeerID, ip string) error {
+	if !v.config.Enable {
+		return nil
+	}
+	err := v.check(token, peerID, ip)
+	if err == nil {
+		v.stats.Counter("valid_tokens").Inc(1)
+		return nil
+	}
+	v.stats.Counter("invalid_tokens").Inc(1)
+	if !v.config.Enforce {
+		log.With("peer", peerID, "ip", ip).Warnf("Accepting invalid peer token: %s", err)
+		return nil
+	}
+	return err
+}
+
+func (v *Verifier) check(token string, peerID core.PeerID, ip string) error {
+	c, err := v.Verify(token)
+	if err != nil {
+		return err
+	}
+	if c.Cluster != v.config.Cluster {
+		return fmt.Errorf("token issued for cluster %q", c.Cluster)
+	}
+	if c.PeerID != peerID {
+		return fmt.Errorf("token issued to peer %s", c.PeerID)
+	}
+	if c.IP != ip {
+		return fmt.Errorf("token issued to ip %s, presented from %s", c.IP, ip)
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peertoken
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func newTestSigner(t *testing.T, config Config, clk clock.Clock) *Signer {
+	s, err := NewSigner(config, clk)
+	require.NoError(t, err)
+	return s
+}
+
+func newTestIssuer(t *testing.T, config Config, clk clock.Clock) (*Issuer, core.PeerContext) {
+	pctx := core.PeerContextFixture()
+	i, err := NewIssuer(config, pctx, clk, newTestSigner(t, config, clk).Source(pctx.IP))
+	require.NoError(t, err)
+	return i, pctx
+}
+
+func newTestVerifier(t *testing.T, config Config, clk clock.Clock) *Verifier {
+	v, err := NewVerifier(config, clk, tally.NoopScope)
+	require.NoError(t, err)
+	return v
+}
+
+func TestIssuedTokenVerifies(t *testing.T) {
+	require := require.New(t)
+
+	config := ConfigFixture()
+	clk := clock.NewMock()
+	i, pctx := newTestIssuer(t, config, clk)
+	v := newTestVerifier(t, config, clk)
+
+	token, err := i.Token()
+	require.NoError(err)
+
+	require.NoError(v.Check(token, pctx.PeerID, pctx.IP))
+
+	c, err := v.Verify(token)
+	require.NoError(err)
+	require.Equal(pctx.PeerID, c.PeerID)
+	require.Equal(pctx.IP, c.IP)
+	require.Equal(config.Cluster, c.Cluster)
+}
+
+func TestCheckRejectsMismatchedClaims(t *testing.T) {
+	config := ConfigFixture()
+	clk := clock.NewMock()
+	i, pctx := newTestIssuer(t, config, clk)
+
+	token, err := i.Token()
+	require.NoError(t, err)
+
+	otherCluster := config
+	otherCluster.Cluster = "other-cluster"
+
+	tests := []struct {
+		desc   string
+		config Config
+		peerID core.PeerID
+		ip     string
+	}{
+		{"peer id", config, core.PeerIDFixture(), pctx.IP},
+		{"replayed from other ip", config, pctx.PeerID, "10.0.0.1"},
+		{"unknown ip", config, pctx.PeerID, ""},
+		{"cluster", otherCluster, pctx.PeerID, pctx.IP},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			v := newTestVerifier(t, test.config, clk)
+			require.Error(t, v.Check(token, test.peerID, test.ip))
+		})
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	config := ConfigFixture()
+	clk := clock.NewMock()
+	i, _ := newTestIssuer(t, config, clk)
+	v := newTestVerifier(t, config, clk)
+
+	token, err := i.Token()
+	require.NoError(t, err)
+
+	// Signed with a key pair whose public key the verifier does not hold, but
+	// under a known key id.
+	_, otherPrivate := KeyPairFixture(config.SigningKey.ID)
+	otherConfig := config
+	otherConfig.SigningKey = otherPrivate
+	forged, err := newTestSigner(t, otherConfig, clk).Sign(core.PeerIDFixture(), "")
+	require.NoError(t, err)
+
+	_, unknownPrivate := KeyPairFixture("unknown")
+	unknownConfig := config
+	unknownConfig.SigningKey = unknownPrivate
+	unknown, err := newTestSigner(t, unknownConfig, clk).Sign(core.PeerIDFixture(), "")
+	require.NoError(t, err)
+
+	// Claims of another token, with the original signature.
+	parts := strings.Split(token, ".")
+	parts[1] = strings.Split(forged, ".")[1]
+	tampered := strings.Join(parts, ".")
+
+	tests := []struct {
+		desc  string
+		token string
+		err   error
+	}{
+		{"missing", "", ErrMissing},
+		{"malformed", "foo", ErrMalformed},
+		{"bad signature", forged, ErrBadSignature},
+		{"unknown key", unknown, ErrUnknownKey},
+		{"tampered", tampered, ErrBadSignature},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := v.Verify(test.token)
+			require.Equal(t, test.err, err)
+		})
+	}
+}
+
+func TestVerifierCannotSign(t *testing.T) {
+	config := ConfigFixture()
+	config.SigningKey = Key{}
+
+	// Hosts with only public keys verify tokens, but cannot sign them.
+	_, err := NewVerifier(config, clock.NewMock(), tally.NoopScope)
+	require.NoError(t, err)
+	_, err = NewSigner(config, clock.NewMock())
+	require.Error(t, err)
+}
+
+func TestVerifyExpiredToken(t *testing.T) {
+	require := require.New(t)
+
+	config := ConfigFixture()
+	config.TTL = time.Minute
+	config.ClockSkew = 10 * time.Second
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+	i, _ := newTestIssuer(t, config, clk)
+	v := newTestVerifier(t, config, clk)
+
+	token, err := i.Token()
+	require.NoError(err)
+
+	clk.Add(time.Minute + 5*time.Second)
+	_, err = v.Verify(token)
+	require.NoError(err)
+
+	clk.Add(10 * time.Second)
+	_, err = v.Verify(token)
+	require.Equal(ErrExpired, err)
+}
+
+func TestIssuerRenewsTokenAfterHalfTTL(t *testing.T) {
+	require := require.New(t)
+
+	config := ConfigFixture()
+	config.TTL = time.Minute
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+	i, _ := newTestIssuer(t, config, clk)
+
+	t1, err := i.Token()
+	require.NoError(err)
+
+	clk.Add(20 * time.Second)
+	t2, err := i.Token()
+	require.NoError(err)
+	require.Equal(t1, t2)
+
+	clk.Add(20 * time.Second)
+	t3, err := i.Token()
+	require.NoError(err)
+	require.NotEqual(t1, t3)
+}
+
+type flakySource struct {
+	source Source
+	err    error
+}
+
+func (s *flakySource) FetchToken(peerID core.PeerID) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	return s.source.FetchToken(peerID)
+}
+
+func TestIssuerKeepsTokenUntilExpiryWhenRenewalFails(t *testing.T) {
+	require := require.New(t)
+
+	config := ConfigFixture()
+	config.TTL = time.Minute
+	clk := clock.NewMock()
+	clk.Set(time.Now())
+	pctx := core.PeerContextFixture()
+	source := &flakySource{source: newTestSigner(t, config, clk).Source(pctx.IP)}
+	i, err := NewIssuer(config, pctx, clk, source)
+	require.NoError(err)
+
+	t1, err := i.Token()
+	require.NoError(err)
+
+	source.err = errors.New("some error")
+	clk.Add(40 * time.Second)
+	t2, err := i.Token()
+	require.NoError(err)
+	require.Equal(t1, t2)
+
+	clk.Add(30 * time.Second)
+	_, err = i.Token()
+	require.Error(err)
+}
+
+func TestKeyRotation(t *testing.T) {
+	require := require.New(t)
+
+	oldPublic, oldPrivate := KeyPairFixture("old")
+	newPublic, newPrivate := KeyPairFixture("new")
+
+	config := ConfigFixture()
+	clk := clock.NewMock()
+	pctx := core.PeerContextFixture()
+
+	// Issuers still signing with the old key.
+	config.SigningKey = oldPrivate
+	oldToken, err := newTestSigner(t, config, clk).Sign(pctx.PeerID, pctx.IP)
+	require.NoError(err)
+
+	// Issuers which sign with the new key, while all hosts accept both.
+	config.SigningKey = newPrivate
+	newToken, err := newTestSigner(t, config, clk).Sign(pctx.PeerID, pctx.IP)
+	require.NoError(err)
+
+	config.PublicKeys = []Key{oldPublic, newPublic}
+	v := newTestVerifier(t, config, clk)
+	require.NoError(v.Check(oldToken, pctx.PeerID, pctx.IP))
+	require.NoError(v.Check(newToken, pctx.PeerID, pctx.IP))
+
+	// Old key removed.
+	config.PublicKeys = []Key{newPublic}
+	v = newTestVerifier(t, config, clk)
+	_, err = v.Verify(oldToken)
+	require.Equal(ErrUnknownKey, err)
+	_, err = v.Verify(newToken)
+	require.NoError(err)
+}
+
+func TestCheckAcceptsInvalidTokensWhenNotEnforced(t *testing.T) {
+	require := require.New(t)
+
+	config := ConfigFixture()
+	config.Enforce = false
+	v := newTestVerifier(t, config, clock.NewMock())
+
+	require.NoError(v.Check("", core.PeerIDFixture(), ""))
+	require.NoError(v.Check("foo", core.PeerIDFixture(), ""))
+}
+
+func TestDisabled(t *testing.T) {
+	require := require.New(t)
+
+	token, err := DisabledIssuer().Token()
+	require.NoError(err)
+	require.Empty(token)
+
+	require.NoError(DisabledVerifier().Check("", core.PeerIDFixture(), ""))
+}
+
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		desc string
+		keys []Key
+	}{
+		{"no keys", nil},
+		{"empty id", []Key{{Path: "p"}}},
+		{"dotted id", []Key{{ID: "a.b", Path: "p"}}},
+		{"empty path", []Key{{ID: "a"}}},
+		{"duplicate id", []Key{{ID: "a", Path: "p"}, {ID: "a", Path: "q"}}},
+		{"missing file", []Key{{ID: "a", Path: "/does/not/exist"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := ConfigFixture()
+			config.PublicKeys = test.keys
+			_, err := NewVerifier(config, clock.NewMock(), tally.NoopScope)
+			require.Error(t, err)
+		})
+	}
+}
@@ -82,6 +82,13 @@ func (readWriter localFileReadWriter) Seek(offset int64, whence int) (int64, err
 	return readWriter.descriptor.Seek(offset, whence)
 }
 
+// File returns the underlying os.File, which allows callers to transfer file
+// contents without copying them through user space. The returned file shares
+// its offset with readWriter and is closed by Close.
+func (readWriter localFileReadWriter) File() *os.File {
+	return readWriter.descriptor
+}
+
 // Size returns the size of the file.
 func (readWriter localFileReadWriter) Size() int64 {
 	// Use file entry instead of descriptor, because descriptor could have been closed.
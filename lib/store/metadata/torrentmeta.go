@@ -14,6 +14,7 @@
 package metadata
 
 import (
+	"encoding/json"
 	"regexp"
 
 	"github.com/uber/kraken/core"
@@ -34,11 +35,15 @@ func (f torrentMetaFactory) Create(suffix string) Metadata {
 // TorrentMeta wraps torrent metainfo storage as metadata.
 type TorrentMeta struct {
 	MetaInfo *core.MetaInfo
+
+	// Namespace is the namespace whose generation rule produced MetaInfo.
+	// Empty if unknown, e.g. for metainfo downloaded from origins.
+	Namespace string
 }
 
 // NewTorrentMeta return a new TorrentMeta.
 func NewTorrentMeta(mi *core.MetaInfo) *TorrentMeta {
-	return &TorrentMeta{mi}
+	return &TorrentMeta{MetaInfo: mi}
 }
 
 // GetSuffix returns a static suffix.
@@ -51,9 +56,21 @@ func (m *TorrentMeta) Movable() bool {
 	return true
 }
 
-// Serialize converts m to bytes.
+// Serialize converts m to bytes. The namespace, if known, is stored as an extra
+// field of the serialized metainfo, which metainfo deserialization ignores.
 func (m *TorrentMeta) Serialize() ([]byte, error) {
-	return m.MetaInfo.Serialize()
+	b, err := m.MetaInfo.Serialize()
+	if err != nil || m.Namespace == "" {
+		return b, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	if fields["Namespace"], err = json.Marshal(m.Namespace); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
 }
 
 // Deserialize loads b into m.
@@ -62,6 +79,13 @@ func (m *TorrentMeta) Deserialize(b []byte) error {
 	if err != nil {
 		return err
 	}
+	var ns struct {
+		Namespace string
+	}
+	if err := json.Unmarshal(b, &ns); err != nil {
+		return err
+	}
 	m.MetaInfo = mi
+	m.Namespace = ns.Namespace
 	return nil
 }
@@ -31,4 +31,24 @@ func TestTorrentMetaSerialization(t *testing.T) {
 	var result TorrentMeta
 	require.NoError(result.Deserialize(b))
 	require.Equal(tm.MetaInfo, result.MetaInfo)
+	require.Empty(result.Namespace)
+}
+
+func TestTorrentMetaSerializationWithNamespace(t *testing.T) {
+	require := require.New(t)
+
+	mi := core.MetaInfoFixture()
+	tm := &TorrentMeta{MetaInfo: mi, Namespace: "models/resnet"}
+	b, err := tm.Serialize()
+	require.NoError(err)
+
+	var result TorrentMeta
+	require.NoError(result.Deserialize(b))
+	require.Equal(tm.MetaInfo, result.MetaInfo)
+	require.Equal("models/resnet", result.Namespace)
+
+	// The namespace does not change the metainfo itself.
+	parsed, err := core.DeserializeMetaInfo(b)
+	require.NoError(err)
+	require.Equal(mi.InfoHash(), parsed.InfoHash())
 }
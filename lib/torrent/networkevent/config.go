@@ -13,8 +13,128 @@
 // limitations under the License.
 package networkevent
 
+import (
+	"time"
+
+	"github.com/c2h5oh/datasize"
+)
+
 // Config defines network event configuration.
 type Config struct {
 	LogPath string `yaml:"log_path"`
 	Enabled bool   `yaml:"enabled"`
+
+	// Rotation configures rotation of the LogPath file.
+	Rotation RotationConfig `yaml:"rotation"`
+
+	// HTTP configures pushing batches of events to a remote endpoint.
+	HTTP HTTPConfig `yaml:"http"`
+
+	// Socket configures streaming events to local Unix socket clients.
+	Socket SocketConfig `yaml:"socket"`
+
+	// Stream configures streaming events to HTTP clients of the agent and
+	// origin servers.
+	Stream StreamConfig `yaml:"stream"`
+
+	// SampleRates maps event names to the fraction of those events which are
+	// produced, between 0 and 1. Events not listed are always produced.
+	//
+	// Sampling is deterministic by torrent, peer pair and piece, such that the
+	// request_piece and receive_piece events of a single transfer are kept
+	// together when both are sampled at the same rate.
+	SampleRates map[Name]float64 `yaml:"sample_rates"`
+}
+
+// RotationConfig defines rotation of the network event log file.
+type RotationConfig struct {
+
+	// MaxSize is the size at which the log file is rotated. Rotation is
+	// disabled if zero.
+	MaxSize datasize.ByteSize `yaml:"max_size"`
+
+	// MaxBackups is the number of rotated files which are kept, named with
+	// increasing numeric suffixes.
+	MaxBackups int `yaml:"max_backups"`
+}
+
+func (c RotationConfig) applyDefaults() RotationConfig {
+	if c.MaxBackups == 0 {
+		c.MaxBackups = 3
+	}
+	return c
+}
+
+// HTTPConfig defines the HTTP batch sink. Events are pushed as newline
+// delimited JSON.
+type HTTPConfig struct {
+
+	// URL is the endpoint events are POSTed to. Disabled if empty.
+	URL string `yaml:"url"`
+
+	Headers map[string]string `yaml:"headers"`
+
+	// BatchSize is the maximum number of events pushed per request.
+	BatchSize int `yaml:"batch_size"`
+
+	// FlushInterval is the maximum time events are buffered before a partial
+	// batch is pushed.
+	FlushInterval time.Duration `yaml:"flush_interval"`
+
+	// QueueSize bounds the number of events buffered while pushing. Events
+	// produced while the queue is full are dropped.
+	QueueSize int `yaml:"queue_size"`
+
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c HTTPConfig) applyDefaults() HTTPConfig {
+	if c.BatchSize == 0 {
+		c.BatchSize = 500
+	}
+	if c.FlushInterval == 0 {
+		c.FlushInterval = 5 * time.Second
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = 10000
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+	return c
+}
+
+// SocketConfig defines the Unix socket sink. Each client connected to the
+// socket receives a stream of newline delimited JSON events.
+type SocketConfig struct {
+
+	// Path is the path of the Unix socket. Disabled if empty.
+	Path string `yaml:"path"`
+
+	// ClientBufferSize bounds the number of events buffered per client. Events
+	// are dropped for clients which fall behind.
+	ClientBufferSize int `yaml:"client_buffer_size"`
+}
+
+func (c SocketConfig) applyDefaults() SocketConfig {
+	if c.ClientBufferSize == 0 {
+		c.ClientBufferSize = 1000
+	}
+	return c
+}
+
+// StreamConfig defines the HTTP stream sink.
+type StreamConfig struct {
+	Enable bool `yaml:"enable"`
+
+	// ClientBufferSize bounds the number of events buffered per client. Events
+	// are dropped for clients which fall behind.
+	ClientBufferSize int `yaml:"client_buffer_size"`
+}
+
+func (c StreamConfig) applyDefaults() StreamConfig {
+	if c.ClientBufferSize == 0 {
+		c.ClientBufferSize = 1000
+	}
+	return c
 }
@@ -46,6 +46,7 @@ type Event struct {
 	Time    time.Time `json:"ts"`
 
 	// Optional fields.
+	Namespace    string `json:"namespace,omitempty"`
 	Peer         string `json:"peer,omitempty"`
 	Piece        int    `json:"piece,omitempty"`
 	Bitfield     []bool `json:"bitfield,omitempty"`
@@ -73,8 +74,11 @@ func (e *Event) JSON() string {
 }
 
 // AddTorrentEvent returns an event for an added torrent with initial bitfield.
-func AddTorrentEvent(h core.InfoHash, self core.PeerID, b *bitset.BitSet, connCapacity int) *Event {
+func AddTorrentEvent(
+	h core.InfoHash, self core.PeerID, namespace string, b *bitset.BitSet, connCapacity int) *Event {
+
 	e := baseEvent(AddTorrent, h, self)
+	e.Namespace = namespace
 	bools := make([]bool, b.Len())
 	for i := uint(0); i < b.Len(); i++ {
 		bools[i] = b.Test(i)
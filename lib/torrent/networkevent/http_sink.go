@@ -0,0 +1,154 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+
+	"go.uber.org/atomic"
+)
+
+// ErrSinkFull is returned when an event is dropped because a sink's buffer
+// is full.
+var ErrSinkFull = errors.New("sink is full")
+
+// httpSink asynchronously pushes batches of events to a remote endpoint.
+// Writes never block: events are dropped once the queue is full, such that
+// a slow or unavailable endpoint cannot stall the scheduler.
+type httpSink struct {
+	config HTTPConfig
+
+	queue   chan []byte
+	dropped atomic.Int64
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewHTTPSink creates a Sink which pushes events to config.URL.
+func NewHTTPSink(config HTTPConfig) (Sink, error) {
+	config = config.applyDefaults()
+	if config.URL == "" {
+		return nil, errors.New("no url supplied")
+	}
+	s := &httpSink{
+		config: config,
+		queue:  make(chan []byte, config.QueueSize),
+		done:   make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.loop()
+	return s, nil
+}
+
+func (s *httpSink) Write(e *Event) error {
+	line, err := marshalLine(e)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-s.done:
+		return errors.New("sink is closed")
+	default:
+	}
+	select {
+	case s.queue <- line:
+		return nil
+	default:
+		s.dropped.Inc()
+		return ErrSinkFull
+	}
+}
+
+func (s *httpSink) loop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch bytes.Buffer
+	var n int
+	flush := func() {
+		if n > 0 {
+			s.push(batch.Bytes(), n)
+		}
+		batch.Reset()
+		n = 0
+	}
+	add := func(line []byte) {
+		batch.Write(line)
+		n++
+		if n >= s.config.BatchSize {
+			flush()
+		}
+	}
+	for {
+		select {
+		case line := <-s.queue:
+			add(line)
+		case <-ticker.C:
+			flush()
+		case <-s.done:
+			// Drain events which were queued before closing.
+			for {
+				select {
+				case line := <-s.queue:
+					add(line)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *httpSink) push(body []byte, n int) {
+	if dropped := s.dropped.Swap(0); dropped > 0 {
+		log.Warnf("Dropped %d network events, http sink queue is full", dropped)
+	}
+	_, err := httputil.Post(
+		s.config.URL,
+		httputil.SendBody(bytes.NewReader(body)),
+		httputil.SendHeaders(s.headers()),
+		httputil.SendTimeout(s.config.Timeout),
+		httputil.SendAcceptedCodes(200, 202, 204))
+	if err != nil {
+		log.Errorf("Error pushing %d network events: %s", n, err)
+	}
+}
+
+func (s *httpSink) headers() map[string]string {
+	h := map[string]string{"Content-Type": "application/x-ndjson"}
+	for k, v := range s.config.Headers {
+		h[k] = v
+	}
+	return h
+}
+
+// Close flushes all queued events and stops the sink.
+func (s *httpSink) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+	})
+	return nil
+}
@@ -14,12 +14,15 @@
 package networkevent
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
+	"math"
+	"strconv"
 
+	"github.com/uber/kraken/utils/errutil"
 	"github.com/uber/kraken/utils/log"
+
+	"github.com/spaolacci/murmur3"
 )
 
 // Producer emits events.
@@ -29,58 +32,113 @@ type Producer interface {
 }
 
 type producer struct {
-	file *os.File
+	sinks       []Sink
+	sampleRates map[Name]float64
 }
 
-// NewProducer creates a new Producer.
-func NewProducer(config Config) (Producer, error) {
-	var f *os.File
-	if config.Enabled {
-		if config.LogPath == "" {
-			return nil, errors.New("no log path supplied")
+// NewProducer creates a new Producer which writes events to the sinks defined
+// in config. Additional sinks may be supplied, which are written to alongside
+// the configured sinks. If config is disabled, events are only written to the
+// additional sinks, and discarded if there are none.
+func NewProducer(config Config, sinks ...Sink) (Producer, error) {
+	if !config.Enabled {
+		log.Warn("Kafka network events disabled")
+		return &producer{sinks: sinks}, nil
+	}
+	for name, rate := range config.SampleRates {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid sample rate for %s: %f", name, rate)
 		}
-		var flag int
-		if _, err := os.Stat(config.LogPath); err != nil {
-			if os.IsNotExist(err) {
-				flag = os.O_WRONLY | os.O_CREATE | os.O_EXCL
-			} else {
-				return nil, fmt.Errorf("stat: %s", err)
-			}
-		} else {
-			flag = os.O_WRONLY | os.O_APPEND
+	}
+	p := &producer{sampleRates: config.SampleRates}
+	if config.LogPath != "" {
+		s, err := NewFileSink(config.LogPath, config.Rotation)
+		if err != nil {
+			return nil, fmt.Errorf("file sink: %s", err)
 		}
-		var err error
-		f, err = os.OpenFile(config.LogPath, flag, 0775)
+		p.sinks = append(p.sinks, s)
+	}
+	if config.HTTP.URL != "" {
+		s, err := NewHTTPSink(config.HTTP)
 		if err != nil {
-			return nil, fmt.Errorf("open %d: %s", flag, err)
+			p.Close()
+			return nil, fmt.Errorf("http sink: %s", err)
 		}
-	} else {
-		log.Warn("Kafka network events disabled")
+		p.sinks = append(p.sinks, s)
 	}
-	return &producer{f}, nil
+	if config.Socket.Path != "" {
+		s, err := NewSocketSink(config.Socket)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("socket sink: %s", err)
+		}
+		p.sinks = append(p.sinks, s)
+	}
+	p.sinks = append(p.sinks, sinks...)
+	if len(p.sinks) == 0 {
+		return nil, errors.New("no sinks configured")
+	}
+	return p, nil
 }
 
-// Produce emits a network event.
+// Produce writes e to all sinks if e is sampled.
 func (p *producer) Produce(e *Event) {
-	if p.file == nil {
+	if len(p.sinks) == 0 || !p.sampled(e) {
 		return
 	}
-	b, err := json.Marshal(e)
-	if err != nil {
-		log.Errorf("Error serializing network event to json: %s", err)
-		return
+	for _, s := range p.sinks {
+		if err := s.Write(e); err != nil && err != ErrSinkFull {
+			log.Errorf("Error writing network event: %s", err)
+		}
 	}
-	line := append(b, byte('\n'))
-	if _, err := p.file.Write(line); err != nil {
-		log.Errorf("Error writing network event: %s", err)
-		return
+}
+
+// sampled returns whether e should be produced according to the sample rate
+// of its name. Events are hashed by torrent, peer pair and piece rather than
+// sampled randomly, such that related events are kept or dropped together.
+func (p *producer) sampled(e *Event) bool {
+	rate, ok := p.sampleRates[e.Name]
+	if !ok || rate >= 1 {
+		return true
 	}
+	if rate <= 0 {
+		return false
+	}
+	h := murmur3.New64()
+	h.Write([]byte(e.Torrent))
+	h.Write([]byte(e.Self))
+	h.Write([]byte(e.Peer))
+	h.Write([]byte(strconv.Itoa(e.Piece)))
+	return float64(h.Sum64())/math.MaxUint64 < rate
 }
 
-// Close closes the producer.
+// Close closes all sinks.
 func (p *producer) Close() error {
-	if p.file == nil {
-		return nil
+	var errs []error
+	for _, s := range p.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errutil.Join(errs)
+}
+
+type namespaceProducer struct {
+	Producer
+	namespace func(torrent string) string
+}
+
+// WithNamespace wraps p to set the namespace of events produced without one to
+// namespace(e.Torrent), such that consumers may filter any event by namespace.
+// Closing the returned Producer closes p.
+func WithNamespace(p Producer, namespace func(torrent string) string) Producer {
+	return &namespaceProducer{p, namespace}
+}
+
+// Produce sets the namespace of e if missing and produces e.
+func (p *namespaceProducer) Produce(e *Event) {
+	if e.Namespace == "" {
+		e.Namespace = p.namespace(e.Torrent)
 	}
-	return p.file.Close()
+	p.Producer.Produce(e)
 }
@@ -20,10 +20,12 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/uber/kraken/core"
 
 	"github.com/stretchr/testify/require"
+	"github.com/willf/bitset"
 )
 
 func TestProducerCreatesAndReusesFile(t *testing.T) {
@@ -93,3 +95,134 @@ func TestDisabledProducerNoops(t *testing.T) {
 
 	p.Produce(ReceivePieceEvent(h, peer1, peer2, 1))
 }
+
+type testSink struct {
+	events []*Event
+	closed bool
+}
+
+func (s *testSink) Write(e *Event) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *testSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestProducerWritesToAdditionalSinks(t *testing.T) {
+	require := require.New(t)
+
+	h := core.InfoHashFixture()
+	peer := core.PeerIDFixture()
+
+	s1 := &testSink{}
+	s2 := &testSink{}
+	p, err := NewProducer(Config{Enabled: true}, s1, s2)
+	require.NoError(err)
+
+	e := TorrentCompleteEvent(h, peer)
+	p.Produce(e)
+	require.NoError(p.Close())
+
+	for _, s := range []*testSink{s1, s2} {
+		require.Equal([]*Event{e}, s.events)
+		require.True(s.closed)
+	}
+}
+
+func TestDisabledProducerWritesToAdditionalSinks(t *testing.T) {
+	require := require.New(t)
+
+	h := core.InfoHashFixture()
+	peer := core.PeerIDFixture()
+
+	s := &testSink{}
+	p, err := NewProducer(Config{}, s)
+	require.NoError(err)
+
+	e := TorrentCompleteEvent(h, peer)
+	p.Produce(e)
+	require.NoError(p.Close())
+
+	require.Equal([]*Event{e}, s.events)
+	require.True(s.closed)
+}
+
+func TestWithNamespaceSetsMissingNamespaces(t *testing.T) {
+	require := require.New(t)
+
+	h := core.InfoHashFixture()
+	peer := core.PeerIDFixture()
+
+	s := &testSink{}
+	p, err := NewProducer(Config{Enabled: true}, s)
+	require.NoError(err)
+
+	p = WithNamespace(p, func(torrent string) string {
+		require.Equal(h.String(), torrent)
+		return "foo"
+	})
+
+	e1 := TorrentCompleteEvent(h, peer)
+	e2 := AddTorrentEvent(h, peer, "bar", bitset.New(1), 10)
+	p.Produce(e1)
+	p.Produce(e2)
+
+	require.Len(s.events, 2)
+	require.Equal("foo", s.events[0].Namespace)
+	require.Equal("bar", s.events[1].Namespace)
+}
+
+func TestProducerErrorsWithoutSinks(t *testing.T) {
+	_, err := NewProducer(Config{Enabled: true})
+	require.Error(t, err)
+}
+
+func TestProducerRejectsInvalidSampleRates(t *testing.T) {
+	_, err := NewProducer(Config{
+		Enabled:     true,
+		SampleRates: map[Name]float64{RequestPiece: 1.5},
+	}, &testSink{})
+	require.Error(t, err)
+}
+
+func TestProducerSampling(t *testing.T) {
+	require := require.New(t)
+
+	h := core.InfoHashFixture()
+	self := core.PeerIDFixture()
+	peer := core.PeerIDFixture()
+
+	s := &testSink{}
+	p, err := NewProducer(Config{
+		Enabled: true,
+		SampleRates: map[Name]float64{
+			RequestPiece:  0.25,
+			ReceivePiece:  0.25,
+			BlacklistConn: 0,
+		},
+	}, s)
+	require.NoError(err)
+
+	n := 1000
+	for i := 0; i < n; i++ {
+		p.Produce(RequestPieceEvent(h, self, peer, i))
+		p.Produce(ReceivePieceEvent(h, self, peer, i))
+	}
+	p.Produce(BlacklistConnEvent(h, self, peer, time.Minute))
+	p.Produce(TorrentCompleteEvent(h, self))
+
+	requests := Filter(s.events, RequestPiece)
+	receives := Filter(s.events, ReceivePiece)
+	require.InDelta(n/4, len(requests), float64(n)/10)
+	require.Empty(Filter(s.events, BlacklistConn))
+	require.Len(Filter(s.events, TorrentComplete), 1)
+
+	// Requests and receives of the same piece are sampled together.
+	require.Equal(len(requests), len(receives))
+	for i := range requests {
+		require.Equal(requests[i].Piece, receives[i].Piece)
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/uber/kraken/lib/torrent/networkevent"
+	"github.com/uber/kraken/utils/stringset"
+)
+
+// Config defines Analyze configuration.
+type Config struct {
+
+	// TopN is the number of slowest pieces, idle peers and sources reported.
+	TopN int `yaml:"top_n"`
+
+	// Origins are the peer ids of origins. If empty, peers which added a
+	// torrent already complete are treated as origins.
+	Origins []string `yaml:"origins"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.TopN == 0 {
+		c.TopN = 5
+	}
+	return c
+}
+
+// Read parses newline delimited JSON events from r, as written by a
+// networkevent.Producer. Malformed lines are skipped and counted.
+func Read(r io.Reader) (events []*networkevent.Event, malformed int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e networkevent.Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			malformed++
+			continue
+		}
+		events = append(events, &e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("scan: %s", err)
+	}
+	return events, malformed, nil
+}
+
+// PieceTransfer is a piece received by Self from Peer.
+type PieceTransfer struct {
+	Self        string        `json:"self"`
+	Peer        string        `json:"peer"`
+	Piece       int           `json:"piece"`
+	RequestedAt time.Time     `json:"requested_at"`
+	ReceivedAt  time.Time     `json:"received_at"`
+	Latency     time.Duration `json:"latency"`
+}
+
+// Source is a peer which served pieces to a download.
+type Source struct {
+	Peer   string `json:"peer"`
+	Origin bool   `json:"origin"`
+	Pieces int    `json:"pieces"`
+}
+
+// Download is the timeline of a single peer downloading a torrent.
+type Download struct {
+	Peer      string    `json:"peer"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Complete  bool      `json:"complete"`
+	Cancelled bool      `json:"cancelled"`
+	Pieces    int       `json:"pieces"`
+
+	// LongestIdle is the longest duration the download went without receiving
+	// a piece, and IdleSince is when it began.
+	LongestIdle time.Duration `json:"longest_idle"`
+	IdleSince   time.Time     `json:"idle_since"`
+
+	// SlowestPieces are the pieces which took longest to arrive after being
+	// requested.
+	SlowestPieces []PieceTransfer `json:"slowest_pieces"`
+
+	// Sources are the peers which served the most pieces.
+	Sources []Source `json:"sources"`
+
+	transfers []PieceTransfer
+	receipts  []time.Time
+	sources   map[string]int
+}
+
+// Duration returns how long the download took, or has taken until its last
+// event if incomplete.
+func (d *Download) Duration() time.Duration {
+	return d.End.Sub(d.Start)
+}
+
+// TorrentReport summarizes the swarm of a single torrent.
+type TorrentReport struct {
+	Torrent   string    `json:"torrent"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Origins   []string  `json:"origins"`
+	Peers     int       `json:"peers"`
+	Completed int       `json:"completed"`
+	Cancelled int       `json:"cancelled"`
+
+	// OriginShare is the fraction of pieces received from origins.
+	OriginShare float64 `json:"origin_share"`
+
+	// CriticalPath is the slowest download, which determines how long the
+	// swarm took to converge. Ties are broken by lowest peer id.
+	CriticalPath *Download `json:"critical_path"`
+
+	// SlowestPieces are the slowest piece transfers across all downloads.
+	SlowestPieces []PieceTransfer `json:"slowest_pieces"`
+
+	// IdlePeers are the downloads which went the longest without receiving a
+	// piece. Ties are broken by peer id.
+	IdlePeers []*Download `json:"idle_peers"`
+}
+
+type requestKey struct {
+	self  string
+	peer  string
+	piece int
+}
+
+// Analyze replays events, which may be gathered from many hosts in any order,
+// and returns a report for each torrent sorted by start time.
+func Analyze(events []*networkevent.Event, config Config) []*TorrentReport {
+	config = config.applyDefaults()
+
+	sorted := make([]*networkevent.Event, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	byTorrent := make(map[string][]*networkevent.Event)
+	for _, e := range sorted {
+		byTorrent[e.Torrent] = append(byTorrent[e.Torrent], e)
+	}
+
+	var reports []*TorrentReport
+	for h, events := range byTorrent {
+		reports = append(reports, analyzeTorrent(h, events, config))
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Start.Equal(reports[j].Start) {
+			return reports[i].Torrent < reports[j].Torrent
+		}
+		return reports[i].Start.Before(reports[j].Start)
+	})
+	return reports
+}
+
+// analyzeTorrent analyzes the events of a single torrent in time order.
+func analyzeTorrent(h string, events []*networkevent.Event, config Config) *TorrentReport {
+	origins := stringset.FromSlice(config.Origins)
+	inferOrigins := len(origins) == 0
+
+	downloads := make(map[string]*Download)
+	requests := make(map[requestKey]time.Time)
+
+	r := &TorrentReport{Torrent: h}
+	if len(events) > 0 {
+		r.Start = events[0].Time
+		r.End = events[len(events)-1].Time
+	}
+
+	for _, e := range events {
+		if e.Name == networkevent.AddTorrent {
+			if complete(e.Bitfield) {
+				if inferOrigins {
+					origins.Add(e.Self)
+				}
+			} else if _, ok := downloads[e.Self]; !ok {
+				downloads[e.Self] = &Download{
+					Peer:     e.Self,
+					Start:    e.Time,
+					End:      e.Time,
+					receipts: []time.Time{e.Time},
+					sources:  make(map[string]int),
+				}
+			}
+			continue
+		}
+		d, ok := downloads[e.Self]
+		if !ok || d.Complete {
+			continue
+		}
+		d.End = e.Time
+		switch e.Name {
+		case networkevent.RequestPiece:
+			requests[requestKey{e.Self, e.Peer, e.Piece}] = e.Time
+		case networkevent.ReceivePiece:
+			d.Pieces++
+			d.sources[e.Peer]++
+			d.receipts = append(d.receipts, e.Time)
+			k := requestKey{e.Self, e.Peer, e.Piece}
+			if t, ok := requests[k]; ok {
+				d.transfers = append(d.transfers, PieceTransfer{
+					Self:        e.Self,
+					Peer:        e.Peer,
+					Piece:       e.Piece,
+					RequestedAt: t,
+					ReceivedAt:  e.Time,
+					Latency:     e.Time.Sub(t),
+				})
+				delete(requests, k)
+			}
+		case networkevent.TorrentComplete:
+			d.Complete = true
+			d.receipts = append(d.receipts, e.Time)
+		case networkevent.TorrentCancelled:
+			d.Cancelled = true
+		}
+	}
+
+	r.Origins = origins.ToSlice()
+	sort.Strings(r.Origins)
+
+	var transfers []PieceTransfer
+	var received, fromOrigins int
+	var all []*Download
+	for _, d := range downloads {
+		if origins.Has(d.Peer) {
+			continue
+		}
+		finish(d, origins, config.TopN)
+		all = append(all, d)
+
+		r.Peers++
+		if d.Complete {
+			r.Completed++
+		}
+		if d.Cancelled {
+			r.Cancelled++
+		}
+		transfers = append(transfers, d.transfers...)
+		for peer, n := range d.sources {
+			received += n
+			if origins.Has(peer) {
+				fromOrigins += n
+			}
+		}
+		if r.CriticalPath == nil || d.Duration() > r.CriticalPath.Duration() ||
+			(d.Duration() == r.CriticalPath.Duration() && d.Peer < r.CriticalPath.Peer) {
+			r.CriticalPath = d
+		}
+	}
+	if received > 0 {
+		r.OriginShare = float64(fromOrigins) / float64(received)
+	}
+	r.SlowestPieces = slowest(transfers, config.TopN)
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].LongestIdle == all[j].LongestIdle {
+			return all[i].Peer < all[j].Peer
+		}
+		return all[i].LongestIdle > all[j].LongestIdle
+	})
+	if len(all) > config.TopN {
+		all = all[:config.TopN]
+	}
+	r.IdlePeers = all
+
+	return r
+}
+
+// finish computes the summary fields of d.
+func finish(d *Download, origins stringset.Set, n int) {
+	receipts := append(d.receipts, d.End)
+	for i := 1; i < len(receipts); i++ {
+		if gap := receipts[i].Sub(receipts[i-1]); gap > d.LongestIdle {
+			d.LongestIdle = gap
+			d.IdleSince = receipts[i-1]
+		}
+	}
+
+	d.SlowestPieces = slowest(d.transfers, n)
+
+	d.Sources = nil
+	for peer, pieces := range d.sources {
+		d.Sources = append(d.Sources, Source{peer, origins.Has(peer), pieces})
+	}
+	sort.Slice(d.Sources, func(i, j int) bool {
+		if d.Sources[i].Pieces == d.Sources[j].Pieces {
+			return d.Sources[i].Peer < d.Sources[j].Peer
+		}
+		return d.Sources[i].Pieces > d.Sources[j].Pieces
+	})
+	if len(d.Sources) > n {
+		d.Sources = d.Sources[:n]
+	}
+}
+
+// slowest returns the n transfers with the highest latency.
+func slowest(transfers []PieceTransfer, n int) []PieceTransfer {
+	s := make([]PieceTransfer, len(transfers))
+	copy(s, transfers)
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].Latency != s[j].Latency {
+			return s[i].Latency > s[j].Latency
+		}
+		if s[i].Self != s[j].Self {
+			return s[i].Self < s[j].Self
+		}
+		if s[i].Peer != s[j].Peer {
+			return s[i].Peer < s[j].Peer
+		}
+		return s[i].Piece < s[j].Piece
+	})
+	if len(s) > n {
+		s = s[:n]
+	}
+	return s
+}
+
+func complete(bitfield []bool) bool {
+	if len(bitfield) == 0 {
+		return false
+	}
+	for _, b := range bitfield {
+		if !b {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package replay
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/willf/bitset"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/networkevent"
+)
+
+type eventBuilder struct {
+	start  time.Time
+	events []*networkevent.Event
+}
+
+func (b *eventBuilder) add(e *networkevent.Event, offset time.Duration) {
+	e.Time = b.start.Add(offset)
+	b.events = append(b.events, e)
+}
+
+func TestAnalyze(t *testing.T) {
+	require := require.New(t)
+
+	h := core.InfoHashFixture()
+	origin := core.PeerIDFixture()
+	p1 := core.PeerIDFixture()
+	p2 := core.PeerIDFixture()
+
+	b := &eventBuilder{start: time.Now()}
+
+	b.add(networkevent.AddTorrentEvent(h, origin, "", bitset.New(2).Set(0).Set(1), 10), 0)
+
+	// p1 downloads both pieces from the origin.
+	b.add(networkevent.AddTorrentEvent(h, p1, "", bitset.New(2), 10), 0)
+	b.add(networkevent.RequestPieceEvent(h, p1, origin, 0), time.Second)
+	b.add(networkevent.RequestPieceEvent(h, p1, origin, 1), time.Second)
+	b.add(networkevent.ReceivePieceEvent(h, p1, origin, 0), 2*time.Second)
+	b.add(networkevent.ReceivePieceEvent(h, p1, origin, 1), 3*time.Second)
+	b.add(networkevent.TorrentCompleteEvent(h, p1), 3*time.Second)
+
+	// p2 downloads piece 0 from p1 and stalls on piece 1 from the origin.
+	b.add(networkevent.AddTorrentEvent(h, p2, "", bitset.New(2), 10), time.Second)
+	b.add(networkevent.RequestPieceEvent(h, p2, p1, 0), 4*time.Second)
+	b.add(networkevent.RequestPieceEvent(h, p2, origin, 1), 4*time.Second)
+	b.add(networkevent.ReceivePieceEvent(h, p2, p1, 0), 5*time.Second)
+	b.add(networkevent.ReceivePieceEvent(h, p2, origin, 1), 14*time.Second)
+	b.add(networkevent.TorrentCompleteEvent(h, p2), 14*time.Second)
+
+	reports := Analyze(b.events, Config{})
+	require.Len(reports, 1)
+	r := reports[0]
+
+	require.Equal(h.String(), r.Torrent)
+	require.Equal([]string{origin.String()}, r.Origins)
+	require.Equal(2, r.Peers)
+	require.Equal(2, r.Completed)
+	require.Equal(0, r.Cancelled)
+	require.Equal(0.75, r.OriginShare)
+
+	require.Equal(p2.String(), r.CriticalPath.Peer)
+	require.Equal(13*time.Second, r.CriticalPath.Duration())
+	require.Equal(9*time.Second, r.CriticalPath.LongestIdle)
+	require.ElementsMatch([]Source{
+		{Peer: origin.String(), Origin: true, Pieces: 1},
+		{Peer: p1.String(), Origin: false, Pieces: 1},
+	}, r.CriticalPath.Sources)
+
+	require.Len(r.SlowestPieces, 4)
+	require.Equal(p2.String(), r.SlowestPieces[0].Self)
+	require.Equal(1, r.SlowestPieces[0].Piece)
+	require.Equal(10*time.Second, r.SlowestPieces[0].Latency)
+
+	require.Len(r.IdlePeers, 2)
+	require.Equal(p2.String(), r.IdlePeers[0].Peer)
+	require.Equal(p1.String(), r.IdlePeers[1].Peer)
+}
+
+func TestAnalyzeExplicitOrigins(t *testing.T) {
+	require := require.New(t)
+
+	h := core.InfoHashFixture()
+	origin := core.PeerIDFixture()
+	p := core.PeerIDFixture()
+
+	b := &eventBuilder{start: time.Now()}
+	b.add(networkevent.AddTorrentEvent(h, p, "", bitset.New(1), 10), 0)
+	b.add(networkevent.RequestPieceEvent(h, p, origin, 0), time.Second)
+	b.add(networkevent.TorrentCancelledEvent(h, p), 2*time.Second)
+
+	reports := Analyze(b.events, Config{Origins: []string{origin.String()}})
+	require.Len(reports, 1)
+	r := reports[0]
+
+	require.Equal([]string{origin.String()}, r.Origins)
+	require.Equal(1, r.Peers)
+	require.Equal(0, r.Completed)
+	require.Equal(1, r.Cancelled)
+	require.Equal(float64(0), r.OriginShare)
+	require.Empty(r.SlowestPieces)
+	require.Equal(2*time.Second, r.CriticalPath.Duration())
+}
+
+func TestAnalyzeBreaksTiesByPeerID(t *testing.T) {
+	require := require.New(t)
+
+	h := core.InfoHashFixture()
+	origin := core.PeerIDFixture()
+
+	var peers []string
+	b := &eventBuilder{start: time.Now()}
+	for i := 0; i < 5; i++ {
+		p := core.PeerIDFixture()
+		peers = append(peers, p.String())
+		b.add(networkevent.AddTorrentEvent(h, p, "", bitset.New(1), 10), 0)
+		b.add(networkevent.RequestPieceEvent(h, p, origin, 0), time.Second)
+		b.add(networkevent.ReceivePieceEvent(h, p, origin, 0), 2*time.Second)
+		b.add(networkevent.TorrentCompleteEvent(h, p), 2*time.Second)
+	}
+	sort.Strings(peers)
+
+	for i := 0; i < 10; i++ {
+		reports := Analyze(b.events, Config{Origins: []string{origin.String()}, TopN: 3})
+		require.Len(reports, 1)
+		r := reports[0]
+
+		require.Equal(peers[0], r.CriticalPath.Peer)
+		require.Len(r.IdlePeers, 3)
+		for j, d := range r.IdlePeers {
+			require.Equal(peers[j], d.Peer)
+		}
+		require.Len(r.SlowestPieces, 3)
+		for j, pt := range r.SlowestPieces {
+			require.Equal(peers[j], pt.Self)
+		}
+	}
+}
+
+func TestAnalyzeEventsFromManyHostsOutOfOrder(t *testing.T) {
+	require := require.New(t)
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+	p := core.PeerIDFixture()
+
+	b := &eventBuilder{start: time.Now()}
+	b.add(networkevent.TorrentCompleteEvent(h2, p), 5*time.Second)
+	b.add(networkevent.AddTorrentEvent(h2, p, "", bitset.New(1), 10), 2*time.Second)
+	b.add(networkevent.AddTorrentEvent(h1, p, "", bitset.New(1), 10), time.Second)
+	b.add(networkevent.TorrentCompleteEvent(h1, p), 3*time.Second)
+
+	reports := Analyze(b.events, Config{})
+	require.Len(reports, 2)
+	require.Equal(h1.String(), reports[0].Torrent)
+	require.Equal(2*time.Second, reports[0].CriticalPath.Duration())
+	require.Equal(h2.String(), reports[1].Torrent)
+	require.Equal(3*time.Second, reports[1].CriticalPath.Duration())
+}
+
+func TestRead(t *testing.T) {
+	require := require.New(t)
+
+	h := core.InfoHashFixture()
+	p := core.PeerIDFixture()
+
+	var buf bytes.Buffer
+	buf.WriteString(networkevent.AddTorrentEvent(h, p, "", bitset.New(1), 10).JSON() + "\n")
+	buf.WriteString("not json\n")
+	buf.WriteString("\n")
+	buf.WriteString(networkevent.TorrentCompleteEvent(h, p).JSON() + "\n")
+
+	events, malformed, err := Read(&buf)
+	require.NoError(err)
+	require.Equal(1, malformed)
+	require.Len(events, 2)
+	require.Equal(networkevent.AddTorrent, events[0].Name)
+	require.Equal(networkevent.TorrentComplete, events[1].Name)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Sink is a destination for network events. Sinks must be safe for concurrent
+// use.
+type Sink interface {
+	Write(e *Event) error
+	Close() error
+}
+
+// marshalLine serializes e as a single line of JSON.
+func marshalLine(e *Event) ([]byte, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("json: %s", err)
+	}
+	return append(b, byte('\n')), nil
+}
+
+// fileSink appends events to a file, optionally rotating it once it exceeds
+// a maximum size.
+type fileSink struct {
+	path   string
+	config RotationConfig
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink creates a Sink which appends events to path. If path already
+// exists, it is reused.
+func NewFileSink(path string, config RotationConfig) (Sink, error) {
+	s := &fileSink{
+		path:   path,
+		config: config.applyDefaults(),
+	}
+	f, size, err := openAppend(path)
+	if err != nil {
+		return nil, err
+	}
+	s.file = f
+	s.size = size
+	return s, nil
+}
+
+// openAppend opens path for appending, returning the file and its size.
+func openAppend(path string) (*os.File, int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0775)
+	if err != nil {
+		return nil, 0, fmt.Errorf("open: %s", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("stat: %s", err)
+	}
+	return f, info.Size(), nil
+}
+
+func (s *fileSink) Write(e *Event) error {
+	line, err := marshalLine(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	var rotateErr error
+	if s.config.MaxSize > 0 && s.size > 0 &&
+		uint64(s.size+int64(len(line))) > s.config.MaxSize.Bytes() {
+
+		if err := s.rotate(); err != nil {
+			// The current file is kept on failure, so the event is not lost.
+			rotateErr = fmt.Errorf("rotate: %s", err)
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+// rotate shifts path.i to path.i+1, dropping the oldest backup, and moves the
+// current file to path.1. The current file is only closed once a new file
+// exists at path, so it keeps receiving events if rotation fails.
+func (s *fileSink) rotate() error {
+	for i := s.config.MaxBackups - 1; i >= 1; i-- {
+		src := s.backup(i)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(src, s.backup(i+1)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, s.backup(1)); err != nil {
+		return err
+	}
+	f, size, err := openAppend(s.path)
+	if err != nil {
+		// Move the current file back, such that the next rotation finds it.
+		os.Rename(s.backup(1), s.path)
+		return err
+	}
+	old := s.file
+	s.file = f
+	s.size = size
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("close: %s", err)
+	}
+	return nil
+}
+
+func (s *fileSink) backup(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,350 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/stretchr/testify/require"
+)
+
+func readEvents(t *testing.T, path string) []*Event {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var events []*Event
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		e := new(Event)
+		require.NoError(t, json.Unmarshal(s.Bytes(), e))
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	h := core.InfoHashFixture()
+	peer := core.PeerIDFixture()
+
+	e := TorrentCompleteEvent(h, peer)
+	line, err := marshalLine(e)
+	require.NoError(err)
+
+	// Each file fits exactly two events.
+	path := filepath.Join(dir, "netevents")
+	s, err := NewFileSink(path, RotationConfig{
+		MaxSize:    datasize.ByteSize(2 * len(line)),
+		MaxBackups: 2,
+	})
+	require.NoError(err)
+
+	for i := 0; i < 7; i++ {
+		require.NoError(s.Write(e))
+	}
+	require.NoError(s.Close())
+
+	require.Len(readEvents(t, path), 1)
+	require.Len(readEvents(t, path+".1"), 2)
+	require.Len(readEvents(t, path+".2"), 2)
+	_, err = os.Stat(path + ".3")
+	require.True(os.IsNotExist(err))
+}
+
+func TestFileSinkWithoutRotation(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "netevents")
+	s, err := NewFileSink(path, RotationConfig{})
+	require.NoError(err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(s.Write(TorrentCompleteEvent(core.InfoHashFixture(), core.PeerIDFixture())))
+	}
+	require.NoError(s.Close())
+
+	require.Len(readEvents(t, path), 10)
+	_, err = os.Stat(path + ".1")
+	require.True(os.IsNotExist(err))
+}
+
+func TestFileSinkKeepsFileWhenRotationFails(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	e := TorrentCompleteEvent(core.InfoHashFixture(), core.PeerIDFixture())
+	line, err := marshalLine(e)
+	require.NoError(err)
+
+	path := filepath.Join(dir, "netevents")
+	s, err := NewFileSink(path, RotationConfig{
+		MaxSize:    datasize.ByteSize(len(line)),
+		MaxBackups: 1,
+	})
+	require.NoError(err)
+
+	// A non-empty directory in place of the backup fails the rotation.
+	require.NoError(os.MkdirAll(filepath.Join(path+".1", "x"), 0775))
+
+	require.NoError(s.Write(e))
+	require.Error(s.Write(e))
+	require.NoError(os.RemoveAll(path + ".1"))
+	require.NoError(s.Write(e))
+	require.NoError(s.Close())
+
+	require.Len(readEvents(t, path+".1"), 2)
+	require.Len(readEvents(t, path), 1)
+}
+
+type eventServer struct {
+	sync.Mutex
+	batches [][]*Event
+	headers []http.Header
+}
+
+func (s *eventServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var batch []*Event
+	sc := bufio.NewScanner(r.Body)
+	for sc.Scan() {
+		e := new(Event)
+		if err := json.Unmarshal(sc.Bytes(), e); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		batch = append(batch, e)
+	}
+	s.Lock()
+	s.batches = append(s.batches, batch)
+	s.headers = append(s.headers, r.Header)
+	s.Unlock()
+}
+
+func (s *eventServer) getBatches() [][]*Event {
+	s.Lock()
+	defer s.Unlock()
+	return append([][]*Event(nil), s.batches...)
+}
+
+func TestHTTPSinkPushesBatches(t *testing.T) {
+	require := require.New(t)
+
+	server := &eventServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	s, err := NewHTTPSink(HTTPConfig{
+		URL:           ts.URL,
+		Headers:       map[string]string{"X-Cluster": "test"},
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+	})
+	require.NoError(err)
+
+	var events []*Event
+	for i := 0; i < 7; i++ {
+		e := ReceivePieceEvent(core.InfoHashFixture(), core.PeerIDFixture(), core.PeerIDFixture(), i)
+		events = append(events, e)
+		require.NoError(s.Write(e))
+	}
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		return len(server.getBatches()) == 2
+	}))
+
+	// Closing flushes the partial batch.
+	require.NoError(s.Close())
+
+	batches := server.getBatches()
+	require.Len(batches, 3)
+	require.Len(batches[0], 3)
+	require.Len(batches[1], 3)
+	require.Len(batches[2], 1)
+
+	var results []*Event
+	for _, b := range batches {
+		results = append(results, b...)
+	}
+	require.Equal(StripTimestamps(events), StripTimestamps(results))
+	require.Equal("test", server.headers[0].Get("X-Cluster"))
+	require.Equal("application/x-ndjson", server.headers[0].Get("Content-Type"))
+}
+
+func TestHTTPSinkFlushesOnInterval(t *testing.T) {
+	require := require.New(t)
+
+	server := &eventServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	s, err := NewHTTPSink(HTTPConfig{
+		URL:           ts.URL,
+		FlushInterval: 10 * time.Millisecond,
+	})
+	require.NoError(err)
+	defer s.Close()
+
+	require.NoError(s.Write(TorrentCompleteEvent(core.InfoHashFixture(), core.PeerIDFixture())))
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		return len(server.getBatches()) == 1
+	}))
+}
+
+func TestHTTPSinkDropsEventsWhenFull(t *testing.T) {
+	require := require.New(t)
+
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer ts.Close()
+
+	s, err := NewHTTPSink(HTTPConfig{
+		URL:           ts.URL,
+		BatchSize:     1,
+		QueueSize:     2,
+		FlushInterval: time.Hour,
+	})
+	require.NoError(err)
+
+	e := TorrentCompleteEvent(core.InfoHashFixture(), core.PeerIDFixture())
+
+	// The first event is pulled off the queue and blocks pushing, then the
+	// queue fills up.
+	var full bool
+	for i := 0; i < 10; i++ {
+		if err := s.Write(e); err == ErrSinkFull {
+			full = true
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.True(full)
+
+	close(unblock)
+	require.NoError(s.Close())
+}
+
+func TestSocketSinkStreamsEventsToClients(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "netevents.sock")
+	s, err := NewSocketSink(SocketConfig{Path: path})
+	require.NoError(err)
+
+	var clients []*bufio.Scanner
+	for i := 0; i < 2; i++ {
+		nc, err := net.Dial("unix", path)
+		require.NoError(err)
+		defer nc.Close()
+		clients = append(clients, bufio.NewScanner(nc))
+	}
+
+	// Wait for clients to be registered.
+	ss := s.(*socketSink)
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		ss.mu.Lock()
+		defer ss.mu.Unlock()
+		return len(ss.clients) == 2
+	}))
+
+	e := TorrentCompleteEvent(core.InfoHashFixture(), core.PeerIDFixture())
+	require.NoError(s.Write(e))
+
+	for _, c := range clients {
+		require.True(c.Scan())
+		result := new(Event)
+		require.NoError(json.NewDecoder(strings.NewReader(c.Text())).Decode(result))
+		require.Equal(StripTimestamps([]*Event{e}), StripTimestamps([]*Event{result}))
+	}
+
+	require.NoError(s.Close())
+
+	// Clients are disconnected and the socket is removed.
+	for _, c := range clients {
+		require.False(c.Scan())
+	}
+	_, err = os.Stat(path)
+	require.True(os.IsNotExist(err))
+}
+
+func TestSocketSinkCloseDisconnectsStalledClients(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "netevents.sock")
+	s, err := NewSocketSink(SocketConfig{Path: path})
+	require.NoError(err)
+
+	// The client never reads, so the sink eventually blocks writing to it.
+	nc, err := net.Dial("unix", path)
+	require.NoError(err)
+	defer nc.Close()
+
+	ss := s.(*socketSink)
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		ss.mu.Lock()
+		defer ss.mu.Unlock()
+		return len(ss.clients) == 1
+	}))
+
+	e := TorrentCompleteEvent(core.InfoHashFixture(), core.PeerIDFixture())
+	for i := 0; i < 10000; i++ {
+		require.NoError(s.Write(e))
+	}
+
+	closed := make(chan error)
+	go func() { closed <- s.Close() }()
+	select {
+	case err := <-closed:
+		require.NoError(err)
+	case <-time.After(5 * time.Second):
+		require.FailNow("close blocked on stalled client")
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/uber/kraken/utils/log"
+)
+
+// socketSink streams events to all clients connected to a Unix socket. Each
+// client has a bounded buffer, and events are dropped for clients which fall
+// behind rather than blocking the producer.
+type socketSink struct {
+	config   SocketConfig
+	listener net.Listener
+
+	mu      sync.Mutex
+	clients map[*socketClient]struct{}
+	closed  bool
+
+	wg sync.WaitGroup
+}
+
+type socketClient struct {
+	conn   net.Conn
+	events chan []byte
+	done   chan struct{}
+}
+
+// NewSocketSink creates a Sink which listens on the Unix socket config.Path.
+// Any stale socket file at config.Path is removed.
+func NewSocketSink(config SocketConfig) (Sink, error) {
+	config = config.applyDefaults()
+	if config.Path == "" {
+		return nil, errors.New("no socket path supplied")
+	}
+	if err := os.Remove(config.Path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("remove stale socket: %s", err)
+	}
+	l, err := net.Listen("unix", config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %s", err)
+	}
+	s := &socketSink{
+		config:   config,
+		listener: l,
+		clients:  make(map[*socketClient]struct{}),
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+func (s *socketSink) accept() {
+	defer s.wg.Done()
+
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if !closed {
+				log.Errorf("Error accepting network event socket client: %s", err)
+			}
+			return
+		}
+		c := &socketClient{
+			conn:   nc,
+			events: make(chan []byte, s.config.ClientBufferSize),
+			done:   make(chan struct{}),
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		s.clients[c] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+func (s *socketSink) serve(c *socketClient) {
+	defer s.wg.Done()
+	defer s.remove(c)
+
+	for {
+		select {
+		case line := <-c.events:
+			if _, err := c.conn.Write(line); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (s *socketSink) remove(c *socketClient) {
+	s.mu.Lock()
+	delete(s.clients, c)
+	s.mu.Unlock()
+
+	c.conn.Close()
+}
+
+func (s *socketSink) Write(e *Event) error {
+	line, err := marshalLine(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.clients {
+		select {
+		case c.events <- line:
+		default:
+			// Client is too slow, drop the event.
+		}
+	}
+	return nil
+}
+
+// Close disconnects all clients and removes the socket. Client connections are
+// closed before waiting for their goroutines, which may be blocked writing to
+// clients which stopped reading.
+func (s *socketSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	for c := range s.clients {
+		close(c.done)
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/stringset"
+)
+
+// Stream is a Sink which broadcasts events to HTTP clients. Each client has a
+// bounded buffer, and events are dropped for clients which fall behind rather
+// than blocking the producer.
+type Stream struct {
+	config StreamConfig
+
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	closed      bool
+	done        chan struct{}
+}
+
+type subscriber struct {
+	torrents stringset.Set
+	events   chan *Event
+}
+
+// NewStream creates a new Stream.
+func NewStream(config StreamConfig) *Stream {
+	return &Stream{
+		config:      config.applyDefaults(),
+		subscribers: make(map[*subscriber]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Subscribe returns a channel of events produced after subscribing, limited to
+// torrents if non-empty. The returned function must be called to unsubscribe.
+func (s *Stream) Subscribe(torrents ...string) (<-chan *Event, func()) {
+	sub := &subscriber{
+		torrents: stringset.FromSlice(torrents),
+		events:   make(chan *Event, s.config.ClientBufferSize),
+	}
+	s.mu.Lock()
+	if !s.closed {
+		s.subscribers[sub] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	return sub.events, func() {
+		s.mu.Lock()
+		delete(s.subscribers, sub)
+		s.mu.Unlock()
+	}
+}
+
+// Write sends e to all subscribers of its torrent.
+func (s *Stream) Write(e *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.subscribers {
+		if len(sub.torrents) > 0 && !sub.torrents.Has(e.Torrent) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			// Subscriber is too slow, drop the event.
+		}
+	}
+	return nil
+}
+
+// Close disconnects all subscribers.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		s.subscribers = make(map[*subscriber]struct{})
+		close(s.done)
+	}
+	return nil
+}
+
+// ServeHTTP streams newline delimited JSON events until the client disconnects.
+// Events may be limited to a comma separated list of info hashes with the
+// "torrent" query parameter.
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	var torrents []string
+	if q := r.URL.Query().Get("torrent"); q != "" {
+		torrents = strings.Split(q, ",")
+	}
+	events, unsubscribe := s.Subscribe(torrents...)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case e := <-events:
+			line, err := marshalLine(e)
+			if err != nil {
+				log.Errorf("Error serializing network event: %s", err)
+				continue
+			}
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+			// Write any other pending events before flushing.
+			for n := len(events); n > 0; n-- {
+				if line, err := marshalLine(<-events); err == nil {
+					w.Write(line)
+				}
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
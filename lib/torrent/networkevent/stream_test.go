@@ -0,0 +1,111 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package networkevent
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func numSubscribers(s *Stream) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subscribers)
+}
+
+func TestStreamServesEventsOfSubscribedTorrents(t *testing.T) {
+	require := require.New(t)
+
+	s := NewStream(StreamConfig{})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+	peer := core.PeerIDFixture()
+
+	resp, err := http.Get(ts.URL + "?torrent=" + h1.String())
+	require.NoError(err)
+	defer resp.Body.Close()
+	require.Equal("application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		return numSubscribers(s) == 1
+	}))
+
+	expected := []*Event{
+		TorrentCompleteEvent(h1, peer),
+		ReceivePieceEvent(h1, peer, core.PeerIDFixture(), 1),
+	}
+	require.NoError(s.Write(expected[0]))
+	require.NoError(s.Write(TorrentCompleteEvent(h2, peer)))
+	require.NoError(s.Write(expected[1]))
+
+	var results []*Event
+	sc := bufio.NewScanner(resp.Body)
+	for len(results) < len(expected) && sc.Scan() {
+		e := new(Event)
+		require.NoError(json.Unmarshal(sc.Bytes(), e))
+		results = append(results, e)
+	}
+	require.Equal(StripTimestamps(expected), StripTimestamps(results))
+
+	// Closing the stream ends the response.
+	require.NoError(s.Close())
+	require.False(sc.Scan())
+}
+
+func TestStreamUnsubscribesDisconnectedClients(t *testing.T) {
+	require := require.New(t)
+
+	s := NewStream(StreamConfig{})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	require.NoError(err)
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		return numSubscribers(s) == 1
+	}))
+
+	resp.Body.Close()
+
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		require.NoError(s.Write(TorrentCompleteEvent(core.InfoHashFixture(), core.PeerIDFixture())))
+		return numSubscribers(s) == 0
+	}))
+}
+
+func TestStreamDropsEventsForSlowSubscribers(t *testing.T) {
+	require := require.New(t)
+
+	s := NewStream(StreamConfig{ClientBufferSize: 2})
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(s.Write(TorrentCompleteEvent(core.InfoHashFixture(), core.PeerIDFixture())))
+	}
+	require.Len(events, 2)
+}
@@ -14,10 +14,12 @@
 package announcer
 
 import (
+	"context"
 	"time"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/tracker/announceclient"
+	"github.com/uber/kraken/utils/syncutil"
 
 	"github.com/andres-erbsen/clock"
 	"go.uber.org/atomic"
@@ -53,18 +55,29 @@ type Announcer struct {
 	events   Events
 	interval *atomic.Int64
 	timer    *clock.Timer
+	activity *syncutil.Activity
 	logger   *zap.SugaredLogger
 }
 
+// Option overrides Announcer defaults.
+type Option func(*Announcer)
+
+// WithActivity marks each tick of the announce timer as done in act once the
+// AnnounceTick event has been emitted and the timer reset.
+func WithActivity(act *syncutil.Activity) Option {
+	return func(a *Announcer) { a.activity = act }
+}
+
 // New creates a new Announcer.
 func New(
 	config Config,
 	client announceclient.Client,
 	events Events,
 	clk clock.Clock,
-	logger *zap.SugaredLogger) *Announcer {
+	logger *zap.SugaredLogger,
+	options ...Option) *Announcer {
 	config = config.applyDefaults()
-	return &Announcer{
+	a := &Announcer{
 		config:   config,
 		client:   client,
 		events:   events,
@@ -72,6 +85,10 @@ func New(
 		timer:    clk.Timer(config.DefaultInterval),
 		logger:   logger,
 	}
+	for _, opt := range options {
+		opt(a)
+	}
+	return a
 }
 
 // Default creates a default Announcer.
@@ -80,19 +97,81 @@ func Default(
 	client announceclient.Client,
 	events Events,
 	clk clock.Clock,
-	logger *zap.SugaredLogger) *Announcer {
-	return New(Config{}, client, events, clk, logger)
+	logger *zap.SugaredLogger,
+	options ...Option) *Announcer {
+	return New(Config{}, client, events, clk, logger, options...)
 }
 
-// Announce announces through the underlying client and returns the resulting
-// peer handout. Updates the announce interval if it has changed.
+// Announce announces through the underlying client, reporting the given peer
+// reputations, and returns the resulting peer handout. Updates the announce
+// interval if it has changed.
 func (a *Announcer) Announce(
-	d core.Digest, h core.InfoHash, complete bool) ([]*core.PeerInfo, error) {
+	d core.Digest,
+	h core.InfoHash,
+	complete bool,
+	reputations ...core.PeerReputation) ([]*core.PeerInfo, error) {
+
+	peers, interval, err := a.client.Announce(d, h, complete, announceclient.V2, reputations...)
+	if err != nil {
+		if err == announceclient.ErrRateLimited {
+			// Back off for as long as the tracker requested.
+			a.updateInterval(interval)
+		}
+		return nil, err
+	}
+	a.updateInterval(interval)
+	return peers, nil
+}
+
+// AnnounceBundle announces all torrents of the bundle identified by manifest
+// through the underlying client, and returns the resulting peer handout of each
+// torrent. Updates the announce interval if it has changed.
+func (a *Announcer) AnnounceBundle(
+	manifest core.Digest,
+	torrents []announceclient.AnnounceTorrent,
+	reputations ...core.PeerReputation) (map[core.InfoHash][]*core.PeerInfo, error) {
+
+	return a.handouts(a.client.AnnounceBundle(manifest, torrents, reputations...))
+}
+
+// AnnounceBatch announces many torrents through the underlying client, and
+// returns the resulting peer handout of each torrent. Updates the announce
+// interval if it has changed.
+func (a *Announcer) AnnounceBatch(
+	torrents []announceclient.AnnounceTorrent,
+	reputations ...core.PeerReputation) (map[core.InfoHash][]*core.PeerInfo, error) {
+
+	return a.handouts(a.client.AnnounceBatch(torrents, reputations...))
+}
+
+// handouts updates the announce interval from the result of a bundle or batch
+// announce, and returns its peer handouts.
+func (a *Announcer) handouts(
+	peers map[core.InfoHash][]*core.PeerInfo,
+	interval time.Duration,
+	err error) (map[core.InfoHash][]*core.PeerInfo, error) {
 
-	peers, interval, err := a.client.Announce(d, h, complete, announceclient.V2)
 	if err != nil {
+		if err == announceclient.ErrRateLimited {
+			// Back off for as long as the tracker requested.
+			a.updateInterval(interval)
+		}
 		return nil, err
 	}
+	a.updateInterval(interval)
+	return peers, nil
+}
+
+// StreamPeers opens a single stream of the seeders of all torrents through the
+// underlying client.
+func (a *Announcer) StreamPeers(
+	ctx context.Context,
+	torrents []announceclient.AnnounceTorrent) (<-chan *announceclient.StreamedPeer, error) {
+
+	return a.client.StreamPeers(ctx, torrents)
+}
+
+func (a *Announcer) updateInterval(interval time.Duration) {
 	if interval == 0 {
 		// Protect against unset intervals.
 		interval = a.config.DefaultInterval
@@ -107,7 +186,6 @@ func (a *Announcer) Announce(
 		// Note: updated interval will take effect after next tick.
 		a.logger.Infof("Announce interval updated to %s", interval)
 	}
-	return peers, nil
 }
 
 // Ticker emits AnnounceTick events at the current announce interval, which may be
@@ -118,6 +196,7 @@ func (a *Announcer) Ticker(done <-chan struct{}) {
 		case <-a.timer.C:
 			a.events.AnnounceTick()
 			a.timer.Reset(time.Duration(a.interval.Load()))
+			a.activity.Done()
 		case <-done:
 			return
 		}
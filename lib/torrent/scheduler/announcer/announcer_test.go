@@ -134,3 +134,127 @@ func TestAnnouncerAnnounceErr(t *testing.T) {
 	_, aErr := announcer.Announce(d, hash, false)
 	require.Equal(err, aErr)
 }
+
+func TestAnnouncerAnnounceRateLimitedBacksOff(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newAnnouncerMocks(t)
+	defer cleanup()
+
+	config := Config{DefaultInterval: 5 * time.Second}
+
+	announcer := mocks.newAnnouncer(config)
+
+	go announcer.Ticker(nil)
+
+	mocks.clk.Add(config.DefaultInterval)
+	mocks.events.expectTick(t)
+
+	d := core.DigestFixture()
+	hash := core.InfoHashFixture()
+	retryAfter := 20 * time.Second
+
+	mocks.client.EXPECT().Announce(d, hash, false, announceclient.V2).Return(
+		nil, retryAfter, announceclient.ErrRateLimited)
+
+	_, err := announcer.Announce(d, hash, false)
+	require.Equal(announceclient.ErrRateLimited, err)
+
+	mocks.clk.Add(config.DefaultInterval)
+	mocks.events.expectTick(t)
+
+	// Timer should have been reset to the requested delay now.
+
+	mocks.clk.Add(config.DefaultInterval)
+	mocks.events.expectNoTick(t)
+
+	mocks.clk.Add(retryAfter - config.DefaultInterval)
+	mocks.events.expectTick(t)
+}
+
+func TestAnnouncerAnnounceReportsReputations(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newAnnouncerMocks(t)
+	defer cleanup()
+
+	announcer := mocks.newAnnouncer(Config{})
+
+	d := core.DigestFixture()
+	hash := core.InfoHashFixture()
+	rep := core.PeerReputation{PeerID: core.PeerIDFixture(), Score: 0.1}
+
+	mocks.client.EXPECT().Announce(
+		d, hash, false, announceclient.V2, rep).Return(nil, time.Duration(0), nil)
+
+	_, err := announcer.Announce(d, hash, false, rep)
+	require.NoError(err)
+}
+
+func TestAnnouncerAnnounceBundleUpdatesInterval(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newAnnouncerMocks(t)
+	defer cleanup()
+
+	config := Config{DefaultInterval: 5 * time.Second}
+
+	announcer := mocks.newAnnouncer(config)
+
+	go announcer.Ticker(nil)
+
+	manifest := core.DigestFixture()
+	torrents := []announceclient.AnnounceTorrent{{
+		Digest:   core.DigestFixture(),
+		InfoHash: core.InfoHashFixture(),
+	}}
+	interval := 10 * time.Second
+	peers := map[core.InfoHash][]*core.PeerInfo{
+		torrents[0].InfoHash: {core.PeerInfoFixture()},
+	}
+
+	mocks.client.EXPECT().AnnounceBundle(manifest, torrents).Return(peers, interval, nil)
+
+	result, err := announcer.AnnounceBundle(manifest, torrents)
+	require.NoError(err)
+	require.Equal(peers, result)
+
+	mocks.clk.Add(config.DefaultInterval)
+	mocks.events.expectTick(t)
+
+	// Timer should have been reset to new interval now.
+
+	mocks.clk.Add(config.DefaultInterval)
+	mocks.events.expectNoTick(t)
+
+	mocks.clk.Add(interval - config.DefaultInterval)
+	mocks.events.expectTick(t)
+}
+
+func TestAnnouncerAnnounceBatchReturnsPeers(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newAnnouncerMocks(t)
+	defer cleanup()
+
+	announcer := mocks.newAnnouncer(Config{})
+
+	torrents := []announceclient.AnnounceTorrent{{
+		Digest:   core.DigestFixture(),
+		InfoHash: core.InfoHashFixture(),
+	}, {
+		Digest:   core.DigestFixture(),
+		InfoHash: core.InfoHashFixture(),
+	}}
+	rep := core.PeerReputation{PeerID: core.PeerIDFixture(), Score: 1}
+	peers := map[core.InfoHash][]*core.PeerInfo{
+		torrents[0].InfoHash: {core.PeerInfoFixture()},
+		torrents[1].InfoHash: {core.PeerInfoFixture()},
+	}
+
+	mocks.client.EXPECT().AnnounceBatch(torrents, rep).Return(peers, time.Second, nil)
+
+	result, err := announcer.AnnounceBatch(torrents, rep)
+	require.NoError(err)
+	require.Equal(peers, result)
+}
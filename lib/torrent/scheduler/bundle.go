@@ -0,0 +1,189 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package scheduler
+
+import (
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/tracker/announceclient"
+)
+
+// bundle is a group of torrents, e.g. the layers of an image, which are
+// downloaded as a single unit. Torrents of a bundle share a connection budget
+// and a peer set, and are announced to the tracker together by a single leader
+// torrent. The remaining torrents are parked outside of the announce queue.
+type bundle struct {
+	manifest core.Digest
+
+	// Torrents owned by the bundle. A torrent belongs to at most one bundle, so
+	// torrents claimed by another bundle are not included.
+	hashes []core.InfoHash
+
+	// Number of downloads of the bundle in progress.
+	refs int
+
+	leader    core.InfoHash
+	hasLeader bool
+	parked    map[core.InfoHash]bool
+
+	// Set once the tracker rejects bundle announces, after which all torrents
+	// of the bundle announce individually.
+	unsupported bool
+
+	// Seeders handed out for any torrent of the bundle.
+	seeders map[core.PeerID]*core.PeerInfo
+}
+
+// addSeeders adds the seeders among peers to the shared peer set of b, up to
+// max peers. Agents which completed one torrent of the bundle most likely hold
+// the others too, e.g. since they pulled the same image. Leechers are excluded
+// since they may not have started the other torrents, and origins since they
+// only seed the blobs which hash to them.
+func (b *bundle) addSeeders(peers []*core.PeerInfo, max int) {
+	for _, p := range peers {
+		if len(b.seeders) >= max {
+			return
+		}
+		if p.Origin || !p.Complete {
+			continue
+		}
+		b.seeders[p.PeerID] = p
+	}
+}
+
+// fallbackPeers returns the shared seeders of b as the peers of a torrent whose
+// own handout has no peers other than self.
+func (b *bundle) fallbackPeers(handout []*core.PeerInfo, self core.PeerID) []*core.PeerInfo {
+	for _, p := range handout {
+		if p.PeerID != self {
+			return handout
+		}
+	}
+	peers := make([]*core.PeerInfo, 0, len(b.seeders))
+	for _, p := range b.seeders {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// addBundle registers a download of the bundle identified by manifest. Torrents
+// which already belong to another bundle are left there.
+func (s *state) addBundle(manifest core.Digest, torrents []storage.Torrent) *bundle {
+	b, ok := s.bundles[manifest]
+	if !ok {
+		b = &bundle{
+			manifest: manifest,
+			parked:   make(map[core.InfoHash]bool),
+			seeders:  make(map[core.PeerID]*core.PeerInfo),
+		}
+		for _, t := range torrents {
+			h := t.InfoHash()
+			if _, ok := s.bundleByHash[h]; ok {
+				continue
+			}
+			b.hashes = append(b.hashes, h)
+			s.bundleByHash[h] = b
+		}
+		s.bundles[manifest] = b
+		s.conns.AddGroup(manifest.Hex(), b.hashes)
+	}
+	b.refs++
+	return b
+}
+
+// removeBundle unregisters a download of the bundle identified by manifest.
+// Once no downloads remain, the torrents of the bundle are released back to
+// their individual connection limits and announces.
+func (s *state) removeBundle(manifest core.Digest) {
+	b, ok := s.bundles[manifest]
+	if !ok {
+		return
+	}
+	b.refs--
+	if b.refs > 0 {
+		return
+	}
+	s.unparkBundle(b)
+	for _, h := range b.hashes {
+		delete(s.bundleByHash, h)
+	}
+	delete(s.bundles, manifest)
+	s.conns.RemoveGroup(manifest.Hex())
+}
+
+// unparkBundle adds all parked torrents of b to the announce queue.
+func (s *state) unparkBundle(b *bundle) {
+	for h := range b.parked {
+		delete(b.parked, h)
+		if ctrl, ok := s.torrentControls[h]; ok && !ctrl.dispatcher.Complete() {
+			s.announceQueue.Add(h)
+		}
+	}
+}
+
+// addToAnnounceQueue adds h to the announce queue, unless h belongs to a bundle
+// which already has a leader, in which case h is parked.
+func (s *state) addToAnnounceQueue(h core.InfoHash) {
+	if b, ok := s.bundleByHash[h]; ok && !b.unsupported {
+		if b.hasLeader {
+			b.parked[h] = true
+			return
+		}
+		b.leader = h
+		b.hasLeader = true
+	}
+	s.announceQueue.Add(h)
+}
+
+// ejectFromAnnounceQueue ejects h from the announce queue. If h leads a bundle,
+// the next open torrent of the bundle is promoted to leader.
+func (s *state) ejectFromAnnounceQueue(h core.InfoHash) {
+	s.announceQueue.Eject(h)
+
+	b, ok := s.bundleByHash[h]
+	if !ok {
+		return
+	}
+	delete(b.parked, h)
+	if !b.hasLeader || b.leader != h {
+		return
+	}
+	b.hasLeader = false
+	for next := range b.parked {
+		delete(b.parked, next)
+		if ctrl, ok := s.torrentControls[next]; ok && !ctrl.dispatcher.Complete() {
+			b.leader = next
+			b.hasLeader = true
+			s.announceQueue.Add(next)
+			return
+		}
+	}
+}
+
+// announceBundle asynchronously announces all incomplete torrents of b.
+func (s *state) announceBundle(b *bundle) {
+	var torrents []announceclient.AnnounceTorrent
+	for _, h := range b.hashes {
+		ctrl, ok := s.torrentControls[h]
+		if !ok || ctrl.dispatcher.Complete() {
+			continue
+		}
+		torrents = append(torrents, announceclient.AnnounceTorrent{
+			Digest:   ctrl.dispatcher.Digest(),
+			InfoHash: h,
+		})
+	}
+	s.sched.activity.Add(1)
+	go s.sched.announceBundle(b.manifest, b.leader, torrents)
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/kraken/core"
+)
+
+func TestBundleFallbackPeers(t *testing.T) {
+	require := require.New(t)
+
+	b := &bundle{seeders: make(map[core.PeerID]*core.PeerInfo)}
+
+	seeder := core.PeerInfoFixture()
+	seeder.Complete = true
+	leecher := core.PeerInfoFixture()
+	leecher.Complete = false
+	origin := core.OriginPeerInfoFixture()
+
+	b.addSeeders([]*core.PeerInfo{seeder, leecher, origin}, 10)
+
+	self := core.PeerInfoFixture()
+
+	// Torrents with peers of their own do not use the shared seeders.
+	handout := []*core.PeerInfo{self, leecher}
+	require.Equal(handout, b.fallbackPeers(handout, self.PeerID))
+
+	require.Equal(
+		[]*core.PeerInfo{seeder}, b.fallbackPeers([]*core.PeerInfo{self}, self.PeerID))
+	require.Equal([]*core.PeerInfo{seeder}, b.fallbackPeers(nil, self.PeerID))
+}
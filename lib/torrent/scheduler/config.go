@@ -19,6 +19,7 @@ import (
 	"github.com/uber/kraken/lib/torrent/scheduler/conn"
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
 	"github.com/uber/kraken/lib/torrent/scheduler/dispatch"
+	"github.com/uber/kraken/lib/torrent/scheduler/reputation"
 	"github.com/uber/kraken/utils/log"
 )
 
@@ -54,12 +55,43 @@ type Config struct {
 
 	ProbeTimeout time.Duration `yaml:"probe_timeout"`
 
+	// ProgressInterval is the interval at which download progress is reported
+	// to callers which subscribed to progress.
+	ProgressInterval time.Duration `yaml:"progress_interval"`
+
+	// MaxBundlePeers is the maximum number of peers shared between the torrents
+	// of a bundle.
+	MaxBundlePeers int `yaml:"max_bundle_peers"`
+
+	// BundleMetaInfoConcurrency is the maximum number of metainfos fetched at
+	// once when a bundle is downloaded.
+	BundleMetaInfoConcurrency int `yaml:"bundle_metainfo_concurrency"`
+
+	// AnnounceBatchSize is the maximum number of due torrents announced together
+	// in a single batch announce on each announce tick. Falls back to announcing
+	// a single torrent per tick if the tracker does not support batches.
+	AnnounceBatchSize int `yaml:"announce_batch_size"`
+
+	// DisablePeerStreams disables streaming seeders of incomplete torrents from
+	// the tracker as they announce, leaving announces as the only source of
+	// peers.
+	DisablePeerStreams bool `yaml:"disable_peer_streams"`
+
+	// ActiveUploadWindow is the duration since a piece was last sent over a
+	// conn within which the conn is considered to be actively uploading. Used
+	// for reporting load to the tracker.
+	ActiveUploadWindow time.Duration `yaml:"active_upload_window"`
+
+	Priority PriorityConfig `yaml:"priority"`
+
 	ConnState connstate.Config `yaml:"connstate"`
 
 	Conn conn.Config `yaml:"conn"`
 
 	Dispatch dispatch.Config `yaml:"dispatch"`
 
+	Reputation reputation.Config `yaml:"reputation"`
+
 	TorrentLog log.Config `yaml:"torrentlog"`
 	Log        log.Config `yaml:"log"`
 }
@@ -86,5 +118,54 @@ func (c Config) applyDefaults() Config {
 	if c.ProbeTimeout == 0 {
 		c.ProbeTimeout = 3 * time.Second
 	}
+	if c.ProgressInterval == 0 {
+		c.ProgressInterval = time.Second
+	}
+	if c.MaxBundlePeers == 0 {
+		c.MaxBundlePeers = 100
+	}
+	if c.BundleMetaInfoConcurrency == 0 {
+		c.BundleMetaInfoConcurrency = 8
+	}
+	if c.AnnounceBatchSize == 0 {
+		c.AnnounceBatchSize = 20
+	}
+	if c.ActiveUploadWindow == 0 {
+		c.ActiveUploadWindow = 10 * time.Second
+	}
+	c.Priority = c.Priority.applyDefaults()
+	return c
+}
+
+// PriorityConfig defines how torrents share resources by priority. Each weight
+// scales a torrent's connection limit and the number of piece requests it
+// pipelines to each peer.
+type PriorityConfig struct {
+	LowWeight    float64 `yaml:"low_weight"`
+	NormalWeight float64 `yaml:"normal_weight"`
+	HighWeight   float64 `yaml:"high_weight"`
+}
+
+func (c PriorityConfig) applyDefaults() PriorityConfig {
+	if c.LowWeight == 0 {
+		c.LowWeight = 0.5
+	}
+	if c.NormalWeight == 0 {
+		c.NormalWeight = 1
+	}
+	if c.HighWeight == 0 {
+		c.HighWeight = 2
+	}
 	return c
 }
+
+func (c PriorityConfig) weight(p Priority) float64 {
+	switch p {
+	case PriorityLow:
+		return c.LowWeight
+	case PriorityHigh:
+		return c.HighWeight
+	default:
+		return c.NormalWeight
+	}
+}
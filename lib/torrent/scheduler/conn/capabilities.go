@@ -0,0 +1,177 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Protocol versions. Peers which predate protocol versioning leave the version
+// field of their handshake empty, which is interpreted as ProtocolVersionLegacy.
+const (
+	// ProtocolVersionLegacy is the original p2p protocol, which supports no
+	// optional capabilities.
+	ProtocolVersionLegacy = 1
+
+	// ProtocolVersionCapabilities adds capability negotiation to the handshake.
+	ProtocolVersionCapabilities = 2
+
+	// ProtocolVersion is the protocol version spoken by this peer.
+	ProtocolVersion = ProtocolVersionCapabilities
+)
+
+// Capability identifies an optional p2p protocol feature. Capabilities are
+// exchanged as strings so peers can safely ignore capabilities introduced by
+// newer versions of the protocol.
+type Capability string
+
+// Known capabilities.
+const (
+	// CapabilityBlockRequest allows requesting chunks of a piece.
+	CapabilityBlockRequest Capability = "block_request"
+
+	// CapabilityCancel allows cancelling in-flight piece requests.
+	CapabilityCancel Capability = "cancel"
+
+	// CapabilityCompression allows compressed piece payloads.
+	CapabilityCompression Capability = "compression"
+
+	// CapabilityPEX allows exchanging peers over established connections.
+	CapabilityPEX Capability = "pex"
+)
+
+// supportedCapabilities are the capabilities implemented by this peer. A
+// capability must only be added here once both sides of it are implemented,
+// since it may be negotiated with any other peer in the fleet.
+var supportedCapabilities = NewCapabilities(CapabilityCompression)
+
+// Capabilities is a set of capabilities.
+type Capabilities map[Capability]struct{}
+
+// NewCapabilities creates a new Capabilities set.
+func NewCapabilities(cs ...Capability) Capabilities {
+	s := make(Capabilities)
+	for _, c := range cs {
+		s[c] = struct{}{}
+	}
+	return s
+}
+
+// Has returns true if c is in s.
+func (s Capabilities) Has(c Capability) bool {
+	_, ok := s[c]
+	return ok
+}
+
+// Intersect returns the capabilities which are in both s and o.
+func (s Capabilities) Intersect(o Capabilities) Capabilities {
+	r := make(Capabilities)
+	for c := range s {
+		if o.Has(c) {
+			r[c] = struct{}{}
+		}
+	}
+	return r
+}
+
+// Without returns a copy of s which excludes cs.
+func (s Capabilities) Without(cs ...Capability) Capabilities {
+	r := make(Capabilities)
+	for c := range s {
+		r[c] = struct{}{}
+	}
+	for _, c := range cs {
+		delete(r, c)
+	}
+	return r
+}
+
+// List returns the capabilities in s in sorted order.
+func (s Capabilities) List() []Capability {
+	l := make([]Capability, 0, len(s))
+	for c := range s {
+		l = append(l, c)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	return l
+}
+
+func (s Capabilities) String() string {
+	var b strings.Builder
+	for i, c := range s.List() {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(string(c))
+	}
+	return b.String()
+}
+
+func (s Capabilities) toStrings() []string {
+	var l []string
+	for _, c := range s.List() {
+		l = append(l, string(c))
+	}
+	return l
+}
+
+func capabilitiesFromStrings(l []string) Capabilities {
+	s := make(Capabilities)
+	for _, c := range l {
+		s[Capability(c)] = struct{}{}
+	}
+	return s
+}
+
+func formatProtocolVersion(v int) string {
+	return strconv.Itoa(v)
+}
+
+func parseProtocolVersion(s string) (int, error) {
+	if s == "" {
+		return ProtocolVersionLegacy, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid protocol version %q", s)
+	}
+	if v < ProtocolVersionLegacy {
+		return 0, fmt.Errorf("invalid protocol version %d", v)
+	}
+	return v, nil
+}
+
+// negotiated describes the protocol features both sides of a connection agreed
+// to use.
+type negotiated struct {
+	version      int
+	capabilities Capabilities
+}
+
+// negotiate determines the protocol version and capabilities used on a
+// connection. Peers always downgrade to the lower of both protocol versions,
+// and legacy peers never negotiate any capabilities.
+func negotiate(local, remote *handshake) negotiated {
+	v := local.version
+	if remote.version < v {
+		v = remote.version
+	}
+	if v < ProtocolVersionCapabilities {
+		return negotiated{v, NewCapabilities()}
+	}
+	return negotiated{v, local.capabilities.Intersect(remote.capabilities)}
+}
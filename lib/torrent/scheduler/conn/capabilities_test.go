@@ -0,0 +1,382 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/gen/go/proto/p2p"
+	"github.com/uber/kraken/lib/torrent/storage"
+)
+
+// legacyHandshakeMessage returns a bitfield message encoded exactly as peers
+// which predate protocol versioning encode it.
+func legacyHandshakeMessage(peerID core.PeerID, info *storage.TorrentInfo) *p2p.Message {
+	b, err := info.Bitfield().MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	return &p2p.Message{
+		Type: p2p.Message_BITFIELD,
+		Bitfield: &p2p.BitfieldMessage{
+			PeerID:              peerID.String(),
+			Name:                info.Digest().Hex(),
+			InfoHash:            info.InfoHash().String(),
+			BitfieldBytes:       b,
+			RemoteBitfieldBytes: map[string][]byte{},
+		},
+	}
+}
+
+func TestCapabilitiesIntersect(t *testing.T) {
+	require := require.New(t)
+
+	a := NewCapabilities(CapabilityCancel, CapabilityCompression, CapabilityPEX)
+	b := NewCapabilities(CapabilityCompression, CapabilityPEX, CapabilityBlockRequest)
+
+	require.Equal(
+		[]Capability{CapabilityCompression, CapabilityPEX}, a.Intersect(b).List())
+	require.Equal("compression,pex", a.Intersect(b).String())
+	require.Equal([]Capability{CapabilityCancel}, a.Without(CapabilityCompression, CapabilityPEX).List())
+}
+
+func TestParseProtocolVersion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+		err      bool
+	}{
+		{"", ProtocolVersionLegacy, false},
+		{"1", 1, false},
+		{"2", 2, false},
+		{"10", 10, false},
+		{"0", 0, true},
+		{"-1", 0, true},
+		{"v2", 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			require := require.New(t)
+
+			v, err := parseProtocolVersion(test.input)
+			if test.err {
+				require.Error(err)
+			} else {
+				require.NoError(err)
+				require.Equal(test.expected, v)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	all := NewCapabilities(
+		CapabilityBlockRequest, CapabilityCancel, CapabilityCompression, CapabilityPEX)
+
+	tests := []struct {
+		desc                 string
+		local                *handshake
+		remote               *handshake
+		expectedVersion      int
+		expectedCapabilities Capabilities
+	}{
+		{
+			"legacy local and legacy remote",
+			&handshake{version: ProtocolVersionLegacy, capabilities: NewCapabilities()},
+			&handshake{version: ProtocolVersionLegacy, capabilities: NewCapabilities()},
+			ProtocolVersionLegacy,
+			NewCapabilities(),
+		}, {
+			"new local and legacy remote",
+			&handshake{version: ProtocolVersion, capabilities: all},
+			&handshake{version: ProtocolVersionLegacy, capabilities: NewCapabilities()},
+			ProtocolVersionLegacy,
+			NewCapabilities(),
+		}, {
+			"legacy local and new remote",
+			&handshake{version: ProtocolVersionLegacy, capabilities: NewCapabilities()},
+			&handshake{version: ProtocolVersion, capabilities: all},
+			ProtocolVersionLegacy,
+			NewCapabilities(),
+		}, {
+			"new local and new remote",
+			&handshake{version: ProtocolVersion, capabilities: all},
+			&handshake{version: ProtocolVersion, capabilities: NewCapabilities(CapabilityCancel)},
+			ProtocolVersion,
+			NewCapabilities(CapabilityCancel),
+		}, {
+			"new local and future remote with unknown capabilities",
+			&handshake{version: ProtocolVersion, capabilities: NewCapabilities(CapabilityPEX)},
+			&handshake{
+				version:      ProtocolVersion + 1,
+				capabilities: NewCapabilities(CapabilityPEX, Capability("teleport")),
+			},
+			ProtocolVersion,
+			NewCapabilities(CapabilityPEX),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			n := negotiate(test.local, test.remote)
+			require.Equal(test.expectedVersion, n.version)
+			require.Equal(test.expectedCapabilities, n.capabilities)
+
+			// Negotiation must be symmetric so both sides agree.
+			r := negotiate(test.remote, test.local)
+			require.Equal(n, r)
+		})
+	}
+}
+
+func TestHandshakeLegacyEncoding(t *testing.T) {
+	require := require.New(t)
+
+	info := storage.TorrentInfoFixture(4, 1)
+	hs := &handshake{
+		peerID:          core.PeerIDFixture(),
+		digest:          info.Digest(),
+		infoHash:        info.InfoHash(),
+		bitfield:        info.Bitfield(),
+		remoteBitfields: make(RemoteBitfields),
+		version:         ProtocolVersionLegacy,
+		capabilities:    NewCapabilities(CapabilityCancel),
+	}
+	msg, err := hs.toP2PMessage()
+	require.NoError(err)
+	require.Equal(legacyHandshakeMessage(hs.peerID, info), msg)
+}
+
+func TestHandshakeIgnoresCapabilitiesFromLegacyVersion(t *testing.T) {
+	require := require.New(t)
+
+	info := storage.TorrentInfoFixture(4, 1)
+	msg := legacyHandshakeMessage(core.PeerIDFixture(), info)
+	msg.Bitfield.Capabilities = []string{string(CapabilityCancel)}
+
+	hs, err := handshakeFromP2PMessage(msg)
+	require.NoError(err)
+	require.Equal(ProtocolVersionLegacy, hs.version)
+	require.Empty(hs.capabilities)
+}
+
+func TestHandshakeRejectsInvalidVersion(t *testing.T) {
+	require := require.New(t)
+
+	msg := legacyHandshakeMessage(core.PeerIDFixture(), storage.TorrentInfoFixture(4, 1))
+	msg.Version = "bogus"
+
+	_, err := handshakeFromP2PMessage(msg)
+	require.Error(err)
+}
+
+// legacyPeer speaks the handshake protocol of peers which predate protocol
+// versioning, using raw sockets.
+type legacyPeer struct {
+	id core.PeerID
+}
+
+func (p *legacyPeer) accept(nc net.Conn, info *storage.TorrentInfo) (*p2p.Message, error) {
+	req, err := readMessageWithTimeout(nc, ConfigFixture().HandshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	resp := legacyHandshakeMessage(p.id, info)
+	if err := sendMessageWithTimeout(nc, resp, ConfigFixture().HandshakeTimeout); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (p *legacyPeer) initialize(addr string, info *storage.TorrentInfo) (*p2p.Message, error) {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	req := legacyHandshakeMessage(p.id, info)
+	if err := sendMessageWithTimeout(nc, req, ConfigFixture().HandshakeTimeout); err != nil {
+		return nil, err
+	}
+	return readMessageWithTimeout(nc, ConfigFixture().HandshakeTimeout)
+}
+
+func TestHandshakerCompatibilityNewInitializerLegacyAcceptor(t *testing.T) {
+	require := require.New(t)
+
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	defer l.Close()
+
+	info := storage.TorrentInfoFixture(4, 1)
+	h := HandshakerFixture(ConfigFixture())
+	h.capabilities = NewCapabilities(CapabilityCancel)
+	lp := &legacyPeer{core.PeerIDFixture()}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		nc, err := l.Accept()
+		require.NoError(err)
+		req, err := lp.accept(nc, info)
+		require.NoError(err)
+
+		// Legacy peers ignore the new fields, but they must still be present.
+		require.Equal(formatProtocolVersion(ProtocolVersion), req.Version)
+		require.Equal([]string{string(CapabilityCancel)}, req.Bitfield.Capabilities)
+	}()
+
+	r, err := h.Initialize(lp.id, l.Addr().String(), info, make(RemoteBitfields), "")
+	require.NoError(err)
+	require.Equal(ProtocolVersionLegacy, r.Conn.ProtocolVersion())
+	require.Empty(r.Conn.Capabilities())
+
+	wg.Wait()
+}
+
+func TestHandshakerCompatibilityLegacyInitializerNewAcceptor(t *testing.T) {
+	require := require.New(t)
+
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(err)
+	defer l.Close()
+
+	info := storage.TorrentInfoFixture(4, 1)
+	h := HandshakerFixture(ConfigFixture())
+	h.capabilities = NewCapabilities(CapabilityCancel)
+	lp := &legacyPeer{core.PeerIDFixture()}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		nc, err := l.Accept()
+		require.NoError(err)
+		pc, err := h.Accept(nc)
+		require.NoError(err)
+		require.Equal(ProtocolVersionLegacy, pc.ProtocolVersion())
+		require.Empty(pc.Capabilities())
+
+		c, err := h.Establish(pc, info, make(RemoteBitfields), "")
+		require.NoError(err)
+		require.Equal(ProtocolVersionLegacy, c.ProtocolVersion())
+		require.Empty(c.Capabilities())
+	}()
+
+	resp, err := lp.initialize(l.Addr().String(), info)
+	require.NoError(err)
+
+	// The legacy peer must be able to parse the response as before.
+	hs, err := handshakeFromP2PMessage(resp)
+	require.NoError(err)
+	require.Equal(h.peerID, hs.peerID)
+	require.Equal(info.InfoHash(), hs.infoHash)
+
+	wg.Wait()
+}
+
+func TestHandshakerCompatibilityNewPeers(t *testing.T) {
+	tests := []struct {
+		desc                 string
+		initializer          Capabilities
+		acceptor             Capabilities
+		expectedCapabilities Capabilities
+	}{
+		{
+			"no capabilities",
+			NewCapabilities(),
+			NewCapabilities(),
+			NewCapabilities(),
+		}, {
+			"same capabilities",
+			NewCapabilities(CapabilityCancel, CapabilityPEX),
+			NewCapabilities(CapabilityCancel, CapabilityPEX),
+			NewCapabilities(CapabilityCancel, CapabilityPEX),
+		}, {
+			"initializer supports more",
+			NewCapabilities(CapabilityCancel, CapabilityPEX),
+			NewCapabilities(CapabilityPEX),
+			NewCapabilities(CapabilityPEX),
+		}, {
+			"acceptor supports more",
+			NewCapabilities(CapabilityCompression),
+			NewCapabilities(CapabilityCompression, CapabilityBlockRequest),
+			NewCapabilities(CapabilityCompression),
+		}, {
+			"disjoint capabilities",
+			NewCapabilities(CapabilityCompression),
+			NewCapabilities(CapabilityBlockRequest),
+			NewCapabilities(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := net.Listen("tcp", "localhost:0")
+			require.NoError(err)
+			defer l.Close()
+
+			info := storage.TorrentInfoFixture(4, 1)
+			h1 := HandshakerFixture(ConfigFixture())
+			h1.capabilities = test.acceptor
+			h2 := HandshakerFixture(ConfigFixture())
+			h2.capabilities = test.initializer
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				nc, err := l.Accept()
+				require.NoError(err)
+				pc, err := h1.Accept(nc)
+				require.NoError(err)
+				require.Equal(ProtocolVersion, pc.ProtocolVersion())
+				require.Equal(test.initializer, pc.Capabilities())
+
+				c, err := h1.Establish(pc, info, make(RemoteBitfields), "")
+				require.NoError(err)
+				require.Equal(ProtocolVersion, c.ProtocolVersion())
+				require.Equal(test.expectedCapabilities, c.Capabilities())
+			}()
+
+			r, err := h2.Initialize(h1.peerID, l.Addr().String(), info, make(RemoteBitfields), "")
+			require.NoError(err)
+			require.Equal(ProtocolVersion, r.Conn.ProtocolVersion())
+			require.Equal(test.expectedCapabilities, r.Conn.Capabilities())
+
+			wg.Wait()
+		})
+	}
+}
+
+func TestHandshakerDisabledCapabilities(t *testing.T) {
+	require := require.New(t)
+
+	config := ConfigFixture()
+	config.DisabledCapabilities = []Capability{CapabilityCancel}
+	h := HandshakerFixture(config)
+
+	require.False(h.capabilities.Has(CapabilityCancel))
+	require.Equal(supportedCapabilities.Without(CapabilityCancel), h.capabilities)
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/uber/kraken/gen/go/proto/p2p"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+)
+
+// compressor compresses and decompresses piece payloads. It is shared by all
+// Conns created by a Handshaker.
+type compressor struct {
+	config     CompressionConfig
+	namespaces []*regexp.Regexp
+	encoder    *zstd.Encoder
+	decoder    *zstd.Decoder
+}
+
+func newCompressor(config CompressionConfig) (*compressor, error) {
+	var namespaces []*regexp.Regexp
+	for _, ns := range config.Namespaces {
+		re, err := regexp.Compile(ns)
+		if err != nil {
+			return nil, fmt.Errorf("namespace regexp %q: %s", ns, err)
+		}
+		namespaces = append(namespaces, re)
+	}
+	ok, level := zstd.EncoderLevelFromString(config.Level)
+	if !ok {
+		return nil, fmt.Errorf("invalid zstd level %q", config.Level)
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+	if err != nil {
+		return nil, fmt.Errorf("zstd encoder: %s", err)
+	}
+	// Payload lengths are int32, so decoded payloads can never legitimately
+	// exceed math.MaxInt32 bytes.
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(math.MaxInt32))
+	if err != nil {
+		return nil, fmt.Errorf("zstd decoder: %s", err)
+	}
+	return &compressor{config, namespaces, encoder, decoder}, nil
+}
+
+// enabled returns true if payloads for torrents in namespace should be
+// compressed.
+func (c *compressor) enabled(namespace string) bool {
+	for _, re := range c.namespaces {
+		if re.MatchString(namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// compress returns the compressed form of b, or false if compression does not
+// save enough bytes to be worthwhile.
+func (c *compressor) compress(b []byte) ([]byte, bool) {
+	compressed := c.encoder.EncodeAll(b, make([]byte, 0, len(b)))
+	maxLength := float64(len(b)) * (1 - c.config.MinSavings)
+	if float64(len(compressed)) > maxLength {
+		return nil, false
+	}
+	return compressed, true
+}
+
+// decompress decompresses b, which must decompress to exactly length bytes.
+func (c *compressor) decompress(b []byte, length int32) ([]byte, error) {
+	result, err := c.decoder.DecodeAll(b, make([]byte, 0, length))
+	if err != nil {
+		return nil, err
+	}
+	if len(result) != int(length) {
+		return nil, fmt.Errorf(
+			"decompressed length %d does not match payload length %d", len(result), length)
+	}
+	return result, nil
+}
+
+// compressPayload attempts to compress the payload of msg. Returns a new
+// message with a compressed payload if compression was worthwhile, else a
+// copy of msg with its payload buffered in memory. Always closes the payload
+// of msg.
+func (c *Conn) compressPayload(msg *Message) (*Message, error) {
+	defer msg.Payload.Close()
+
+	b, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("read payload: %s", err)
+	}
+	compressed, ok := c.compressor.compress(b)
+	if !ok {
+		c.incompressiblePieces++
+		c.stats.Counter("incompressible_payloads").Inc(1)
+		if c.incompressiblePieces >= c.config.Compression.MaxIncompressiblePieces {
+			c.log().Infof("Disabling compression after %d incompressible pieces", c.incompressiblePieces)
+			c.compressPayloads = false
+		}
+		return &Message{Message: msg.Message, Payload: piecereader.NewBuffer(b)}, nil
+	}
+	c.incompressiblePieces = 0
+	c.stats.Counter("compressed_payloads").Inc(1)
+	c.stats.Counter("compression_saved_bytes").Inc(int64(len(b) - len(compressed)))
+
+	// Copy the message so the caller's message is not modified.
+	m := *msg.Message
+	pp := *m.PiecePayload
+	pp.Compression = p2p.PiecePayloadMessage_ZSTD
+	pp.CompressedLength = int32(len(compressed))
+	m.PiecePayload = &pp
+	return &Message{Message: &m, Payload: piecereader.NewBuffer(compressed)}, nil
+}
+
+// readCompressedPayload reads and decompresses the payload of a compressed
+// piece payload message.
+func (c *Conn) readCompressedPayload(
+	pp *p2p.PiecePayloadMessage) ([]byte, time.Duration, error) {
+
+	if !c.capabilities.Has(CapabilityCompression) {
+		return nil, 0, errors.New("compression capability not negotiated")
+	}
+	if pp.CompressedLength <= 0 || pp.CompressedLength > pp.Length {
+		return nil, 0, fmt.Errorf(
+			"invalid compressed length %d for payload length %d", pp.CompressedLength, pp.Length)
+	}
+	compressed, transferTime, err := c.readPayload(pp.CompressedLength)
+	if err != nil {
+		return nil, 0, err
+	}
+	payload, err := c.compressor.decompress(compressed, pp.Length)
+	if err != nil {
+		return nil, 0, fmt.Errorf("decompress: %s", err)
+	}
+	c.stats.Counter("decompressed_payloads").Inc(1)
+	return payload, transferTime, nil
+}
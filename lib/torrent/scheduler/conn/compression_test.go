@@ -0,0 +1,291 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/gen/go/proto/p2p"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+	"github.com/uber/kraken/utils/randutil"
+)
+
+func compressionConfigFixture(namespaces ...string) Config {
+	config := ConfigFixture()
+	config.Compression.Namespaces = namespaces
+	return config
+}
+
+func counterValue(c *Conn, name string) int64 {
+	v, ok := c.stats.(tally.TestScope).Snapshot().Counters()[name]
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
+
+func receivePayload(t *testing.T, c *Conn) *Message {
+	select {
+	case msg := <-c.Receiver():
+		require.Equal(t, p2p.Message_PIECE_PAYLOAD, msg.Message.Type)
+		return msg
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for piece payload")
+		return nil
+	}
+}
+
+func requirePayload(t *testing.T, expected []byte, msg *Message) {
+	require.Equal(t, int32(len(expected)), msg.Message.PiecePayload.Length)
+	b, err := ioutil.ReadAll(msg.Payload)
+	require.NoError(t, err)
+	require.Equal(t, expected, b)
+}
+
+func TestCompressorSkipsIncompressibleData(t *testing.T) {
+	require := require.New(t)
+
+	c, err := newCompressor(CompressionConfig{}.applyDefaults())
+	require.NoError(err)
+
+	text := bytes.Repeat([]byte("kraken"), 1000)
+	compressed, ok := c.compress(text)
+	require.True(ok)
+	require.True(len(compressed) < len(text))
+
+	result, err := c.decompress(compressed, int32(len(text)))
+	require.NoError(err)
+	require.Equal(text, result)
+
+	_, ok = c.compress(randutil.Blob(4096))
+	require.False(ok)
+}
+
+func TestCompressorDecompressLengthMismatch(t *testing.T) {
+	require := require.New(t)
+
+	c, err := newCompressor(CompressionConfig{}.applyDefaults())
+	require.NoError(err)
+
+	text := bytes.Repeat([]byte("kraken"), 1000)
+	compressed, ok := c.compress(text)
+	require.True(ok)
+
+	_, err = c.decompress(compressed, int32(len(text)-1))
+	require.Error(err)
+}
+
+func TestCompressorInvalidConfig(t *testing.T) {
+	_, err := newCompressor(CompressionConfig{Namespaces: []string{"("}}.applyDefaults())
+	require.Error(t, err)
+
+	_, err = newCompressor(CompressionConfig{Level: "ultra"}.applyDefaults())
+	require.Error(t, err)
+}
+
+func TestCompressorEnabledByNamespace(t *testing.T) {
+	require := require.New(t)
+
+	c, err := newCompressor(CompressionConfig{
+		Namespaces: []string{"^uber-usi/.*"},
+	}.applyDefaults())
+	require.NoError(err)
+
+	require.True(c.enabled("uber-usi/labrat"))
+	require.False(c.enabled("other/labrat"))
+	require.False(c.enabled(""))
+}
+
+func TestConnSendsCompressedPayload(t *testing.T) {
+	require := require.New(t)
+
+	text := bytes.Repeat([]byte("kraken"), 10000)
+	info := storage.TorrentInfoFixture(1, 1)
+
+	local, remote, cleanup := PipeFixture(compressionConfigFixture(".*"), info)
+	defer cleanup()
+
+	require.NoError(local.Send(NewPiecePayloadMessage(0, piecereader.NewBuffer(text))))
+
+	msg := receivePayload(t, remote)
+	require.Equal(p2p.PiecePayloadMessage_ZSTD, msg.Message.PiecePayload.Compression)
+	requirePayload(t, text, msg)
+
+	require.Equal(int64(1), counterValue(local, "compressed_payloads+module=conn"))
+	require.Equal(int64(1), counterValue(remote, "decompressed_payloads+module=conn"))
+
+	// Only the compressed bytes are charged against bandwidth.
+	compressedLength := int64(msg.Message.PiecePayload.CompressedLength)
+	require.True(compressedLength < int64(len(text)))
+	require.Equal(
+		8*compressedLength,
+		counterValue(local, "piece_bandwidth+module=conn,piece_bandwidth_direction=egress"))
+	require.Equal(
+		8*compressedLength,
+		counterValue(remote, "piece_bandwidth+module=conn,piece_bandwidth_direction=ingress"))
+}
+
+func TestConnSkipsCompressionForIncompressiblePayloads(t *testing.T) {
+	require := require.New(t)
+
+	config := compressionConfigFixture(".*")
+	config.Compression.MaxIncompressiblePieces = 2
+	info := storage.TorrentInfoFixture(1, 1)
+
+	local, remote, cleanup := PipeFixture(config, info)
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		blob := randutil.Blob(4096)
+		require.NoError(local.Send(NewPiecePayloadMessage(0, piecereader.NewBuffer(blob))))
+
+		msg := receivePayload(t, remote)
+		require.Equal(p2p.PiecePayloadMessage_NONE, msg.Message.PiecePayload.Compression)
+		requirePayload(t, blob, msg)
+	}
+
+	// After two incompressible pieces, the connection stops attempting
+	// compression.
+	require.Equal(int64(2), counterValue(local, "incompressible_payloads+module=conn"))
+
+	// Even compressible payloads are now sent uncompressed.
+	text := bytes.Repeat([]byte("kraken"), 10000)
+	require.NoError(local.Send(NewPiecePayloadMessage(0, piecereader.NewBuffer(text))))
+	msg := receivePayload(t, remote)
+	require.Equal(p2p.PiecePayloadMessage_NONE, msg.Message.PiecePayload.Compression)
+	requirePayload(t, text, msg)
+}
+
+func TestConnDoesNotCompressUnmatchedNamespace(t *testing.T) {
+	require := require.New(t)
+
+	text := bytes.Repeat([]byte("kraken"), 10000)
+	info := storage.TorrentInfoFixture(1, 1)
+
+	local, remote, cleanup := PipeFixture(compressionConfigFixture("^other$"), info)
+	defer cleanup()
+
+	require.NoError(local.Send(NewPiecePayloadMessage(0, piecereader.NewBuffer(text))))
+
+	msg := receivePayload(t, remote)
+	require.Equal(p2p.PiecePayloadMessage_NONE, msg.Message.PiecePayload.Compression)
+	requirePayload(t, text, msg)
+}
+
+func TestHandshakeCompressionRequiresNegotiation(t *testing.T) {
+	tests := []struct {
+		desc           string
+		localDisabled  []Capability
+		remoteDisabled []Capability
+		expected       p2p.PiecePayloadMessage_Compression
+	}{
+		{"both support compression", nil, nil, p2p.PiecePayloadMessage_ZSTD},
+		{"local disabled", []Capability{CapabilityCompression}, nil, p2p.PiecePayloadMessage_NONE},
+		{"remote disabled", nil, []Capability{CapabilityCompression}, p2p.PiecePayloadMessage_NONE},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			namespace := "uber-usi/labrat"
+			text := bytes.Repeat([]byte("kraken"), 10000)
+			info := storage.TorrentInfoFixture(1, 1)
+
+			localConfig := compressionConfigFixture(namespace)
+			localConfig.DisabledCapabilities = test.localDisabled
+			remoteConfig := compressionConfigFixture(namespace)
+			remoteConfig.DisabledCapabilities = test.remoteDisabled
+
+			localHandshaker := HandshakerFixture(localConfig)
+			remoteHandshaker := HandshakerFixture(remoteConfig)
+
+			l, err := net.Listen("tcp", "localhost:0")
+			require.NoError(err)
+			defer l.Close()
+
+			accepted := make(chan *Conn, 1)
+			go func() {
+				nc, err := l.Accept()
+				if err != nil {
+					return
+				}
+				pc, err := remoteHandshaker.Accept(nc)
+				if err != nil {
+					return
+				}
+				c, err := remoteHandshaker.Establish(pc, info, nil, "")
+				if err != nil {
+					return
+				}
+				accepted <- c
+			}()
+
+			result, err := localHandshaker.Initialize(
+				remoteHandshaker.peerID, l.Addr().String(), info, nil, namespace)
+			require.NoError(err)
+			local := result.Conn
+			remote := <-accepted
+			local.Start()
+			remote.Start()
+			defer local.Close()
+			defer remote.Close()
+
+			// The acceptor uses the namespace sent by the opener.
+			require.NoError(remote.Send(NewPiecePayloadMessage(0, piecereader.NewBuffer(text))))
+
+			msg := receivePayload(t, local)
+			require.Equal(test.expected, msg.Message.PiecePayload.Compression)
+			requirePayload(t, text, msg)
+		})
+	}
+}
+
+func TestConnRejectsCompressedPayloadWithoutCapability(t *testing.T) {
+	require := require.New(t)
+
+	nc1, nc2 := net.Pipe()
+	defer nc1.Close()
+	defer nc2.Close()
+
+	info := storage.TorrentInfoFixture(1, 1)
+	c, err := HandshakerFixture(ConfigFixture()).newConn(
+		noopDeadline{nc1}, core.PeerIDFixture(), info, false,
+		negotiated{ProtocolVersionLegacy, NewCapabilities()}, "", "")
+	require.NoError(err)
+
+	go func() {
+		sendMessage(nc2, &p2p.Message{
+			Type: p2p.Message_PIECE_PAYLOAD,
+			PiecePayload: &p2p.PiecePayloadMessage{
+				Index:            0,
+				Length:           16,
+				Compression:      p2p.PiecePayloadMessage_ZSTD,
+				CompressedLength: 8,
+			},
+		})
+		nc2.Write(make([]byte, 8))
+	}()
+
+	_, err = c.readMessage()
+	require.Error(err)
+}
@@ -23,6 +23,13 @@ import (
 // Config is the configuration for individual live connections.
 type Config struct {
 
+	// Transport is the network transport used for connections between peers.
+	// One of "tcp" or "quic". All peers in a cluster must use the same
+	// transport.
+	Transport string `yaml:"transport"`
+
+	QUIC QUICConfig `yaml:"quic"`
+
 	// HandshakeTimeout is the timeout for dialing, writing, and reading connections
 	// during handshake.
 	HandshakeTimeout time.Duration `yaml:"handshake_timeout"`
@@ -38,9 +45,113 @@ type Config struct {
 	ReceiverBufferSize int `yaml:"receiver_buffer_size"`
 
 	Bandwidth bandwidth.Config `yaml:"bandwidth"`
+
+	// DisableSendfile disables sending piece payloads directly from disk to
+	// the socket, forcing payloads to be copied through user space.
+	DisableSendfile bool `yaml:"disable_sendfile"`
+
+	// SendfileChunkSize is the number of bytes sent per sendfile call when
+	// sending piece payloads from disk. Egress bandwidth is reserved per chunk.
+	SendfileChunkSize uint64 `yaml:"sendfile_chunk_size"`
+
+	// DisabledCapabilities are protocol capabilities which will not be
+	// advertised during handshake, even if supported. Useful for rolling
+	// protocol changes across a fleet.
+	DisabledCapabilities []Capability `yaml:"disabled_capabilities"`
+
+	Compression CompressionConfig `yaml:"compression"`
+
+	// FaultInjection injects faults into connections. Should only be used for
+	// testing and debugging purposes.
+	FaultInjection FaultConfig `yaml:"fault_injection"`
+}
+
+// QUICConfig defines the QUIC transport, which multiplexes all torrents shared
+// with a peer over a single QUIC connection. Peers authenticate each other with
+// the client certificate and CAs of the tls configuration, so the certificate
+// must be valid for both client and server authentication under the tls name.
+type QUICConfig struct {
+
+	// MaxIdleTimeout is the duration after which a QUIC connection with no
+	// network activity is closed.
+	MaxIdleTimeout time.Duration `yaml:"max_idle_timeout"`
+
+	// KeepAlivePeriod is the interval at which keep-alive packets are sent on
+	// idle QUIC connections. Keep-alives are disabled if zero.
+	KeepAlivePeriod time.Duration `yaml:"keep_alive_period"`
+
+	// MaxIncomingStreams is the maximum number of concurrent torrents a peer
+	// may open over a single QUIC connection.
+	MaxIncomingStreams int64 `yaml:"max_incoming_streams"`
+
+	// MaxStreamReceiveWindow is the maximum flow control window of a single
+	// torrent's stream.
+	MaxStreamReceiveWindow uint64 `yaml:"max_stream_receive_window"`
+
+	// MaxConnectionReceiveWindow is the maximum flow control window of a QUIC
+	// connection, shared by all of its streams.
+	MaxConnectionReceiveWindow uint64 `yaml:"max_connection_receive_window"`
+}
+
+func (c QUICConfig) applyDefaults() QUICConfig {
+	if c.MaxIdleTimeout == 0 {
+		c.MaxIdleTimeout = 30 * time.Second
+	}
+	if c.MaxIncomingStreams == 0 {
+		c.MaxIncomingStreams = 1000
+	}
+	if c.MaxStreamReceiveWindow == 0 {
+		c.MaxStreamReceiveWindow = 16 * memsize.MB
+	}
+	if c.MaxConnectionReceiveWindow == 0 {
+		c.MaxConnectionReceiveWindow = 64 * memsize.MB
+	}
+	return c
+}
+
+// CompressionConfig defines compression of piece payloads. Compression is only
+// used on connections where both peers negotiated CapabilityCompression, and
+// is decided by the sender: any peer which supports the capability accepts
+// compressed payloads regardless of its own namespace configuration.
+type CompressionConfig struct {
+
+	// Namespaces is a list of namespace regexps for which piece payloads are
+	// compressed when sending. Compression is disabled if empty.
+	Namespaces []string `yaml:"namespaces"`
+
+	// Level is the zstd encoder level. One of "fastest", "default", "better"
+	// or "best".
+	Level string `yaml:"level"`
+
+	// MinSavings is the minimum fraction of a piece which compression must
+	// save for the compressed payload to be sent. Pieces which compress worse
+	// than this are sent uncompressed.
+	MinSavings float64 `yaml:"min_savings"`
+
+	// MaxIncompressiblePieces is the number of consecutive incompressible
+	// pieces after which a connection stops attempting compression, e.g. for
+	// blobs which are already compressed.
+	MaxIncompressiblePieces int `yaml:"max_incompressible_pieces"`
+}
+
+func (c CompressionConfig) applyDefaults() CompressionConfig {
+	if c.Level == "" {
+		c.Level = "fastest"
+	}
+	if c.MinSavings == 0 {
+		c.MinSavings = 0.1
+	}
+	if c.MaxIncompressiblePieces == 0 {
+		c.MaxIncompressiblePieces = 3
+	}
+	return c
 }
 
 func (c Config) applyDefaults() Config {
+	if c.Transport == "" {
+		c.Transport = TransportTCP
+	}
+	c.QUIC = c.QUIC.applyDefaults()
 	if c.HandshakeTimeout == 0 {
 		c.HandshakeTimeout = 5 * time.Second
 	}
@@ -50,6 +161,10 @@ func (c Config) applyDefaults() Config {
 	if c.ReceiverBufferSize == 0 {
 		c.ReceiverBufferSize = 10000
 	}
+	if c.SendfileChunkSize == 0 {
+		c.SendfileChunkSize = memsize.MB
+	}
+	c.Compression = c.Compression.applyDefaults()
 	if c.Bandwidth.EgressBitsPerSec == 0 {
 		c.Bandwidth.EgressBitsPerSec = 200 * 8 * memsize.Mbit
 	}
@@ -33,6 +33,7 @@ import (
 	"github.com/uber/kraken/lib/torrent/storage/piecereader"
 	"github.com/uber/kraken/utils/bandwidth"
 	"github.com/uber/kraken/utils/memsize"
+	"github.com/uber/kraken/utils/syncutil"
 )
 
 // Maximum support protocol message size. Does not include piece payload.
@@ -49,10 +50,15 @@ type Events interface {
 type Conn struct {
 	peerID      core.PeerID
 	infoHash    core.InfoHash
+	namespace   string
 	createdAt   time.Time
 	localPeerID core.PeerID
 	bandwidth   *bandwidth.Limiter
 
+	// bandwidthNamespace is the namespace whose bandwidth class payloads are
+	// charged to. Unlike namespace, it never comes from the remote peer.
+	bandwidthNamespace string
+
 	events Events
 
 	mu                    sync.Mutex // Protects the following fields:
@@ -68,10 +74,25 @@ type Conn struct {
 	// Marks whether the connection was opened by the remote peer, or the local peer.
 	openedByRemote bool
 
+	// Protocol version and capabilities negotiated during handshake.
+	protocolVersion int
+	capabilities    Capabilities
+
+	compressor *compressor
+
+	// Only accessed by writeLoop: whether piece payloads sent over c are
+	// compressed, and how many consecutive pieces failed to compress.
+	compressPayloads     bool
+	incompressiblePieces int
+
+	// Faults injected into messages sent over c. Nil unless fault injection
+	// is enabled.
+	faults *connFaults
+
 	startOnce sync.Once
 
 	// 向远端 Peer 发送
-	sender   chan *Message
+	sender chan *Message
 	// 从远端 Peer 接收
 	receiver chan *Message
 
@@ -80,6 +101,10 @@ type Conn struct {
 	done   chan struct{}  // Signals to readLoop / writeLoop to exit.
 	wg     sync.WaitGroup // Waits for readLoop / writeLoop to exit.
 
+	// Counts sent and received messages until they are handled. Nil unless
+	// someone waits for the peer to go idle.
+	activity *syncutil.Activity
+
 	logger *zap.SugaredLogger
 }
 
@@ -95,6 +120,12 @@ func newConn(
 	remotePeerID core.PeerID,
 	info *storage.TorrentInfo,
 	openedByRemote bool,
+	n negotiated,
+	namespace string,
+	bandwidthNamespace string,
+	compressor *compressor,
+	faults *faultInjector,
+	activity *syncutil.Activity,
 	logger *zap.SugaredLogger) (*Conn, error) {
 
 	// Clear all deadlines set during handshake. Once a Conn is created, we
@@ -104,23 +135,33 @@ func newConn(
 	}
 
 	c := &Conn{
-		peerID:         remotePeerID,
-		infoHash:       info.InfoHash(),
-		createdAt:      clk.Now(),
-		localPeerID:    localPeerID,
-		bandwidth:      bandwidth,
-		events:         events,
-		nc:             nc,
-		config:         config,
-		clk:            clk,
-		stats:          stats,
-		networkEvents:  networkEvents,
-		openedByRemote: openedByRemote,
-		sender:         make(chan *Message, config.SenderBufferSize),
-		receiver:       make(chan *Message, config.ReceiverBufferSize),
-		closed:         atomic.NewBool(false),
-		done:           make(chan struct{}),
-		logger:         logger,
+		peerID:          remotePeerID,
+		infoHash:        info.InfoHash(),
+		namespace:       namespace,
+		createdAt:       clk.Now(),
+		localPeerID:     localPeerID,
+		bandwidth:       bandwidth,
+		events:          events,
+		nc:              nc,
+		config:          config,
+		clk:             clk,
+		stats:           stats,
+		networkEvents:   networkEvents,
+		openedByRemote:  openedByRemote,
+		protocolVersion: n.version,
+		capabilities:    n.capabilities,
+		compressor:      compressor,
+		compressPayloads: compressor.enabled(namespace) &&
+			n.capabilities.Has(CapabilityCompression),
+		faults:   faults.forPeer(remotePeerID),
+		sender:   make(chan *Message, config.SenderBufferSize),
+		receiver: make(chan *Message, config.ReceiverBufferSize),
+		closed:   atomic.NewBool(false),
+		done:     make(chan struct{}),
+		activity: activity,
+		logger:   logger,
+
+		bandwidthNamespace: bandwidthNamespace,
 	}
 
 	return c, nil
@@ -133,7 +174,7 @@ func newConn(
 func (c *Conn) Start() {
 	c.startOnce.Do(func() {
 		c.wg.Add(2)
-		go c.readLoop()
+		c.activity.Go(c.readLoop)
 		go c.writeLoop()
 	})
 }
@@ -143,12 +184,29 @@ func (c *Conn) PeerID() core.PeerID {
 	return c.peerID
 }
 
+// Namespace returns the namespace of the torrent being transmitted over this
+// connection.
+func (c *Conn) Namespace() string {
+	return c.namespace
+}
+
 // InfoHash returns the info hash for the torrent being transmitted over this
 // connection.
 func (c *Conn) InfoHash() core.InfoHash {
 	return c.infoHash
 }
 
+// ProtocolVersion returns the protocol version negotiated with the remote peer.
+func (c *Conn) ProtocolVersion() int {
+	return c.protocolVersion
+}
+
+// Capabilities returns the capabilities negotiated with the remote peer. Only
+// message types covered by these capabilities may be sent over c.
+func (c *Conn) Capabilities() Capabilities {
+	return c.capabilities
+}
+
 // CreatedAt returns the time at which the Conn was created.
 func (c *Conn) CreatedAt() time.Time {
 	return c.createdAt
@@ -161,12 +219,24 @@ func (c *Conn) String() string {
 
 // Send writes the given message to the underlying connection.
 func (c *Conn) Send(msg *Message) error {
+	c.activity.Add(1)
 	select {
 	case <-c.done:
+		c.activity.Done()
 		return errors.New("conn closed")
 	case c.sender <- msg:
+		if c.activity != nil {
+			// Messages sent while c is closing may be missed by both
+			// writeLoop and Close.
+			select {
+			case <-c.done:
+				c.drainSender()
+			default:
+			}
+		}
 		return nil
 	default:
+		c.activity.Done()
 		// TODO(codyg): Consider a timeout here instead.
 		c.stats.Tagged(map[string]string{
 			"dropped_message_type": msg.Message.Type.String(),
@@ -185,12 +255,29 @@ func (c *Conn) Close() {
 	if !c.closed.CAS(false, true) {
 		return
 	}
-	go func() {
+	c.activity.Go(func() {
 		close(c.done)
 		c.nc.Close()
 		c.wg.Wait()
+		c.drainSender()
 		c.events.ConnClosed(c)
-	}()
+	})
+}
+
+// drainSender marks all messages left unsent in c as done. Only used once c is
+// closed.
+func (c *Conn) drainSender() {
+	if c.activity == nil {
+		return
+	}
+	for {
+		select {
+		case <-c.sender:
+			c.activity.Done()
+		default:
+			return
+		}
+	}
 }
 
 // IsClosed returns true if the c is closed.
@@ -198,17 +285,20 @@ func (c *Conn) IsClosed() bool {
 	return c.closed.Load()
 }
 
-func (c *Conn) readPayload(length int32) ([]byte, error) {
-	if err := c.bandwidth.ReserveIngress(int64(length)); err != nil {
+// readPayload reads a payload of length bytes, and returns the time spent
+// reading it off the socket, excluding the time throttled by ingress limits.
+func (c *Conn) readPayload(length int32) ([]byte, time.Duration, error) {
+	if err := c.bandwidth.ReserveIngressFor(c.bandwidthNamespace, c.infoHash.Hex(), int64(length)); err != nil {
 		c.log().Errorf("Error reserving ingress bandwidth for piece payload: %s", err)
-		return nil, fmt.Errorf("ingress bandwidth: %s", err)
+		return nil, 0, fmt.Errorf("ingress bandwidth: %s", err)
 	}
+	start := c.clk.Now()
 	payload := make([]byte, length)
 	if _, err := io.ReadFull(c.nc, payload); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	c.countBandwidth("ingress", int64(8*length))
-	return payload, nil
+	return payload, c.clk.Now().Sub(start), nil
 }
 
 func (c *Conn) readMessage() (*Message, error) {
@@ -217,10 +307,19 @@ func (c *Conn) readMessage() (*Message, error) {
 		return nil, fmt.Errorf("read message: %s", err)
 	}
 	var pr storage.PieceReader
+	var transferTime time.Duration
 	if p2pMessage.Type == p2p.Message_PIECE_PAYLOAD {
 		// For payload messages, we must read the actual payload to the connection
 		// after reading the message.
-		payload, err := c.readPayload(p2pMessage.PiecePayload.Length)
+		var payload []byte
+		switch p2pMessage.PiecePayload.Compression {
+		case p2p.PiecePayloadMessage_NONE:
+			payload, transferTime, err = c.readPayload(p2pMessage.PiecePayload.Length)
+		case p2p.PiecePayloadMessage_ZSTD:
+			payload, transferTime, err = c.readCompressedPayload(p2pMessage.PiecePayload)
+		default:
+			err = fmt.Errorf("unknown compression %s", p2pMessage.PiecePayload.Compression)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("read payload: %s", err)
 		}
@@ -228,13 +327,15 @@ func (c *Conn) readMessage() (*Message, error) {
 		pr = piecereader.NewBuffer(payload)
 	}
 
-	return &Message{p2pMessage, pr}, nil
+	return &Message{Message: p2pMessage, Payload: pr, TransferTime: transferTime}, nil
 }
 
 // readLoop reads messages off of the underlying connection and sends them to the
 // receiver channel.
 func (c *Conn) readLoop() {
 	defer func() {
+		// Closing the receiver is handled like a message.
+		c.activity.Add(1)
 		close(c.receiver)
 		c.wg.Done()
 		c.Close()
@@ -250,6 +351,7 @@ func (c *Conn) readLoop() {
 				c.log().Infof("Error reading message from socket, exiting read loop: %s", err)
 				return
 			}
+			c.activity.Add(1)
 			c.receiver <- msg
 		}
 	}
@@ -258,7 +360,13 @@ func (c *Conn) readLoop() {
 func (c *Conn) sendPiecePayload(pr storage.PieceReader) error {
 	defer pr.Close()
 
-	if err := c.bandwidth.ReserveEgress(int64(pr.Length())); err != nil {
+	if sent, err := c.sendFilePayload(pr); err != nil {
+		return err
+	} else if sent {
+		return nil
+	}
+
+	if err := c.bandwidth.ReserveEgressFor(c.bandwidthNamespace, c.infoHash.Hex(), int64(pr.Length())); err != nil {
 		// TODO(codyg): This is bad. Consider alerting here.
 		c.log().Errorf("Error reserving egress bandwidth for piece payload: %s", err)
 		return fmt.Errorf("egress bandwidth: %s", err)
@@ -272,12 +380,33 @@ func (c *Conn) sendPiecePayload(pr storage.PieceReader) error {
 }
 
 func (c *Conn) sendMessage(msg *Message) error {
+	var stall bool
+	if c.faults != nil {
+		var err error
+		msg, stall, err = c.injectFaults(msg)
+		if err != nil {
+			return fmt.Errorf("inject faults: %s", err)
+		}
+	}
+	if msg.Message.Type == p2p.Message_PIECE_PAYLOAD && c.compressPayloads {
+		// Compression must happen before the message is written, since the
+		// message carries the compressed length of the payload. Bandwidth is
+		// reserved for the compressed payload only.
+		var err error
+		msg, err = c.compressPayload(msg)
+		if err != nil {
+			return fmt.Errorf("compress payload: %s", err)
+		}
+	}
 	if err := sendMessage(c.nc, msg.Message); err != nil {
 		return fmt.Errorf("send message: %s", err)
 	}
 	if msg.Message.Type == p2p.Message_PIECE_PAYLOAD {
 		// For payload messages, we must write the actual payload to the connection
 		// after writing the message.
+		if stall {
+			return c.stallPiecePayload(msg.Payload)
+		}
 		if err := c.sendPiecePayload(msg.Payload); err != nil {
 			return fmt.Errorf("send piece payload: %s", err)
 		}
@@ -300,8 +429,12 @@ func (c *Conn) writeLoop() {
 		case msg := <-c.sender:
 			if err := c.sendMessage(msg); err != nil {
 				c.log().Infof("Error writing message to socket, exiting write loop: %s", err)
+				// Starts closing c before msg is done.
+				c.Close()
+				c.activity.Done()
 				return
 			}
+			c.activity.Done()
 		}
 	}
 }
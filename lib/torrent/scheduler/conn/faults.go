@@ -0,0 +1,276 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/gen/go/proto/p2p"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+)
+
+// FaultType defines the kinds of faults which may be injected into connections.
+type FaultType string
+
+// Supported fault types.
+const (
+	// FaultLatency delays every message by Latency.
+	FaultLatency FaultType = "latency"
+
+	// FaultThrottle delays piece payloads as if sent at BitsPerSec.
+	FaultThrottle FaultType = "throttle"
+
+	// FaultDrop closes the connection instead of sending a message.
+	FaultDrop FaultType = "drop"
+
+	// FaultCorrupt corrupts a byte of piece payloads.
+	FaultCorrupt FaultType = "corrupt"
+
+	// FaultStall sends half of a piece payload, then stops sending anything
+	// until the connection is closed.
+	FaultStall FaultType = "stall"
+)
+
+var (
+	errFaultDrop  = errors.New("connection dropped by fault injection")
+	errFaultStall = errors.New("connection stalled by fault injection")
+)
+
+// FaultConfig defines faults injected into connections, for testing how peers
+// handle misbehaving remote peers. Faults are injected into the messages a
+// peer sends, i.e. the peer configured with faults is the one misbehaving.
+// Should only be used for testing and debugging purposes.
+type FaultConfig struct {
+	Enable bool `yaml:"enable"`
+
+	// Seed seeds fault probabilities. Seeded from the current time if zero.
+	Seed int64 `yaml:"seed"`
+
+	Faults []Fault `yaml:"faults"`
+}
+
+// Fault defines a single fault.
+type Fault struct {
+	Type FaultType `yaml:"type"`
+
+	// Probability is the chance the fault is injected into each eligible
+	// message. Defaults to 1.
+	Probability float64 `yaml:"probability"`
+
+	// PeerIDs restricts the fault to connections with these remote peers.
+	// Applies to all connections if empty.
+	PeerIDs []string `yaml:"peer_ids"`
+
+	// Latency is the delay added by FaultLatency.
+	Latency time.Duration `yaml:"latency"`
+
+	// BitsPerSec is the rate FaultThrottle limits piece payloads to.
+	BitsPerSec uint64 `yaml:"bits_per_sec"`
+}
+
+type fault struct {
+	Fault
+	peerIDs map[core.PeerID]bool
+}
+
+func newFault(f Fault) (*fault, error) {
+	if f.Probability == 0 {
+		f.Probability = 1
+	}
+	if f.Probability < 0 || f.Probability > 1 {
+		return nil, fmt.Errorf("probability %f must be between 0 and 1", f.Probability)
+	}
+	switch f.Type {
+	case FaultLatency:
+		if f.Latency <= 0 {
+			return nil, errors.New("latency must be positive")
+		}
+	case FaultThrottle:
+		if f.BitsPerSec == 0 {
+			return nil, errors.New("bits_per_sec must be non-zero")
+		}
+	case FaultDrop, FaultCorrupt, FaultStall:
+	default:
+		return nil, fmt.Errorf("unknown fault type %q", f.Type)
+	}
+	var peerIDs map[core.PeerID]bool
+	if len(f.PeerIDs) > 0 {
+		peerIDs = make(map[core.PeerID]bool)
+		for _, s := range f.PeerIDs {
+			id, err := core.NewPeerID(s)
+			if err != nil {
+				return nil, fmt.Errorf("peer id %q: %s", s, err)
+			}
+			peerIDs[id] = true
+		}
+	}
+	return &fault{f, peerIDs}, nil
+}
+
+// faultInjector injects faults into Conns. It is shared by all Conns created
+// by a Handshaker.
+type faultInjector struct {
+	faults []*fault
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newFaultInjector(config FaultConfig) (*faultInjector, error) {
+	if !config.Enable {
+		return nil, nil
+	}
+	var faults []*fault
+	for _, f := range config.Faults {
+		ff, err := newFault(f)
+		if err != nil {
+			return nil, fmt.Errorf("fault %s: %s", f.Type, err)
+		}
+		faults = append(faults, ff)
+	}
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &faultInjector{faults: faults, rand: rand.New(rand.NewSource(seed))}, nil
+}
+
+// forPeer returns the faults which apply to connections with peerID, or nil
+// if there are none.
+func (i *faultInjector) forPeer(peerID core.PeerID) *connFaults {
+	if i == nil {
+		return nil
+	}
+	var faults []*fault
+	for _, f := range i.faults {
+		if f.peerIDs == nil || f.peerIDs[peerID] {
+			faults = append(faults, f)
+		}
+	}
+	if len(faults) == 0 {
+		return nil
+	}
+	return &connFaults{i, faults}
+}
+
+func (i *faultInjector) trigger(f *fault) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.rand.Float64() < f.Probability
+}
+
+func (i *faultInjector) intn(n int) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.rand.Intn(n)
+}
+
+// connFaults are the faults which apply to a single Conn.
+type connFaults struct {
+	injector *faultInjector
+	faults   []*fault
+}
+
+// injectFaults applies faults to msg before it is sent. Returns the message to
+// send in place of msg, and whether its payload should stall.
+func (c *Conn) injectFaults(msg *Message) (*Message, bool, error) {
+	var stall bool
+	isPayload := msg.Message.Type == p2p.Message_PIECE_PAYLOAD
+	for _, f := range c.faults.faults {
+		switch f.Type {
+		case FaultThrottle, FaultCorrupt, FaultStall:
+			if !isPayload {
+				continue
+			}
+		}
+		if !c.faults.injector.trigger(f) {
+			continue
+		}
+		c.stats.Tagged(map[string]string{
+			"fault": string(f.Type),
+		}).Counter("injected_faults").Inc(1)
+
+		switch f.Type {
+		case FaultLatency:
+			if err := c.faultSleep(f.Latency); err != nil {
+				return nil, false, err
+			}
+		case FaultThrottle:
+			d := time.Duration(float64(msg.Payload.Length()) * 8 / float64(f.BitsPerSec) * float64(time.Second))
+			if err := c.faultSleep(d); err != nil {
+				return nil, false, err
+			}
+		case FaultDrop:
+			if isPayload {
+				msg.Payload.Close()
+			}
+			return nil, false, errFaultDrop
+		case FaultCorrupt:
+			var err error
+			msg, err = c.corruptPayload(msg)
+			if err != nil {
+				return nil, false, err
+			}
+		case FaultStall:
+			stall = true
+		}
+	}
+	return msg, stall, nil
+}
+
+// faultSleep sleeps for d, or until c is closed.
+func (c *Conn) faultSleep(d time.Duration) error {
+	select {
+	case <-c.clk.After(d):
+		return nil
+	case <-c.done:
+		return errors.New("conn closed")
+	}
+}
+
+// corruptPayload returns a copy of msg with a single byte of its payload
+// flipped. Always closes the payload of msg.
+func (c *Conn) corruptPayload(msg *Message) (*Message, error) {
+	defer msg.Payload.Close()
+
+	b, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("read payload: %s", err)
+	}
+	if len(b) > 0 {
+		b[c.faults.injector.intn(len(b))] ^= 0xff
+	}
+	return &Message{Message: msg.Message, Payload: piecereader.NewBuffer(b)}, nil
+}
+
+// stallPiecePayload writes the first half of pr, then blocks until c is closed.
+func (c *Conn) stallPiecePayload(pr storage.PieceReader) error {
+	defer pr.Close()
+
+	if _, err := io.CopyN(c.nc, pr, int64(pr.Length()/2)); err != nil {
+		return fmt.Errorf("copy to socket: %s", err)
+	}
+	<-c.done
+	return errFaultStall
+}
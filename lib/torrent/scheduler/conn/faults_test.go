@@ -0,0 +1,192 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+)
+
+func faultConfigFixture(faults ...Fault) Config {
+	config := ConfigFixture()
+	config.FaultInjection = FaultConfig{Enable: true, Seed: 1, Faults: faults}
+	return config
+}
+
+func TestFaultInjectorInvalidConfig(t *testing.T) {
+	for _, f := range []Fault{
+		{Type: "explode"},
+		{Type: FaultDrop, Probability: 2},
+		{Type: FaultLatency},
+		{Type: FaultThrottle},
+		{Type: FaultCorrupt, PeerIDs: []string{"invalid"}},
+	} {
+		_, err := newFaultInjector(FaultConfig{Enable: true, Faults: []Fault{f}})
+		require.Error(t, err)
+	}
+}
+
+func TestFaultInjectorDisabled(t *testing.T) {
+	require := require.New(t)
+
+	i, err := newFaultInjector(FaultConfig{Faults: []Fault{{Type: FaultDrop}}})
+	require.NoError(err)
+	require.Nil(i.forPeer(core.PeerIDFixture()))
+}
+
+func TestFaultInjectorPeerIDs(t *testing.T) {
+	require := require.New(t)
+
+	bad := core.PeerIDFixture()
+
+	i, err := newFaultInjector(FaultConfig{
+		Enable: true,
+		Faults: []Fault{
+			{Type: FaultCorrupt, PeerIDs: []string{bad.String()}},
+			{Type: FaultLatency, Latency: time.Second},
+		},
+	})
+	require.NoError(err)
+
+	require.Len(i.forPeer(bad).faults, 2)
+	require.Len(i.forPeer(core.PeerIDFixture()).faults, 1)
+}
+
+func TestFaultInjectorProbability(t *testing.T) {
+	require := require.New(t)
+
+	i, err := newFaultInjector(FaultConfig{
+		Enable: true,
+		Seed:   1,
+		Faults: []Fault{{Type: FaultDrop, Probability: 0.25}},
+	})
+	require.NoError(err)
+
+	var n int
+	for j := 0; j < 1000; j++ {
+		if i.trigger(i.faults[0]) {
+			n++
+		}
+	}
+	require.InDelta(250, n, 50)
+}
+
+func TestConnFaultCorruptsPayload(t *testing.T) {
+	require := require.New(t)
+
+	text := bytes.Repeat([]byte("kraken"), 1000)
+	info := storage.TorrentInfoFixture(1, 1)
+
+	local, remote, cleanup := PipeFixture(faultConfigFixture(Fault{Type: FaultCorrupt}), info)
+	defer cleanup()
+
+	require.NoError(local.Send(NewPiecePayloadMessage(0, piecereader.NewBuffer(text))))
+
+	msg := receivePayload(t, remote)
+	b, err := ioutil.ReadAll(msg.Payload)
+	require.NoError(err)
+	require.Len(b, len(text))
+
+	var diff int
+	for i := range b {
+		if b[i] != text[i] {
+			diff++
+		}
+	}
+	require.Equal(1, diff)
+	require.Equal(int64(1), counterValue(local, "injected_faults+fault=corrupt,module=conn"))
+}
+
+func TestConnFaultDropClosesConn(t *testing.T) {
+	require := require.New(t)
+
+	info := storage.TorrentInfoFixture(1, 1)
+
+	local, remote, cleanup := PipeFixture(faultConfigFixture(Fault{Type: FaultDrop}), info)
+	defer cleanup()
+
+	require.NoError(local.Send(NewAnnouncePieceMessage(0)))
+
+	select {
+	case _, ok := <-remote.Receiver():
+		require.False(ok)
+	case <-time.After(5 * time.Second):
+		require.FailNow("timed out waiting for remote to close")
+	}
+	require.True(local.IsClosed())
+}
+
+func TestConnFaultStallsPayload(t *testing.T) {
+	require := require.New(t)
+
+	info := storage.TorrentInfoFixture(1, 1)
+
+	local, remote, cleanup := PipeFixture(faultConfigFixture(Fault{Type: FaultStall}), info)
+	defer cleanup()
+
+	require.NoError(local.Send(NewPiecePayloadMessage(0, piecereader.NewBuffer(make([]byte, 1000)))))
+	require.NoError(local.Send(NewAnnouncePieceMessage(0)))
+
+	// Neither the payload nor any later message arrive, but the conn stays open.
+	select {
+	case <-remote.Receiver():
+		require.FailNow("received message from stalled conn")
+	case <-time.After(200 * time.Millisecond):
+	}
+	require.False(local.IsClosed())
+
+	local.Close()
+	select {
+	case _, ok := <-remote.Receiver():
+		require.False(ok)
+	case <-time.After(5 * time.Second):
+		require.FailNow("timed out waiting for remote to close")
+	}
+}
+
+func TestConnFaultDelays(t *testing.T) {
+	tests := []struct {
+		desc     string
+		fault    Fault
+		expected time.Duration
+	}{
+		{"latency", Fault{Type: FaultLatency, Latency: 100 * time.Millisecond}, 100 * time.Millisecond},
+		// 1000 bytes at 80Kbit.
+		{"throttle", Fault{Type: FaultThrottle, BitsPerSec: 80000}, 100 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			text := bytes.Repeat([]byte("k"), 1000)
+			info := storage.TorrentInfoFixture(1, 1)
+
+			local, remote, cleanup := PipeFixture(faultConfigFixture(test.fault), info)
+			defer cleanup()
+
+			start := time.Now()
+			require.NoError(local.Send(NewPiecePayloadMessage(0, piecereader.NewBuffer(text))))
+			requirePayload(t, text, receivePayload(t, remote))
+			require.True(time.Since(start) >= test.expected)
+		})
+	}
+}
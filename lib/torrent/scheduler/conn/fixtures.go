@@ -14,7 +14,15 @@
 package conn
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/andres-erbsen/clock"
@@ -54,15 +62,17 @@ func PipeFixture(
 
 	var err error
 
+	n := negotiated{ProtocolVersion, supportedCapabilities}
+
 	local, err = HandshakerFixture(config).newConn(
-		noopDeadline{nc1}, core.PeerIDFixture(), info, false)
+		noopDeadline{nc1}, core.PeerIDFixture(), info, false, n, "", "")
 	if err != nil {
 		panic(err)
 	}
 	local.Start()
 
 	remote, err = HandshakerFixture(config).newConn(
-		noopDeadline{nc2}, core.PeerIDFixture(), info, true)
+		noopDeadline{nc2}, core.PeerIDFixture(), info, true, n, "", "")
 	if err != nil {
 		panic(err)
 	}
@@ -80,12 +90,17 @@ func Fixture() (*Conn, func()) {
 
 // HandshakerFixture returns a Handshaker for testing.
 func HandshakerFixture(config Config) *Handshaker {
+	transport, err := NewTransport(config, TLSFixture())
+	if err != nil {
+		panic(err)
+	}
 	h, err := NewHandshaker(
 		config,
 		tally.NewTestScope("", nil),
 		clock.New(),
 		networkevent.NewTestProducer(),
 		core.PeerIDFixture(),
+		transport,
 		noopEvents{},
 		zap.NewNop().Sugar())
 	if err != nil {
@@ -98,3 +113,47 @@ func HandshakerFixture(config Config) *Handshaker {
 func ConfigFixture() Config {
 	return Config{}.applyDefaults()
 }
+
+var (
+	_tlsFixtureOnce sync.Once
+	_tlsFixture     *tls.Config
+)
+
+// TLSFixture returns a client TLS config for testing, whose certificate is
+// signed by its only root and valid for both client and server authentication.
+// All calls return the same config, such that peers trust each other.
+func TLSFixture() *tls.Config {
+	_tlsFixtureOnce.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "kraken"},
+			DNSNames:              []string{"kraken"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(24 * time.Hour),
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			panic(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			panic(err)
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(cert)
+		_tlsFixture = &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+			RootCAs:      roots,
+			ServerName:   "kraken",
+		}
+	})
+	return _tlsFixture
+}
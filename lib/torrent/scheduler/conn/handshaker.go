@@ -20,9 +20,11 @@ import (
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/gen/go/proto/p2p"
+	"github.com/uber/kraken/lib/peertoken"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/storage"
 	"github.com/uber/kraken/utils/bandwidth"
+	"github.com/uber/kraken/utils/syncutil"
 
 	"github.com/andres-erbsen/clock"
 	"github.com/uber-go/tally"
@@ -71,6 +73,9 @@ type handshake struct {
 	bitfield        *bitset.BitSet
 	remoteBitfields RemoteBitfields
 	namespace       string
+	version         int
+	capabilities    Capabilities
+	token           string
 }
 
 // toP2PMessage 转换成 bitfield message
@@ -83,7 +88,7 @@ func (h *handshake) toP2PMessage() (*p2p.Message, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &p2p.Message{
+	msg := &p2p.Message{
 		Type: p2p.Message_BITFIELD,
 		Bitfield: &p2p.BitfieldMessage{
 			PeerID:              h.peerID.String(),
@@ -92,8 +97,16 @@ func (h *handshake) toP2PMessage() (*p2p.Message, error) {
 			BitfieldBytes:       b,
 			RemoteBitfieldBytes: rb,
 			Namespace:           h.namespace,
+			Token:               h.token,
 		},
-	}, nil
+	}
+	// Legacy handshakes are encoded exactly as peers which predate protocol
+	// versioning would encode them.
+	if h.version > ProtocolVersionLegacy {
+		msg.Version = formatProtocolVersion(h.version)
+		msg.Bitfield.Capabilities = h.capabilities.toStrings()
+	}
+	return msg, nil
 }
 
 // handshakeFromP2PMessage 从 p2p message 转换成 shake
@@ -125,6 +138,14 @@ func handshakeFromP2PMessage(m *p2p.Message) (*handshake, error) {
 	if err := remoteBitfields.unmarshalBinary(bitfieldMsg.RemoteBitfieldBytes); err != nil {
 		return nil, err
 	}
+	version, err := parseProtocolVersion(m.Version)
+	if err != nil {
+		return nil, fmt.Errorf("version: %s", err)
+	}
+	capabilities := NewCapabilities()
+	if version > ProtocolVersionLegacy {
+		capabilities = capabilitiesFromStrings(bitfieldMsg.Capabilities)
+	}
 
 	return &handshake{
 		peerID:          peerID,
@@ -133,6 +154,9 @@ func handshakeFromP2PMessage(m *p2p.Message) (*handshake, error) {
 		digest:          d,
 		namespace:       bitfieldMsg.Namespace,
 		remoteBitfields: remoteBitfields,
+		version:         version,
+		capabilities:    capabilities,
+		token:           bitfieldMsg.Token,
 	}, nil
 }
 
@@ -173,6 +197,16 @@ func (pc *PendingConn) Namespace() string {
 	return pc.handshake.namespace
 }
 
+// ProtocolVersion returns the protocol version of the remote peer.
+func (pc *PendingConn) ProtocolVersion() int {
+	return pc.handshake.version
+}
+
+// Capabilities returns the capabilities advertised by the remote peer.
+func (pc *PendingConn) Capabilities() Capabilities {
+	return pc.handshake.capabilities
+}
+
 // Close closes the connection.
 func (pc *PendingConn) Close() {
 	pc.nc.Close()
@@ -194,7 +228,33 @@ type Handshaker struct {
 	bandwidth     *bandwidth.Limiter
 	networkEvents networkevent.Producer
 	peerID        core.PeerID
+	transport     Transport
 	events        Events
+	capabilities  Capabilities
+	compressor    *compressor
+	faults        *faultInjector
+	tokens        *peertoken.Issuer
+	verifier      *peertoken.Verifier
+	activity      *syncutil.Activity
+}
+
+// HandshakerOption configures a Handshaker.
+type HandshakerOption func(*Handshaker)
+
+// WithPeerTokens sends tokens issued by i in all handshakes, and rejects
+// handshakes of remote peers whose tokens are not accepted by v.
+func WithPeerTokens(i *peertoken.Issuer, v *peertoken.Verifier) HandshakerOption {
+	return func(h *Handshaker) {
+		h.tokens = i
+		h.verifier = v
+	}
+}
+
+// WithActivity counts the goroutines of Conns and the messages they send and
+// receive as work of a. Received messages are done once handled by whoever
+// reads them off the Conn.
+func WithActivity(a *syncutil.Activity) HandshakerOption {
+	return func(h *Handshaker) { h.activity = a }
 }
 
 // NewHandshaker creates a new Handshaker.
@@ -204,8 +264,10 @@ func NewHandshaker(
 	clk clock.Clock,
 	networkEvents networkevent.Producer,
 	peerID core.PeerID,
+	transport Transport,
 	events Events,
-	logger *zap.SugaredLogger) (*Handshaker, error) {
+	logger *zap.SugaredLogger,
+	options ...HandshakerOption) (*Handshaker, error) {
 
 	config = config.applyDefaults()
 
@@ -213,20 +275,44 @@ func NewHandshaker(
 		"module": "conn",
 	})
 
-	bl, err := bandwidth.NewLimiter(config.Bandwidth, bandwidth.WithLogger(logger))
+	bl, err := bandwidth.NewLimiter(
+		config.Bandwidth, bandwidth.WithLogger(logger), bandwidth.WithStats(stats))
 	if err != nil {
 		return nil, fmt.Errorf("bandwidth: %s", err)
 	}
 
-	return &Handshaker{
+	compressor, err := newCompressor(config.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("compression: %s", err)
+	}
+
+	faults, err := newFaultInjector(config.FaultInjection)
+	if err != nil {
+		return nil, fmt.Errorf("fault injection: %s", err)
+	}
+	if faults != nil {
+		logger.Warnf("Fault injection enabled with %d faults", len(faults.faults))
+	}
+
+	h := &Handshaker{
 		config:        config,
 		stats:         stats,
 		clk:           clk,
 		bandwidth:     bl,
 		networkEvents: networkEvents,
 		peerID:        peerID,
+		transport:     transport,
 		events:        events,
-	}, nil
+		capabilities:  supportedCapabilities.Without(config.DisabledCapabilities...),
+		compressor:    compressor,
+		faults:        faults,
+		tokens:        peertoken.DisabledIssuer(),
+		verifier:      peertoken.DisabledVerifier(),
+	}
+	for _, opt := range options {
+		opt(h)
+	}
+	return h, nil
 }
 
 // Accept upgrades a raw network connection opened by a remote peer into a
@@ -241,19 +327,26 @@ func (h *Handshaker) Accept(nc net.Conn) (*PendingConn, error) {
 }
 
 // Establish upgrades a PendingConn returned via Accept into a fully
-// established Conn.
+// established Conn. Payloads are charged to the bandwidth class of namespace,
+// the namespace of the torrent in local state, rather than the namespace sent
+// by the remote peer, which could otherwise pick its own class. An empty
+// namespace charges the default class.
 // 将 PendingConn（half-opened）升级成全连接状态
 func (h *Handshaker) Establish(
 	pc *PendingConn,
 	info *storage.TorrentInfo,
-	remoteBitfields RemoteBitfields) (*Conn, error) {
+	remoteBitfields RemoteBitfields,
+	namespace string) (*Conn, error) {
 
 	// Namespace is one-directional: it is only supplied by the connection opener
 	// and is not reciprocated by the connection acceptor.
-	if err := h.sendHandshake(pc.nc, info, remoteBitfields, ""); err != nil {
+	local, err := h.sendHandshake(pc.nc, info, remoteBitfields, "")
+	if err != nil {
 		return nil, fmt.Errorf("send handshake: %s", err)
 	}
-	c, err := h.newConn(pc.nc, pc.handshake.peerID, info, true)
+	c, err := h.newConn(
+		pc.nc, pc.handshake.peerID, info, true, negotiate(local, pc.handshake),
+		pc.handshake.namespace, namespace)
 	if err != nil {
 		return nil, fmt.Errorf("new conn: %s", err)
 	}
@@ -271,7 +364,7 @@ func (h *Handshaker) Initialize(
 	remoteBitfields RemoteBitfields,
 	namespace string) (*HandshakeResult, error) {
 
-	nc, err := net.DialTimeout("tcp", addr, h.config.HandshakeTimeout)
+	nc, err := h.transport.Dial(addr, h.config.HandshakeTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("dial: %s", err)
 	}
@@ -284,13 +377,38 @@ func (h *Handshaker) Initialize(
 	return r, nil
 }
 
+// SetBandwidthWeight scales the share of bandwidth which Conns for h receive
+// relative to other torrents in the same namespace class.
+func (h *Handshaker) SetBandwidthWeight(infoHash core.InfoHash, w float64) {
+	h.bandwidth.SetWeight(infoHash.Hex(), w)
+}
+
+// BandwidthSnapshot returns the current bandwidth limits of Conns.
+func (h *Handshaker) BandwidthSnapshot() bandwidth.Snapshot {
+	return h.bandwidth.Snapshot()
+}
+
+// EgressBytes returns the total number of bytes sent over Conns.
+func (h *Handshaker) EgressBytes() int64 {
+	return h.bandwidth.EgressBytes()
+}
+
+// Close stops background work of h. Existing Conns are unaffected.
+func (h *Handshaker) Close() {
+	h.bandwidth.Close()
+}
+
 // 发送握手消息
 func (h *Handshaker) sendHandshake(
 	nc net.Conn,
 	info *storage.TorrentInfo,
 	remoteBitfields RemoteBitfields,
-	namespace string) error {
+	namespace string) (*handshake, error) {
 
+	token, err := h.tokens.Token()
+	if err != nil {
+		return nil, fmt.Errorf("token: %s", err)
+	}
 	hs := &handshake{
 		peerID:          h.peerID,
 		digest:          info.Digest(),
@@ -298,12 +416,18 @@ func (h *Handshaker) sendHandshake(
 		bitfield:        info.Bitfield(),
 		remoteBitfields: remoteBitfields,
 		namespace:       namespace,
+		version:         ProtocolVersion,
+		capabilities:    h.capabilities,
+		token:           token,
 	}
 	msg, err := hs.toP2PMessage()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := sendMessageWithTimeout(nc, msg, h.config.HandshakeTimeout); err != nil {
+		return nil, err
 	}
-	return sendMessageWithTimeout(nc, msg, h.config.HandshakeTimeout)
+	return hs, nil
 }
 
 func (h *Handshaker) readHandshake(nc net.Conn) (*handshake, error) {
@@ -315,6 +439,15 @@ func (h *Handshaker) readHandshake(nc net.Conn) (*handshake, error) {
 	if err != nil {
 		return nil, fmt.Errorf("handshake from p2p message: %s", err)
 	}
+	// Tokens are bound to the address the peer enrolled from, so a token
+	// replayed from another host is rejected.
+	remoteIP, _, err := net.SplitHostPort(nc.RemoteAddr().String())
+	if err != nil {
+		return nil, fmt.Errorf("parse remote addr: %s", err)
+	}
+	if err := h.verifier.Check(hs.token, hs.peerID, remoteIP); err != nil {
+		return nil, fmt.Errorf("peer token: %s", err)
+	}
 	return hs, nil
 }
 
@@ -326,7 +459,8 @@ func (h *Handshaker) fullHandshake(
 	namespace string) (*HandshakeResult, error) {
 
 	// 发送握手消息
-	if err := h.sendHandshake(nc, info, remoteBitfields, namespace); err != nil {
+	local, err := h.sendHandshake(nc, info, remoteBitfields, namespace)
+	if err != nil {
 		return nil, fmt.Errorf("send handshake: %s", err)
 	}
 	// 读取返回
@@ -337,7 +471,7 @@ func (h *Handshaker) fullHandshake(
 	if hs.peerID != peerID {
 		return nil, errors.New("unexpected peer id")
 	}
-	c, err := h.newConn(nc, peerID, info, false)
+	c, err := h.newConn(nc, peerID, info, false, negotiate(local, hs), namespace, namespace)
 	if err != nil {
 		return nil, fmt.Errorf("new conn: %s", err)
 	}
@@ -348,7 +482,10 @@ func (h *Handshaker) newConn(
 	nc net.Conn,
 	peerID core.PeerID,
 	info *storage.TorrentInfo,
-	openedByRemote bool) (*Conn, error) {
+	openedByRemote bool,
+	n negotiated,
+	namespace string,
+	bandwidthNamespace string) (*Conn, error) {
 
 	return newConn(
 		h.config,
@@ -362,5 +499,11 @@ func (h *Handshaker) newConn(
 		peerID,
 		info,
 		openedByRemote,
+		n,
+		namespace,
+		bandwidthNamespace,
+		h.compressor,
+		h.faults,
+		h.activity,
 		zap.NewNop().Sugar())
 }
@@ -19,10 +19,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/gen/go/proto/p2p"
+	"github.com/uber/kraken/lib/peertoken"
+	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/storage"
 	"github.com/uber/kraken/utils/bitsetutil"
 )
@@ -65,11 +70,14 @@ func TestHandshakerSetsConnFieldsProperly(t *testing.T) {
 		require.Equal(info.Bitfield(), pc.Bitfield())
 		require.Equal(namespace, pc.Namespace())
 
-		c, err := h1.Establish(pc, info, remoteBitfields)
+		c, err := h1.Establish(pc, info, remoteBitfields, "")
 		require.NoError(err)
 		require.Equal(h2.peerID, c.PeerID())
 		require.Equal(info.InfoHash(), c.InfoHash())
 		require.True(c.CreatedAt().After(start))
+		require.Equal(namespace, c.Namespace())
+		// Bandwidth is never charged to the namespace sent by the remote peer.
+		require.Empty(c.bandwidthNamespace)
 	}()
 
 	wg.Add(1)
@@ -81,6 +89,7 @@ func TestHandshakerSetsConnFieldsProperly(t *testing.T) {
 		require.Equal(h1.peerID, r.Conn.PeerID())
 		require.Equal(info.InfoHash(), r.Conn.InfoHash())
 		require.True(r.Conn.CreatedAt().After(start))
+		require.Equal(namespace, r.Conn.bandwidthNamespace)
 		require.Equal(info.Bitfield(), r.Bitfield)
 		require.Equal(remoteBitfields, r.RemoteBitfields)
 	}()
@@ -125,3 +134,101 @@ func TestHandshakerHandlesEmptyBitfield(t *testing.T) {
 
 	wg.Wait()
 }
+
+func tokenHandshakerFixture(
+	t *testing.T, config Config, tokens peertoken.Config, ip string) *Handshaker {
+
+	pctx := core.PeerContextFixture()
+	i := peertoken.IssuerFixture(tokens, pctx, ip)
+	v, err := peertoken.NewVerifier(tokens, clock.New(), tally.NoopScope)
+	require.NoError(t, err)
+	transport, err := NewTransport(config, TLSFixture())
+	require.NoError(t, err)
+	h, err := NewHandshaker(
+		config,
+		tally.NewTestScope("", nil),
+		clock.New(),
+		networkevent.NewTestProducer(),
+		pctx.PeerID,
+		transport,
+		noopEvents{},
+		zap.NewNop().Sugar(),
+		WithPeerTokens(i, v))
+	require.NoError(t, err)
+	return h
+}
+
+func TestHandshakerPeerTokens(t *testing.T) {
+	config := ConfigFixture()
+	tokens := peertoken.ConfigFixture()
+
+	tests := []struct {
+		desc     string
+		h1       *Handshaker
+		h2       *Handshaker
+		accepted bool
+	}{
+		{
+			"valid tokens",
+			tokenHandshakerFixture(t, config, tokens, "127.0.0.1"),
+			tokenHandshakerFixture(t, config, tokens, "127.0.0.1"),
+			true,
+		}, {
+			"missing token",
+			tokenHandshakerFixture(t, config, tokens, "127.0.0.1"),
+			HandshakerFixture(config),
+			false,
+		}, {
+			"token signed with unknown key",
+			tokenHandshakerFixture(t, config, tokens, "127.0.0.1"),
+			tokenHandshakerFixture(t, config, peertoken.ConfigFixture(), "127.0.0.1"),
+			false,
+		}, {
+			"token bound to other address",
+			tokenHandshakerFixture(t, config, tokens, "127.0.0.1"),
+			tokenHandshakerFixture(t, config, tokens, "10.0.0.1"),
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			l1, err := net.Listen("tcp", "localhost:0")
+			require.NoError(err)
+			defer l1.Close()
+
+			info := storage.TorrentInfoFixture(4, 1)
+
+			var wg sync.WaitGroup
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				nc, err := l1.Accept()
+				require.NoError(err)
+				defer nc.Close()
+
+				pc, err := test.h1.Accept(nc)
+				if !test.accepted {
+					require.Error(err)
+					return
+				}
+				require.NoError(err)
+				_, err = test.h1.Establish(pc, info, make(RemoteBitfields), "")
+				require.NoError(err)
+			}()
+
+			_, err = test.h2.Initialize(
+				test.h1.peerID, l1.Addr().String(), info, make(RemoteBitfields), "")
+			if test.accepted {
+				require.NoError(err)
+			} else {
+				require.Error(err)
+			}
+
+			wg.Wait()
+		})
+	}
+}
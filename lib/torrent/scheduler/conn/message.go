@@ -31,6 +31,10 @@ import (
 type Message struct {
 	Message *p2p.Message
 	Payload storage.PieceReader
+
+	// TransferTime is the time spent reading Payload off the connection,
+	// excluding ingress throttling. Only set for received payloads.
+	TransferTime time.Duration
 }
 
 // NewPiecePayloadMessage returns a Message for sending a piece payload.
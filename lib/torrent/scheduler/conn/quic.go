@@ -0,0 +1,306 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// quicALPN identifies the p2p protocol during the QUIC TLS handshake.
+const quicALPN = "kraken-p2p"
+
+var errQUICTransportClosed = errors.New("quic transport closed")
+
+// quicTransport multiplexes all torrents shared with a peer over a single QUIC
+// connection, using one stream per torrent. Since streams are independent, loss
+// on one torrent's stream does not block the others, as it would with TCP.
+//
+// Listening and dialing share a single UDP socket, so a QUIC connection
+// accepted from a peer is reused when dialing that peer, and vice versa.
+type quicTransport struct {
+	serverTLS  *tls.Config
+	clientTLS  *tls.Config
+	quicConfig *quic.Config
+
+	// Streams opened by remote peers on any QUIC connection.
+	streams chan net.Conn
+
+	mu        sync.Mutex // Protects the following fields:
+	transport *quic.Transport
+	udpConn   *net.UDPConn
+	peers     map[string]*quicPeer
+	done      chan struct{}
+	closed    bool
+}
+
+// quicPeer is a QUIC connection to a peer, which may still be dialing.
+type quicPeer struct {
+	ready chan struct{} // Closed once conn or err is set.
+	conn  quic.Connection
+	err   error
+}
+
+// newQUICTransport creates a quicTransport which authenticates peers with the
+// roots and certificate of clientTLS. Since peers both dial and accept, the
+// certificate must be valid for client and server authentication, under the
+// server name of clientTLS.
+func newQUICTransport(config QUICConfig, clientTLS *tls.Config) (*quicTransport, error) {
+	if clientTLS == nil || len(clientTLS.Certificates) == 0 {
+		return nil, errors.New("quic transport requires a tls client certificate")
+	}
+	client := clientTLS.Clone()
+	client.NextProtos = []string{quicALPN}
+	server := clientTLS.Clone()
+	server.ClientAuth = tls.RequireAndVerifyClientCert
+	server.ClientCAs = clientTLS.RootCAs
+	server.NextProtos = []string{quicALPN}
+	return &quicTransport{
+		serverTLS: server,
+		clientTLS: client,
+		quicConfig: &quic.Config{
+			MaxIdleTimeout:             config.MaxIdleTimeout,
+			KeepAlivePeriod:            config.KeepAlivePeriod,
+			MaxIncomingStreams:         config.MaxIncomingStreams,
+			MaxStreamReceiveWindow:     config.MaxStreamReceiveWindow,
+			MaxConnectionReceiveWindow: config.MaxConnectionReceiveWindow,
+		},
+		streams: make(chan net.Conn),
+		peers:   make(map[string]*quicPeer),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Listen binds the UDP socket used for all QUIC connections. Must be called
+// before Dial.
+func (t *quicTransport) Listen(addr string) (net.Listener, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve: %s", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, errQUICTransportClosed
+	}
+	if t.transport != nil {
+		return nil, errors.New("quic transport already listening")
+	}
+	udpConn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	tr := &quic.Transport{Conn: udpConn}
+	ql, err := tr.Listen(t.serverTLS, t.quicConfig)
+	if err != nil {
+		udpConn.Close()
+		return nil, fmt.Errorf("quic listen: %s", err)
+	}
+	t.transport = tr
+	t.udpConn = udpConn
+
+	go t.acceptLoop(ql)
+
+	return &quicListener{t, udpConn.LocalAddr()}, nil
+}
+
+// Dial opens a new stream to the peer at addr, dialing a QUIC connection only
+// if there is none to the peer already.
+func (t *quicTransport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	qc, err := t.getOrDial(ctx, raddr)
+	if err != nil {
+		return nil, err
+	}
+	s, err := qc.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open stream: %s", err)
+	}
+	return &quicStreamConn{s, qc}, nil
+}
+
+func (t *quicTransport) getOrDial(ctx context.Context, raddr *net.UDPAddr) (quic.Connection, error) {
+	key := raddr.String()
+
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, errQUICTransportClosed
+	}
+	if t.transport == nil {
+		t.mu.Unlock()
+		return nil, errors.New("quic transport not listening")
+	}
+	p, ok := t.peers[key]
+	if !ok {
+		p = &quicPeer{ready: make(chan struct{})}
+		t.peers[key] = p
+	}
+	tr := t.transport
+	t.mu.Unlock()
+
+	if ok {
+		// Another goroutine is dialing, or has dialed, the peer.
+		select {
+		case <-p.ready:
+			return p.conn, p.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	qc, err := tr.Dial(ctx, raddr, t.clientTLS, t.quicConfig)
+	if err != nil {
+		t.mu.Lock()
+		delete(t.peers, key)
+		t.mu.Unlock()
+		p.err = fmt.Errorf("dial: %s", err)
+		close(p.ready)
+		return nil, p.err
+	}
+	p.conn = qc
+	close(p.ready)
+	go t.acceptStreams(key, p)
+	return qc, nil
+}
+
+func (t *quicTransport) acceptLoop(ql *quic.Listener) {
+	for {
+		qc, err := ql.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		key := qc.RemoteAddr().String()
+		p := &quicPeer{ready: make(chan struct{}), conn: qc}
+		close(p.ready)
+
+		t.mu.Lock()
+		if _, ok := t.peers[key]; !ok {
+			t.peers[key] = p
+		}
+		t.mu.Unlock()
+
+		go t.acceptStreams(key, p)
+	}
+}
+
+// acceptStreams accepts streams opened by the remote peer on p until the
+// connection closes, at which point p is forgotten.
+func (t *quicTransport) acceptStreams(key string, p *quicPeer) {
+	defer func() {
+		t.mu.Lock()
+		if t.peers[key] == p {
+			delete(t.peers, key)
+		}
+		t.mu.Unlock()
+	}()
+
+	for {
+		s, err := p.conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		select {
+		case t.streams <- &quicStreamConn{s, p.conn}:
+		case <-t.done:
+			s.CancelRead(0)
+			s.Close()
+			return
+		}
+	}
+}
+
+func (t *quicTransport) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	close(t.done)
+	for _, p := range t.peers {
+		select {
+		case <-p.ready:
+			if p.conn != nil {
+				p.conn.CloseWithError(0, "transport closed")
+			}
+		default:
+		}
+	}
+	if t.transport == nil {
+		return nil
+	}
+	t.transport.Close()
+	return t.udpConn.Close()
+}
+
+// quicListener accepts streams opened by remote peers.
+type quicListener struct {
+	transport *quicTransport
+	addr      net.Addr
+}
+
+func (l *quicListener) Accept() (net.Conn, error) {
+	select {
+	case nc := <-l.transport.streams:
+		return nc, nil
+	case <-l.transport.done:
+		return nil, errQUICTransportClosed
+	}
+}
+
+func (l *quicListener) Close() error {
+	return l.transport.close()
+}
+
+func (l *quicListener) Addr() net.Addr {
+	return l.addr
+}
+
+// quicStreamConn adapts a QUIC stream to a net.Conn.
+type quicStreamConn struct {
+	quic.Stream
+	conn quic.Connection
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *quicStreamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes both directions of the stream. The underlying QUIC connection
+// remains open for other torrents.
+func (c *quicStreamConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/uber/kraken/lib/torrent/storage"
+)
+
+// filePieceReader is a storage.PieceReader which may be backed by a file on
+// disk, e.g. piecereader.FileReader.
+type filePieceReader interface {
+	File() (*os.File, bool, error)
+}
+
+// sendFilePayload writes the payload of pr directly from disk to the socket
+// via sendfile / splice, without copying it through user space. Returns false
+// if pr or the underlying connection do not support zero-copy transfers, in
+// which case nothing was written and the caller should fall back to copying.
+func (c *Conn) sendFilePayload(pr storage.PieceReader) (bool, error) {
+	if c.config.DisableSendfile {
+		return false, nil
+	}
+	tc, ok := c.nc.(*net.TCPConn)
+	if !ok {
+		return false, nil
+	}
+	fr, ok := pr.(filePieceReader)
+	if !ok {
+		return false, nil
+	}
+	f, ok, err := fr.File()
+	if err != nil {
+		return false, fmt.Errorf("file: %s", err)
+	}
+	if !ok {
+		return false, nil
+	}
+
+	// Bandwidth is reserved in chunks so large pieces do not exceed the limiter
+	// burst and egress is smoothed across the piece.
+	remaining := int64(pr.Length())
+	for remaining > 0 {
+		n := int64(c.config.SendfileChunkSize)
+		if remaining < n {
+			n = remaining
+		}
+		if err := c.bandwidth.ReserveEgressFor(c.bandwidthNamespace, c.infoHash.Hex(), n); err != nil {
+			c.log().Errorf("Error reserving egress bandwidth for piece payload: %s", err)
+			return true, fmt.Errorf("egress bandwidth: %s", err)
+		}
+		// TCPConn.ReadFrom uses sendfile when given a file limited by an
+		// io.LimitedReader, starting at the file's current offset.
+		w, err := tc.ReadFrom(&io.LimitedReader{R: f, N: n})
+		c.countBandwidth("egress", 8*w)
+		if err != nil {
+			return true, fmt.Errorf("sendfile: %s", err)
+		}
+		if w != n {
+			return true, fmt.Errorf("sendfile: short write: %d < %d", w, n)
+		}
+		remaining -= w
+	}
+	c.stats.Counter("sendfile_payloads").Inc(1)
+	return true, nil
+}
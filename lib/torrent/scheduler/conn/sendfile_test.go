@@ -0,0 +1,202 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+	"github.com/uber/kraken/utils/memsize"
+	"github.com/uber/kraken/utils/randutil"
+)
+
+type cacheFileOpener struct {
+	cas  *store.CAStore
+	name string
+}
+
+func (o cacheFileOpener) Open() (store.FileReader, error) {
+	return o.cas.GetCacheFileReader(o.name)
+}
+
+// tcpConnFixture returns a Conn over a loopback TCP connection, and the remote
+// end of the connection.
+func tcpConnFixture(config Config) (*Conn, net.Conn, func()) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		panic(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		nc, err := l.Accept()
+		if err != nil {
+			panic(err)
+		}
+		accepted <- nc
+	}()
+	local, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	remote := <-accepted
+
+	info := storage.TorrentInfoFixture(1, 1)
+	c, err := HandshakerFixture(config).newConn(
+		local, core.PeerIDFixture(), info, false, negotiated{ProtocolVersion, supportedCapabilities}, "", "")
+	if err != nil {
+		panic(err)
+	}
+	return c, remote, func() {
+		local.Close()
+		remote.Close()
+	}
+}
+
+// pieceFileFixture writes blob into a CAStore cache file and returns a function
+// which creates piece readers for it.
+func pieceFileFixture(blob []byte) (func(offset, length int64) *piecereader.FileReader, func()) {
+	cas, cleanup := store.CAStoreFixture()
+	d, err := core.NewDigester().FromBytes(blob)
+	if err != nil {
+		panic(err)
+	}
+	if err := cas.CreateCacheFile(d.Hex(), bytes.NewReader(blob)); err != nil {
+		panic(err)
+	}
+	opener := cacheFileOpener{cas, d.Hex()}
+	return func(offset, length int64) *piecereader.FileReader {
+		return piecereader.NewFileReader(offset, length, opener)
+	}, cleanup
+}
+
+func TestSendPiecePayload(t *testing.T) {
+	blob := randutil.Text(3*memsize.MB + 17)
+
+	tests := []struct {
+		desc      string
+		config    Config
+		sendfile  bool
+		newReader func(newFileReader func(offset, length int64) *piecereader.FileReader,
+			offset, length int64) storage.PieceReader
+	}{
+		{
+			"file reader uses sendfile",
+			ConfigFixture(),
+			true,
+			func(nfr func(int64, int64) *piecereader.FileReader, offset, length int64) storage.PieceReader {
+				return nfr(offset, length)
+			},
+		}, {
+			"sendfile disabled",
+			func() Config {
+				c := ConfigFixture()
+				c.DisableSendfile = true
+				return c
+			}(),
+			false,
+			func(nfr func(int64, int64) *piecereader.FileReader, offset, length int64) storage.PieceReader {
+				return nfr(offset, length)
+			},
+		}, {
+			"buffer reader falls back",
+			ConfigFixture(),
+			false,
+			func(_ func(int64, int64) *piecereader.FileReader, offset, length int64) storage.PieceReader {
+				return piecereader.NewBuffer(blob[offset : offset+length])
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			newFileReader, cleanup := pieceFileFixture(blob)
+			defer cleanup()
+
+			c, remote, cleanup := tcpConnFixture(test.config)
+			defer cleanup()
+
+			// Sends an unaligned piece which spans multiple chunks.
+			offset := int64(5)
+			length := int64(len(blob)) - offset
+
+			errc := make(chan error, 1)
+			go func() {
+				errc <- c.sendPiecePayload(test.newReader(newFileReader, offset, length))
+			}()
+
+			result := make([]byte, length)
+			_, err := io.ReadFull(remote, result)
+			require.NoError(err)
+			require.NoError(<-errc)
+			require.Equal(blob[offset:], result)
+
+			sendfilePayloads := c.stats.(tally.TestScope).Snapshot().Counters()["sendfile_payloads+module=conn"]
+			if test.sendfile {
+				require.NotNil(sendfilePayloads)
+				require.Equal(int64(1), sendfilePayloads.Value())
+			} else {
+				require.Nil(sendfilePayloads)
+			}
+		})
+	}
+}
+
+func benchmarkSendPiecePayload(b *testing.B, config Config, pieceLength int64) {
+	const numPieces = 16
+
+	blob := randutil.Text(uint64(numPieces * pieceLength))
+	newFileReader, cleanup := pieceFileFixture(blob)
+	defer cleanup()
+
+	c, remote, cleanup := tcpConnFixture(config)
+	defer cleanup()
+
+	go io.Copy(ioutil.Discard, remote)
+
+	b.SetBytes(pieceLength)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pr := newFileReader(int64(i%numPieces)*pieceLength, pieceLength)
+		if err := c.sendPiecePayload(pr); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSendPiecePayload(b *testing.B) {
+	for _, pieceLength := range []int64{256 * int64(memsize.KB), 4 * int64(memsize.MB)} {
+		for _, sendfile := range []bool{true, false} {
+			config := ConfigFixture()
+			config.DisableSendfile = !sendfile
+			name := fmt.Sprintf("piece=%s/sendfile=%t", memsize.Format(uint64(pieceLength)), sendfile)
+			b.Run(name, func(b *testing.B) {
+				benchmarkSendPiecePayload(b, config, pieceLength)
+			})
+		}
+	}
+}
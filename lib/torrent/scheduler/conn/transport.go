@@ -0,0 +1,64 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Supported transports.
+const (
+	TransportTCP  = "tcp"
+	TransportQUIC = "quic"
+)
+
+// Transport establishes the network connections which Conns are built on. Each
+// net.Conn returned by a Transport carries a single torrent.
+type Transport interface {
+	// Listen accepts connections opened by remote peers on addr. Closing the
+	// returned listener shuts down the Transport.
+	Listen(addr string) (net.Listener, error)
+
+	// Dial opens a connection to the peer listening on addr.
+	Dial(addr string, timeout time.Duration) (net.Conn, error)
+}
+
+// NewTransport creates the Transport selected by config. The QUIC transport
+// authenticates peers with tls, which must include a certificate, whereas the
+// TCP transport ignores tls.
+func NewTransport(config Config, tls *tls.Config) (Transport, error) {
+	config = config.applyDefaults()
+	switch config.Transport {
+	case TransportTCP:
+		return tcpTransport{}, nil
+	case TransportQUIC:
+		return newQUICTransport(config.QUIC, tls)
+	default:
+		return nil, fmt.Errorf("unknown transport %q", config.Transport)
+	}
+}
+
+// tcpTransport opens a separate TCP connection per torrent.
+type tcpTransport struct{}
+
+func (tcpTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func (tcpTransport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, timeout)
+}
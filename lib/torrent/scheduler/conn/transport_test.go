@@ -0,0 +1,270 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package conn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+)
+
+func transportFixture(t *testing.T, transport string) (Transport, net.Listener) {
+	config := ConfigFixture()
+	config.Transport = transport
+	tr, err := NewTransport(config, TLSFixture())
+	require.NoError(t, err)
+	l, err := tr.Listen("localhost:0")
+	require.NoError(t, err)
+	return tr, l
+}
+
+// echo writes back everything read from nc.
+func echo(nc net.Conn) {
+	defer nc.Close()
+	io.Copy(nc, nc)
+}
+
+func requireEcho(t *testing.T, nc net.Conn, msg string) {
+	_, err := nc.Write([]byte(msg))
+	require.NoError(t, err)
+	b := make([]byte, len(msg))
+	_, err = io.ReadFull(nc, b)
+	require.NoError(t, err)
+	require.Equal(t, msg, string(b))
+}
+
+func TestNewTransportUnknown(t *testing.T) {
+	config := ConfigFixture()
+	config.Transport = "carrier-pigeon"
+	_, err := NewTransport(config, TLSFixture())
+	require.Error(t, err)
+}
+
+func TestTransportDialAndAccept(t *testing.T) {
+	for _, transport := range []string{TransportTCP, TransportQUIC} {
+		t.Run(transport, func(t *testing.T) {
+			require := require.New(t)
+
+			_, l := transportFixture(t, transport)
+			defer l.Close()
+
+			client, cl := transportFixture(t, transport)
+			defer cl.Close()
+
+			go func() {
+				for {
+					nc, err := l.Accept()
+					if err != nil {
+						return
+					}
+					go echo(nc)
+				}
+			}()
+
+			// Each dial carries an independent stream of data.
+			var ncs []net.Conn
+			for i := 0; i < 3; i++ {
+				nc, err := client.Dial(l.Addr().String(), time.Second)
+				require.NoError(err)
+				defer nc.Close()
+				ncs = append(ncs, nc)
+			}
+			for i, nc := range ncs {
+				requireEcho(t, nc, string(rune('a'+i)))
+			}
+		})
+	}
+}
+
+func TestQUICTransportReusesConnectionPerPeer(t *testing.T) {
+	require := require.New(t)
+
+	server, l := transportFixture(t, TransportQUIC)
+	defer l.Close()
+
+	client, cl := transportFixture(t, TransportQUIC)
+	defer cl.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- nc
+		}
+	}()
+
+	nc1, err := client.Dial(l.Addr().String(), time.Second)
+	require.NoError(err)
+	requireWrite(t, nc1)
+	nc2, err := client.Dial(l.Addr().String(), time.Second)
+	require.NoError(err)
+	requireWrite(t, nc2)
+
+	// Both streams share the same QUIC connection.
+	require.Equal(
+		nc1.(*quicStreamConn).conn, nc2.(*quicStreamConn).conn)
+
+	remote1 := <-accepted
+	remote2 := <-accepted
+	require.Equal(
+		remote1.(*quicStreamConn).conn, remote2.(*quicStreamConn).conn)
+
+	// Closing a stream does not close the connection.
+	nc1.Close()
+	remote1.Close()
+	_, err = io.ReadFull(remote2, make([]byte, 1))
+	require.NoError(err)
+	go echo(remote2)
+	requireEcho(t, nc2, "still open")
+
+	// The server dialing back to the client reuses the connection the client
+	// opened, since both sides share one UDP socket for listening and dialing.
+	go func() {
+		for {
+			nc, err := cl.Accept()
+			if err != nil {
+				return
+			}
+			go echo(nc)
+		}
+	}()
+	nc3, err := server.Dial(cl.Addr().String(), time.Second)
+	require.NoError(err)
+	defer nc3.Close()
+	requireEcho(t, nc3, "reverse")
+	require.Equal(1, len(server.(*quicTransport).peers))
+}
+
+func requireWrite(t *testing.T, nc net.Conn) {
+	// QUIC streams are only visible to the remote peer once data is sent.
+	_, err := nc.Write([]byte{0})
+	require.NoError(t, err)
+}
+
+func TestQUICTransportDialBeforeListen(t *testing.T) {
+	config := ConfigFixture()
+	config.Transport = TransportQUIC
+	tr, err := NewTransport(config, TLSFixture())
+	require.NoError(t, err)
+
+	_, err = tr.Dial("localhost:8080", time.Second)
+	require.Error(t, err)
+}
+
+func TestQUICTransportRequiresCertificate(t *testing.T) {
+	config := ConfigFixture()
+	config.Transport = TransportQUIC
+
+	_, err := NewTransport(config, nil)
+	require.Error(t, err)
+
+	_, err = NewTransport(config, &tls.Config{RootCAs: TLSFixture().RootCAs})
+	require.Error(t, err)
+}
+
+func TestQUICTransportRejectsUntrustedPeers(t *testing.T) {
+	require := require.New(t)
+
+	_, l := transportFixture(t, TransportQUIC)
+	defer l.Close()
+
+	config := ConfigFixture()
+	config.Transport = TransportQUIC
+	untrusted := TLSFixture().Clone()
+	untrusted.RootCAs = x509.NewCertPool()
+	tr, err := NewTransport(config, untrusted)
+	require.NoError(err)
+	cl, err := tr.Listen("localhost:0")
+	require.NoError(err)
+	defer cl.Close()
+
+	_, err = tr.Dial(l.Addr().String(), time.Second)
+	require.Error(err)
+}
+
+func TestQUICTransportListenerClose(t *testing.T) {
+	require := require.New(t)
+
+	tr, l := transportFixture(t, TransportQUIC)
+
+	errc := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		errc <- err
+	}()
+	require.NoError(l.Close())
+	require.Error(<-errc)
+
+	_, err := tr.Dial("localhost:8080", time.Second)
+	require.Error(err)
+}
+
+func TestHandshakerOverQUIC(t *testing.T) {
+	require := require.New(t)
+
+	config := ConfigFixture()
+	config.Transport = TransportQUIC
+
+	h1 := HandshakerFixture(config)
+	h2 := HandshakerFixture(config)
+
+	l1, err := h1.transport.Listen("localhost:0")
+	require.NoError(err)
+	defer l1.Close()
+	l2, err := h2.transport.Listen("localhost:0")
+	require.NoError(err)
+	defer l2.Close()
+
+	info := storage.TorrentInfoFixture(4, 1)
+	namespace := core.TagFixture()
+
+	established := make(chan *Conn, 1)
+	go func() {
+		nc, err := l2.Accept()
+		if err != nil {
+			return
+		}
+		pc, err := h2.Accept(nc)
+		if err != nil {
+			return
+		}
+		c, err := h2.Establish(pc, info, nil, "")
+		if err != nil {
+			return
+		}
+		established <- c
+	}()
+
+	result, err := h1.Initialize(h2.peerID, l2.Addr().String(), info, nil, namespace)
+	require.NoError(err)
+	defer result.Conn.Close()
+
+	c := <-established
+	defer c.Close()
+
+	require.Equal(h1.peerID, c.PeerID())
+	require.Equal(h2.peerID, result.Conn.PeerID())
+	require.Equal(info.InfoHash(), c.InfoHash())
+}
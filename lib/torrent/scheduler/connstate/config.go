@@ -22,6 +22,10 @@ type Config struct {
 	// Scheduler will maintain at once for each torrent.
 	MaxOpenConnectionsPerTorrent int `yaml:"max_open_conn"`
 
+	// MaxOpenConnectionsPerBundle is the maximum number of connections which a
+	// Scheduler will maintain at once across all torrents of a bundle.
+	MaxOpenConnectionsPerBundle int `yaml:"max_open_conn_per_bundle"`
+
 	// MaxMutualConnections is the maximum number of mutual connections a peer
 	// can have and still connect with us.
 	MaxMutualConnections int `yaml:"max_mutual_conn"`
@@ -38,6 +42,9 @@ func (c Config) applyDefaults() Config {
 	if c.MaxOpenConnectionsPerTorrent == 0 {
 		c.MaxOpenConnectionsPerTorrent = 10
 	}
+	if c.MaxOpenConnectionsPerBundle == 0 {
+		c.MaxOpenConnectionsPerBundle = 3 * c.MaxOpenConnectionsPerTorrent
+	}
 	// Defaults to no mutual connection limit.
 	if c.MaxMutualConnections == 0 {
 		c.MaxMutualConnections = c.MaxOpenConnectionsPerTorrent
@@ -15,6 +15,7 @@ package connstate
 
 import (
 	"errors"
+	"math"
 	"time"
 
 	"github.com/andres-erbsen/clock"
@@ -91,6 +92,21 @@ type State struct {
 
 	// All blacklisted conns. These do not count towards conn capacity.
 	blacklist map[connKey]*blacklistEntry
+
+	// Weights which scale the connection limit of individual torrents. Torrents
+	// without a weight use the configured limit.
+	weights map[core.InfoHash]float64
+
+	// Groups of torrents which share a single connection budget, keyed by the
+	// group id and by each member torrent.
+	groups       map[string]*group
+	groupsByHash map[core.InfoHash]*group
+}
+
+// group is a set of torrents which share MaxOpenConnectionsPerBundle.
+type group struct {
+	id     string
+	hashes []core.InfoHash
 }
 
 // New creates a new State.
@@ -104,14 +120,74 @@ func New(
 	config = config.applyDefaults()
 
 	return &State{
-		config:      config,
-		clk:         clk,
-		netevents:   netevents,
-		localPeerID: localPeerID,
-		logger:      logger,
-		conns:       make(map[core.InfoHash]map[core.PeerID]entry),
-		blacklist:   make(map[connKey]*blacklistEntry),
+		config:       config,
+		clk:          clk,
+		netevents:    netevents,
+		localPeerID:  localPeerID,
+		logger:       logger,
+		conns:        make(map[core.InfoHash]map[core.PeerID]entry),
+		blacklist:    make(map[connKey]*blacklistEntry),
+		weights:      make(map[core.InfoHash]float64),
+		groups:       make(map[string]*group),
+		groupsByHash: make(map[core.InfoHash]*group),
+	}
+}
+
+// SetWeight scales the maximum number of connections for h by w. Lowering the
+// weight does not close existing connections, but prevents new connections from
+// being added until h is below its new limit. A weight of 1 restores the
+// configured limit.
+func (s *State) SetWeight(h core.InfoHash, w float64) {
+	if w == 1 {
+		delete(s.weights, h)
+		return
+	}
+	s.weights[h] = w
+}
+
+// AddGroup makes hashes share a single budget of MaxOpenConnectionsPerBundle
+// connections, in addition to their per-torrent limits. A torrent belongs to at
+// most one group, so hashes which already belong to another group are left
+// there. No-ops if id is already a group.
+func (s *State) AddGroup(id string, hashes []core.InfoHash) {
+	if _, ok := s.groups[id]; ok {
+		return
 	}
+	g := &group{id: id}
+	for _, h := range hashes {
+		if _, ok := s.groupsByHash[h]; ok {
+			continue
+		}
+		g.hashes = append(g.hashes, h)
+		s.groupsByHash[h] = g
+	}
+	s.groups[id] = g
+}
+
+// RemoveGroup releases the torrents of group id back to their per-torrent
+// limits. Existing connections are not closed.
+func (s *State) RemoveGroup(id string) {
+	g, ok := s.groups[id]
+	if !ok {
+		return
+	}
+	for _, h := range g.hashes {
+		delete(s.groupsByHash, h)
+	}
+	delete(s.groups, id)
+}
+
+// MaxConns returns the maximum number of connections for h.
+func (s *State) MaxConns(h core.InfoHash) int {
+	w, ok := s.weights[h]
+	if !ok {
+		return s.config.MaxOpenConnectionsPerTorrent
+	}
+	n := int(math.Round(float64(s.config.MaxOpenConnectionsPerTorrent) * w))
+	if n < 1 {
+		n = 1
+	}
+	return n
 }
 
 // ActiveConns returns a list of all active connections.
@@ -139,7 +215,14 @@ func (s *State) Saturated(h core.InfoHash) bool {
 			active++
 		}
 	}
-	return active == s.config.MaxOpenConnectionsPerTorrent
+	if active >= s.MaxConns(h) {
+		return true
+	}
+	if g, ok := s.groupsByHash[h]; ok {
+		_, groupActive := s.groupConns(g)
+		return groupActive >= s.config.MaxOpenConnectionsPerBundle
+	}
+	return false
 }
 
 // Blacklist blacklists peerID/h for the configured BlacklistDuration.
@@ -182,9 +265,14 @@ func (s *State) ClearBlacklist(h core.InfoHash) {
 // AddPending sets the connection for peerID/h as pending and reserves capacity
 // for it.
 func (s *State) AddPending(peerID core.PeerID, h core.InfoHash, neighbors []core.PeerID) error {
-	if len(s.conns[h]) == s.config.MaxOpenConnectionsPerTorrent {
+	if len(s.conns[h]) >= s.MaxConns(h) {
 		return ErrTorrentAtCapacity
 	}
+	if g, ok := s.groupsByHash[h]; ok {
+		if total, _ := s.groupConns(g); total >= s.config.MaxOpenConnectionsPerBundle {
+			return ErrTorrentAtCapacity
+		}
+	}
 	switch s.get(h, peerID).status {
 	case _uninit:
 		if s.numMutualConns(h, neighbors) > s.config.MaxMutualConnections {
@@ -224,7 +312,9 @@ func (s *State) MovePendingToActive(c *conn.Conn) error {
 	s.put(c.InfoHash(), c.PeerID(), entry{status: _active, conn: c})
 
 	s.log("hash", c.InfoHash(), "peer", c.PeerID()).Info("Moved conn from pending to active")
-	s.netevents.Produce(networkevent.AddActiveConnEvent(c.InfoHash(), s.localPeerID, c.PeerID()))
+	e := networkevent.AddActiveConnEvent(c.InfoHash(), s.localPeerID, c.PeerID())
+	e.Namespace = c.Namespace()
+	s.netevents.Produce(e)
 
 	return nil
 }
@@ -244,8 +334,23 @@ func (s *State) DeleteActive(c *conn.Conn) {
 
 	s.log("hash", c.InfoHash(), "peer", c.PeerID()).Infof(
 		"Deleted active conn, capacity now at %d", s.capacity(c.InfoHash()))
-	s.netevents.Produce(networkevent.DropActiveConnEvent(
-		c.InfoHash(), s.localPeerID, c.PeerID()))
+	event := networkevent.DropActiveConnEvent(c.InfoHash(), s.localPeerID, c.PeerID())
+	event.Namespace = c.Namespace()
+	s.netevents.Produce(event)
+}
+
+// groupConns returns the number of pending or active conns and the number of
+// active conns across all torrents of g.
+func (s *State) groupConns(g *group) (total, active int) {
+	for _, h := range g.hashes {
+		for _, e := range s.conns[h] {
+			total++
+			if e.status == _active {
+				active++
+			}
+		}
+	}
+	return total, active
 }
 
 func (s *State) numMutualConns(h core.InfoHash, neighbors []core.PeerID) int {
@@ -280,6 +385,34 @@ func (s *State) BlacklistSnapshot() []BlacklistedConn {
 	return conns
 }
 
+// ConnInfo describes a pending or active conn.
+type ConnInfo struct {
+	PeerID    core.PeerID   `json:"peer_id"`
+	InfoHash  core.InfoHash `json:"info_hash"`
+	Active    bool          `json:"active"`
+	CreatedAt time.Time     `json:"created_at,omitempty"`
+}
+
+// ConnSnapshot returns a snapshot of all pending and active conns. CreatedAt
+// is only set for active conns.
+func (s *State) ConnSnapshot() []ConnInfo {
+	var conns []ConnInfo
+	for h, peers := range s.conns {
+		for peerID, e := range peers {
+			c := ConnInfo{
+				PeerID:   peerID,
+				InfoHash: h,
+				Active:   e.status == _active,
+			}
+			if c.Active {
+				c.CreatedAt = e.conn.CreatedAt()
+			}
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
 func (s *State) get(h core.InfoHash, peerID core.PeerID) entry {
 	peers, ok := s.conns[h]
 	if !ok {
@@ -309,7 +442,7 @@ func (s *State) delete(h core.InfoHash, peerID core.PeerID) {
 }
 
 func (s *State) capacity(h core.InfoHash) int {
-	return s.config.MaxOpenConnectionsPerTorrent - len(s.conns[h])
+	return s.MaxConns(h) - len(s.conns[h])
 }
 
 func (s *State) log(args ...interface{}) *zap.SugaredLogger {
@@ -122,6 +122,35 @@ func TestStateAddPendingReservesCapacity(t *testing.T) {
 	require.Equal(ErrTorrentAtCapacity, s.AddPending(core.PeerIDFixture(), h, nil))
 }
 
+func TestStateWeightScalesCapacity(t *testing.T) {
+	require := require.New(t)
+
+	s := testState(Config{MaxOpenConnectionsPerTorrent: 10}, clock.New())
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+
+	s.SetWeight(h1, 0.5)
+	s.SetWeight(h2, 2)
+	require.Equal(5, s.MaxConns(h1))
+	require.Equal(20, s.MaxConns(h2))
+
+	for i := 0; i < 5; i++ {
+		require.NoError(s.AddPending(core.PeerIDFixture(), h1, nil))
+	}
+	require.Equal(ErrTorrentAtCapacity, s.AddPending(core.PeerIDFixture(), h1, nil))
+
+	// Restoring the weight restores the configured limit.
+	s.SetWeight(h1, 1)
+	require.Equal(10, s.MaxConns(h1))
+	require.NoError(s.AddPending(core.PeerIDFixture(), h1, nil))
+
+	// Weights never reduce capacity to zero.
+	s.SetWeight(h1, 0.01)
+	require.Equal(1, s.MaxConns(h1))
+	require.Equal(ErrTorrentAtCapacity, s.AddPending(core.PeerIDFixture(), h1, nil))
+}
+
 func TestStateDeletePendingAllowsFutureAddPending(t *testing.T) {
 	require := require.New(t)
 
@@ -163,6 +192,26 @@ func TestStateMovePendingToActivePreventsFuturePending(t *testing.T) {
 	require.Equal(ErrConnAlreadyActive, s.AddPending(c.PeerID(), c.InfoHash(), nil))
 }
 
+func TestStateConnSnapshot(t *testing.T) {
+	require := require.New(t)
+
+	s := testState(Config{}, clock.New())
+
+	c, cleanup := conn.Fixture()
+	defer cleanup()
+
+	pending := core.PeerIDFixture()
+
+	require.NoError(s.AddPending(pending, c.InfoHash(), nil))
+	require.NoError(s.AddPending(c.PeerID(), c.InfoHash(), nil))
+	require.NoError(s.MovePendingToActive(c))
+
+	require.ElementsMatch([]ConnInfo{
+		{PeerID: pending, InfoHash: c.InfoHash()},
+		{PeerID: c.PeerID(), InfoHash: c.InfoHash(), Active: true, CreatedAt: c.CreatedAt()},
+	}, s.ConnSnapshot())
+}
+
 func TestStateMovePendingToActiveRejectsNonPendingConns(t *testing.T) {
 	require := require.New(t)
 
@@ -302,3 +351,87 @@ func TestMaxMutualConns(t *testing.T) {
 	require.Equal(s.AddPending(core.PeerIDFixture(), h, neighbors[:mutualConnLimit+1]), ErrTooManyMutualConns)
 	require.NoError(s.AddPending(core.PeerIDFixture(), h, neighbors[:mutualConnLimit]))
 }
+
+func TestStateGroupSharesCapacity(t *testing.T) {
+	require := require.New(t)
+
+	s := testState(Config{
+		MaxOpenConnectionsPerTorrent: 3,
+		MaxOpenConnectionsPerBundle:  4,
+	}, clock.New())
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+	h3 := core.InfoHashFixture()
+
+	s.AddGroup("bundle", []core.InfoHash{h1, h2})
+
+	for i := 0; i < 3; i++ {
+		require.NoError(s.AddPending(core.PeerIDFixture(), h1, nil))
+	}
+	require.NoError(s.AddPending(core.PeerIDFixture(), h2, nil))
+	require.Equal(ErrTorrentAtCapacity, s.AddPending(core.PeerIDFixture(), h2, nil))
+
+	// Torrents outside of the group are unaffected.
+	require.NoError(s.AddPending(core.PeerIDFixture(), h3, nil))
+
+	s.RemoveGroup("bundle")
+	require.NoError(s.AddPending(core.PeerIDFixture(), h2, nil))
+}
+
+func TestStateGroupMembershipIsExclusive(t *testing.T) {
+	require := require.New(t)
+
+	s := testState(Config{
+		MaxOpenConnectionsPerTorrent: 5,
+		MaxOpenConnectionsPerBundle:  3,
+	}, clock.New())
+
+	shared := core.InfoHashFixture()
+	h := core.InfoHashFixture()
+
+	s.AddGroup("a", []core.InfoHash{shared})
+	s.AddGroup("b", []core.InfoHash{shared, h})
+
+	for i := 0; i < 3; i++ {
+		require.NoError(s.AddPending(core.PeerIDFixture(), shared, nil))
+	}
+	// shared belongs to group a, so its conns do not count towards group b.
+	require.NoError(s.AddPending(core.PeerIDFixture(), h, nil))
+
+	// Removing group b leaves shared in group a.
+	s.RemoveGroup("b")
+	require.Equal(ErrTorrentAtCapacity, s.AddPending(core.PeerIDFixture(), shared, nil))
+
+	s.RemoveGroup("a")
+	require.NoError(s.AddPending(core.PeerIDFixture(), shared, nil))
+}
+
+func TestStateGroupSaturated(t *testing.T) {
+	require := require.New(t)
+
+	s := testState(Config{
+		MaxOpenConnectionsPerTorrent: 2,
+		MaxOpenConnectionsPerBundle:  2,
+	}, clock.New())
+
+	info1 := storage.TorrentInfoFixture(1, 1)
+	info2 := storage.TorrentInfoFixture(1, 1)
+
+	s.AddGroup("bundle", []core.InfoHash{info1.InfoHash(), info2.InfoHash()})
+
+	for _, info := range []*storage.TorrentInfo{info1, info2} {
+		c, _, cleanup := conn.PipeFixture(conn.Config{}, info)
+		defer cleanup()
+
+		require.NoError(s.AddPending(c.PeerID(), info.InfoHash(), nil))
+		require.NoError(s.MovePendingToActive(c))
+	}
+
+	// Neither torrent is at its own limit, but the group is.
+	require.True(s.Saturated(info1.InfoHash()))
+	require.True(s.Saturated(info2.InfoHash()))
+
+	s.RemoveGroup("bundle")
+	require.False(s.Saturated(info1.InfoHash()))
+}
@@ -20,11 +20,13 @@ import (
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/blobrefresh"
 	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/lib/peertoken"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler/announcequeue"
 	"github.com/uber/kraken/lib/torrent/storage/agentstorage"
 	"github.com/uber/kraken/lib/torrent/storage/originstorage"
+	"github.com/uber/kraken/lib/torrent/storage/piececache"
 	"github.com/uber/kraken/tracker/announceclient"
 	"github.com/uber/kraken/tracker/metainfoclient"
 
@@ -39,15 +41,24 @@ func NewAgentScheduler(
 	cads *store.CADownloadStore,
 	netevents networkevent.Producer,
 	trackers hashring.PassiveRing,
-	tls *tls.Config) (ReloadableScheduler, error) {
+	tls *tls.Config,
+	tokens *peertoken.Issuer,
+	tokenVerifier *peertoken.Verifier) (ReloadableScheduler, error) {
 
+	load := NewLoadMeter()
 	s, err := newScheduler(
 		config,
 		agentstorage.NewTorrentArchive(stats, cads, metainfoclient.New(trackers, tls)),
 		stats,
 		pctx,
-		announceclient.New(pctx, trackers, tls),
-		netevents)
+		announceclient.New(
+			pctx, trackers, tls,
+			announceclient.WithTokenIssuer(tokens),
+			announceclient.WithLoadReporter(load)),
+		netevents,
+		WithTLS(tls),
+		WithPeerTokens(tokens, tokenVerifier),
+		WithLoadMeter(load))
 	if err != nil {
 		return nil, fmt.Errorf("new scheduler: %s", err)
 	}
@@ -68,15 +79,21 @@ func NewOriginScheduler(
 	pctx core.PeerContext,
 	cas *store.CAStore,
 	netevents networkevent.Producer,
-	blobRefresher *blobrefresh.Refresher) (ReloadableScheduler, error) {
+	blobRefresher *blobrefresh.Refresher,
+	pieceCache *piececache.Cache,
+	tls *tls.Config,
+	tokens *peertoken.Issuer,
+	tokenVerifier *peertoken.Verifier) (ReloadableScheduler, error) {
 
 	s, err := newScheduler(
 		config,
-		originstorage.NewTorrentArchive(cas, blobRefresher),
+		originstorage.NewTorrentArchive(cas, blobRefresher, pieceCache),
 		stats,
 		pctx,
 		announceclient.Disabled(),
-		netevents)
+		netevents,
+		WithTLS(tls),
+		WithPeerTokens(tokens, tokenVerifier))
 	if err != nil {
 		return nil, err
 	}
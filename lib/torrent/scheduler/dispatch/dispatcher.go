@@ -16,6 +16,8 @@ package dispatch
 import (
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -24,6 +26,7 @@ import (
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler/conn"
 	"github.com/uber/kraken/lib/torrent/scheduler/dispatch/piecerequest"
+	"github.com/uber/kraken/lib/torrent/scheduler/reputation"
 	"github.com/uber/kraken/lib/torrent/scheduler/torrentlog"
 	"github.com/uber/kraken/lib/torrent/storage"
 	"github.com/uber/kraken/utils/syncutil"
@@ -54,6 +57,7 @@ type Events interface {
 type Messages interface {
 	Send(msg *conn.Message) error
 	Receiver() <-chan *conn.Message
+	Capabilities() conn.Capabilities
 	Close()
 }
 
@@ -78,10 +82,33 @@ type Dispatcher struct {
 	pendingPiecesDone     chan struct{}
 	completeOnce          sync.Once
 	events                Events
+	reputation            *reputation.Table
+	timedOut              map[piecerequest.Request]bool // Only accessed by watchPendingPieceRequests.
+	activity              *syncutil.Activity
 	logger                *zap.SugaredLogger
 	torrentlog            *torrentlog.Logger
 }
 
+// Option overrides Dispatcher defaults.
+type Option func(*dispatcherOverrides)
+
+type dispatcherOverrides struct {
+	activity *syncutil.Activity
+	rand     *rand.Rand
+}
+
+// WithActivity counts the goroutines of the Dispatcher and the messages it
+// dispatches as work of a. Messages must be added to a by the Messages of each
+// peer before they are received.
+func WithActivity(a *syncutil.Activity) Option {
+	return func(o *dispatcherOverrides) { o.activity = a }
+}
+
+// WithRand selects pieces to request using r instead of the global source.
+func WithRand(r *rand.Rand) Option {
+	return func(o *dispatcherOverrides) { o.rand = r }
+}
+
 // New creates a new Dispatcher.
 func New(
 	config Config,
@@ -89,12 +116,15 @@ func New(
 	clk clock.Clock,
 	netevents networkevent.Producer,
 	events Events,
+	rep *reputation.Table,
 	peerID core.PeerID,
 	t storage.Torrent,
 	logger *zap.SugaredLogger,
-	tlog *torrentlog.Logger) (*Dispatcher, error) {
+	tlog *torrentlog.Logger,
+	options ...Option) (*Dispatcher, error) {
 
-	d, err := newDispatcher(config, stats, clk, netevents, events, peerID, t, logger, tlog)
+	d, err := newDispatcher(
+		config, stats, clk, netevents, events, rep, peerID, t, logger, tlog, options...)
 	if err != nil {
 		return nil, err
 	}
@@ -116,20 +146,31 @@ func newDispatcher(
 	clk clock.Clock,
 	netevents networkevent.Producer,
 	events Events,
+	rep *reputation.Table,
 	peerID core.PeerID,
 	t storage.Torrent,
 	logger *zap.SugaredLogger,
-	tlog *torrentlog.Logger) (*Dispatcher, error) {
+	tlog *torrentlog.Logger,
+	options ...Option) (*Dispatcher, error) {
 
 	config = config.applyDefaults()
 
+	var overrides dispatcherOverrides
+	for _, opt := range options {
+		opt(&overrides)
+	}
+
 	stats = stats.Tagged(map[string]string{
 		"module": "dispatch",
 	})
 
 	pieceRequestTimeout := config.calcPieceRequestTimeout(t.MaxPieceLength())
+	var managerOptions []piecerequest.Option
+	if overrides.rand != nil {
+		managerOptions = append(managerOptions, piecerequest.WithRand(overrides.rand))
+	}
 	pieceRequestManager, err := piecerequest.NewManager(
-		clk, pieceRequestTimeout, config.PieceRequestPolicy, config.PipelineLimit)
+		clk, pieceRequestTimeout, config.PieceRequestPolicy, config.PipelineLimit, managerOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("piece request manager: %s", err)
 	}
@@ -147,11 +188,25 @@ func newDispatcher(
 		pieceRequestManager: pieceRequestManager,
 		pendingPiecesDone:   make(chan struct{}),
 		events:              events,
+		reputation:          rep,
+		timedOut:            make(map[piecerequest.Request]bool),
+		activity:            overrides.activity,
 		logger:              logger,
 		torrentlog:          tlog,
 	}, nil
 }
 
+// SetWeight scales the number of piece requests d pipelines to each peer by w,
+// such that torrents with higher weights claim a larger share of bandwidth. A
+// weight of 1 restores the configured pipeline limit.
+func (d *Dispatcher) SetWeight(w float64) {
+	n := int(math.Round(float64(d.config.PipelineLimit) * w))
+	if n < 1 {
+		n = 1
+	}
+	d.pieceRequestManager.SetPipelineLimit(n)
+}
+
 // Digest returns the blob digest for d's torrent.
 func (d *Dispatcher) Digest() core.Digest {
 	return d.torrent.Digest()
@@ -240,7 +295,7 @@ func (d *Dispatcher) AddPeer(
 	if err != nil {
 		return err
 	}
-	go d.maybeRequestMorePieces(p)
+	d.activity.Go(func() { d.maybeRequestMorePieces(p) })
 	go d.feed(p)
 	return nil
 }
@@ -312,7 +367,7 @@ func (d *Dispatcher) String() string {
 }
 
 func (d *Dispatcher) complete() {
-	d.completeOnce.Do(func() { go d.events.DispatcherComplete(d) })
+	d.completeOnce.Do(func() { d.activity.Go(func() { d.events.DispatcherComplete(d) }) })
 	d.pendingPiecesDoneOnce.Do(func() { close(d.pendingPiecesDone) })
 
 	d.peers.Range(func(k, v interface{}) bool {
@@ -397,6 +452,7 @@ func (d *Dispatcher) resendFailedPieceRequests() {
 		d.log().Infof("Resending %d failed piece requests", len(failedRequests))
 		d.stats.Counter("piece_request_failures").Inc(int64(len(failedRequests)))
 	}
+	d.recordTimeouts(failedRequests)
 
 	var sent int
 	for _, r := range failedRequests {
@@ -426,11 +482,33 @@ func (d *Dispatcher) resendFailedPieceRequests() {
 	}
 }
 
+// recordTimeouts records each expired request in failedRequests against the
+// reputation of its peer, once per request.
+func (d *Dispatcher) recordTimeouts(failedRequests []piecerequest.Request) {
+	timedOut := make(map[piecerequest.Request]bool)
+	for _, r := range failedRequests {
+		if r.Status != piecerequest.StatusExpired {
+			continue
+		}
+		timedOut[r] = true
+		if !d.timedOut[r] {
+			d.reputation.RequestTimeout(r.PeerID)
+		}
+	}
+	d.timedOut = timedOut
+}
+
 func (d *Dispatcher) watchPendingPieceRequests() {
+	// The timer is stopped on exit, such that no tick is left unhandled once
+	// all pieces are done.
+	timer := d.clk.Timer(d.pieceRequestTimeout / 2)
+	defer timer.Stop()
 	for {
 		select {
-		case <-d.clk.After(d.pieceRequestTimeout / 2):
+		case <-timer.C:
 			d.resendFailedPieceRequests()
+			timer.Reset(d.pieceRequestTimeout / 2)
+			d.activity.Done()
 		case <-d.pendingPiecesDone:
 			return
 		}
@@ -444,9 +522,11 @@ func (d *Dispatcher) feed(p *peer) {
 		if err := d.dispatch(p, msg); err != nil {
 			d.log().Errorf("Error dispatching message: %s", err)
 		}
+		d.activity.Done()
 	}
 	d.removePeer(p)
 	d.events.PeerRemoved(p.id, d.torrent.InfoHash())
+	d.activity.Done()
 }
 
 func (d *Dispatcher) dispatch(p *peer, msg *conn.Message) error {
@@ -458,7 +538,7 @@ func (d *Dispatcher) dispatch(p *peer, msg *conn.Message) error {
 	case p2p.Message_PIECE_REQUEST:
 		d.handlePieceRequest(p, msg.Message.PieceRequest)
 	case p2p.Message_PIECE_PAYLOAD:
-		d.handlePiecePayload(p, msg.Message.PiecePayload, msg.Payload)
+		d.handlePiecePayload(p, msg.Message.PiecePayload, msg.Payload, msg.TransferTime)
 	case p2p.Message_CANCEL_PIECE:
 		d.handleCancelPiece(p, msg.Message.CancelPiece)
 	case p2p.Message_BITFIELD:
@@ -526,7 +606,7 @@ func (d *Dispatcher) handlePieceRequest(p *peer, msg *p2p.PieceRequestMessage) {
 }
 
 func (d *Dispatcher) handlePiecePayload(
-	p *peer, msg *p2p.PiecePayloadMessage, payload storage.PieceReader) {
+	p *peer, msg *p2p.PiecePayloadMessage, payload storage.PieceReader, transferTime time.Duration) {
 
 	defer payload.Close()
 
@@ -541,12 +621,17 @@ func (d *Dispatcher) handlePiecePayload(
 		if err != storage.ErrPieceComplete {
 			d.log("peer", p, "piece", i).Errorf("Error writing piece payload: %s", err)
 			d.pieceRequestManager.MarkInvalid(p.id, i)
+			if err == storage.ErrPieceInvalid {
+				d.reputation.InvalidPiece(p.id)
+			}
 		} else {
 			p.pstats.incrementDuplicatePiecesReceived()
 		}
 		return
 	}
 
+	d.reputation.GoodPiece(p.id, d.torrent.PieceLength(i), transferTime)
+
 	d.netevents.Produce(
 		networkevent.ReceivePieceEvent(d.torrent.InfoHash(), d.localPeerID, p.id, i))
 
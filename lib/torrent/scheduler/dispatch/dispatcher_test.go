@@ -23,12 +23,15 @@ import (
 	"github.com/uber/kraken/gen/go/proto/p2p"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler/conn"
+	"github.com/uber/kraken/lib/torrent/scheduler/dispatch/piecerequest"
+	"github.com/uber/kraken/lib/torrent/scheduler/reputation"
 	"github.com/uber/kraken/lib/torrent/scheduler/torrentlog"
 	"github.com/uber/kraken/lib/torrent/storage"
 	"github.com/uber/kraken/lib/torrent/storage/agentstorage"
 	"github.com/uber/kraken/lib/torrent/storage/piecereader"
 	"github.com/uber/kraken/utils/bitsetutil"
 	"github.com/uber/kraken/utils/memsize"
+	"github.com/uber/kraken/utils/testutil"
 	"go.uber.org/zap"
 
 	"github.com/andres-erbsen/clock"
@@ -38,13 +41,17 @@ import (
 )
 
 type mockMessages struct {
-	sent     []*conn.Message
-	receiver chan *conn.Message
-	closed   bool
+	sent         []*conn.Message
+	receiver     chan *conn.Message
+	capabilities conn.Capabilities
+	closed       bool
 }
 
-func newMockMessages() *mockMessages {
-	return &mockMessages{receiver: make(chan *conn.Message)}
+func newMockMessages(cs ...conn.Capability) *mockMessages {
+	return &mockMessages{
+		receiver:     make(chan *conn.Message),
+		capabilities: conn.NewCapabilities(cs...),
+	}
 }
 
 func (m *mockMessages) Send(msg *conn.Message) error {
@@ -57,6 +64,8 @@ func (m *mockMessages) Send(msg *conn.Message) error {
 
 func (m *mockMessages) Receiver() <-chan *conn.Message { return m.receiver }
 
+func (m *mockMessages) Capabilities() conn.Capabilities { return m.capabilities }
+
 func (m *mockMessages) Close() {
 	if m.closed {
 		return
@@ -111,6 +120,7 @@ func testDispatcher(config Config, clk clock.Clock, t storage.Torrent) *Dispatch
 		clk,
 		networkevent.NewTestProducer(),
 		noopEvents{},
+		reputation.New(reputation.Config{}, clk),
 		core.PeerIDFixture(),
 		t,
 		zap.NewNop().Sugar(),
@@ -472,3 +482,103 @@ func TestDispatcherPeerPieceCounts(t *testing.T) {
 	require.Equal(1, d.numPeersByPiece.Get(1))
 	require.Equal(2, d.numPeersByPiece.Get(2))
 }
+
+func TestDispatcherPeerCapabilities(t *testing.T) {
+	require := require.New(t)
+
+	blob := core.SizedBlobFixture(1, 1)
+
+	torrent, cleanup := agentstorage.TorrentFixture(blob.MetaInfo)
+	defer cleanup()
+
+	d := testDispatcher(Config{}, clock.NewMock(), torrent)
+
+	legacy, err := d.addPeer(core.PeerIDFixture(), bitsetutil.FromBools(false), newMockMessages())
+	require.NoError(err)
+	require.False(legacy.supports(conn.CapabilityCancel))
+
+	p, err := d.addPeer(
+		core.PeerIDFixture(), bitsetutil.FromBools(false), newMockMessages(conn.CapabilityCancel))
+	require.NoError(err)
+	require.True(p.supports(conn.CapabilityCancel))
+	require.False(p.supports(conn.CapabilityPEX))
+}
+
+func TestDispatcherCorruptPiecePayloadMarksRequestInvalid(t *testing.T) {
+	require := require.New(t)
+
+	blob := core.SizedBlobFixture(4, 1)
+
+	torrent, cleanup := agentstorage.TorrentFixture(blob.MetaInfo)
+	defer cleanup()
+
+	d := testDispatcher(Config{}, clock.NewMock(), torrent)
+
+	config := conn.ConfigFixture()
+	config.FaultInjection = conn.FaultConfig{
+		Enable: true,
+		Faults: []conn.Fault{{Type: conn.FaultCorrupt}},
+	}
+	local, remote, cleanupConns := conn.PipeFixture(config, torrent.Stat())
+	defer cleanupConns()
+
+	// Remote serves every requested piece, but corrupts each payload on send.
+	go func() {
+		for msg := range remote.Receiver() {
+			if msg.Message.Type != p2p.Message_PIECE_REQUEST {
+				continue
+			}
+			i := int(msg.Message.PieceRequest.Index)
+			remote.Send(conn.NewPiecePayloadMessage(i, piecereader.NewBuffer(blob.Content[i:i+1])))
+		}
+	}()
+
+	peerID := core.PeerIDFixture()
+	require.NoError(d.AddPeer(peerID, bitsetutil.FromBools(true, true, true, true), local))
+
+	// Every request sent to the peer should be marked invalid, rather than
+	// writing the corrupt payloads.
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		failed := d.pieceRequestManager.GetFailedRequests()
+		for _, r := range failed {
+			if r.PeerID != peerID || r.Status != piecerequest.StatusInvalid {
+				return false
+			}
+		}
+		return len(failed) == d.config.PipelineLimit
+	}))
+	require.Equal(uint(0), torrent.Bitfield().Count())
+	require.True(d.reputation.Score(peerID) < 0.1)
+}
+
+func TestDispatcherDump(t *testing.T) {
+	require := require.New(t)
+
+	blob := core.SizedBlobFixture(3, 1)
+
+	torrent, cleanup := agentstorage.TorrentFixture(blob.MetaInfo)
+	defer cleanup()
+	require.NoError(torrent.WritePiece(piecereader.NewBuffer(blob.Content[2:3]), 2))
+
+	d := testDispatcher(Config{}, clock.NewMock(), torrent)
+
+	p, err := d.addPeer(core.PeerIDFixture(), bitsetutil.FromBools(true, true, false), newMockMessages())
+	require.NoError(err)
+	_, err = d.maybeRequestMorePieces(p)
+	require.NoError(err)
+
+	dump := d.Dump()
+	require.Equal(torrent.InfoHash(), dump.InfoHash)
+	require.Equal("001", dump.Bitfield)
+	require.False(dump.Complete)
+	require.Len(dump.Peers, 1)
+	require.Equal(p.id, dump.Peers[0].PeerID)
+	require.Equal("110", dump.Peers[0].Bitfield)
+	require.Equal(2, dump.Peers[0].PieceRequestsSent)
+	require.Len(dump.Requests, 2)
+	for i, r := range dump.Requests {
+		require.Equal(i, r.Piece)
+		require.Equal(p.id, r.PeerID)
+		require.Equal("pending", r.Status)
+	}
+}
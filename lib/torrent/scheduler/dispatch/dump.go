@@ -0,0 +1,117 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package dispatch
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/willf/bitset"
+)
+
+// Dump is a snapshot of Dispatcher state, for debugging purposes.
+type Dump struct {
+	InfoHash      core.InfoHash `json:"info_hash"`
+	Digest        core.Digest   `json:"digest"`
+	Complete      bool          `json:"complete"`
+	Bitfield      string        `json:"bitfield"`
+	CreatedAt     time.Time     `json:"created_at"`
+	LastReadTime  time.Time     `json:"last_read_time"`
+	LastWriteTime time.Time     `json:"last_write_time"`
+	Peers         []PeerDump    `json:"peers"`
+	Requests      []RequestDump `json:"requests"`
+}
+
+// PeerDump is a snapshot of a peer connected to a Dispatcher.
+type PeerDump struct {
+	PeerID                  core.PeerID `json:"peer_id"`
+	Bitfield                string      `json:"bitfield"`
+	LastGoodPieceReceived   time.Time   `json:"last_good_piece_received"`
+	LastPieceSent           time.Time   `json:"last_piece_sent"`
+	PieceRequestsSent       int         `json:"piece_requests_sent"`
+	PieceRequestsReceived   int         `json:"piece_requests_received"`
+	PiecesSent              int         `json:"pieces_sent"`
+	GoodPiecesReceived      int         `json:"good_pieces_received"`
+	DuplicatePiecesReceived int         `json:"duplicate_pieces_received"`
+}
+
+// RequestDump is a snapshot of a piece request sent by a Dispatcher.
+type RequestDump struct {
+	Piece  int         `json:"piece"`
+	PeerID core.PeerID `json:"peer_id"`
+	Status string      `json:"status"`
+	SentAt time.Time   `json:"sent_at"`
+}
+
+// Dump returns a snapshot of d. Peers are sorted by peer id and requests by
+// piece.
+func (d *Dispatcher) Dump() Dump {
+	var peers []PeerDump
+	d.peers.Range(func(k, v interface{}) bool {
+		p := v.(*peer)
+		peers = append(peers, PeerDump{
+			PeerID:                  p.id,
+			Bitfield:                bitfieldString(p.bitfield.Clone()),
+			LastGoodPieceReceived:   p.getLastGoodPieceReceived(),
+			LastPieceSent:           p.getLastPieceSent(),
+			PieceRequestsSent:       p.pstats.getPieceRequestsSent(),
+			PieceRequestsReceived:   p.pstats.getPieceRequestsReceived(),
+			PiecesSent:              p.pstats.getPiecesSent(),
+			GoodPiecesReceived:      p.pstats.getGoodPiecesReceived(),
+			DuplicatePiecesReceived: p.pstats.getDuplicatePiecesReceived(),
+		})
+		return true
+	})
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].PeerID.LessThan(peers[j].PeerID)
+	})
+
+	var requests []RequestDump
+	for _, r := range d.pieceRequestManager.GetRequests() {
+		requests = append(requests, RequestDump{
+			Piece:  r.Piece,
+			PeerID: r.PeerID,
+			Status: r.Status.String(),
+			SentAt: r.SentAt(),
+		})
+	}
+
+	return Dump{
+		InfoHash:      d.torrent.InfoHash(),
+		Digest:        d.torrent.Digest(),
+		Complete:      d.torrent.Complete(),
+		Bitfield:      bitfieldString(d.torrent.Bitfield()),
+		CreatedAt:     d.createdAt,
+		LastReadTime:  d.LastReadTime(),
+		LastWriteTime: d.LastWriteTime(),
+		Peers:         peers,
+		Requests:      requests,
+	}
+}
+
+// bitfieldString formats b as a string of 0s and 1s, one per piece.
+func bitfieldString(b *bitset.BitSet) string {
+	var s strings.Builder
+	for i := uint(0); i < b.Len(); i++ {
+		if b.Test(i) {
+			s.WriteByte('1')
+		} else {
+			s.WriteByte('0')
+		}
+	}
+	return s.String()
+}
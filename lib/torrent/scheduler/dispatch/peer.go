@@ -19,6 +19,7 @@ import (
 
 	"github.com/andres-erbsen/clock"
 	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/scheduler/conn"
 	"github.com/willf/bitset"
 )
 
@@ -32,6 +33,10 @@ type peer struct {
 
 	messages Messages
 
+	// Capabilities negotiated with the remote peer. Messages which require a
+	// capability must not be sent to peers which lack it.
+	capabilities conn.Capabilities
+
 	clk clock.Clock
 
 	// May be accessed outside of the peer struct.
@@ -50,11 +55,12 @@ func newPeer(
 	pstats *peerStats) *peer {
 
 	return &peer{
-		id:       peerID,
-		bitfield: newSyncBitfield(b),
-		messages: messages,
-		clk:      clk,
-		pstats:   pstats,
+		id:           peerID,
+		bitfield:     newSyncBitfield(b),
+		messages:     messages,
+		capabilities: messages.Capabilities(),
+		clk:          clk,
+		pstats:       pstats,
 	}
 }
 
@@ -62,6 +68,11 @@ func (p *peer) String() string {
 	return p.id.String()
 }
 
+// supports returns true if c was negotiated with the remote peer.
+func (p *peer) supports(c conn.Capability) bool {
+	return p.capabilities.Has(c)
+}
+
 func (p *peer) getLastGoodPieceReceived() time.Time {
 	p.mu.Lock()
 	defer p.mu.Unlock()
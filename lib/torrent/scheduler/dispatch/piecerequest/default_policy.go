@@ -25,10 +25,12 @@ import (
 // 默认策略是随机选取请求分片
 const DefaultPolicy = "default"
 
-type defaultPolicy struct{}
+type defaultPolicy struct {
+	rand *rand.Rand // Global source if nil.
+}
 
-func newDefaultPolicy() *defaultPolicy {
-	return &defaultPolicy{}
+func newDefaultPolicy(r *rand.Rand) *defaultPolicy {
+	return &defaultPolicy{rand: r}
 }
 
 func (p *defaultPolicy) selectPieces(
@@ -55,7 +57,7 @@ func (p *defaultPolicy) selectPieces(
 
 			// Replace elements in the 'reservoir' with decreasing probability.
 		} else {
-			j := rand.Intn(k)
+			j := p.intn(k)
 			if j < limit {
 				pieces[j] = int(i)
 			}
@@ -65,3 +67,10 @@ func (p *defaultPolicy) selectPieces(
 
 	return pieces, nil
 }
+
+func (p *defaultPolicy) intn(n int) int {
+	if p.rand == nil {
+		return rand.Intn(n)
+	}
+	return p.rand.Intn(n)
+}
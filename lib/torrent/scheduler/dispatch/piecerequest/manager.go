@@ -15,6 +15,7 @@ package piecerequest
 
 import (
 	"fmt"
+	"math/rand"
 	"sort"
 	"sync"
 	"time"
@@ -45,6 +46,22 @@ const (
 	StatusInvalid
 )
 
+// String returns the name of s.
+func (s Status) String() string {
+	switch s {
+	case StatusPending:
+		return "pending"
+	case StatusExpired:
+		return "expired"
+	case StatusUnsent:
+		return "unsent"
+	case StatusInvalid:
+		return "invalid"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
 // Request represents a piece request to peer.
 type Request struct {
 	Piece  int
@@ -70,12 +87,32 @@ type Manager struct {
 	pipelineLimit int
 }
 
+// Option overrides Manager defaults.
+type Option func(*managerOverrides)
+
+type managerOverrides struct {
+	rand *rand.Rand
+}
+
+// WithRand draws the random choices of piece selection policies from r instead
+// of the global source. Since r is only used under the Manager lock, r may be
+// owned by the caller, e.g. to replay the same choices from the same seed.
+func WithRand(r *rand.Rand) Option {
+	return func(o *managerOverrides) { o.rand = r }
+}
+
 // NewManager creates a new Manager.
 func NewManager(
 	clk clock.Clock,
 	timeout time.Duration,
 	policy string,
-	pipelineLimit int) (*Manager, error) {
+	pipelineLimit int,
+	options ...Option) (*Manager, error) {
+
+	var overrides managerOverrides
+	for _, opt := range options {
+		opt(&overrides)
+	}
 
 	m := &Manager{
 		requests:       make(map[int][]*Request),
@@ -87,7 +124,7 @@ func NewManager(
 
 	switch policy {
 	case DefaultPolicy:
-		m.policy = newDefaultPolicy()
+		m.policy = newDefaultPolicy(overrides.rand)
 	case RarestFirstPolicy:
 		m.policy = newRarestFirstPolicy()
 	default:
@@ -96,6 +133,15 @@ func NewManager(
 	return m, nil
 }
 
+// SetPipelineLimit sets the maximum number of pending requests per peer.
+// Requests which are already pending are not affected.
+func (m *Manager) SetPipelineLimit(n int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.pipelineLimit = n
+}
+
 // ReservePieces selects the next piece(s) to be requested from given peer.
 // It selects peers on a rarity-first basis using numPeersByPiece.
 // If allowDuplicates is set, may return pieces which have already been
@@ -199,6 +245,37 @@ func (m *Manager) ClearPeer(peerID core.PeerID) {
 	}
 }
 
+// GetRequests returns a copy of all piece requests, sorted by piece.
+func (m *Manager) GetRequests() []Request {
+	m.RLock()
+	defer m.RUnlock()
+
+	var requests []Request
+	for _, rs := range m.requests {
+		for _, r := range rs {
+			status := r.Status
+			if status == StatusPending && m.expired(r) {
+				status = StatusExpired
+			}
+			requests = append(requests, Request{
+				Piece:  r.Piece,
+				PeerID: r.PeerID,
+				Status: status,
+				sentAt: r.sentAt,
+			})
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Piece < requests[j].Piece
+	})
+	return requests
+}
+
+// SentAt returns when r was sent.
+func (r Request) SentAt() time.Time {
+	return r.sentAt
+}
+
 // GetFailedRequests returns a copy of all failed piece requests.
 func (m *Manager) GetFailedRequests() []Request {
 	m.RLock()
@@ -62,6 +62,28 @@ func TestManagerPipelineLimit(t *testing.T) {
 	require.Len(m.PendingPieces(peerID), 3)
 }
 
+func TestManagerSetPipelineLimit(t *testing.T) {
+	require := require.New(t)
+
+	m := newManager(clock.NewMock(), 5*time.Second, DefaultPolicy, 3)
+
+	peerID := core.PeerIDFixture()
+	bitfield := bitsetutil.FromBools(true, true, true, true, true, true)
+	counts := countsFromInts(0, 0, 0, 0, 0, 0)
+
+	m.SetPipelineLimit(1)
+	pieces, err := m.ReservePieces(peerID, bitfield, counts, false)
+	require.NoError(err)
+	require.Len(pieces, 1)
+
+	m.SetPipelineLimit(4)
+	pieces, err = m.ReservePieces(peerID, bitfield, counts, false)
+	require.NoError(err)
+	require.Len(pieces, 3)
+
+	require.Len(m.PendingPieces(peerID), 4)
+}
+
 func TestManagerReserveExpiredRequest(t *testing.T) {
 	require := require.New(t)
 
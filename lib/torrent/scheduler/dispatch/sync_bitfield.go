@@ -40,6 +40,13 @@ func (s *syncBitfield) Copy() *bitset.BitSet {
 	return b
 }
 
+func (s *syncBitfield) Clone() *bitset.BitSet {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.b.Clone()
+}
+
 func (s *syncBitfield) Intersection(other *bitset.BitSet) *bitset.BitSet {
 	s.RLock()
 	defer s.RUnlock()
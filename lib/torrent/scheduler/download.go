@@ -0,0 +1,102 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package scheduler
+
+import "fmt"
+
+// Priority is the priority class of a download. A torrent takes the highest
+// priority of all downloads waiting on it, and is apportioned connections and
+// piece requests according to its priority.
+type Priority int
+
+// Priority classes.
+const (
+	// PriorityLow is for background downloads, e.g. preloading.
+	PriorityLow Priority = -1
+
+	// PriorityNormal is the default priority. Torrents without waiting
+	// downloads, e.g. seeding torrents, also have normal priority.
+	PriorityNormal Priority = 0
+
+	// PriorityHigh is for latency-critical downloads, e.g. container starts.
+	PriorityHigh Priority = 1
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+// ParsePriority parses a priority class from its name. The empty string
+// parses to PriorityNormal.
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "low":
+		return PriorityLow, nil
+	case "", "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	default:
+		return 0, fmt.Errorf("invalid priority %q", s)
+	}
+}
+
+// Progress describes how much of a torrent has been downloaded.
+type Progress struct {
+	BytesDownloaded int64
+	Length          int64
+}
+
+// ProgressFunc is called with the progress of a download.
+type ProgressFunc func(Progress)
+
+type downloadOptions struct {
+	priority Priority
+	progress ProgressFunc
+}
+
+// DownloadOption configures a download.
+type DownloadOption func(*downloadOptions)
+
+// WithPriority sets the priority of a download. Defaults to PriorityNormal.
+func WithPriority(p Priority) DownloadOption {
+	return func(o *downloadOptions) { o.priority = p }
+}
+
+// WithProgress subscribes f to the progress of a download. f is called from
+// the goroutine calling Download, periodically while the torrent is downloading
+// and once more when the download succeeds.
+func WithProgress(f ProgressFunc) DownloadOption {
+	return func(o *downloadOptions) { o.progress = f }
+}
+
+// waiter is a client waiting on a torrent to complete.
+type waiter struct {
+	priority Priority
+	errc     chan error
+}
+
+func newWaiter(p Priority) *waiter {
+	// Buffer size of 1 so sends do not block.
+	return &waiter{p, make(chan error, 1)}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package scheduler
+
+import (
+	"sort"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
+	"github.com/uber/kraken/lib/torrent/scheduler/dispatch"
+)
+
+// StateDump is a snapshot of the full scheduler state, for debugging stalled
+// downloads.
+type StateDump struct {
+	PeerID      core.PeerID                 `json:"peer_id"`
+	Time        time.Time                   `json:"time"`
+	Torrents    []TorrentDump               `json:"torrents"`
+	Blacklist   []connstate.BlacklistedConn `json:"blacklist"`
+	Reputations []core.PeerReputation       `json:"reputations"`
+}
+
+// TorrentDump is a snapshot of a single torrent in the scheduler.
+type TorrentDump struct {
+	dispatch.Dump
+
+	Namespace    string               `json:"namespace"`
+	Priority     string               `json:"priority"`
+	LocalRequest bool                 `json:"local_request"`
+	Waiters      int                  `json:"waiters"`
+	MaxConns     int                  `json:"max_conns"`
+	Conns        []connstate.ConnInfo `json:"conns"`
+}
+
+// dump returns a snapshot of s. Torrents are sorted by info hash.
+func (s *state) dump() *StateDump {
+	conns := make(map[core.InfoHash][]connstate.ConnInfo)
+	for _, c := range s.conns.ConnSnapshot() {
+		conns[c.InfoHash] = append(conns[c.InfoHash], c)
+	}
+
+	var torrents []TorrentDump
+	for h, ctrl := range s.torrentControls {
+		tc := conns[h]
+		sort.Slice(tc, func(i, j int) bool {
+			return tc[i].PeerID.LessThan(tc[j].PeerID)
+		})
+		torrents = append(torrents, TorrentDump{
+			Dump:         ctrl.dispatcher.Dump(),
+			Namespace:    ctrl.namespace,
+			Priority:     ctrl.priority.String(),
+			LocalRequest: ctrl.localRequest,
+			Waiters:      len(ctrl.waiters),
+			MaxConns:     s.conns.MaxConns(h),
+			Conns:        tc,
+		})
+	}
+	sort.Slice(torrents, func(i, j int) bool {
+		return torrents[i].InfoHash.Hex() < torrents[j].InfoHash.Hex()
+	})
+
+	return &StateDump{
+		PeerID:      s.sched.pctx.PeerID,
+		Time:        s.sched.clock.Now(),
+		Torrents:    torrents,
+		Blacklist:   s.conns.BlacklistSnapshot(),
+		Reputations: s.sched.reputation.Reports(),
+	}
+}
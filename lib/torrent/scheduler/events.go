@@ -14,6 +14,7 @@
 package scheduler
 
 import (
+	"context"
 	"time"
 
 	"github.com/uber/kraken/core"
@@ -22,7 +23,9 @@ import (
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
 	"github.com/uber/kraken/lib/torrent/scheduler/dispatch"
 	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/tracker/announceclient"
 	"github.com/uber/kraken/utils/memsize"
+	"github.com/uber/kraken/utils/syncutil"
 	"github.com/uber/kraken/utils/timeutil"
 
 	"github.com/willf/bitset"
@@ -45,14 +48,16 @@ type eventLoop interface {
 }
 
 type baseEventLoop struct {
-	events chan event
-	done   chan struct{}
+	events   chan event
+	done     chan struct{}
+	activity *syncutil.Activity // Counts events until they are applied.
 }
 
-func newEventLoop() *baseEventLoop {
+func newEventLoop(activity *syncutil.Activity) *baseEventLoop {
 	return &baseEventLoop{
-		events: make(chan event),
-		done:   make(chan struct{}),
+		events:   make(chan event),
+		done:     make(chan struct{}),
+		activity: activity,
 	}
 }
 
@@ -60,10 +65,12 @@ func newEventLoop() *baseEventLoop {
 // running l (i.e. within apply methods), else deadlock will occur. Returns false
 // if the l is not running.
 func (l *baseEventLoop) send(e event) bool {
+	l.activity.Add(1)
 	select {
 	case l.events <- e:
 		return true
 	case <-l.done:
+		l.activity.Done()
 		return false
 	}
 }
@@ -71,12 +78,15 @@ func (l *baseEventLoop) send(e event) bool {
 func (l *baseEventLoop) sendTimeout(e event, timeout time.Duration) error {
 	timer := time.NewTimer(timeout)
 	defer timer.Stop()
+	l.activity.Add(1)
 	select {
 	case l.events <- e:
 		return nil
 	case <-l.done:
+		l.activity.Done()
 		return ErrSchedulerStopped
 	case <-timer.C:
+		l.activity.Done()
 		return ErrSendEventTimedOut
 	}
 }
@@ -86,6 +96,7 @@ func (l *baseEventLoop) run(s *state) {
 		select {
 		case e := <-l.events:
 			e.apply(s)
+			l.activity.Done()
 		case <-l.done:
 			return
 		}
@@ -163,10 +174,17 @@ func (e incomingHandshakeEvent) apply(s *state) {
 		return
 	}
 	var rb conn.RemoteBitfields
+	var namespace string
 	if ctrl, ok := s.torrentControls[e.pc.InfoHash()]; ok {
 		rb = ctrl.dispatcher.RemoteBitfields()
+		// Only trust the namespace of torrents we requested ourselves, since
+		// torrents added for remote peers take the namespace they sent.
+		if ctrl.localRequest {
+			namespace = ctrl.namespace
+		}
 	}
-	go s.sched.establishIncomingHandshake(e.pc, rb)
+	s.sched.activity.Add(1)
+	go s.sched.establishIncomingHandshake(e.pc, rb, namespace)
 }
 
 // failedIncomingHandshakeEvent occurs when a pending incoming connection fails
@@ -232,11 +250,18 @@ func (e outgoingConnEvent) apply(s *state) {
 // announceTickEvent occurs when it is time to announce to the tracker.
 type announceTickEvent struct{}
 
-// apply pulls the next dispatcher from the announce queue and asynchronously
-// makes an announce request to the tracker.
+// apply pulls the next dispatchers from the announce queue, up to the announce
+// batch size, and asynchronously makes an announce request to the tracker. Due
+// torrents are coalesced into a single batch announce, while bundle leaders are
+// announced along with the rest of their bundle.
 func (e announceTickEvent) apply(s *state) {
+	limit := 1
+	if !s.sched.clock.Now().Before(s.batchUnsupportedUntil) {
+		limit = s.sched.config.AnnounceBatchSize
+	}
 	var skipped []core.InfoHash
-	for {
+	var batch []announceclient.AnnounceTorrent
+	for n := 0; n < limit; {
 		h, ok := s.announceQueue.Next()
 		if !ok {
 			s.log().Debug("No torrents in announce queue")
@@ -252,9 +277,25 @@ func (e announceTickEvent) apply(s *state) {
 			s.log("hash", h).Error("Pulled unknown torrent off announce queue")
 			continue
 		}
-		go s.sched.announce(
-			ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), ctrl.dispatcher.Complete())
-		break
+		n++
+		if b, ok := s.bundleByHash[h]; ok && !b.unsupported && b.hasLeader && b.leader == h {
+			s.announceBundle(b)
+			continue
+		}
+		batch = append(batch, announceclient.AnnounceTorrent{
+			Digest:   ctrl.dispatcher.Digest(),
+			InfoHash: h,
+			Complete: ctrl.dispatcher.Complete(),
+		})
+	}
+	switch len(batch) {
+	case 0:
+	case 1:
+		s.sched.activity.Add(1)
+		go s.sched.announce(batch[0].Digest, batch[0].InfoHash, batch[0].Complete)
+	default:
+		s.sched.activity.Add(1)
+		go s.sched.announceBatch(batch)
 	}
 	// Re-enqueue any torrents we pulled off and ignored, else we would never
 	// announce them again.
@@ -282,28 +323,157 @@ func (e announceResultEvent) apply(s *state) {
 		return
 	}
 	s.announceQueue.Ready(e.infoHash)
-	if ctrl.dispatcher.Complete() {
-		// Torrent is already complete, don't open any new connections.
-		return
+	s.connectPeers(ctrl, e.peers)
+}
+
+// bundleAnnounceResultEvent occurs when a successfully announced bundle response
+// was received from the tracker.
+type bundleAnnounceResultEvent struct {
+	manifest core.Digest
+	leader   core.InfoHash
+	peers    map[core.InfoHash][]*core.PeerInfo
+}
+
+// apply adds the seeders of each torrent to the shared peer set of the bundle,
+// and opens connections to the peer handout of each torrent if there is
+// capacity. Torrents without any peers in their handout, e.g. since no other
+// agent announced them yet, fall back to the shared peer set.
+//
+// Also marks the bundle leader as ready to announce again.
+func (e bundleAnnounceResultEvent) apply(s *state) {
+	s.announceQueue.Ready(e.leader)
+
+	b, ok := s.bundles[e.manifest]
+	if ok {
+		for _, peers := range e.peers {
+			b.addSeeders(peers, s.sched.config.MaxBundlePeers)
+		}
 	}
-	for _, p := range e.peers {
-		if p.PeerID == s.sched.pctx.PeerID {
-			// Tracker may return our own peer.
+	for h, peers := range e.peers {
+		ctrl, ok := s.torrentControls[h]
+		if !ok {
 			continue
 		}
-		if s.conns.Blacklisted(p.PeerID, e.infoHash) {
+		if b != nil {
+			peers = b.fallbackPeers(peers, s.sched.pctx.PeerID)
+		}
+		s.connectPeers(ctrl, peers)
+	}
+}
+
+// bundlesUnsupportedEvent occurs when the tracker does not support bundle
+// announces.
+type bundlesUnsupportedEvent struct {
+	manifest core.Digest
+	leader   core.InfoHash
+}
+
+// apply falls back to announcing each torrent of the bundle individually.
+func (e bundlesUnsupportedEvent) apply(s *state) {
+	s.announceQueue.Ready(e.leader)
+
+	b, ok := s.bundles[e.manifest]
+	if !ok || b.unsupported {
+		return
+	}
+	s.log("manifest", e.manifest).Info("Tracker does not support bundles, announcing torrents individually")
+	b.unsupported = true
+	for h := range b.parked {
+		if ctrl, ok := s.torrentControls[h]; ok && !ctrl.dispatcher.Complete() {
+			s.sched.activity.Add(1)
+			go s.sched.announce(ctrl.dispatcher.Digest(), h, false)
+		}
+	}
+	s.unparkBundle(b)
+}
+
+// batchAnnounceResultEvent occurs when a successfully announced batch response
+// was received from the tracker.
+type batchAnnounceResultEvent struct {
+	torrents []announceclient.AnnounceTorrent
+	peers    map[core.InfoHash][]*core.PeerInfo
+}
+
+// apply opens connections to the peer handout of each torrent in the batch if
+// there is capacity, and marks every torrent of the batch as ready to announce
+// again.
+func (e batchAnnounceResultEvent) apply(s *state) {
+	for _, t := range e.torrents {
+		s.announceQueue.Ready(t.InfoHash)
+		ctrl, ok := s.torrentControls[t.InfoHash]
+		if !ok {
+			s.log("hash", t.InfoHash).Info("Dispatcher closed after announce response received")
 			continue
 		}
-		if err := s.conns.AddPending(p.PeerID, e.infoHash, nil); err != nil {
-			if err == connstate.ErrTorrentAtCapacity {
-				break
-			}
+		s.connectPeers(ctrl, e.peers[t.InfoHash])
+	}
+}
+
+// batchAnnounceErrEvent occurs when a batch announce request fails.
+type batchAnnounceErrEvent struct {
+	torrents []announceclient.AnnounceTorrent
+	err      error
+}
+
+// apply marks every torrent of the batch as ready to announce again.
+func (e batchAnnounceErrEvent) apply(s *state) {
+	s.log("torrents", len(e.torrents)).Errorf("Error announcing batch: %s", e.err)
+	for _, t := range e.torrents {
+		s.announceQueue.Ready(t.InfoHash)
+	}
+}
+
+// batchUnsupportedEvent occurs when the tracker does not support batch
+// announces.
+type batchUnsupportedEvent struct {
+	torrents []announceclient.AnnounceTorrent
+}
+
+// apply disables batch announces for a while, in case the tracker is upgraded,
+// and announces each torrent of the rejected batch individually.
+func (e batchUnsupportedEvent) apply(s *state) {
+	now := s.sched.clock.Now()
+	if !now.Before(s.batchUnsupportedUntil) {
+		s.log().Info("Tracker does not support batch announces, announcing torrents individually")
+		s.batchUnsupportedUntil = now.Add(_batchUnsupportedBackoff)
+	}
+	for _, t := range e.torrents {
+		if _, ok := s.torrentControls[t.InfoHash]; !ok {
+			s.announceQueue.Ready(t.InfoHash)
 			continue
 		}
-		// 和对端 peer 创建连接
-		go s.sched.initializeOutgoingHandshake(
-			p, ctrl.dispatcher.Stat(), ctrl.dispatcher.RemoteBitfields(), ctrl.namespace)
+		s.sched.activity.Add(1)
+		go s.sched.announce(t.Digest, t.InfoHash, t.Complete)
+	}
+}
+
+// streamedPeerEvent occurs when the tracker pushes a new seeder on the peer
+// stream of a torrent.
+type streamedPeerEvent struct {
+	infoHash core.InfoHash
+	peer     *core.PeerInfo
+}
+
+// apply opens a connection to the seeder if there is capacity.
+func (e streamedPeerEvent) apply(s *state) {
+	ctrl, ok := s.torrentControls[e.infoHash]
+	if !ok {
+		return
+	}
+	s.connectPeers(ctrl, []*core.PeerInfo{e.peer})
+}
+
+// peerStreamsUnsupportedEvent occurs when the tracker does not support peer
+// streams.
+type peerStreamsUnsupportedEvent struct{}
+
+// apply stops updating the peer stream, relying on announces alone for peers.
+func (e peerStreamsUnsupportedEvent) apply(s *state) {
+	if s.peerStreamsUnsupported {
+		return
 	}
+	s.log().Info("Tracker does not support peer streams, relying on announces for peers")
+	s.peerStreamsUnsupported = true
 }
 
 // announceErrEvent occurs when an announce request fails.
@@ -322,7 +492,7 @@ func (e announceErrEvent) apply(s *state) {
 type newTorrentEvent struct {
 	namespace string
 	torrent   storage.Torrent
-	errc      chan error
+	waiter    *waiter
 }
 
 // apply begins seeding / leeching a new torrent.
@@ -332,23 +502,99 @@ func (e newTorrentEvent) apply(s *state) {
 		var err error
 		ctrl, err = s.addTorrent(e.namespace, e.torrent, true)
 		if err != nil {
-			e.errc <- err
+			e.waiter.errc <- err
 			return
 		}
 		s.log("torrent", e.torrent).Info("Added new torrent")
 	}
 	if ctrl.dispatcher.Complete() {
-		e.errc <- nil
+		e.waiter.errc <- nil
 		return
 	}
-	// 任务下载完成/关闭/remove的时候会往 ctrl.errors中发送nil，这样外面的下载任务方法才会返回
-	ctrl.errors = append(ctrl.errors, e.errc)
+	// 任务下载完成/关闭/remove的时候会通知 ctrl.waiters，这样外面的下载任务方法才会返回
+	s.addWaiter(ctrl, e.waiter)
 
 	// Immediately announce new torrents.
 	// 开始真正下载数据
+	s.sched.activity.Add(1)
 	go s.sched.announce(ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), ctrl.dispatcher.Complete())
 }
 
+// cancelDownloadEvent occurs when a client stops waiting on a torrent, e.g.
+// because its context was cancelled.
+type cancelDownloadEvent struct {
+	infoHash core.InfoHash
+	waiter   *waiter
+}
+
+// apply unregisters the waiter, and removes the torrent if no other clients
+// are waiting on it.
+func (e cancelDownloadEvent) apply(s *state) {
+	ctrl, ok := s.torrentControls[e.infoHash]
+	if !ok {
+		return
+	}
+	if !s.removeWaiter(ctrl, e.waiter) {
+		return
+	}
+	if len(ctrl.waiters) == 0 && !ctrl.dispatcher.Complete() {
+		s.log("hash", e.infoHash).Info("Removing torrent with no remaining downloads")
+		s.removeTorrent(e.infoHash, context.Canceled)
+	}
+}
+
+// newBundleEvent occurs when a bundle of torrents was requested for download.
+type newBundleEvent struct {
+	namespace string
+	manifest  core.Digest
+	torrents  []storage.Torrent
+	waiters   []*waiter
+}
+
+// apply begins leeching all torrents of a bundle, each notifying its own waiter.
+func (e newBundleEvent) apply(s *state) {
+	b := s.addBundle(e.manifest, e.torrents)
+	for i, t := range e.torrents {
+		ctrl, ok := s.torrentControls[t.InfoHash()]
+		if !ok {
+			var err error
+			ctrl, err = s.addTorrent(e.namespace, t, true)
+			if err != nil {
+				e.waiters[i].errc <- err
+				continue
+			}
+			s.log("torrent", t, "manifest", e.manifest).Info("Added new bundle torrent")
+		}
+		if ctrl.dispatcher.Complete() {
+			e.waiters[i].errc <- nil
+			continue
+		}
+		s.addWaiter(ctrl, e.waiters[i])
+	}
+
+	// Immediately announce new bundles.
+	if !b.unsupported && b.hasLeader {
+		s.announceBundle(b)
+		return
+	}
+	for _, t := range e.torrents {
+		if ctrl, ok := s.torrentControls[t.InfoHash()]; ok && !ctrl.dispatcher.Complete() {
+			s.sched.activity.Add(1)
+			go s.sched.announce(ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), false)
+		}
+	}
+}
+
+// removeBundleEvent occurs when a bundle download returns.
+type removeBundleEvent struct {
+	manifest core.Digest
+}
+
+// apply releases the bundle once no other downloads of it remain.
+func (e removeBundleEvent) apply(s *state) {
+	s.removeBundle(e.manifest)
+}
+
 // dispatcherCompleteEvent occurs when a dispatcher finishes downloading its torrent.
 type dispatcherCompleteEvent struct {
 	dispatcher *dispatch.Dispatcher
@@ -359,15 +605,14 @@ func (e dispatcherCompleteEvent) apply(s *state) {
 	infoHash := e.dispatcher.InfoHash()
 
 	s.conns.ClearBlacklist(infoHash)
-	s.announceQueue.Eject(infoHash)
+	s.ejectFromAnnounceQueue(infoHash)
 	ctrl, ok := s.torrentControls[infoHash]
 	if !ok {
 		s.log("dispatcher", e.dispatcher).Error("Completed dispatcher not found")
 		return
 	}
-	for _, errc := range ctrl.errors {
-		errc <- nil
-	}
+	s.notifyWaiters(ctrl, nil)
+	s.updatePeerStream()
 	if ctrl.localRequest {
 		// Normalize the download time for all torrent sizes to a per MB value.
 		// Skip torrents that are less than a MB in size because we can't measure
@@ -380,9 +625,12 @@ func (e dispatcherCompleteEvent) apply(s *state) {
 	}
 
 	s.log("hash", infoHash).Info("Torrent complete")
-	s.sched.netevents.Produce(networkevent.TorrentCompleteEvent(infoHash, s.sched.pctx.PeerID))
+	event := networkevent.TorrentCompleteEvent(infoHash, s.sched.pctx.PeerID)
+	event.Namespace = ctrl.namespace
+	s.sched.netevents.Produce(event)
 
 	// Immediately announce completed torrents.
+	s.sched.activity.Add(1)
 	go s.sched.announce(ctrl.dispatcher.Digest(), ctrl.dispatcher.InfoHash(), true)
 }
 
@@ -452,6 +700,24 @@ type emitStatsEvent struct{}
 
 func (e emitStatsEvent) apply(s *state) {
 	s.sched.stats.Gauge("torrents").Update(float64(len(s.torrentControls)))
+
+	now := s.sched.clock.Now()
+	var uploads int
+	for _, c := range s.conns.ActiveConns() {
+		ctrl, ok := s.torrentControls[c.InfoHash()]
+		if !ok {
+			continue
+		}
+		if now.Sub(ctrl.dispatcher.LastPieceSent(c.PeerID())) < s.sched.config.ActiveUploadWindow {
+			uploads++
+		}
+	}
+	s.sched.stats.Gauge("active_uploads").Update(float64(uploads))
+	s.sched.load.sample(
+		now,
+		s.sched.handshaker.EgressBytes(),
+		s.sched.handshaker.BandwidthSnapshot().EgressBitsPerSec,
+		uploads)
 }
 
 type blacklistSnapshotEvent struct {
@@ -462,6 +728,15 @@ func (e blacklistSnapshotEvent) apply(s *state) {
 	e.result <- s.conns.BlacklistSnapshot()
 }
 
+// dumpEvent occurs when a state dump is requested via scheduler API.
+type dumpEvent struct {
+	result chan *StateDump
+}
+
+func (e dumpEvent) apply(s *state) {
+	e.result <- s.dump()
+}
+
 // removeTorrentEvent occurs when a torrent is manually removed via scheduler API.
 type removeTorrentEvent struct {
 	digest core.Digest
@@ -499,8 +774,8 @@ func (e shutdownEvent) apply(s *state) {
 	// Notify local clients of pending torrents that they will not complete.
 	for _, ctrl := range s.torrentControls {
 		ctrl.dispatcher.TearDown()
-		for _, errc := range ctrl.errors {
-			errc <- ErrSchedulerStopped
+		for _, w := range ctrl.waiters {
+			w.errc <- ErrSchedulerStopped
 		}
 	}
 	s.sched.eventLoop.stop()
@@ -93,6 +93,9 @@ func newStateMocks(t *testing.T) (*stateMocks, func()) {
 }
 
 func (m *stateMocks) newState(config Config) *state {
+	// Peer streams are opened asynchronously, which would race with announce
+	// expectations.
+	config.DisablePeerStreams = true
 	sched, err := newScheduler(
 		config,
 		m.torrentArchive,
@@ -127,7 +130,7 @@ func TestAnnounceTickEvent(t *testing.T) {
 	mocks, cleanup := newStateMocks(t)
 	defer cleanup()
 
-	state := mocks.newState(Config{})
+	state := mocks.newState(Config{AnnounceBatchSize: 1})
 
 	var ctrls []*torrentControl
 	for i := 0; i < 5; i++ {
@@ -152,6 +155,87 @@ func TestAnnounceTickEvent(t *testing.T) {
 	})
 }
 
+func TestAnnounceTickEventBatchesDueTorrents(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStateMocks(t)
+	defer cleanup()
+
+	state := mocks.newState(Config{AnnounceBatchSize: 3})
+
+	var ctrls []*torrentControl
+	for i := 0; i < 5; i++ {
+		c, err := state.addTorrent(_testNamespace, mocks.newTorrent(), true)
+		require.NoError(err)
+		ctrls = append(ctrls, c)
+	}
+
+	// First three torrents should announce in a single batch.
+	var torrents []announceclient.AnnounceTorrent
+	for _, c := range ctrls[:3] {
+		torrents = append(torrents, announceclient.AnnounceTorrent{
+			Digest:   c.dispatcher.Digest(),
+			InfoHash: c.dispatcher.InfoHash(),
+		})
+	}
+	mocks.announceClient.EXPECT().
+		AnnounceBatch(torrents).
+		Return(nil, time.Second, nil)
+
+	announceTickEvent{}.apply(state)
+
+	mocks.eventLoop.expect(batchAnnounceResultEvent{torrents: torrents})
+}
+
+func TestBatchUnsupportedEventAnnouncesIndividually(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newStateMocks(t)
+	defer cleanup()
+
+	state := mocks.newState(Config{AnnounceBatchSize: 3})
+
+	var ctrls []*torrentControl
+	for i := 0; i < 2; i++ {
+		c, err := state.addTorrent(_testNamespace, mocks.newTorrent(), true)
+		require.NoError(err)
+		ctrls = append(ctrls, c)
+	}
+
+	var torrents []announceclient.AnnounceTorrent
+	for _, c := range ctrls {
+		torrents = append(torrents, announceclient.AnnounceTorrent{
+			Digest:   c.dispatcher.Digest(),
+			InfoHash: c.dispatcher.InfoHash(),
+		})
+	}
+	mocks.announceClient.EXPECT().
+		AnnounceBatch(torrents).
+		Return(nil, time.Duration(0), announceclient.ErrBatchUnsupported)
+
+	announceTickEvent{}.apply(state)
+
+	mocks.eventLoop.expect(batchUnsupportedEvent{torrents})
+
+	for _, c := range ctrls {
+		mocks.announceClient.EXPECT().
+			Announce(
+				c.dispatcher.Digest(),
+				c.dispatcher.InfoHash(),
+				false,
+				announceclient.V2).
+			Return(nil, time.Second, nil)
+	}
+
+	batchUnsupportedEvent{torrents}.apply(state)
+	require.True(state.sched.clock.Now().Before(state.batchUnsupportedUntil))
+
+	for i := 0; i < len(ctrls); i++ {
+		e := <-mocks.eventLoop.c
+		require.IsType(announceResultEvent{}, e)
+	}
+}
+
 func TestAnnounceTickEventSkipsFullTorrents(t *testing.T) {
 	require := require.New(t)
 
@@ -0,0 +1,66 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package scheduler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+)
+
+// LoadMeter measures the upload load of the local peer, such that it may be
+// reported to the tracker in announces. LoadMeter implements
+// announceclient.LoadReporter.
+type LoadMeter struct {
+	mu              sync.Mutex
+	load            core.PeerLoad
+	lastEgressBytes int64
+	lastSample      time.Time
+}
+
+// NewLoadMeter creates a new LoadMeter.
+func NewLoadMeter() *LoadMeter {
+	return &LoadMeter{}
+}
+
+// Load returns the most recently measured load.
+func (m *LoadMeter) Load() *core.PeerLoad {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	load := m.load
+	return &load
+}
+
+// sample updates the measured load given the total bytes sent at now, the
+// egress limit (zero if unlimited) and the number of conns actively uploading.
+func (m *LoadMeter) sample(
+	now time.Time, egressBytes int64, egressLimit uint64, activeUploads int) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.lastSample.IsZero() && now.After(m.lastSample) && egressBytes >= m.lastEgressBytes {
+		elapsed := now.Sub(m.lastSample).Seconds()
+		m.load.EgressBitsPerSec = uint64(float64(egressBytes-m.lastEgressBytes) * 8 / elapsed)
+		m.load.EgressUtilization = 0
+		if egressLimit > 0 {
+			m.load.EgressUtilization = float64(m.load.EgressBitsPerSec) / float64(egressLimit)
+		}
+	}
+	m.load.ActiveUploads = activeUploads
+	m.lastEgressBytes = egressBytes
+	m.lastSample = now
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMeterSample(t *testing.T) {
+	require := require.New(t)
+
+	m := NewLoadMeter()
+	require.Equal(uint64(0), m.Load().EgressBitsPerSec)
+
+	now := time.Now()
+	m.sample(now, 1000, 16000, 1)
+	require.Equal(uint64(0), m.Load().EgressBitsPerSec)
+	require.Equal(1, m.Load().ActiveUploads)
+
+	m.sample(now.Add(time.Second), 2000, 16000, 3)
+	load := m.Load()
+	require.Equal(uint64(8000), load.EgressBitsPerSec)
+	require.Equal(0.5, load.EgressUtilization)
+	require.Equal(3, load.ActiveUploads)
+}
+
+func TestLoadMeterSampleUnlimitedEgress(t *testing.T) {
+	require := require.New(t)
+
+	m := NewLoadMeter()
+	now := time.Now()
+	m.sample(now, 0, 0, 0)
+	m.sample(now.Add(2*time.Second), 1000, 0, 0)
+
+	load := m.Load()
+	require.Equal(uint64(4000), load.EgressBitsPerSec)
+	require.Equal(0.0, load.EgressUtilization)
+}
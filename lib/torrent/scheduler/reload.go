@@ -57,7 +57,8 @@ func (rs *reloadableScheduler) reload(config Config) error {
 	s.Stop()
 
 	n, err := newScheduler(
-		config, s.torrentArchive, s.stats, s.pctx, s.announceClient, s.netevents)
+		config, s.torrentArchive, s.stats, s.pctx, s.announceClient, s.netevents,
+		WithTLS(s.tls), WithPeerTokens(s.tokens, s.tokenVerifier), WithLoadMeter(s.load))
 	if err != nil {
 		return fmt.Errorf("create new scheduler: %s", err)
 	}
@@ -0,0 +1,86 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package reputation
+
+import (
+	"time"
+
+	"github.com/uber/kraken/utils/memsize"
+)
+
+// Config defines Table configuration.
+type Config struct {
+
+	// HalfLife is the duration after which past behavior of a peer counts half
+	// as much towards its score.
+	HalfLife time.Duration `yaml:"half_life"`
+
+	// InvalidPieceWeight is the weight of receiving an invalid piece payload,
+	// relative to receiving a valid one.
+	InvalidPieceWeight float64 `yaml:"invalid_piece_weight"`
+
+	// TimeoutWeight is the weight of a piece request timing out, relative to
+	// receiving a valid piece payload.
+	TimeoutWeight float64 `yaml:"timeout_weight"`
+
+	// SlowPieceBitsPerSec is the throughput below which a valid piece payload
+	// counts as slow. Slow pieces count against a peer with SlowPieceWeight.
+	SlowPieceBitsPerSec uint64 `yaml:"slow_piece_bits_per_sec"`
+
+	// SlowPieceWeight is the weight of receiving a slow piece payload, relative
+	// to receiving a fast one.
+	SlowPieceWeight float64 `yaml:"slow_piece_weight"`
+
+	// ReportThreshold is the score below which peers are reported to the tracker.
+	ReportThreshold float64 `yaml:"report_threshold"`
+
+	// MaxReports is the maximum number of peers reported per announce. The
+	// lowest scoring peers are reported first.
+	MaxReports int `yaml:"max_reports"`
+
+	// MaxPeers is the maximum number of peers scores are kept for. When full,
+	// the highest scoring peers are evicted first.
+	MaxPeers int `yaml:"max_peers"`
+
+	// DisableReports disables reporting scores to the tracker.
+	DisableReports bool `yaml:"disable_reports"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.HalfLife == 0 {
+		c.HalfLife = 10 * time.Minute
+	}
+	if c.InvalidPieceWeight == 0 {
+		c.InvalidPieceWeight = 5
+	}
+	if c.TimeoutWeight == 0 {
+		c.TimeoutWeight = 1
+	}
+	if c.SlowPieceBitsPerSec == 0 {
+		c.SlowPieceBitsPerSec = 8 * memsize.Mbit
+	}
+	if c.SlowPieceWeight == 0 {
+		c.SlowPieceWeight = 0.5
+	}
+	if c.ReportThreshold == 0 {
+		c.ReportThreshold = 0.9
+	}
+	if c.MaxReports == 0 {
+		c.MaxReports = 20
+	}
+	if c.MaxPeers == 0 {
+		c.MaxPeers = 10000
+	}
+	return c
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package reputation
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+)
+
+// _minWeight is the combined weight below which a peer's history has decayed
+// enough to be forgotten.
+const _minWeight = 0.01
+
+// _reportsTTL is how long Reports are reused, such that the many announces of
+// a single announce tick only compute them once.
+const _reportsTTL = time.Second
+
+// Table keeps decaying reputation scores of the peers we receive pieces from.
+// Scores are derived from how many valid, invalid, slow and timed out piece
+// requests each peer served, where past outcomes count exponentially less
+// over time. Table is thread-safe.
+type Table struct {
+	config Config
+	clk    clock.Clock
+
+	mu        sync.Mutex
+	peers     map[core.PeerID]*record
+	reports   []core.PeerReputation
+	reportsAt time.Time
+}
+
+// New creates a new Table.
+func New(config Config, clk clock.Clock) *Table {
+	return &Table{
+		config: config.applyDefaults(),
+		clk:    clk,
+		peers:  make(map[core.PeerID]*record),
+	}
+}
+
+// record is the decayed weight of good and bad outcomes from a single peer.
+type record struct {
+	good      float64
+	bad       float64
+	updatedAt time.Time
+}
+
+func (r *record) decay(now time.Time, halfLife time.Duration) {
+	if elapsed := now.Sub(r.updatedAt); elapsed > 0 {
+		f := math.Pow(0.5, float64(elapsed)/float64(halfLife))
+		r.good *= f
+		r.bad *= f
+	}
+	r.updatedAt = now
+}
+
+// score is the ratio of good outcomes, with one implicit good outcome such
+// that unknown peers start at 1.
+func (r *record) score() float64 {
+	return (r.good + 1) / (r.good + r.bad + 1)
+}
+
+// GoodPiece records that peerID served a valid piece of size bytes, which took
+// transferTime to arrive once it started arriving. Time spent queued or
+// throttled locally must not be included, since it is not the fault of peerID.
+func (t *Table) GoodPiece(peerID core.PeerID, size int64, transferTime time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r := t.get(peerID)
+	if transferTime > 0 &&
+		float64(size*8)/transferTime.Seconds() < float64(t.config.SlowPieceBitsPerSec) {
+		r.good += 1 - t.config.SlowPieceWeight
+		r.bad += t.config.SlowPieceWeight
+		return
+	}
+	r.good++
+}
+
+// InvalidPiece records that peerID served an invalid piece.
+func (t *Table) InvalidPiece(peerID core.PeerID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.get(peerID).bad += t.config.InvalidPieceWeight
+}
+
+// RequestTimeout records that a piece request to peerID timed out.
+func (t *Table) RequestTimeout(peerID core.PeerID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.get(peerID).bad += t.config.TimeoutWeight
+}
+
+// Score returns the current score of peerID, between 0 and 1. Peers without
+// any recorded outcomes score 1.
+func (t *Table) Score(peerID core.PeerID) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r, ok := t.peers[peerID]
+	if !ok {
+		return 1
+	}
+	r.decay(t.clk.Now(), t.config.HalfLife)
+	return r.score()
+}
+
+// Reports returns the lowest scoring peers below the report threshold, in
+// increasing order of score. Returns nil if reports are disabled. Reports are
+// recomputed at most once per second, and the result must not be modified.
+func (t *Table) Reports() []core.PeerReputation {
+	if t.config.DisableReports {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clk.Now()
+	if !t.reportsAt.IsZero() && now.Sub(t.reportsAt) < _reportsTTL {
+		return t.reports
+	}
+	var reports []core.PeerReputation
+	for peerID, r := range t.peers {
+		r.decay(now, t.config.HalfLife)
+		if r.good+r.bad < _minWeight {
+			delete(t.peers, peerID)
+			continue
+		}
+		if s := r.score(); s < t.config.ReportThreshold {
+			reports = append(reports, core.PeerReputation{PeerID: peerID, Score: s})
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Score < reports[j].Score
+	})
+	if len(reports) > t.config.MaxReports {
+		reports = reports[:t.config.MaxReports]
+	}
+	t.reports, t.reportsAt = reports, now
+	return reports
+}
+
+// get returns the decayed record of peerID, creating it if necessary.
+func (t *Table) get(peerID core.PeerID) *record {
+	now := t.clk.Now()
+	r, ok := t.peers[peerID]
+	if !ok {
+		if len(t.peers) >= t.config.MaxPeers {
+			t.evict(now)
+		}
+		r = &record{updatedAt: now}
+		t.peers[peerID] = r
+	}
+	r.decay(now, t.config.HalfLife)
+	return r
+}
+
+// evict removes the highest scoring peer, which carries the least information
+// about misbehaving peers.
+func (t *Table) evict(now time.Time) {
+	var best core.PeerID
+	bestScore := -1.0
+	for peerID, r := range t.peers {
+		r.decay(now, t.config.HalfLife)
+		if s := r.score(); s > bestScore {
+			best, bestScore = peerID, s
+		}
+	}
+	delete(t.peers, best)
+}
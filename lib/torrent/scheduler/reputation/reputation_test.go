@@ -0,0 +1,150 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package reputation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/memsize"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableUnknownPeerScoresOne(t *testing.T) {
+	table := New(Config{}, clock.NewMock())
+	require.Equal(t, 1.0, table.Score(core.PeerIDFixture()))
+}
+
+func TestTableInvalidPieceLowersScore(t *testing.T) {
+	require := require.New(t)
+
+	table := New(Config{}, clock.NewMock())
+
+	good := core.PeerIDFixture()
+	bad := core.PeerIDFixture()
+
+	for i := 0; i < 10; i++ {
+		table.GoodPiece(good, int64(memsize.MB), time.Millisecond)
+	}
+	table.InvalidPiece(bad)
+
+	require.Equal(1.0, table.Score(good))
+	require.InDelta(1.0/6, table.Score(bad), 0.001)
+}
+
+func TestTableTimeoutsAndSlowPiecesLowerScore(t *testing.T) {
+	require := require.New(t)
+
+	table := New(Config{SlowPieceBitsPerSec: 8 * memsize.Mbit}, clock.NewMock())
+
+	timeout := core.PeerIDFixture()
+	slow := core.PeerIDFixture()
+
+	table.RequestTimeout(timeout)
+	// 1MB in 2 seconds.
+	table.GoodPiece(slow, int64(memsize.MB), 2*time.Second)
+
+	require.InDelta(0.5, table.Score(timeout), 0.001)
+	require.InDelta(0.75, table.Score(slow), 0.001)
+}
+
+func TestTableScoresDecay(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	config := Config{HalfLife: time.Minute}
+	table := New(config, clk)
+
+	p := core.PeerIDFixture()
+	table.InvalidPiece(p)
+	s1 := table.Score(p)
+
+	clk.Add(config.HalfLife)
+	s2 := table.Score(p)
+	require.True(s2 > s1)
+
+	clk.Add(20 * config.HalfLife)
+	require.InDelta(1.0, table.Score(p), 0.001)
+
+	// Fully decayed peers are forgotten.
+	require.Empty(table.Reports())
+	require.Empty(table.peers)
+}
+
+func TestTableReports(t *testing.T) {
+	require := require.New(t)
+
+	table := New(Config{MaxReports: 2}, clock.NewMock())
+
+	p1 := core.PeerIDFixture()
+	p2 := core.PeerIDFixture()
+	p3 := core.PeerIDFixture()
+	p4 := core.PeerIDFixture()
+
+	table.InvalidPiece(p1)
+	table.RequestTimeout(p2)
+	table.InvalidPiece(p3)
+	table.InvalidPiece(p3)
+	table.GoodPiece(p4, int64(memsize.MB), time.Millisecond)
+
+	reports := table.Reports()
+	require.Len(reports, 2)
+	require.Equal(p3, reports[0].PeerID)
+	require.Equal(p1, reports[1].PeerID)
+}
+
+func TestTableReportsAreReusedWithinTTL(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	table := New(Config{}, clk)
+
+	p1 := core.PeerIDFixture()
+	p2 := core.PeerIDFixture()
+
+	table.InvalidPiece(p1)
+	require.Len(table.Reports(), 1)
+
+	table.InvalidPiece(p2)
+	require.Len(table.Reports(), 1)
+
+	clk.Add(_reportsTTL)
+	require.Len(table.Reports(), 2)
+}
+
+func TestTableReportsDisabled(t *testing.T) {
+	table := New(Config{DisableReports: true}, clock.NewMock())
+	table.InvalidPiece(core.PeerIDFixture())
+	require.Nil(t, table.Reports())
+}
+
+func TestTableEvictsHighestScoringPeer(t *testing.T) {
+	require := require.New(t)
+
+	table := New(Config{MaxPeers: 2}, clock.NewMock())
+
+	bad := core.PeerIDFixture()
+	good := core.PeerIDFixture()
+
+	table.InvalidPiece(bad)
+	table.GoodPiece(good, int64(memsize.MB), time.Millisecond)
+	table.RequestTimeout(core.PeerIDFixture())
+
+	require.Len(table.peers, 2)
+	require.Contains(table.peers, bad)
+	require.NotContains(table.peers, good)
+}
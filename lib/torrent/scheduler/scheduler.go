@@ -14,8 +14,11 @@
 package scheduler
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"sync"
 	"time"
@@ -25,15 +28,20 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/peertoken"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler/announcequeue"
 	"github.com/uber/kraken/lib/torrent/scheduler/announcer"
 	"github.com/uber/kraken/lib/torrent/scheduler/conn"
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
+	"github.com/uber/kraken/lib/torrent/scheduler/reputation"
 	"github.com/uber/kraken/lib/torrent/scheduler/torrentlog"
 	"github.com/uber/kraken/lib/torrent/storage"
 	"github.com/uber/kraken/tracker/announceclient"
+	"github.com/uber/kraken/utils/bandwidth"
+	"github.com/uber/kraken/utils/httputil"
 	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/syncutil"
 )
 
 // Scheduler errors.
@@ -48,8 +56,16 @@ var (
 // Scheduler defines operations for scheduler.
 type Scheduler interface {
 	Stop()
-	Download(namespace string, d core.Digest) error
+	Download(ctx context.Context, namespace string, d core.Digest, opts ...DownloadOption) error
+	DownloadBundle(
+		ctx context.Context,
+		namespace string,
+		manifest core.Digest,
+		layers []core.Digest,
+		opts ...DownloadOption) error
 	BlacklistSnapshot() ([]connstate.BlacklistedConn, error)
+	BandwidthSnapshot() bandwidth.Snapshot
+	Dump() (*StateDump, error)
 	RemoveTorrent(d core.Digest) error
 	Probe() error
 }
@@ -69,6 +85,7 @@ type scheduler struct {
 	torrentArchive storage.TorrentArchive
 	stats          tally.Scope
 
+	transport  conn.Transport
 	handshaker *conn.Handshaker
 
 	eventLoop *liftedEventLoop
@@ -81,12 +98,34 @@ type scheduler struct {
 	// TODO(codyg): We only need this hold on this reference for reloading the scheduler...
 	announceClient announceclient.Client
 
+	// TLS and peer tokens are likewise only held for reloading the scheduler.
+	tls           *tls.Config
+	tokens        *peertoken.Issuer
+	tokenVerifier *peertoken.Verifier
+
+	// load is shared with the announce client, which reports it to the tracker.
+	load *LoadMeter
+
 	announcer *announcer.Announcer
 
+	// peerStreamTorrents carries the latest set of incomplete torrents to
+	// stream peers for. Only the most recent set is buffered.
+	peerStreamTorrents chan []announceclient.AnnounceTorrent
+
+	reputation *reputation.Table
+
 	netevents networkevent.Producer
 
 	torrentlog *torrentlog.Logger
 
+	// rand seeds the piece selection of each torrent. Global source if nil.
+	// Only accessed by the event loop.
+	rand *rand.Rand
+
+	// activity counts pending events, messages and goroutines. Nil unless
+	// someone waits for the scheduler to go idle.
+	activity *syncutil.Activity
+
 	logger *zap.SugaredLogger
 
 	// The following fields orchestrate the stopping of the scheduler.
@@ -95,23 +134,96 @@ type scheduler struct {
 	wg       sync.WaitGroup // Waits for eventLoop and listenLoop to exit.
 }
 
-// schedOverrides defines scheduler fields which may be overrided for testing
-// purposes.
+// schedOverrides defines scheduler fields which may be overrided, mostly for
+// testing and simulation purposes.
 type schedOverrides struct {
-	clock     clock.Clock
-	eventLoop eventLoop
+	clock         clock.Clock
+	eventLoop     eventLoop
+	transport     conn.Transport
+	tls           *tls.Config
+	tokens        *peertoken.Issuer
+	tokenVerifier *peertoken.Verifier
+	load          *LoadMeter
+	rand          *rand.Rand
+	activity      *syncutil.Activity
 }
 
-type option func(*schedOverrides)
+// Option overrides scheduler defaults.
+type Option func(*schedOverrides)
 
-func withClock(c clock.Clock) option {
+// WithClock runs the scheduler on clk instead of the system clock.
+func WithClock(c clock.Clock) Option {
 	return func(o *schedOverrides) { o.clock = c }
 }
 
-func withEventLoop(l eventLoop) option {
+// WithTransport connects to peers over t instead of the configured transport.
+func WithTransport(t conn.Transport) Option {
+	return func(o *schedOverrides) { o.transport = t }
+}
+
+// WithTLS secures connections to peers with tls, if supported by the configured
+// transport.
+func WithTLS(tls *tls.Config) Option {
+	return func(o *schedOverrides) { o.tls = tls }
+}
+
+// WithPeerTokens sends tokens issued by i in handshakes, and rejects handshakes
+// of peers whose tokens are not accepted by v.
+func WithPeerTokens(i *peertoken.Issuer, v *peertoken.Verifier) Option {
+	return func(o *schedOverrides) {
+		o.tokens = i
+		o.tokenVerifier = v
+	}
+}
+
+// WithLoadMeter measures upload load into m instead of a private LoadMeter.
+func WithLoadMeter(m *LoadMeter) Option {
+	return func(o *schedOverrides) { o.load = m }
+}
+
+// WithRand draws the random choices of the scheduler from r instead of the
+// global source, such that they can be replayed from the same seed.
+func WithRand(r *rand.Rand) Option {
+	return func(o *schedOverrides) { o.rand = r }
+}
+
+// WithActivity counts events, messages and goroutines of the scheduler as work
+// of a until they are handled, such that a driver of the clock can wait for the
+// scheduler to go idle. The clock must add each tick to a before sending it,
+// and the transport must mark goroutines blocked on it as done while they
+// wait. Peer streams and fault injection are not counted, so they should be
+// disabled.
+func WithActivity(a *syncutil.Activity) Option {
+	return func(o *schedOverrides) { o.activity = a }
+}
+
+func withEventLoop(l eventLoop) Option {
 	return func(o *schedOverrides) { o.eventLoop = l }
 }
 
+// New creates and starts a Scheduler which downloads torrents into ta and
+// announces them through announceClient and aq. Most clients should use
+// NewAgentScheduler or NewOriginScheduler instead.
+func New(
+	config Config,
+	ta storage.TorrentArchive,
+	stats tally.Scope,
+	pctx core.PeerContext,
+	announceClient announceclient.Client,
+	aq announcequeue.Queue,
+	netevents networkevent.Producer,
+	options ...Option) (Scheduler, error) {
+
+	s, err := newScheduler(config, ta, stats, pctx, announceClient, netevents, options...)
+	if err != nil {
+		return nil, fmt.Errorf("new scheduler: %s", err)
+	}
+	if err := s.start(aq); err != nil {
+		return nil, fmt.Errorf("start: %s", err)
+	}
+	return s, nil
+}
+
 // newScheduler creates and starts a scheduler.
 func newScheduler(
 	config Config,
@@ -120,7 +232,7 @@ func newScheduler(
 	pctx core.PeerContext,
 	announceClient announceclient.Client,
 	netevents networkevent.Producer,
-	options ...option) (*scheduler, error) {
+	options ...Option) (*scheduler, error) {
 
 	config = config.applyDefaults()
 
@@ -137,12 +249,17 @@ func newScheduler(
 	})
 
 	overrides := schedOverrides{
-		clock:     clock.New(),
-		eventLoop: newEventLoop(),
+		clock:         clock.New(),
+		tokens:        peertoken.DisabledIssuer(),
+		tokenVerifier: peertoken.DisabledVerifier(),
+		load:          NewLoadMeter(),
 	}
 	for _, opt := range options {
 		opt(&overrides)
 	}
+	if overrides.eventLoop == nil {
+		overrides.eventLoop = newEventLoop(overrides.activity)
+	}
 
 	eventLoop := liftEventLoop(overrides.eventLoop)
 	// 抢占
@@ -151,8 +268,18 @@ func newScheduler(
 		preemptionTick = overrides.clock.Tick(config.PreemptionInterval)
 	}
 
+	transport := overrides.transport
+	if transport == nil {
+		transport, err = conn.NewTransport(config.Conn, overrides.tls)
+		if err != nil {
+			return nil, fmt.Errorf("transport: %s", err)
+		}
+	}
+
 	handshaker, err := conn.NewHandshaker(
-		config.Conn, stats, overrides.clock, netevents, pctx.PeerID, eventLoop, slogger)
+		config.Conn, stats, overrides.clock, netevents, pctx.PeerID, transport, eventLoop, slogger,
+		conn.WithPeerTokens(overrides.tokens, overrides.tokenVerifier),
+		conn.WithActivity(overrides.activity))
 	if err != nil {
 		return nil, fmt.Errorf("conn: %s", err)
 	}
@@ -162,22 +289,35 @@ func newScheduler(
 		return nil, fmt.Errorf("torrentlog: %s", err)
 	}
 
+	ann := announcer.Default(
+		announceClient, eventLoop, overrides.clock, slogger,
+		announcer.WithActivity(overrides.activity))
+
 	s := &scheduler{
-		pctx:           pctx,
-		config:         config,
-		clock:          overrides.clock,
-		torrentArchive: ta,
-		stats:          stats,
-		handshaker:     handshaker,
-		eventLoop:      eventLoop,
-		preemptionTick: preemptionTick,
-		emitStatsTick:  overrides.clock.Tick(config.EmitStatsInterval),
-		announceClient: announceClient,
-		announcer:      announcer.Default(announceClient, eventLoop, overrides.clock, slogger),
-		netevents:      netevents,
-		torrentlog:     tlog,
-		logger:         slogger,
-		done:           done,
+		pctx:               pctx,
+		config:             config,
+		clock:              overrides.clock,
+		torrentArchive:     ta,
+		stats:              stats,
+		transport:          transport,
+		handshaker:         handshaker,
+		eventLoop:          eventLoop,
+		preemptionTick:     preemptionTick,
+		emitStatsTick:      overrides.clock.Tick(config.EmitStatsInterval),
+		announceClient:     announceClient,
+		tls:                overrides.tls,
+		tokens:             overrides.tokens,
+		tokenVerifier:      overrides.tokenVerifier,
+		load:               overrides.load,
+		announcer:          ann,
+		peerStreamTorrents: make(chan []announceclient.AnnounceTorrent, 1),
+		reputation:         reputation.New(config.Reputation, overrides.clock),
+		netevents:          netevents,
+		torrentlog:         tlog,
+		rand:               overrides.rand,
+		activity:           overrides.activity,
+		logger:             slogger,
+		done:               done,
 	}
 
 	if config.DisablePreemption {
@@ -200,7 +340,7 @@ func (s *scheduler) start(aq announcequeue.Queue) error {
 		s.pctx.PeerID, s.pctx.IP, s.pctx.Port)
 
 	// 监听在 peer-port端口
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", s.pctx.Port))
+	l, err := s.transport.Listen(fmt.Sprintf(":%d", s.pctx.Port))
 	if err != nil {
 		return err
 	}
@@ -213,6 +353,11 @@ func (s *scheduler) start(aq announcequeue.Queue) error {
 	go s.tickerLoop()
 	go s.announceLoop()
 
+	if !s.config.DisablePeerStreams {
+		s.wg.Add(1)
+		go s.peerStreamLoop()
+	}
+
 	return nil
 }
 
@@ -223,6 +368,7 @@ func (s *scheduler) Stop() {
 
 		close(s.done)
 		s.listener.Close()
+		s.handshaker.Close()
 		s.eventLoop.send(shutdownEvent{})
 
 		// Waits for all loops to stop.
@@ -234,7 +380,9 @@ func (s *scheduler) Stop() {
 	})
 }
 
-func (s *scheduler) doDownload(namespace string, d core.Digest) (size int64, err error) {
+func (s *scheduler) doDownload(
+	ctx context.Context, namespace string, d core.Digest, opts downloadOptions) (size int64, err error) {
+
 	// 创建种子，如果本地不存在下载文件元数据，则从tracker获取
 	t, err := s.torrentArchive.CreateTorrent(namespace, d)
 	if err != nil {
@@ -244,20 +392,48 @@ func (s *scheduler) doDownload(namespace string, d core.Digest) (size int64, err
 		return 0, fmt.Errorf("create torrent: %s", err)
 	}
 
-	// Buffer size of 1 so sends do not block.
-	errc := make(chan error, 1)
+	w := newWaiter(opts.priority)
 	// 开始下载
-	if !s.eventLoop.send(newTorrentEvent{namespace, t, errc}) {
+	if !s.eventLoop.send(newTorrentEvent{namespace, t, w}) {
 		return 0, ErrSchedulerStopped
 	}
-	return t.Length(), <-errc
+
+	var progress <-chan time.Time
+	if opts.progress != nil {
+		ticker := s.clock.Ticker(s.config.ProgressInterval)
+		defer ticker.Stop()
+		progress = ticker.C
+	}
+	for {
+		select {
+		case err := <-w.errc:
+			if err == nil && opts.progress != nil {
+				opts.progress(Progress{t.BytesDownloaded(), t.Length()})
+			}
+			return t.Length(), err
+		case <-progress:
+			opts.progress(Progress{t.BytesDownloaded(), t.Length()})
+			s.activity.Done()
+		case <-ctx.Done():
+			s.eventLoop.send(cancelDownloadEvent{t.InfoHash(), w})
+			return t.Length(), ctx.Err()
+		}
+	}
 }
 
 // Download downloads the torrent given metainfo. Once the torrent is downloaded,
-// it will begin seeding asynchronously.
-func (s *scheduler) Download(namespace string, d core.Digest) error {
-	start := time.Now()
-	size, err := s.doDownload(namespace, d)
+// it will begin seeding asynchronously. If ctx is cancelled before the download
+// completes, Download returns ctx.Err() and the torrent is removed unless other
+// downloads are still waiting on it.
+func (s *scheduler) Download(
+	ctx context.Context, namespace string, d core.Digest, opts ...DownloadOption) error {
+
+	var o downloadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	start := s.clock.Now()
+	size, err := s.doDownload(ctx, namespace, d, o)
 	if err != nil {
 		var errTag string
 		switch err {
@@ -269,6 +445,8 @@ func (s *scheduler) Download(namespace string, d core.Digest) error {
 			errTag = "scheduler_stopped"
 		case ErrTorrentRemoved:
 			errTag = "removed"
+		case context.Canceled, context.DeadlineExceeded:
+			errTag = "cancelled"
 		default:
 			errTag = "unknown"
 		}
@@ -277,13 +455,149 @@ func (s *scheduler) Download(namespace string, d core.Digest) error {
 		}).Counter("download_errors").Inc(1)
 		s.torrentlog.DownloadFailure(namespace, d, size, err)
 	} else {
-		downloadTime := time.Since(start)
+		downloadTime := s.clock.Now().Sub(start)
 		recordDownloadTime(s.stats, size, downloadTime)
 		s.torrentlog.DownloadSuccess(namespace, d, size, downloadTime)
 	}
 	return err
 }
 
+func (s *scheduler) doDownloadBundle(
+	ctx context.Context,
+	namespace string,
+	manifest core.Digest,
+	layers []core.Digest,
+	opts downloadOptions) (size int64, err error) {
+
+	torrents, err := s.createBundleTorrents(namespace, layers)
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range torrents {
+		size += t.Length()
+	}
+
+	waiters := make([]*waiter, len(torrents))
+	for i := range waiters {
+		waiters[i] = newWaiter(opts.priority)
+	}
+	if !s.eventLoop.send(newBundleEvent{namespace, manifest, torrents, waiters}) {
+		return 0, ErrSchedulerStopped
+	}
+	defer s.eventLoop.send(removeBundleEvent{manifest})
+
+	// Stops waiting on all torrents from i onwards.
+	cancel := func(i int) {
+		for ; i < len(torrents); i++ {
+			s.eventLoop.send(cancelDownloadEvent{torrents[i].InfoHash(), waiters[i]})
+		}
+	}
+	reportProgress := func() {
+		var p Progress
+		for _, t := range torrents {
+			p.BytesDownloaded += t.BytesDownloaded()
+			p.Length += t.Length()
+		}
+		opts.progress(p)
+	}
+
+	var progress <-chan time.Time
+	if opts.progress != nil {
+		ticker := s.clock.Ticker(s.config.ProgressInterval)
+		defer ticker.Stop()
+		progress = ticker.C
+	}
+	for i := 0; i < len(waiters); {
+		select {
+		case err := <-waiters[i].errc:
+			if err != nil {
+				cancel(i + 1)
+				return size, err
+			}
+			i++
+		case <-progress:
+			reportProgress()
+			s.activity.Done()
+		case <-ctx.Done():
+			cancel(i)
+			return size, ctx.Err()
+		}
+	}
+	if opts.progress != nil {
+		reportProgress()
+	}
+	return size, nil
+}
+
+// createBundleTorrents creates the torrents of layers, skipping duplicates.
+// Metainfos are fetched concurrently, up to BundleMetaInfoConcurrency at once,
+// since a bundle may reference many layers.
+func (s *scheduler) createBundleTorrents(
+	namespace string, layers []core.Digest) ([]storage.Torrent, error) {
+
+	var digests []core.Digest
+	seen := make(map[core.Digest]bool)
+	for _, d := range layers {
+		if !seen[d] {
+			seen[d] = true
+			digests = append(digests, d)
+		}
+	}
+
+	torrents := make([]storage.Torrent, len(digests))
+	errs := make([]error, len(digests))
+	sem := make(chan struct{}, s.config.BundleMetaInfoConcurrency)
+	var wg sync.WaitGroup
+	for i, d := range digests {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, d core.Digest) {
+			defer wg.Done()
+			torrents[i], errs[i] = s.torrentArchive.CreateTorrent(namespace, d)
+			<-sem
+		}(i, d)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == storage.ErrNotFound {
+			return nil, ErrTorrentNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("create torrent %s: %s", digests[i], err)
+		}
+	}
+	return torrents, nil
+}
+
+// DownloadBundle downloads the torrents of layers as a single bundle identified
+// by manifest. Torrents of a bundle share a connection budget and a peer set,
+// and are announced to the tracker together. If the tracker does not support
+// bundles, each torrent is announced individually. If any torrent fails, the
+// remaining downloads are cancelled and its error is returned.
+func (s *scheduler) DownloadBundle(
+	ctx context.Context,
+	namespace string,
+	manifest core.Digest,
+	layers []core.Digest,
+	opts ...DownloadOption) error {
+
+	var o downloadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	start := s.clock.Now()
+	size, err := s.doDownloadBundle(ctx, namespace, manifest, layers, o)
+	if err != nil {
+		s.stats.Counter("bundle_download_errors").Inc(1)
+		s.log("manifest", manifest).Infof("Error downloading bundle: %s", err)
+	} else {
+		s.stats.Timer("bundle_download_time").Record(s.clock.Now().Sub(start))
+		s.log("manifest", manifest, "size", size).Info("Downloaded bundle")
+	}
+	return err
+}
+
 // BlacklistSnapshot returns a snapshot of the current connection blacklist.
 func (s *scheduler) BlacklistSnapshot() ([]connstate.BlacklistedConn, error) {
 	result := make(chan []connstate.BlacklistedConn)
@@ -293,6 +607,20 @@ func (s *scheduler) BlacklistSnapshot() ([]connstate.BlacklistedConn, error) {
 	return <-result, nil
 }
 
+// Dump returns a snapshot of the full scheduler state.
+func (s *scheduler) Dump() (*StateDump, error) {
+	result := make(chan *StateDump)
+	if !s.eventLoop.send(dumpEvent{result}) {
+		return nil, ErrSchedulerStopped
+	}
+	return <-result, nil
+}
+
+// BandwidthSnapshot returns the current bandwidth limits of the scheduler.
+func (s *scheduler) BandwidthSnapshot() bandwidth.Snapshot {
+	return s.handshaker.BandwidthSnapshot()
+}
+
 // RemoveTorrent forcibly stops leeching / seeding torrent for d and removes
 // the torrent from disk.
 func (s *scheduler) RemoveTorrent(d core.Digest) error {
@@ -329,7 +657,7 @@ func (s *scheduler) listenLoop() {
 			s.log().Infof("Error accepting new conn, exiting listen loop: %s", err)
 			return
 		}
-		go func() {
+		s.activity.Go(func() {
 			pc, err := s.handshaker.Accept(nc)
 			if err != nil {
 				s.log().Infof("Error accepting handshake, closing net conn: %s", err)
@@ -338,7 +666,7 @@ func (s *scheduler) listenLoop() {
 			}
 			// 接受请求
 			s.eventLoop.send(incomingHandshakeEvent{pc})
-		}()
+		})
 	}
 }
 
@@ -350,8 +678,10 @@ func (s *scheduler) tickerLoop() {
 		select {
 		case <-s.preemptionTick:
 			s.eventLoop.send(preemptionTickEvent{})
+			s.activity.Done()
 		case <-s.emitStatsTick:
 			s.eventLoop.send(emitStatsEvent{})
+			s.activity.Done()
 		case <-s.done:
 			return
 		}
@@ -367,7 +697,9 @@ func (s *scheduler) announceLoop() {
 
 // 告诉 traker 下载结果,是否完成
 func (s *scheduler) announce(d core.Digest, h core.InfoHash, complete bool) {
-	peers, err := s.announcer.Announce(d, h, complete)
+	defer s.activity.Done()
+
+	peers, err := s.announcer.Announce(d, h, complete, s.reputation.Reports()...)
 	if err != nil {
 		if err != announceclient.ErrDisabled {
 			s.eventLoop.send(announceErrEvent{h, err})
@@ -377,6 +709,157 @@ func (s *scheduler) announce(d core.Digest, h core.InfoHash, complete bool) {
 	s.eventLoop.send(announceResultEvent{h, peers})
 }
 
+// announceBundle announces all torrents of the bundle identified by manifest.
+func (s *scheduler) announceBundle(
+	manifest core.Digest, leader core.InfoHash, torrents []announceclient.AnnounceTorrent) {
+
+	defer s.activity.Done()
+
+	peers, err := s.announcer.AnnounceBundle(manifest, torrents, s.reputation.Reports()...)
+	if err != nil {
+		switch err {
+		case announceclient.ErrDisabled:
+		case announceclient.ErrBundlesUnsupported:
+			s.eventLoop.send(bundlesUnsupportedEvent{manifest, leader})
+		default:
+			s.eventLoop.send(announceErrEvent{leader, err})
+		}
+		return
+	}
+	s.eventLoop.send(bundleAnnounceResultEvent{manifest, leader, peers})
+}
+
+// announceBatch announces all torrents of a batch at once.
+func (s *scheduler) announceBatch(torrents []announceclient.AnnounceTorrent) {
+	defer s.activity.Done()
+
+	peers, err := s.announcer.AnnounceBatch(torrents, s.reputation.Reports()...)
+	if err != nil {
+		switch err {
+		case announceclient.ErrDisabled:
+		case announceclient.ErrBatchUnsupported:
+			s.eventLoop.send(batchUnsupportedEvent{torrents})
+		default:
+			s.eventLoop.send(batchAnnounceErrEvent{torrents, err})
+		}
+		return
+	}
+	s.eventLoop.send(batchAnnounceResultEvent{torrents, peers})
+}
+
+// _batchUnsupportedBackoff is how long torrents are announced individually once
+// the tracker rejects a batch announce, before batches are tried again.
+const _batchUnsupportedBackoff = 10 * time.Minute
+
+// Peer stream delays.
+const (
+	// _peerStreamRetryDelay is the initial delay before reopening a lost or
+	// failed peer stream. Consecutive failures double it up to
+	// _peerStreamMaxRetryDelay, unless the tracker requests a Retry-After.
+	_peerStreamRetryDelay    = 5 * time.Second
+	_peerStreamMaxRetryDelay = 2 * time.Minute
+
+	// _peerStreamCoalesceDelay batches torrent set changes before reopening the
+	// peer stream, so a burst of added torrents reopens it only once.
+	_peerStreamCoalesceDelay = time.Second
+)
+
+// updatePeerStream replaces the set of torrents the peer stream covers. Never
+// blocks: if a previous set has not been consumed yet, it is replaced.
+func (s *scheduler) updatePeerStream(torrents []announceclient.AnnounceTorrent) {
+	for {
+		select {
+		case s.peerStreamTorrents <- torrents:
+			return
+		default:
+		}
+		select {
+		case <-s.peerStreamTorrents:
+		default:
+		}
+	}
+}
+
+// peerStreamLoop multiplexes the seeders of all incomplete torrents on a single
+// peer stream per tracker, sending them into the event loop as the tracker
+// pushes them. The stream is reopened whenever the set of torrents changes or
+// the stream is lost, since announces continue regardless.
+func (s *scheduler) peerStreamLoop() {
+	defer s.wg.Done()
+
+	var torrents []announceclient.AnnounceTorrent
+	backoff := _peerStreamRetryDelay
+	for {
+		if len(torrents) == 0 {
+			select {
+			case torrents = <-s.peerStreamTorrents:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+
+		var delay time.Duration
+		ctx, cancel := context.WithCancel(context.Background())
+		peers, err := s.announcer.StreamPeers(ctx, torrents)
+		switch err {
+		case nil:
+			backoff = _peerStreamRetryDelay
+			delay = _peerStreamRetryDelay
+		FORWARD:
+			for {
+				select {
+				case p, ok := <-peers:
+					if !ok {
+						break FORWARD
+					}
+					s.eventLoop.send(streamedPeerEvent{p.InfoHash, p.Peer})
+				case torrents = <-s.peerStreamTorrents:
+					delay = _peerStreamCoalesceDelay
+					break FORWARD
+				case <-s.done:
+					cancel()
+					return
+				}
+			}
+			cancel()
+		case announceclient.ErrDisabled:
+			cancel()
+			return
+		case announceclient.ErrStreamsUnsupported:
+			cancel()
+			s.eventLoop.send(peerStreamsUnsupportedEvent{})
+			return
+		default:
+			cancel()
+			s.log().Infof("Error streaming peers: %s", err)
+			if d, ok := httputil.RetryAfter(err); ok {
+				delay = d
+			} else {
+				delay = backoff
+				backoff *= 2
+				if backoff > _peerStreamMaxRetryDelay {
+					backoff = _peerStreamMaxRetryDelay
+				}
+			}
+		}
+
+		// Torrent set changes received while waiting are picked up by the
+		// next stream.
+		timer := s.clock.After(delay)
+	WAIT:
+		for {
+			select {
+			case torrents = <-s.peerStreamTorrents:
+			case <-timer:
+				break WAIT
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
 func (s *scheduler) failIncomingHandshake(pc *conn.PendingConn, err error) {
 	s.log(
 		"peer", pc.PeerID(),
@@ -386,15 +869,20 @@ func (s *scheduler) failIncomingHandshake(pc *conn.PendingConn, err error) {
 }
 
 // establishIncomingHandshake attempts to establish a pending conn initialized
-// by a remote peer. Success / failure is communicated via events.
+// by a remote peer, charging its bandwidth to the class of the local namespace
+// of the torrent. Success / failure is communicated via events.
 // 远程 peer 想建立连接
-func (s *scheduler) establishIncomingHandshake(pc *conn.PendingConn, rb conn.RemoteBitfields) {
+func (s *scheduler) establishIncomingHandshake(
+	pc *conn.PendingConn, rb conn.RemoteBitfields, namespace string) {
+
+	defer s.activity.Done()
+
 	info, err := s.torrentArchive.Stat(pc.Namespace(), pc.Digest())
 	if err != nil {
 		s.failIncomingHandshake(pc, fmt.Errorf("torrent stat: %s", err))
 		return
 	}
-	c, err := s.handshaker.Establish(pc, info, rb)
+	c, err := s.handshaker.Establish(pc, info, rb, namespace)
 	if err != nil {
 		s.failIncomingHandshake(pc, fmt.Errorf("establish handshake: %s", err))
 		return
@@ -409,6 +897,8 @@ func (s *scheduler) establishIncomingHandshake(pc *conn.PendingConn, rb conn.Rem
 func (s *scheduler) initializeOutgoingHandshake(
 	p *core.PeerInfo, info *storage.TorrentInfo, rb conn.RemoteBitfields, namespace string) {
 
+	defer s.activity.Done()
+
 	addr := fmt.Sprintf("%s:%d", p.IP, p.Port)
 	result, err := s.handshaker.Initialize(p.PeerID, addr, info, rb, namespace)
 	if err != nil {
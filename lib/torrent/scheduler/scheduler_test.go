@@ -14,6 +14,9 @@
 package scheduler
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"os"
 	"sync"
 	"testing"
@@ -24,9 +27,14 @@ import (
 	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler/announcequeue"
+	"github.com/uber/kraken/lib/torrent/scheduler/conn"
 	"github.com/uber/kraken/lib/torrent/storage/piecereader"
 	"github.com/uber/kraken/tracker/announceclient"
+	"github.com/uber/kraken/tracker/metainfoclient"
 	"github.com/uber/kraken/utils/bitsetutil"
+	"github.com/uber/kraken/utils/memsize"
+	"github.com/uber/kraken/utils/randutil"
+	"github.com/uber/kraken/utils/testutil"
 
 	"github.com/andres-erbsen/clock"
 	"github.com/stretchr/testify/require"
@@ -50,12 +58,46 @@ func TestDownloadTorrentWithSeederAndLeecher(t *testing.T) {
 		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
 
 	seeder.writeTorrent(namespace, blob)
-	require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
+	require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
 
-	require.NoError(leecher.scheduler.Download(namespace, blob.Digest))
+	require.NoError(leecher.scheduler.Download(context.Background(), namespace, blob.Digest))
 	leecher.checkTorrent(t, namespace, blob)
 }
 
+func TestDownloadTorrentWithCompression(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	config := configFixture()
+	config.Conn.Compression.Namespaces = []string{".*"}
+
+	seeder := mocks.newPeer(config)
+	leecher := mocks.newPeer(config)
+
+	content := bytes.Repeat(randutil.Text(memsize.KB), 256)
+	d, err := core.NewDigester().FromBytes(content)
+	require.NoError(err)
+	mi, err := core.NewMetaInfo(d, bytes.NewReader(content), int64(32*memsize.KB))
+	require.NoError(err)
+	blob := core.CustomBlobFixture(content, d, mi)
+	namespace := core.TagFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+
+	seeder.writeTorrent(namespace, blob)
+	require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
+
+	require.NoError(leecher.scheduler.Download(context.Background(), namespace, blob.Digest))
+	leecher.checkTorrent(t, namespace, blob)
+
+	decompressed, ok := leecher.stats.Snapshot().Counters()["decompressed_payloads+module=conn"]
+	require.True(ok)
+	require.Equal(int64(blob.MetaInfo.NumPieces()), decompressed.Value())
+}
+
 func TestDownloadManyTorrentsWithSeederAndLeecher(t *testing.T) {
 	require := require.New(t)
 
@@ -80,9 +122,9 @@ func TestDownloadManyTorrentsWithSeederAndLeecher(t *testing.T) {
 			defer wg.Done()
 
 			seeder.writeTorrent(namespace, blob)
-			require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
+			require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
 
-			require.NoError(leecher.scheduler.Download(namespace, blob.Digest))
+			require.NoError(leecher.scheduler.Download(context.Background(), namespace, blob.Digest))
 			leecher.checkTorrent(t, namespace, blob)
 		}()
 	}
@@ -111,7 +153,7 @@ func TestDownloadManyTorrentsWithSeederAndManyLeechers(t *testing.T) {
 			namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(6)
 
 		seeder.writeTorrent(namespace, blob)
-		require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
+		require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
 	}
 
 	var wg sync.WaitGroup
@@ -122,7 +164,7 @@ func TestDownloadManyTorrentsWithSeederAndManyLeechers(t *testing.T) {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				require.NoError(p.scheduler.Download(namespace, blob.Digest))
+				require.NoError(p.scheduler.Download(context.Background(), namespace, blob.Digest))
 				p.checkTorrent(t, namespace, blob)
 			}()
 		}
@@ -162,13 +204,113 @@ func TestDownloadTorrentWhenPeersAllHaveDifferentPiece(t *testing.T) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			require.NoError(p.scheduler.Download(namespace, blob.Digest))
+			require.NoError(p.scheduler.Download(context.Background(), namespace, blob.Digest))
 			p.checkTorrent(t, namespace, blob)
 		}()
 	}
 	wg.Wait()
 }
 
+func TestDownloadTorrentWithSeederAndLeecherOverQUIC(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	config := quicConfigFixture()
+
+	seeder := mocks.newPeer(config)
+	leecher := mocks.newPeer(config)
+
+	blob := core.NewBlobFixture()
+	namespace := core.TagFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+
+	seeder.writeTorrent(namespace, blob)
+	require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
+
+	require.NoError(leecher.scheduler.Download(context.Background(), namespace, blob.Digest))
+	leecher.checkTorrent(t, namespace, blob)
+}
+
+func TestDownloadManyTorrentsWithSeederAndManyLeechersOverQUIC(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	config := quicConfigFixture()
+	namespace := core.TagFixture()
+
+	seeder := mocks.newPeer(config)
+	leechers := mocks.newPeers(5, config)
+
+	// Torrents shared between the same peers are multiplexed over a single
+	// QUIC connection.
+	blobs := make([]*core.BlobFixture, 5)
+	for i := range blobs {
+		blob := core.NewBlobFixture()
+		blobs[i] = blob
+
+		mocks.metaInfoClient.EXPECT().Download(
+			namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(6)
+
+		seeder.writeTorrent(namespace, blob)
+		require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
+	}
+
+	var wg sync.WaitGroup
+	for _, blob := range blobs {
+		blob := blob
+		for _, p := range leechers {
+			p := p
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				require.NoError(p.scheduler.Download(context.Background(), namespace, blob.Digest))
+				p.checkTorrent(t, namespace, blob)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func TestDownloadTorrentWithCompressionOverQUIC(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	config := quicConfigFixture()
+	config.Conn.Compression.Namespaces = []string{".*"}
+
+	seeder := mocks.newPeer(config)
+	leecher := mocks.newPeer(config)
+
+	content := bytes.Repeat(randutil.Text(memsize.KB), 256)
+	d, err := core.NewDigester().FromBytes(content)
+	require.NoError(err)
+	mi, err := core.NewMetaInfo(d, bytes.NewReader(content), int64(32*memsize.KB))
+	require.NoError(err)
+	blob := core.CustomBlobFixture(content, d, mi)
+	namespace := core.TagFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+
+	seeder.writeTorrent(namespace, blob)
+	require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
+
+	require.NoError(leecher.scheduler.Download(context.Background(), namespace, blob.Digest))
+	leecher.checkTorrent(t, namespace, blob)
+
+	decompressed, ok := leecher.stats.Snapshot().Counters()["decompressed_payloads+module=conn"]
+	require.True(ok)
+	require.Equal(int64(blob.MetaInfo.NumPieces()), decompressed.Value())
+}
+
 func TestSeederTTI(t *testing.T) {
 	require := require.New(t)
 
@@ -186,14 +328,14 @@ func TestSeederTTI(t *testing.T) {
 	clk := clock.NewMock()
 	w := newEventWatcher()
 
-	seeder := mocks.newPeer(config, withEventLoop(w), withClock(clk))
+	seeder := mocks.newPeer(config, withEventLoop(w), WithClock(clk))
 	seeder.writeTorrent(namespace, blob)
-	require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
+	require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
 
-	leecher := mocks.newPeer(config, withClock(clk))
+	leecher := mocks.newPeer(config, WithClock(clk))
 
 	errc := make(chan error)
-	go func() { errc <- leecher.scheduler.Download(namespace, blob.Digest) }()
+	go func() { errc <- leecher.scheduler.Download(context.Background(), namespace, blob.Digest) }()
 
 	require.NoError(<-errc)
 	leecher.checkTorrent(t, namespace, blob)
@@ -235,9 +377,9 @@ func TestLeecherTTI(t *testing.T) {
 
 	mocks.metaInfoClient.EXPECT().Download(namespace, blob.Digest).Return(blob.MetaInfo, nil)
 
-	p := mocks.newPeer(config, withEventLoop(w), withClock(clk))
+	p := mocks.newPeer(config, withEventLoop(w), WithClock(clk))
 	errc := make(chan error)
-	go func() { errc <- p.scheduler.Download(namespace, blob.Digest) }()
+	go func() { errc <- p.scheduler.Download(context.Background(), namespace, blob.Digest) }()
 
 	waitForTorrentAdded(t, p.scheduler, blob.MetaInfo.InfoHash())
 
@@ -269,7 +411,7 @@ func TestMultipleDownloadsForSameTorrentSucceed(t *testing.T) {
 
 	seeder := mocks.newPeer(config)
 	seeder.writeTorrent(namespace, blob)
-	require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
+	require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
 
 	leecher := mocks.newPeer(config)
 
@@ -279,7 +421,7 @@ func TestMultipleDownloadsForSameTorrentSucceed(t *testing.T) {
 		go func() {
 			defer wg.Done()
 			// Multiple goroutines should be able to wait on the same torrent.
-			require.NoError(leecher.scheduler.Download(namespace, blob.Digest))
+			require.NoError(leecher.scheduler.Download(context.Background(), namespace, blob.Digest))
 		}()
 	}
 	wg.Wait()
@@ -287,7 +429,7 @@ func TestMultipleDownloadsForSameTorrentSucceed(t *testing.T) {
 	leecher.checkTorrent(t, namespace, blob)
 
 	// After the torrent is complete, further calls to Download should succeed immediately.
-	require.NoError(leecher.scheduler.Download(namespace, blob.Digest))
+	require.NoError(leecher.scheduler.Download(context.Background(), namespace, blob.Digest))
 }
 
 func TestEmitStatsEventTriggers(t *testing.T) {
@@ -298,7 +440,7 @@ func TestEmitStatsEventTriggers(t *testing.T) {
 	clk := clock.NewMock()
 	w := newEventWatcher()
 
-	mocks.newPeer(config, withEventLoop(w), withClock(clk))
+	mocks.newPeer(config, withEventLoop(w), WithClock(clk))
 
 	clk.Add(config.EmitStatsInterval)
 	w.waitFor(t, emitStatsEvent{})
@@ -325,9 +467,9 @@ func TestNetworkEvents(t *testing.T) {
 		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
 
 	seeder.writeTorrent(namespace, blob)
-	require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
+	require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
 
-	require.NoError(leecher.scheduler.Download(namespace, blob.Digest))
+	require.NoError(leecher.scheduler.Download(context.Background(), namespace, blob.Digest))
 	leecher.checkTorrent(t, namespace, blob)
 
 	sid := seeder.pctx.PeerID
@@ -338,7 +480,7 @@ func TestNetworkEvents(t *testing.T) {
 	waitForConnRemoved(t, leecher.scheduler, sid, h)
 
 	seederExpected := []*networkevent.Event{
-		networkevent.AddTorrentEvent(h, sid, bitsetutil.FromBools(true), config.ConnState.MaxOpenConnectionsPerTorrent),
+		networkevent.AddTorrentEvent(h, sid, namespace, bitsetutil.FromBools(true), config.ConnState.MaxOpenConnectionsPerTorrent),
 		networkevent.TorrentCompleteEvent(h, sid),
 		networkevent.AddActiveConnEvent(h, sid, lid),
 		networkevent.DropActiveConnEvent(h, sid, lid),
@@ -346,7 +488,7 @@ func TestNetworkEvents(t *testing.T) {
 	}
 
 	leecherExpected := []*networkevent.Event{
-		networkevent.AddTorrentEvent(h, lid, bitsetutil.FromBools(false), config.ConnState.MaxOpenConnectionsPerTorrent),
+		networkevent.AddTorrentEvent(h, lid, namespace, bitsetutil.FromBools(false), config.ConnState.MaxOpenConnectionsPerTorrent),
 		networkevent.AddActiveConnEvent(h, lid, sid),
 		networkevent.RequestPieceEvent(h, lid, sid, 0),
 		networkevent.ReceivePieceEvent(h, lid, sid, 0),
@@ -355,6 +497,11 @@ func TestNetworkEvents(t *testing.T) {
 		networkevent.BlacklistConnEvent(h, lid, sid, config.ConnState.BlacklistDuration),
 	}
 
+	// Every event is tagged with the namespace of its torrent.
+	for _, e := range append(seederExpected, leecherExpected...) {
+		e.Namespace = namespace
+	}
+
 	require.Equal(
 		networkevent.StripTimestamps(seederExpected),
 		networkevent.StripTimestamps(seeder.testProducer.Events()))
@@ -364,6 +511,101 @@ func TestNetworkEvents(t *testing.T) {
 		networkevent.StripTimestamps(leecher.testProducer.Events()))
 }
 
+func TestCorruptSeederIsBlacklisted(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	config := configFixture()
+	config.ConnTTI = 2 * time.Second
+	config.ConnState.BlacklistDuration = 30 * time.Second
+
+	seederConfig := config
+	seederConfig.Conn.FaultInjection = conn.FaultConfig{
+		Enable: true,
+		Faults: []conn.Fault{{Type: conn.FaultCorrupt}},
+	}
+
+	seeder := mocks.newPeer(seederConfig)
+	leecher := mocks.newPeer(config)
+
+	blob := core.SizedBlobFixture(4, 1)
+	namespace := core.TagFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+
+	seeder.writeTorrent(namespace, blob)
+	require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go leecher.scheduler.Download(ctx, namespace, blob.Digest)
+
+	h := blob.MetaInfo.InfoHash()
+
+	// The leecher never receives a valid piece from the seeder, so the conn
+	// goes idle and the seeder is blacklisted once the conn is closed.
+	require.NoError(testutil.PollUntilTrue(10*time.Second, func() bool {
+		blacklist, err := leecher.scheduler.BlacklistSnapshot()
+		require.NoError(err)
+		for _, c := range blacklist {
+			if c.PeerID == seeder.pctx.PeerID && c.InfoHash == h {
+				return true
+			}
+		}
+		return false
+	}))
+
+	tor, err := leecher.torrentArchive.GetTorrent(namespace, blob.Digest)
+	require.NoError(err)
+	require.Equal(uint(0), tor.Bitfield().Count())
+
+	// The seeder is reported to the tracker with a low reputation.
+	reports := leecher.scheduler.reputation.Reports()
+	require.Len(reports, 1)
+	require.Equal(seeder.pctx.PeerID, reports[0].PeerID)
+	require.True(reports[0].Score < 0.1)
+}
+
+func TestSchedulerDump(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	config := configFixture()
+
+	seeder := mocks.newPeer(config)
+	leecher := mocks.newPeer(config)
+
+	blob := core.SizedBlobFixture(2, 1)
+	namespace := core.TagFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+
+	seeder.writeTorrent(namespace, blob)
+	require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
+	require.NoError(leecher.scheduler.Download(context.Background(), namespace, blob.Digest))
+
+	h := blob.MetaInfo.InfoHash()
+
+	dump, err := seeder.scheduler.Dump()
+	require.NoError(err)
+	require.Equal(seeder.pctx.PeerID, dump.PeerID)
+	require.Len(dump.Torrents, 1)
+
+	torrent := dump.Torrents[0]
+	require.Equal(h, torrent.InfoHash)
+	require.Equal(blob.Digest, torrent.Digest)
+	require.Equal(namespace, torrent.Namespace)
+	require.True(torrent.Complete)
+	require.Equal("11", torrent.Bitfield)
+	require.Equal(PriorityNormal.String(), torrent.Priority)
+}
+
 func TestPullInactiveTorrent(t *testing.T) {
 	require := require.New(t)
 
@@ -390,7 +632,7 @@ func TestPullInactiveTorrent(t *testing.T) {
 
 	leecher := mocks.newPeer(config)
 
-	require.NoError(leecher.scheduler.Download(namespace, blob.Digest))
+	require.NoError(leecher.scheduler.Download(context.Background(), namespace, blob.Digest))
 	leecher.checkTorrent(t, namespace, blob)
 }
 
@@ -413,9 +655,9 @@ func TestSchedulerReload(t *testing.T) {
 			namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
 
 		seeder.writeTorrent(namespace, blob)
-		require.NoError(seeder.scheduler.Download(namespace, blob.Digest))
+		require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
 
-		require.NoError(leecher.scheduler.Download(namespace, blob.Digest))
+		require.NoError(leecher.scheduler.Download(context.Background(), namespace, blob.Digest))
 		leecher.checkTorrent(t, namespace, blob)
 	}
 
@@ -446,7 +688,7 @@ func TestSchedulerRemoveTorrent(t *testing.T) {
 		namespace, blob.Digest).Return(blob.MetaInfo, nil)
 
 	errc := make(chan error)
-	go func() { errc <- p.scheduler.Download(namespace, blob.Digest) }()
+	go func() { errc <- p.scheduler.Download(context.Background(), namespace, blob.Digest) }()
 
 	w.waitFor(t, newTorrentEvent{})
 
@@ -458,6 +700,148 @@ func TestSchedulerRemoveTorrent(t *testing.T) {
 	require.True(os.IsNotExist(err))
 }
 
+func TestDownloadCancelledRemovesTorrent(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	p := mocks.newPeer(configFixture())
+
+	blob := core.NewBlobFixture()
+	namespace := core.TagFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(blob.MetaInfo, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() { errc <- p.scheduler.Download(ctx, namespace, blob.Digest) }()
+
+	waitForTorrentAdded(t, p.scheduler, blob.MetaInfo.InfoHash())
+
+	cancel()
+
+	require.Equal(context.Canceled, <-errc)
+
+	waitForTorrentRemoved(t, p.scheduler, blob.MetaInfo.InfoHash())
+
+	_, err := p.torrentArchive.Stat(namespace, blob.Digest)
+	require.True(os.IsNotExist(err))
+}
+
+func TestDownloadCancelledDoesNotAffectOtherDownloads(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	p := mocks.newPeer(configFixture())
+
+	blob := core.NewBlobFixture()
+	namespace := core.TagFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(blob.MetaInfo, nil).AnyTimes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc1 := make(chan error)
+	go func() { errc1 <- p.scheduler.Download(ctx, namespace, blob.Digest) }()
+
+	waitForTorrentAdded(t, p.scheduler, blob.MetaInfo.InfoHash())
+
+	errc2 := make(chan error)
+	go func() { errc2 <- p.scheduler.Download(context.Background(), namespace, blob.Digest) }()
+
+	cancel()
+
+	require.Equal(context.Canceled, <-errc1)
+
+	// The remaining download keeps the torrent alive.
+	waitForTorrentAdded(t, p.scheduler, blob.MetaInfo.InfoHash())
+	select {
+	case err := <-errc2:
+		t.Fatalf("unexpected download result: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(p.scheduler.RemoveTorrent(blob.Digest))
+	require.Equal(ErrTorrentRemoved, <-errc2)
+}
+
+func TestDownloadPriority(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	config := configFixture()
+	p := mocks.newPeer(config)
+
+	blob := core.NewBlobFixture()
+	namespace := core.TagFixture()
+	h := blob.MetaInfo.InfoHash()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(blob.MetaInfo, nil).AnyTimes()
+
+	lowCtx, cancelLow := context.WithCancel(context.Background())
+	defer cancelLow()
+	go p.scheduler.Download(lowCtx, namespace, blob.Digest, WithPriority(PriorityLow))
+
+	waitForTorrentPriority(t, p.scheduler, h, PriorityLow)
+	low := maxConns(p.scheduler, h)
+
+	// The torrent takes the highest priority of its waiting downloads.
+	highCtx, cancelHigh := context.WithCancel(context.Background())
+	go p.scheduler.Download(highCtx, namespace, blob.Digest, WithPriority(PriorityHigh))
+
+	waitForTorrentPriority(t, p.scheduler, h, PriorityHigh)
+	high := maxConns(p.scheduler, h)
+	require.Equal(4*low, high)
+
+	cancelHigh()
+
+	waitForTorrentPriority(t, p.scheduler, h, PriorityLow)
+	require.Equal(low, maxConns(p.scheduler, h))
+}
+
+func TestDownloadProgress(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	config := configFixture()
+	config.ProgressInterval = time.Millisecond
+
+	seeder := mocks.newPeer(config)
+	leecher := mocks.newPeer(config)
+
+	blob := core.SizedBlobFixture(256*uint64(memsize.KB), 16*uint64(memsize.KB))
+	namespace := core.TagFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+
+	seeder.writeTorrent(namespace, blob)
+	require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
+
+	var progress []Progress
+	require.NoError(leecher.scheduler.Download(
+		context.Background(), namespace, blob.Digest,
+		WithProgress(func(p Progress) { progress = append(progress, p) })))
+	leecher.checkTorrent(t, namespace, blob)
+
+	require.NotEmpty(progress)
+	for i := 1; i < len(progress); i++ {
+		require.True(progress[i].BytesDownloaded >= progress[i-1].BytesDownloaded)
+	}
+	last := progress[len(progress)-1]
+	require.Equal(blob.Length(), last.Length)
+	require.Equal(last.Length, last.BytesDownloaded)
+}
+
 func TestSchedulerProbe(t *testing.T) {
 	require := require.New(t)
 
@@ -503,3 +887,218 @@ func TestSchedulerProbeTimeoutsIfDeadlocked(t *testing.T) {
 
 	close(release)
 }
+
+func TestDownloadBundle(t *testing.T) {
+	for _, supported := range []bool{true, false} {
+		t.Run(fmt.Sprintf("supported=%t", supported), func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, cleanup := newTestMocks(t)
+			defer cleanup()
+
+			bundleAnnounces := mocks.useCountingTracker("/bundles/announce", supported)
+
+			config := configFixture()
+			config.ProgressInterval = time.Millisecond
+
+			seeder := mocks.newPeer(config)
+			leecher := mocks.newPeer(config)
+
+			namespace := core.TagFixture()
+			manifest := core.DigestFixture()
+
+			var blobs []*core.BlobFixture
+			var layers []core.Digest
+			var length int64
+			for i := 0; i < 5; i++ {
+				blob := core.NewBlobFixture()
+				blobs = append(blobs, blob)
+				layers = append(layers, blob.Digest)
+				length += blob.Length()
+
+				mocks.metaInfoClient.EXPECT().Download(
+					namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+
+				seeder.writeTorrent(namespace, blob)
+				require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
+			}
+
+			var progress []Progress
+			require.NoError(leecher.scheduler.DownloadBundle(
+				context.Background(), namespace, manifest, layers,
+				WithProgress(func(p Progress) { progress = append(progress, p) })))
+			for _, blob := range blobs {
+				leecher.checkTorrent(t, namespace, blob)
+			}
+
+			// Only the leecher announces bundles, and falls back to announcing
+			// individually after a single rejected bundle announce.
+			if supported {
+				require.True(bundleAnnounces.Load() > 0)
+			} else {
+				require.Equal(int64(1), bundleAnnounces.Load())
+			}
+
+			require.NotEmpty(progress)
+			last := progress[len(progress)-1]
+			require.Equal(length, last.Length)
+			require.Equal(last.Length, last.BytesDownloaded)
+		})
+	}
+}
+
+func TestDownloadBatchAnnounce(t *testing.T) {
+	for _, supported := range []bool{true, false} {
+		t.Run(fmt.Sprintf("supported=%t", supported), func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, cleanup := newTestMocks(t)
+			defer cleanup()
+
+			batchAnnounces := mocks.useCountingTracker("/batch/announce", supported)
+
+			config := configFixture()
+
+			seeder := mocks.newPeer(config)
+			leecher := mocks.newPeer(config)
+
+			namespace := core.TagFixture()
+
+			var blobs []*core.BlobFixture
+			for i := 0; i < 5; i++ {
+				blob := core.NewBlobFixture()
+				blobs = append(blobs, blob)
+
+				mocks.metaInfoClient.EXPECT().Download(
+					namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+			}
+
+			// Start downloading before any seeder exists, such that the torrents
+			// are due for announce together on the next tick.
+			var wg sync.WaitGroup
+			for _, blob := range blobs {
+				blob := blob
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					require.NoError(leecher.scheduler.Download(context.Background(), namespace, blob.Digest))
+					leecher.checkTorrent(t, namespace, blob)
+				}()
+			}
+
+			require.NoError(testutil.PollUntilTrue(10*time.Second, func() bool {
+				return batchAnnounces.Load() > 0
+			}))
+
+			for _, blob := range blobs {
+				seeder.writeTorrent(namespace, blob)
+				require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
+			}
+			wg.Wait()
+
+			if !supported {
+				// Each peer falls back to announcing individually after a single
+				// rejected batch announce.
+				require.True(batchAnnounces.Load() <= 2)
+			}
+		})
+	}
+}
+
+func TestDownloadStreamsSeedersBeforeNextAnnounce(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	namespace := core.TagFixture()
+	blob := core.NewBlobFixture()
+
+	streams := mocks.useCountingTracker("/peers/stream", true)
+
+	config := configFixture()
+
+	seeder := mocks.newPeer(config)
+	leecher := mocks.newPeer(config)
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(blob.MetaInfo, nil).Times(2)
+
+	// The leecher announces before any seeder exists.
+	errc := make(chan error)
+	go func() {
+		errc <- leecher.scheduler.Download(context.Background(), namespace, blob.Digest)
+	}()
+	require.NoError(testutil.PollUntilTrue(5*time.Second, func() bool {
+		return streams.Load() > 0
+	}))
+
+	// The seeder must be pushed to the leecher, since the leecher does not
+	// announce again until the default announce interval elapses.
+	seeder.writeTorrent(namespace, blob)
+	require.NoError(seeder.scheduler.Download(context.Background(), namespace, blob.Digest))
+
+	select {
+	case err := <-errc:
+		require.NoError(err)
+	case <-time.After(3 * time.Second):
+		require.FailNow("leecher did not receive streamed seeder")
+	}
+	leecher.checkTorrent(t, namespace, blob)
+}
+
+func TestDownloadBundleNotFound(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	p := mocks.newPeer(configFixture())
+
+	namespace := core.TagFixture()
+	blob := core.NewBlobFixture()
+
+	mocks.metaInfoClient.EXPECT().Download(
+		namespace, blob.Digest).Return(nil, metainfoclient.ErrNotFound)
+
+	require.Equal(ErrTorrentNotFound, p.scheduler.DownloadBundle(
+		context.Background(), namespace, core.DigestFixture(), []core.Digest{blob.Digest}))
+}
+
+func TestDownloadBundleCancelledRemovesTorrents(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newTestMocks(t)
+	defer cleanup()
+
+	p := mocks.newPeer(configFixture())
+
+	namespace := core.TagFixture()
+
+	var layers []core.Digest
+	var hashes []core.InfoHash
+	for i := 0; i < 3; i++ {
+		blob := core.NewBlobFixture()
+		layers = append(layers, blob.Digest)
+		hashes = append(hashes, blob.MetaInfo.InfoHash())
+
+		mocks.metaInfoClient.EXPECT().Download(
+			namespace, blob.Digest).Return(blob.MetaInfo, nil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		errc <- p.scheduler.DownloadBundle(ctx, namespace, core.DigestFixture(), layers)
+	}()
+
+	for _, h := range hashes {
+		waitForTorrentAdded(t, p.scheduler, h)
+	}
+	cancel()
+	require.Equal(context.Canceled, <-errc)
+
+	for _, h := range hashes {
+		waitForTorrentRemoved(t, p.scheduler, h)
+	}
+}
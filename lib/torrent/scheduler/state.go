@@ -16,6 +16,8 @@ package scheduler
 import (
 	"errors"
 	"fmt"
+	"math/rand"
+	"time"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/torrent/networkevent"
@@ -24,6 +26,7 @@ import (
 	"github.com/uber/kraken/lib/torrent/scheduler/connstate"
 	"github.com/uber/kraken/lib/torrent/scheduler/dispatch"
 	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/tracker/announceclient"
 	"go.uber.org/zap"
 
 	"github.com/willf/bitset"
@@ -33,7 +36,8 @@ import (
 type torrentControl struct {
 	namespace    string
 	dispatcher   *dispatch.Dispatcher
-	errors       []chan error
+	waiters      []*waiter
+	priority     Priority
 	localRequest bool
 }
 
@@ -53,16 +57,44 @@ type state struct {
 	torrentControls map[core.InfoHash]*torrentControl
 	conns           *connstate.State
 	announceQueue   announcequeue.Queue
+	bundles         map[core.Digest]*bundle
+	bundleByHash    map[core.InfoHash]*bundle
+
+	// batchUnsupportedUntil is set once the tracker rejects batch announces,
+	// and marks when to try them again.
+	batchUnsupportedUntil time.Time
+
+	// peerStreamsUnsupported is set once the tracker rejects peer streams.
+	peerStreamsUnsupported bool
 }
 
 func newState(s *scheduler, aq announcequeue.Queue) *state {
-	return &state{
+	st := &state{
 		sched:           s,
 		torrentControls: make(map[core.InfoHash]*torrentControl),
-		conns: connstate.New(
-			s.config.ConnState, s.clock, s.pctx.PeerID, s.netevents, s.logger),
-		announceQueue: aq,
+		announceQueue:   aq,
+		bundles:         make(map[core.Digest]*bundle),
+		bundleByHash:    make(map[core.InfoHash]*bundle),
 	}
+	// Conn state is only modified from the event loop, so its events may look
+	// up the namespace of their torrent.
+	st.conns = connstate.New(
+		s.config.ConnState, s.clock, s.pctx.PeerID,
+		networkevent.WithNamespace(s.netevents, st.namespace), s.logger)
+	return st
+}
+
+// namespace returns the namespace of the torrent with the given info hash, or
+// empty if the torrent is not active.
+func (s *state) namespace(torrent string) string {
+	h, err := core.NewInfoHashFromHex(torrent)
+	if err != nil {
+		return ""
+	}
+	if ctrl, ok := s.torrentControls[h]; ok {
+		return ctrl.namespace
+	}
+	return ""
 }
 
 // addTorrent initializes a new torrentControl for t. Overwrites any existing
@@ -74,12 +106,14 @@ func (s *state) addTorrent(
 		s.sched.config.Dispatch,
 		s.sched.stats,
 		s.sched.clock,
-		s.sched.netevents,
+		networkevent.WithNamespace(s.sched.netevents, func(string) string { return namespace }),
 		s.sched.eventLoop,
+		s.sched.reputation,
 		s.sched.pctx.PeerID,
 		t,
 		s.sched.logger,
-		s.sched.torrentlog)
+		s.sched.torrentlog,
+		s.dispatchOptions()...)
 	if err != nil {
 		return nil, fmt.Errorf("new dispatcher: %s", err)
 	}
@@ -88,16 +122,81 @@ func (s *state) addTorrent(
 		dispatcher:   d,
 		localRequest: localRequest,
 	}
-	s.announceQueue.Add(t.InfoHash())
+	s.addToAnnounceQueue(t.InfoHash())
 	s.sched.netevents.Produce(networkevent.AddTorrentEvent(
 		t.InfoHash(),
 		s.sched.pctx.PeerID,
+		namespace,
 		t.Bitfield(),
 		s.sched.config.ConnState.MaxOpenConnectionsPerTorrent))
 	s.torrentControls[t.InfoHash()] = ctrl
+	s.setPriority(ctrl, PriorityNormal)
+	s.updatePeerStream()
 	return ctrl, nil
 }
 
+// dispatchOptions returns the options of a new dispatcher. Each dispatcher
+// selects pieces from its own source, seeded from the scheduler source in the
+// order torrents are added.
+func (s *state) dispatchOptions() []dispatch.Option {
+	options := []dispatch.Option{dispatch.WithActivity(s.sched.activity)}
+	if s.sched.rand != nil {
+		options = append(options, dispatch.WithRand(rand.New(rand.NewSource(s.sched.rand.Int63()))))
+	}
+	return options
+}
+
+// addWaiter registers w to be notified when ctrl's torrent completes or fails.
+func (s *state) addWaiter(ctrl *torrentControl, w *waiter) {
+	ctrl.waiters = append(ctrl.waiters, w)
+	s.updatePriority(ctrl)
+}
+
+// removeWaiter unregisters w from ctrl. Returns false if w was not waiting,
+// i.e. it was already notified.
+func (s *state) removeWaiter(ctrl *torrentControl, w *waiter) bool {
+	for i, cur := range ctrl.waiters {
+		if cur == w {
+			ctrl.waiters = append(ctrl.waiters[:i], ctrl.waiters[i+1:]...)
+			s.updatePriority(ctrl)
+			return true
+		}
+	}
+	return false
+}
+
+// notifyWaiters sends err to all clients waiting on ctrl, and unregisters them.
+func (s *state) notifyWaiters(ctrl *torrentControl, err error) {
+	for _, w := range ctrl.waiters {
+		w.errc <- err
+	}
+	ctrl.waiters = nil
+	s.updatePriority(ctrl)
+}
+
+// updatePriority sets ctrl to the highest priority of its waiters.
+func (s *state) updatePriority(ctrl *torrentControl) {
+	p := PriorityNormal
+	for i, w := range ctrl.waiters {
+		if i == 0 || w.priority > p {
+			p = w.priority
+		}
+	}
+	if p != ctrl.priority {
+		s.setPriority(ctrl, p)
+	}
+}
+
+// setPriority apportions connections, piece requests and bandwidth to ctrl's
+// torrent according to p.
+func (s *state) setPriority(ctrl *torrentControl, p Priority) {
+	ctrl.priority = p
+	w := s.sched.config.Priority.weight(p)
+	s.conns.SetWeight(ctrl.dispatcher.InfoHash(), w)
+	s.sched.handshaker.SetBandwidthWeight(ctrl.dispatcher.InfoHash(), w)
+	ctrl.dispatcher.SetWeight(w)
+}
+
 // removeTorrent tears down the torrentControl associated with h, sending err to
 // all clients waiting on this torrent.
 func (s *state) removeTorrent(h core.InfoHash, err error) {
@@ -107,14 +206,36 @@ func (s *state) removeTorrent(h core.InfoHash, err error) {
 	}
 	if !ctrl.dispatcher.Complete() {
 		ctrl.dispatcher.TearDown()
-		s.announceQueue.Eject(h)
-		for _, errc := range ctrl.errors {
-			errc <- err
-		}
-		s.sched.netevents.Produce(networkevent.TorrentCancelledEvent(h, s.sched.pctx.PeerID))
+		s.ejectFromAnnounceQueue(h)
+		s.notifyWaiters(ctrl, err)
+		e := networkevent.TorrentCancelledEvent(h, s.sched.pctx.PeerID)
+		e.Namespace = ctrl.namespace
+		s.sched.netevents.Produce(e)
 		s.sched.torrentArchive.DeleteTorrent(ctrl.dispatcher.Digest())
 	}
+	s.conns.SetWeight(h, 1)
+	s.sched.handshaker.SetBandwidthWeight(h, 1)
 	delete(s.torrentControls, h)
+	s.updatePeerStream()
+}
+
+// updatePeerStream points the peer stream at the current set of incomplete
+// torrents, unless peer streams are disabled or not supported by the tracker.
+func (s *state) updatePeerStream() {
+	if s.sched.config.DisablePeerStreams || s.peerStreamsUnsupported {
+		return
+	}
+	var torrents []announceclient.AnnounceTorrent
+	for h, ctrl := range s.torrentControls {
+		if ctrl.dispatcher.Complete() {
+			continue
+		}
+		torrents = append(torrents, announceclient.AnnounceTorrent{
+			Digest:   ctrl.dispatcher.Digest(),
+			InfoHash: h,
+		})
+	}
+	s.sched.updatePeerStream(torrents)
 }
 
 // addOutgoingConn adds a conn, initialized by us, to state. The conn must already
@@ -161,6 +282,35 @@ func (s *state) addIncomingConn(
 	return nil
 }
 
+// connectPeers opens connections to peers for ctrl's torrent while there is
+// capacity. Connections are added as pending and handshaked asynchronously.
+func (s *state) connectPeers(ctrl *torrentControl, peers []*core.PeerInfo) {
+	if ctrl.dispatcher.Complete() {
+		// Torrent is already complete, don't open any new connections.
+		return
+	}
+	h := ctrl.dispatcher.InfoHash()
+	for _, p := range peers {
+		if p.PeerID == s.sched.pctx.PeerID {
+			// Tracker may return our own peer.
+			continue
+		}
+		if s.conns.Blacklisted(p.PeerID, h) {
+			continue
+		}
+		if err := s.conns.AddPending(p.PeerID, h, nil); err != nil {
+			if err == connstate.ErrTorrentAtCapacity {
+				break
+			}
+			continue
+		}
+		// 和对端 peer 创建连接
+		s.sched.activity.Add(1)
+		go s.sched.initializeOutgoingHandshake(
+			p, ctrl.dispatcher.Stat(), ctrl.dispatcher.RemoteBitfields(), ctrl.namespace)
+	}
+}
+
 func (s *state) log(args ...interface{}) *zap.SugaredLogger {
 	return s.sched.log(args...)
 }
@@ -17,12 +17,14 @@ import (
 	"flag"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
 	"reflect"
 	"strconv"
 	"testing"
 	"time"
 
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 
 	"github.com/golang/mock/gomock"
@@ -81,6 +83,12 @@ func configFixture() Config {
 	}.applyDefaults()
 }
 
+func quicConfigFixture() Config {
+	config := configFixture()
+	config.Conn.Transport = conn.TransportQUIC
+	return config
+}
+
 type testMocks struct {
 	ctrl           *gomock.Controller
 	metaInfoClient *mockmetainfoclient.MockClient
@@ -105,6 +113,27 @@ func newTestMocks(t gomock.TestReporter) (*testMocks, func()) {
 	}, cleanup.Run
 }
 
+// useCountingTracker replaces the tracker of peers created afterwards with one
+// which counts requests to path. If supported is false, requests to path are
+// rejected like an older tracker would. Returns the request count.
+func (m *testMocks) useCountingTracker(path string, supported bool) *atomic.Int64 {
+	h := trackerserver.Fixture().Handler()
+	n := atomic.NewInt64(0)
+	addr, stop := testutil.StartServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			n.Inc()
+			if !supported {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	}))
+	m.cleanup.Add(stop)
+	m.trackerAddr = addr
+	return n
+}
+
 type testPeer struct {
 	pctx           core.PeerContext
 	scheduler      *scheduler
@@ -115,7 +144,7 @@ type testPeer struct {
 	cleanup        *testutil.Cleanup
 }
 
-func (m *testMocks) newPeer(config Config, options ...option) *testPeer {
+func (m *testMocks) newPeer(config Config, options ...Option) *testPeer {
 	var cleanup testutil.Cleanup
 	m.cleanup.Add(cleanup.Run)
 
@@ -135,6 +164,7 @@ func (m *testMocks) newPeer(config Config, options ...option) *testPeer {
 	ac := announceclient.New(pctx, hashring.NoopPassiveRing(hostlist.Fixture(m.trackerAddr)), nil)
 	tp := networkevent.NewTestProducer()
 
+	options = append([]Option{WithTLS(conn.TLSFixture())}, options...)
 	s, err := newScheduler(config, ta, stats, pctx, ac, tp, options...)
 	if err != nil {
 		panic(err)
@@ -302,6 +332,48 @@ func waitForTorrentAdded(t *testing.T, s *scheduler, infoHash core.InfoHash) {
 	}
 }
 
+type torrentPriorityEvent struct {
+	infoHash core.InfoHash
+	result   chan Priority
+}
+
+func (e torrentPriorityEvent) apply(s *state) {
+	ctrl, ok := s.torrentControls[e.infoHash]
+	if !ok {
+		e.result <- PriorityNormal
+		return
+	}
+	e.result <- ctrl.priority
+}
+
+func waitForTorrentPriority(t *testing.T, s *scheduler, infoHash core.InfoHash, p Priority) {
+	err := testutil.PollUntilTrue(5*time.Second, func() bool {
+		result := make(chan Priority)
+		s.eventLoop.send(torrentPriorityEvent{infoHash, result})
+		return <-result == p
+	})
+	if err != nil {
+		t.Fatalf(
+			"scheduler=%s did not set priority=%s for hash=%s: %s",
+			s.pctx.PeerID, p, infoHash, err)
+	}
+}
+
+type maxConnsEvent struct {
+	infoHash core.InfoHash
+	result   chan int
+}
+
+func (e maxConnsEvent) apply(s *state) {
+	e.result <- s.conns.MaxConns(e.infoHash)
+}
+
+func maxConns(s *scheduler, infoHash core.InfoHash) int {
+	result := make(chan int)
+	s.eventLoop.send(maxConnsEvent{infoHash, result})
+	return <-result
+}
+
 // eventWatcher wraps an eventLoop and watches all events being sent. Note, clients
 // must call WaitFor else all sends will block.
 type eventWatcher struct {
@@ -311,7 +383,7 @@ type eventWatcher struct {
 
 func newEventWatcher() *eventWatcher {
 	return &eventWatcher{
-		l:      newEventLoop(),
+		l:      newEventLoop(nil),
 		events: make(chan event),
 	}
 }
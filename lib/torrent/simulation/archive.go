@@ -0,0 +1,270 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+
+	"github.com/willf/bitset"
+)
+
+var (
+	errPieceNotComplete   = errors.New("piece not complete")
+	errWritePieceConflict = errors.New("piece is already being written to")
+)
+
+// TorrentArchive is an in-memory storage.TorrentArchive. Blob content is shared
+// by every archive in a simulation, so each archive only tracks which pieces
+// it has. Written pieces are still verified against their piece sums.
+type TorrentArchive struct {
+	blobs map[core.Digest]*core.BlobFixture
+
+	mu       sync.Mutex
+	torrents map[core.Digest]*Torrent
+}
+
+// NewTorrentArchive creates a new TorrentArchive which serves blobs. The blobs
+// map must not be modified afterwards.
+func NewTorrentArchive(blobs map[core.Digest]*core.BlobFixture) *TorrentArchive {
+	return &TorrentArchive{
+		blobs:    blobs,
+		torrents: make(map[core.Digest]*Torrent),
+	}
+}
+
+// Seed adds a complete torrent for d.
+func (a *TorrentArchive) Seed(d core.Digest) error {
+	t, err := a.CreateTorrent("", d)
+	if err != nil {
+		return err
+	}
+	tt := t.(*Torrent)
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	for i := 0; i < tt.NumPieces(); i++ {
+		tt.bitfield.Set(uint(i))
+	}
+	return nil
+}
+
+// Stat returns TorrentInfo for d. Ignores namespace.
+func (a *TorrentArchive) Stat(namespace string, d core.Digest) (*storage.TorrentInfo, error) {
+	t, err := a.GetTorrent(namespace, d)
+	if err != nil {
+		return nil, err
+	}
+	return t.Stat(), nil
+}
+
+// CreateTorrent returns the existing Torrent for d, or initializes an empty
+// one. Returns storage.ErrNotFound if d is not a known blob.
+func (a *TorrentArchive) CreateTorrent(namespace string, d core.Digest) (storage.Torrent, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if t, ok := a.torrents[d]; ok {
+		return t, nil
+	}
+	blob, ok := a.blobs[d]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	t := newTorrent(blob)
+	a.torrents[d] = t
+	return t, nil
+}
+
+// GetTorrent returns the existing Torrent for d. Ignores namespace.
+func (a *TorrentArchive) GetTorrent(namespace string, d core.Digest) (storage.Torrent, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t, ok := a.torrents[d]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return t, nil
+}
+
+// DeleteTorrent deletes the Torrent for d.
+func (a *TorrentArchive) DeleteTorrent(d core.Digest) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.torrents, d)
+	return nil
+}
+
+// Torrent is an in-memory storage.Torrent.
+type Torrent struct {
+	blob *core.BlobFixture
+
+	mu       sync.Mutex // Protects the following fields:
+	bitfield *bitset.BitSet
+	writing  map[int]bool
+}
+
+func newTorrent(blob *core.BlobFixture) *Torrent {
+	return &Torrent{
+		blob:     blob,
+		bitfield: bitset.New(uint(blob.MetaInfo.NumPieces())),
+		writing:  make(map[int]bool),
+	}
+}
+
+// Digest returns the digest of the target blob.
+func (t *Torrent) Digest() core.Digest {
+	return t.blob.MetaInfo.Digest()
+}
+
+// Stat returns the storage.TorrentInfo for t.
+func (t *Torrent) Stat() *storage.TorrentInfo {
+	return storage.NewTorrentInfo(t.blob.MetaInfo, t.Bitfield())
+}
+
+// NumPieces returns the number of pieces in the torrent.
+func (t *Torrent) NumPieces() int {
+	return t.blob.MetaInfo.NumPieces()
+}
+
+// Length returns the length of the target blob.
+func (t *Torrent) Length() int64 {
+	return t.blob.MetaInfo.Length()
+}
+
+// PieceLength returns the length of piece pi.
+func (t *Torrent) PieceLength(pi int) int64 {
+	return t.blob.MetaInfo.GetPieceLength(pi)
+}
+
+// MaxPieceLength returns the longest piece length of the torrent.
+func (t *Torrent) MaxPieceLength() int64 {
+	return t.PieceLength(0)
+}
+
+// InfoHash returns the torrent metainfo hash.
+func (t *Torrent) InfoHash() core.InfoHash {
+	return t.blob.MetaInfo.InfoHash()
+}
+
+// Complete returns whether all pieces have been written.
+func (t *Torrent) Complete() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.bitfield.All()
+}
+
+// BytesDownloaded returns the number of bytes in complete pieces.
+func (t *Torrent) BytesDownloaded() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var n int64
+	for i, ok := t.bitfield.NextSet(0); ok; i, ok = t.bitfield.NextSet(i + 1) {
+		n += t.PieceLength(int(i))
+	}
+	return n
+}
+
+// Bitfield returns a copy of the bitfield of complete pieces.
+func (t *Torrent) Bitfield() *bitset.BitSet {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.bitfield.Clone()
+}
+
+func (t *Torrent) String() string {
+	return fmt.Sprintf("torrent(name=%s, hash=%s)", t.Digest().Hex(), t.InfoHash().Hex())
+}
+
+// HasPiece returns if piece pi is complete.
+func (t *Torrent) HasPiece(pi int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.bitfield.Test(uint(pi))
+}
+
+// MissingPieces returns the indices of all missing pieces.
+func (t *Torrent) MissingPieces() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var missing []int
+	for i := 0; i < t.NumPieces(); i++ {
+		if !t.bitfield.Test(uint(i)) {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// WritePiece verifies src against the piece sum of pi and marks pi complete.
+// The content itself is discarded.
+func (t *Torrent) WritePiece(src storage.PieceReader, pi int) error {
+	if pi < 0 || pi >= t.NumPieces() {
+		return fmt.Errorf("invalid piece index %d: num pieces = %d", pi, t.NumPieces())
+	}
+	if int64(src.Length()) != t.PieceLength(pi) {
+		return fmt.Errorf(
+			"invalid piece length: expected %d, got %d", t.PieceLength(pi), src.Length())
+	}
+
+	t.mu.Lock()
+	if t.bitfield.Test(uint(pi)) {
+		t.mu.Unlock()
+		return storage.ErrPieceComplete
+	}
+	if t.writing[pi] {
+		t.mu.Unlock()
+		return errWritePieceConflict
+	}
+	t.writing[pi] = true
+	t.mu.Unlock()
+
+	h := t.blob.MetaInfo.NewPieceHash()
+	_, err := io.Copy(h, src)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.writing, pi)
+	if err != nil {
+		return fmt.Errorf("write piece: copy: %s", err)
+	}
+	if !t.blob.MetaInfo.VerifyPiece(pi, h.Sum(nil)) {
+		return storage.ErrPieceInvalid
+	}
+	t.bitfield.Set(uint(pi))
+	return nil
+}
+
+// GetPieceReader returns a reader for piece pi.
+func (t *Torrent) GetPieceReader(pi int) (storage.PieceReader, error) {
+	if !t.HasPiece(pi) {
+		return nil, errPieceNotComplete
+	}
+	start := t.blob.MetaInfo.PieceLength() * int64(pi)
+	end := start + t.PieceLength(pi)
+	return piecereader.NewBuffer(t.blob.Content[start:end]), nil
+}
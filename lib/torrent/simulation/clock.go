@@ -0,0 +1,142 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package simulation
+
+import (
+	"sync"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber/kraken/utils/syncutil"
+)
+
+// Clock is a mock clock.Clock shared by every node of a simulation. Each tick
+// of its timers is added to an activity before it is sent, and must be marked
+// as done by its receiver once handled.
+//
+// Ticks are not sent while the clock advances. Instead, expired timers are
+// held until DeliverNext sends their ticks one at a time, in the order timers
+// were created, so each tick can be handled before the next is sent.
+type Clock struct {
+	mock     *clock.Mock
+	activity *syncutil.Activity
+
+	mu     sync.Mutex
+	timers []*timer
+}
+
+// timer forwards the ticks of a mock timer or ticker.
+type timer struct {
+	ticks <-chan time.Time
+	fire  func(now time.Time)
+}
+
+// NewClock creates a new Clock starting at the Unix epoch.
+func NewClock(activity *syncutil.Activity) *Clock {
+	return &Clock{
+		mock:     clock.NewMock(),
+		activity: activity,
+	}
+}
+
+// Add moves the clock forward by d, expiring timers along the way.
+func (c *Clock) Add(d time.Duration) {
+	c.mock.Add(d)
+}
+
+// DeliverNext sends the tick of the first expired timer. Returns false if no
+// timer has expired.
+func (c *Clock) DeliverNext() bool {
+	c.mu.Lock()
+	timers := c.timers
+	c.mu.Unlock()
+
+	for _, t := range timers {
+		select {
+		case now := <-t.ticks:
+			t.fire(now)
+			return true
+		default:
+		}
+	}
+	return false
+}
+
+// register forwards the ticks of a mock timer, sent to ticks, to fire.
+func (c *Clock) register(ticks <-chan time.Time, fire func(time.Time)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.timers = append(c.timers, &timer{ticks, fire})
+}
+
+// send sends now to ch without blocking, dropping it if ch is full like the
+// ticks of a real timer.
+func (c *Clock) send(ch chan time.Time, now time.Time) {
+	c.activity.Add(1)
+	select {
+	case ch <- now:
+	default:
+		c.activity.Done()
+	}
+}
+
+// After implements clock.Clock.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	return c.Timer(d).C
+}
+
+// AfterFunc implements clock.Clock. The function is counted in the activity
+// while it runs.
+func (c *Clock) AfterFunc(d time.Duration, f func()) *clock.Timer {
+	t := c.mock.Timer(d)
+	c.register(t.C, func(time.Time) { c.activity.Go(f) })
+	t.C = nil
+	return t
+}
+
+// Now implements clock.Clock.
+func (c *Clock) Now() time.Time {
+	return c.mock.Now()
+}
+
+// Sleep implements clock.Clock. The caller is marked as done until d elapses.
+func (c *Clock) Sleep(d time.Duration) {
+	ch := c.After(d)
+	c.activity.Done()
+	<-ch
+}
+
+// Tick implements clock.Clock.
+func (c *Clock) Tick(d time.Duration) <-chan time.Time {
+	return c.Ticker(d).C
+}
+
+// Ticker implements clock.Clock.
+func (c *Clock) Ticker(d time.Duration) *clock.Ticker {
+	t := c.mock.Ticker(d)
+	ch := make(chan time.Time, 1)
+	c.register(t.C, func(now time.Time) { c.send(ch, now) })
+	t.C = ch
+	return t
+}
+
+// Timer implements clock.Clock.
+func (c *Clock) Timer(d time.Duration) *clock.Timer {
+	t := c.mock.Timer(d)
+	ch := make(chan time.Time, 1)
+	c.register(t.C, func(now time.Time) { c.send(ch, now) })
+	t.C = ch
+	return t
+}
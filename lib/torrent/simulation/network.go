@@ -0,0 +1,626 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package simulation
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber/kraken/lib/torrent/scheduler/conn"
+	"github.com/uber/kraken/utils/memsize"
+	"github.com/uber/kraken/utils/syncutil"
+)
+
+var (
+	errConnRefused    = errors.New("connection refused")
+	errListenerInUse  = errors.New("listener already in use")
+	errListenerClosed = errors.New("listener closed")
+)
+
+// NetworkConfig defines the links between simulated nodes.
+type NetworkConfig struct {
+
+	// Latency is the one-way delay between any two nodes.
+	Latency time.Duration `yaml:"latency"`
+
+	// EgressBitsPerSec and IngressBitsPerSec limit the link of each node.
+	// Unlimited if zero. Egress may be overridden per node by SetEgress.
+	EgressBitsPerSec  uint64 `yaml:"egress_bits_per_sec"`
+	IngressBitsPerSec uint64 `yaml:"ingress_bits_per_sec"`
+
+	// Loss is the probability that a segment is lost. Connections are reliable
+	// streams, so a lost segment is delayed by RetransmitTimeout, along with
+	// every segment behind it.
+	Loss float64 `yaml:"loss"`
+
+	RetransmitTimeout time.Duration `yaml:"retransmit_timeout"`
+
+	// SegmentSize is the unit in which writes are scheduled onto links.
+	SegmentSize uint64 `yaml:"segment_size"`
+
+	// SendWindow is the number of undelivered bytes after which writes to a
+	// connection block.
+	SendWindow uint64 `yaml:"send_window"`
+}
+
+func (c NetworkConfig) applyDefaults() NetworkConfig {
+	if c.RetransmitTimeout == 0 {
+		c.RetransmitTimeout = 200 * time.Millisecond
+	}
+	if c.SegmentSize == 0 {
+		c.SegmentSize = 64 * memsize.KB
+	}
+	if c.SendWindow == 0 {
+		c.SendWindow = 4 * memsize.MB
+	}
+	return c
+}
+
+// Network is an in-memory network whose delays are measured on a mock clock.
+// Segments written to a connection are scheduled onto the links of both ends
+// as of the time they were written, and only become readable once delivered at
+// or after their arrival time.
+//
+// Writes are scheduled lazily, ordered by write time and then by connection,
+// so contention between senders does not depend on the order in which
+// goroutines happen to write.
+//
+// Goroutines blocked reading from or writing to a connection are marked as
+// done in an activity until they are woken, so a simulation can tell when all
+// nodes wait on the network.
+type Network struct {
+	config   NetworkConfig
+	clk      clock.Clock
+	activity *syncutil.Activity
+
+	mu       sync.Mutex // Protects the following fields:
+	rand     *rand.Rand
+	seq      uint64
+	nodes    map[string]*node
+	unsent   []*segment
+	inflight segmentHeap
+	arrived  []*segment
+	unread   int
+}
+
+// node is a host in the network.
+type node struct {
+	addr        string
+	egress      uint64
+	ingress     uint64
+	egressFree  time.Time
+	ingressFree time.Time
+	listener    *listener
+
+	// dials counts connections dialed from this node, by destination.
+	dials map[string]int
+}
+
+// NewNetwork creates a new Network. Loss is drawn from a source seeded with
+// seed. The activity may be nil.
+func NewNetwork(
+	config NetworkConfig, clk clock.Clock, seed int64, activity *syncutil.Activity) *Network {
+
+	return &Network{
+		config:   config.applyDefaults(),
+		clk:      clk,
+		activity: activity,
+		rand:     rand.New(rand.NewSource(seed)),
+		nodes:    make(map[string]*node),
+	}
+}
+
+// Transport returns a conn.Transport for the node at addr. The address passed
+// to Listen is ignored: the node always listens on addr.
+func (n *Network) Transport(addr string) conn.Transport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	nd, ok := n.nodes[addr]
+	if !ok {
+		nd = &node{
+			addr:    addr,
+			egress:  n.config.EgressBitsPerSec,
+			ingress: n.config.IngressBitsPerSec,
+			dials:   make(map[string]int),
+		}
+		n.nodes[addr] = nd
+	}
+	return &transport{n, nd}
+}
+
+// SetEgress overrides the egress limit of the node at addr, which must have a
+// Transport. Unlimited if zero.
+func (n *Network) SetEgress(addr string, bitsPerSec uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.nodes[addr].egress = bitsPerSec
+}
+
+// Deliver makes all segments which have arrived by the current time readable.
+func (n *Network) Deliver() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.receive()
+	for _, seg := range n.arrived {
+		n.deliver(seg)
+	}
+	n.dropArrived(0, len(n.arrived))
+}
+
+// DeliverNext delivers the first segment which has arrived by the current time
+// on a stream whose reader waits for it, such that each delivery wakes at most
+// one reader. Segments which close a stream, or which belong to a closed
+// stream, do not wait for a reader. Returns false if no segment was delivered.
+func (n *Network) DeliverNext() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.receive()
+	seen := make(map[*stream]bool)
+	for i, seg := range n.arrived {
+		s := seg.stream
+		if seen[s] {
+			// Streams are delivered in order.
+			continue
+		}
+		seen[s] = true
+		if seg.fin || s.closed() || s.readable.waiters > 0 {
+			n.deliver(seg)
+			n.dropArrived(i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+// receive moves segments which have arrived by the current time from inflight
+// to arrived, after scheduling unsent segments. Must be called with n.mu held.
+func (n *Network) receive() {
+	n.flush()
+	now := n.clk.Now()
+	for len(n.inflight) > 0 && !n.inflight[0].deliverAt.After(now) {
+		n.arrived = append(n.arrived, heap.Pop(&n.inflight).(*segment))
+	}
+}
+
+// dropArrived removes arrived segments i through j-1. Must be called with n.mu
+// held.
+func (n *Network) dropArrived(i, j int) {
+	k := copy(n.arrived[i:], n.arrived[j:])
+	for l := i + k; l < len(n.arrived); l++ {
+		n.arrived[l] = nil
+	}
+	n.arrived = n.arrived[:i+k]
+}
+
+// deliver makes seg readable and wakes the ends of its stream. Must be called
+// with n.mu held.
+func (n *Network) deliver(seg *segment) {
+	s := seg.stream
+	s.inflight -= len(seg.data)
+	if seg.fin {
+		// The remote end learns of the close once all data sent before it has
+		// arrived, after which its writes fail.
+		s.eof = true
+		s.reverse.reset = true
+		n.wake(&s.reverse.writable)
+	} else if !s.closed() {
+		s.buf = append(s.buf, seg.data...)
+		n.unread += len(seg.data)
+	}
+	n.wake(&s.readable)
+	n.wake(&s.writable)
+}
+
+// Unread returns the number of delivered bytes which have not been read yet.
+func (n *Network) Unread() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.unread
+}
+
+// transmitTime returns the duration nbytes occupies a link of bitsPerSec.
+func transmitTime(nbytes int, bitsPerSec uint64) time.Duration {
+	if bitsPerSec == 0 {
+		return 0
+	}
+	return time.Duration(float64(nbytes) * 8 / float64(bitsPerSec) * float64(time.Second))
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// flush schedules unsent segments onto links. Must be called with n.mu held.
+func (n *Network) flush() {
+	sort.SliceStable(n.unsent, func(i, j int) bool {
+		a, b := n.unsent[i], n.unsent[j]
+		if !a.sentAt.Equal(b.sentAt) {
+			return a.sentAt.Before(b.sentAt)
+		}
+		return a.stream.key < b.stream.key
+	})
+	for i, seg := range n.unsent {
+		if seg.stream.closed() && !seg.fin {
+			seg.stream.inflight -= len(seg.data)
+		} else {
+			n.schedule(seg)
+		}
+		n.unsent[i] = nil
+	}
+	n.unsent = n.unsent[:0]
+}
+
+// schedule schedules seg onto the links of its stream. Must be called with
+// n.mu held.
+func (n *Network) schedule(seg *segment) {
+	s := seg.stream
+	data := seg.data
+
+	start := maxTime(seg.sentAt, s.src.egressFree)
+	s.src.egressFree = start.Add(transmitTime(len(data), s.src.egress))
+
+	arrival := s.src.egressFree.Add(n.config.Latency)
+	if n.config.Loss > 0 && n.rand.Float64() < n.config.Loss {
+		arrival = arrival.Add(n.config.RetransmitTimeout)
+	}
+	if s.dst.ingress > 0 {
+		// Ingress is reserved when the segment is sent rather than when it
+		// arrives, which may reorder contention between senders slightly.
+		arrival = maxTime(arrival, s.dst.ingressFree.Add(transmitTime(len(data), s.dst.ingress)))
+		s.dst.ingressFree = arrival
+	}
+	// Streams are delivered in order.
+	arrival = maxTime(arrival, s.lastArrival)
+	s.lastArrival = arrival
+
+	n.seq++
+	seg.deliverAt = arrival
+	seg.seq = n.seq
+	heap.Push(&n.inflight, seg)
+}
+
+// segment is a chunk of a stream in flight, or the end of the stream if fin is
+// set.
+type segment struct {
+	sentAt    time.Time
+	deliverAt time.Time
+	seq       uint64
+	stream    *stream
+	data      []byte
+	fin       bool
+}
+
+// segmentHeap orders segments by delivery time, breaking ties by schedule order.
+type segmentHeap []*segment
+
+func (h segmentHeap) Len() int { return len(h) }
+
+func (h segmentHeap) Less(i, j int) bool {
+	if h[i].deliverAt.Equal(h[j].deliverAt) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].deliverAt.Before(h[j].deliverAt)
+}
+
+func (h segmentHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *segmentHeap) Push(x interface{}) { *h = append(*h, x.(*segment)) }
+
+func (h *segmentHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return s
+}
+
+// stream is one direction of a connection. All fields are protected by the
+// Network lock.
+type stream struct {
+	key         string
+	src, dst    *node
+	reverse     *stream
+	readable    waitQueue
+	writable    waitQueue
+	buf         []byte
+	inflight    int
+	lastArrival time.Time
+
+	// writerClosed and readerClosed are set when src and dst close their
+	// connection respectively. eof is set once the close of src has been
+	// delivered to dst, and reset once the close of dst has been delivered to
+	// src.
+	writerClosed bool
+	readerClosed bool
+	eof          bool
+	reset        bool
+}
+
+// newStream creates a stream from src to dst over the connection identified by
+// conn, which must not depend on goroutine scheduling.
+func newStream(n *Network, conn string, src, dst *node) *stream {
+	return &stream{
+		key:      fmt.Sprintf("%s %s", conn, src.addr),
+		src:      src,
+		dst:      dst,
+		readable: waitQueue{cond: sync.NewCond(&n.mu)},
+		writable: waitQueue{cond: sync.NewCond(&n.mu)},
+	}
+}
+
+// closed returns true if either end of s has closed its connection, after
+// which undelivered data is discarded.
+func (s *stream) closed() bool {
+	return s.writerClosed || s.readerClosed
+}
+
+// waitQueue holds the goroutines blocked on one end of a stream.
+type waitQueue struct {
+	cond    *sync.Cond
+	waiters int
+}
+
+// wait blocks the caller on q until woken, marking it as done in the activity
+// of n in the meantime. Must be called with n.mu held.
+func (n *Network) wait(q *waitQueue) {
+	q.waiters++
+	n.activity.Done()
+	q.cond.Wait()
+}
+
+// wake wakes all goroutines blocked on q, adding them back to the activity of
+// n. Must be called with n.mu held.
+func (n *Network) wake(q *waitQueue) {
+	n.activity.Add(q.waiters)
+	q.waiters = 0
+	q.cond.Broadcast()
+}
+
+// addr is the address of a simulated node.
+type addr string
+
+func (a addr) Network() string { return "simulation" }
+
+func (a addr) String() string { return string(a) }
+
+// pipeConn is one end of a simulated connection.
+type pipeConn struct {
+	network *Network
+	in      *stream
+	out     *stream
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	c.network.mu.Lock()
+	defer c.network.mu.Unlock()
+
+	s := c.in
+	for len(s.buf) == 0 {
+		if s.readerClosed {
+			return 0, io.ErrClosedPipe
+		}
+		if s.eof {
+			return 0, io.EOF
+		}
+		c.network.wait(&s.readable)
+	}
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	c.network.unread -= n
+	return n, nil
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	c.network.mu.Lock()
+	defer c.network.mu.Unlock()
+
+	s := c.out
+	size := int(c.network.config.SegmentSize)
+	window := int(c.network.config.SendWindow)
+	var written int
+	for written < len(b) {
+		for s.inflight >= window && !s.writerClosed && !s.reset {
+			c.network.wait(&s.writable)
+		}
+		if s.writerClosed || s.reset {
+			return written, io.ErrClosedPipe
+		}
+		end := written + size
+		if end > len(b) {
+			end = len(b)
+		}
+		data := make([]byte, end-written)
+		copy(data, b[written:end])
+		c.network.unsent = append(c.network.unsent, &segment{
+			sentAt: c.network.clk.Now(),
+			stream: s,
+			data:   data,
+		})
+		s.inflight += len(data)
+		written = end
+	}
+	return written, nil
+}
+
+// Close resets the connection: data which has not been delivered yet is
+// discarded in both directions, so the peer does not observe writes which
+// raced with the close. The peer reads EOF once the close has crossed the
+// network.
+func (c *pipeConn) Close() error {
+	c.network.mu.Lock()
+	defer c.network.mu.Unlock()
+
+	c.close()
+	return nil
+}
+
+// close closes c. Must be called with the Network lock held.
+func (c *pipeConn) close() {
+	n := c.network
+	if !c.in.readerClosed {
+		n.unread -= len(c.in.buf)
+		c.in.buf = nil
+		c.in.readerClosed = true
+	}
+	if !c.out.writerClosed {
+		c.out.writerClosed = true
+		n.unsent = append(n.unsent, &segment{
+			sentAt: n.clk.Now(),
+			stream: c.out,
+			fin:    true,
+		})
+	}
+	n.wake(&c.in.readable)
+	n.wake(&c.out.writable)
+}
+
+func (c *pipeConn) LocalAddr() net.Addr { return addr(c.out.src.addr) }
+
+func (c *pipeConn) RemoteAddr() net.Addr { return addr(c.out.dst.addr) }
+
+// Deadlines are ignored, since they are measured on the system clock. Blocked
+// reads and writes are released by closing the connection instead.
+
+func (c *pipeConn) SetDeadline(t time.Time) error { return nil }
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error { return nil }
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// transport implements conn.Transport for a single node.
+type transport struct {
+	network *Network
+	node    *node
+}
+
+func (t *transport) Listen(string) (net.Listener, error) {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+
+	if t.node.listener != nil {
+		return nil, errListenerInUse
+	}
+	l := &listener{
+		network: t.network,
+		node:    t.node,
+		cond:    sync.NewCond(&t.network.mu),
+	}
+	t.node.listener = l
+	return l, nil
+}
+
+// Dial queues a connection on the listener of the node at a, without waiting
+// for it to be accepted.
+func (t *transport) Dial(a string, timeout time.Duration) (net.Conn, error) {
+	n := t.network
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	dst, ok := n.nodes[a]
+	if !ok || dst.listener == nil {
+		return nil, fmt.Errorf("dial %s: %s", a, errConnRefused)
+	}
+	l := dst.listener
+	// Connections are identified by their ends and the number of earlier
+	// connections between them, which is deterministic as long as a node does
+	// not dial the same peer concurrently.
+	id := fmt.Sprintf("%s>%s#%d", t.node.addr, a, t.node.dials[a])
+	t.node.dials[a]++
+	up := newStream(n, id, t.node, dst)
+	down := newStream(n, id, dst, t.node)
+	up.reverse = down
+	down.reverse = up
+	local := &pipeConn{n, down, up}
+
+	// Queued connections are counted in the activity until accepted.
+	l.queue = append(l.queue, &pipeConn{n, up, down})
+	n.activity.Add(1)
+	l.cond.Broadcast()
+	return local, nil
+}
+
+// listener accepts connections dialed to a node. All fields are protected by
+// the Network lock.
+type listener struct {
+	network *Network
+	node    *node
+	cond    *sync.Cond
+	queue   []*pipeConn
+	closed  bool
+
+	// accepted is set while the last accepted connection is counted in the
+	// activity, which lasts until the caller accepts again.
+	accepted bool
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	n := l.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if l.accepted {
+		l.accepted = false
+		n.activity.Done()
+	}
+	for len(l.queue) == 0 && !l.closed {
+		l.cond.Wait()
+	}
+	if l.closed {
+		return nil, errListenerClosed
+	}
+	c := l.queue[0]
+	l.queue[0] = nil
+	l.queue = l.queue[1:]
+	l.accepted = true
+	return c, nil
+}
+
+// Close closes l, resetting connections which have not been accepted yet.
+func (l *listener) Close() error {
+	n := l.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	l.node.listener = nil
+	for _, c := range l.queue {
+		c.close()
+	}
+	n.activity.Add(-len(l.queue))
+	l.queue = nil
+	l.cond.Broadcast()
+	return nil
+}
+
+func (l *listener) Addr() net.Addr { return addr(l.node.addr) }
@@ -0,0 +1,148 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package simulation
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+// connPair dials b from a over n.
+func connPair(t *testing.T, n *Network) (net.Conn, net.Conn) {
+	require := require.New(t)
+
+	l, err := n.Transport("b:1").Listen(":1")
+	require.NoError(err)
+
+	accepted := make(chan net.Conn)
+	go func() {
+		nc, err := l.Accept()
+		require.NoError(err)
+		accepted <- nc
+	}()
+	a, err := n.Transport("a:1").Dial("b:1", time.Second)
+	require.NoError(err)
+	return a, <-accepted
+}
+
+// readable returns the number of bytes nc can read without blocking.
+func readable(n *Network, nc net.Conn) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return len(nc.(*pipeConn).in.buf)
+}
+
+func TestNetworkLatencyAndBandwidth(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	n := NewNetwork(NetworkConfig{
+		Latency:          10 * time.Millisecond,
+		EgressBitsPerSec: 8 * 1000, // 1000 bytes/sec.
+		SegmentSize:      100,
+	}, clk, 0, nil)
+
+	a, b := connPair(t, n)
+
+	_, err := a.Write(make([]byte, 200))
+	require.NoError(err)
+
+	// First segment leaves after 100ms and arrives 10ms later.
+	clk.Add(109 * time.Millisecond)
+	n.Deliver()
+	require.Equal(0, readable(n, b))
+
+	clk.Add(time.Millisecond)
+	n.Deliver()
+	require.Equal(100, readable(n, b))
+
+	clk.Add(100 * time.Millisecond)
+	n.Deliver()
+	require.Equal(200, readable(n, b))
+
+	buf := make([]byte, 200)
+	_, err = io.ReadFull(b, buf)
+	require.NoError(err)
+	require.Equal(0, n.Unread())
+}
+
+func TestNetworkLossDelaysStream(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	n := NewNetwork(NetworkConfig{
+		Latency:           10 * time.Millisecond,
+		Loss:              1,
+		RetransmitTimeout: 100 * time.Millisecond,
+	}, clk, 0, nil)
+
+	a, b := connPair(t, n)
+
+	_, err := a.Write([]byte("hello"))
+	require.NoError(err)
+
+	clk.Add(100 * time.Millisecond)
+	n.Deliver()
+	require.Equal(0, readable(n, b))
+
+	clk.Add(10 * time.Millisecond)
+	n.Deliver()
+	require.Equal(5, readable(n, b))
+}
+
+func TestNetworkClose(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	n := NewNetwork(NetworkConfig{Latency: 10 * time.Millisecond}, clk, 0, nil)
+
+	a, b := connPair(t, n)
+
+	_, err := a.Write([]byte("hello"))
+	require.NoError(err)
+	clk.Add(10 * time.Millisecond)
+	n.Deliver()
+
+	_, err = a.Write([]byte("world"))
+	require.NoError(err)
+	require.NoError(a.Close())
+
+	// Delivered data is still read before EOF, while undelivered data is
+	// discarded.
+	clk.Add(10 * time.Millisecond)
+	n.Deliver()
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(b, buf)
+	require.NoError(err)
+	require.Equal("hello", string(buf))
+	_, err = b.Read(buf)
+	require.Equal(io.EOF, err)
+	require.Equal(0, n.Unread())
+
+	_, err = b.Write([]byte("hello"))
+	require.Error(err)
+}
+
+func TestNetworkDialWithoutListener(t *testing.T) {
+	n := NewNetwork(NetworkConfig{}, clock.NewMock(), 0, nil)
+
+	_, err := n.Transport("a:1").Dial("b:1", time.Second)
+	require.Error(t, err)
+}
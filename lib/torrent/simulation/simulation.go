@@ -0,0 +1,513 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package simulation
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/networkevent"
+	"github.com/uber/kraken/lib/torrent/scheduler"
+	"github.com/uber/kraken/lib/torrent/scheduler/announcequeue"
+	"github.com/uber/kraken/tracker/announceclient"
+	"github.com/uber/kraken/utils/memsize"
+	"github.com/uber/kraken/utils/syncutil"
+)
+
+// Config defines a simulation, in which a number of agents download a single
+// blob seeded by a number of origins.
+type Config struct {
+
+	// Seed seeds blob content, arrival times, network loss and the random
+	// choices of nodes and the tracker.
+	Seed int64 `yaml:"seed"`
+
+	Agents  int `yaml:"agents"`
+	Origins int `yaml:"origins"`
+
+	BlobSize    uint64 `yaml:"blob_size"`
+	PieceLength uint64 `yaml:"piece_length"`
+	Namespace   string `yaml:"namespace"`
+
+	// ArrivalWindow is the duration over which agents start their downloads,
+	// uniformly at random. All agents start at once if zero.
+	ArrivalWindow time.Duration `yaml:"arrival_window"`
+
+	// Timeout is the simulated duration after which unfinished downloads fail.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Step is the simulated duration the clock advances at a time. Network
+	// delivery and timers happen at step boundaries, so Step should not
+	// exceed the network latency.
+	Step time.Duration `yaml:"step"`
+
+	// StepWait is the maximum real duration to wait for nodes to go idle
+	// after each delivery, arrival or timer, after which the simulation fails.
+	StepWait time.Duration `yaml:"step_wait"`
+
+	Network NetworkConfig `yaml:"network"`
+
+	// OriginEgressBitsPerSec overrides the network egress limit of origins.
+	OriginEgressBitsPerSec uint64 `yaml:"origin_egress_bits_per_sec"`
+
+	Tracker TrackerConfig `yaml:"tracker"`
+
+	// Scheduler is the configuration of every agent and origin. Logging, peer
+	// streams and fault injection are always disabled, and bandwidth limiting
+	// is replaced by the network.
+	Scheduler scheduler.Config `yaml:"scheduler"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.Agents == 0 {
+		c.Agents = 100
+	}
+	if c.Origins == 0 {
+		c.Origins = 3
+	}
+	if c.BlobSize == 0 {
+		c.BlobSize = 64 * memsize.MB
+	}
+	if c.PieceLength == 0 {
+		c.PieceLength = 4 * memsize.MB
+	}
+	if c.Namespace == "" {
+		c.Namespace = "simulation"
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Minute
+	}
+	if c.Step == 0 {
+		c.Step = 10 * time.Millisecond
+	}
+	if c.StepWait == 0 {
+		c.StepWait = time.Second
+	}
+	c.Scheduler.Log.Disable = true
+	c.Scheduler.TorrentLog.Disable = true
+	c.Scheduler.Conn.Bandwidth.Enable = false
+	c.Scheduler.Conn.Bandwidth.Adaptive.Enable = false
+	c.Scheduler.DisablePeerStreams = true
+	c.Scheduler.Conn.FaultInjection.Enable = false
+	return c
+}
+
+// Result summarizes the download times of agents in a simulation.
+type Result struct {
+	Agents int
+
+	// Completed holds the download times of agents which completed, sorted in
+	// ascending order.
+	Completed []time.Duration
+
+	// Failed is the number of agents which failed or timed out.
+	Failed int
+
+	// Elapsed is the simulated duration of the whole simulation.
+	Elapsed time.Duration
+}
+
+// Percentile returns the p-th percentile, between 0 and 100, of completed
+// download times.
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.Completed) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(r.Completed)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(r.Completed) {
+		i = len(r.Completed) - 1
+	}
+	return r.Completed[i]
+}
+
+func (r *Result) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "agents=%d completed=%d failed=%d elapsed=%s\n",
+		r.Agents, len(r.Completed), r.Failed, r.Elapsed)
+	if len(r.Completed) > 0 {
+		fmt.Fprintf(&b, "min=%s p50=%s p90=%s p99=%s max=%s\n",
+			r.Completed[0], r.Percentile(50), r.Percentile(90), r.Percentile(99),
+			r.Completed[len(r.Completed)-1])
+	}
+	return b.String()
+}
+
+// Simulation runs real schedulers for agents and origins in a single process,
+// connected by an in-memory Network and a Tracker, on simulated time.
+//
+// Results are deterministic for a given seed. Every node shares a single
+// Clock, and counts its pending events, messages and goroutines in a shared
+// activity. The simulation wakes one node at a time, by an arrival, a delivery
+// or a timer, and waits for the activity to drain before moving on.
+type Simulation struct {
+	config   Config
+	clk      *Clock
+	rand     *rand.Rand
+	activity *syncutil.Activity
+	network  *Network
+	tracker  *Tracker
+	blob     *core.BlobFixture
+	trace    *traceProducer
+
+	origins []scheduler.Scheduler
+	agents  []scheduler.Scheduler
+
+	// err is set once nodes fail to go idle.
+	err error
+}
+
+// New creates a new Simulation. Network events of all nodes, stamped with
+// simulated time, are emitted to trace.
+func New(config Config, trace networkevent.Producer) (*Simulation, error) {
+	config = config.applyDefaults()
+
+	r := rand.New(rand.NewSource(config.Seed))
+	blob, err := generateBlob(r, config.BlobSize, config.PieceLength)
+	if err != nil {
+		return nil, fmt.Errorf("generate blob: %s", err)
+	}
+	activity := syncutil.NewActivity()
+	clk := NewClock(activity)
+	tracker, err := NewTracker(config.Tracker, clk, r.Int63())
+	if err != nil {
+		return nil, fmt.Errorf("tracker: %s", err)
+	}
+	s := &Simulation{
+		config:   config,
+		clk:      clk,
+		rand:     r,
+		activity: activity,
+		network:  NewNetwork(config.Network, clk, r.Int63(), activity),
+		tracker:  tracker,
+		blob:     blob,
+		trace:    newTraceProducer(clk, trace),
+	}
+	blobs := map[core.Digest]*core.BlobFixture{blob.Digest: blob}
+
+	for i := 0; i < config.Origins; i++ {
+		pctx, err := newPeerContext(fmt.Sprintf("origin-%d", i), true)
+		if err != nil {
+			s.stop()
+			return nil, err
+		}
+		ta := NewTorrentArchive(blobs)
+		if err := ta.Seed(blob.Digest); err != nil {
+			s.stop()
+			return nil, fmt.Errorf("seed origin: %s", err)
+		}
+		sched, err := s.newScheduler(pctx, ta, announceclient.Disabled(), announcequeue.Disabled())
+		if err != nil {
+			s.stop()
+			return nil, fmt.Errorf("origin %d: %s", i, err)
+		}
+		if config.OriginEgressBitsPerSec > 0 {
+			s.network.SetEgress(addrOf(pctx), config.OriginEgressBitsPerSec)
+		}
+		s.origins = append(s.origins, sched)
+		tracker.AddOrigin(core.PeerInfoFromContext(pctx, true))
+	}
+	for i := 0; i < config.Agents; i++ {
+		pctx, err := newPeerContext(fmt.Sprintf("agent-%d", i), false)
+		if err != nil {
+			s.stop()
+			return nil, err
+		}
+		sched, err := s.newScheduler(
+			pctx, NewTorrentArchive(blobs), tracker.Client(pctx), announcequeue.New())
+		if err != nil {
+			s.stop()
+			return nil, fmt.Errorf("agent %d: %s", i, err)
+		}
+		s.agents = append(s.agents, sched)
+		s.trace.addAgent(i, pctx.PeerID)
+	}
+	return s, nil
+}
+
+func newPeerContext(ip string, origin bool) (core.PeerContext, error) {
+	peerID, err := core.HashedPeerID(ip)
+	if err != nil {
+		return core.PeerContext{}, fmt.Errorf("peer id: %s", err)
+	}
+	return core.PeerContext{
+		PeerID: peerID,
+		Zone:   "simulation",
+		IP:     ip,
+		Port:   7000,
+		Origin: origin,
+	}, nil
+}
+
+func (s *Simulation) newScheduler(
+	pctx core.PeerContext,
+	ta *TorrentArchive,
+	ac announceclient.Client,
+	aq announcequeue.Queue) (scheduler.Scheduler, error) {
+
+	sched, err := scheduler.New(
+		s.config.Scheduler, ta, tally.NoopScope, pctx, ac, aq, s.trace,
+		scheduler.WithClock(s.clk),
+		scheduler.WithTransport(s.network.Transport(addrOf(pctx))),
+		scheduler.WithRand(rand.New(rand.NewSource(s.rand.Int63()))),
+		scheduler.WithActivity(s.activity))
+	if err != nil {
+		return nil, err
+	}
+	// Nodes start their loops in the background.
+	s.settle()
+	return sched, nil
+}
+
+func addrOf(pctx core.PeerContext) string {
+	return fmt.Sprintf("%s:%d", pctx.IP, pctx.Port)
+}
+
+func generateBlob(r *rand.Rand, size, pieceLength uint64) (*core.BlobFixture, error) {
+	content := make([]byte, size)
+	r.Read(content)
+	d, err := core.NewDigester().FromBytes(content)
+	if err != nil {
+		return nil, err
+	}
+	mi, err := core.NewMetaInfo(d, bytes.NewReader(content), int64(pieceLength))
+	if err != nil {
+		return nil, err
+	}
+	return core.CustomBlobFixture(content, d, mi), nil
+}
+
+// Run drives the simulation until all agents have finished downloading or the
+// timeout elapses, then stops all nodes. Returns an error if nodes failed to
+// go idle within StepWait, after which results are no longer deterministic.
+func (s *Simulation) Run() (*Result, error) {
+	start := s.clk.Now()
+	arrivals := make([]time.Duration, len(s.agents))
+	for i := range arrivals {
+		if s.config.ArrivalWindow > 0 {
+			arrivals[i] = time.Duration(s.rand.Int63n(int64(s.config.ArrivalWindow)))
+		}
+	}
+	order := make([]int, len(s.agents))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return arrivals[order[i]] < arrivals[order[j]] })
+
+	var wg sync.WaitGroup
+	var next int
+	for s.err == nil && s.trace.finished() < len(s.agents) {
+		elapsed := s.clk.Now().Sub(start)
+		if elapsed >= s.config.Timeout {
+			break
+		}
+		for ; next < len(order) && arrivals[order[next]] <= elapsed; next++ {
+			wg.Add(1)
+			s.download(order[next], &wg)
+			s.settle()
+		}
+		for s.network.DeliverNext() {
+			s.settle()
+		}
+		s.clk.Add(s.config.Step)
+		for s.clk.DeliverNext() {
+			s.settle()
+		}
+	}
+	result := &Result{
+		Agents:  len(s.agents),
+		Elapsed: s.clk.Now().Sub(start),
+	}
+	for i, at := range s.trace.completions() {
+		if !at.IsZero() {
+			result.Completed = append(result.Completed, at.Sub(start)-arrivals[i])
+		}
+	}
+	result.Failed = result.Agents - len(result.Completed)
+	sort.Slice(result.Completed, func(i, j int) bool { return result.Completed[i] < result.Completed[j] })
+
+	// Unfinished downloads fail once their schedulers stop.
+	s.stop()
+	wg.Wait()
+	if s.err != nil {
+		return nil, s.err
+	}
+	return result, nil
+}
+
+// download starts the download of agent i. The download is counted in the
+// activity until the agent adds its torrent, or the download fails first.
+func (s *Simulation) download(i int, wg *sync.WaitGroup) {
+	s.activity.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.agents[i].Download(context.Background(), s.config.Namespace, s.blob.Digest)
+		s.trace.downloadReturned(i, err)
+	}()
+}
+
+// settle waits for all nodes to go idle.
+func (s *Simulation) settle() {
+	if n := s.activity.Wait(0, s.config.StepWait); n > 0 && s.err == nil {
+		s.err = fmt.Errorf(
+			"nodes still busy after %s at %s: %d pending",
+			s.config.StepWait, s.clk.Now().Sub(time.Unix(0, 0)), n)
+	}
+}
+
+// stop stops all nodes.
+func (s *Simulation) stop() {
+	var wg sync.WaitGroup
+	for _, sched := range append(s.origins, s.agents...) {
+		wg.Add(1)
+		go func(sched scheduler.Scheduler) {
+			defer wg.Done()
+			sched.Stop()
+		}(sched)
+	}
+	wg.Wait()
+	s.tracker.Close()
+}
+
+// traceProducer stamps events with simulated time before emitting them, and
+// tracks the downloads of agents from their events.
+type traceProducer struct {
+	clk      clock.Clock
+	producer networkevent.Producer
+	activity *syncutil.Activity
+
+	mu        sync.Mutex
+	agents    map[core.PeerID]int
+	added     []bool
+	completed []time.Time
+	failed    []bool
+}
+
+func newTraceProducer(clk *Clock, producer networkevent.Producer) *traceProducer {
+	return &traceProducer{
+		clk:      clk,
+		producer: producer,
+		activity: clk.activity,
+		agents:   make(map[core.PeerID]int),
+	}
+}
+
+// addAgent tracks the downloads of agent i, which has peerID.
+func (p *traceProducer) addAgent(i int, peerID core.PeerID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.agents[peerID] = i
+	p.added = append(p.added, false)
+	p.completed = append(p.completed, time.Time{})
+	p.failed = append(p.failed, false)
+}
+
+func (p *traceProducer) Produce(e *networkevent.Event) {
+	e.Time = p.clk.Now()
+	p.track(e)
+	if p.producer != nil {
+		p.producer.Produce(e)
+	}
+}
+
+func (p *traceProducer) track(e *networkevent.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peerID, err := core.NewPeerID(e.Self)
+	if err != nil {
+		return
+	}
+	i, ok := p.agents[peerID]
+	if !ok {
+		return
+	}
+	switch e.Name {
+	case networkevent.AddTorrent:
+		p.setAdded(i)
+	case networkevent.TorrentComplete:
+		p.completed[i] = e.Time
+	case networkevent.TorrentCancelled:
+		p.failed[i] = true
+	}
+}
+
+// setAdded marks the download of agent i as running, after which the
+// scheduler of the agent accounts for it. Must be called with p.mu held.
+func (p *traceProducer) setAdded(i int) {
+	if !p.added[i] {
+		p.added[i] = true
+		p.activity.Done()
+	}
+}
+
+// downloadReturned records that the download of agent i returned err. Errors
+// after the torrent was added are reported by events instead, since they may
+// return after the simulation moved on.
+func (p *traceProducer) downloadReturned(i int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.added[i] {
+		if err != nil {
+			p.failed[i] = true
+		} else {
+			p.completed[i] = p.clk.Now()
+		}
+		p.setAdded(i)
+	}
+}
+
+// finished returns the number of agents whose downloads completed or failed.
+func (p *traceProducer) finished() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var n int
+	for i := range p.completed {
+		if !p.completed[i].IsZero() || p.failed[i] {
+			n++
+		}
+	}
+	return n
+}
+
+// completions returns the completion time of each agent, which is zero unless
+// its download completed.
+func (p *traceProducer) completions() []time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]time.Time(nil), p.completed...)
+}
+
+func (p *traceProducer) Close() error {
+	if p.producer == nil {
+		return nil
+	}
+	return p.producer.Close()
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package simulation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber/kraken/lib/torrent/networkevent"
+	"github.com/uber/kraken/utils/memsize"
+)
+
+func TestSimulationSwarm(t *testing.T) {
+	require := require.New(t)
+
+	trace := networkevent.NewTestProducer()
+	s, err := New(Config{
+		Seed:          1,
+		Agents:        10,
+		Origins:       1,
+		BlobSize:      memsize.MB,
+		PieceLength:   64 * memsize.KB,
+		ArrivalWindow: time.Second,
+		Timeout:       5 * time.Minute,
+		Network: NetworkConfig{
+			Latency:           10 * time.Millisecond,
+			EgressBitsPerSec:  80 * memsize.Mbit,
+			IngressBitsPerSec: 80 * memsize.Mbit,
+		},
+	}, trace)
+	require.NoError(err)
+
+	result, err := s.Run()
+	require.NoError(err)
+	t.Log(result)
+	require.Equal(10, result.Agents)
+	require.Equal(0, result.Failed)
+	require.Len(result.Completed, 10)
+
+	// A 1MB blob cannot cross an 80Mbit link in under 100ms.
+	require.True(result.Completed[0] >= 100*time.Millisecond, result.String())
+	require.True(result.Percentile(50) <= result.Percentile(99))
+
+	// Events are stamped with simulated time.
+	complete := make(map[string]bool)
+	for _, e := range trace.Events() {
+		require.False(e.Time.After(time.Unix(0, 0).Add(result.Elapsed)))
+		if e.Name == networkevent.TorrentComplete {
+			complete[e.Self] = true
+		}
+	}
+	// Includes the origin, which starts complete.
+	require.Len(complete, 11)
+}
+
+func TestSimulationDeterministic(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{
+		Seed:          2,
+		Agents:        10,
+		Origins:       2,
+		BlobSize:      memsize.MB,
+		PieceLength:   64 * memsize.KB,
+		ArrivalWindow: 200 * time.Millisecond,
+		Timeout:       5 * time.Minute,
+		Network: NetworkConfig{
+			Latency:          10 * time.Millisecond,
+			EgressBitsPerSec: 80 * memsize.Mbit,
+			Loss:             0.01,
+		},
+	}
+	run := func() *Result {
+		s, err := New(config, nil)
+		require.NoError(err)
+		result, err := s.Run()
+		require.NoError(err)
+		return result
+	}
+	result := run()
+	require.Len(result.Completed, 10)
+	require.Equal(result, run())
+}
+
+func TestResultPercentile(t *testing.T) {
+	require := require.New(t)
+
+	r := &Result{Completed: []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}}
+	require.Equal(time.Duration(1), r.Percentile(0))
+	require.Equal(time.Duration(5), r.Percentile(50))
+	require.Equal(time.Duration(9), r.Percentile(90))
+	require.Equal(time.Duration(10), r.Percentile(100))
+	require.Equal(time.Duration(0), (&Result{}).Percentile(50))
+}
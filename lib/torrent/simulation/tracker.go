@@ -0,0 +1,189 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package simulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/tracker/announceclient"
+	"github.com/uber/kraken/tracker/peerhandoutpolicy"
+	"github.com/uber/kraken/tracker/peerstore"
+)
+
+// TrackerConfig defines the simulated tracker.
+type TrackerConfig struct {
+	AnnounceInterval time.Duration `yaml:"announce_interval"`
+	PeerHandoutLimit int           `yaml:"peer_handout_limit"`
+	PriorityPolicy   string        `yaml:"priority_policy"`
+	PeerTTL          time.Duration `yaml:"peer_ttl"`
+
+	Reputation peerhandoutpolicy.ReputationConfig `yaml:"reputation"`
+}
+
+func (c TrackerConfig) applyDefaults() TrackerConfig {
+	if c.AnnounceInterval == 0 {
+		c.AnnounceInterval = 3 * time.Second
+	}
+	if c.PeerHandoutLimit == 0 {
+		c.PeerHandoutLimit = 50
+	}
+	if c.PriorityPolicy == "" {
+		c.PriorityPolicy = "completeness"
+	}
+	return c
+}
+
+// Tracker hands out peers in-process the same way trackerserver does, using
+// the local peer store on the simulation clock.
+type Tracker struct {
+	config    TrackerConfig
+	peerStore *peerstore.LocalStore
+	policy    *peerhandoutpolicy.PriorityPolicy
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	origins []*core.PeerInfo
+}
+
+// NewTracker creates a new Tracker. Peers are handed out at random from a
+// source seeded with seed.
+func NewTracker(config TrackerConfig, clk clock.Clock, seed int64) (*Tracker, error) {
+	config = config.applyDefaults()
+	var options []peerhandoutpolicy.Option
+	if config.Reputation.Enable {
+		options = append(options, peerhandoutpolicy.WithReputations(
+			peerhandoutpolicy.NewReputations(config.Reputation, clk)))
+	}
+	policy, err := peerhandoutpolicy.NewPriorityPolicy(
+		tally.NoopScope, config.PriorityPolicy, options...)
+	if err != nil {
+		return nil, fmt.Errorf("peer handout policy: %s", err)
+	}
+	return &Tracker{
+		config:    config,
+		peerStore: peerstore.NewLocalStore(peerstore.LocalConfig{TTL: config.PeerTTL}, clk),
+		policy:    policy,
+		rand:      rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// AddOrigin adds an origin which is handed out for every torrent.
+func (t *Tracker) AddOrigin(origin *core.PeerInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.origins = append(t.origins, origin)
+}
+
+// Client returns an announceclient.Client which announces pctx to t.
+func (t *Tracker) Client(pctx core.PeerContext) announceclient.Client {
+	return &trackerClient{t, pctx}
+}
+
+// Close stops the tracker.
+func (t *Tracker) Close() {
+	t.peerStore.Close()
+}
+
+func (t *Tracker) announce(
+	h core.InfoHash,
+	peer *core.PeerInfo,
+	reputations []core.PeerReputation) ([]*core.PeerInfo, error) {
+
+	t.policy.Report(peer, peer.IP, reputations)
+	if err := t.peerStore.UpdatePeer(h, peer); err != nil {
+		return nil, fmt.Errorf("update peer: %s", err)
+	}
+	if peer.Complete {
+		return nil, nil
+	}
+	// The peer store picks peers from the global source, so all peers are
+	// fetched and picked from the tracker source instead.
+	peers, err := t.peerStore.GetPeers(h, math.MaxInt32)
+	if err != nil {
+		return nil, fmt.Errorf("get peers: %s", err)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].PeerID.String() < peers[j].PeerID.String()
+	})
+	t.mu.Lock()
+	t.rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > t.config.PeerHandoutLimit {
+		peers = peers[:t.config.PeerHandoutLimit]
+	}
+	peers = append(peers, t.origins...)
+	t.mu.Unlock()
+	if len(peers) == 0 {
+		return nil, errors.New("no peers available")
+	}
+	return t.policy.SortPeers(peer, peers), nil
+}
+
+type trackerClient struct {
+	tracker *Tracker
+	pctx    core.PeerContext
+}
+
+func (c *trackerClient) Announce(
+	d core.Digest,
+	h core.InfoHash,
+	complete bool,
+	version int,
+	reputations ...core.PeerReputation) ([]*core.PeerInfo, time.Duration, error) {
+
+	peers, err := c.tracker.announce(h, core.PeerInfoFromContext(c.pctx, complete), reputations)
+	if err != nil {
+		return nil, 0, err
+	}
+	return peers, c.tracker.config.AnnounceInterval, nil
+}
+
+func (c *trackerClient) AnnounceBundle(
+	manifest core.Digest,
+	torrents []announceclient.AnnounceTorrent,
+	reputations ...core.PeerReputation) (map[core.InfoHash][]*core.PeerInfo, time.Duration, error) {
+
+	return c.AnnounceBatch(torrents, reputations...)
+}
+
+func (c *trackerClient) AnnounceBatch(
+	torrents []announceclient.AnnounceTorrent,
+	reputations ...core.PeerReputation) (map[core.InfoHash][]*core.PeerInfo, time.Duration, error) {
+
+	result := make(map[core.InfoHash][]*core.PeerInfo)
+	for _, t := range torrents {
+		// Torrents without peers must not fail the rest of the batch.
+		peers, _ := c.tracker.announce(
+			t.InfoHash, core.PeerInfoFromContext(c.pctx, t.Complete), reputations)
+		result[t.InfoHash] = peers
+	}
+	return result, c.tracker.config.AnnounceInterval, nil
+}
+
+func (c *trackerClient) StreamPeers(
+	ctx context.Context,
+	torrents []announceclient.AnnounceTorrent) (<-chan *announceclient.StreamedPeer, error) {
+
+	return nil, announceclient.ErrStreamsUnsupported
+}
@@ -179,7 +179,7 @@ func (t *Torrent) writePiece(src storage.PieceReader, pi int) error {
 	}
 	defer f.Close()
 
-	h := core.PieceHash()
+	h := t.metaInfo.NewPieceHash()
 	r := io.TeeReader(src, h) // Calculates piece sum as we write to file.
 
 	if _, err := f.Seek(t.getFileOffset(pi), 0); err != nil {
@@ -188,8 +188,8 @@ func (t *Torrent) writePiece(src storage.PieceReader, pi int) error {
 	if _, err := io.Copy(f, r); err != nil {
 		return fmt.Errorf("copy: %s", err)
 	}
-	if h.Sum32() != t.metaInfo.GetPieceSum(pi) {
-		return errors.New("invalid piece sum")
+	if !t.metaInfo.VerifyPiece(pi, h.Sum(nil)) {
+		return storage.ErrPieceInvalid
 	}
 
 	if err := t.markPieceComplete(pi); err != nil {
@@ -231,6 +231,9 @@ func (t *Torrent) WritePiece(src storage.PieceReader, pi int) error {
 	if err := t.writePiece(src, pi); err != nil {
 		// Allow other threads to write this piece since we mysteriously failed.
 		piece.markEmpty()
+		if err == storage.ErrPieceInvalid {
+			return err
+		}
 		return fmt.Errorf("write piece: %s", err)
 	}
 
@@ -89,6 +89,49 @@ func TestTorrentWriteUpdatesBytesDownloadedAndBitfield(t *testing.T) {
 	require.Equal(bitsetutil.FromBools(true, false), tor.Bitfield())
 }
 
+func TestTorrentWriteInvalidPiece(t *testing.T) {
+	require := require.New(t)
+
+	cads, cleanup := store.CADownloadStoreFixture()
+	defer cleanup()
+
+	blob := core.SizedBlobFixture(2, 1)
+
+	prepareStore(cads, blob.MetaInfo)
+
+	tor, err := NewTorrent(cads, blob.MetaInfo)
+	require.NoError(err)
+
+	require.Equal(
+		storage.ErrPieceInvalid,
+		tor.WritePiece(piecereader.NewBuffer([]byte{^blob.Content[0]}), 0))
+	require.Equal(int64(0), tor.BytesDownloaded())
+	require.False(tor.HasPiece(0))
+}
+
+func TestTorrentWriteMetaInfoV2Pieces(t *testing.T) {
+	require := require.New(t)
+
+	cads, cleanup := store.CADownloadStoreFixture()
+	defer cleanup()
+
+	blob := core.SizedBlobFixture(8, 4, core.WithMerkleRoot())
+
+	prepareStore(cads, blob.MetaInfo)
+
+	tor, err := NewTorrent(cads, blob.MetaInfo)
+	require.NoError(err)
+
+	require.Equal(
+		storage.ErrPieceInvalid,
+		tor.WritePiece(piecereader.NewBuffer(blob.Content[4:]), 0))
+	require.False(tor.HasPiece(0))
+
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[:4]), 0))
+	require.NoError(tor.WritePiece(piecereader.NewBuffer(blob.Content[4:]), 1))
+	require.True(tor.Complete())
+}
+
 func TestTorrentWriteComplete(t *testing.T) {
 	require := require.New(t)
 
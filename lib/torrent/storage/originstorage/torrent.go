@@ -20,6 +20,7 @@ import (
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piececache"
 	"github.com/uber/kraken/lib/torrent/storage/piecereader"
 
 	"github.com/willf/bitset"
@@ -36,14 +37,19 @@ var (
 type Torrent struct {
 	metaInfo    *core.MetaInfo
 	cas         *store.CAStore
+	pieceCache  *piececache.Cache
 	numComplete *atomic.Int32
 }
 
-// NewTorrent creates a new Torrent.
-func NewTorrent(cas *store.CAStore, mi *core.MetaInfo) (*Torrent, error) {
+// NewTorrent creates a new Torrent. Piece reads are served through pieceCache,
+// which may be shared across torrents.
+func NewTorrent(
+	cas *store.CAStore, mi *core.MetaInfo, pieceCache *piececache.Cache) (*Torrent, error) {
+
 	return &Torrent{
 		cas:         cas,
 		metaInfo:    mi,
+		pieceCache:  pieceCache,
 		numComplete: atomic.NewInt32(int32(mi.NumPieces())),
 	}, nil
 }
@@ -121,7 +127,9 @@ func (t *Torrent) GetPieceReader(pi int) (storage.PieceReader, error) {
 	if pi >= t.NumPieces() {
 		return nil, fmt.Errorf("invalid piece index %d: num pieces = %d", pi, t.NumPieces())
 	}
-	return piecereader.NewFileReader(t.getFileOffset(pi), t.PieceLength(pi), &opener{t}), nil
+	return t.pieceCache.GetPieceReader(t.InfoHash(), pi, t.PieceLength(pi), func() (storage.PieceReader, error) {
+		return piecereader.NewFileReader(t.getFileOffset(pi), t.PieceLength(pi), &opener{t}), nil
+	})
 }
 
 // HasPiece returns if piece pi is complete.
@@ -23,6 +23,7 @@ import (
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/store/metadata"
 	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piececache"
 
 	"github.com/willf/bitset"
 )
@@ -32,13 +33,17 @@ import (
 type TorrentArchive struct {
 	cas           *store.CAStore
 	blobRefresher *blobrefresh.Refresher
+	pieceCache    *piececache.Cache
 }
 
-// NewTorrentArchive creates a new TorrentArchive.
+// NewTorrentArchive creates a new TorrentArchive. pieceCache is shared by all
+// torrents in the archive.
 func NewTorrentArchive(
-	cas *store.CAStore, blobRefresher *blobrefresh.Refresher) *TorrentArchive {
+	cas *store.CAStore,
+	blobRefresher *blobrefresh.Refresher,
+	pieceCache *piececache.Cache) *TorrentArchive {
 
-	return &TorrentArchive{cas, blobRefresher}
+	return &TorrentArchive{cas, blobRefresher, pieceCache}
 }
 
 func (a *TorrentArchive) getMetaInfo(namespace string, d core.Digest) (*core.MetaInfo, error) {
@@ -81,7 +86,7 @@ func (a *TorrentArchive) GetTorrent(namespace string, d core.Digest) (storage.To
 	if err != nil {
 		return nil, err
 	}
-	t, err := NewTorrent(a.cas, mi)
+	t, err := NewTorrent(a.cas, mi, a.pieceCache)
 	if err != nil {
 		return nil, fmt.Errorf("initialize torrent: %s", err)
 	}
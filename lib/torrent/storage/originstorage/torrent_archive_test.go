@@ -23,6 +23,7 @@ import (
 	"github.com/uber/kraken/lib/blobrefresh"
 	"github.com/uber/kraken/lib/metainfogen"
 	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/torrent/storage/piececache"
 	"github.com/uber/kraken/mocks/lib/backend"
 	"github.com/uber/kraken/utils/mockutil"
 	"github.com/uber/kraken/utils/testutil"
@@ -61,7 +62,8 @@ func newArchiveMocks(t *testing.T, namespace string) (*archiveMocks, func()) {
 }
 
 func (m *archiveMocks) new() *TorrentArchive {
-	return NewTorrentArchive(m.cas, m.blobRefresher)
+	return NewTorrentArchive(
+		m.cas, m.blobRefresher, piececache.New(piececache.Config{}, tally.NoopScope))
 }
 
 func TestTorrentArchiveStatNoExistTriggersRefresh(t *testing.T) {
@@ -21,10 +21,12 @@ import (
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/store"
+	"github.com/uber/kraken/lib/torrent/storage/piececache"
 	"github.com/uber/kraken/lib/torrent/storage/piecereader"
 	"github.com/uber/kraken/utils/bitsetutil"
 
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 )
 
 func TestTorrentCreate(t *testing.T) {
@@ -38,7 +40,7 @@ func TestTorrentCreate(t *testing.T) {
 
 	cas.CreateCacheFile(mi.Digest().Hex(), bytes.NewReader(blob.Content))
 
-	tor, err := NewTorrent(cas, mi)
+	tor, err := NewTorrent(cas, mi, piececache.New(piececache.Config{}, tally.NoopScope))
 	require.NoError(err)
 
 	// New torrent
@@ -66,7 +68,7 @@ func TestTorrentGetPieceReaderConcurrent(t *testing.T) {
 
 	cas.CreateCacheFile(mi.Digest().Hex(), bytes.NewReader(blob.Content))
 
-	tor, err := NewTorrent(cas, mi)
+	tor, err := NewTorrent(cas, mi, piececache.New(piececache.Config{}, tally.NoopScope))
 	require.NoError(err)
 
 	wg := sync.WaitGroup{}
@@ -99,7 +101,7 @@ func TestTorrentWritePieceError(t *testing.T) {
 
 	cas.CreateCacheFile(mi.Digest().Hex(), bytes.NewReader(blob.Content))
 
-	tor, err := NewTorrent(cas, mi)
+	tor, err := NewTorrent(cas, mi, piececache.New(piececache.Config{}, tally.NoopScope))
 	require.NoError(err)
 
 	err = tor.WritePiece(piecereader.NewBuffer([]byte{}), 0)
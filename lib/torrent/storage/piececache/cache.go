@@ -0,0 +1,235 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package piececache
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/uber-go/tally"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+)
+
+// LoadFunc reads a piece from its backing storage.
+type LoadFunc func() (storage.PieceReader, error)
+
+type key struct {
+	hash  core.InfoHash
+	piece int
+}
+
+func (k key) String() string {
+	return fmt.Sprintf("%s:%d", k.hash.Hex(), k.piece)
+}
+
+type entry struct {
+	key  key
+	data []byte
+}
+
+// Cache is a size-bounded, in-memory cache of piece payloads which is shared
+// across torrents. Pieces are only admitted once they have been requested
+// AdmissionThreshold times, and are evicted in least-recently-used order.
+// Cache is thread-safe.
+//
+// Cached pieces are always served from memory. Pieces which are not admitted
+// are served by the reader of their backing storage, which may still be sent
+// directly from disk.
+type Cache struct {
+	config Config
+	stats  tally.Scope
+	loads  singleflight.Group
+
+	mu          sync.Mutex // Protects the following fields:
+	entries     map[key]*list.Element
+	lru         *list.List // Front is most recently used.
+	size        uint64
+	frequencies map[key]int
+	requests    int
+}
+
+// New creates a new Cache.
+func New(config Config, stats tally.Scope) *Cache {
+	config = config.applyDefaults()
+
+	stats = stats.Tagged(map[string]string{
+		"module": "piececache",
+	})
+
+	return &Cache{
+		config:      config,
+		stats:       stats,
+		entries:     make(map[key]*list.Element),
+		lru:         list.New(),
+		frequencies: make(map[key]int),
+	}
+}
+
+// GetPieceReader returns a reader for piece i of the torrent identified by h.
+// Cached pieces are served from memory, otherwise load is used to read the
+// piece from its backing storage.
+func (c *Cache) GetPieceReader(
+	h core.InfoHash, i int, length int64, load LoadFunc) (storage.PieceReader, error) {
+
+	if !c.config.Enable {
+		return load()
+	}
+
+	k := key{h, i}
+	data, ok, admit := c.lookup(k, length)
+	if ok {
+		c.stats.Counter("hits").Inc(1)
+		return c.newReader(data), nil
+	}
+	c.stats.Counter("misses").Inc(1)
+
+	if !admit {
+		return load()
+	}
+	// Concurrent misses of the same piece share a single load.
+	v, err, _ := c.loads.Do(k.String(), func() (interface{}, error) {
+		return c.loadPiece(k, length, load)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.newReader(v.([]byte)), nil
+}
+
+// loadPiece reads piece k into memory and admits it into the cache.
+func (c *Cache) loadPiece(k key, length int64, load LoadFunc) ([]byte, error) {
+	c.mu.Lock()
+	e, ok := c.entries[k]
+	c.mu.Unlock()
+	if ok {
+		// Loaded by a previous call which completed since the lookup.
+		return e.Value.(*entry).data, nil
+	}
+
+	pr, err := load()
+	if err != nil {
+		return nil, err
+	}
+	defer pr.Close()
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(pr, data); err != nil {
+		return nil, fmt.Errorf("read piece: %s", err)
+	}
+	c.insert(k, data)
+	return data, nil
+}
+
+// lookup returns the cached data for k if present. Otherwise, records a
+// request for k and returns whether k should be admitted into the cache.
+func (c *Cache) lookup(k key, length int64) (data []byte, ok bool, admit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[k]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*entry).data, true, false
+	}
+
+	c.requests++
+	if c.requests >= c.config.FrequencySampleSize {
+		c.decayFrequencies()
+	}
+	c.frequencies[k]++
+
+	admit = c.frequencies[k] >= c.config.AdmissionThreshold &&
+		uint64(length) <= c.config.MaxPieceSize &&
+		uint64(length) <= c.config.MaxSize
+	return nil, false, admit
+}
+
+// decayFrequencies halves all request frequencies, dropping pieces which are
+// no longer being requested. Must be called with c.mu held.
+func (c *Cache) decayFrequencies() {
+	for k, f := range c.frequencies {
+		if f/2 == 0 {
+			delete(c.frequencies, k)
+		} else {
+			c.frequencies[k] = f / 2
+		}
+	}
+	c.requests = 0
+}
+
+func (c *Cache) insert(k key, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[k]; ok {
+		// Another reader loaded the same piece concurrently.
+		return
+	}
+	for c.size+uint64(len(data)) > c.config.MaxSize {
+		c.evict()
+	}
+	c.entries[k] = c.lru.PushFront(&entry{k, data})
+	c.size += uint64(len(data))
+	delete(c.frequencies, k)
+
+	c.stats.Gauge("size").Update(float64(c.size))
+	c.stats.Gauge("pieces").Update(float64(len(c.entries)))
+}
+
+// evict removes the least recently used piece. Must be called with c.mu held.
+func (c *Cache) evict() {
+	e := c.lru.Back()
+	if e == nil {
+		return
+	}
+	c.lru.Remove(e)
+	ent := e.Value.(*entry)
+	delete(c.entries, ent.key)
+	c.size -= uint64(len(ent.data))
+
+	c.stats.Counter("evictions").Inc(1)
+}
+
+// Size returns the total number of bytes currently cached.
+func (c *Cache) Size() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// reader is a storage.PieceReader which reads a cached piece from memory.
+type reader struct {
+	*piecereader.Buffer
+	stats tally.Scope
+}
+
+func (c *Cache) newReader(data []byte) *reader {
+	return &reader{
+		Buffer: piecereader.NewBuffer(data),
+		stats:  c.stats,
+	}
+}
+
+// Read reads the cached piece into p.
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.Buffer.Read(p)
+	r.stats.Counter("bytes_served").Inc(int64(n))
+	return n, err
+}
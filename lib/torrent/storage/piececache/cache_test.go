@@ -0,0 +1,316 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package piececache
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	"go.uber.org/atomic"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/torrent/storage"
+	"github.com/uber/kraken/lib/torrent/storage/piecereader"
+	"github.com/uber/kraken/utils/randutil"
+)
+
+// countingLoader loads pieces from an in-memory blob and counts loads.
+type countingLoader struct {
+	pieces [][]byte
+	loads  *atomic.Int32
+}
+
+func newCountingLoader(numPieces int, pieceLength uint64) *countingLoader {
+	var pieces [][]byte
+	for i := 0; i < numPieces; i++ {
+		pieces = append(pieces, randutil.Blob(pieceLength))
+	}
+	return &countingLoader{pieces, atomic.NewInt32(0)}
+}
+
+func (l *countingLoader) load(i int) LoadFunc {
+	return func() (storage.PieceReader, error) {
+		l.loads.Inc()
+		return piecereader.NewBuffer(l.pieces[i]), nil
+	}
+}
+
+func (l *countingLoader) read(t *testing.T, c *Cache, h core.InfoHash, i int) {
+	pr, err := c.GetPieceReader(h, i, int64(len(l.pieces[i])), l.load(i))
+	require.NoError(t, err)
+	defer pr.Close()
+
+	require.Equal(t, len(l.pieces[i]), pr.Length())
+	b, err := ioutil.ReadAll(pr)
+	require.NoError(t, err)
+	require.Equal(t, l.pieces[i], b)
+}
+
+// filePiece is a storage.PieceReader backed by a file.
+type filePiece struct {
+	*piecereader.Buffer
+	file   *os.File
+	closed bool
+}
+
+func (p *filePiece) File() (*os.File, bool, error) {
+	return p.file, true, nil
+}
+
+func (p *filePiece) Close() error {
+	p.closed = true
+	return nil
+}
+
+func counter(stats tally.TestScope, name string) int64 {
+	c, ok := stats.Snapshot().Counters()[name+"+module=piececache"]
+	if !ok {
+		return 0
+	}
+	return c.Value()
+}
+
+func TestCacheDisabledAlwaysLoads(t *testing.T) {
+	require := require.New(t)
+
+	c := New(Config{}, tally.NoopScope)
+	l := newCountingLoader(1, 16)
+	h := core.InfoHashFixture()
+
+	for i := 0; i < 5; i++ {
+		l.read(t, c, h, 0)
+	}
+	require.Equal(int32(5), l.loads.Load())
+	require.Equal(uint64(0), c.Size())
+}
+
+func TestCacheAdmitsPiecesAfterThreshold(t *testing.T) {
+	require := require.New(t)
+
+	stats := tally.NewTestScope("", nil)
+	c := New(Config{Enable: true, AdmissionThreshold: 3}, stats)
+	l := newCountingLoader(1, 16)
+	h := core.InfoHashFixture()
+
+	// The first two requests are not admitted and go to disk.
+	l.read(t, c, h, 0)
+	l.read(t, c, h, 0)
+	require.Equal(uint64(0), c.Size())
+	require.Equal(int32(2), l.loads.Load())
+
+	// The third request admits the piece.
+	l.read(t, c, h, 0)
+	require.Equal(uint64(16), c.Size())
+	require.Equal(int32(3), l.loads.Load())
+
+	// Further requests are served from memory.
+	for i := 0; i < 10; i++ {
+		l.read(t, c, h, 0)
+	}
+	require.Equal(int32(3), l.loads.Load())
+
+	require.Equal(int64(10), counter(stats, "hits"))
+	require.Equal(int64(3), counter(stats, "misses"))
+	// The admitting request is also served from memory.
+	require.Equal(int64(176), counter(stats, "bytes_served"))
+}
+
+func TestCacheKeysPiecesByTorrent(t *testing.T) {
+	require := require.New(t)
+
+	c := New(Config{Enable: true, AdmissionThreshold: 1}, tally.NoopScope)
+	l1 := newCountingLoader(1, 16)
+	l2 := newCountingLoader(1, 16)
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+
+	l1.read(t, c, h1, 0)
+	l2.read(t, c, h2, 0)
+	l1.read(t, c, h1, 0)
+	l2.read(t, c, h2, 0)
+
+	require.Equal(int32(1), l1.loads.Load())
+	require.Equal(int32(1), l2.loads.Load())
+	require.Equal(uint64(32), c.Size())
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	require := require.New(t)
+
+	stats := tally.NewTestScope("", nil)
+	c := New(Config{Enable: true, AdmissionThreshold: 1, MaxSize: 32}, stats)
+	l := newCountingLoader(3, 16)
+	h := core.InfoHashFixture()
+
+	l.read(t, c, h, 0)
+	l.read(t, c, h, 1)
+	require.Equal(uint64(32), c.Size())
+
+	// Touch piece 0 so piece 1 is the least recently used.
+	l.read(t, c, h, 0)
+	require.Equal(int32(2), l.loads.Load())
+
+	l.read(t, c, h, 2)
+	require.Equal(uint64(32), c.Size())
+	require.Equal(int64(1), counter(stats, "evictions"))
+
+	// Piece 0 and 2 are cached, piece 1 was evicted.
+	l.read(t, c, h, 0)
+	l.read(t, c, h, 2)
+	require.Equal(int32(3), l.loads.Load())
+	l.read(t, c, h, 1)
+	require.Equal(int32(4), l.loads.Load())
+}
+
+func TestCacheSkipsLargePieces(t *testing.T) {
+	require := require.New(t)
+
+	c := New(Config{Enable: true, AdmissionThreshold: 1, MaxPieceSize: 8}, tally.NoopScope)
+	l := newCountingLoader(1, 16)
+	h := core.InfoHashFixture()
+
+	l.read(t, c, h, 0)
+	l.read(t, c, h, 0)
+	require.Equal(int32(2), l.loads.Load())
+	require.Equal(uint64(0), c.Size())
+}
+
+func TestCacheDecaysFrequencies(t *testing.T) {
+	require := require.New(t)
+
+	c := New(Config{
+		Enable:              true,
+		AdmissionThreshold:  2,
+		FrequencySampleSize: 4,
+	}, tally.NoopScope)
+	l := newCountingLoader(4, 16)
+	h := core.InfoHashFixture()
+
+	// Piece 0 is requested once, then decays before its second request.
+	l.read(t, c, h, 0)
+	l.read(t, c, h, 1)
+	l.read(t, c, h, 2)
+	l.read(t, c, h, 3)
+	l.read(t, c, h, 0)
+	require.Equal(uint64(0), c.Size())
+
+	// Now piece 0 is requested twice within the sample, so it is admitted.
+	l.read(t, c, h, 0)
+	require.Equal(uint64(16), c.Size())
+}
+
+func TestCacheLoadError(t *testing.T) {
+	require := require.New(t)
+
+	c := New(Config{Enable: true, AdmissionThreshold: 1}, tally.NoopScope)
+
+	_, err := c.GetPieceReader(core.InfoHashFixture(), 0, 16, func() (storage.PieceReader, error) {
+		return nil, errors.New("some error")
+	})
+	require.Error(err)
+	require.Equal(uint64(0), c.Size())
+}
+
+func TestCacheConcurrentReaders(t *testing.T) {
+	c := New(Config{Enable: true, AdmissionThreshold: 2, MaxSize: 64}, tally.NoopScope)
+	l := newCountingLoader(8, 16)
+	h := core.InfoHashFixture()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				l.read(t, c, h, (i+j)%len(l.pieces))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.True(t, c.Size() <= 64)
+}
+
+func TestCacheOnlySendsMissesWhichAreNotAdmittedFromFile(t *testing.T) {
+	require := require.New(t)
+
+	f, err := ioutil.TempFile("", "")
+	require.NoError(err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	c := New(Config{Enable: true, AdmissionThreshold: 2}, tally.NoopScope)
+	h := core.InfoHashFixture()
+	data := randutil.Blob(16)
+	load := func() (storage.PieceReader, error) {
+		return &filePiece{Buffer: piecereader.NewBuffer(data), file: f}, nil
+	}
+
+	// First request is not admitted.
+	pr, err := c.GetPieceReader(h, 0, 16, load)
+	require.NoError(err)
+	_, ok := pr.(*filePiece)
+	require.True(ok)
+	require.NoError(pr.Close())
+
+	// Second request is admitted and served from memory, as are all hits.
+	for i := 0; i < 2; i++ {
+		pr, err = c.GetPieceReader(h, 0, 16, load)
+		require.NoError(err)
+		_, ok = pr.(interface {
+			File() (*os.File, bool, error)
+		})
+		require.False(ok)
+		b, err := ioutil.ReadAll(pr)
+		require.NoError(err)
+		require.Equal(data, b)
+		require.NoError(pr.Close())
+	}
+	require.Equal(uint64(16), c.Size())
+}
+
+func TestCacheConcurrentMissesLoadOnce(t *testing.T) {
+	c := New(Config{Enable: true, AdmissionThreshold: 1}, tally.NoopScope)
+	l := newCountingLoader(1, 16)
+	h := core.InfoHashFixture()
+
+	load := func() (storage.PieceReader, error) {
+		// Widen the window for concurrent misses.
+		time.Sleep(10 * time.Millisecond)
+		return l.load(0)()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pr, err := c.GetPieceReader(h, 0, 16, load)
+			require.NoError(t, err)
+			defer pr.Close()
+			b, err := ioutil.ReadAll(pr)
+			require.NoError(t, err)
+			require.Equal(t, l.pieces[0], b)
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(1), l.loads.Load())
+}
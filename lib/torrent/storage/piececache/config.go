@@ -0,0 +1,57 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package piececache
+
+import "github.com/uber/kraken/utils/memsize"
+
+// Config defines Cache configuration.
+type Config struct {
+	// Enable enables caching pieces in memory. If disabled, all pieces are
+	// read from disk.
+	Enable bool `yaml:"enable"`
+
+	// MaxSize is the maximum total number of bytes of pieces held in memory.
+	MaxSize uint64 `yaml:"max_size"`
+
+	// MaxPieceSize is the maximum size of a single cached piece. Larger pieces
+	// are always read from disk.
+	MaxPieceSize uint64 `yaml:"max_piece_size"`
+
+	// AdmissionThreshold is the number of times a piece must be requested
+	// before it is admitted into the cache. Prevents pieces which are only read
+	// once from evicting hot pieces.
+	AdmissionThreshold int `yaml:"admission_threshold"`
+
+	// FrequencySampleSize is the number of requests after which all tracked
+	// request frequencies are halved, so pieces which were hot in the past
+	// do not stay admitted forever. Also bounds the memory used for tracking
+	// frequencies.
+	FrequencySampleSize int `yaml:"frequency_sample_size"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.MaxSize == 0 {
+		c.MaxSize = memsize.GB
+	}
+	if c.MaxPieceSize == 0 {
+		c.MaxPieceSize = 16 * memsize.MB
+	}
+	if c.AdmissionThreshold == 0 {
+		c.AdmissionThreshold = 2
+	}
+	if c.FrequencySampleSize == 0 {
+		c.FrequencySampleSize = 100000
+	}
+	return c
+}
@@ -26,13 +26,18 @@ type Opener interface {
 	Open() (store.FileReader, error)
 }
 
+// osFile is implemented by store.FileReaders which are backed by an os.File.
+type osFile interface {
+	File() *os.File
+}
+
 // FileReader is a storage.PieceReader which reads a piece from a file.
 type FileReader struct {
 	offset int64
 	length int64
 
 	opener Opener
-	closer io.Closer
+	file   store.FileReader
 	reader io.Reader
 }
 
@@ -46,28 +51,53 @@ func NewFileReader(offset, length int64, opener Opener) *FileReader {
 	}
 }
 
+func (r *FileReader) open() error {
+	if r.reader != nil {
+		return nil
+	}
+	f, err := r.opener.Open()
+	if err != nil {
+		return fmt.Errorf("open: %s", err)
+	}
+	if _, err := f.Seek(r.offset, os.SEEK_SET); err != nil {
+		f.Close()
+		return fmt.Errorf("seek: %s", err)
+	}
+	r.reader = io.LimitReader(f, r.length)
+	r.file = f
+	return nil
+}
+
 // Read reads a piece in p.
 func (r *FileReader) Read(p []byte) (int, error) {
-	if r.reader == nil {
-		f, err := r.opener.Open()
-		if err != nil {
-			return 0, fmt.Errorf("open: %s", err)
-		}
-		if _, err := f.Seek(r.offset, os.SEEK_SET); err != nil {
-			return 0, fmt.Errorf("seek: %s", err)
-		}
-		r.reader = io.LimitReader(f, r.length)
-		r.closer = f
+	if err := r.open(); err != nil {
+		return 0, err
 	}
 	return r.reader.Read(p)
 }
 
+// File returns the os.File which backs the piece, positioned at the start of
+// the piece, so the piece can be sent without copying it through user space.
+// Returns false if the piece is not backed by an os.File, in which case Read
+// should be used instead. Must be called before Read. The returned file is
+// closed by Close.
+func (r *FileReader) File() (*os.File, bool, error) {
+	if err := r.open(); err != nil {
+		return nil, false, err
+	}
+	of, ok := r.file.(osFile)
+	if !ok {
+		return nil, false, nil
+	}
+	return of.File(), true, nil
+}
+
 // Close closes the underlying file.
 func (r *FileReader) Close() error {
-	if r.closer == nil {
+	if r.file == nil {
 		return nil
 	}
-	return r.closer.Close()
+	return r.file.Close()
 }
 
 // Length returns the length of the piece.
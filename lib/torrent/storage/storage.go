@@ -29,6 +29,10 @@ var ErrNotFound = errors.New("torrent not found")
 // complete.
 var ErrPieceComplete = errors.New("piece is already complete")
 
+// ErrPieceInvalid occurs when Torrent cannot write a piece because its content
+// does not match the torrent metainfo.
+var ErrPieceInvalid = errors.New("invalid piece")
+
 // PieceReader defines operations for lazy piece reading.
 type PieceReader interface {
 	io.ReadCloser
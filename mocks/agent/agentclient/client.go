@@ -49,6 +49,20 @@ func (mr *MockClientMockRecorder) Download(arg0, arg1 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockClient)(nil).Download), arg0, arg1)
 }
 
+// DownloadBundle mocks base method
+func (m *MockClient) DownloadBundle(arg0 string, arg1 core.Digest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DownloadBundle", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DownloadBundle indicates an expected call of DownloadBundle
+func (mr *MockClientMockRecorder) DownloadBundle(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadBundle", reflect.TypeOf((*MockClient)(nil).DownloadBundle), arg0, arg1)
+}
+
 // GetTag mocks base method
 func (m *MockClient) GetTag(arg0 string) (core.Digest, error) {
 	m.ctrl.T.Helper()
@@ -5,9 +5,12 @@
 package mockscheduler
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
 	core "github.com/uber/kraken/core"
+	scheduler "github.com/uber/kraken/lib/torrent/scheduler"
 	connstate "github.com/uber/kraken/lib/torrent/scheduler/connstate"
+	bandwidth "github.com/uber/kraken/utils/bandwidth"
 	reflect "reflect"
 )
 
@@ -34,6 +37,20 @@ func (m *MockScheduler) EXPECT() *MockSchedulerMockRecorder {
 	return m.recorder
 }
 
+// BandwidthSnapshot mocks base method
+func (m *MockScheduler) BandwidthSnapshot() bandwidth.Snapshot {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BandwidthSnapshot")
+	ret0, _ := ret[0].(bandwidth.Snapshot)
+	return ret0
+}
+
+// BandwidthSnapshot indicates an expected call of BandwidthSnapshot
+func (mr *MockSchedulerMockRecorder) BandwidthSnapshot() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BandwidthSnapshot", reflect.TypeOf((*MockScheduler)(nil).BandwidthSnapshot))
+}
+
 // BlacklistSnapshot mocks base method
 func (m *MockScheduler) BlacklistSnapshot() ([]connstate.BlacklistedConn, error) {
 	m.ctrl.T.Helper()
@@ -50,17 +67,56 @@ func (mr *MockSchedulerMockRecorder) BlacklistSnapshot() *gomock.Call {
 }
 
 // Download mocks base method
-func (m *MockScheduler) Download(arg0 string, arg1 core.Digest) error {
+func (m *MockScheduler) Download(arg0 context.Context, arg1 string, arg2 core.Digest, arg3 ...scheduler.DownloadOption) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Download", arg0, arg1)
+	varargs := []interface{}{arg0, arg1, arg2}
+	for _, a := range arg3 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Download", varargs...)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Download indicates an expected call of Download
-func (mr *MockSchedulerMockRecorder) Download(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockSchedulerMockRecorder) Download(arg0, arg1, arg2 interface{}, arg3 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1, arg2}, arg3...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockScheduler)(nil).Download), varargs...)
+}
+
+// DownloadBundle mocks base method
+func (m *MockScheduler) DownloadBundle(arg0 context.Context, arg1 string, arg2 core.Digest, arg3 []core.Digest, arg4 ...scheduler.DownloadOption) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1, arg2, arg3}
+	for _, a := range arg4 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "DownloadBundle", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DownloadBundle indicates an expected call of DownloadBundle
+func (mr *MockSchedulerMockRecorder) DownloadBundle(arg0, arg1, arg2, arg3 interface{}, arg4 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1, arg2, arg3}, arg4...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadBundle", reflect.TypeOf((*MockScheduler)(nil).DownloadBundle), varargs...)
+}
+
+// Dump mocks base method
+func (m *MockScheduler) Dump() (*scheduler.StateDump, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Dump")
+	ret0, _ := ret[0].(*scheduler.StateDump)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Dump indicates an expected call of Dump
+func (mr *MockSchedulerMockRecorder) Dump() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Download", reflect.TypeOf((*MockScheduler)(nil).Download), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dump", reflect.TypeOf((*MockScheduler)(nil).Dump))
 }
 
 // Probe mocks base method
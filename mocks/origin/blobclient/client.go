@@ -7,6 +7,7 @@ package mockblobclient
 import (
 	gomock "github.com/golang/mock/gomock"
 	core "github.com/uber/kraken/core"
+	blobclient "github.com/uber/kraken/origin/blobclient"
 	io "io"
 	reflect "reflect"
 	time "time"
@@ -120,6 +121,21 @@ func (mr *MockClientMockRecorder) GetMetaInfo(arg0, arg1 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetaInfo", reflect.TypeOf((*MockClient)(nil).GetMetaInfo), arg0, arg1)
 }
 
+// GetMetaInfoVersion mocks base method
+func (m *MockClient) GetMetaInfoVersion(arg0 core.Digest) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetaInfoVersion", arg0)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetaInfoVersion indicates an expected call of GetMetaInfoVersion
+func (mr *MockClientMockRecorder) GetMetaInfoVersion(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetaInfoVersion", reflect.TypeOf((*MockClient)(nil).GetMetaInfoVersion), arg0)
+}
+
 // GetPeerContext mocks base method
 func (m *MockClient) GetPeerContext() (core.PeerContext, error) {
 	m.ctrl.T.Helper()
@@ -151,17 +167,36 @@ func (mr *MockClientMockRecorder) Locations(arg0 interface{}) *gomock.Call {
 }
 
 // OverwriteMetaInfo mocks base method
-func (m *MockClient) OverwriteMetaInfo(arg0 core.Digest, arg1 int64) error {
+func (m *MockClient) OverwriteMetaInfo(arg0 core.Digest, arg1 int64, arg2 ...blobclient.OverwriteOption) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "OverwriteMetaInfo", arg0, arg1)
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "OverwriteMetaInfo", varargs...)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // OverwriteMetaInfo indicates an expected call of OverwriteMetaInfo
-func (mr *MockClientMockRecorder) OverwriteMetaInfo(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockClientMockRecorder) OverwriteMetaInfo(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OverwriteMetaInfo", reflect.TypeOf((*MockClient)(nil).OverwriteMetaInfo), varargs...)
+}
+
+// RegenerateMetaInfo mocks base method
+func (m *MockClient) RegenerateMetaInfo(arg0 string, arg1 core.Digest, arg2 bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RegenerateMetaInfo", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RegenerateMetaInfo indicates an expected call of RegenerateMetaInfo
+func (mr *MockClientMockRecorder) RegenerateMetaInfo(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OverwriteMetaInfo", reflect.TypeOf((*MockClient)(nil).OverwriteMetaInfo), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegenerateMetaInfo", reflect.TypeOf((*MockClient)(nil).RegenerateMetaInfo), arg0, arg1, arg2)
 }
 
 // ReplicateToRemote mocks base method
@@ -209,17 +244,17 @@ func (mr *MockClientMockRecorder) StatLocal(arg0, arg1 interface{}) *gomock.Call
 }
 
 // TransferBlob mocks base method
-func (m *MockClient) TransferBlob(arg0 core.Digest, arg1 io.Reader) error {
+func (m *MockClient) TransferBlob(arg0 string, arg1 core.Digest, arg2 io.Reader) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "TransferBlob", arg0, arg1)
+	ret := m.ctrl.Call(m, "TransferBlob", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // TransferBlob indicates an expected call of TransferBlob
-func (mr *MockClientMockRecorder) TransferBlob(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockClientMockRecorder) TransferBlob(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferBlob", reflect.TypeOf((*MockClient)(nil).TransferBlob), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferBlob", reflect.TypeOf((*MockClient)(nil).TransferBlob), arg0, arg1, arg2)
 }
 
 // UploadBlob mocks base method
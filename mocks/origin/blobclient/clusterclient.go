@@ -7,6 +7,7 @@ package mockblobclient
 import (
 	gomock "github.com/golang/mock/gomock"
 	core "github.com/uber/kraken/core"
+	blobclient "github.com/uber/kraken/origin/blobclient"
 	io "io"
 	reflect "reflect"
 )
@@ -63,18 +64,38 @@ func (mr *MockClusterClientMockRecorder) GetMetaInfo(arg0, arg1 interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetaInfo", reflect.TypeOf((*MockClusterClient)(nil).GetMetaInfo), arg0, arg1)
 }
 
+// GetMetaInfoVersion mocks base method
+func (m *MockClusterClient) GetMetaInfoVersion(arg0 core.Digest) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetaInfoVersion", arg0)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetaInfoVersion indicates an expected call of GetMetaInfoVersion
+func (mr *MockClusterClientMockRecorder) GetMetaInfoVersion(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetaInfoVersion", reflect.TypeOf((*MockClusterClient)(nil).GetMetaInfoVersion), arg0)
+}
+
 // OverwriteMetaInfo mocks base method
-func (m *MockClusterClient) OverwriteMetaInfo(arg0 core.Digest, arg1 int64) error {
+func (m *MockClusterClient) OverwriteMetaInfo(arg0 core.Digest, arg1 int64, arg2 ...blobclient.OverwriteOption) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "OverwriteMetaInfo", arg0, arg1)
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "OverwriteMetaInfo", varargs...)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // OverwriteMetaInfo indicates an expected call of OverwriteMetaInfo
-func (mr *MockClusterClientMockRecorder) OverwriteMetaInfo(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockClusterClientMockRecorder) OverwriteMetaInfo(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OverwriteMetaInfo", reflect.TypeOf((*MockClusterClient)(nil).OverwriteMetaInfo), arg0, arg1)
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OverwriteMetaInfo", reflect.TypeOf((*MockClusterClient)(nil).OverwriteMetaInfo), varargs...)
 }
 
 // Owners mocks base method
@@ -5,8 +5,10 @@
 package mockannounceclient
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
 	core "github.com/uber/kraken/core"
+	announceclient "github.com/uber/kraken/tracker/announceclient"
 	reflect "reflect"
 	time "time"
 )
@@ -35,9 +37,13 @@ func (m *MockClient) EXPECT() *MockClientMockRecorder {
 }
 
 // Announce mocks base method
-func (m *MockClient) Announce(arg0 core.Digest, arg1 core.InfoHash, arg2 bool, arg3 int) ([]*core.PeerInfo, time.Duration, error) {
+func (m *MockClient) Announce(arg0 core.Digest, arg1 core.InfoHash, arg2 bool, arg3 int, arg4 ...core.PeerReputation) ([]*core.PeerInfo, time.Duration, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Announce", arg0, arg1, arg2, arg3)
+	varargs := []interface{}{arg0, arg1, arg2, arg3}
+	for _, a := range arg4 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Announce", varargs...)
 	ret0, _ := ret[0].([]*core.PeerInfo)
 	ret1, _ := ret[1].(time.Duration)
 	ret2, _ := ret[2].(error)
@@ -45,7 +51,65 @@ func (m *MockClient) Announce(arg0 core.Digest, arg1 core.InfoHash, arg2 bool, a
 }
 
 // Announce indicates an expected call of Announce
-func (mr *MockClientMockRecorder) Announce(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+func (mr *MockClientMockRecorder) Announce(arg0, arg1, arg2, arg3 interface{}, arg4 ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Announce", reflect.TypeOf((*MockClient)(nil).Announce), arg0, arg1, arg2, arg3)
+	varargs := append([]interface{}{arg0, arg1, arg2, arg3}, arg4...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Announce", reflect.TypeOf((*MockClient)(nil).Announce), varargs...)
+}
+
+// AnnounceBatch mocks base method
+func (m *MockClient) AnnounceBatch(arg0 []announceclient.AnnounceTorrent, arg1 ...core.PeerReputation) (map[core.InfoHash][]*core.PeerInfo, time.Duration, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "AnnounceBatch", varargs...)
+	ret0, _ := ret[0].(map[core.InfoHash][]*core.PeerInfo)
+	ret1, _ := ret[1].(time.Duration)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// AnnounceBatch indicates an expected call of AnnounceBatch
+func (mr *MockClientMockRecorder) AnnounceBatch(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AnnounceBatch", reflect.TypeOf((*MockClient)(nil).AnnounceBatch), varargs...)
+}
+
+// AnnounceBundle mocks base method
+func (m *MockClient) AnnounceBundle(arg0 core.Digest, arg1 []announceclient.AnnounceTorrent, arg2 ...core.PeerReputation) (map[core.InfoHash][]*core.PeerInfo, time.Duration, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "AnnounceBundle", varargs...)
+	ret0, _ := ret[0].(map[core.InfoHash][]*core.PeerInfo)
+	ret1, _ := ret[1].(time.Duration)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// AnnounceBundle indicates an expected call of AnnounceBundle
+func (mr *MockClientMockRecorder) AnnounceBundle(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AnnounceBundle", reflect.TypeOf((*MockClient)(nil).AnnounceBundle), varargs...)
+}
+
+// StreamPeers mocks base method
+func (m *MockClient) StreamPeers(arg0 context.Context, arg1 []announceclient.AnnounceTorrent) (<-chan *announceclient.StreamedPeer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StreamPeers", arg0, arg1)
+	ret0, _ := ret[0].(<-chan *announceclient.StreamedPeer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StreamPeers indicates an expected call of StreamPeers
+func (mr *MockClientMockRecorder) StreamPeers(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StreamPeers", reflect.TypeOf((*MockClient)(nil).StreamPeers), arg0, arg1)
 }
@@ -7,6 +7,7 @@ package mockpeerstore
 import (
 	gomock "github.com/golang/mock/gomock"
 	core "github.com/uber/kraken/core"
+	peerstore "github.com/uber/kraken/tracker/peerstore"
 	reflect "reflect"
 )
 
@@ -60,6 +61,51 @@ func (mr *MockStoreMockRecorder) GetPeers(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPeers", reflect.TypeOf((*MockStore)(nil).GetPeers), arg0, arg1)
 }
 
+// HotTorrents mocks base method
+func (m *MockStore) HotTorrents(arg0 int) ([]*peerstore.SwarmStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HotTorrents", arg0)
+	ret0, _ := ret[0].([]*peerstore.SwarmStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HotTorrents indicates an expected call of HotTorrents
+func (mr *MockStoreMockRecorder) HotTorrents(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HotTorrents", reflect.TypeOf((*MockStore)(nil).HotTorrents), arg0)
+}
+
+// Scrape mocks base method
+func (m *MockStore) Scrape(arg0 core.InfoHash) (*peerstore.SwarmStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Scrape", arg0)
+	ret0, _ := ret[0].(*peerstore.SwarmStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Scrape indicates an expected call of Scrape
+func (mr *MockStoreMockRecorder) Scrape(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Scrape", reflect.TypeOf((*MockStore)(nil).Scrape), arg0)
+}
+
+// Subscribe mocks base method
+func (m *MockStore) Subscribe(arg0 []core.InfoHash) (<-chan *peerstore.PeerEvent, func()) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Subscribe", arg0)
+	ret0, _ := ret[0].(<-chan *peerstore.PeerEvent)
+	ret1, _ := ret[1].(func())
+	return ret0, ret1
+}
+
+// Subscribe indicates an expected call of Subscribe
+func (mr *MockStoreMockRecorder) Subscribe(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribe", reflect.TypeOf((*MockStore)(nil).Subscribe), arg0)
+}
+
 // UpdatePeer mocks base method
 func (m *MockStore) UpdatePeer(arg0 core.InfoHash, arg1 *core.PeerInfo) error {
 	m.ctrl.T.Helper()
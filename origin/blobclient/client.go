@@ -41,7 +41,7 @@ type Client interface {
 
 	// DeleteBlob 删除
 	DeleteBlob(d core.Digest) error
-	TransferBlob(d core.Digest, blob io.Reader) error
+	TransferBlob(namespace string, d core.Digest, blob io.Reader) error
 
 	Stat(namespace string, d core.Digest) (*core.BlobInfo, error)
 
@@ -49,7 +49,9 @@ type Client interface {
 	StatLocal(namespace string, d core.Digest) (*core.BlobInfo, error)
 
 	GetMetaInfo(namespace string, d core.Digest) (*core.MetaInfo, error)
-	OverwriteMetaInfo(d core.Digest, pieceLength int64) error
+	GetMetaInfoVersion(d core.Digest) (string, error)
+	OverwriteMetaInfo(d core.Digest, pieceLength int64, opts ...OverwriteOption) error
+	RegenerateMetaInfo(namespace string, d core.Digest, force bool) error
 
 	UploadBlob(namespace string, d core.Digest, blob io.Reader) error
 	DuplicateUploadBlob(namespace string, d core.Digest, blob io.Reader, delay time.Duration) error
@@ -170,9 +172,10 @@ func (c *HTTPClient) DeleteBlob(d core.Digest) error {
 }
 
 // TransferBlob uploads a blob to a single origin server. Unlike its cousin UploadBlob,
-// TransferBlob is an internal API which does not replicate the blob.
-func (c *HTTPClient) TransferBlob(d core.Digest, blob io.Reader) error {
-	tc := newTransferClient(c.addr, c.tls)
+// TransferBlob is an internal API which does not replicate the blob. namespace
+// is only used to generate metainfo, and may be empty if unknown.
+func (c *HTTPClient) TransferBlob(namespace string, d core.Digest, blob io.Reader) error {
+	tc := newTransferClient(c.addr, namespace, c.tls)
 	return runChunkedUpload(tc, d, blob, int64(c.chunkSize))
 }
 
@@ -246,11 +249,73 @@ func (c *HTTPClient) GetMetaInfo(namespace string, d core.Digest) (*core.MetaInf
 	return mi, nil
 }
 
+// GetMetaInfoVersion returns the version of the metainfo for d, which changes
+// whenever the metainfo is overwritten. Unlike GetMetaInfo, never initiates a
+// download of the blob of d, and returns a 404 httputil.StatusError if the blob
+// of d is not available.
+func (c *HTTPClient) GetMetaInfoVersion(d core.Digest) (string, error) {
+	r, err := httputil.Get(
+		fmt.Sprintf("http://%s/internal/blobs/%s/metainfo/version", c.addr, d),
+		httputil.SendTimeout(5*time.Second),
+		httputil.SendTLS(c.tls))
+	if err != nil {
+		return "", err
+	}
+	defer r.Body.Close()
+	version, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", fmt.Errorf("read body: %s", err)
+	}
+	return string(version), nil
+}
+
+// OverwriteOption allows setting optional OverwriteMetaInfo parameters.
+type OverwriteOption func(url.Values)
+
+// OverwriteWithVersion generates metainfo of the given core.MetaInfo version,
+// which allows migrating existing blobs between versions. Defaults to
+// core.MetaInfoV1.
+func OverwriteWithVersion(version int) OverwriteOption {
+	return func(v url.Values) { v.Set("version", strconv.Itoa(version)) }
+}
+
+// OverwriteWithMerkleRoot generates version 2 metainfo which commits to the
+// merkle root of its piece hashes.
+func OverwriteWithMerkleRoot() OverwriteOption {
+	return func(v url.Values) {
+		v.Set("version", strconv.Itoa(core.MetaInfoV2))
+		v.Set("merkle", "true")
+	}
+}
+
 // OverwriteMetaInfo overwrites existing metainfo for d with new metainfo
-// configured with pieceLength. Primarily intended for benchmarking purposes.
-func (c *HTTPClient) OverwriteMetaInfo(d core.Digest, pieceLength int64) error {
+// configured with pieceLength. Primarily intended for benchmarking purposes,
+// and for migrating metainfo to a new version.
+func (c *HTTPClient) OverwriteMetaInfo(
+	d core.Digest, pieceLength int64, opts ...OverwriteOption) error {
+
+	v := url.Values{}
+	v.Add("piece_length", strconv.FormatInt(pieceLength, 10))
+	for _, opt := range opts {
+		opt(v)
+	}
+	_, err := httputil.Post(
+		fmt.Sprintf("http://%s/internal/blobs/%s/metainfo?%s", c.addr, d, v.Encode()),
+		httputil.SendTLS(c.tls))
+	return err
+}
+
+// RegenerateMetaInfo regenerates the metainfo of d with the metainfo rule
+// configured for namespace, overwriting existing metainfo. Intended for applying
+// rule changes to existing blobs. Returns a 404 httputil.StatusError if the
+// origin does not have the blob of d, and a 409 httputil.StatusError if the
+// existing metainfo was generated for another namespace with a different rule,
+// unless force is set.
+func (c *HTTPClient) RegenerateMetaInfo(namespace string, d core.Digest, force bool) error {
 	_, err := httputil.Post(
-		fmt.Sprintf("http://%s/internal/blobs/%s/metainfo?piece_length=%d", c.addr, d, pieceLength),
+		fmt.Sprintf("http://%s/internal/namespace/%s/blobs/%s/metainfo?force=%t",
+			c.addr, url.PathEscape(namespace), d, force),
+		httputil.SendTimeout(15*time.Minute),
 		httputil.SendTLS(c.tls))
 	return err
 }
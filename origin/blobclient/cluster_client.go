@@ -83,8 +83,9 @@ type ClusterClient interface {
 	UploadBlob(namespace string, d core.Digest, blob io.Reader) error
 	DownloadBlob(namespace string, d core.Digest, dst io.Writer) error
 	GetMetaInfo(namespace string, d core.Digest) (*core.MetaInfo, error)
+	GetMetaInfoVersion(d core.Digest) (string, error)
 	Stat(namespace string, d core.Digest) (*core.BlobInfo, error)
-	OverwriteMetaInfo(d core.Digest, pieceLength int64) error
+	OverwriteMetaInfo(d core.Digest, pieceLength int64, opts ...OverwriteOption) error
 	Owners(d core.Digest) ([]core.PeerContext, error)
 	ReplicateToRemote(namespace string, d core.Digest, remoteDNS string) error
 }
@@ -155,6 +156,44 @@ func (c *clusterClient) GetMetaInfo(namespace string, d core.Digest) (mi *core.M
 	return mi, err
 }
 
+// GetMetaInfoVersion returns the version of the metainfo for d agreed on by all
+// origins which have the blob of d. Returns ErrMetaInfoVersionConflict if
+// origins disagree, and a 404 httputil.StatusError only if no origin has the
+// blob of d.
+func (c *clusterClient) GetMetaInfoVersion(d core.Digest) (string, error) {
+	clients, err := c.resolver.Resolve(d)
+	if err != nil {
+		return "", fmt.Errorf("resolve clients: %s", err)
+	}
+	var version string
+	var lastErr, notFoundErr error
+	for _, client := range clients {
+		v, err := client.GetMetaInfoVersion(d)
+		if err != nil {
+			if httputil.IsNotFound(err) {
+				notFoundErr = err
+			} else {
+				lastErr = err
+			}
+			continue
+		}
+		if version != "" && v != version {
+			return "", ErrMetaInfoVersionConflict
+		}
+		version = v
+	}
+	if version != "" {
+		return version, nil
+	}
+	if lastErr != nil {
+		return "", lastErr
+	}
+	if notFoundErr != nil {
+		return "", notFoundErr
+	}
+	return "", errors.New("no origins resolved")
+}
+
 // Stat checks availability of a blob in the cluster.
 func (c *clusterClient) Stat(namespace string, d core.Digest) (bi *core.BlobInfo, err error) {
 	clients, err := c.resolver.Resolve(d)
@@ -177,14 +216,16 @@ func (c *clusterClient) Stat(namespace string, d core.Digest) (bi *core.BlobInfo
 // OverwriteMetaInfo overwrites existing metainfo for d with new metainfo configured
 // with pieceLength on every origin server. Returns error if any origin was unable
 // to overwrite metainfo. Primarly intended for benchmarking purposes.
-func (c *clusterClient) OverwriteMetaInfo(d core.Digest, pieceLength int64) error {
+func (c *clusterClient) OverwriteMetaInfo(
+	d core.Digest, pieceLength int64, opts ...OverwriteOption) error {
+
 	clients, err := c.resolver.Resolve(d)
 	if err != nil {
 		return fmt.Errorf("resolve clients: %s", err)
 	}
 	var errs []error
 	for _, client := range clients {
-		if err := client.OverwriteMetaInfo(d, pieceLength); err != nil {
+		if err := client.OverwriteMetaInfo(d, pieceLength, opts...); err != nil {
 			errs = append(errs, fmt.Errorf("origin %s: %s", client.Addr(), err))
 		}
 	}
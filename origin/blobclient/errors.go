@@ -17,3 +17,7 @@ import "errors"
 
 // ErrBlobNotFound is returned when a blob is not found on origin.
 var ErrBlobNotFound = errors.New("blob not found")
+
+// ErrMetaInfoVersionConflict is returned when origins report different
+// metainfo versions for a blob, e.g. while its metainfo is being overwritten.
+var ErrMetaInfoVersionConflict = errors.New("origins disagree on metainfo version")
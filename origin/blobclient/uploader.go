@@ -72,12 +72,13 @@ func runChunkedUploadHelper(u uploader, d core.Digest, blob io.Reader, chunkSize
 // transferClient executes chunked uploads for internal blob transfers.
 // 集群内部的上传操作
 type transferClient struct {
-	addr string
-	tls  *tls.Config
+	addr      string
+	namespace string
+	tls       *tls.Config
 }
 
-func newTransferClient(addr string, tls *tls.Config) *transferClient {
-	return &transferClient{addr, tls}
+func newTransferClient(addr, namespace string, tls *tls.Config) *transferClient {
+	return &transferClient{addr, namespace, tls}
 }
 
 func (c *transferClient) start(d core.Digest) (uid string, err error) {
@@ -108,8 +109,14 @@ func (c *transferClient) patch(
 }
 
 func (c *transferClient) commit(d core.Digest, uid string) error {
+	// The namespace is optional, and allows the receiving origin to generate
+	// metainfo with the same rule as the sender.
+	u := fmt.Sprintf("http://%s/internal/blobs/%s/uploads/%s", c.addr, d, uid)
+	if c.namespace != "" {
+		u += "?" + url.Values{"namespace": {c.namespace}}.Encode()
+	}
 	_, err := httputil.Put(
-		fmt.Sprintf("http://%s/internal/blobs/%s/uploads/%s", c.addr, d, uid),
+		u,
 		httputil.SendTimeout(15*time.Minute),
 		httputil.SendTLS(c.tls))
 	return err
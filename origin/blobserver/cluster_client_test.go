@@ -267,3 +267,47 @@ func TestClusterClientStatContinueWhenNotFound(t *testing.T) {
 	require.NotNil(bi)
 	require.Equal(int64(256), bi.Size)
 }
+
+func TestClusterClientGetMetaInfoVersion(t *testing.T) {
+	notFound := httputil.StatusError{Status: 404}
+	unavailable := httputil.StatusError{Status: 503}
+
+	tests := []struct {
+		desc     string
+		versions []string
+		errs     []error
+		expected string
+		err      error
+	}{
+		{"all agree", []string{"a", "a"}, []error{nil, nil}, "a", nil},
+		{"missing on one origin", []string{"", "a"}, []error{notFound, nil}, "a", nil},
+		{"conflict", []string{"a", "b"}, []error{nil, nil}, "", blobclient.ErrMetaInfoVersionConflict},
+		{"missing on all origins", []string{"", ""}, []error{notFound, notFound}, "", notFound},
+		{"unavailable", []string{"", ""}, []error{notFound, unavailable}, "", unavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockResolver := mockblobclient.NewMockClientResolver(ctrl)
+
+			cc := blobclient.NewClusterClient(mockResolver)
+
+			d := core.DigestFixture()
+
+			mockClient1 := mockblobclient.NewMockClient(ctrl)
+			mockClient2 := mockblobclient.NewMockClient(ctrl)
+			mockResolver.EXPECT().Resolve(d).Return([]blobclient.Client{mockClient1, mockClient2}, nil)
+
+			mockClient1.EXPECT().GetMetaInfoVersion(d).Return(test.versions[0], test.errs[0])
+			mockClient2.EXPECT().GetMetaInfoVersion(d).Return(test.versions[1], test.errs[1])
+
+			version, err := cc.GetMetaInfoVersion(d)
+			require.Equal(test.err, err)
+			require.Equal(test.expected, version)
+		})
+	}
+}
@@ -160,12 +160,14 @@ func (s *Server) Handler() http.Handler {
 	r.Delete("/internal/blobs/{digest}", handler.Wrap(s.deleteBlobHandler))
 
 	r.Post("/internal/blobs/{digest}/metainfo", handler.Wrap(s.overwriteMetaInfoHandler))
+	r.Get("/internal/blobs/{digest}/metainfo/version", handler.Wrap(s.getMetaInfoVersionHandler))
 
 	r.Get("/internal/peercontext", handler.Wrap(s.getPeerContextHandler))
 
 	r.Head("/internal/namespace/{namespace}/blobs/{digest}", handler.Wrap(s.statHandler))
 
 	r.Get("/internal/namespace/{namespace}/blobs/{digest}/metainfo", handler.Wrap(s.getMetaInfoHandler))
+	r.Post("/internal/namespace/{namespace}/blobs/{digest}/metainfo", handler.Wrap(s.regenerateMetaInfoHandler))
 
 	r.Put(
 		"/internal/duplicate/namespace/{namespace}/blobs/{digest}/uploads/{uid}",
@@ -346,22 +348,98 @@ func (s *Server) overwriteMetaInfoHandler(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		return handler.Errorf("invalid piece_length argument: %s", err).Status(http.StatusBadRequest)
 	}
-	return s.overwriteMetaInfo(d, pieceLength)
+	var opts []core.MetaInfoOption
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return handler.Errorf("invalid version argument: %s", err).Status(http.StatusBadRequest)
+		}
+		if version != core.MetaInfoV1 && version != core.MetaInfoV2 {
+			return handler.Errorf("unsupported version: %d", version).Status(http.StatusBadRequest)
+		}
+		opts = append(opts, core.WithMetaInfoVersion(version))
+	}
+	if m := r.URL.Query().Get("merkle"); m != "" {
+		merkle, err := strconv.ParseBool(m)
+		if err != nil {
+			return handler.Errorf("invalid merkle argument: %s", err).Status(http.StatusBadRequest)
+		}
+		if merkle {
+			opts = append(opts, core.WithMerkleRoot())
+		}
+	}
+	return s.overwriteMetaInfo(d, pieceLength, opts...)
+}
+
+// regenerateMetaInfoHandler regenerates the metainfo of d with the metainfo rule
+// of namespace. Unlike getMetaInfoHandler, never initiates a download of the
+// blob of d. Responds with 409 if the metainfo of d was generated for another
+// namespace with a different rule, unless forced.
+func (s *Server) regenerateMetaInfoHandler(w http.ResponseWriter, r *http.Request) error {
+	force, err := strconv.ParseBool(httputil.GetQueryArg(r, "force", "false"))
+	if err != nil {
+		return handler.Errorf("parse arg `force` as bool: %s", err).Status(http.StatusBadRequest)
+	}
+	namespace, err := httputil.ParseParam(r, "namespace")
+	if err != nil {
+		return err
+	}
+	d, err := httputil.ParseDigest(r, "digest")
+	if err != nil {
+		return err
+	}
+	if _, err := s.cas.GetCacheFileStat(d.Hex()); os.IsNotExist(err) {
+		return handler.ErrorStatus(http.StatusNotFound)
+	} else if err != nil {
+		return handler.Errorf("cache stat: %s", err)
+	}
+	if err := s.metaInfoGenerator.Regenerate(namespace, d, force); err == metainfogen.ErrNamespaceConflict {
+		return handler.Errorf("%s", err).Status(http.StatusConflict)
+	} else if err != nil {
+		return handler.Errorf("generate metainfo: %s", err)
+	}
+	return nil
 }
 
-// overwriteMetaInfo generates metainfo configured with pieceLength for d and
-// writes it to disk, overwriting any existing metainfo. Primarily intended for
-// benchmarking purposes.
-func (s *Server) overwriteMetaInfo(d core.Digest, pieceLength int64) error {
+// getMetaInfoVersionHandler returns the version of the metainfo of d, which is
+// its info hash. Unlike getMetaInfoHandler, never initiates a download of the
+// blob of d.
+func (s *Server) getMetaInfoVersionHandler(w http.ResponseWriter, r *http.Request) error {
+	d, err := httputil.ParseDigest(r, "digest")
+	if err != nil {
+		return err
+	}
+	var tm metadata.TorrentMeta
+	if err := s.cas.GetCacheFileMetadata(d.Hex(), &tm); os.IsNotExist(err) {
+		return handler.ErrorStatus(http.StatusNotFound)
+	} else if err != nil {
+		return handler.Errorf("get cache metadata: %s", err)
+	}
+	io.WriteString(w, tm.MetaInfo.InfoHash().Hex())
+	return nil
+}
+
+// overwriteMetaInfo generates metainfo configured with pieceLength and opts for
+// d and writes it to disk, overwriting any existing metainfo. Primarily intended
+// for benchmarking purposes, and for migrating metainfo between versions.
+func (s *Server) overwriteMetaInfo(
+	d core.Digest, pieceLength int64, opts ...core.MetaInfoOption) error {
+
 	f, err := s.cas.GetCacheFileReader(d.Hex())
 	if err != nil {
 		return handler.Errorf("get cache file: %s", err)
 	}
-	mi, err := core.NewMetaInfo(d, f, pieceLength)
+	mi, err := core.NewMetaInfo(d, f, pieceLength, opts...)
 	if err != nil {
 		return handler.Errorf("create metainfo: %s", err)
 	}
-	if _, err := s.cas.SetCacheFileMetadata(d.Hex(), metadata.NewTorrentMeta(mi)); err != nil {
+	// Keep the namespace which owns the existing metainfo, if any.
+	var tm metadata.TorrentMeta
+	if err := s.cas.GetCacheFileMetadata(d.Hex(), &tm); err != nil && !os.IsNotExist(err) {
+		return handler.Errorf("get cache metadata: %s", err)
+	}
+	tm.MetaInfo = mi
+	if _, err := s.cas.SetCacheFileMetadata(d.Hex(), &tm); err != nil {
 		return handler.Errorf("set metainfo: %s", err)
 	}
 	return nil
@@ -379,16 +457,17 @@ func (s *Server) getMetaInfo(namespace string, d core.Digest) ([]byte, error) {
 	} else if err != nil {
 		return nil, handler.Errorf("get cache metadata: %s", err)
 	}
-	return tm.Serialize()
+	return tm.MetaInfo.Serialize()
 }
 
 type localReplicationHook struct {
-	server *Server
+	server    *Server
+	namespace string
 }
 
 func (h *localReplicationHook) Run(d core.Digest) {
 	timer := h.server.stats.Timer("replicate_blob").Start()
-	if err := h.server.replicateBlobLocally(d); err != nil {
+	if err := h.server.replicateBlobLocally(h.namespace, d); err != nil {
 		// Don't return error here as we only want to cache storage backend errors.
 		log.With("blob", d.Hex()).Errorf("Error replicating remote blob: %s", err)
 		h.server.stats.Counter("replicate_blob_errors").Inc(1)
@@ -402,7 +481,7 @@ func (s *Server) startRemoteBlobDownload(
 
 	var hooks []blobrefresh.PostHook
 	if replicateLocally {
-		hooks = append(hooks, &localReplicationHook{s})
+		hooks = append(hooks, &localReplicationHook{s, namespace})
 	}
 	err := s.blobRefresher.Refresh(namespace, d, hooks...)
 	switch err {
@@ -417,13 +496,13 @@ func (s *Server) startRemoteBlobDownload(
 	}
 }
 
-func (s *Server) replicateBlobLocally(d core.Digest) error {
+func (s *Server) replicateBlobLocally(namespace string, d core.Digest) error {
 	return s.applyToReplicas(d, func(i int, client blobclient.Client) error {
 		f, err := s.cas.GetCacheFileReader(d.Hex())
 		if err != nil {
 			return fmt.Errorf("get cache reader: %s", err)
 		}
-		if err := client.TransferBlob(d, f); err != nil {
+		if err := client.TransferBlob(namespace, d, f); err != nil {
 			return fmt.Errorf("transfer blob: %s", err)
 		}
 		return nil
@@ -540,7 +619,9 @@ func (s *Server) commitTransferHandler(w http.ResponseWriter, r *http.Request) e
 	if err := s.uploader.commit(d, uid); err != nil {
 		return err
 	}
-	if err := s.metaInfoGenerator.Generate(d); err != nil {
+	// Namespace is optional for transfers.
+	namespace := r.URL.Query().Get("namespace")
+	if err := s.metaInfoGenerator.Generate(namespace, d); err != nil {
 		return handler.Errorf("generate metainfo: %s", err)
 	}
 	return nil
@@ -679,7 +760,7 @@ func (s *Server) writeBack(namespace string, d core.Digest, delay time.Duration)
 	if err := s.writeBackManager.Add(task); err != nil {
 		return handler.Errorf("add write-back task: %s", err)
 	}
-	if err := s.metaInfoGenerator.Generate(d); err != nil {
+	if err := s.metaInfoGenerator.Generate(namespace, d); err != nil {
 		return handler.Errorf("generate metainfo: %s", err)
 	}
 	return nil
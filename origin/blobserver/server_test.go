@@ -137,7 +137,7 @@ func TestStatHandlerReturnSize(t *testing.T) {
 	blob := core.SizedBlobFixture(256, 8)
 	namespace := core.TagFixture()
 
-	require.NoError(client.TransferBlob(blob.Digest, bytes.NewReader(blob.Content)))
+	require.NoError(client.TransferBlob(namespace, blob.Digest, bytes.NewReader(blob.Content)))
 
 	ensureHasBlob(t, cp.Provide(s.host), namespace, blob)
 
@@ -213,7 +213,7 @@ func TestDeleteBlob(t *testing.T) {
 	blob := core.NewBlobFixture()
 	namespace := core.TagFixture()
 
-	require.NoError(client.TransferBlob(blob.Digest, bytes.NewReader(blob.Content)))
+	require.NoError(client.TransferBlob(namespace, blob.Digest, bytes.NewReader(blob.Content)))
 
 	ensureHasBlob(t, cp.Provide(s.host), namespace, blob)
 
@@ -370,7 +370,7 @@ func TestTransferBlob(t *testing.T) {
 	blob := core.NewBlobFixture()
 	namespace := core.TagFixture()
 
-	err := cp.Provide(master1).TransferBlob(blob.Digest, bytes.NewReader(blob.Content))
+	err := cp.Provide(master1).TransferBlob(namespace, blob.Digest, bytes.NewReader(blob.Content))
 	require.NoError(err)
 	ensureHasBlob(t, cp.Provide(master1), namespace, blob)
 
@@ -379,7 +379,7 @@ func TestTransferBlob(t *testing.T) {
 	require.NoError(s.cas.GetCacheFileMetadata(blob.Digest.Hex(), &tm))
 
 	// Pushing again should be a no-op.
-	err = cp.Provide(master1).TransferBlob(blob.Digest, bytes.NewReader(blob.Content))
+	err = cp.Provide(master1).TransferBlob(namespace, blob.Digest, bytes.NewReader(blob.Content))
 	require.NoError(err)
 	ensureHasBlob(t, cp.Provide(master1), namespace, blob)
 }
@@ -484,7 +484,7 @@ func TestTransferBlobSmallChunkSize(t *testing.T) {
 
 	client := blobclient.New(s.addr, blobclient.WithChunkSize(13))
 
-	err := client.TransferBlob(blob.Digest, bytes.NewReader(blob.Content))
+	err := client.TransferBlob(namespace, blob.Digest, bytes.NewReader(blob.Content))
 	require.NoError(err)
 	ensureHasBlob(t, client, namespace, blob)
 }
@@ -500,7 +500,7 @@ func TestOverwriteMetainfo(t *testing.T) {
 	blob := core.NewBlobFixture()
 	namespace := core.TagFixture()
 
-	err := cp.Provide(master1).TransferBlob(blob.Digest, bytes.NewReader(blob.Content))
+	err := cp.Provide(master1).TransferBlob(namespace, blob.Digest, bytes.NewReader(blob.Content))
 	require.NoError(err)
 
 	mi, err := cp.Provide(master1).GetMetaInfo(namespace, blob.Digest)
@@ -515,6 +515,116 @@ func TestOverwriteMetainfo(t *testing.T) {
 	require.Equal(int64(16), mi.PieceLength())
 }
 
+func TestOverwriteMetaInfoMigratesVersion(t *testing.T) {
+	require := require.New(t)
+
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	blob := core.NewBlobFixture()
+	namespace := core.TagFixture()
+
+	err := cp.Provide(master1).TransferBlob(namespace, blob.Digest, bytes.NewReader(blob.Content))
+	require.NoError(err)
+
+	mi, err := cp.Provide(master1).GetMetaInfo(namespace, blob.Digest)
+	require.NoError(err)
+	require.Equal(core.MetaInfoV1, mi.Version())
+	v1 := mi
+
+	err = cp.Provide(master1).OverwriteMetaInfo(
+		blob.Digest, mi.PieceLength(), blobclient.OverwriteWithMerkleRoot())
+	require.NoError(err)
+
+	mi, err = cp.Provide(master1).GetMetaInfo(namespace, blob.Digest)
+	require.NoError(err)
+	require.Equal(core.MetaInfoV2, mi.Version())
+	require.Equal(int64(len(blob.Content)), mi.Length())
+
+	err = cp.Provide(master1).OverwriteMetaInfo(
+		blob.Digest, mi.PieceLength(), blobclient.OverwriteWithVersion(core.MetaInfoV1))
+	require.NoError(err)
+
+	mi, err = cp.Provide(master1).GetMetaInfo(namespace, blob.Digest)
+	require.NoError(err)
+	require.Equal(core.MetaInfoV1, mi.Version())
+	require.Equal(v1.InfoHash(), mi.InfoHash())
+
+	err = cp.Provide(master1).OverwriteMetaInfo(
+		blob.Digest, mi.PieceLength(), blobclient.OverwriteWithVersion(3))
+	require.True(httputil.IsStatus(err, http.StatusBadRequest))
+}
+
+func TestRegenerateMetaInfo(t *testing.T) {
+	require := require.New(t)
+
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	blob := core.NewBlobFixture()
+	namespace := core.TagFixture()
+
+	// Does not trigger a download of unknown blobs.
+	err := cp.Provide(master1).RegenerateMetaInfo(namespace, blob.Digest, false)
+	require.True(httputil.IsNotFound(err))
+
+	err = cp.Provide(master1).TransferBlob(namespace, blob.Digest, bytes.NewReader(blob.Content))
+	require.NoError(err)
+
+	mi, err := cp.Provide(master1).GetMetaInfo(namespace, blob.Digest)
+	require.NoError(err)
+
+	err = cp.Provide(master1).OverwriteMetaInfo(blob.Digest, 16)
+	require.NoError(err)
+
+	require.NoError(cp.Provide(master1).RegenerateMetaInfo(namespace, blob.Digest, false))
+
+	result, err := cp.Provide(master1).GetMetaInfo(namespace, blob.Digest)
+	require.NoError(err)
+	require.Equal(mi.InfoHash(), result.InfoHash())
+}
+
+func TestGetMetaInfoVersionChangesOnOverwrite(t *testing.T) {
+	require := require.New(t)
+
+	cp := newTestClientProvider()
+
+	s := newTestServer(t, master1, hashRingMaxReplica(), cp)
+	defer s.cleanup()
+
+	blob := core.NewBlobFixture()
+	namespace := core.TagFixture()
+
+	// Does not trigger a download of unknown blobs.
+	_, err := cp.Provide(master1).GetMetaInfoVersion(blob.Digest)
+	require.True(httputil.IsNotFound(err))
+
+	err = cp.Provide(master1).TransferBlob(namespace, blob.Digest, bytes.NewReader(blob.Content))
+	require.NoError(err)
+
+	mi, err := cp.Provide(master1).GetMetaInfo(namespace, blob.Digest)
+	require.NoError(err)
+
+	version, err := cp.Provide(master1).GetMetaInfoVersion(blob.Digest)
+	require.NoError(err)
+	require.Equal(mi.InfoHash().Hex(), version)
+
+	err = cp.Provide(master1).OverwriteMetaInfo(blob.Digest, 16)
+	require.NoError(err)
+
+	mi, err = cp.Provide(master1).GetMetaInfo(namespace, blob.Digest)
+	require.NoError(err)
+
+	newVersion, err := cp.Provide(master1).GetMetaInfoVersion(blob.Digest)
+	require.NoError(err)
+	require.NotEqual(version, newVersion)
+	require.Equal(mi.InfoHash().Hex(), newVersion)
+}
+
 func TestReplicateToRemote(t *testing.T) {
 	require := require.New(t)
 
@@ -526,7 +636,7 @@ func TestReplicateToRemote(t *testing.T) {
 	blob := core.NewBlobFixture()
 	namespace := core.TagFixture()
 
-	require.NoError(cp.Provide(master1).TransferBlob(blob.Digest, bytes.NewReader(blob.Content)))
+	require.NoError(cp.Provide(master1).TransferBlob(namespace, blob.Digest, bytes.NewReader(blob.Content)))
 
 	remote := "remote:80"
 
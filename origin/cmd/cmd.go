@@ -27,11 +27,13 @@ import (
 	"github.com/uber/kraken/lib/healthcheck"
 	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/lib/metainfogen"
+	"github.com/uber/kraken/lib/peertoken"
 	"github.com/uber/kraken/lib/persistedretry"
 	"github.com/uber/kraken/lib/persistedretry/writeback"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler"
+	"github.com/uber/kraken/lib/torrent/storage/piececache"
 	"github.com/uber/kraken/localdb"
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
@@ -218,13 +220,45 @@ func Run(flags *Flags, opts ...Option) {
 
 	blobRefresher := blobrefresh.New(config.BlobRefresh, stats, cas, backendManager, metaInfoGenerator)
 
-	netevents, err := networkevent.NewProducer(config.NetworkEvent)
+	var stream *networkevent.Stream
+	var sinks []networkevent.Sink
+	if config.NetworkEvent.Stream.Enable {
+		stream = networkevent.NewStream(config.NetworkEvent.Stream)
+		sinks = append(sinks, stream)
+	}
+	netevents, err := networkevent.NewProducer(config.NetworkEvent, sinks...)
 	if err != nil {
 		log.Fatalf("Error creating network event producer: %s", err)
 	}
 
+	pieceCache := piececache.New(config.PieceCache, stats)
+
+	// Origins are trusted to sign their own tokens.
+	var tokenSource peertoken.Source
+	if config.PeerToken.Enable {
+		signer, err := peertoken.NewSigner(config.PeerToken, clock.New())
+		if err != nil {
+			log.Fatalf("Error creating peer token signer: %s", err)
+		}
+		tokenSource = signer.Source(pctx.IP)
+	}
+	tokens, err := peertoken.NewIssuer(config.PeerToken, pctx, clock.New(), tokenSource)
+	if err != nil {
+		log.Fatalf("Error creating peer token issuer: %s", err)
+	}
+	tokenVerifier, err := peertoken.NewVerifier(config.PeerToken, clock.New(), stats)
+	if err != nil {
+		log.Fatalf("Error creating peer token verifier: %s", err)
+	}
+
+	tls, err := config.TLS.BuildClient()
+	if err != nil {
+		log.Fatalf("Error building client tls config: %s", err)
+	}
+
 	sched, err := scheduler.NewOriginScheduler(
-		config.Scheduler, stats, pctx, cas, netevents, blobRefresher)
+		config.Scheduler, stats, pctx, cas, netevents, blobRefresher, pieceCache,
+		tls, tokens, tokenVerifier)
 	if err != nil {
 		log.Fatalf("Error creating scheduler: %s", err)
 	}
@@ -235,11 +269,6 @@ func Run(flags *Flags, opts ...Option) {
 		log.Fatalf("Error creating cluster host list: %s", err)
 	}
 
-	tls, err := config.TLS.BuildClient()
-	if err != nil {
-		log.Fatalf("Error building client tls config: %s", err)
-	}
-
 	// 健康检查
 	healthCheckFilter := healthcheck.NewFilter(config.HealthCheck, healthcheck.Default(tls))
 
@@ -288,7 +317,7 @@ func Run(flags *Flags, opts ...Option) {
 		log.Fatalf("Error initializing blob server: %s", err)
 	}
 
-	h := addTorrentDebugEndpoints(server.Handler(), sched)
+	h := addTorrentDebugEndpoints(server.Handler(), sched, stream)
 
 	go func() { log.Fatal(server.ListenAndServe(h)) }()
 
@@ -305,7 +334,9 @@ func Run(flags *Flags, opts ...Option) {
 
 // addTorrentDebugEndpoints mounts experimental debugging endpoints which are
 // compatible with the agent server.
-func addTorrentDebugEndpoints(h http.Handler, sched scheduler.ReloadableScheduler) http.Handler {
+func addTorrentDebugEndpoints(
+	h http.Handler, sched scheduler.ReloadableScheduler, netevents *networkevent.Stream) http.Handler {
+
 	r := chi.NewRouter()
 
 	r.Patch("/x/config/scheduler", handler.Wrap(func(w http.ResponseWriter, r *http.Request) error {
@@ -317,6 +348,10 @@ func addTorrentDebugEndpoints(h http.Handler, sched scheduler.ReloadableSchedule
 		return nil
 	}))
 
+	if netevents != nil {
+		r.Get("/x/networkevents", netevents.ServeHTTP)
+	}
+
 	r.Mount("/", h)
 
 	return r
@@ -21,10 +21,12 @@ import (
 	"github.com/uber/kraken/lib/healthcheck"
 	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/lib/metainfogen"
+	"github.com/uber/kraken/lib/peertoken"
 	"github.com/uber/kraken/lib/persistedretry"
 	"github.com/uber/kraken/lib/store"
 	"github.com/uber/kraken/lib/torrent/networkevent"
 	"github.com/uber/kraken/lib/torrent/scheduler"
+	"github.com/uber/kraken/lib/torrent/storage/piececache"
 	"github.com/uber/kraken/localdb"
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
@@ -37,31 +39,34 @@ import (
 // Config defines origin server configuration.
 // TODO(evelynl94): consolidate cluster and hashring.
 type Config struct {
-	Verbose       bool
-	ZapLogging    zap.Config               `yaml:"zap"`
+	Verbose    bool
+	ZapLogging zap.Config `yaml:"zap"`
 	// 集群地址配置，可配置静态地址列表和DNS
-	Cluster       hostlist.Config          `yaml:"cluster"`
+	Cluster hostlist.Config `yaml:"cluster"`
 	// hash ring 配置，指定一个blob的最大副本数量， 和地址列表/健康情况刷新间隔
-	HashRing      hashring.Config          `yaml:"hashring"`
+	HashRing hashring.Config `yaml:"hashring"`
 	// 集群节点健康检查方法， 因为 origin的集群一般比较小，所以集群节点间会互相检查 （Active Health Check）
-	HealthCheck   healthcheck.FilterConfig `yaml:"healthcheck"`
-	BlobServer    blobserver.Config        `yaml:"blobserver"`
-	CAStore       store.CAStoreConfig      `yaml:"castore"`
+	HealthCheck healthcheck.FilterConfig `yaml:"healthcheck"`
+	BlobServer  blobserver.Config        `yaml:"blobserver"`
+	CAStore     store.CAStoreConfig      `yaml:"castore"`
 	// scheduler 配置
-	Scheduler     scheduler.Config         `yaml:"scheduler"`
-	NetworkEvent  networkevent.Config      `yaml:"network_event"`
-	PeerIDFactory core.PeerIDFactory       `yaml:"peer_id_factory"`
+	Scheduler scheduler.Config `yaml:"scheduler"`
+	// In-memory cache of hot pieces served to peers.
+	PieceCache    piececache.Config   `yaml:"piece_cache"`
+	NetworkEvent  networkevent.Config `yaml:"network_event"`
+	PeerIDFactory core.PeerIDFactory  `yaml:"peer_id_factory"`
 	// 监控项配置
-	Metrics       metrics.Config           `yaml:"metrics"`
-	MetaInfoGen   metainfogen.Config       `yaml:"metainfogen"`
+	Metrics     metrics.Config     `yaml:"metrics"`
+	MetaInfoGen metainfogen.Config `yaml:"metainfogen"`
 	// 后端存储配置
-	Backends      []backend.Config         `yaml:"backends"`
+	Backends []backend.Config `yaml:"backends"`
 	// 后端存储用户认证
-	Auth          backend.AuthConfig       `yaml:"auth"`
-	BlobRefresh   blobrefresh.Config       `yaml:"blobrefresh"`
-	LocalDB       localdb.Config           `yaml:"localdb"`
-	WriteBack     persistedretry.Config    `yaml:"writeback"`
+	Auth        backend.AuthConfig    `yaml:"auth"`
+	BlobRefresh blobrefresh.Config    `yaml:"blobrefresh"`
+	LocalDB     localdb.Config        `yaml:"localdb"`
+	WriteBack   persistedretry.Config `yaml:"writeback"`
 	// nginx 配置
-	Nginx         nginx.Config             `yaml:"nginx"`
-	TLS           httputil.TLSConfig       `yaml:"tls"`
+	Nginx     nginx.Config       `yaml:"nginx"`
+	TLS       httputil.TLSConfig `yaml:"tls"`
+	PeerToken peertoken.Config   `yaml:"peer_token"`
 }
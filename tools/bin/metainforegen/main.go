@@ -0,0 +1,173 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+	"github.com/uber/kraken/utils/osutil"
+)
+
+// Regenerates the metainfo of blobs in a namespace on every origin which holds
+// them, such that changes to the metainfogen rule of the namespace apply to
+// existing blobs. Origins which do not hold a blob are skipped. Regenerating
+// with an unchanged rule produces identical metainfo.
+//
+// Metainfo is stored per digest, so blobs shared with another namespace whose
+// rule disagrees are skipped and reported as conflicts, unless -force is set.
+//
+// Usage: metainforegen -namespace <namespace> -hosts <origins> [-force] [digest file]
+//
+// Digests are read one per line from the digest file, or stdin if omitted.
+func main() {
+	namespace := flag.String("namespace", "", "namespace of the blobs")
+	hostFile := flag.String("f", "", "origin host file")
+	hostStr := flag.String("hosts", "", "comma-separated origin hosts")
+	port := flag.Int("port", 0, "origin blob server port")
+	concurrency := flag.Int("concurrency", 8, "number of blobs regenerated concurrently")
+	force := flag.Bool("force", false, "overwrite metainfo generated for other namespaces")
+	flag.Parse()
+
+	if *namespace == "" {
+		log.Fatal("-namespace required")
+	}
+	if (*hostFile != "" && *hostStr != "") || (*hostFile == "" && *hostStr == "") {
+		log.Fatal("Must set either -f or -hosts")
+	}
+	if *port == 0 {
+		log.Fatal("-port must be non-zero")
+	}
+	if *concurrency <= 0 {
+		log.Fatal("-concurrency must be positive")
+	}
+
+	var hosts []string
+	if *hostFile != "" {
+		hosts = readLines(*hostFile)
+	} else {
+		hosts = strings.Split(*hostStr, ",")
+	}
+	var clients []blobclient.Client
+	for _, host := range hosts {
+		clients = append(clients, blobclient.New(fmt.Sprintf("%s:%d", host, *port)))
+	}
+
+	var lines []string
+	if flag.NArg() > 0 {
+		lines = readLines(flag.Arg(0))
+	} else {
+		var err error
+		lines, err = osutil.ReadLines(os.Stdin)
+		if err != nil {
+			log.Fatalf("Error reading stdin: %s", err)
+		}
+	}
+	var digests []core.Digest
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		d, err := parseDigest(line)
+		if err != nil {
+			log.Fatalf("Error parsing digest %q: %s", line, err)
+		}
+		digests = append(digests, d)
+	}
+
+	var mu sync.Mutex
+	var regenerated, missing, conflicts, failed int
+
+	work := make(chan core.Digest)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range work {
+				var found, conflict bool
+				var errs []string
+				for _, client := range clients {
+					err := client.RegenerateMetaInfo(*namespace, d, *force)
+					if httputil.IsNotFound(err) {
+						continue
+					} else if httputil.IsConflict(err) {
+						conflict = true
+						continue
+					} else if err != nil {
+						errs = append(errs, fmt.Sprintf("%s: %s", client.Addr(), err))
+						continue
+					}
+					found = true
+				}
+				mu.Lock()
+				switch {
+				case len(errs) > 0:
+					failed++
+					log.Errorf("Error regenerating metainfo of %s: %s", d, strings.Join(errs, ", "))
+				case conflict:
+					conflicts++
+					log.Warnf("Metainfo of %s was generated for another namespace, skipped", d)
+				case !found:
+					missing++
+					log.Warnf("No origin holds %s", d)
+				default:
+					regenerated++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, d := range digests {
+		work <- d
+	}
+	close(work)
+	wg.Wait()
+
+	fmt.Printf(
+		"regenerated: %d, missing: %d, conflicts: %d, failed: %d\n",
+		regenerated, missing, conflicts, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func readLines(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Error opening %s: %s", path, err)
+	}
+	defer f.Close()
+	lines, err := osutil.ReadLines(f)
+	if err != nil {
+		log.Fatalf("Error reading %s: %s", path, err)
+	}
+	return lines
+}
+
+// parseDigest accepts both "sha256:<hex>" and bare hex digests.
+func parseDigest(s string) (core.Digest, error) {
+	if strings.Contains(s, ":") {
+		return core.ParseSHA256Digest(s)
+	}
+	return core.NewSHA256DigestFromHex(s)
+}
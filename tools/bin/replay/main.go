@@ -0,0 +1,111 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/uber/kraken/lib/torrent/networkevent"
+	"github.com/uber/kraken/lib/torrent/networkevent/replay"
+	"github.com/uber/kraken/utils/log"
+)
+
+// Ingests networkevent logs gathered from many hosts and reports the critical
+// path of each torrent: the slowest download, the slowest pieces, the peers
+// which idled the longest, and the share of pieces served by origins.
+//
+// Usage: replay [flags] <event log>...
+func main() {
+	top := flag.Int("top", 5, "number of slowest pieces, idle peers and sources to report")
+	origins := flag.String("origins", "", "comma separated origin peer ids, inferred from seeders if empty")
+	torrent := flag.String("torrent", "", "only report the torrent with this info hash")
+	asJSON := flag.Bool("json", false, "print reports as json")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatal("Must provide at least one event log")
+	}
+
+	var events []*networkevent.Event
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Error opening %s: %s", path, err)
+		}
+		e, malformed, err := replay.Read(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Error reading %s: %s", path, err)
+		}
+		if malformed > 0 {
+			log.Warnf("Skipped %d malformed events in %s", malformed, path)
+		}
+		events = append(events, e...)
+	}
+
+	config := replay.Config{TopN: *top}
+	if *origins != "" {
+		config.Origins = strings.Split(*origins, ",")
+	}
+
+	var reports []*replay.TorrentReport
+	for _, r := range replay.Analyze(events, config) {
+		if *torrent == "" || r.Torrent == *torrent {
+			reports = append(reports, r)
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			log.Fatalf("Error encoding reports: %s", err)
+		}
+		return
+	}
+	for _, r := range reports {
+		printReport(r)
+	}
+}
+
+func printReport(r *replay.TorrentReport) {
+	fmt.Printf("torrent %s\n", r.Torrent)
+	fmt.Printf("  duration=%s peers=%d completed=%d cancelled=%d origin_share=%.2f\n",
+		r.End.Sub(r.Start), r.Peers, r.Completed, r.Cancelled, r.OriginShare)
+
+	if d := r.CriticalPath; d != nil {
+		fmt.Printf("  critical path: peer=%s duration=%s complete=%t pieces=%d longest_idle=%s\n",
+			d.Peer, d.Duration(), d.Complete, d.Pieces, d.LongestIdle)
+		for _, s := range d.Sources {
+			fmt.Printf("    source %s pieces=%d origin=%t\n", s.Peer, s.Pieces, s.Origin)
+		}
+	}
+	if len(r.SlowestPieces) > 0 {
+		fmt.Println("  slowest pieces:")
+		for _, p := range r.SlowestPieces {
+			fmt.Printf("    piece=%d %s <- %s latency=%s\n", p.Piece, p.Self, p.Peer, p.Latency)
+		}
+	}
+	if len(r.IdlePeers) > 0 {
+		fmt.Println("  idle peers:")
+		for _, d := range r.IdlePeers {
+			fmt.Printf("    %s idle=%s since=%s\n",
+				d.Peer, d.LongestIdle, d.IdleSince.Format("15:04:05.000"))
+		}
+	}
+}
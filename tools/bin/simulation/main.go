@@ -0,0 +1,77 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/uber/kraken/lib/torrent/networkevent"
+	"github.com/uber/kraken/lib/torrent/simulation"
+	"github.com/uber/kraken/utils/configutil"
+	"github.com/uber/kraken/utils/log"
+)
+
+// Runs an in-process swarm simulation and prints the distribution of download
+// times. The network event trace may be replayed with tools/bin/visualization.
+func main() {
+	configFile := flag.String("config", "", "simulation config file")
+	traceFile := flag.String("trace", "", "network event trace output file")
+	agents := flag.Int("agents", 0, "number of agents, overrides config")
+	origins := flag.Int("origins", 0, "number of origins, overrides config")
+	seed := flag.Int64("seed", 0, "random seed, overrides config")
+	latency := flag.Duration("latency", 0, "one-way network latency, overrides config")
+	flag.Parse()
+
+	var config simulation.Config
+	if *configFile != "" {
+		if err := configutil.Load(*configFile, &config); err != nil {
+			log.Fatalf("Error loading config: %s", err)
+		}
+	}
+	if *agents != 0 {
+		config.Agents = *agents
+	}
+	if *origins != 0 {
+		config.Origins = *origins
+	}
+	if *seed != 0 {
+		config.Seed = *seed
+	}
+	if *latency != 0 {
+		config.Network.Latency = *latency
+	}
+
+	trace, err := networkevent.NewProducer(networkevent.Config{
+		Enabled: *traceFile != "",
+		LogPath: *traceFile,
+	})
+	if err != nil {
+		log.Fatalf("Error creating trace producer: %s", err)
+	}
+	defer trace.Close()
+
+	s, err := simulation.New(config, trace)
+	if err != nil {
+		log.Fatalf("Error creating simulation: %s", err)
+	}
+	start := time.Now()
+	result, err := s.Run()
+	if err != nil {
+		log.Fatalf("Error running simulation: %s", err)
+	}
+	fmt.Print(result)
+	fmt.Printf("real=%s\n", time.Since(start))
+}
@@ -14,6 +14,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -22,11 +23,34 @@ import (
 )
 
 func main() {
-	eventFile := kingpin.Arg("events", "Network event file").Required().File()
+	eventFile := kingpin.Arg("events", "Network event file").File()
+	sources := kingpin.Flag(
+		"source", "agent or origin server address to stream events from, may be repeated").Strings()
+	torrents := kingpin.Flag(
+		"torrent", "only stream events of this info hash from sources, may be repeated").Strings()
+	maxTorrents := kingpin.Flag("max-torrents", "maximum number of torrents kept").Default("100").Int()
+	maxEvents := kingpin.Flag(
+		"max-events-per-torrent", "maximum number of events kept per torrent").Default("100000").Int()
 	port := kingpin.Flag("port", "listening port").Default("3000").Int()
 	kingpin.Parse()
 
-	s := newServer(*eventFile)
+	if *eventFile == nil && len(*sources) == 0 {
+		kingpin.Fatalf("must provide an event file or at least one source")
+	}
+
+	s := newServer(config{
+		live:                len(*sources) > 0,
+		maxTorrents:         *maxTorrents,
+		maxEventsPerTorrent: *maxEvents,
+		clientBufferSize:    10000,
+	})
+	if *eventFile != nil {
+		s.load(*eventFile)
+	}
+	for _, addr := range *sources {
+		go subscribe(context.Background(), s, addr, *torrents)
+	}
+
 	addr := fmt.Sprintf("localhost:%d", *port)
 	log.Printf("Listening on %s ...", addr)
 	log.Fatal(http.ListenAndServe(addr, s.handler()))
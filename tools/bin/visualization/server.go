@@ -17,51 +17,189 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"github.com/uber/kraken/lib/torrent/networkevent"
+	"github.com/uber/kraken/utils/stringset"
 )
 
+// eventNames are the events rendered by the visualization.
+var eventNames = []networkevent.Name{
+	networkevent.AddTorrent,
+	networkevent.AddActiveConn,
+	networkevent.DropActiveConn,
+	networkevent.BlacklistConn,
+	networkevent.ReceivePiece,
+	networkevent.TorrentComplete,
+	networkevent.TorrentCancelled,
+}
+
+type config struct {
+	// live is set if events are streamed from running agents and origins
+	// rather than replayed from a file.
+	live bool
+
+	// maxTorrents bounds the number of torrents whose events are kept, evicting
+	// the least recently updated torrent.
+	maxTorrents int
+
+	// maxEventsPerTorrent bounds the number of events kept per torrent.
+	maxEventsPerTorrent int
+
+	// clientBufferSize bounds the number of events buffered per browser.
+	// Browsers which fall behind are disconnected.
+	clientBufferSize int
+}
+
+type torrentHistory struct {
+	namespace string
+	events    []*networkevent.Event
+	updatedAt time.Time
+}
+
+// filter matches events by info hash or namespace. An empty filter matches
+// all events.
+type filter struct {
+	torrents   stringset.Set
+	namespaces stringset.Set
+}
+
+func parseFilter(q url.Values) filter {
+	return filter{
+		torrents:   stringset.FromSlice(q["infohash"]),
+		namespaces: stringset.FromSlice(q["namespace"]),
+	}
+}
+
+func (f filter) match(torrent, namespace string) bool {
+	if len(f.torrents) == 0 && len(f.namespaces) == 0 {
+		return true
+	}
+	return f.torrents.Has(torrent) || f.namespaces.Has(namespace)
+}
+
+type client struct {
+	filter filter
+	events chan *networkevent.Event
+	done   chan struct{}
+}
+
 type server struct {
-	events []*networkevent.Event
+	config config
+
+	mu       sync.Mutex
+	torrents map[string]*torrentHistory
+	clients  map[*client]struct{}
 }
 
-func newServer(eventFile *os.File) *server {
-	var events []*networkevent.Event
+func newServer(config config) *server {
+	return &server{
+		config:   config,
+		torrents: make(map[string]*torrentHistory),
+		clients:  make(map[*client]struct{}),
+	}
+}
 
-	scanner := bufio.NewScanner(eventFile)
+// load adds recorded events from r.
+func (s *server) load(r io.Reader) {
+	var events []*networkevent.Event
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		line := scanner.Bytes()
 		var event networkevent.Event
-		if err := json.Unmarshal(line, &event); err != nil {
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
 			log.Printf("Error unmarshalling event: %s\n", err)
 			continue
 		}
 		events = append(events, &event)
 	}
-	events = networkevent.Filter(
-		events,
-		networkevent.AddTorrent,
-		networkevent.AddActiveConn,
-		networkevent.DropActiveConn,
-		networkevent.BlacklistConn,
-		networkevent.ReceivePiece,
-		networkevent.TorrentComplete,
-		networkevent.TorrentCancelled)
 	networkevent.Sort(events)
+	for _, e := range events {
+		s.add(e)
+	}
+}
 
-	return &server{events}
+// add records e and sends it to all browsers whose filter matches.
+func (s *server) add(e *networkevent.Event) {
+	if len(networkevent.Filter([]*networkevent.Event{e}, eventNames...)) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.torrents[e.Torrent]
+	if !ok {
+		s.evict()
+		t = &torrentHistory{}
+		s.torrents[e.Torrent] = t
+	}
+	if e.Namespace != "" {
+		t.namespace = e.Namespace
+	}
+	if len(t.events) < s.config.maxEventsPerTorrent {
+		t.events = append(t.events, e)
+	}
+	t.updatedAt = time.Now()
+
+	for c := range s.clients {
+		if !c.filter.match(e.Torrent, t.namespace) {
+			continue
+		}
+		select {
+		case c.events <- e:
+		default:
+			// Browser is too slow, disconnect it such that it can reload the
+			// full history.
+			delete(s.clients, c)
+			close(c.done)
+		}
+	}
+}
+
+// evict removes the least recently updated torrent if at capacity.
+func (s *server) evict() {
+	if len(s.torrents) < s.config.maxTorrents {
+		return
+	}
+	var oldest string
+	var oldestTime time.Time
+	for h, t := range s.torrents {
+		if oldest == "" || t.updatedAt.Before(oldestTime) {
+			oldest = h
+			oldestTime = t.updatedAt
+		}
+	}
+	delete(s.torrents, oldest)
+}
+
+// history returns all recorded events matching f, sorted by time.
+func (s *server) history(f filter) []*networkevent.Event {
+	var events []*networkevent.Event
+	for h, t := range s.torrents {
+		if f.match(h, t.namespace) {
+			events = append(events, t.events...)
+		}
+	}
+	networkevent.Sort(events)
+	return events
 }
 
 func (s *server) handler() http.Handler {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, "/static/html/app.html", http.StatusSeeOther)
+		q := r.URL.Query()
+		if s.config.live {
+			q.Set("mode", "live")
+		}
+		http.Redirect(w, r, "/static/html/app.html?"+q.Encode(), http.StatusSeeOther)
 	})
 
 	fs := http.FileServer(http.Dir("./tools/bin/visualization/static/"))
@@ -69,19 +207,84 @@ func (s *server) handler() http.Handler {
 
 	r.HandleFunc("/events", s.getEvents)
 
+	r.HandleFunc("/events/stream", s.streamEvents)
+
 	return r
 }
 
+// getEvents returns all recorded events matching the "infohash" and
+// "namespace" query parameters.
 func (s *server) getEvents(w http.ResponseWriter, r *http.Request) {
-	if err := json.NewEncoder(w).Encode(s.events); err != nil {
+	s.mu.Lock()
+	events := s.history(parseFilter(r.URL.Query()))
+	s.mu.Unlock()
+
+	if err := json.NewEncoder(w).Encode(events); err != nil {
 		log.Printf("Error encoding events: %s\n", err)
 		http.Error(w, fmt.Sprintf("encode events: %s", err), 500)
 		return
 	}
 }
 
-type byTime []networkevent.Event
+// streamEvents sends all recorded events matching the "infohash" and
+// "namespace" query parameters to the browser as server-sent events, followed
+// by new events as they arrive.
+func (s *server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", 500)
+		return
+	}
+
+	c := &client{
+		filter: parseFilter(r.URL.Query()),
+		events: make(chan *networkevent.Event, s.config.clientBufferSize),
+		done:   make(chan struct{}),
+	}
+	s.mu.Lock()
+	history := s.history(c.filter)
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
 
-func (s byTime) Len() int           { return len(s) }
-func (s byTime) Less(i, j int) bool { return s[i].Time.Before(s[j].Time) }
-func (s byTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+	defer func() {
+		s.mu.Lock()
+		if _, ok := s.clients[c]; ok {
+			delete(s.clients, c)
+			close(c.done)
+		}
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	for _, e := range history {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case e := <-c.events:
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-c.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, e *networkevent.Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", b)
+	return err
+}
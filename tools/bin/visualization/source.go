@@ -0,0 +1,91 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/uber/kraken/lib/torrent/networkevent"
+)
+
+const (
+	_minRetryInterval = time.Second
+	_maxRetryInterval = 30 * time.Second
+)
+
+// subscribe streams events from the /x/networkevents endpoint of the agent or
+// origin at addr into s until ctx is done, reconnecting on failure. Events may
+// be limited to torrents.
+func subscribe(ctx context.Context, s *server, addr string, torrents []string) {
+	u := fmt.Sprintf("http://%s/x/networkevents", addr)
+	if len(torrents) > 0 {
+		u += "?torrent=" + strings.Join(torrents, ",")
+	}
+	interval := _minRetryInterval
+	for {
+		start := time.Now()
+		err := stream(ctx, s, u)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > _maxRetryInterval {
+			interval = _minRetryInterval
+		}
+		log.Printf("Stream from %s ended, reconnecting in %s: %s\n", addr, interval, err)
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+		interval *= 2
+		if interval > _maxRetryInterval {
+			interval = _maxRetryInterval
+		}
+	}
+}
+
+func stream(ctx context.Context, s *server, u string) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var e networkevent.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Printf("Error unmarshalling event: %s\n", err)
+			continue
+		}
+		s.add(&e)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("eof")
+}
@@ -315,69 +315,103 @@ class Graph {
   }
 }
 
-d3.request('http://' + location.host + '/events').get(req => {
-  var events = JSON.parse(req.response);
-  var graph = new Graph(events[0].torrent, Date.parse(events[0].ts));
-
-  // Maps peer id to list of events which occurred before the peer was added
-  // to the graph. Early events are possible in cases where a connection is
-  // added before the torrent is opened, which is valid.
-  var earlyEvents = {};
-
-  function applyEvent(event) {
-    try {
-      switch (event.event) {
-        case 'add_torrent':
-          graph.addPeer(event.self, event.bitfield);
-          if (event.self in earlyEvents) {
-            earlyEvents[event.self].forEach(e => applyEvent(e))
-          }
-          break;
-        case 'add_active_conn':
-          graph.addActiveConn(event.self, event.peer);
-          break;
-        case 'drop_active_conn':
-          graph.removeActiveConn(event.self, event.peer);
-          break;
-        case 'receive_piece':
-          graph.receivePiece(event.self, event.piece);
-          break;
-        case 'torrent_complete':
-          graph.completePeer(event.self);
-          break;
-        case 'blacklist_conn':
-          graph.blacklistConn(event.self, event.peer, parseInt(event.duration_ms));
-          break;
-      }
-    } catch (err) {
-      if (err.message == 'not found') {
-        if (!(err.peer in earlyEvents)) {
-          earlyEvents[err.peer] = [];
+// Maps peer id to list of events which occurred before the peer was added
+// to the graph. Early events are possible in cases where a connection is
+// added before the torrent is opened, which is valid.
+var earlyEvents = {};
+
+function applyEvent(graph, event) {
+  try {
+    switch (event.event) {
+      case 'add_torrent':
+        graph.addPeer(event.self, event.bitfield);
+        if (event.self in earlyEvents) {
+          earlyEvents[event.self].forEach(e => applyEvent(graph, e))
         }
-        earlyEvents[err.peer].push(event);
-      } else {
-        console.log('unhandled error: ' + err);
+        break;
+      case 'add_active_conn':
+        graph.addActiveConn(event.self, event.peer);
+        break;
+      case 'drop_active_conn':
+        graph.removeActiveConn(event.self, event.peer);
+        break;
+      case 'receive_piece':
+        graph.receivePiece(event.self, event.piece);
+        break;
+      case 'torrent_complete':
+        graph.completePeer(event.self);
+        break;
+      case 'blacklist_conn':
+        graph.blacklistConn(event.self, event.peer, parseInt(event.duration_ms));
+        break;
+    }
+  } catch (err) {
+    if (err.message == 'not found') {
+      if (!(err.peer in earlyEvents)) {
+        earlyEvents[err.peer] = [];
       }
+      earlyEvents[err.peer].push(event);
+    } else {
+      console.log('unhandled error: ' + err);
     }
   }
+}
 
-  // Every interval seconds, we read all events that occur within that interval
-  // and apply them to the graph. This gives the illusion of events occuring in
-  // real-time.
-  const interval = 100;
-
-  function readEvents(i, until) {
-    if (i >= events.length) {
+// Every interval milliseconds, the graph is updated with all events that
+// occurred within that interval.
+const interval = 100;
+
+const params = new URLSearchParams(location.search);
+
+// Filters events by info hash or namespace.
+const filter = new URLSearchParams();
+params.getAll('infohash').forEach(h => filter.append('infohash', h));
+params.getAll('namespace').forEach(ns => filter.append('namespace', ns));
+
+if (params.get('mode') == 'live') {
+  // Apply events streamed from running agents and origins as they arrive. Only
+  // the torrent of the first event is drawn.
+  var graph = null;
+  var dirty = false;
+  var source = new EventSource('/events/stream?' + filter.toString());
+  source.onmessage = msg => {
+    var event = JSON.parse(msg.data);
+    if (graph == null) {
+      graph = new Graph(event.torrent, Date.parse(event.ts));
+    }
+    if (event.torrent != graph.torrent) {
       return;
     }
-    graph.setTime(until);
-    while (i < events.length && Date.parse(events[i].ts) < until) {
-      applyEvent(events[i]);
-      i++;
+    graph.setTime(Math.max(graph.curTime, Date.parse(event.ts)));
+    applyEvent(graph, event);
+    dirty = true;
+  };
+  setInterval(() => {
+    if (graph != null && dirty) {
+      graph.update();
+      dirty = false;
+    }
+  }, interval);
+} else {
+  d3.request('http://' + location.host + '/events?' + filter.toString()).get(req => {
+    var events = JSON.parse(req.response);
+    var graph = new Graph(events[0].torrent, Date.parse(events[0].ts));
+
+    // Read all events within each interval and apply them to the graph. This
+    // gives the illusion of events occuring in real-time.
+    function readEvents(i, until) {
+      if (i >= events.length) {
+        return;
+      }
+      graph.setTime(until);
+      while (i < events.length && Date.parse(events[i].ts) < until) {
+        applyEvent(graph, events[i]);
+        i++;
+      }
+      graph.update();
+      setTimeout(() => readEvents(i, until + interval), interval);
     }
-    graph.update();
-    setTimeout(() => readEvents(i, until + interval), interval);
-  }
 
-  readEvents(0, Date.parse(events[0].ts) + interval);
-});
+    readEvents(0, Date.parse(events[0].ts) + interval);
+  });
+}
@@ -14,28 +14,66 @@
 package announceclient
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/lib/peertoken"
 	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
 )
 
 // ErrDisabled is returned when announce is disabled.
 var ErrDisabled = errors.New("announcing disabled")
 
+// ErrBatchUnsupported is returned when the tracker does not support batch
+// announces.
+var ErrBatchUnsupported = errors.New("batch announces not supported by tracker")
+
+// ErrStreamsUnsupported is returned when the tracker does not support peer
+// streams.
+var ErrStreamsUnsupported = errors.New("peer streams not supported by tracker")
+
+// ErrBundlesUnsupported is returned when the tracker does not support bundle
+// announces.
+var ErrBundlesUnsupported = errors.New("tracker does not support bundles")
+
+// ErrRateLimited is returned when the tracker rate limited the announce. The
+// tracker is not announced to again until its requested Retry-After delay has
+// elapsed, which is returned as the announce interval.
+var ErrRateLimited = errors.New("announce rate limited by tracker")
+
+// _defaultRetryAfter is the delay honoured when a rate limited response has no
+// Retry-After header.
+const _defaultRetryAfter = 5 * time.Second
+
 // Request defines an announce request.
 type Request struct {
 	Name     string         `json:"name"`
 	Digest   *core.Digest   `json:"digest"` // Optional (for now).
 	InfoHash core.InfoHash  `json:"info_hash"`
 	Peer     *core.PeerInfo `json:"peer"`
+
+	// Reputations are scores the announcing peer has assigned to peers it
+	// downloaded from. Optional.
+	Reputations []core.PeerReputation `json:"reputations,omitempty"`
+
+	// Token proves the announcing peer is enrolled in the cluster. Optional.
+	Token string `json:"token,omitempty"`
+
+	// Load is the current upload load of the announcing peer. Optional.
+	Load *core.PeerLoad `json:"load,omitempty"`
 }
 
 // GetDigest is a backwards compatible accessor of the request digest.
@@ -56,24 +94,147 @@ type Response struct {
 	Interval time.Duration    `json:"interval"`
 }
 
+// AnnounceTorrent is a torrent announced as part of a bundle or batch.
+type AnnounceTorrent struct {
+	Digest   core.Digest   `json:"digest"`
+	InfoHash core.InfoHash `json:"info_hash"`
+	Complete bool          `json:"complete"`
+}
+
+// BundleRequest defines a bundle announce request, which announces all torrents
+// of a bundle, e.g. the layers of an image, at once.
+type BundleRequest struct {
+	Manifest core.Digest       `json:"manifest"`
+	Peer     *core.PeerInfo    `json:"peer"`
+	Torrents []AnnounceTorrent `json:"torrents"`
+
+	// Reputations are scores the announcing peer has assigned to peers it
+	// downloaded from. Optional.
+	Reputations []core.PeerReputation `json:"reputations,omitempty"`
+
+	// Token proves the announcing peer is enrolled in the cluster. Optional.
+	Token string `json:"token,omitempty"`
+
+	// Load is the current upload load of the announcing peer. Optional.
+	Load *core.PeerLoad `json:"load,omitempty"`
+}
+
+// TorrentPeers is the peer handout for a single torrent of a bundle or batch.
+type TorrentPeers struct {
+	InfoHash core.InfoHash    `json:"info_hash"`
+	Peers    []*core.PeerInfo `json:"peers"`
+}
+
+// BundleResponse defines a bundle announce response.
+type BundleResponse struct {
+	Torrents []TorrentPeers `json:"torrents"`
+	Interval time.Duration  `json:"interval"`
+}
+
+// BatchRequest defines a batch announce request, which announces many unrelated
+// torrents of a single peer at once.
+type BatchRequest struct {
+	Peer     *core.PeerInfo    `json:"peer"`
+	Torrents []AnnounceTorrent `json:"torrents"`
+
+	// Reputations are scores the announcing peer has assigned to peers it
+	// downloaded from. Optional.
+	Reputations []core.PeerReputation `json:"reputations,omitempty"`
+
+	// Token proves the announcing peer is enrolled in the cluster. Optional.
+	Token string `json:"token,omitempty"`
+
+	// Load is the current upload load of the announcing peer. Optional.
+	Load *core.PeerLoad `json:"load,omitempty"`
+}
+
+// StreamRequest defines a peer stream request, which streams seeders of many
+// torrents to a single peer over one connection.
+type StreamRequest struct {
+	PeerID   core.PeerID       `json:"peer_id"`
+	Torrents []AnnounceTorrent `json:"torrents"`
+
+	// Token proves the streaming peer is enrolled in the cluster. Optional.
+	Token string `json:"token,omitempty"`
+}
+
+// StreamedPeer is a seeder of a torrent pushed over a peer stream.
+type StreamedPeer struct {
+	InfoHash core.InfoHash  `json:"info_hash"`
+	Peer     *core.PeerInfo `json:"peer"`
+}
+
+// BatchResponse defines a batch announce response.
+type BatchResponse struct {
+	Torrents []TorrentPeers `json:"torrents"`
+	Interval time.Duration  `json:"interval"`
+}
+
 // Client defines a client for announcing and getting peers.
 type Client interface {
 	Announce(
 		d core.Digest,
 		h core.InfoHash,
 		complete bool,
-		version int) ([]*core.PeerInfo, time.Duration, error)
+		version int,
+		reputations ...core.PeerReputation) ([]*core.PeerInfo, time.Duration, error)
+
+	AnnounceBundle(
+		manifest core.Digest,
+		torrents []AnnounceTorrent,
+		reputations ...core.PeerReputation) (map[core.InfoHash][]*core.PeerInfo, time.Duration, error)
+
+	AnnounceBatch(
+		torrents []AnnounceTorrent,
+		reputations ...core.PeerReputation) (map[core.InfoHash][]*core.PeerInfo, time.Duration, error)
+
+	StreamPeers(ctx context.Context, torrents []AnnounceTorrent) (<-chan *StreamedPeer, error)
+}
+
+// LoadReporter reports the current upload load of the local peer.
+type LoadReporter interface {
+	Load() *core.PeerLoad
 }
 
 type client struct {
-	pctx core.PeerContext
-	ring hashring.PassiveRing
-	tls  *tls.Config
+	pctx   core.PeerContext
+	ring   hashring.PassiveRing
+	tls    *tls.Config
+	tokens *peertoken.Issuer
+	load   LoadReporter
+
+	mu           sync.Mutex
+	backoffUntil map[string]time.Time // Tracker addr -> end of rate limit.
+}
+
+// Option configures a client.
+type Option func(*client)
+
+// WithTokenIssuer attaches tokens issued by i to all announces.
+func WithTokenIssuer(i *peertoken.Issuer) Option {
+	return func(c *client) { c.tokens = i }
+}
+
+// WithLoadReporter attaches the load reported by r to all announces.
+func WithLoadReporter(r LoadReporter) Option {
+	return func(c *client) { c.load = r }
 }
 
 // New creates a new client.
-func New(pctx core.PeerContext, ring hashring.PassiveRing, tls *tls.Config) Client {
-	return &client{pctx, ring, tls}
+func New(
+	pctx core.PeerContext, ring hashring.PassiveRing, tls *tls.Config, options ...Option) Client {
+
+	c := &client{
+		pctx:         pctx,
+		ring:         ring,
+		tls:          tls,
+		tokens:       peertoken.DisabledIssuer(),
+		backoffUntil: make(map[string]time.Time),
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	return c
 }
 
 // Announce versionss.
@@ -90,19 +251,28 @@ func getEndpoint(version int, addr string, h core.InfoHash) (method, url string)
 }
 
 // Announce announces the torrent identified by (d, h) with the number of
-// downloaded bytes. Returns a list of all other peers announcing for said torrent,
-// sorted by priority, and the interval for the next announce.
+// downloaded bytes, along with any reputations of other peers. Returns a list
+// of all other peers announcing for said torrent, sorted by priority, and the
+// interval for the next announce.
 func (c *client) Announce(
 	d core.Digest,
 	h core.InfoHash,
 	complete bool,
-	version int) (peers []*core.PeerInfo, interval time.Duration, err error) {
+	version int,
+	reputations ...core.PeerReputation) (peers []*core.PeerInfo, interval time.Duration, err error) {
 
+	token, err := c.tokens.Token()
+	if err != nil {
+		return nil, 0, fmt.Errorf("token: %s", err)
+	}
 	body, err := json.Marshal(&Request{
-		Name:     d.Hex(), // For backwards compatability. TODO(codyg): Remove.
-		Digest:   &d,
-		InfoHash: h,
-		Peer:     core.PeerInfoFromContext(c.pctx, complete),
+		Name:        d.Hex(), // For backwards compatability. TODO(codyg): Remove.
+		Digest:      &d,
+		InfoHash:    h,
+		Peer:        core.PeerInfoFromContext(c.pctx, complete),
+		Reputations: reputations,
+		Token:       token,
+		Load:        c.getLoad(),
 	})
 	if err != nil {
 		return nil, 0, fmt.Errorf("marshal request: %s", err)
@@ -110,6 +280,9 @@ func (c *client) Announce(
 	var httpResp *http.Response
 	// 获取可用 tracker
 	for _, addr := range c.ring.Locations(d) {
+		if delay := c.backoff(addr); delay > 0 {
+			return nil, delay, ErrRateLimited
+		}
 		method, url := getEndpoint(version, addr, h)
 		httpResp, err = httputil.Send(
 			method,
@@ -122,6 +295,9 @@ func (c *client) Announce(
 				c.ring.Failed(addr)
 				continue
 			}
+			if httputil.IsTooManyRequests(err) {
+				return nil, c.rateLimited(addr, err), ErrRateLimited
+			}
 			return nil, 0, err
 		}
 		defer httpResp.Body.Close()
@@ -134,6 +310,326 @@ func (c *client) Announce(
 	return nil, 0, err
 }
 
+// AnnounceBundle announces all torrents of the bundle identified by manifest,
+// along with any reputations of other peers. Returns the peer handout of each
+// incomplete torrent, and the interval for the next announce.
+//
+// Torrents are grouped by the tracker which owns them, such that the bundle is
+// announced once to each tracker. Returns ErrBundlesUnsupported if any tracker
+// does not support bundle announces.
+func (c *client) AnnounceBundle(
+	manifest core.Digest,
+	torrents []AnnounceTorrent,
+	reputations ...core.PeerReputation) (map[core.InfoHash][]*core.PeerInfo, time.Duration, error) {
+
+	return c.announceGrouped(torrents, func(addr string, group []AnnounceTorrent, token string) (
+		[]TorrentPeers, time.Duration, error) {
+
+		var resp BundleResponse
+		err := c.post(addr, "bundles/announce", &BundleRequest{
+			Manifest:    manifest,
+			Peer:        core.PeerInfoFromContext(c.pctx, false),
+			Torrents:    group,
+			Reputations: reputations,
+			Token:       token,
+			Load:        c.getLoad(),
+		}, &resp, ErrBundlesUnsupported)
+		return resp.Torrents, resp.Interval, err
+	})
+}
+
+// AnnounceBatch announces many torrents at once, along with any reputations of
+// other peers. Returns the peer handout of each incomplete torrent, and the
+// interval for the next announce.
+//
+// Like AnnounceBundle, torrents are grouped by the tracker which owns them.
+// Returns ErrBatchUnsupported if any tracker does not support batch announces,
+// in which case callers should fall back to Announce.
+func (c *client) AnnounceBatch(
+	torrents []AnnounceTorrent,
+	reputations ...core.PeerReputation) (map[core.InfoHash][]*core.PeerInfo, time.Duration, error) {
+
+	return c.announceGrouped(torrents, func(addr string, group []AnnounceTorrent, token string) (
+		[]TorrentPeers, time.Duration, error) {
+
+		var resp BatchResponse
+		err := c.post(addr, "batch/announce", &BatchRequest{
+			Peer:        core.PeerInfoFromContext(c.pctx, false),
+			Torrents:    group,
+			Reputations: reputations,
+			Token:       token,
+			Load:        c.getLoad(),
+		}, &resp, ErrBatchUnsupported)
+		return resp.Torrents, resp.Interval, err
+	})
+}
+
+// announceFunc sends a single announce of torrents, all owned by addr, and
+// returns their peer handouts and the interval for the next announce.
+type announceFunc func(
+	addr string, torrents []AnnounceTorrent, token string) ([]TorrentPeers, time.Duration, error)
+
+// announceGrouped announces torrents through announce once per tracker which
+// owns them. Torrents of a tracker which cannot be reached are retried on the
+// next location of their digest, and torrents of a tracker which is rate
+// limiting c are skipped. Returns the handouts of all torrents which were
+// announced, and the longest interval of any tracker.
+//
+// An error is only returned if no torrent could be announced, or if a tracker
+// does not support the announce, such that callers can fall back for all
+// torrents at once.
+func (c *client) announceGrouped(
+	torrents []AnnounceTorrent,
+	announce announceFunc) (map[core.InfoHash][]*core.PeerInfo, time.Duration, error) {
+
+	token, err := c.tokens.Token()
+	if err != nil {
+		return nil, 0, fmt.Errorf("token: %s", err)
+	}
+	peers := make(map[core.InfoHash][]*core.PeerInfo)
+	var interval, delay time.Duration
+	var lastErr error
+	failed := make(map[string]bool)
+	for pending := torrents; len(pending) > 0; {
+		addrs, groups := c.groupByTracker(pending, failed)
+		if len(addrs) == 0 && lastErr == nil {
+			lastErr = errors.New("no trackers available")
+		}
+		pending = nil
+		for _, addr := range addrs {
+			if d := c.backoff(addr); d > 0 {
+				delay, lastErr = maxDuration(delay, d), ErrRateLimited
+				continue
+			}
+			handouts, i, err := announce(addr, groups[addr], token)
+			if err != nil {
+				if err == ErrBatchUnsupported || err == ErrBundlesUnsupported {
+					return nil, 0, err
+				}
+				if httputil.IsTooManyRequests(err) {
+					delay, lastErr = maxDuration(delay, c.rateLimited(addr, err)), ErrRateLimited
+					continue
+				}
+				if httputil.IsNetworkError(err) {
+					c.ring.Failed(addr)
+					failed[addr] = true
+					pending = append(pending, groups[addr]...)
+				}
+				lastErr = err
+				continue
+			}
+			for _, t := range handouts {
+				peers[t.InfoHash] = t.Peers
+			}
+			interval = maxDuration(interval, i)
+		}
+	}
+	if len(peers) == 0 && lastErr != nil {
+		if lastErr == ErrRateLimited {
+			return nil, delay, lastErr
+		}
+		return nil, 0, lastErr
+	}
+	return peers, interval, nil
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// backoff returns the remaining delay before addr may be announced to again,
+// or zero if addr is not rate limiting c.
+func (c *client) backoff(addr string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	until, ok := c.backoffUntil[addr]
+	if !ok {
+		return 0
+	}
+	delay := time.Until(until)
+	if delay <= 0 {
+		delete(c.backoffUntil, addr)
+		return 0
+	}
+	return delay
+}
+
+// rateLimited records that addr rejected an announce with the rate limited
+// error err, and returns the delay addr requested.
+func (c *client) rateLimited(addr string, err error) time.Duration {
+	delay, ok := httputil.RetryAfter(err)
+	if !ok || delay <= 0 {
+		delay = _defaultRetryAfter
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.backoffUntil[addr] = time.Now().Add(delay)
+	return delay
+}
+
+// getLoad returns the load to attach to announces, if any.
+func (c *client) getLoad() *core.PeerLoad {
+	if c.load == nil {
+		return nil
+	}
+	return c.load.Load()
+}
+
+// groupByTracker groups torrents by the first tracker which owns them and has
+// not failed. Returns the tracker addresses in order of first appearance.
+// Torrents whose trackers have all failed are dropped.
+func (c *client) groupByTracker(
+	torrents []AnnounceTorrent, failed map[string]bool) ([]string, map[string][]AnnounceTorrent) {
+
+	groups := make(map[string][]AnnounceTorrent)
+	var addrs []string
+	for _, t := range torrents {
+		for _, addr := range c.ring.Locations(t.Digest) {
+			if failed[addr] {
+				continue
+			}
+			if _, ok := groups[addr]; !ok {
+				addrs = append(addrs, addr)
+			}
+			groups[addr] = append(groups[addr], t)
+			break
+		}
+	}
+	return addrs, groups
+}
+
+// StreamPeers opens a single stream to each tracker owning any of torrents,
+// over which the tracker pushes seeders of the torrents it owns as they
+// announce. Seeders are sent on the returned channel, which is closed once any
+// stream ends, either because ctx was cancelled or a connection was lost, such
+// that callers reopen all streams at once. Returns ErrStreamsUnsupported if a
+// tracker does not support peer streams, in which case callers should rely on
+// announces alone. Other errors may carry the Retry-After delay requested by an
+// overloaded tracker.
+func (c *client) StreamPeers(
+	ctx context.Context, torrents []AnnounceTorrent) (<-chan *StreamedPeer, error) {
+
+	token, err := c.tokens.Token()
+	if err != nil {
+		return nil, fmt.Errorf("token: %s", err)
+	}
+	addrs, groups := c.groupByTracker(torrents, nil)
+	if len(addrs) == 0 {
+		return nil, errors.New("no trackers available")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	peers := make(chan *StreamedPeer)
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		body, err := c.openStream(ctx, addr, &StreamRequest{
+			PeerID:   c.pctx.PeerID,
+			Torrents: groups[addr],
+			Token:    token,
+		})
+		if err != nil {
+			cancel()
+			wg.Wait()
+			return nil, err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+			readPeerEvents(ctx, body, peers)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		close(peers)
+	}()
+	return peers, nil
+}
+
+func (c *client) openStream(
+	ctx context.Context, addr string, req *StreamRequest) (io.ReadCloser, error) {
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("json marshal request: %s", err)
+	}
+	httpResp, err := httputil.Post(
+		fmt.Sprintf("http://%s/peers/stream", addr),
+		httputil.SendBody(bytes.NewReader(body)),
+		httputil.SendContext(ctx),
+		httputil.SendTimeout(0), // Streams are open until ctx is cancelled.
+		httputil.SendTLS(c.tls))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if httputil.IsNotFound(err) || httputil.IsStatus(err, http.StatusMethodNotAllowed) {
+			return nil, ErrStreamsUnsupported
+		}
+		if httputil.IsNetworkError(err) {
+			c.ring.Failed(addr)
+		}
+		return nil, err
+	}
+	return httpResp.Body, nil
+}
+
+// readPeerEvents decodes the server-sent events of a peer stream from body onto
+// peers, until body is exhausted or ctx is cancelled.
+func readPeerEvents(ctx context.Context, body io.ReadCloser, peers chan<- *StreamedPeer) {
+	defer body.Close()
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			// Keep-alive comments and event delimiters.
+			continue
+		}
+		p := new(StreamedPeer)
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), p); err != nil {
+			log.Errorf("Error decoding peer stream event: %s", err)
+			continue
+		}
+		select {
+		case peers <- p:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// post sends req as JSON to the endpoint at path of addr, and decodes the
+// response into resp. Returns unsupported if addr does not serve path.
+func (c *client) post(addr, path string, req, resp interface{}, unsupported error) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal request: %s", err)
+	}
+	httpResp, err := httputil.Post(
+		fmt.Sprintf("http://%s/%s", addr, path),
+		httputil.SendBody(bytes.NewReader(body)),
+		httputil.SendTimeout(10*time.Second),
+		httputil.SendTLS(c.tls))
+	if err != nil {
+		if httputil.IsNotFound(err) || httputil.IsStatus(err, http.StatusMethodNotAllowed) {
+			return unsupported
+		}
+		return err
+	}
+	defer httpResp.Body.Close()
+	if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+		return fmt.Errorf("decode response: %s", err)
+	}
+	return nil
+}
+
 // DisabledClient rejects all announces. Suitable for origin peers which should
 // not be announcing.
 type DisabledClient struct{}
@@ -145,7 +641,35 @@ func Disabled() Client {
 
 // Announce always returns error.
 func (c DisabledClient) Announce(
-	d core.Digest, h core.InfoHash, complete bool, version int) ([]*core.PeerInfo, time.Duration, error) {
+	d core.Digest,
+	h core.InfoHash,
+	complete bool,
+	version int,
+	reputations ...core.PeerReputation) ([]*core.PeerInfo, time.Duration, error) {
 
 	return nil, 0, ErrDisabled
 }
+
+// AnnounceBundle always returns error.
+func (c DisabledClient) AnnounceBundle(
+	manifest core.Digest,
+	torrents []AnnounceTorrent,
+	reputations ...core.PeerReputation) (map[core.InfoHash][]*core.PeerInfo, time.Duration, error) {
+
+	return nil, 0, ErrDisabled
+}
+
+// AnnounceBatch always returns error.
+func (c DisabledClient) AnnounceBatch(
+	torrents []AnnounceTorrent,
+	reputations ...core.PeerReputation) (map[core.InfoHash][]*core.PeerInfo, time.Duration, error) {
+
+	return nil, 0, ErrDisabled
+}
+
+// StreamPeers always returns error.
+func (c DisabledClient) StreamPeers(
+	ctx context.Context, torrents []AnnounceTorrent) (<-chan *StreamedPeer, error) {
+
+	return nil, ErrDisabled
+}
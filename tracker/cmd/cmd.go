@@ -17,10 +17,12 @@ import (
 	"flag"
 
 	"github.com/uber/kraken/lib/healthcheck"
+	"github.com/uber/kraken/lib/peertoken"
 	"github.com/uber/kraken/lib/upstream"
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
 	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/tracker/metainfocache"
 	"github.com/uber/kraken/tracker/originstore"
 	"github.com/uber/kraken/tracker/peerhandoutpolicy"
 	"github.com/uber/kraken/tracker/peerstore"
@@ -121,16 +123,16 @@ func Run(flags *Flags, opts ...Option) {
 
 	go metrics.EmitVersion(stats)
 
-	peerStore, err := peerstore.New(config.PeerStore)
+	tls, err := config.TLS.BuildClient()
 	if err != nil {
-		log.Fatalf("Could not create PeerStore: %s", err)
+		log.Fatalf("Error building client tls config: %s", err)
 	}
-	defer peerStore.Close()
 
-	tls, err := config.TLS.BuildClient()
+	peerStore, err := peerstore.New(config.PeerStore, stats, flags.Port, tls)
 	if err != nil {
-		log.Fatalf("Error building client tls config: %s", err)
+		log.Fatalf("Could not create PeerStore: %s", err)
 	}
+	defer peerStore.Close()
 
 	origins, err := config.Origin.Build(upstream.WithHealthCheck(healthcheck.Default(tls)))
 	if err != nil {
@@ -140,7 +142,17 @@ func Run(flags *Flags, opts ...Option) {
 	originStore := originstore.New(
 		config.OriginStore, clock.New(), origins, blobclient.NewProvider(blobclient.WithTLS(tls)))
 
-	policy, err := peerhandoutpolicy.NewPriorityPolicy(stats, config.PeerHandoutPolicy.Priority)
+	var policyOptions []peerhandoutpolicy.Option
+	if config.PeerHandoutPolicy.Reputation.Enable {
+		policyOptions = append(policyOptions, peerhandoutpolicy.WithReputations(
+			peerhandoutpolicy.NewReputations(config.PeerHandoutPolicy.Reputation, clock.New())))
+	}
+	if config.PeerHandoutPolicy.Priority == "load" {
+		policyOptions = append(policyOptions, peerhandoutpolicy.WithLoads(
+			peerhandoutpolicy.NewLoads(config.PeerHandoutPolicy.Load, clock.New())))
+	}
+	policy, err := peerhandoutpolicy.NewPriorityPolicy(
+		stats, config.PeerHandoutPolicy.Priority, policyOptions...)
 	if err != nil {
 		log.Fatalf("Could not load peer handout policy: %s", err)
 	}
@@ -148,8 +160,30 @@ func Run(flags *Flags, opts ...Option) {
 	r := blobclient.NewClientResolver(blobclient.NewProvider(blobclient.WithTLS(tls)), origins)
 	originCluster := blobclient.NewClusterClient(r)
 
+	tokenVerifier, err := peertoken.NewVerifier(config.PeerToken, clock.New(), stats)
+	if err != nil {
+		log.Fatalf("Error creating peer token verifier: %s", err)
+	}
+
+	serverOptions := []trackerserver.Option{trackerserver.WithTokenVerifier(tokenVerifier)}
+	if config.PeerToken.Enable && config.PeerToken.SigningKey.ID != "" {
+		signer, err := peertoken.NewSigner(config.PeerToken, clock.New())
+		if err != nil {
+			log.Fatalf("Error creating peer token signer: %s", err)
+		}
+		serverOptions = append(serverOptions, trackerserver.WithTokenSigner(signer))
+	}
+	if config.MetaInfoCache.Enable {
+		metaInfoCache, err := metainfocache.New(config.MetaInfoCache, stats, clock.New())
+		if err != nil {
+			log.Fatalf("Error creating metainfo cache: %s", err)
+		}
+		serverOptions = append(serverOptions, trackerserver.WithMetaInfoCache(metaInfoCache))
+	}
+
 	server := trackerserver.New(
-		config.TrackerServer, stats, policy, peerStore, originStore, originCluster)
+		config.TrackerServer, stats, policy, peerStore, originStore, originCluster,
+		serverOptions...)
 	go func() {
 		log.Fatal(server.ListenAndServe())
 	}()
@@ -16,9 +16,11 @@ package cmd
 import (
 	"go.uber.org/zap"
 
+	"github.com/uber/kraken/lib/peertoken"
 	"github.com/uber/kraken/lib/upstream"
 	"github.com/uber/kraken/metrics"
 	"github.com/uber/kraken/nginx"
+	"github.com/uber/kraken/tracker/metainfocache"
 	"github.com/uber/kraken/tracker/originstore"
 	"github.com/uber/kraken/tracker/peerhandoutpolicy"
 	"github.com/uber/kraken/tracker/peerstore"
@@ -37,4 +39,6 @@ type Config struct {
 	Metrics           metrics.Config           `yaml:"metrics"`
 	Nginx             nginx.Config             `yaml:"nginx"`
 	TLS               httputil.TLSConfig       `yaml:"tls"`
+	PeerToken         peertoken.Config         `yaml:"peer_token"`
+	MetaInfoCache     metainfocache.Config     `yaml:"metainfocache"`
 }
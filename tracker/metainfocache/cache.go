@@ -0,0 +1,277 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metainfocache
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/log"
+)
+
+// ErrNotFound is returned when metainfo is not cached.
+var ErrNotFound = errors.New("metainfo not cached")
+
+// _tmpPrefix prefixes files which are still being written.
+const _tmpPrefix = ".tmp-"
+
+type entry struct {
+	digest      core.Digest
+	size        uint64
+	validatedAt time.Time
+
+	// checkedAt is when the version was last checked, even if unsuccessfully.
+	checkedAt time.Time
+}
+
+// Cache is a size-bounded, on-disk cache of metainfo keyed by digest. Metainfo
+// is evicted in least-recently-used order. Since metainfo is immutable unless
+// overwritten on origins, cached metainfo is only periodically revalidated
+// against the origin metainfo version. Each file's modification time records
+// when it was last validated, such that a restarted tracker does not need to
+// revalidate its entire cache at once. Cache is thread-safe.
+type Cache struct {
+	config Config
+	stats  tally.Scope
+	clk    clock.Clock
+
+	mu      sync.Mutex // Protects the following fields:
+	entries map[core.Digest]*list.Element
+	lru     *list.List // Front is most recently used.
+	size    uint64
+}
+
+// New creates a new Cache, loading any metainfo already stored in the
+// configured directory.
+func New(config Config, stats tally.Scope, clk clock.Clock) (*Cache, error) {
+	config = config.applyDefaults()
+
+	stats = stats.Tagged(map[string]string{
+		"module": "metainfocache",
+	})
+
+	if err := os.MkdirAll(config.Dir, 0775); err != nil {
+		return nil, fmt.Errorf("mkdir: %s", err)
+	}
+	c := &Cache{
+		config:  config,
+		stats:   stats,
+		clk:     clk,
+		entries: make(map[core.Digest]*list.Element),
+		lru:     list.New(),
+	}
+	if err := c.load(); err != nil {
+		return nil, fmt.Errorf("load: %s", err)
+	}
+	return c, nil
+}
+
+// load indexes all metainfo files in the cache directory, treating the most
+// recently validated as the most recently used.
+func (c *Cache) load() error {
+	infos, err := ioutil.ReadDir(c.config.Dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(info.Name(), _tmpPrefix) {
+			// Leftover from an interrupted Put.
+			os.Remove(filepath.Join(c.config.Dir, info.Name()))
+			continue
+		}
+		d, err := core.NewSHA256DigestFromHex(info.Name())
+		if err != nil {
+			log.With("name", info.Name()).Warn("Skipping unknown file in metainfo cache")
+			continue
+		}
+		c.entries[d] = c.lru.PushFront(
+			&entry{d, uint64(info.Size()), info.ModTime(), info.ModTime()})
+		c.size += uint64(info.Size())
+	}
+	c.evict()
+	c.stats.Gauge("size").Update(float64(c.size))
+	return nil
+}
+
+// Get returns the cached metainfo of d. Stale is true if the metainfo version
+// has not been checked within the revalidate interval, in which case callers
+// should check the metainfo version on origins and call either Validated,
+// Unvalidated or Delete.
+// Returns ErrNotFound if d is not cached.
+func (c *Cache) Get(d core.Digest) (mi *core.MetaInfo, stale bool, err error) {
+	c.mu.Lock()
+	el, ok := c.entries[d]
+	if !ok {
+		c.mu.Unlock()
+		c.stats.Counter("misses").Inc(1)
+		return nil, false, ErrNotFound
+	}
+	c.lru.MoveToFront(el)
+	stale = c.clk.Now().Sub(el.Value.(*entry).checkedAt) >= c.config.RevalidateInterval
+	c.mu.Unlock()
+
+	b, err := ioutil.ReadFile(c.path(d))
+	if err == nil {
+		mi, err = core.DeserializeMetaInfo(b)
+	}
+	if err != nil {
+		log.With("digest", d).Errorf("Error reading cached metainfo: %s", err)
+		c.Delete(d)
+		c.stats.Counter("misses").Inc(1)
+		return nil, false, ErrNotFound
+	}
+	c.stats.Counter("hits").Inc(1)
+	return mi, stale, nil
+}
+
+// Put caches mi, replacing any metainfo cached under the same digest.
+// Metainfo larger than the max size is not cached.
+func (c *Cache) Put(mi *core.MetaInfo) error {
+	b, err := mi.Serialize()
+	if err != nil {
+		return fmt.Errorf("serialize: %s", err)
+	}
+	size := uint64(len(b))
+	if size > c.config.MaxSize {
+		return nil
+	}
+	f, err := ioutil.TempFile(c.config.Dir, _tmpPrefix)
+	if err != nil {
+		return fmt.Errorf("create tmp file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("write tmp file: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close tmp file: %s", err)
+	}
+	now := c.clk.Now()
+	if err := os.Chtimes(f.Name(), now, now); err != nil {
+		return fmt.Errorf("chtimes: %s", err)
+	}
+
+	d := mi.Digest()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Rename(f.Name(), c.path(d)); err != nil {
+		return fmt.Errorf("rename: %s", err)
+	}
+	if el, ok := c.entries[d]; ok {
+		c.size -= el.Value.(*entry).size
+		c.lru.Remove(el)
+	}
+	c.entries[d] = c.lru.PushFront(&entry{d, size, now, now})
+	c.size += size
+	c.evict()
+	c.stats.Gauge("size").Update(float64(c.size))
+	return nil
+}
+
+// Validated records that the cached metainfo of d was found to be up to date.
+func (c *Cache) Validated(d core.Digest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[d]
+	if !ok {
+		return
+	}
+	now := c.clk.Now()
+	e := el.Value.(*entry)
+	e.validatedAt = now
+	e.checkedAt = now
+	if err := os.Chtimes(c.path(d), now, now); err != nil {
+		log.With("digest", d).Errorf("Error touching cached metainfo: %s", err)
+	}
+}
+
+// Unvalidated records that the version of the cached metainfo of d could not
+// be checked. The metainfo is served for another revalidate interval, unless
+// it was last validated over the max staleness ago, in which case it is
+// deleted. Returns whether the metainfo is still cached.
+func (c *Cache) Unvalidated(d core.Digest) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[d]
+	if !ok {
+		return false
+	}
+	now := c.clk.Now()
+	e := el.Value.(*entry)
+	if now.Sub(e.validatedAt) >= c.config.MaxStaleness {
+		c.remove(el)
+		c.stats.Counter("expirations").Inc(1)
+		c.stats.Gauge("size").Update(float64(c.size))
+		return false
+	}
+	e.checkedAt = now
+	return true
+}
+
+// Delete removes the cached metainfo of d, if any.
+func (c *Cache) Delete(d core.Digest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[d]
+	if !ok {
+		return
+	}
+	c.remove(el)
+	c.stats.Gauge("size").Update(float64(c.size))
+}
+
+// evict removes the least recently used metainfo until the max size is met.
+func (c *Cache) evict() {
+	for c.size > c.config.MaxSize {
+		c.remove(c.lru.Back())
+		c.stats.Counter("evictions").Inc(1)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.digest)
+	c.size -= e.size
+	if err := os.Remove(c.path(e.digest)); err != nil && !os.IsNotExist(err) {
+		log.With("digest", e.digest).Errorf("Error removing cached metainfo: %s", err)
+	}
+}
+
+func (c *Cache) path(d core.Digest) string {
+	return filepath.Join(c.config.Dir, d.Hex())
+}
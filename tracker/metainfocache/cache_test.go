@@ -0,0 +1,197 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metainfocache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+
+	"github.com/uber/kraken/core"
+)
+
+func newTestCache(t *testing.T, config Config, clk clock.Clock) (*Cache, func()) {
+	dir, err := ioutil.TempDir("", "metainfocache")
+	require.NoError(t, err)
+	config.Dir = dir
+	c, err := New(config, tally.NoopScope, clk)
+	require.NoError(t, err)
+	return c, func() { os.RemoveAll(dir) }
+}
+
+func metaInfoSize(t *testing.T, mi *core.MetaInfo) uint64 {
+	b, err := mi.Serialize()
+	require.NoError(t, err)
+	return uint64(len(b))
+}
+
+func TestCachePutGet(t *testing.T) {
+	require := require.New(t)
+
+	c, cleanup := newTestCache(t, Config{}, clock.NewMock())
+	defer cleanup()
+
+	mi := core.MetaInfoFixture()
+
+	_, _, err := c.Get(mi.Digest())
+	require.Equal(ErrNotFound, err)
+
+	require.NoError(c.Put(mi))
+
+	result, stale, err := c.Get(mi.Digest())
+	require.NoError(err)
+	require.False(stale)
+	require.Equal(mi, result)
+}
+
+func TestCacheStaleUntilValidated(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	c, cleanup := newTestCache(t, Config{RevalidateInterval: time.Minute}, clk)
+	defer cleanup()
+
+	mi := core.MetaInfoFixture()
+	require.NoError(c.Put(mi))
+
+	clk.Add(time.Minute)
+	_, stale, err := c.Get(mi.Digest())
+	require.NoError(err)
+	require.True(stale)
+
+	c.Validated(mi.Digest())
+	_, stale, err = c.Get(mi.Digest())
+	require.NoError(err)
+	require.False(stale)
+}
+
+func TestCacheUnvalidatedUntilMaxStaleness(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	c, cleanup := newTestCache(t, Config{
+		RevalidateInterval: time.Minute,
+		MaxStaleness:       3 * time.Minute,
+	}, clk)
+	defer cleanup()
+
+	mi := core.MetaInfoFixture()
+	require.NoError(c.Put(mi))
+
+	clk.Add(time.Minute)
+	_, stale, err := c.Get(mi.Digest())
+	require.NoError(err)
+	require.True(stale)
+
+	// A failed check defers the next check by the revalidate interval.
+	require.True(c.Unvalidated(mi.Digest()))
+	_, stale, err = c.Get(mi.Digest())
+	require.NoError(err)
+	require.False(stale)
+
+	clk.Add(time.Minute)
+	require.True(c.Unvalidated(mi.Digest()))
+
+	// Metainfo which cannot be validated for the max staleness is deleted.
+	clk.Add(time.Minute)
+	require.False(c.Unvalidated(mi.Digest()))
+	_, _, err = c.Get(mi.Digest())
+	require.Equal(ErrNotFound, err)
+}
+
+func TestCacheDelete(t *testing.T) {
+	require := require.New(t)
+
+	c, cleanup := newTestCache(t, Config{}, clock.NewMock())
+	defer cleanup()
+
+	mi := core.MetaInfoFixture()
+	require.NoError(c.Put(mi))
+
+	c.Delete(mi.Digest())
+
+	_, _, err := c.Get(mi.Digest())
+	require.Equal(ErrNotFound, err)
+	_, err = os.Stat(c.path(mi.Digest()))
+	require.True(os.IsNotExist(err))
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	require := require.New(t)
+
+	mi1 := core.MetaInfoFixture()
+	mi2 := core.MetaInfoFixture()
+	mi3 := core.MetaInfoFixture()
+	maxSize := metaInfoSize(t, mi1) + metaInfoSize(t, mi2) + metaInfoSize(t, mi3) - 1
+
+	c, cleanup := newTestCache(t, Config{MaxSize: maxSize}, clock.NewMock())
+	defer cleanup()
+
+	require.NoError(c.Put(mi1))
+	require.NoError(c.Put(mi2))
+
+	// Touch mi1 such that mi2 is the least recently used.
+	_, _, err := c.Get(mi1.Digest())
+	require.NoError(err)
+
+	require.NoError(c.Put(mi3))
+
+	_, _, err = c.Get(mi2.Digest())
+	require.Equal(ErrNotFound, err)
+	for _, mi := range []*core.MetaInfo{mi1, mi3} {
+		_, _, err := c.Get(mi.Digest())
+		require.NoError(err)
+	}
+}
+
+func TestCacheSurvivesRestart(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	c, cleanup := newTestCache(t, Config{RevalidateInterval: time.Minute}, clk)
+	defer cleanup()
+
+	mi := core.MetaInfoFixture()
+	require.NoError(c.Put(mi))
+
+	// Leftover of an interrupted Put.
+	tmp := filepath.Join(c.config.Dir, _tmpPrefix+"foo")
+	require.NoError(ioutil.WriteFile(tmp, []byte("foo"), 0644))
+
+	restarted, err := New(c.config, tally.NoopScope, clk)
+	require.NoError(err)
+
+	result, stale, err := restarted.Get(mi.Digest())
+	require.NoError(err)
+	require.False(stale)
+	require.Equal(mi, result)
+
+	// Validation times are kept across restarts.
+	clk.Add(time.Minute)
+	restarted, err = New(c.config, tally.NoopScope, clk)
+	require.NoError(err)
+
+	_, stale, err = restarted.Get(mi.Digest())
+	require.NoError(err)
+	require.True(stale)
+
+	_, err = os.Stat(tmp)
+	require.True(os.IsNotExist(err))
+}
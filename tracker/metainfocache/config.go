@@ -0,0 +1,58 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package metainfocache
+
+import (
+	"time"
+
+	"github.com/uber/kraken/utils/memsize"
+)
+
+// Config defines Cache configuration.
+type Config struct {
+
+	// Enable enables caching metainfo on disk. If disabled, all metainfo
+	// requests are forwarded to origins.
+	Enable bool `yaml:"enable"`
+
+	// Dir is the directory cached metainfo is stored in. Survives restarts.
+	Dir string `yaml:"dir"`
+
+	// MaxSize is the maximum total number of bytes of metainfo stored on disk.
+	MaxSize uint64 `yaml:"max_size"`
+
+	// RevalidateInterval is the duration cached metainfo is served before its
+	// version is checked against origins.
+	RevalidateInterval time.Duration `yaml:"revalidate_interval"`
+
+	// MaxStaleness is the maximum duration cached metainfo is served since it
+	// was last validated, while its version cannot be checked against origins.
+	MaxStaleness time.Duration `yaml:"max_staleness"`
+}
+
+func (c Config) applyDefaults() Config {
+	if c.Dir == "" {
+		c.Dir = "/var/cache/kraken/kraken-tracker/metainfo/"
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = memsize.GB
+	}
+	if c.RevalidateInterval == 0 {
+		c.RevalidateInterval = 10 * time.Minute
+	}
+	if c.MaxStaleness == 0 {
+		c.MaxStaleness = time.Hour
+	}
+	return c
+}
@@ -49,26 +49,56 @@ func New(ring hashring.PassiveRing, tls *tls.Config) Client {
 	return &client{ring, tls}
 }
 
+// _maxRetryAfter is the longest delay requested by a rate limiting tracker which
+// is waited for. Longer delays fail the download instead of blocking the caller.
+const _maxRetryAfter = 10 * time.Second
+
+// newRateLimitBackOff returns the backoff between retries of rate limited
+// requests, which bounds the total time a download waits on rate limits.
+func newRateLimitBackOff() backoff.BackOff {
+	return &backoff.ExponentialBackOff{
+		InitialInterval:     time.Second,
+		RandomizationFactor: 0.5,
+		Multiplier:          2,
+		MaxInterval:         _maxRetryAfter,
+		MaxElapsedTime:      30 * time.Second,
+		Clock:               backoff.SystemClock,
+	}
+}
+
 // Download returns the MetaInfo associated with name. Returns ErrNotFound if
-// no torrent exists under name.
+// no torrent exists under name. Requests rejected by tracker rate limits are
+// retried with backoff, for a bounded total time.
 func (c *client) Download(namespace string, d core.Digest) (*core.MetaInfo, error) {
 	var resp *http.Response
 	var err error
+	rateLimits := newRateLimitBackOff()
+	rateLimits.Reset()
 	for _, addr := range c.ring.Locations(d) {
-		resp, err = httputil.PollAccepted(
-			fmt.Sprintf(
-				"http://%s/namespace/%s/blobs/%s/metainfo",
-				addr, url.PathEscape(namespace), d),
-			&backoff.ExponentialBackOff{
-				InitialInterval:     time.Second,
-				RandomizationFactor: 0.05,
-				Multiplier:          1.3,
-				MaxInterval:         5 * time.Second,
-				MaxElapsedTime:      15 * time.Minute,
-				Clock:               backoff.SystemClock,
-			},
-			httputil.SendTimeout(10*time.Second),
-			httputil.SendTLS(c.tls))
+		for {
+			resp, err = httputil.PollAccepted(
+				fmt.Sprintf(
+					"http://%s/namespace/%s/blobs/%s/metainfo",
+					addr, url.PathEscape(namespace), d),
+				&backoff.ExponentialBackOff{
+					InitialInterval:     time.Second,
+					RandomizationFactor: 0.05,
+					Multiplier:          1.3,
+					MaxInterval:         5 * time.Second,
+					MaxElapsedTime:      15 * time.Minute,
+					Clock:               backoff.SystemClock,
+				},
+				httputil.SendTimeout(10*time.Second),
+				httputil.SendTLS(c.tls))
+			if err == nil || !httputil.IsTooManyRequests(err) {
+				break
+			}
+			delay, ok := retryDelay(rateLimits, err)
+			if !ok {
+				break
+			}
+			time.Sleep(delay)
+		}
 		if err != nil {
 			if httputil.IsNetworkError(err) {
 				c.ring.Failed(addr)
@@ -92,3 +122,21 @@ func (c *client) Download(namespace string, d core.Digest) (*core.MetaInfo, erro
 	}
 	return nil, err
 }
+
+// retryDelay returns the delay before retrying a request rejected by a rate
+// limit with err, which is at least the delay requested by the tracker. Returns
+// false if the request should not be retried, since the backoff is exhausted or
+// the tracker requested a delay longer than _maxRetryAfter.
+func retryDelay(b backoff.BackOff, err error) (time.Duration, bool) {
+	delay := b.NextBackOff()
+	if delay == backoff.Stop {
+		return 0, false
+	}
+	if d, ok := httputil.RetryAfter(err); ok && d > delay {
+		if d > _maxRetryAfter {
+			return 0, false
+		}
+		delay = d
+	}
+	return delay, true
+}
@@ -16,4 +16,8 @@ package peerhandoutpolicy
 // Config defines configuration for the peer handout policy.
 type Config struct {
 	Priority string `yaml:"priority"`
+
+	Reputation ReputationConfig `yaml:"reputation"`
+
+	Load LoadConfig `yaml:"load"`
 }
@@ -0,0 +1,204 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerhandoutpolicy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+	"golang.org/x/time/rate"
+)
+
+// LoadConfig defines how upload loads reported by announcing peers are used to
+// balance leechers across seeders. Only used by the "load" priority policy.
+type LoadConfig struct {
+
+	// TTL is the duration a load is kept after it was last announced.
+	TTL time.Duration `yaml:"ttl"`
+
+	// UploadSlots is the number of concurrent uploads at which a peer is
+	// considered fully loaded, regardless of its egress utilization.
+	UploadSlots int `yaml:"upload_slots"`
+
+	// SaturationThreshold is the load at or above which peers are handed out
+	// after all other peers, including origins.
+	SaturationThreshold float64 `yaml:"saturation_threshold"`
+
+	// CountedHandouts is the number of leading peers of each handout which the
+	// leecher is expected to connect to. Each such peer is charged one upload
+	// until it next reports its load, such that bursts of announces between
+	// load reports are spread across seeders.
+	CountedHandouts int `yaml:"counted_handouts"`
+
+	// MaxOriginHandoutsPerSec limits the rate at which leechers are handed out
+	// origins, protecting origin NICs from massive rollouts. Leechers over the
+	// limit are only handed out other peers, unless no other complete seeders
+	// exist. Zero disables the limit.
+	MaxOriginHandoutsPerSec float64 `yaml:"max_origin_handouts_per_sec"`
+
+	// OriginHandoutBurst is the number of origin handouts allowed in a burst
+	// above MaxOriginHandoutsPerSec.
+	OriginHandoutBurst int `yaml:"origin_handout_burst"`
+
+	// MaxPeers is the maximum number of peer loads kept. Loads of new peers are
+	// dropped while full.
+	MaxPeers int `yaml:"max_peers"`
+}
+
+func (c LoadConfig) applyDefaults() LoadConfig {
+	if c.TTL == 0 {
+		c.TTL = time.Minute
+	}
+	if c.UploadSlots == 0 {
+		c.UploadSlots = 20
+	}
+	if c.SaturationThreshold == 0 {
+		c.SaturationThreshold = 0.95
+	}
+	if c.CountedHandouts == 0 {
+		c.CountedHandouts = 5
+	}
+	if c.OriginHandoutBurst == 0 {
+		c.OriginHandoutBurst = 50
+	}
+	if c.MaxPeers == 0 {
+		c.MaxPeers = 100000
+	}
+	return c
+}
+
+type loadReport struct {
+	load      core.PeerLoad
+	handouts  int
+	expiresAt time.Time
+}
+
+// Loads tracks the upload loads reported in announce requests, plus the
+// handouts of each peer since it last reported. Loads is thread-safe.
+type Loads struct {
+	config  LoadConfig
+	clk     clock.Clock
+	origins *rate.Limiter
+
+	mu    sync.Mutex
+	peers map[core.PeerID]*loadReport
+}
+
+// NewLoads creates a new Loads.
+func NewLoads(config LoadConfig, clk clock.Clock) *Loads {
+	config = config.applyDefaults()
+	var origins *rate.Limiter
+	if config.MaxOriginHandoutsPerSec > 0 {
+		origins = rate.NewLimiter(
+			rate.Limit(config.MaxOriginHandoutsPerSec), config.OriginHandoutBurst)
+	}
+	return &Loads{
+		config:  config,
+		clk:     clk,
+		origins: origins,
+		peers:   make(map[core.PeerID]*loadReport),
+	}
+}
+
+// Report records the load of peerID, resetting its handouts. No-op if load is
+// nil, e.g. for peers which do not report loads.
+func (l *Loads) Report(peerID core.PeerID, load *core.PeerLoad) {
+	if load == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clk.Now()
+	if _, ok := l.peers[peerID]; !ok && len(l.peers) >= l.config.MaxPeers {
+		l.cleanup(now)
+		if len(l.peers) >= l.config.MaxPeers {
+			return
+		}
+	}
+	l.peers[peerID] = &loadReport{
+		load:      *load,
+		expiresAt: now.Add(l.config.TTL),
+	}
+}
+
+// Score returns the load of peerID, where 0 is idle and 1 is fully loaded.
+// The load is the greater of the egress utilization and the fraction of upload
+// slots used, counting handouts since the last report as uploads. Returns false
+// if peerID has not reported its load.
+func (l *Loads) Score(peerID core.PeerID) (float64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r, ok := l.peers[peerID]
+	if !ok {
+		return 0, false
+	}
+	if l.clk.Now().After(r.expiresAt) {
+		delete(l.peers, peerID)
+		return 0, false
+	}
+	score := r.load.EgressUtilization
+	slots := float64(r.load.ActiveUploads+r.handouts) / float64(l.config.UploadSlots)
+	if slots > score {
+		score = slots
+	}
+	return score, true
+}
+
+// saturated returns whether score is high enough to hand out the peer last.
+func (l *Loads) saturated(score float64) bool {
+	return score >= l.config.SaturationThreshold
+}
+
+// countHandouts charges an upload to the leading non-origin peers of a handout.
+func (l *Loads) countHandouts(peers []*core.PeerInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var n int
+	for _, p := range peers {
+		if n == l.config.CountedHandouts {
+			break
+		}
+		if p.Origin {
+			continue
+		}
+		if r, ok := l.peers[p.PeerID]; ok {
+			r.handouts++
+		}
+		n++
+	}
+}
+
+// allowOriginHandout returns whether origins may be handed out to a leecher.
+func (l *Loads) allowOriginHandout() bool {
+	if l.origins == nil {
+		return true
+	}
+	return l.origins.AllowN(l.clk.Now(), 1)
+}
+
+// cleanup deletes all expired loads.
+func (l *Loads) cleanup(now time.Time) {
+	for peerID, r := range l.peers {
+		if now.After(r.expiresAt) {
+			delete(l.peers, peerID)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerhandoutpolicy
+
+import "github.com/uber/kraken/core"
+
+const _loadPolicy = "load"
+
+const (
+	_loadBuckets       = 10
+	_unknownLoadBucket = _loadBuckets / 2
+	_saturatedPriority = 100
+)
+
+// loadAssignmentPolicy assigns priorities based on download completeness and
+// spare upload capacity. Seeders are handed out first, least loaded first, then
+// origins, then incomplete peers, least loaded first. Saturated peers are handed
+// out after everyone else. Peers which have not reported their load are
+// assumed to be half loaded.
+type loadAssignmentPolicy struct {
+	loads *Loads
+}
+
+func newLoadAssignmentPolicy(loads *Loads) assignmentPolicy {
+	return &loadAssignmentPolicy{loads}
+}
+
+func (p *loadAssignmentPolicy) assignPriority(peer *core.PeerInfo) (int, string) {
+	if peer.Origin {
+		return _loadBuckets, "origin"
+	}
+	bucket := _unknownLoadBucket
+	if score, ok := p.loads.Score(peer.PeerID); ok {
+		if p.loads.saturated(score) {
+			return _saturatedPriority, "peer_saturated"
+		}
+		bucket = int(score * _loadBuckets)
+		if bucket >= _loadBuckets {
+			bucket = _loadBuckets - 1
+		}
+	}
+	if peer.Complete {
+		return bucket, "peer_seeder"
+	}
+	return _loadBuckets + 1 + bucket, "peer_incomplete"
+}
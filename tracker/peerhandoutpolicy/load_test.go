@@ -0,0 +1,214 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerhandoutpolicy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestLoadsScore(t *testing.T) {
+	require := require.New(t)
+
+	l := NewLoads(LoadConfig{UploadSlots: 10}, clock.NewMock())
+
+	p := core.PeerIDFixture()
+
+	_, ok := l.Score(p)
+	require.False(ok)
+
+	l.Report(p, &core.PeerLoad{EgressUtilization: 0.3, ActiveUploads: 2})
+	score, ok := l.Score(p)
+	require.True(ok)
+	require.InDelta(0.3, score, 0.001)
+
+	l.Report(p, &core.PeerLoad{EgressUtilization: 0.1, ActiveUploads: 5})
+	score, ok = l.Score(p)
+	require.True(ok)
+	require.InDelta(0.5, score, 0.001)
+}
+
+func TestLoadsIgnoresNilLoad(t *testing.T) {
+	require := require.New(t)
+
+	l := NewLoads(LoadConfig{}, clock.NewMock())
+
+	p := core.PeerIDFixture()
+	l.Report(p, nil)
+
+	_, ok := l.Score(p)
+	require.False(ok)
+}
+
+func TestLoadsExpire(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	l := NewLoads(LoadConfig{TTL: time.Minute}, clk)
+
+	p := core.PeerIDFixture()
+	l.Report(p, &core.PeerLoad{EgressUtilization: 0.5})
+
+	clk.Add(30 * time.Second)
+	_, ok := l.Score(p)
+	require.True(ok)
+
+	clk.Add(time.Minute)
+	_, ok = l.Score(p)
+	require.False(ok)
+}
+
+func TestLoadsBoundsMemory(t *testing.T) {
+	require := require.New(t)
+
+	l := NewLoads(LoadConfig{MaxPeers: 2}, clock.NewMock())
+
+	p1 := core.PeerIDFixture()
+	p2 := core.PeerIDFixture()
+	p3 := core.PeerIDFixture()
+	for _, p := range []core.PeerID{p1, p2, p3} {
+		l.Report(p, &core.PeerLoad{})
+	}
+
+	_, ok := l.Score(p3)
+	require.False(ok)
+
+	// Existing peers may still update their loads.
+	l.Report(p1, &core.PeerLoad{EgressUtilization: 0.4})
+	score, ok := l.Score(p1)
+	require.True(ok)
+	require.InDelta(0.4, score, 0.001)
+}
+
+func TestLoadPolicyRequiresLoads(t *testing.T) {
+	_, err := NewPriorityPolicy(tally.NoopScope, _loadPolicy)
+	require.Error(t, err)
+}
+
+func TestLoadPolicySortsBySpareCapacity(t *testing.T) {
+	require := require.New(t)
+
+	loads := NewLoads(LoadConfig{UploadSlots: 10, SaturationThreshold: 0.9}, clock.NewMock())
+	policy, err := NewPriorityPolicy(tally.NoopScope, _loadPolicy, WithLoads(loads))
+	require.NoError(err)
+
+	idleSeeder := core.PeerInfoFixture()
+	idleSeeder.Complete = true
+	busySeeder := core.PeerInfoFixture()
+	busySeeder.Complete = true
+	unknownSeeder := core.PeerInfoFixture()
+	unknownSeeder.Complete = true
+	saturatedSeeder := core.PeerInfoFixture()
+	saturatedSeeder.Complete = true
+	origin := core.PeerInfoFixture()
+	origin.Origin = true
+	idleLeecher := core.PeerInfoFixture()
+	busyLeecher := core.PeerInfoFixture()
+
+	policy.ReportLoad(idleSeeder, &core.PeerLoad{EgressUtilization: 0.05})
+	policy.ReportLoad(busySeeder, &core.PeerLoad{EgressUtilization: 0.8})
+	policy.ReportLoad(saturatedSeeder, &core.PeerLoad{ActiveUploads: 10})
+	policy.ReportLoad(idleLeecher, &core.PeerLoad{})
+	policy.ReportLoad(busyLeecher, &core.PeerLoad{EgressUtilization: 0.7})
+
+	peers := policy.SortPeers(core.PeerInfoFixture(), []*core.PeerInfo{
+		busyLeecher, saturatedSeeder, origin, busySeeder, idleLeecher, unknownSeeder, idleSeeder,
+	})
+	require.Equal([]*core.PeerInfo{
+		idleSeeder, unknownSeeder, busySeeder, origin, idleLeecher, busyLeecher, saturatedSeeder,
+	}, peers)
+}
+
+func TestLoadPolicyCountsHandouts(t *testing.T) {
+	require := require.New(t)
+
+	loads := NewLoads(LoadConfig{UploadSlots: 10, CountedHandouts: 1}, clock.NewMock())
+	policy, err := NewPriorityPolicy(tally.NoopScope, _loadPolicy, WithLoads(loads))
+	require.NoError(err)
+
+	s1 := core.PeerInfoFixture()
+	s1.Complete = true
+	s2 := core.PeerInfoFixture()
+	s2.Complete = true
+	policy.ReportLoad(s1, &core.PeerLoad{})
+	policy.ReportLoad(s2, &core.PeerLoad{ActiveUploads: 2})
+
+	// s1 is handed out first until its handouts catch up with the uploads of s2.
+	for i := 0; i < 2; i++ {
+		peers := policy.SortPeers(core.PeerInfoFixture(), []*core.PeerInfo{s2, s1})
+		require.Equal([]*core.PeerInfo{s1, s2}, peers)
+	}
+	score, ok := loads.Score(s1.PeerID)
+	require.True(ok)
+	require.InDelta(0.2, score, 0.001)
+
+	// Only the leading peer was charged.
+	score, ok = loads.Score(s2.PeerID)
+	require.True(ok)
+	require.InDelta(0.2, score, 0.001)
+
+	// Reporting a load resets handouts.
+	policy.ReportLoad(s1, &core.PeerLoad{})
+	score, ok = loads.Score(s1.PeerID)
+	require.True(ok)
+	require.Equal(0.0, score)
+}
+
+func TestLoadPolicyLimitsOriginHandouts(t *testing.T) {
+	require := require.New(t)
+
+	loads := NewLoads(LoadConfig{
+		MaxOriginHandoutsPerSec: 1,
+		OriginHandoutBurst:      2,
+	}, clock.NewMock())
+	policy, err := NewPriorityPolicy(tally.NoopScope, _loadPolicy, WithLoads(loads))
+	require.NoError(err)
+
+	origin := core.PeerInfoFixture()
+	origin.Origin = true
+	seeder := core.PeerInfoFixture()
+	seeder.Complete = true
+
+	for i := 0; i < 2; i++ {
+		peers := policy.SortPeers(core.PeerInfoFixture(), []*core.PeerInfo{seeder, origin})
+		require.ElementsMatch([]*core.PeerInfo{origin, seeder}, peers)
+	}
+
+	// Over the limit, origins are withheld.
+	peers := policy.SortPeers(core.PeerInfoFixture(), []*core.PeerInfo{seeder, origin})
+	require.Equal([]*core.PeerInfo{seeder}, peers)
+
+	// Unless there is no one else to download from.
+	peers = policy.SortPeers(core.PeerInfoFixture(), []*core.PeerInfo{origin})
+	require.Equal([]*core.PeerInfo{origin}, peers)
+
+	// Or other peers are still leeching.
+	leecher := core.PeerInfoFixture()
+	peers = policy.SortPeers(core.PeerInfoFixture(), []*core.PeerInfo{leecher, origin})
+	require.Len(peers, 2)
+	require.Contains(peers, origin)
+
+	// The source is matched by peer id, since peer stores return copies.
+	source := core.PeerInfoFixture()
+	source.Complete = true
+	sourceCopy := *source
+	peers = policy.SortPeers(source, []*core.PeerInfo{&sourceCopy, origin})
+	require.Contains(peers, origin)
+}
@@ -33,26 +33,57 @@ type assignmentPolicy interface {
 	assignPriority(peer *core.PeerInfo) (priority int, label string)
 }
 
+// _lowReputationPriority is added to the priority of low reputation peers, such
+// that they sort after all other peers.
+const _lowReputationPriority = 1000
+
 // PriorityPolicy wraps an assignmentPolicy and uses it to sort lists of peers.
 type PriorityPolicy struct {
-	stats  tally.Scope
-	policy assignmentPolicy
+	stats       tally.Scope
+	policy      assignmentPolicy
+	reputations *Reputations
+	loads       *Loads
+}
+
+// Option configures a PriorityPolicy.
+type Option func(*PriorityPolicy)
+
+// WithReputations deprioritizes or excludes peers with low reputations in r.
+// Origins are never affected.
+func WithReputations(r *Reputations) Option {
+	return func(p *PriorityPolicy) { p.reputations = r }
+}
+
+// WithLoads balances handouts across peers by the loads in l, and limits the
+// rate origins are handed out. Required by the "load" priority policy.
+func WithLoads(l *Loads) Option {
+	return func(p *PriorityPolicy) { p.loads = l }
 }
 
 // NewPriorityPolicy returns a PriorityPolicy that assigns priorities using the given priority policy.
-func NewPriorityPolicy(stats tally.Scope, priorityPolicy string) (*PriorityPolicy, error) {
+func NewPriorityPolicy(
+	stats tally.Scope, priorityPolicy string, options ...Option) (*PriorityPolicy, error) {
+
 	p := &PriorityPolicy{
 		stats: stats.Tagged(map[string]string{
 			"module":   "peerhandoutpolicy",
 			"priority": priorityPolicy,
 		}),
 	}
+	for _, opt := range options {
+		opt(p)
+	}
 
 	switch priorityPolicy {
 	case _defaultPolicy:
 		p.policy = newDefaultAssignmentPolicy()
 	case _completenessPolicy:
 		p.policy = newCompletenessAssignmentPolicy()
+	case _loadPolicy:
+		if p.loads == nil {
+			return nil, fmt.Errorf("priority policy %q requires loads", priorityPolicy)
+		}
+		p.policy = newLoadAssignmentPolicy(p.loads)
 	default:
 		return nil, fmt.Errorf("priority policy %q not found", priorityPolicy)
 	}
@@ -60,17 +91,59 @@ func NewPriorityPolicy(stats tally.Scope, priorityPolicy string) (*PriorityPolic
 	return p, nil
 }
 
+// Report records the reputations source, announcing from addr, has assigned to
+// other peers. No-op if p was not configured with reputations.
+func (p *PriorityPolicy) Report(
+	source *core.PeerInfo, addr string, reputations []core.PeerReputation) {
+
+	if p.reputations == nil {
+		return
+	}
+	p.reputations.Report(addr, source.PeerID, reputations)
+}
+
+// ReportLoad records the upload load of source. No-op if p was not configured
+// with loads.
+func (p *PriorityPolicy) ReportLoad(source *core.PeerInfo, load *core.PeerLoad) {
+	if p.loads == nil {
+		return
+	}
+	p.loads.Report(source.PeerID, load)
+}
+
 // SortPeers returns the given list of peers sorted by the priority assigned to them
-// by the priorityPolicy. Excludes the source peer from the list.
+// by the priorityPolicy. Excludes the source peer from the list, as well as
+// peers with reputations below the exclude threshold, and origins if the
+// origin handout rate is exceeded.
 func (p *PriorityPolicy) SortPeers(source *core.PeerInfo, peers []*core.PeerInfo) []*core.PeerInfo {
+	if p.loads != nil {
+		peers = p.limitOrigins(source, peers)
+	}
 
+	var excluded int
 	peerPriorities := make([]*peerPriorityInfo, 0, len(peers))
 	for k := 0; k < len(peers); k++ {
-		if peers[k] != source {
-			priority, label := p.policy.assignPriority(peers[k])
-			peerPriorities = append(peerPriorities,
-				&peerPriorityInfo{peers[k], priority, label})
+		if peers[k] == source {
+			continue
 		}
+		priority, label := p.policy.assignPriority(peers[k])
+		if p.reputations != nil && !peers[k].Origin {
+			if score, ok := p.reputations.Score(peers[k].PeerID); ok {
+				if score < p.reputations.config.ExcludeThreshold {
+					excluded++
+					continue
+				}
+				if score < p.reputations.config.DeprioritizeThreshold {
+					priority += _lowReputationPriority
+					label = "low_reputation"
+				}
+			}
+		}
+		peerPriorities = append(peerPriorities,
+			&peerPriorityInfo{peers[k], priority, label})
+	}
+	if excluded > 0 {
+		p.stats.Counter("low_reputation_excluded").Inc(int64(excluded))
 	}
 
 	sort.Slice(peerPriorities, func(i, j int) bool {
@@ -89,6 +162,10 @@ func (p *PriorityPolicy) SortPeers(source *core.PeerInfo, peers []*core.PeerInfo
 	}
 	peers = peers[:len(peerPriorities)]
 
+	if p.loads != nil {
+		p.loads.countHandouts(peers)
+	}
+
 	for label, count := range priorityCounts {
 		p.stats.Tagged(map[string]string{
 			"label": label,
@@ -97,3 +174,31 @@ func (p *PriorityPolicy) SortPeers(source *core.PeerInfo, peers []*core.PeerInfo
 
 	return peers
 }
+
+// limitOrigins removes origins from peers if the origin handout rate is
+// exceeded. Origins are kept unless peers contains another complete seeder,
+// since leechers alone cannot complete the download.
+func (p *PriorityPolicy) limitOrigins(source *core.PeerInfo, peers []*core.PeerInfo) []*core.PeerInfo {
+	var origins, seeders int
+	for _, peer := range peers {
+		if peer.PeerID == source.PeerID {
+			continue
+		}
+		if peer.Origin {
+			origins++
+		} else if peer.Complete {
+			seeders++
+		}
+	}
+	if origins == 0 || seeders == 0 || p.loads.allowOriginHandout() {
+		return peers
+	}
+	p.stats.Counter("origin_handouts_limited").Inc(1)
+	filtered := peers[:0]
+	for _, peer := range peers {
+		if !peer.Origin {
+			filtered = append(filtered, peer)
+		}
+	}
+	return filtered
+}
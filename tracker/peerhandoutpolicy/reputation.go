@@ -0,0 +1,236 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerhandoutpolicy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+)
+
+// ReputationConfig defines how reputations reported by announcing peers affect
+// the peers handed out to everyone.
+type ReputationConfig struct {
+	Enable bool `yaml:"enable"`
+
+	// TTL is the duration a report is kept after it was last announced.
+	TTL time.Duration `yaml:"ttl"`
+
+	// MinReporters is the number of distinct reporters which must report a peer
+	// before its reputation affects handouts. Protects against a single
+	// misbehaving reporter. Reporters are identified by the address announces
+	// are received from rather than their self-declared peer id, so a single
+	// host cannot pose as many reporters.
+	MinReporters int `yaml:"min_reporters"`
+
+	// DeprioritizeThreshold is the score below which peers are handed out after
+	// all other peers.
+	DeprioritizeThreshold float64 `yaml:"deprioritize_threshold"`
+
+	// ExcludeThreshold is the score below which peers are not handed out at all.
+	ExcludeThreshold float64 `yaml:"exclude_threshold"`
+
+	// MaxReportersPerPeer is the maximum number of reports kept for each peer.
+	MaxReportersPerPeer int `yaml:"max_reporters_per_peer"`
+
+	// MaxReportsPerReporter is the maximum number of peers a single reporter
+	// may have reports on. Further reports are dropped until earlier ones
+	// expire.
+	MaxReportsPerReporter int `yaml:"max_reports_per_reporter"`
+
+	// MaxPeers is the maximum number of reported peers kept. Reports of new
+	// peers are dropped while full.
+	MaxPeers int `yaml:"max_peers"`
+}
+
+func (c ReputationConfig) applyDefaults() ReputationConfig {
+	if c.TTL == 0 {
+		c.TTL = 10 * time.Minute
+	}
+	if c.MinReporters == 0 {
+		c.MinReporters = 2
+	}
+	if c.DeprioritizeThreshold == 0 {
+		c.DeprioritizeThreshold = 0.5
+	}
+	if c.ExcludeThreshold == 0 {
+		c.ExcludeThreshold = 0.2
+	}
+	if c.MaxReportersPerPeer == 0 {
+		c.MaxReportersPerPeer = 32
+	}
+	if c.MaxReportsPerReporter == 0 {
+		c.MaxReportsPerReporter = 256
+	}
+	if c.MaxPeers == 0 {
+		c.MaxPeers = 100000
+	}
+	return c
+}
+
+type report struct {
+	score     float64
+	expiresAt time.Time
+}
+
+// Reputations aggregates the peer reputations reported in announce requests.
+// A peer's reputation is the mean score reported by the peers which have
+// downloaded from it. Peers only report others which misbehaved, so reported
+// scores are not diluted by well-behaved peers. Reputations is thread-safe.
+type Reputations struct {
+	config ReputationConfig
+	clk    clock.Clock
+
+	mu      sync.Mutex
+	peers   map[core.PeerID]map[string]report // Peer -> reporter -> report.
+	reports map[string]int                    // Reporter -> number of reports.
+}
+
+// NewReputations creates a new Reputations.
+func NewReputations(config ReputationConfig, clk clock.Clock) *Reputations {
+	return &Reputations{
+		config:  config.applyDefaults(),
+		clk:     clk,
+		peers:   make(map[core.PeerID]map[string]report),
+		reports: make(map[string]int),
+	}
+}
+
+// Report records reputations reported by source from the address reporter,
+// replacing any previous reports by reporter of the same peers.
+func (r *Reputations) Report(
+	reporter string, source core.PeerID, reputations []core.PeerReputation) {
+
+	if len(reputations) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clk.Now()
+	for _, rep := range reputations {
+		if rep.PeerID == source {
+			continue
+		}
+		if _, ok := r.peers[rep.PeerID][reporter]; !ok {
+			if !r.hasRoom(now, reporter, rep.PeerID) {
+				continue
+			}
+			reports, ok := r.peers[rep.PeerID]
+			if !ok {
+				reports = make(map[string]report)
+				r.peers[rep.PeerID] = reports
+			} else if len(reports) >= r.config.MaxReportersPerPeer {
+				r.evictOldest(reports)
+			}
+			r.reports[reporter]++
+		}
+		r.peers[rep.PeerID][reporter] = report{
+			score:     clamp(rep.Score),
+			expiresAt: now.Add(r.config.TTL),
+		}
+	}
+}
+
+// hasRoom returns whether a new report of peerID by reporter may be recorded,
+// deleting expired reports if either limit is reached.
+func (r *Reputations) hasRoom(now time.Time, reporter string, peerID core.PeerID) bool {
+	full := func() bool {
+		if _, ok := r.peers[peerID]; !ok && len(r.peers) >= r.config.MaxPeers {
+			return true
+		}
+		return r.reports[reporter] >= r.config.MaxReportsPerReporter
+	}
+	if !full() {
+		return true
+	}
+	r.cleanup(now)
+	return !full()
+}
+
+// Score returns the reputation of peerID. Returns false if peerID has not been
+// reported by enough peers.
+func (r *Reputations) Score(peerID core.PeerID) (float64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reports, ok := r.peers[peerID]
+	if !ok {
+		return 0, false
+	}
+	now := r.clk.Now()
+	var sum float64
+	for reporter, rep := range reports {
+		if now.After(rep.expiresAt) {
+			r.remove(reports, reporter)
+			continue
+		}
+		sum += rep.score
+	}
+	if len(reports) == 0 {
+		delete(r.peers, peerID)
+		return 0, false
+	}
+	if len(reports) < r.config.MinReporters {
+		return 0, false
+	}
+	return sum / float64(len(reports)), true
+}
+
+// cleanup deletes all expired reports.
+func (r *Reputations) cleanup(now time.Time) {
+	for peerID, reports := range r.peers {
+		for reporter, rep := range reports {
+			if now.After(rep.expiresAt) {
+				r.remove(reports, reporter)
+			}
+		}
+		if len(reports) == 0 {
+			delete(r.peers, peerID)
+		}
+	}
+}
+
+func (r *Reputations) evictOldest(reports map[string]report) {
+	var oldest string
+	var oldestExpiresAt time.Time
+	for reporter, rep := range reports {
+		if oldestExpiresAt.IsZero() || rep.expiresAt.Before(oldestExpiresAt) {
+			oldest, oldestExpiresAt = reporter, rep.expiresAt
+		}
+	}
+	r.remove(reports, oldest)
+}
+
+// remove deletes the report of reporter from reports.
+func (r *Reputations) remove(reports map[string]report, reporter string) {
+	delete(reports, reporter)
+	if r.reports[reporter]--; r.reports[reporter] <= 0 {
+		delete(r.reports, reporter)
+	}
+}
+
+func clamp(score float64) float64 {
+	if score < 0 {
+		return 0
+	}
+	if score > 1 {
+		return 1
+	}
+	return score
+}
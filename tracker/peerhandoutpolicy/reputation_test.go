@@ -0,0 +1,219 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerhandoutpolicy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/randutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func reportFrom(r *Reputations, n int, peerID core.PeerID, score float64) {
+	for i := 0; i < n; i++ {
+		r.Report(randutil.IP(), core.PeerIDFixture(), []core.PeerReputation{{PeerID: peerID, Score: score}})
+	}
+}
+
+func TestReputationsRequiresMinReporters(t *testing.T) {
+	require := require.New(t)
+
+	r := NewReputations(ReputationConfig{MinReporters: 2}, clock.NewMock())
+
+	p := core.PeerIDFixture()
+
+	reportFrom(r, 1, p, 0.2)
+	_, ok := r.Score(p)
+	require.False(ok)
+
+	reportFrom(r, 1, p, 0.4)
+	score, ok := r.Score(p)
+	require.True(ok)
+	require.InDelta(0.3, score, 0.001)
+}
+
+func TestReputationsReplacesReportsFromSameReporter(t *testing.T) {
+	require := require.New(t)
+
+	r := NewReputations(ReputationConfig{MinReporters: 1}, clock.NewMock())
+
+	p := core.PeerIDFixture()
+	reporter := core.PeerIDFixture()
+
+	r.Report("10.0.0.1", reporter, []core.PeerReputation{{PeerID: p, Score: 0.1}})
+	r.Report("10.0.0.1", reporter, []core.PeerReputation{{PeerID: p, Score: 0.7}})
+
+	score, ok := r.Score(p)
+	require.True(ok)
+	require.InDelta(0.7, score, 0.001)
+}
+
+func TestReputationsIgnoresSelfReports(t *testing.T) {
+	r := NewReputations(ReputationConfig{MinReporters: 1}, clock.NewMock())
+
+	p := core.PeerIDFixture()
+	r.Report("10.0.0.1", p, []core.PeerReputation{{PeerID: p, Score: 0}})
+
+	_, ok := r.Score(p)
+	require.False(t, ok)
+}
+
+func TestReputationsExpire(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	config := ReputationConfig{MinReporters: 1, TTL: time.Minute}
+	r := NewReputations(config, clk)
+
+	p := core.PeerIDFixture()
+	reportFrom(r, 1, p, 0.1)
+
+	_, ok := r.Score(p)
+	require.True(ok)
+
+	clk.Add(config.TTL + time.Second)
+
+	_, ok = r.Score(p)
+	require.False(ok)
+	require.Empty(r.peers)
+}
+
+func TestReputationsBoundsMemory(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	config := ReputationConfig{MaxReportersPerPeer: 3, MaxPeers: 2, TTL: time.Minute}
+	r := NewReputations(config, clk)
+
+	p := core.PeerIDFixture()
+	reportFrom(r, 5, p, 0.1)
+	require.Len(r.peers[p], 3)
+
+	reportFrom(r, 1, core.PeerIDFixture(), 0.1)
+	reportFrom(r, 1, core.PeerIDFixture(), 0.1)
+	require.Len(r.peers, 2)
+
+	// Expired reports make room for new peers.
+	clk.Add(config.TTL + time.Second)
+	q := core.PeerIDFixture()
+	reportFrom(r, 1, q, 0.1)
+	require.Len(r.peers, 1)
+	require.Contains(r.peers, q)
+}
+
+func TestReputationsCountsReportersByAddress(t *testing.T) {
+	require := require.New(t)
+
+	r := NewReputations(ReputationConfig{MinReporters: 2}, clock.NewMock())
+
+	p := core.PeerIDFixture()
+
+	// Many peer ids announcing from the same address count as one reporter.
+	for i := 0; i < 5; i++ {
+		r.Report("10.0.0.1", core.PeerIDFixture(), []core.PeerReputation{{PeerID: p, Score: 0}})
+	}
+	_, ok := r.Score(p)
+	require.False(ok)
+
+	r.Report("10.0.0.2", core.PeerIDFixture(), []core.PeerReputation{{PeerID: p, Score: 0}})
+	_, ok = r.Score(p)
+	require.True(ok)
+}
+
+func TestReputationsLimitsReportsPerReporter(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	config := ReputationConfig{MinReporters: 1, MaxReportsPerReporter: 2, TTL: time.Minute}
+	r := NewReputations(config, clk)
+
+	peers := []core.PeerID{core.PeerIDFixture(), core.PeerIDFixture(), core.PeerIDFixture()}
+	var reps []core.PeerReputation
+	for _, p := range peers {
+		reps = append(reps, core.PeerReputation{PeerID: p, Score: 0})
+	}
+	r.Report("10.0.0.1", core.PeerIDFixture(), reps)
+
+	for _, p := range peers[:2] {
+		_, ok := r.Score(p)
+		require.True(ok)
+	}
+	_, ok := r.Score(peers[2])
+	require.False(ok)
+
+	// Updating existing reports is still allowed.
+	r.Report("10.0.0.1", core.PeerIDFixture(), []core.PeerReputation{{PeerID: peers[0], Score: 0.4}})
+	score, ok := r.Score(peers[0])
+	require.True(ok)
+	require.InDelta(0.4, score, 0.001)
+
+	// Expired reports make room for new ones.
+	clk.Add(config.TTL + time.Second)
+	r.Report("10.0.0.1", core.PeerIDFixture(), reps[2:])
+	_, ok = r.Score(peers[2])
+	require.True(ok)
+	require.Equal(1, r.reports["10.0.0.1"])
+}
+
+func TestPriorityPolicyReputations(t *testing.T) {
+	require := require.New(t)
+
+	r := NewReputations(ReputationConfig{
+		MinReporters:          2,
+		DeprioritizeThreshold: 0.5,
+		ExcludeThreshold:      0.2,
+	}, clock.NewMock())
+
+	policy, err := NewPriorityPolicy(tally.NoopScope, _completenessPolicy, WithReputations(r))
+	require.NoError(err)
+
+	good := core.PeerInfoFixture()
+	good.Complete = true
+	low := core.PeerInfoFixture()
+	low.Complete = true
+	bad := core.PeerInfoFixture()
+	bad.Complete = true
+	origin := core.PeerInfoFixture()
+	origin.Origin = true
+	incomplete := core.PeerInfoFixture()
+
+	for i := 0; i < 2; i++ {
+		policy.Report(core.PeerInfoFixture(), randutil.IP(), []core.PeerReputation{
+			{PeerID: low.PeerID, Score: 0.3},
+			{PeerID: bad.PeerID, Score: 0.1},
+			{PeerID: origin.PeerID, Score: 0},
+		})
+	}
+
+	peers := policy.SortPeers(
+		core.PeerInfoFixture(), []*core.PeerInfo{bad, low, incomplete, origin, good})
+	require.Equal([]*core.PeerInfo{good, origin, incomplete, low}, peers)
+}
+
+func TestPriorityPolicyReportWithoutReputations(t *testing.T) {
+	require := require.New(t)
+
+	policy := DefaultPriorityPolicyFixture()
+
+	p := core.PeerInfoFixture()
+	for i := 0; i < 5; i++ {
+		policy.Report(core.PeerInfoFixture(), randutil.IP(), []core.PeerReputation{{PeerID: p.PeerID, Score: 0}})
+	}
+	require.Equal([]*core.PeerInfo{p}, policy.SortPeers(core.PeerInfoFixture(), []*core.PeerInfo{p}))
+}
@@ -15,21 +15,31 @@ package peerstore
 
 import (
 	"time"
+
+	"github.com/uber/kraken/lib/hostlist"
 )
 
 // Config defines Store configuration.
 //
 // NOTE: By default, the LocalStore implementation is used. Redis configuration
-// is ignored unless RedisConfig.Enabled is true.
+// is ignored unless RedisConfig.Enabled is true, and gossip configuration is
+// ignored unless GossipConfig.Enabled is true. The GossipStore stores peers
+// according to the local configuration.
 // 默认使用 local，除非 RedisConfig.Enabled 设置成 true
 type Config struct {
-	Local LocalConfig `yaml:"local"`
-	Redis RedisConfig `yaml:"redis"`
+	Local  LocalConfig  `yaml:"local"`
+	Redis  RedisConfig  `yaml:"redis"`
+	Gossip GossipConfig `yaml:"gossip"`
 }
 
 // LocalConfig defines LocalStore configuration.
 type LocalConfig struct {
 	TTL time.Duration `yaml:"ttl"`
+
+	// MaxTorrents and MaxPeersPerTorrent bound the memory of the store. Updates
+	// of new torrents or peers are rejected while full. Unlimited if zero.
+	MaxTorrents        int `yaml:"max_torrents"`
+	MaxPeersPerTorrent int `yaml:"max_peers_per_torrent"`
 }
 
 func (c *LocalConfig) applyDefaults() {
@@ -51,6 +61,21 @@ type RedisConfig struct {
 	MaxIdleConns      int           `yaml:"max_idle_conns"`
 	MaxActiveConns    int           `yaml:"max_active_conns"`
 	IdleConnTimeout   time.Duration `yaml:"idle_conn_timeout"`
+
+	// PeerNotifications enables Subscribe by publishing announced peers on a
+	// Redis channel per torrent, such that subscribers receive peers announced
+	// to any tracker. Trackers only subscribe to the channels of torrents they
+	// stream peers of.
+	PeerNotifications bool `yaml:"peer_notifications"`
+
+	// TorrentSetShards is the number of keys the torrents announced in each
+	// window are indexed across for HotTorrents, such that announces of all
+	// torrents do not contend on a single key.
+	TorrentSetShards int `yaml:"torrent_set_shards"`
+
+	// HotTorrentCandidates bounds the number of torrents HotTorrents samples
+	// from the index of each window. Torrents outside the sample are missed.
+	HotTorrentCandidates int `yaml:"hot_torrent_candidates"`
 }
 
 func (c *RedisConfig) applyDefaults() {
@@ -78,4 +103,68 @@ func (c *RedisConfig) applyDefaults() {
 	if c.IdleConnTimeout == 0 {
 		c.IdleConnTimeout = 60 * time.Second
 	}
+	if c.TorrentSetShards == 0 {
+		c.TorrentSetShards = 16
+	}
+	if c.HotTorrentCandidates == 0 {
+		c.HotTorrentCandidates = 1000
+	}
+}
+
+// GossipConfig defines GossipStore configuration.
+type GossipConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Hosts lists all tracker instances of the cluster. The local tracker is
+	// excluded automatically.
+	Hosts hostlist.Config `yaml:"hosts"`
+
+	// Interval is the interval at which pending peer updates are pushed to
+	// other trackers. Must be well below the announce interval, such that
+	// peers announcing to different trackers find each other promptly.
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout is the timeout of each push to another tracker.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Secret is shared by all trackers of the cluster, and must not be given
+	// to any other host. Pushes are signed with Secret, and pushes without a
+	// valid signature are rejected. Required.
+	Secret string `yaml:"secret"`
+
+	// MaxClockSkew bounds the age of accepted pushes, limiting how long a
+	// captured push can be replayed.
+	MaxClockSkew time.Duration `yaml:"max_clock_skew"`
+
+	// MaxPendingUpdates is the number of distinct peer updates buffered between
+	// pushes. Further updates are dropped, and replicated once the peers
+	// announce again.
+	MaxPendingUpdates int `yaml:"max_pending_updates"`
+
+	// MaxTorrents and MaxPeersPerTorrent bound the memory of the underlying
+	// LocalStore, taking precedence over LocalConfig, since every tracker
+	// stores the peers of all torrents.
+	MaxTorrents        int `yaml:"max_torrents"`
+	MaxPeersPerTorrent int `yaml:"max_peers_per_torrent"`
+}
+
+func (c *GossipConfig) applyDefaults() {
+	if c.Interval == 0 {
+		c.Interval = 250 * time.Millisecond
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 2 * time.Second
+	}
+	if c.MaxClockSkew == 0 {
+		c.MaxClockSkew = 30 * time.Second
+	}
+	if c.MaxPendingUpdates == 0 {
+		c.MaxPendingUpdates = 10000
+	}
+	if c.MaxTorrents == 0 {
+		c.MaxTorrents = 100000
+	}
+	if c.MaxPeersPerTorrent == 0 {
+		c.MaxPeersPerTorrent = 10000
+	}
 }
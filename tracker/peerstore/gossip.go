@@ -0,0 +1,284 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+)
+
+// Update is a peer update replicated between trackers.
+type Update struct {
+	InfoHash string         `json:"info_hash"`
+	Peer     *core.PeerInfo `json:"peer"`
+}
+
+// GossipRequest defines a push of peer updates from one tracker to another.
+type GossipRequest struct {
+	Updates []Update `json:"updates"`
+}
+
+// Headers of signed gossip requests.
+const (
+	GossipTimestampHeader = "X-Kraken-Gossip-Timestamp"
+	GossipSignatureHeader = "X-Kraken-Gossip-Signature"
+)
+
+// ErrGossipUnauthorized is returned when a gossip request does not carry a
+// valid signature of another tracker.
+var ErrGossipUnauthorized = errors.New("gossip request not signed by a tracker")
+
+// Replica is implemented by Stores which apply peer updates replicated from
+// other trackers.
+type Replica interface {
+	// Authenticate verifies that the gossip request with header h and body was
+	// pushed by another tracker.
+	Authenticate(h http.Header, body []byte) error
+
+	ApplyUpdates(updates []Update) error
+}
+
+type updateKey struct {
+	h      core.InfoHash
+	peerID core.PeerID
+}
+
+// GossipStore is a Store which replicates peer updates between tracker
+// instances, such that each tracker knows the peers announced to all others
+// without an external store. Peers are stored in a LocalStore, and updates are
+// pushed to all other trackers in batches every GossipConfig.Interval.
+//
+// Pushes are best-effort: updates lost to failed pushes or full buffers are
+// replicated once the peer announces again.
+type GossipStore struct {
+	*LocalStore
+
+	config   GossipConfig
+	stats    tally.Scope
+	clk      clock.Clock
+	trackers hostlist.List
+	tls      *tls.Config
+
+	mu      sync.Mutex
+	pending map[updateKey]*core.PeerInfo
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewGossipStore creates a new GossipStore which pushes updates to trackers.
+func NewGossipStore(
+	config GossipConfig,
+	local LocalConfig,
+	stats tally.Scope,
+	clk clock.Clock,
+	trackers hostlist.List,
+	tls *tls.Config) *GossipStore {
+
+	config.applyDefaults()
+	local.MaxTorrents = config.MaxTorrents
+	local.MaxPeersPerTorrent = config.MaxPeersPerTorrent
+
+	stats = stats.Tagged(map[string]string{
+		"module": "peerstore",
+	})
+
+	s := &GossipStore{
+		LocalStore: NewLocalStore(local, clk),
+		config:     config,
+		stats:      stats,
+		clk:        clk,
+		trackers:   trackers,
+		tls:        tls,
+		pending:    make(map[updateKey]*core.PeerInfo),
+		stop:       make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.pushLoop(clk.Ticker(config.Interval))
+	return s
+}
+
+// Close implements Store.
+func (s *GossipStore) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.wg.Wait()
+		s.LocalStore.Close()
+	})
+}
+
+// UpdatePeer implements Store.
+func (s *GossipStore) UpdatePeer(h core.InfoHash, p *core.PeerInfo) error {
+	if err := s.LocalStore.UpdatePeer(h, p); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := updateKey{h, p.PeerID}
+	if _, ok := s.pending[k]; !ok && len(s.pending) >= s.config.MaxPendingUpdates {
+		s.stats.Counter("gossip_dropped_updates").Inc(1)
+		return nil
+	}
+	c := *p
+	s.pending[k] = &c
+	return nil
+}
+
+// sign returns the signature of a push of body at timestamp ts.
+func (s *GossipStore) sign(ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(s.config.Secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Authenticate implements Replica. Requests must be signed with the shared
+// secret of the trackers, within MaxClockSkew of the local clock. All requests
+// are rejected if no secret is configured.
+func (s *GossipStore) Authenticate(h http.Header, body []byte) error {
+	if s.config.Secret == "" {
+		return ErrGossipUnauthorized
+	}
+	ts := h.Get(GossipTimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrGossipUnauthorized
+	}
+	age := s.clk.Now().Sub(time.Unix(sec, 0))
+	if age > s.config.MaxClockSkew || age < -s.config.MaxClockSkew {
+		return ErrGossipUnauthorized
+	}
+	sig, err := hex.DecodeString(h.Get(GossipSignatureHeader))
+	if err != nil {
+		return ErrGossipUnauthorized
+	}
+	expected, _ := hex.DecodeString(s.sign(ts, body))
+	if !hmac.Equal(sig, expected) {
+		return ErrGossipUnauthorized
+	}
+	return nil
+}
+
+// ApplyUpdates implements Replica. Replicated updates are stored, but not
+// pushed any further. The batch is rejected as a whole if any update is
+// malformed.
+func (s *GossipStore) ApplyUpdates(updates []Update) error {
+	hashes := make([]core.InfoHash, len(updates))
+	for i, u := range updates {
+		h, err := core.NewInfoHashFromHex(u.InfoHash)
+		if err != nil {
+			return fmt.Errorf("parse info hash: %s", err)
+		}
+		if u.Peer == nil {
+			return fmt.Errorf("update of %s has no peer", h)
+		}
+		hashes[i] = h
+	}
+	for i, u := range updates {
+		if err := s.LocalStore.UpdatePeer(hashes[i], u.Peer); err != nil {
+			s.stats.Counter("gossip_rejected_updates").Inc(1)
+		}
+	}
+	s.stats.Counter("gossip_received_updates").Inc(int64(len(updates)))
+	return nil
+}
+
+func (s *GossipStore) pushLoop(ticker *clock.Ticker) {
+	defer s.wg.Done()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.push()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// push sends all pending updates to every other tracker.
+func (s *GossipStore) push() {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[updateKey]*core.PeerInfo)
+	s.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	req := GossipRequest{Updates: make([]Update, 0, len(pending))}
+	for k, p := range pending {
+		req.Updates = append(req.Updates, Update{k.h.Hex(), p})
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		log.Errorf("Error marshaling gossip request: %s", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for addr := range s.trackers.Resolve() {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			if err := s.send(addr, body); err != nil {
+				s.stats.Counter("gossip_push_errors").Inc(1)
+				log.With("tracker", addr).Errorf("Error pushing peer updates: %s", err)
+			}
+		}(addr)
+	}
+	wg.Wait()
+	s.stats.Counter("gossip_pushed_updates").Inc(int64(len(req.Updates)))
+}
+
+func (s *GossipStore) send(addr string, body []byte) error {
+	ts := strconv.FormatInt(s.clk.Now().Unix(), 10)
+	resp, err := httputil.Post(
+		fmt.Sprintf("http://%s/peerstore/gossip", addr),
+		httputil.SendBody(bytes.NewReader(body)),
+		httputil.SendHeaders(map[string]string{
+			GossipTimestampHeader: ts,
+			GossipSignatureHeader: s.sign(ts, body),
+		}),
+		httputil.SendTimeout(s.config.Timeout),
+		httputil.SendTLS(s.tls))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+const _testSecret = "gossip-secret"
+
+// gossipRecorder records the gossip requests pushed to it.
+type gossipRecorder struct {
+	mu      sync.Mutex
+	reqs    []GossipRequest
+	headers []http.Header
+	bodies  [][]byte
+}
+
+func (r *gossipRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var g GossipRequest
+	if err := json.Unmarshal(body, &g); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	r.headers = append(r.headers, req.Header)
+	r.bodies = append(r.bodies, body)
+	r.mu.Unlock()
+	r.mu.Lock()
+	r.reqs = append(r.reqs, g)
+	r.mu.Unlock()
+}
+
+func (r *gossipRecorder) requests() []GossipRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]GossipRequest(nil), r.reqs...)
+}
+
+func newGossipFixture(
+	t *testing.T, config GossipConfig) (*GossipStore, *clock.Mock, *gossipRecorder, func()) {
+
+	rec := &gossipRecorder{}
+	addr, stop := testutil.StartServer(rec)
+
+	config.Interval = time.Second
+	config.Secret = _testSecret
+	clk := clock.NewMock()
+	s := NewGossipStore(
+		config, LocalConfig{TTL: time.Hour}, tally.NoopScope, clk, hostlist.Fixture(addr), nil)
+
+	return s, clk, rec, func() {
+		s.Close()
+		stop()
+	}
+}
+
+func waitForRequests(t *testing.T, rec *gossipRecorder, n int) []GossipRequest {
+	require.NoError(t, testutil.PollUntilTrue(5*time.Second, func() bool {
+		return len(rec.requests()) >= n
+	}))
+	return rec.requests()
+}
+
+func TestGossipStorePushesCoalescedUpdates(t *testing.T) {
+	require := require.New(t)
+
+	s, clk, rec, cleanup := newGossipFixture(t, GossipConfig{})
+	defer cleanup()
+
+	h := core.InfoHashFixture()
+	p1 := core.PeerInfoFixture()
+	p2 := core.PeerInfoFixture()
+
+	require.NoError(s.UpdatePeer(h, p1))
+	require.NoError(s.UpdatePeer(h, p2))
+	p1.Complete = true
+	require.NoError(s.UpdatePeer(h, p1))
+
+	clk.Add(time.Second)
+
+	reqs := waitForRequests(t, rec, 1)
+	require.ElementsMatch([]Update{{h.Hex(), p1}, {h.Hex(), p2}}, reqs[0].Updates)
+
+	// Nothing is pushed without new updates.
+	clk.Add(time.Second)
+	time.Sleep(50 * time.Millisecond)
+	require.Len(rec.requests(), 1)
+}
+
+func TestGossipStoreDoesNotPushReplicatedUpdates(t *testing.T) {
+	require := require.New(t)
+
+	s, clk, rec, cleanup := newGossipFixture(t, GossipConfig{})
+	defer cleanup()
+
+	h := core.InfoHashFixture()
+	p := core.PeerInfoFixture()
+
+	require.NoError(s.ApplyUpdates([]Update{{h.Hex(), p}}))
+
+	peers, err := s.GetPeers(h, 10)
+	require.NoError(err)
+	require.Equal([]*core.PeerInfo{p}, peers)
+
+	clk.Add(time.Second)
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(rec.requests())
+}
+
+func TestGossipStoreDropsUpdatesWhenPendingBufferFull(t *testing.T) {
+	require := require.New(t)
+
+	s, clk, rec, cleanup := newGossipFixture(t, GossipConfig{MaxPendingUpdates: 1})
+	defer cleanup()
+
+	h := core.InfoHashFixture()
+	p1 := core.PeerInfoFixture()
+	p2 := core.PeerInfoFixture()
+
+	require.NoError(s.UpdatePeer(h, p1))
+	require.NoError(s.UpdatePeer(h, p2))
+
+	// Both peers are still stored locally.
+	peers, err := s.GetPeers(h, 10)
+	require.NoError(err)
+	require.Len(peers, 2)
+
+	clk.Add(time.Second)
+
+	reqs := waitForRequests(t, rec, 1)
+	require.Equal([]Update{{h.Hex(), p1}}, reqs[0].Updates)
+}
+
+func TestGossipStoreApplyUpdatesRejectsInvalidUpdates(t *testing.T) {
+	s, _, _, cleanup := newGossipFixture(t, GossipConfig{})
+	defer cleanup()
+
+	require.Error(t, s.ApplyUpdates([]Update{{"invalid", core.PeerInfoFixture()}}))
+	require.Error(t, s.ApplyUpdates([]Update{{core.InfoHashFixture().Hex(), nil}}))
+}
+
+func TestGossipStoreApplyUpdatesRejectsBatchAsWhole(t *testing.T) {
+	require := require.New(t)
+
+	s, _, _, cleanup := newGossipFixture(t, GossipConfig{})
+	defer cleanup()
+
+	h := core.InfoHashFixture()
+
+	require.Error(s.ApplyUpdates([]Update{
+		{h.Hex(), core.PeerInfoFixture()},
+		{"invalid", core.PeerInfoFixture()},
+	}))
+
+	peers, err := s.GetPeers(h, 10)
+	require.NoError(err)
+	require.Empty(peers)
+}
+
+func TestGossipStoreAuthenticate(t *testing.T) {
+	require := require.New(t)
+
+	s, clk, rec, cleanup := newGossipFixture(t, GossipConfig{})
+	defer cleanup()
+
+	require.NoError(s.UpdatePeer(core.InfoHashFixture(), core.PeerInfoFixture()))
+	clk.Add(time.Second)
+	waitForRequests(t, rec, 1)
+
+	rec.mu.Lock()
+	header, body := rec.headers[0], rec.bodies[0]
+	rec.mu.Unlock()
+
+	// Pushes are signed by the sender.
+	require.NoError(s.Authenticate(header, body))
+
+	// Tampered bodies, missing signatures and foreign secrets are rejected.
+	require.Equal(ErrGossipUnauthorized, s.Authenticate(header, append(body, ' ')))
+	require.Equal(ErrGossipUnauthorized, s.Authenticate(http.Header{}, body))
+
+	other, _, _, otherCleanup := newGossipFixture(t, GossipConfig{})
+	defer otherCleanup()
+	other.config.Secret = "other-secret"
+	require.Equal(ErrGossipUnauthorized, other.Authenticate(header, body))
+
+	// Replays are only accepted within the clock skew.
+	clk.Add(s.config.MaxClockSkew + time.Second)
+	require.Equal(ErrGossipUnauthorized, s.Authenticate(header, body))
+}
+
+func TestGossipStoreAuthenticateRequiresSecret(t *testing.T) {
+	s, _, _, cleanup := newGossipFixture(t, GossipConfig{})
+	defer cleanup()
+
+	header := http.Header{}
+	header.Set(GossipTimestampHeader, strconv.FormatInt(s.clk.Now().Unix(), 10))
+	header.Set(GossipSignatureHeader, s.sign(header.Get(GossipTimestampHeader), nil))
+	require.NoError(t, s.Authenticate(header, nil))
+
+	s.config.Secret = ""
+	require.Equal(t, ErrGossipUnauthorized, s.Authenticate(header, nil))
+}
@@ -14,6 +14,7 @@
 package peerstore
 
 import (
+	"errors"
 	"math/rand"
 	"sync"
 	"time"
@@ -28,6 +29,12 @@ const (
 	_cleanupExpiredPeerGroupsInterval  = time.Hour
 )
 
+// Errors returned by LocalStore.UpdatePeer when the store is full.
+var (
+	ErrTooManyTorrents = errors.New("too many torrents")
+	ErrTooManyPeers    = errors.New("too many peers for torrent")
+)
+
 // LocalStore is an in-memory Store implementation.
 type LocalStore struct {
 	config                          LocalConfig
@@ -40,6 +47,8 @@ type LocalStore struct {
 
 	mu         sync.RWMutex
 	peerGroups map[core.InfoHash]*peerGroup
+
+	notifier *notifier
 }
 
 type peerGroup struct {
@@ -57,6 +66,7 @@ type peerEntry struct {
 	id        core.PeerID
 	ip        string
 	port      int
+	origin    bool
 	complete  bool
 	expiresAt time.Time
 }
@@ -71,6 +81,7 @@ func NewLocalStore(config LocalConfig, clk clock.Clock) *LocalStore {
 		cleanupExpiredPeerGroupsTicker:  time.NewTicker(_cleanupExpiredPeerGroupsInterval),
 		stop:                            make(chan struct{}),
 		peerGroups:                      make(map[core.InfoHash]*peerGroup),
+		notifier:                        newNotifier(),
 	}
 	go s.cleanupTask()
 	return s
@@ -119,10 +130,16 @@ func (s *LocalStore) GetPeers(h core.InfoHash, n int) ([]*core.PeerInfo, error)
 // 新的peer加入到
 func (s *LocalStore) UpdatePeer(h core.InfoHash, p *core.PeerInfo) error {
 	g := s.getOrInitLockedPeerGroup(h)
+	if g == nil {
+		return ErrTooManyTorrents
+	}
 	defer g.mu.Unlock()
 
 	e, ok := g.peerMap[p.PeerID]
 	if !ok {
+		if s.config.MaxPeersPerTorrent > 0 && len(g.peerList) >= s.config.MaxPeersPerTorrent {
+			return ErrTooManyPeers
+		}
 		e = &peerEntry{}
 		g.peerList = append(g.peerList, e)
 		g.peerMap[p.PeerID] = e
@@ -130,6 +147,7 @@ func (s *LocalStore) UpdatePeer(h core.InfoHash, p *core.PeerInfo) error {
 	e.id = p.PeerID
 	e.ip = p.IP
 	e.port = p.Port
+	e.origin = p.Origin
 	e.complete = p.Complete
 	e.expiresAt = s.clk.Now().Add(s.config.TTL)
 
@@ -137,9 +155,64 @@ func (s *LocalStore) UpdatePeer(h core.InfoHash, p *core.PeerInfo) error {
 	// peerEntry expires.
 	g.lastExpiresAt = e.expiresAt
 
+	s.notifier.notify(h, p)
+
 	return nil
 }
 
+// Subscribe implements Store.
+func (s *LocalStore) Subscribe(hashes []core.InfoHash) (<-chan *PeerEvent, func()) {
+	return s.notifier.subscribe(hashes)
+}
+
+// Scrape implements Store.
+func (s *LocalStore) Scrape(h core.InfoHash) (*SwarmStats, error) {
+	s.mu.RLock()
+	g, ok := s.peerGroups[h]
+	s.mu.RUnlock()
+	if !ok {
+		return &SwarmStats{InfoHash: h}, nil
+	}
+	return s.scrape(h, g), nil
+}
+
+// HotTorrents implements Store.
+func (s *LocalStore) HotTorrents(n int) ([]*SwarmStats, error) {
+	s.mu.RLock()
+	groups := make(map[core.InfoHash]*peerGroup, len(s.peerGroups))
+	for h, g := range s.peerGroups {
+		groups[h] = g
+	}
+	s.mu.RUnlock()
+
+	stats := make([]*SwarmStats, 0, len(groups))
+	for h, g := range groups {
+		st := s.scrape(h, g)
+		if st.Seeders()+st.Leechers() > 0 {
+			stats = append(stats, st)
+		}
+	}
+	return Hottest(stats, n), nil
+}
+
+// scrape counts the unexpired peers of g.
+func (s *LocalStore) scrape(h core.InfoHash, g *peerGroup) *SwarmStats {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	stats := &SwarmStats{InfoHash: h}
+	now := s.clk.Now()
+	for _, e := range g.peerList {
+		if now.After(e.expiresAt) {
+			continue
+		}
+		stats.add(e.origin, e.complete)
+	}
+	return stats
+}
+
+// getOrInitLockedPeerGroup returns nil if h has no peer group and the store
+// already holds MaxTorrents peer groups.
 func (s *LocalStore) getOrInitLockedPeerGroup(h core.InfoHash) *peerGroup {
 	// We must take care to handle a race condition against
 	// cleanupExpiredPeerGroups. Consider two goroutines, A and B, where A
@@ -158,6 +231,10 @@ func (s *LocalStore) getOrInitLockedPeerGroup(h core.InfoHash) *peerGroup {
 		s.mu.Lock()
 		g, ok := s.peerGroups[h]
 		if !ok {
+			if s.config.MaxTorrents > 0 && len(s.peerGroups) >= s.config.MaxTorrents {
+				s.mu.Unlock()
+				return nil
+			}
 			g = &peerGroup{
 				peerMap:       make(map[core.PeerID]*peerEntry),
 				lastExpiresAt: s.clk.Now().Add(s.config.TTL),
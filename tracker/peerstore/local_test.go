@@ -143,3 +143,96 @@ func TestLocalStoreConcurrency(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestLocalStoreSubscribe(t *testing.T) {
+	require := require.New(t)
+
+	s := NewLocalStore(LocalConfig{}, clock.New())
+	defer s.Close()
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+
+	peers, cancel := s.Subscribe([]core.InfoHash{h1, h2})
+
+	p := core.PeerInfoFixture()
+	p.Complete = false
+	require.NoError(s.UpdatePeer(h1, p))
+	require.Equal(&PeerEvent{h1, p}, <-peers)
+
+	// Repeated announces without any change are not sent again.
+	require.NoError(s.UpdatePeer(h1, p))
+
+	// Other torrents are not sent.
+	require.NoError(s.UpdatePeer(core.InfoHashFixture(), core.PeerInfoFixture()))
+
+	// The same peer is sent for each subscribed torrent.
+	require.NoError(s.UpdatePeer(h2, p))
+	require.Equal(&PeerEvent{h2, p}, <-peers)
+
+	complete := *p
+	complete.Complete = true
+	require.NoError(s.UpdatePeer(h1, &complete))
+	require.Equal(&PeerEvent{h1, &complete}, <-peers)
+
+	cancel()
+	_, ok := <-peers
+	require.False(ok)
+}
+
+func TestNotifierSignalsChangedTorrents(t *testing.T) {
+	require := require.New(t)
+
+	n := newNotifier()
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+
+	_, cancel1 := n.subscribe([]core.InfoHash{h1})
+	<-n.changed
+	require.Equal(map[core.InfoHash]bool{h1: true}, n.hashes())
+
+	// Subscribing to torrents which already have subscribers changes nothing.
+	_, cancel2 := n.subscribe([]core.InfoHash{h1, h2})
+	<-n.changed
+	_, cancel3 := n.subscribe([]core.InfoHash{h2})
+	select {
+	case <-n.changed:
+		require.FailNow("unexpected change")
+	default:
+	}
+	require.Equal(map[core.InfoHash]bool{h1: true, h2: true}, n.hashes())
+
+	cancel2()
+	cancel3()
+	<-n.changed
+	require.Equal(map[core.InfoHash]bool{h1: true}, n.hashes())
+
+	cancel1()
+	<-n.changed
+	require.Empty(n.hashes())
+}
+
+func TestLocalStoreBounds(t *testing.T) {
+	require := require.New(t)
+
+	s := NewLocalStore(LocalConfig{MaxTorrents: 1, MaxPeersPerTorrent: 1}, clock.New())
+	defer s.Close()
+
+	h1 := core.InfoHashFixture()
+	h2 := core.InfoHashFixture()
+	p1 := core.PeerInfoFixture()
+	p2 := core.PeerInfoFixture()
+
+	require.NoError(s.UpdatePeer(h1, p1))
+	require.Equal(ErrTooManyPeers, s.UpdatePeer(h1, p2))
+	require.Equal(ErrTooManyTorrents, s.UpdatePeer(h2, p1))
+
+	// Existing peers can still be updated.
+	p1.Complete = true
+	require.NoError(s.UpdatePeer(h1, p1))
+
+	peers, err := s.GetPeers(h1, 10)
+	require.NoError(err)
+	require.Equal([]*core.PeerInfo{p1}, peers)
+}
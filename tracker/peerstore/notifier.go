@@ -0,0 +1,131 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"sync"
+
+	"github.com/uber/kraken/core"
+)
+
+// _subscriptionBufferSize is the number of peers buffered for each subscriber.
+// Peers are dropped for slow subscribers, which are expected to fall back to
+// polling for any peers they missed.
+const _subscriptionBufferSize = 64
+
+// subscription is a single subscriber of one or more torrents.
+type subscription struct {
+	c chan *PeerEvent
+
+	// Complete bit of each peer already sent to c, per torrent, such that a
+	// peer is only sent again once it completes.
+	sent map[core.InfoHash]map[core.PeerID]bool
+}
+
+// notifier fans peer updates out to subscribers of each torrent.
+type notifier struct {
+	mu   sync.Mutex
+	subs map[core.InfoHash]map[*subscription]struct{}
+
+	// changed is signalled whenever the set of torrents with subscribers
+	// changes.
+	changed chan struct{}
+}
+
+func newNotifier() *notifier {
+	return &notifier{
+		subs:    make(map[core.InfoHash]map[*subscription]struct{}),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// subscribe registers a new subscriber of hashes. The returned channel is
+// closed once cancel is called.
+func (n *notifier) subscribe(hashes []core.InfoHash) (<-chan *PeerEvent, func()) {
+	sub := &subscription{
+		c:    make(chan *PeerEvent, _subscriptionBufferSize),
+		sent: make(map[core.InfoHash]map[core.PeerID]bool),
+	}
+
+	n.mu.Lock()
+	for _, h := range hashes {
+		if _, ok := n.subs[h]; !ok {
+			n.subs[h] = make(map[*subscription]struct{})
+			n.signal()
+		}
+		n.subs[h][sub] = struct{}{}
+		sub.sent[h] = make(map[core.PeerID]bool)
+	}
+	n.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			for h := range sub.sent {
+				delete(n.subs[h], sub)
+				if len(n.subs[h]) == 0 {
+					delete(n.subs, h)
+					n.signal()
+				}
+			}
+			close(sub.c)
+		})
+	}
+	return sub.c, cancel
+}
+
+// signal notifies a pending receiver of changed, if any. Never blocks.
+func (n *notifier) signal() {
+	select {
+	case n.changed <- struct{}{}:
+	default:
+	}
+}
+
+// hashes returns the torrents which have subscribers.
+func (n *notifier) hashes() map[core.InfoHash]bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	hashes := make(map[core.InfoHash]bool, len(n.subs))
+	for h := range n.subs {
+		hashes[h] = true
+	}
+	return hashes
+}
+
+// notify sends peers to all subscribers of h which have not yet seen them in
+// their current state. Never blocks.
+func (n *notifier) notify(h core.InfoHash, peers ...*core.PeerInfo) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for sub := range n.subs[h] {
+		sent := sub.sent[h]
+		for _, p := range peers {
+			if complete, ok := sent[p.PeerID]; ok && (complete || !p.Complete) {
+				continue
+			}
+			c := *p
+			select {
+			case sub.c <- &PeerEvent{h, &c}:
+				sent[p.PeerID] = p.Complete
+			default:
+			}
+		}
+	}
+}
@@ -14,10 +14,15 @@
 package peerstore
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/utils/log"
@@ -31,6 +36,22 @@ func peerSetKey(h core.InfoHash, window int64) string {
 	return fmt.Sprintf("peerset:%s:%d", h.String(), window)
 }
 
+// peerChannel is the channel peers announcing for h are published on.
+func peerChannel(h core.InfoHash) string {
+	return fmt.Sprintf("peers:%s", h.Hex())
+}
+
+// originSetKey indexes the peers of peerSetKey(h, window) which are origins.
+// Kept apart from the peer set, whose encoding older trackers must be able to
+// parse.
+func originSetKey(h core.InfoHash, window int64) string {
+	return fmt.Sprintf("originset:%s:%d", h.String(), window)
+}
+
+func torrentSetKey(window int64, shard int) string {
+	return fmt.Sprintf("torrentset:%d:%d", window, shard)
+}
+
 func serializePeer(p *core.PeerInfo) string {
 	var completeBit int
 	if p.Complete {
@@ -69,6 +90,11 @@ type RedisStore struct {
 	config RedisConfig
 	pool   *redis.Pool
 	clk    clock.Clock
+
+	notifier *notifier
+
+	stopOnce sync.Once
+	stop     chan struct{}
 }
 
 // NewRedisStore creates a new RedisStore.
@@ -96,7 +122,9 @@ func NewRedisStore(config RedisConfig, clk clock.Clock) (*RedisStore, error) {
 			IdleTimeout: config.IdleConnTimeout,
 			Wait:        true,
 		},
-		clk: clk,
+		clk:      clk,
+		notifier: newNotifier(),
+		stop:     make(chan struct{}),
 	}
 
 	// Ensure we can connect to Redis.
@@ -106,17 +134,28 @@ func NewRedisStore(config RedisConfig, clk clock.Clock) (*RedisStore, error) {
 	}
 	c.Close()
 
+	if config.PeerNotifications {
+		go s.listenPeerNotifications()
+	}
+
 	return s, nil
 }
 
 // Close implements Store.
-func (s *RedisStore) Close() {}
+func (s *RedisStore) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
 
 func (s *RedisStore) curPeerSetWindow() int64 {
 	t := s.clk.Now().Unix()
 	return t - (t % int64(s.config.PeerSetWindowSize.Seconds()))
 }
 
+// torrentSetShard returns the shard of the torrent set h is indexed in.
+func (s *RedisStore) torrentSetShard(h core.InfoHash) int {
+	return int(binary.BigEndian.Uint32(h[:4]) % uint32(s.config.TorrentSetShards))
+}
+
 func (s *RedisStore) peerSetWindows() []int64 {
 	cur := s.curPeerSetWindow()
 	ws := make([]int64, s.config.MaxPeerSetWindows)
@@ -143,14 +182,50 @@ func (s *RedisStore) UpdatePeer(h core.InfoHash, p *core.PeerInfo) error {
 	if err := c.Send("EXPIREAT", k, expireAt); err != nil {
 		return fmt.Errorf("send EXPIREAT: %s", err)
 	}
+	cmds := []string{"SADD", "EXPIREAT"}
+
+	if p.Origin {
+		ok := originSetKey(h, w)
+
+		if err := c.Send("SADD", ok, serializePeer(p)); err != nil {
+			return fmt.Errorf("send SADD: %s", err)
+		}
+		if err := c.Send("EXPIREAT", ok, expireAt); err != nil {
+			return fmt.Errorf("send EXPIREAT: %s", err)
+		}
+		cmds = append(cmds, "SADD", "EXPIREAT")
+	}
+
+	// Index h in the current window for HotTorrents.
+	tk := torrentSetKey(w, s.torrentSetShard(h))
+
+	if err := c.Send("SADD", tk, h.String()); err != nil {
+		return fmt.Errorf("send SADD: %s", err)
+	}
+	if err := c.Send("EXPIREAT", tk, expireAt); err != nil {
+		return fmt.Errorf("send EXPIREAT: %s", err)
+	}
+	cmds = append(cmds, "SADD", "EXPIREAT")
+
+	// Publish p to trackers streaming peers of h. Publishing to channels without
+	// subscribers is cheap.
+	if s.config.PeerNotifications {
+		b, err := json.Marshal(p)
+		if err != nil {
+			return fmt.Errorf("json marshal peer: %s", err)
+		}
+		if err := c.Send("PUBLISH", peerChannel(h), b); err != nil {
+			return fmt.Errorf("send PUBLISH: %s", err)
+		}
+		cmds = append(cmds, "PUBLISH")
+	}
 	if err := c.Flush(); err != nil {
 		return fmt.Errorf("flush: %s", err)
 	}
-	if _, err := c.Receive(); err != nil {
-		return fmt.Errorf("SADD: %s", err)
-	}
-	if _, err := c.Receive(); err != nil {
-		return fmt.Errorf("EXPIREAT: %s", err)
+	for _, cmd := range cmds {
+		if _, err := c.Receive(); err != nil {
+			return fmt.Errorf("%s: %s", cmd, err)
+		}
 	}
 	return nil
 }
@@ -197,3 +272,312 @@ func (s *RedisStore) GetPeers(h core.InfoHash, n int) ([]*core.PeerInfo, error)
 	}
 	return peers, nil
 }
+
+// Scrape implements Store.
+func (s *RedisStore) Scrape(h core.InfoHash) (*SwarmStats, error) {
+	c := s.pool.Get()
+	defer c.Close()
+
+	stats, err := s.scrape(c, []core.InfoHash{h})
+	if err != nil {
+		return nil, err
+	}
+	return stats[0], nil
+}
+
+// HotTorrents implements Store. Rather than reading the full index of
+// announced torrents, HotTorrents samples at most HotTorrentCandidates torrents
+// from each window, ranks them by the size of their peer sets, and only scrapes
+// the busiest.
+func (s *RedisStore) HotTorrents(n int) ([]*SwarmStats, error) {
+	c := s.pool.Get()
+	defer c.Close()
+
+	hashes, err := s.sampleTorrents(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(hashes) > n*_hotTorrentsScrapeFactor {
+		hashes, err = s.busiestTorrents(c, hashes, n*_hotTorrentsScrapeFactor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var stats []*SwarmStats
+	for len(hashes) > 0 {
+		batch := hashes
+		if len(batch) > _scrapeBatchSize {
+			batch = batch[:_scrapeBatchSize]
+		}
+		hashes = hashes[len(batch):]
+
+		bstats, err := s.scrape(c, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, st := range bstats {
+			if st.Seeders()+st.Leechers() > 0 {
+				stats = append(stats, st)
+			}
+		}
+	}
+	return Hottest(stats, n), nil
+}
+
+const (
+	// _scrapeBatchSize is the number of torrents scraped in each pipeline.
+	_scrapeBatchSize = 100
+
+	// _hotTorrentsScrapeFactor is the number of candidates scraped per hot
+	// torrent requested. Candidates are ranked by peers rather than leechers,
+	// so a margin is scraped to find the torrents with the most leechers.
+	_hotTorrentsScrapeFactor = 4
+)
+
+// sampleTorrents returns a random sample of the torrents announced in all
+// windows, bounded by HotTorrentCandidates per window.
+func (s *RedisStore) sampleTorrents(c redis.Conn) ([]core.InfoHash, error) {
+	shards := s.config.TorrentSetShards
+	perShard := (s.config.HotTorrentCandidates + shards - 1) / shards
+
+	windows := s.peerSetWindows()
+	for _, w := range windows {
+		for i := 0; i < shards; i++ {
+			if err := c.Send("SRANDMEMBER", torrentSetKey(w, i), perShard); err != nil {
+				return nil, fmt.Errorf("send SRANDMEMBER: %s", err)
+			}
+		}
+	}
+	if err := c.Flush(); err != nil {
+		return nil, fmt.Errorf("flush: %s", err)
+	}
+	seen := make(map[core.InfoHash]bool)
+	var hashes []core.InfoHash
+	for i := 0; i < len(windows)*shards; i++ {
+		result, err := redis.Strings(c.Receive())
+		if err == redis.ErrNil {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("SRANDMEMBER: %s", err)
+		}
+		for _, r := range result {
+			h, err := core.NewInfoHashFromHex(r)
+			if err != nil {
+				log.Errorf("Error parsing torrent set member %q: %s", r, err)
+				continue
+			}
+			if !seen[h] {
+				seen[h] = true
+				hashes = append(hashes, h)
+			}
+		}
+	}
+	return hashes, nil
+}
+
+// busiestTorrents returns the n torrents of hashes with the largest peer sets.
+// Peers are counted once per window they announced in, which is cheap to
+// compute but only approximates the size of each swarm.
+func (s *RedisStore) busiestTorrents(
+	c redis.Conn, hashes []core.InfoHash, n int) ([]core.InfoHash, error) {
+
+	windows := s.peerSetWindows()
+	for _, h := range hashes {
+		for _, w := range windows {
+			if err := c.Send("SCARD", peerSetKey(h, w)); err != nil {
+				return nil, fmt.Errorf("send SCARD: %s", err)
+			}
+		}
+	}
+	if err := c.Flush(); err != nil {
+		return nil, fmt.Errorf("flush: %s", err)
+	}
+	sizes := make(map[core.InfoHash]int, len(hashes))
+	for _, h := range hashes {
+		for range windows {
+			size, err := redis.Int(c.Receive())
+			if err != nil {
+				return nil, fmt.Errorf("SCARD: %s", err)
+			}
+			sizes[h] += size
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return sizes[hashes[i]] > sizes[hashes[j]]
+	})
+	return hashes[:n], nil
+}
+
+// scrape counts the distinct peers across all windows of each torrent in hashes.
+func (s *RedisStore) scrape(c redis.Conn, hashes []core.InfoHash) ([]*SwarmStats, error) {
+	windows := s.peerSetWindows()
+	for _, h := range hashes {
+		for _, w := range windows {
+			if err := c.Send("SMEMBERS", peerSetKey(h, w)); err != nil {
+				return nil, fmt.Errorf("send SMEMBERS: %s", err)
+			}
+			if err := c.Send("SMEMBERS", originSetKey(h, w)); err != nil {
+				return nil, fmt.Errorf("send SMEMBERS: %s", err)
+			}
+		}
+	}
+	if err := c.Flush(); err != nil {
+		return nil, fmt.Errorf("flush: %s", err)
+	}
+	stats := make([]*SwarmStats, len(hashes))
+	for i, h := range hashes {
+		// Collapses the same peer across windows, like GetPeers.
+		complete := make(map[peerIdentity]bool)
+		origins := make(map[peerIdentity]bool)
+		for range windows {
+			for _, set := range []map[peerIdentity]bool{complete, origins} {
+				result, err := redis.Strings(c.Receive())
+				if err != nil {
+					return nil, fmt.Errorf("SMEMBERS: %s", err)
+				}
+				for _, r := range result {
+					id, ok, err := deserializePeer(r)
+					if err != nil {
+						log.Errorf("Error deserializing peer %q: %s", r, err)
+						continue
+					}
+					set[id] = set[id] || ok
+				}
+			}
+		}
+		stats[i] = &SwarmStats{InfoHash: h}
+		for id, ok := range complete {
+			_, origin := origins[id]
+			stats[i].add(origin, ok)
+		}
+	}
+	return stats, nil
+}
+
+// Subscribe implements Store. Subscribers only receive peers if peer
+// notifications are enabled.
+func (s *RedisStore) Subscribe(hashes []core.InfoHash) (<-chan *PeerEvent, func()) {
+	return s.notifier.subscribe(hashes)
+}
+
+// listenPeerNotifications notifies subscribers of peers published by any
+// tracker, until s is closed. Reconnects to Redis on errors.
+func (s *RedisStore) listenPeerNotifications() {
+	for {
+		if err := s.receivePeerNotifications(); err != nil {
+			log.Errorf("Error receiving redis peer notifications: %s", err)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(s.config.DialTimeout):
+		}
+	}
+}
+
+func (s *RedisStore) receivePeerNotifications() error {
+	// Notifications may be arbitrarily far apart, so unlike pooled conns, the
+	// subscribed conn has no read timeout.
+	c, err := redis.Dial(
+		"tcp",
+		s.config.Addr,
+		redis.DialConnectTimeout(s.config.DialTimeout),
+		redis.DialWriteTimeout(s.config.WriteTimeout))
+	if err != nil {
+		return fmt.Errorf("dial: %s", err)
+	}
+	psc := redis.PubSubConn{Conn: c}
+
+	// Only the channels of torrents with local subscribers are subscribed to,
+	// such that trackers do not receive every announce of the cluster. Since
+	// Receive blocks, subscriptions are updated by a separate goroutine, which
+	// also closes the conn once s is closed to unblock Receive.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer psc.Close()
+
+		subscribed := make(map[core.InfoHash]bool)
+		for {
+			if err := syncSubscriptions(psc, subscribed, s.notifier.hashes()); err != nil {
+				log.Errorf("Error updating redis peer subscriptions: %s", err)
+				return
+			}
+			select {
+			case <-s.notifier.changed:
+			case <-s.stop:
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			s.handlePeerNotification(v.Channel, v.Data)
+		case error:
+			select {
+			case <-s.stop:
+				return nil
+			default:
+				return v
+			}
+		}
+	}
+}
+
+// syncSubscriptions subscribes psc to the peer channels of wanted torrents, and
+// unsubscribes it from those no longer wanted. subscribed is updated to match
+// wanted.
+func syncSubscriptions(
+	psc redis.PubSubConn, subscribed map[core.InfoHash]bool, wanted map[core.InfoHash]bool) error {
+
+	var add, remove []interface{}
+	for h := range wanted {
+		if !subscribed[h] {
+			add = append(add, peerChannel(h))
+			subscribed[h] = true
+		}
+	}
+	for h := range subscribed {
+		if !wanted[h] {
+			remove = append(remove, peerChannel(h))
+			delete(subscribed, h)
+		}
+	}
+	// Unsubscribing without channels would unsubscribe from all channels.
+	if len(add) > 0 {
+		if err := psc.Subscribe(add...); err != nil {
+			return fmt.Errorf("subscribe: %s", err)
+		}
+	}
+	if len(remove) > 0 {
+		if err := psc.Unsubscribe(remove...); err != nil {
+			return fmt.Errorf("unsubscribe: %s", err)
+		}
+	}
+	return nil
+}
+
+// handlePeerNotification notifies subscribers of the torrent of channel of the
+// peer published in data.
+func (s *RedisStore) handlePeerNotification(channel string, data []byte) {
+	parts := strings.Split(channel, ":")
+	if len(parts) != 2 || parts[0] != "peers" {
+		return
+	}
+	h, err := core.NewInfoHashFromHex(parts[1])
+	if err != nil {
+		log.Errorf("Error parsing peer channel %q: %s", channel, err)
+		return
+	}
+	p := new(core.PeerInfo)
+	if err := json.Unmarshal(data, p); err != nil {
+		log.Errorf("Error decoding peer notification: %s", err)
+		return
+	}
+	s.notifier.notify(h, p)
+}
@@ -14,6 +14,8 @@
 package peerstore
 
 import (
+	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
@@ -21,6 +23,7 @@ import (
 
 	"github.com/alicebob/miniredis"
 	"github.com/andres-erbsen/clock"
+	"github.com/garyburd/redigo/redis"
 	"github.com/stretchr/testify/require"
 )
 
@@ -179,3 +182,87 @@ func TestRedisStorePeerExpiration(t *testing.T) {
 	require.NoError(err)
 	require.Empty(result)
 }
+
+func TestRedisStoreSerializesOriginsInSeparateSet(t *testing.T) {
+	require := require.New(t)
+
+	config := redisConfigFixture()
+	s, err := NewRedisStore(config, clock.New())
+	require.NoError(err)
+	defer s.Close()
+
+	h := core.InfoHashFixture()
+	p := core.PeerInfoFixture()
+	p.Origin = true
+	p.Complete = true
+	require.NoError(s.UpdatePeer(h, p))
+
+	// Peer sets keep the encoding older trackers parse.
+	c, err := redis.Dial("tcp", config.Addr)
+	require.NoError(err)
+	defer c.Close()
+	w := s.curPeerSetWindow()
+	expected := fmt.Sprintf("%s:%s:%d:1", p.PeerID, p.IP, p.Port)
+	for _, k := range []string{peerSetKey(h, w), originSetKey(h, w)} {
+		result, err := redis.Strings(c.Do("SMEMBERS", k))
+		require.NoError(err)
+		require.Equal([]string{expected}, result)
+	}
+
+	stats, err := s.Scrape(h)
+	require.NoError(err)
+	require.Equal(&SwarmStats{InfoHash: h, OriginSeeders: 1}, stats)
+}
+
+func TestRedisStoreHotTorrentsSamplesCandidates(t *testing.T) {
+	require := require.New(t)
+
+	config := redisConfigFixture()
+	config.TorrentSetShards = 2
+	config.HotTorrentCandidates = 4
+	s, err := NewRedisStore(config, clock.New())
+	require.NoError(err)
+	defer s.Close()
+
+	for i := 0; i < 20; i++ {
+		require.NoError(s.UpdatePeer(core.InfoHashFixture(), core.PeerInfoFixture()))
+	}
+
+	// At most two torrents are sampled from each of the two shards.
+	stats, err := s.HotTorrents(100)
+	require.NoError(err)
+	require.True(len(stats) > 0)
+	require.True(len(stats) <= 4)
+
+	stats, err = s.HotTorrents(1)
+	require.NoError(err)
+	require.Len(stats, 1)
+}
+
+func TestRedisStoreHandlePeerNotification(t *testing.T) {
+	require := require.New(t)
+
+	s, err := NewRedisStore(redisConfigFixture(), clock.New())
+	require.NoError(err)
+	defer s.Close()
+
+	h := core.InfoHashFixture()
+
+	peers, cancel := s.Subscribe([]core.InfoHash{h})
+	defer cancel()
+
+	p := core.PeerInfoFixture()
+	b, err := json.Marshal(p)
+	require.NoError(err)
+
+	// Notifications of other channels are ignored.
+	s.handlePeerNotification("torrents:"+h.Hex(), b)
+	select {
+	case <-peers:
+		require.FailNow("unexpected peer")
+	default:
+	}
+
+	s.handlePeerNotification(peerChannel(h), b)
+	require.Equal(&PeerEvent{h, p}, <-peers)
+}
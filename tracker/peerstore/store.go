@@ -14,10 +14,16 @@
 package peerstore
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
 	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hostlist"
 	"github.com/uber/kraken/utils/log"
 )
 
@@ -32,10 +38,120 @@ type Store interface {
 
 	// UpdatePeer updates peer fields.
 	UpdatePeer(h core.InfoHash, peer *core.PeerInfo) error
+
+	// Scrape returns statistics on the peers announcing for h.
+	Scrape(h core.InfoHash) (*SwarmStats, error)
+
+	// HotTorrents returns statistics on the n torrents with the most leechers.
+	HotTorrents(n int) ([]*SwarmStats, error)
+
+	// Subscribe returns a channel which receives peers as they announce for any
+	// of hashes, and a function which cancels the subscription and closes the
+	// channel. Each peer is received when it first announces for a torrent, and
+	// again once it completes. Peers may be dropped if the channel is not
+	// drained.
+	Subscribe(hashes []core.InfoHash) (<-chan *PeerEvent, func())
+}
+
+// PeerEvent is a peer announcing for a torrent, as received by subscribers.
+type PeerEvent struct {
+	InfoHash core.InfoHash
+	Peer     *core.PeerInfo
+}
+
+// SwarmStats counts the peers announcing for a torrent, split by whether each
+// peer is an origin or an agent, and whether it has completed the torrent.
+type SwarmStats struct {
+	InfoHash       core.InfoHash `json:"-"`
+	OriginSeeders  int           `json:"origin_seeders"`
+	OriginLeechers int           `json:"origin_leechers"`
+	AgentSeeders   int           `json:"agent_seeders"`
+	AgentLeechers  int           `json:"agent_leechers"`
+}
+
+// swarmStatsJSON encodes the info hash of SwarmStats as hex.
+type swarmStatsJSON struct {
+	InfoHash string `json:"info_hash"`
+	*swarmStatsAlias
 }
 
-// New creates a new Store implementation based on config.
-func New(config Config) (Store, error) {
+type swarmStatsAlias SwarmStats
+
+// MarshalJSON encodes s with a hex info hash.
+func (s *SwarmStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(swarmStatsJSON{s.InfoHash.Hex(), (*swarmStatsAlias)(s)})
+}
+
+// UnmarshalJSON decodes s with a hex info hash.
+func (s *SwarmStats) UnmarshalJSON(b []byte) error {
+	v := swarmStatsJSON{swarmStatsAlias: (*swarmStatsAlias)(s)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	h, err := core.NewInfoHashFromHex(v.InfoHash)
+	if err != nil {
+		return fmt.Errorf("parse info hash: %s", err)
+	}
+	s.InfoHash = h
+	return nil
+}
+
+// Seeders returns the number of peers which have completed the torrent.
+func (s *SwarmStats) Seeders() int {
+	return s.OriginSeeders + s.AgentSeeders
+}
+
+// Leechers returns the number of peers which have not completed the torrent.
+func (s *SwarmStats) Leechers() int {
+	return s.OriginLeechers + s.AgentLeechers
+}
+
+// add counts a peer in s.
+func (s *SwarmStats) add(origin, complete bool) {
+	switch {
+	case origin && complete:
+		s.OriginSeeders++
+	case origin:
+		s.OriginLeechers++
+	case complete:
+		s.AgentSeeders++
+	default:
+		s.AgentLeechers++
+	}
+}
+
+// Hottest sorts stats by leechers, then seeders, and returns at most n.
+func Hottest(stats []*SwarmStats, n int) []*SwarmStats {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Leechers() != stats[j].Leechers() {
+			return stats[i].Leechers() > stats[j].Leechers()
+		}
+		return stats[i].Seeders() > stats[j].Seeders()
+	})
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// New creates a new Store implementation based on config. The port the tracker
+// listens on identifies the local tracker in the gossip hosts.
+func New(config Config, stats tally.Scope, port int, tls *tls.Config) (Store, error) {
+	if config.Gossip.Enabled {
+		log.Info("Gossip peer store enabled")
+		if config.Gossip.Secret == "" {
+			return nil, errors.New("gossip secret required")
+		}
+		hosts, err := hostlist.New(config.Gossip.Hosts)
+		if err != nil {
+			return nil, fmt.Errorf("gossip hosts: %s", err)
+		}
+		trackers, err := hostlist.StripLocal(hosts, port)
+		if err != nil {
+			return nil, fmt.Errorf("strip local gossip host: %s", err)
+		}
+		return NewGossipStore(config.Gossip, config.Local, stats, clock.New(), trackers, tls), nil
+	}
 	if config.Redis.Enabled {
 		log.Info("Redis peer store enabled")
 		s, err := NewRedisStore(config.Redis, clock.New())
@@ -0,0 +1,126 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package peerstore
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func testStores(t *testing.T, f func(t *testing.T, s Store)) {
+	t.Run("local", func(t *testing.T) {
+		s := NewLocalStore(LocalConfig{TTL: time.Hour}, clock.New())
+		defer s.Close()
+		f(t, s)
+	})
+	t.Run("redis", func(t *testing.T) {
+		s, err := NewRedisStore(redisConfigFixture(), clock.New())
+		require.NoError(t, err)
+		defer s.Close()
+		f(t, s)
+	})
+}
+
+func peerFixture(origin, complete bool) *core.PeerInfo {
+	p := core.PeerInfoFixture()
+	p.Origin = origin
+	p.Complete = complete
+	return p
+}
+
+func TestStoreScrape(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		require := require.New(t)
+
+		h := core.InfoHashFixture()
+
+		stats, err := s.Scrape(h)
+		require.NoError(err)
+		require.Equal(&SwarmStats{InfoHash: h}, stats)
+
+		peers := []*core.PeerInfo{
+			peerFixture(true, true),
+			peerFixture(false, true),
+			peerFixture(false, true),
+			peerFixture(false, false),
+		}
+		for _, p := range peers {
+			require.NoError(s.UpdatePeer(h, p))
+		}
+		// Updating a peer does not count it twice.
+		peers[3].Complete = true
+		require.NoError(s.UpdatePeer(h, peers[3]))
+
+		stats, err = s.Scrape(h)
+		require.NoError(err)
+		require.Equal(&SwarmStats{
+			InfoHash:      h,
+			OriginSeeders: 1,
+			AgentSeeders:  3,
+		}, stats)
+	})
+}
+
+func TestStoreHotTorrents(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		require := require.New(t)
+
+		var hashes []core.InfoHash
+		for i := 0; i < 4; i++ {
+			h := core.InfoHashFixture()
+			hashes = append(hashes, h)
+			for j := 0; j < i; j++ {
+				require.NoError(s.UpdatePeer(h, peerFixture(false, false)))
+			}
+			require.NoError(s.UpdatePeer(h, peerFixture(false, true)))
+		}
+
+		stats, err := s.HotTorrents(2)
+		require.NoError(err)
+		require.Len(stats, 2)
+		require.Equal(hashes[3], stats[0].InfoHash)
+		require.Equal(3, stats[0].Leechers())
+		require.Equal(hashes[2], stats[1].InfoHash)
+		require.Equal(2, stats[1].Leechers())
+
+		stats, err = s.HotTorrents(10)
+		require.NoError(err)
+		require.Len(stats, 4)
+	})
+}
+
+func TestSwarmStatsJSON(t *testing.T) {
+	require := require.New(t)
+
+	stats := &SwarmStats{
+		InfoHash:       core.InfoHashFixture(),
+		OriginSeeders:  1,
+		OriginLeechers: 2,
+		AgentSeeders:   3,
+		AgentLeechers:  4,
+	}
+	b, err := json.Marshal(stats)
+	require.NoError(err)
+	require.Contains(string(b), `"info_hash":"`+stats.InfoHash.Hex()+`"`)
+
+	var result SwarmStats
+	require.NoError(json.Unmarshal(b, &result))
+	require.Equal(*stats, result)
+}
@@ -23,12 +23,14 @@ import (
 type testStore struct {
 	sync.Mutex
 	torrents map[core.InfoHash][]core.PeerInfo
+	notifier *notifier
 }
 
 // TestStore returns a thread-safe, in-memory peer store for testing purposes.
 func NewTestStore() Store {
 	return &testStore{
 		torrents: make(map[core.InfoHash][]core.PeerInfo),
+		notifier: newNotifier(),
 	}
 }
 
@@ -38,6 +40,8 @@ func (s *testStore) UpdatePeer(h core.InfoHash, p *core.PeerInfo) error {
 	s.Lock()
 	defer s.Unlock()
 
+	s.notifier.notify(h, p)
+
 	peers, ok := s.torrents[h]
 	if !ok {
 		s.torrents[h] = []core.PeerInfo{*p}
@@ -68,3 +72,33 @@ func (s *testStore) GetPeers(h core.InfoHash, n int) ([]*core.PeerInfo, error) {
 	}
 	return copies, nil
 }
+
+func (s *testStore) Scrape(h core.InfoHash) (*SwarmStats, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	stats := &SwarmStats{InfoHash: h}
+	for _, p := range s.torrents[h] {
+		stats.add(p.Origin, p.Complete)
+	}
+	return stats, nil
+}
+
+func (s *testStore) HotTorrents(n int) ([]*SwarmStats, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var stats []*SwarmStats
+	for h, peers := range s.torrents {
+		st := &SwarmStats{InfoHash: h}
+		for _, p := range peers {
+			st.add(p.Origin, p.Complete)
+		}
+		stats = append(stats, st)
+	}
+	return Hottest(stats, n), nil
+}
+
+func (s *testStore) Subscribe(hashes []core.InfoHash) (<-chan *PeerEvent, func()) {
+	return s.notifier.subscribe(hashes)
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package scrapeclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+)
+
+// Torrent identifies a torrent to scrape. Digest determines which tracker owns
+// the torrent.
+type Torrent struct {
+	Digest   core.Digest   `json:"digest"`
+	InfoHash core.InfoHash `json:"info_hash"`
+}
+
+// Request defines a batch scrape request.
+type Request struct {
+	Torrents []Torrent `json:"torrents"`
+}
+
+// Response defines a scrape response.
+type Response struct {
+	Torrents []*peerstore.SwarmStats `json:"torrents"`
+}
+
+// Client defines a client for scraping swarm statistics from trackers.
+type Client interface {
+	Scrape(d core.Digest, h core.InfoHash) (*peerstore.SwarmStats, error)
+	ScrapeBatch(torrents []Torrent) ([]*peerstore.SwarmStats, error)
+	HotTorrents(n int) ([]*peerstore.SwarmStats, error)
+}
+
+type client struct {
+	ring     hashring.PassiveRing
+	trackers hostlist.List
+	tls      *tls.Config
+}
+
+// New creates a new Client. Torrents are scraped from the tracker which owns
+// them in ring, whereas hot torrents are listed from every tracker in trackers.
+func New(ring hashring.PassiveRing, trackers hostlist.List, tls *tls.Config) Client {
+	return &client{ring, trackers, tls}
+}
+
+// Scrape returns the swarm statistics of h.
+func (c *client) Scrape(d core.Digest, h core.InfoHash) (*peerstore.SwarmStats, error) {
+	stats, err := c.ScrapeBatch([]Torrent{{d, h}})
+	if err != nil {
+		return nil, err
+	}
+	return stats[0], nil
+}
+
+// ScrapeBatch returns the swarm statistics of torrents, in the same order.
+// Torrents are grouped into a single request per tracker.
+func (c *client) ScrapeBatch(torrents []Torrent) ([]*peerstore.SwarmStats, error) {
+	groups := make(map[string][]Torrent)
+	var addrs []string
+	for _, t := range torrents {
+		locs := c.ring.Locations(t.Digest)
+		if len(locs) == 0 {
+			return nil, errors.New("no trackers available")
+		}
+		if _, ok := groups[locs[0]]; !ok {
+			addrs = append(addrs, locs[0])
+		}
+		groups[locs[0]] = append(groups[locs[0]], t)
+	}
+	byHash := make(map[core.InfoHash]*peerstore.SwarmStats)
+	for _, addr := range addrs {
+		b, err := json.Marshal(&Request{groups[addr]})
+		if err != nil {
+			return nil, fmt.Errorf("json marshal: %s", err)
+		}
+		resp, err := httputil.Post(
+			fmt.Sprintf("http://%s/scrape", addr),
+			httputil.SendBody(bytes.NewReader(b)),
+			httputil.SendTimeout(30*time.Second),
+			httputil.SendTLS(c.tls))
+		if err != nil {
+			if httputil.IsNetworkError(err) {
+				c.ring.Failed(addr)
+			}
+			return nil, err
+		}
+		var r Response
+		err = json.NewDecoder(resp.Body).Decode(&r)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("json decode: %s", err)
+		}
+		for _, st := range r.Torrents {
+			byHash[st.InfoHash] = st
+		}
+	}
+	result := make([]*peerstore.SwarmStats, len(torrents))
+	for i, t := range torrents {
+		st, ok := byHash[t.InfoHash]
+		if !ok {
+			st = &peerstore.SwarmStats{InfoHash: t.InfoHash}
+		}
+		result[i] = st
+	}
+	return result, nil
+}
+
+// HotTorrents returns the n torrents with the most leechers across all trackers.
+// Trackers which fail are skipped, unless all of them fail.
+func (c *client) HotTorrents(n int) ([]*peerstore.SwarmStats, error) {
+	byHash := make(map[core.InfoHash]*peerstore.SwarmStats)
+	var err error
+	var succeeded bool
+	for addr := range c.trackers.Resolve() {
+		var stats []*peerstore.SwarmStats
+		stats, err = c.hotTorrents(addr, n)
+		if err != nil {
+			log.With("tracker", addr).Errorf("Error listing hot torrents: %s", err)
+			continue
+		}
+		succeeded = true
+		for _, st := range stats {
+			// Trackers sharing a peer store report the same torrents.
+			if cur, ok := byHash[st.InfoHash]; !ok || st.Leechers() > cur.Leechers() {
+				byHash[st.InfoHash] = st
+			}
+		}
+	}
+	if !succeeded {
+		if err == nil {
+			err = errors.New("no trackers available")
+		}
+		return nil, err
+	}
+	stats := make([]*peerstore.SwarmStats, 0, len(byHash))
+	for _, st := range byHash {
+		stats = append(stats, st)
+	}
+	return peerstore.Hottest(stats, n), nil
+}
+
+func (c *client) hotTorrents(addr string, n int) ([]*peerstore.SwarmStats, error) {
+	resp, err := httputil.Get(
+		fmt.Sprintf("http://%s/scrape/hot?limit=%d", addr, n),
+		httputil.SendTimeout(30*time.Second),
+		httputil.SendTLS(c.tls))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var r Response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("json decode: %s", err)
+	}
+	return r.Torrents, nil
+}
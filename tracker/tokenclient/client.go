@@ -0,0 +1,77 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package tokenclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+)
+
+// Client fetches peer tokens from trackers. It implements peertoken.Source.
+type Client struct {
+	ring hashring.PassiveRing
+	tls  *tls.Config
+}
+
+// New creates a new Client. Tokens of a peer are fetched from the trackers
+// which own its peer id in ring.
+func New(ring hashring.PassiveRing, tls *tls.Config) *Client {
+	return &Client{ring, tls}
+}
+
+// FetchToken requests a token for peerID from a tracker. The tracker binds the
+// token to the address it observes the request from.
+func (c *Client) FetchToken(peerID core.PeerID) (string, error) {
+	d, err := core.NewDigester().FromBytes([]byte(peerID.String()))
+	if err != nil {
+		return "", fmt.Errorf("digest peer id: %s", err)
+	}
+	v := url.Values{}
+	v.Set("peer_id", peerID.String())
+	var lastErr error
+	for _, addr := range c.ring.Locations(d) {
+		resp, err := httputil.Post(
+			fmt.Sprintf("http://%s/peertoken?%s", addr, v.Encode()),
+			httputil.SendTimeout(10*time.Second),
+			httputil.SendTLS(c.tls))
+		if err != nil {
+			if httputil.IsNetworkError(err) {
+				c.ring.Failed(addr)
+				log.With("tracker", addr).Errorf("Error fetching peer token: %s", err)
+				lastErr = err
+				continue
+			}
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return "", fmt.Errorf("read body: %s", err)
+		}
+		return string(b), nil
+	}
+	if lastErr == nil {
+		return "", errors.New("no trackers")
+	}
+	return "", lastErr
+}
@@ -35,7 +35,10 @@ func (s *Server) announceHandlerV1(w http.ResponseWriter, r *http.Request) error
 	if err != nil {
 		return handler.Errorf("get request digest: %s", err)
 	}
-	resp, err := s.announce(d, req.InfoHash, req.Peer)
+	if err := s.checkToken(req.Token, req.Peer); err != nil {
+		return err
+	}
+	resp, err := s.announce(d, req.InfoHash, req.Peer, s.proxies.clientIP(r), req.Reputations, req.Load)
 	if err != nil {
 		return err
 	}
@@ -62,7 +65,10 @@ func (s *Server) announceHandlerV2(w http.ResponseWriter, r *http.Request) error
 	if err != nil {
 		return handler.Errorf("get request digest: %s", err)
 	}
-	resp, err := s.announce(d, h, req.Peer)
+	if err := s.checkToken(req.Token, req.Peer); err != nil {
+		return err
+	}
+	resp, err := s.announce(d, h, req.Peer, s.proxies.clientIP(r), req.Reputations, req.Load)
 	if err != nil {
 		return err
 	}
@@ -72,26 +78,138 @@ func (s *Server) announceHandlerV2(w http.ResponseWriter, r *http.Request) error
 	return nil
 }
 
-func (s *Server) announce(
-	d core.Digest, h core.InfoHash, peer *core.PeerInfo) (*announceclient.Response, error) {
+func (s *Server) announceBundleHandler(w http.ResponseWriter, r *http.Request) error {
+	req := new(announceclient.BundleRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return handler.Errorf("json decode request: %s", err).Status(http.StatusBadRequest)
+	}
+	// A bundle is announced like a batch, with the manifest only used for logging.
+	batch := &announceclient.BatchRequest{
+		Peer:        req.Peer,
+		Torrents:    req.Torrents,
+		Reputations: req.Reputations,
+		Token:       req.Token,
+		Load:        req.Load,
+	}
+	return s.serveBatch(w, r, batch, "bundle", s.config.MaxBundleSize, "manifest", req.Manifest)
+}
 
-	// 更新 peer 信息
-	if err := s.peerStore.UpdatePeer(h, peer); err != nil {
-		log.With(
-			"hash", h,
-			"peer_id", peer.PeerID).Errorf("Error updating peer: %s", err)
+func (s *Server) announceBatchHandler(w http.ResponseWriter, r *http.Request) error {
+	req := new(announceclient.BatchRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return handler.Errorf("json decode request: %s", err).Status(http.StatusBadRequest)
+	}
+	return s.serveBatch(w, r, req, "batch", s.config.MaxBatchSize)
+}
+
+// serveBatch announces each torrent of req, a batch of the given kind limited
+// to limit torrents, and writes their peer handouts. Any logArgs are attached to
+// handout errors.
+func (s *Server) serveBatch(
+	w http.ResponseWriter,
+	r *http.Request,
+	req *announceclient.BatchRequest,
+	kind string,
+	limit int,
+	logArgs ...interface{}) error {
+
+	if err := s.checkToken(req.Token, req.Peer); err != nil {
+		return err
+	}
+	if len(req.Torrents) > limit {
+		return handler.Errorf("%s exceeds %d torrents", kind, limit).Status(http.StatusBadRequest)
+	}
+	torrents := s.announceTorrents(
+		req.Peer, s.proxies.clientIP(r), req.Reputations, req.Load, req.Torrents, logArgs...)
+	s.stats.Counter(kind + "_torrents").Inc(int64(len(req.Torrents)))
+	resp := &announceclient.BatchResponse{
+		Torrents: torrents,
+		Interval: s.announceInterval(),
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return handler.Errorf("json encode response: %s", err)
 	}
-	peers, err := s.getPeerHandout(d, h, peer)
+	return nil
+}
+
+// checkToken rejects announces of peers without a valid token.
+func (s *Server) checkToken(token string, peer *core.PeerInfo) error {
+	if peer == nil {
+		return handler.Errorf("no peer supplied").Status(http.StatusBadRequest)
+	}
+	if err := s.tokens.Check(token, peer.PeerID, peer.IP); err != nil {
+		return handler.Errorf("peer token: %s", err).Status(http.StatusForbidden)
+	}
+	return nil
+}
+
+func (s *Server) announce(
+	d core.Digest,
+	h core.InfoHash,
+	peer *core.PeerInfo,
+	addr string,
+	reputations []core.PeerReputation,
+	load *core.PeerLoad) (*announceclient.Response, error) {
+
+	s.policy.Report(peer, addr, reputations)
+	s.policy.ReportLoad(peer, load)
+
+	peers, err := s.updatePeer(d, h, peer)
 	if err != nil {
 		return nil, err
 	}
 	// 下次发起announce的间隔默认3秒钟
 	return &announceclient.Response{
 		Peers:    peers,
-		Interval: s.config.AnnounceInterval,
+		Interval: s.announceInterval(),
 	}, nil
 }
 
+// announceTorrents announces peer, received from addr, for each of torrents and
+// returns their peer handouts. Torrents without any peers available are
+// returned with an empty handout rather than failing the remaining torrents.
+// Any logArgs are attached to handout errors.
+func (s *Server) announceTorrents(
+	peer *core.PeerInfo,
+	addr string,
+	reputations []core.PeerReputation,
+	load *core.PeerLoad,
+	torrents []announceclient.AnnounceTorrent,
+	logArgs ...interface{}) []announceclient.TorrentPeers {
+
+	s.policy.Report(peer, addr, reputations)
+	s.policy.ReportLoad(peer, load)
+
+	result := make([]announceclient.TorrentPeers, 0, len(torrents))
+	for _, t := range torrents {
+		p := *peer
+		p.Complete = t.Complete
+		peers, err := s.updatePeer(t.Digest, t.InfoHash, &p)
+		if err != nil {
+			log.With(append(logArgs, "hash", t.InfoHash)...).Infof(
+				"Error getting peer handout: %s", err)
+		}
+		result = append(result, announceclient.TorrentPeers{
+			InfoHash: t.InfoHash,
+			Peers:    peers,
+		})
+	}
+	return result
+}
+
+// updatePeer records peer as announcing h and returns its peer handout.
+func (s *Server) updatePeer(
+	d core.Digest, h core.InfoHash, peer *core.PeerInfo) ([]*core.PeerInfo, error) {
+
+	// 更新 peer 信息
+	if err := s.peerStore.UpdatePeer(h, peer); err != nil {
+		log.With(
+			"hash", h,
+			"peer_id", peer.PeerID).Errorf("Error updating peer: %s", err)
+	}
+	return s.getPeerHandout(d, h, peer)
+}
+
 func (s *Server) getPeerHandout(
 	d core.Digest, h core.InfoHash, peer *core.PeerInfo) ([]*core.PeerInfo, error) {
 
@@ -21,12 +21,18 @@ import (
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/lib/healthcheck"
 	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/lib/peertoken"
 	"github.com/uber/kraken/tracker/announceclient"
+	"github.com/uber/kraken/tracker/peerhandoutpolicy"
+	"github.com/uber/kraken/utils/httputil"
 	"github.com/uber/kraken/utils/testutil"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 )
 
 func newAnnounceClient(pctx core.PeerContext, addr string) announceclient.Client {
@@ -125,6 +131,109 @@ func TestAnnouceUnavailableOriginClusterCanStillProvidePeers(t *testing.T) {
 	require.Equal(peers, result)
 }
 
+type staticLoad core.PeerLoad
+
+func (l staticLoad) Load() *core.PeerLoad {
+	load := core.PeerLoad(l)
+	return &load
+}
+
+func TestAnnounceLoadBalancesSeeders(t *testing.T) {
+	for _, version := range []int{announceclient.V1, announceclient.V2} {
+		t.Run(fmt.Sprintf("V%d", version), func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, cleanup := newServerMocks(t, Config{})
+			defer cleanup()
+
+			policy, err := peerhandoutpolicy.NewPriorityPolicy(
+				tally.NoopScope,
+				"load",
+				peerhandoutpolicy.WithLoads(peerhandoutpolicy.NewLoads(
+					peerhandoutpolicy.LoadConfig{}, clock.New())))
+			require.NoError(err)
+			mocks.policy = policy
+
+			addr, stop := testutil.StartServer(mocks.handler())
+			defer stop()
+
+			blob := core.NewBlobFixture()
+			h := blob.MetaInfo.InfoHash()
+
+			busyCtx := core.PeerContextFixture()
+			idleCtx := core.PeerContextFixture()
+			busy := core.PeerInfoFromContext(busyCtx, true)
+			idle := core.PeerInfoFromContext(idleCtx, true)
+
+			mocks.peerStore.EXPECT().UpdatePeer(h, gomock.Any()).Return(nil).Times(3)
+			mocks.peerStore.EXPECT().GetPeers(
+				h, gomock.Any()).Return([]*core.PeerInfo{busy, idle}, nil)
+			mocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(nil, nil)
+
+			for ctx, load := range map[core.PeerContext]staticLoad{
+				busyCtx: {EgressUtilization: 0.9, ActiveUploads: 15},
+				idleCtx: {EgressUtilization: 0.1, ActiveUploads: 1},
+			} {
+				client := announceclient.New(
+					ctx, hashring.NoopPassiveRing(hostlist.Fixture(addr)), nil,
+					announceclient.WithLoadReporter(load))
+				_, _, err := client.Announce(blob.Digest, h, true, version)
+				require.NoError(err)
+			}
+
+			client := newAnnounceClient(core.PeerContextFixture(), addr)
+			result, _, err := client.Announce(blob.Digest, h, false, version)
+			require.NoError(err)
+			require.Equal([]*core.PeerInfo{idle, busy}, result)
+		})
+	}
+}
+
+func TestAnnounceReputationsExcludeReportedPeers(t *testing.T) {
+	for _, version := range []int{announceclient.V1, announceclient.V2} {
+		t.Run(fmt.Sprintf("V%d", version), func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, cleanup := newServerMocks(t, Config{})
+			defer cleanup()
+
+			policy, err := peerhandoutpolicy.NewPriorityPolicy(
+				tally.NoopScope,
+				"default",
+				peerhandoutpolicy.WithReputations(peerhandoutpolicy.NewReputations(
+					peerhandoutpolicy.ReputationConfig{MinReporters: 1}, clock.New())))
+			require.NoError(err)
+			mocks.policy = policy
+
+			addr, stop := testutil.StartServer(mocks.handler())
+			defer stop()
+
+			blob := core.NewBlobFixture()
+			h := blob.MetaInfo.InfoHash()
+
+			good := core.PeerInfoFixture()
+			bad := core.PeerInfoFixture()
+
+			mocks.peerStore.EXPECT().UpdatePeer(h, gomock.Any()).Return(nil).Times(2)
+			mocks.peerStore.EXPECT().GetPeers(
+				h, gomock.Any()).Return([]*core.PeerInfo{good, bad}, nil).Times(2)
+			mocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(nil, nil).Times(2)
+
+			reporter := newAnnounceClient(core.PeerContextFixture(), addr)
+			result, _, err := reporter.Announce(
+				blob.Digest, h, false, version, core.PeerReputation{PeerID: bad.PeerID, Score: 0})
+			require.NoError(err)
+			require.Equal([]*core.PeerInfo{good}, result)
+
+			// The bad peer is excluded for other peers too.
+			client := newAnnounceClient(core.PeerContextFixture(), addr)
+			result, _, err = client.Announce(blob.Digest, h, false, version)
+			require.NoError(err)
+			require.Equal([]*core.PeerInfo{good}, result)
+		})
+	}
+}
+
 func TestAnnounceRequestGetDigestBackwardsCompatibility(t *testing.T) {
 	d := core.DigestFixture()
 	h := core.InfoHashFixture()
@@ -168,3 +277,265 @@ func TestAnnounceRequestGetDigestBackwardsCompatibility(t *testing.T) {
 		})
 	}
 }
+
+func TestAnnounceBundle(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{AnnounceInterval: 5 * time.Second}
+
+	mocks, cleanup := newServerMocks(t, config)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	pctx := core.PeerContextFixture()
+	client := newAnnounceClient(pctx, addr)
+
+	manifest := core.DigestFixture()
+	b1 := core.NewBlobFixture()
+	b2 := core.NewBlobFixture()
+
+	peers := []*core.PeerInfo{core.PeerInfoFixture()}
+
+	mocks.originStore.EXPECT().GetOrigins(b1.Digest).Return(nil, nil)
+	mocks.peerStore.EXPECT().GetPeers(
+		b1.MetaInfo.InfoHash(), gomock.Any()).Return(peers, nil)
+	mocks.peerStore.EXPECT().UpdatePeer(
+		b1.MetaInfo.InfoHash(), core.PeerInfoFromContext(pctx, false)).Return(nil)
+
+	// Complete torrents get an empty handout.
+	mocks.peerStore.EXPECT().UpdatePeer(
+		b2.MetaInfo.InfoHash(), core.PeerInfoFromContext(pctx, true)).Return(nil)
+
+	result, interval, err := client.AnnounceBundle(manifest, []announceclient.AnnounceTorrent{
+		{Digest: b1.Digest, InfoHash: b1.MetaInfo.InfoHash(), Complete: false},
+		{Digest: b2.Digest, InfoHash: b2.MetaInfo.InfoHash(), Complete: true},
+	})
+	require.NoError(err)
+	require.Equal(config.AnnounceInterval, interval)
+	require.Equal(peers, result[b1.MetaInfo.InfoHash()])
+	require.Empty(result[b2.MetaInfo.InfoHash()])
+}
+
+func TestAnnounceBundleRejectsOversizedBundles(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{MaxBundleSize: 1})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	client := newAnnounceClient(core.PeerContextFixture(), addr)
+
+	var torrents []announceclient.AnnounceTorrent
+	for i := 0; i < 2; i++ {
+		b := core.NewBlobFixture()
+		torrents = append(torrents, announceclient.AnnounceTorrent{
+			Digest: b.Digest, InfoHash: b.MetaInfo.InfoHash()})
+	}
+	_, _, err := client.AnnounceBundle(core.DigestFixture(), torrents)
+	require.Error(err)
+	require.NotEqual(announceclient.ErrBundlesUnsupported, err)
+}
+
+func TestAnnounceBatch(t *testing.T) {
+	require := require.New(t)
+
+	config := Config{AnnounceInterval: 5 * time.Second}
+	mocks, cleanup := newServerMocks(t, config)
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	pctx := core.PeerContextFixture()
+	client := newAnnounceClient(pctx, addr)
+
+	b1 := core.NewBlobFixture()
+	b2 := core.NewBlobFixture()
+
+	peers := []*core.PeerInfo{core.PeerInfoFixture()}
+
+	mocks.originStore.EXPECT().GetOrigins(b1.Digest).Return(nil, nil)
+	mocks.peerStore.EXPECT().GetPeers(
+		b1.MetaInfo.InfoHash(), gomock.Any()).Return(peers, nil)
+	mocks.peerStore.EXPECT().UpdatePeer(
+		b1.MetaInfo.InfoHash(), core.PeerInfoFromContext(pctx, false)).Return(nil)
+
+	mocks.peerStore.EXPECT().UpdatePeer(
+		b2.MetaInfo.InfoHash(), core.PeerInfoFromContext(pctx, true)).Return(nil)
+
+	result, interval, err := client.AnnounceBatch([]announceclient.AnnounceTorrent{
+		{Digest: b1.Digest, InfoHash: b1.MetaInfo.InfoHash(), Complete: false},
+		{Digest: b2.Digest, InfoHash: b2.MetaInfo.InfoHash(), Complete: true},
+	})
+	require.NoError(err)
+	require.Equal(config.AnnounceInterval, interval)
+	require.Equal(peers, result[b1.MetaInfo.InfoHash()])
+	require.Empty(result[b2.MetaInfo.InfoHash()])
+}
+
+func TestAnnounceBatchRetriesNextTrackerOnNetworkError(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	deadAddr, stopDead := testutil.StartServer(mocks.handler())
+	stopDead()
+
+	pctx := core.PeerContextFixture()
+	ring := hashring.NewPassive(
+		hashring.Config{MaxReplica: 2},
+		hostlist.Fixture(addr, deadAddr),
+		healthcheck.IdentityFilter{})
+	client := announceclient.New(pctx, ring, nil)
+
+	// Find a blob owned by the dead tracker first.
+	b := core.NewBlobFixture()
+	for ring.Locations(b.Digest)[0] != deadAddr {
+		b = core.NewBlobFixture()
+	}
+
+	mocks.peerStore.EXPECT().UpdatePeer(
+		b.MetaInfo.InfoHash(), core.PeerInfoFromContext(pctx, true)).Return(nil)
+
+	result, _, err := client.AnnounceBatch([]announceclient.AnnounceTorrent{
+		{Digest: b.Digest, InfoHash: b.MetaInfo.InfoHash(), Complete: true},
+	})
+	require.NoError(err)
+	require.Contains(result, b.MetaInfo.InfoHash())
+}
+
+func TestAnnounceBatchRejectsOversizedBatches(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{MaxBatchSize: 1})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	client := newAnnounceClient(core.PeerContextFixture(), addr)
+
+	var torrents []announceclient.AnnounceTorrent
+	for i := 0; i < 2; i++ {
+		b := core.NewBlobFixture()
+		torrents = append(torrents, announceclient.AnnounceTorrent{
+			Digest: b.Digest, InfoHash: b.MetaInfo.InfoHash()})
+	}
+	_, _, err := client.AnnounceBatch(torrents)
+	require.Error(err)
+	require.NotEqual(announceclient.ErrBatchUnsupported, err)
+}
+
+func newTokenMocks(t *testing.T) (*serverMocks, peertoken.Config, func()) {
+	config := peertoken.ConfigFixture()
+	v, err := peertoken.NewVerifier(config, clock.New(), tally.NoopScope)
+	require.NoError(t, err)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	mocks.options = append(mocks.options, WithTokenVerifier(v))
+	return mocks, config, cleanup
+}
+
+func newTokenAnnounceClient(
+	t *testing.T, config peertoken.Config, issuedTo, pctx core.PeerContext, addr string) announceclient.Client {
+
+	i := peertoken.IssuerFixture(config, issuedTo, issuedTo.IP)
+	return announceclient.New(
+		pctx, hashring.NoopPassiveRing(hostlist.Fixture(addr)), nil, announceclient.WithTokenIssuer(i))
+}
+
+func TestAnnounceWithValidToken(t *testing.T) {
+	for _, version := range []int{announceclient.V1, announceclient.V2} {
+		t.Run(fmt.Sprintf("V%d", version), func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, tokenConfig, cleanup := newTokenMocks(t)
+			defer cleanup()
+
+			addr, stop := testutil.StartServer(mocks.handler())
+			defer stop()
+
+			blob := core.NewBlobFixture()
+			pctx := core.PeerContextFixture()
+
+			client := newTokenAnnounceClient(t, tokenConfig, pctx, pctx, addr)
+
+			peers := []*core.PeerInfo{core.PeerInfoFixture()}
+
+			mocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(nil, nil)
+			mocks.peerStore.EXPECT().GetPeers(
+				blob.MetaInfo.InfoHash(), gomock.Any()).Return(peers, nil)
+			mocks.peerStore.EXPECT().UpdatePeer(
+				blob.MetaInfo.InfoHash(), core.PeerInfoFromContext(pctx, false)).Return(nil)
+
+			result, _, err := client.Announce(blob.Digest, blob.MetaInfo.InfoHash(), false, version)
+			require.NoError(err)
+			require.Equal(peers, result)
+		})
+	}
+}
+
+func TestAnnounceRejectsInvalidTokens(t *testing.T) {
+	pctx := core.PeerContextFixture()
+
+	tests := []struct {
+		desc   string
+		client func(t *testing.T, config peertoken.Config, addr string) announceclient.Client
+	}{
+		{
+			"missing token",
+			func(t *testing.T, config peertoken.Config, addr string) announceclient.Client {
+				return newAnnounceClient(pctx, addr)
+			},
+		}, {
+			"token of other peer",
+			func(t *testing.T, config peertoken.Config, addr string) announceclient.Client {
+				return newTokenAnnounceClient(t, config, core.PeerContextFixture(), pctx, addr)
+			},
+		}, {
+			"token signed with unknown key",
+			func(t *testing.T, config peertoken.Config, addr string) announceclient.Client {
+				return newTokenAnnounceClient(t, peertoken.ConfigFixture(), pctx, pctx, addr)
+			},
+		}, {
+			"token bound to other ip",
+			func(t *testing.T, config peertoken.Config, addr string) announceclient.Client {
+				other := pctx
+				other.IP = "10.0.0.1"
+				return newTokenAnnounceClient(t, config, other, pctx, addr)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, tokenConfig, cleanup := newTokenMocks(t)
+			defer cleanup()
+
+			addr, stop := testutil.StartServer(mocks.handler())
+			defer stop()
+
+			client := test.client(t, tokenConfig, addr)
+			blob := core.NewBlobFixture()
+
+			for _, version := range []int{announceclient.V1, announceclient.V2} {
+				_, _, err := client.Announce(blob.Digest, blob.MetaInfo.InfoHash(), false, version)
+				require.True(httputil.IsForbidden(err))
+			}
+
+			_, _, err := client.AnnounceBatch([]announceclient.AnnounceTorrent{
+				{Digest: blob.Digest, InfoHash: blob.MetaInfo.InfoHash()},
+			})
+			require.True(httputil.IsForbidden(err))
+		})
+	}
+}
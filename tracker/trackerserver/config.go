@@ -29,9 +29,96 @@ type Config struct {
 
 	AnnounceInterval time.Duration `yaml:"announce_interval"`
 
+	// Limits the number of torrents in each bundle announce.
+	MaxBundleSize int `yaml:"max_bundle_size"`
+
+	// Limits the number of torrents in each batch announce.
+	MaxBatchSize int `yaml:"max_batch_size"`
+
+	// Limits the number of torrents in each scrape and hot torrents listing.
+	MaxScrapeSize int `yaml:"max_scrape_size"`
+
+	// Limits the number of concurrently open peer streams.
+	MaxPeerStreams int `yaml:"max_peer_streams"`
+
+	// Interval of keep-alive messages sent on idle peer streams.
+	PeerStreamKeepAlive time.Duration `yaml:"peer_stream_keepalive"`
+
+	// Delay clients are asked to wait before retrying peer streams rejected
+	// because MaxPeerStreams was reached.
+	PeerStreamRetryAfter time.Duration `yaml:"peer_stream_retry_after"`
+
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	// TrustedProxies lists the IPs or CIDRs of reverse proxies, e.g. nginx,
+	// whose X-Real-IP header is trusted as the client address. Requests over
+	// unix sockets are always trusted. Defaults to loopback addresses.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
 	Listener listener.Config `yaml:"listener"`
 }
 
+// RateLimitConfig defines per-peer and per-IP limits of announce and metainfo
+// requests, and the adaptive announce interval handed out when the tracker is
+// overloaded.
+type RateLimitConfig struct {
+	Enable bool `yaml:"enable"`
+
+	// Limits the announce rate of each PeerID, including bundle and batch
+	// announces.
+	PeerAnnouncesPerSec float64 `yaml:"peer_announces_per_sec"`
+	PeerAnnounceBurst   int     `yaml:"peer_announce_burst"`
+
+	// Limits the announce rate of each client IP.
+	IPAnnouncesPerSec float64 `yaml:"ip_announces_per_sec"`
+	IPAnnounceBurst   int     `yaml:"ip_announce_burst"`
+
+	// Limits the metainfo request rate of each client IP.
+	IPMetaInfoPerSec float64 `yaml:"ip_metainfo_per_sec"`
+	IPMetaInfoBurst  int     `yaml:"ip_metainfo_burst"`
+
+	// TargetAnnouncesPerSec is the total announce rate the tracker is sized
+	// for. Above it, the announce interval handed out grows in proportion to
+	// the announce rate, up to MaxAnnounceInterval.
+	TargetAnnouncesPerSec float64       `yaml:"target_announces_per_sec"`
+	MaxAnnounceInterval   time.Duration `yaml:"max_announce_interval"`
+
+	// Limits the number of PeerIDs and IPs tracked by each limit. Requests of
+	// untracked keys share a single limit while full.
+	MaxKeys int `yaml:"max_keys"`
+}
+
+func (c RateLimitConfig) applyDefaults() RateLimitConfig {
+	if c.PeerAnnouncesPerSec == 0 {
+		c.PeerAnnouncesPerSec = 2
+	}
+	if c.PeerAnnounceBurst == 0 {
+		c.PeerAnnounceBurst = 10
+	}
+	if c.IPAnnouncesPerSec == 0 {
+		c.IPAnnouncesPerSec = 10
+	}
+	if c.IPAnnounceBurst == 0 {
+		c.IPAnnounceBurst = 50
+	}
+	if c.IPMetaInfoPerSec == 0 {
+		c.IPMetaInfoPerSec = 10
+	}
+	if c.IPMetaInfoBurst == 0 {
+		c.IPMetaInfoBurst = 50
+	}
+	if c.TargetAnnouncesPerSec == 0 {
+		c.TargetAnnouncesPerSec = 2000
+	}
+	if c.MaxAnnounceInterval == 0 {
+		c.MaxAnnounceInterval = 30 * time.Second
+	}
+	if c.MaxKeys == 0 {
+		c.MaxKeys = 100000
+	}
+	return c
+}
+
 func (c Config) applyDefaults() Config {
 	if c.GetMetaInfoLimit == 0 {
 		c.GetMetaInfoLimit = time.Second
@@ -42,5 +129,27 @@ func (c Config) applyDefaults() Config {
 	if c.AnnounceInterval == 0 {
 		c.AnnounceInterval = 3 * time.Second
 	}
+	if c.MaxBundleSize == 0 {
+		c.MaxBundleSize = 200
+	}
+	if c.MaxBatchSize == 0 {
+		c.MaxBatchSize = 200
+	}
+	if c.MaxScrapeSize == 0 {
+		c.MaxScrapeSize = 1000
+	}
+	if c.MaxPeerStreams == 0 {
+		c.MaxPeerStreams = 10000
+	}
+	if c.PeerStreamKeepAlive == 0 {
+		c.PeerStreamKeepAlive = 15 * time.Second
+	}
+	if c.PeerStreamRetryAfter == 0 {
+		c.PeerStreamRetryAfter = time.Minute
+	}
+	if c.TrustedProxies == nil {
+		c.TrustedProxies = []string{"127.0.0.1", "::1"}
+	}
+	c.RateLimit = c.RateLimit.applyDefaults()
 	return c
 }
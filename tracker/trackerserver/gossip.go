@@ -0,0 +1,56 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/utils/handler"
+)
+
+// _maxGossipBodySize bounds the size of a single push.
+const _maxGossipBodySize = 32 << 20
+
+// gossipHandler applies peer updates pushed by other trackers. Returns 404 if
+// the peer store does not replicate updates, and 403 if the push is not signed
+// by another tracker.
+func (s *Server) gossipHandler(w http.ResponseWriter, r *http.Request) error {
+	replica, ok := s.peerStore.(peerstore.Replica)
+	if !ok {
+		return handler.Errorf("peer store does not accept replicated updates").Status(http.StatusNotFound)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, _maxGossipBodySize+1))
+	if err != nil {
+		return handler.Errorf("read body: %s", err)
+	}
+	if len(body) > _maxGossipBodySize {
+		return handler.Errorf("gossip request too large").Status(http.StatusRequestEntityTooLarge)
+	}
+	if err := replica.Authenticate(r.Header, body); err != nil {
+		s.stats.Counter("gossip_unauthorized").Inc(1)
+		return handler.Errorf("%s", err).Status(http.StatusForbidden)
+	}
+	var req peerstore.GossipRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return handler.Errorf("json decode request: %s", err).Status(http.StatusBadRequest)
+	}
+	if err := replica.ApplyUpdates(req.Updates); err != nil {
+		return handler.Errorf("apply updates: %s", err).Status(http.StatusBadRequest)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/tracker/announceclient"
+	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+// startGossipTrackers starts n trackers whose gossip stores replicate to each
+// other.
+func startGossipTrackers(t *testing.T, n int) ([]string, []*peerstore.GossipStore, func()) {
+	var cleanup testutil.Cleanup
+	defer cleanup.Recover()
+
+	// Servers are started before their stores exist, since each store must know
+	// the addresses of all other trackers.
+	handlers := make([]http.Handler, n)
+	addrs := make([]string, n)
+	for i := range addrs {
+		i := i
+		addr, stop := testutil.StartServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		cleanup.Add(stop)
+		addrs[i] = addr
+	}
+
+	stores := make([]*peerstore.GossipStore, n)
+	for i := range stores {
+		var others []string
+		for j, addr := range addrs {
+			if j != i {
+				others = append(others, addr)
+			}
+		}
+		s := peerstore.NewGossipStore(
+			peerstore.GossipConfig{Interval: 50 * time.Millisecond, Secret: "gossip-secret"},
+			peerstore.LocalConfig{TTL: time.Hour},
+			tally.NoopScope,
+			clock.New(),
+			hostlist.Fixture(others...),
+			nil)
+		cleanup.Add(s.Close)
+		stores[i] = s
+
+		mocks, finish := newServerMocks(t, Config{})
+		cleanup.Add(finish)
+		mocks.originStore.EXPECT().GetOrigins(gomock.Any()).Return(nil, nil).AnyTimes()
+		handlers[i] = New(
+			mocks.config, mocks.stats, mocks.policy, s, mocks.originStore, mocks.originCluster).Handler()
+	}
+	return addrs, stores, cleanup.Run
+}
+
+func TestGossipReplicatesPeersBetweenTrackers(t *testing.T) {
+	require := require.New(t)
+
+	addrs, stores, cleanup := startGossipTrackers(t, 3)
+	defer cleanup()
+
+	blob := core.NewBlobFixture()
+	h := blob.MetaInfo.InfoHash()
+
+	seeder := core.PeerContextFixture()
+	_, _, err := newAnnounceClient(seeder, addrs[0]).Announce(
+		blob.Digest, h, true, announceclient.V2)
+	require.NoError(err)
+
+	// Every tracker converges well within a single announce interval.
+	for _, s := range stores {
+		require.NoError(testutil.PollUntilTrue(3*time.Second, func() bool {
+			peers, err := s.GetPeers(h, 10)
+			return err == nil && len(peers) == 1
+		}))
+	}
+
+	// A leecher announcing to another tracker is handed out the seeder.
+	peers, _, err := newAnnounceClient(core.PeerContextFixture(), addrs[2]).Announce(
+		blob.Digest, h, false, announceclient.V2)
+	require.NoError(err)
+	var found bool
+	for _, p := range peers {
+		if p.PeerID == seeder.PeerID {
+			found = true
+			require.True(p.Complete)
+		}
+	}
+	require.True(found)
+}
+
+func TestGossipRejectsUnsignedPush(t *testing.T) {
+	require := require.New(t)
+
+	addrs, stores, cleanup := startGossipTrackers(t, 2)
+	defer cleanup()
+
+	h := core.InfoHashFixture()
+	body, err := json.Marshal(peerstore.GossipRequest{
+		Updates: []peerstore.Update{{InfoHash: h.Hex(), Peer: core.PeerInfoFixture()}},
+	})
+	require.NoError(err)
+
+	_, err = httputil.Post(
+		"http://"+addrs[0]+"/peerstore/gossip",
+		httputil.SendBody(bytes.NewReader(body)),
+		httputil.SendHeaders(map[string]string{
+			peerstore.GossipTimestampHeader: strconv.FormatInt(time.Now().Unix(), 10),
+			peerstore.GossipSignatureHeader: "deadbeef",
+		}))
+	require.True(httputil.IsForbidden(err))
+
+	peers, err := stores[0].GetPeers(h, 10)
+	require.NoError(err)
+	require.Empty(peers)
+}
+
+func TestGossipRejectedByNonReplicatedStore(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	_, err := httputil.Post("http://" + addr + "/peerstore/gossip")
+	require.True(httputil.IsNotFound(err))
+}
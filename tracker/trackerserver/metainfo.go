@@ -17,8 +17,10 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/utils/handler"
 	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
 )
 
 func (s *Server) getMetaInfoHandler(w http.ResponseWriter, r *http.Request) error {
@@ -31,8 +33,7 @@ func (s *Server) getMetaInfoHandler(w http.ResponseWriter, r *http.Request) erro
 		return handler.Errorf("parse digest: %s", err).Status(http.StatusBadRequest)
 	}
 
-	timer := s.stats.Timer("get_metainfo").Start()
-	mi, err := s.originCluster.GetMetaInfo(namespace, d)
+	mi, err := s.getMetaInfo(namespace, d)
 	if err != nil {
 		if serr, ok := err.(httputil.StatusError); ok {
 			// Propagate errors received from origin.
@@ -40,7 +41,6 @@ func (s *Server) getMetaInfoHandler(w http.ResponseWriter, r *http.Request) erro
 		}
 		return err
 	}
-	timer.Stop()
 
 	b, err := mi.Serialize()
 	if err != nil {
@@ -50,3 +50,65 @@ func (s *Server) getMetaInfoHandler(w http.ResponseWriter, r *http.Request) erro
 	w.Write(b)
 	return nil
 }
+
+// getMetaInfo returns the metainfo of d, from the metainfo cache if enabled.
+// Concurrent requests of the same metainfo share a single lookup.
+func (s *Server) getMetaInfo(namespace string, d core.Digest) (*core.MetaInfo, error) {
+	if s.metaInfoCache == nil {
+		return s.fetchMetaInfo(namespace, d)
+	}
+	v, err, _ := s.metaInfoFetches.Do(namespace+":"+d.String(), func() (interface{}, error) {
+		return s.getCachedMetaInfo(namespace, d)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*core.MetaInfo), nil
+}
+
+// getCachedMetaInfo returns the cached metainfo of d, revalidating it against
+// the origin metainfo version if stale. Cached metainfo is invalidated if its
+// version changed or origins no longer have the blob of d. If the version
+// cannot be checked due to transient errors, cached metainfo is still served
+// up to the max staleness of the cache.
+func (s *Server) getCachedMetaInfo(namespace string, d core.Digest) (*core.MetaInfo, error) {
+	mi, stale, err := s.metaInfoCache.Get(d)
+	if err == nil {
+		if !stale {
+			return mi, nil
+		}
+		version, err := s.originCluster.GetMetaInfoVersion(d)
+		if err == nil && version == mi.InfoHash().Hex() {
+			s.metaInfoCache.Validated(d)
+			return mi, nil
+		}
+		if err == nil || httputil.IsNotFound(err) {
+			s.stats.Counter("metainfo_cache_invalidations").Inc(1)
+			s.metaInfoCache.Delete(d)
+		} else {
+			log.With("digest", d).Infof("Error getting origin metainfo version: %s", err)
+			if s.metaInfoCache.Unvalidated(d) {
+				return mi, nil
+			}
+		}
+	}
+	mi, err = s.fetchMetaInfo(namespace, d)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.metaInfoCache.Put(mi); err != nil {
+		log.With("digest", d).Errorf("Error caching metainfo: %s", err)
+	}
+	return mi, nil
+}
+
+// fetchMetaInfo fetches the metainfo of d from origins.
+func (s *Server) fetchMetaInfo(namespace string, d core.Digest) (*core.MetaInfo, error) {
+	timer := s.stats.Timer("get_metainfo").Start()
+	mi, err := s.originCluster.GetMetaInfo(namespace, d)
+	if err != nil {
+		return nil, err
+	}
+	timer.Stop()
+	return mi, nil
+}
@@ -14,16 +14,24 @@
 package trackerserver
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/uber/kraken/core"
 	"github.com/uber/kraken/lib/hashring"
 	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/tracker/metainfocache"
 	"github.com/uber/kraken/tracker/metainfoclient"
 	"github.com/uber/kraken/utils/httputil"
 	"github.com/uber/kraken/utils/testutil"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 )
 
 func newMetaInfoClient(addr string) metainfoclient.Client {
@@ -72,3 +80,144 @@ func TestGetMetaInfoHandlerPropagatesOriginError(t *testing.T) {
 	require.Error(err)
 	require.True(httputil.IsStatus(err, 599))
 }
+
+func newTestMetaInfoCache(t *testing.T, clk clock.Clock) (*metainfocache.Cache, func()) {
+	dir, err := ioutil.TempDir("", "metainfocache")
+	require.NoError(t, err)
+	c, err := metainfocache.New(metainfocache.Config{
+		Enable:             true,
+		Dir:                dir,
+		RevalidateInterval: time.Minute,
+	}, tally.NoopScope, clk)
+	require.NoError(t, err)
+	return c, func() { os.RemoveAll(dir) }
+}
+
+func TestGetMetaInfoHandlerCachesMetaInfo(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	cache, cacheCleanup := newTestMetaInfoCache(t, clock.NewMock())
+	defer cacheCleanup()
+	mocks.options = append(mocks.options, WithMetaInfoCache(cache))
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	namespace := core.TagFixture()
+	mi := core.MetaInfoFixture()
+
+	mocks.originCluster.EXPECT().GetMetaInfo(namespace, mi.Digest()).Return(mi, nil).Times(1)
+
+	client := newMetaInfoClient(addr)
+
+	for i := 0; i < 3; i++ {
+		result, err := client.Download(namespace, mi.Digest())
+		require.NoError(err)
+		require.Equal(mi, result)
+	}
+}
+
+func TestGetMetaInfoHandlerRevalidatesStaleMetaInfo(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	clk := clock.NewMock()
+	cache, cacheCleanup := newTestMetaInfoCache(t, clk)
+	defer cacheCleanup()
+	mocks.options = append(mocks.options, WithMetaInfoCache(cache))
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	namespace := core.TagFixture()
+	blob := core.SizedBlobFixture(100, 4)
+	mi := blob.MetaInfo
+	d := mi.Digest()
+
+	mocks.originCluster.EXPECT().GetMetaInfo(namespace, d).Return(mi, nil)
+
+	client := newMetaInfoClient(addr)
+
+	_, err := client.Download(namespace, d)
+	require.NoError(err)
+
+	// Unchanged version keeps the cached metainfo.
+	clk.Add(time.Minute)
+	mocks.originCluster.EXPECT().GetMetaInfoVersion(d).Return(mi.InfoHash().Hex(), nil)
+
+	result, err := client.Download(namespace, d)
+	require.NoError(err)
+	require.Equal(mi, result)
+
+	// Changed version refetches the metainfo.
+	overwritten, err := core.NewMetaInfo(d, bytes.NewReader(blob.Content), 8)
+	require.NoError(err)
+
+	clk.Add(time.Minute)
+	mocks.originCluster.EXPECT().GetMetaInfoVersion(d).Return(overwritten.InfoHash().Hex(), nil)
+	mocks.originCluster.EXPECT().GetMetaInfo(namespace, d).Return(overwritten, nil)
+
+	result, err = client.Download(namespace, d)
+	require.NoError(err)
+	require.Equal(overwritten, result)
+
+	// Missing blob refetches the metainfo.
+	clk.Add(time.Minute)
+	mocks.originCluster.EXPECT().GetMetaInfoVersion(d).Return(
+		"", httputil.StatusError{Status: 404})
+	mocks.originCluster.EXPECT().GetMetaInfo(namespace, d).Return(mi, nil)
+
+	result, err = client.Download(namespace, d)
+	require.NoError(err)
+	require.Equal(mi, result)
+}
+
+func TestGetMetaInfoHandlerServesCachedMetaInfoOnTransientErrors(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	clk := clock.NewMock()
+	cache, cacheCleanup := newTestMetaInfoCache(t, clk)
+	defer cacheCleanup()
+	mocks.options = append(mocks.options, WithMetaInfoCache(cache))
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	namespace := core.TagFixture()
+	mi := core.MetaInfoFixture()
+	d := mi.Digest()
+
+	mocks.originCluster.EXPECT().GetMetaInfo(namespace, d).Return(mi, nil)
+
+	client := newMetaInfoClient(addr)
+
+	_, err := client.Download(namespace, d)
+	require.NoError(err)
+
+	// Cached metainfo is served while versions cannot be checked.
+	clk.Add(time.Minute)
+	mocks.originCluster.EXPECT().GetMetaInfoVersion(d).Return(
+		"", blobclient.ErrMetaInfoVersionConflict)
+
+	result, err := client.Download(namespace, d)
+	require.NoError(err)
+	require.Equal(mi, result)
+
+	// Until the max staleness is exceeded.
+	clk.Add(time.Hour)
+	mocks.originCluster.EXPECT().GetMetaInfoVersion(d).Return(
+		"", httputil.StatusError{Status: 503})
+	mocks.originCluster.EXPECT().GetMetaInfo(namespace, d).Return(mi, nil)
+
+	result, err = client.Download(namespace, d)
+	require.NoError(err)
+	require.Equal(mi, result)
+}
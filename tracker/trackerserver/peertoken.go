@@ -0,0 +1,43 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/httputil"
+)
+
+// issueTokenHandler signs a peer token for the peer_id query argument, bound to
+// the address the request was received from. Enrollment relies on nginx only
+// accepting POST requests from clients with a certificate signed by the
+// cluster CA.
+func (s *Server) issueTokenHandler(w http.ResponseWriter, r *http.Request) error {
+	if s.signer == nil {
+		return handler.Errorf("peer token issuance disabled").Status(http.StatusNotFound)
+	}
+	peerID, err := core.NewPeerID(httputil.GetQueryArg(r, "peer_id", ""))
+	if err != nil {
+		return handler.Errorf("parse peer id: %s", err).Status(http.StatusBadRequest)
+	}
+	token, err := s.signer.Sign(peerID, s.proxies.clientIP(r))
+	if err != nil {
+		return handler.Errorf("sign token: %s", err)
+	}
+	io.WriteString(w, token)
+	return nil
+}
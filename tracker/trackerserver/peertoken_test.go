@@ -0,0 +1,69 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/lib/peertoken"
+	"github.com/uber/kraken/tracker/tokenclient"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestIssueTokenBindsTokenToClientAddress(t *testing.T) {
+	require := require.New(t)
+
+	config := peertoken.ConfigFixture()
+	signer, err := peertoken.NewSigner(config, clock.New())
+	require.NoError(err)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+	mocks.options = append(mocks.options, WithTokenSigner(signer))
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	peerID := core.PeerIDFixture()
+	client := tokenclient.New(hashring.NoopPassiveRing(hostlist.Fixture(addr)), nil)
+	token, err := client.FetchToken(peerID)
+	require.NoError(err)
+
+	v, err := peertoken.NewVerifier(config, clock.New(), tally.NoopScope)
+	require.NoError(err)
+	require.NoError(v.Check(token, peerID, "127.0.0.1"))
+	require.Error(v.Check(token, peerID, "10.0.0.1"))
+}
+
+func TestIssueTokenDisabledWithoutSigner(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	client := tokenclient.New(hashring.NoopPassiveRing(hostlist.Fixture(addr)), nil)
+	_, err := client.FetchToken(core.PeerIDFixture())
+	require.True(httputil.IsNotFound(err))
+}
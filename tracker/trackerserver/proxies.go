@@ -0,0 +1,80 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/uber/kraken/utils/log"
+)
+
+// proxies lists the networks of reverse proxies whose X-Real-IP header is
+// trusted as the address of the client.
+type proxies []*net.IPNet
+
+// parseProxies parses IPs and CIDRs into proxies. Invalid entries are logged
+// and skipped, such that X-Real-IP is not trusted from them.
+func parseProxies(addrs []string) proxies {
+	var p proxies
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				log.Errorf("Invalid trusted proxy %q", addr)
+				continue
+			}
+			if v4 := ip.To4(); v4 != nil {
+				ip = v4
+			}
+			p = append(p, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(addr)
+		if err != nil {
+			log.Errorf("Invalid trusted proxy %q: %s", addr, err)
+			continue
+		}
+		p = append(p, n)
+	}
+	return p
+}
+
+func (p proxies) contains(ip net.IP) bool {
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client which sent r. The address forwarded by
+// nginx in X-Real-IP is only used if r was received from a trusted proxy or over
+// a unix socket, since any other client may set it to impersonate another.
+func (p proxies) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// Unix sockets have no remote host.
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+		return r.RemoteAddr
+	}
+	if ip := r.Header.Get("X-Real-IP"); ip != "" && p.contains(net.ParseIP(host)) {
+		return ip
+	}
+	return host
+}
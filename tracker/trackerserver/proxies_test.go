@@ -0,0 +1,50 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxiesClientIP(t *testing.T) {
+	p := parseProxies([]string{"127.0.0.1", "10.0.0.0/8", "::1", "invalid"})
+
+	tests := []struct {
+		desc       string
+		remoteAddr string
+		realIP     string
+		expected   string
+	}{
+		{"trusted ipv4 proxy", "127.0.0.1:1234", "1.2.3.4", "1.2.3.4"},
+		{"trusted ipv6 proxy", "[::1]:1234", "1.2.3.4", "1.2.3.4"},
+		{"trusted proxy network", "10.1.2.3:1234", "1.2.3.4", "1.2.3.4"},
+		{"untrusted client", "5.6.7.8:1234", "1.2.3.4", "5.6.7.8"},
+		{"no header", "127.0.0.1:1234", "", "127.0.0.1"},
+		{"unix socket", "@", "1.2.3.4", "1.2.3.4"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			r, err := http.NewRequest("GET", "/", nil)
+			require.NoError(t, err)
+			r.RemoteAddr = test.remoteAddr
+			if test.realIP != "" {
+				r.Header.Set("X-Real-IP", test.realIP)
+			}
+			require.Equal(t, test.expected, p.clientIP(r))
+		})
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/uber/kraken/core"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+	"golang.org/x/time/rate"
+)
+
+// keyedLimiter rate limits requests by key, e.g. by PeerID or IP. Once maxKeys
+// keys are tracked, requests of untracked keys share a single overflow limiter,
+// such that flooding the limiter with new keys does not bypass it.
+type keyedLimiter struct {
+	limit   rate.Limit
+	burst   int
+	maxKeys int
+
+	mu       sync.Mutex
+	limiters map[string]*keyLimiter
+	overflow *rate.Limiter
+}
+
+type keyLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newKeyedLimiter(perSec float64, burst int, maxKeys int) *keyedLimiter {
+	return &keyedLimiter{
+		limit:    rate.Limit(perSec),
+		burst:    burst,
+		maxKeys:  maxKeys,
+		limiters: make(map[string]*keyLimiter),
+		overflow: rate.NewLimiter(rate.Limit(perSec), burst),
+	}
+}
+
+// allow returns whether a request of key may proceed at now, else the delay
+// until it may proceed.
+func (l *keyedLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	kl, ok := l.limiters[key]
+	if !ok {
+		if len(l.limiters) >= l.maxKeys {
+			l.cleanup(now)
+			if len(l.limiters) >= l.maxKeys {
+				return reserve(l.overflow, now)
+			}
+		}
+		kl = &keyLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = kl
+	}
+	kl.lastSeen = now
+	return reserve(kl.limiter, now)
+}
+
+// reserve takes a token from limiter if one is available at now, else returns
+// the delay until one is.
+func reserve(limiter *rate.Limiter, now time.Time) (bool, time.Duration) {
+	r := limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// cleanup deletes limiters which have been idle long enough to refill, and are
+// thus equivalent to new limiters.
+func (l *keyedLimiter) cleanup(now time.Time) {
+	refill := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second))
+	for key, kl := range l.limiters {
+		if now.Sub(kl.lastSeen) > refill {
+			delete(l.limiters, key)
+		}
+	}
+}
+
+// rateMeter measures the rate of events over the last full second.
+type rateMeter struct {
+	mu      sync.Mutex
+	current time.Time // Start of the current second.
+	count   int
+	last    int
+}
+
+func (m *rateMeter) mark(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(now)
+	m.count++
+}
+
+func (m *rateMeter) rate(now time.Time) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll(now)
+	return float64(m.last)
+}
+
+func (m *rateMeter) roll(now time.Time) {
+	sec := now.Truncate(time.Second)
+	if sec.Equal(m.current) {
+		return
+	}
+	if sec.Sub(m.current) == time.Second {
+		m.last = m.count
+	} else {
+		m.last = 0
+	}
+	m.current = sec
+	m.count = 0
+}
+
+// rateLimiter enforces RateLimitConfig on tracker requests.
+type rateLimiter struct {
+	config    RateLimitConfig
+	proxies   proxies
+	clk       clock.Clock
+	stats     tally.Scope
+	peers     *keyedLimiter
+	ips       *keyedLimiter
+	metaInfo  *keyedLimiter
+	announces *rateMeter
+}
+
+func newRateLimiter(
+	config RateLimitConfig, proxies proxies, clk clock.Clock, stats tally.Scope) *rateLimiter {
+
+	return &rateLimiter{
+		config:  config,
+		proxies: proxies,
+		clk:     clk,
+		stats:   stats.SubScope("rate_limit"),
+		peers: newKeyedLimiter(
+			config.PeerAnnouncesPerSec, config.PeerAnnounceBurst, config.MaxKeys),
+		ips: newKeyedLimiter(
+			config.IPAnnouncesPerSec, config.IPAnnounceBurst, config.MaxKeys),
+		metaInfo: newKeyedLimiter(
+			config.IPMetaInfoPerSec, config.IPMetaInfoBurst, config.MaxKeys),
+		announces: new(rateMeter),
+	}
+}
+
+// announceInterval scales base by the ratio of the current announce rate to
+// the target announce rate, such that peers back off while the tracker is
+// overloaded.
+func (l *rateLimiter) announceInterval(base time.Duration) time.Duration {
+	load := l.announces.rate(l.clk.Now()) / l.config.TargetAnnouncesPerSec
+	if load <= 1 {
+		return base
+	}
+	interval := time.Duration(float64(base) * load)
+	if interval > l.config.MaxAnnounceInterval {
+		interval = l.config.MaxAnnounceInterval
+	}
+	if interval < base {
+		interval = base
+	}
+	return interval
+}
+
+// announceMiddleware rejects announces which exceed the per-IP or per-PeerID
+// limits. The PeerID is read from the JSON body of the announce, which is then
+// restored for the announce handler.
+func (l *rateLimiter) announceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := l.clk.Now()
+		l.announces.mark(now)
+
+		if ok, delay := l.ips.allow(l.proxies.clientIP(r), now); !ok {
+			l.reject(w, "ip_announces", delay)
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("read body: %s", err), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		var req struct {
+			Peer *struct {
+				PeerID core.PeerID `json:"peer_id"`
+			} `json:"peer"`
+		}
+		if json.Unmarshal(b, &req) == nil && req.Peer != nil {
+			if ok, delay := l.peers.allow(req.Peer.PeerID.String(), now); !ok {
+				l.reject(w, "peer_announces", delay)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// metaInfoMiddleware rejects metainfo requests which exceed the per-IP limit.
+func (l *rateLimiter) metaInfoMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, delay := l.metaInfo.allow(l.proxies.clientIP(r), l.clk.Now()); !ok {
+			l.reject(w, "ip_metainfo", delay)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reject responds with 429 and a Retry-After header of delay, rounded up to
+// the next second.
+func (l *rateLimiter) reject(w http.ResponseWriter, limit string, delay time.Duration) {
+	l.stats.Tagged(map[string]string{"limit": limit}).Counter("rejected").Inc(1)
+	sec := int(math.Ceil(delay.Seconds()))
+	if sec < 1 {
+		sec = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(sec))
+	http.Error(w, fmt.Sprintf("%s rate limit exceeded", limit), http.StatusTooManyRequests)
+}
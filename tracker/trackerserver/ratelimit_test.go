@@ -0,0 +1,233 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/tracker/announceclient"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestKeyedLimiter(t *testing.T) {
+	require := require.New(t)
+
+	l := newKeyedLimiter(1, 2, 10)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		ok, _ := l.allow("a", now)
+		require.True(ok)
+	}
+	ok, delay := l.allow("a", now)
+	require.False(ok)
+	require.Equal(time.Second, delay)
+
+	// Keys are limited independently.
+	ok, _ = l.allow("b", now)
+	require.True(ok)
+
+	ok, _ = l.allow("a", now.Add(time.Second))
+	require.True(ok)
+}
+
+func TestKeyedLimiterBoundsKeys(t *testing.T) {
+	require := require.New(t)
+
+	l := newKeyedLimiter(1, 1, 2)
+	now := time.Now()
+
+	l.allow("a", now)
+	l.allow("b", now)
+
+	// Untracked keys share the overflow limit while full.
+	ok, _ := l.allow("c", now)
+	require.True(ok)
+	ok, _ = l.allow("d", now)
+	require.False(ok)
+
+	// Idle limiters are evicted once refilled.
+	l.allow("c", now.Add(2*time.Second))
+	ok, _ = l.allow("c", now.Add(2*time.Second))
+	require.False(ok)
+}
+
+func TestRateLimiterAnnounceInterval(t *testing.T) {
+	require := require.New(t)
+
+	clk := clock.NewMock()
+	l := newRateLimiter(RateLimitConfig{
+		TargetAnnouncesPerSec: 10,
+		MaxAnnounceInterval:   20 * time.Second,
+	}.applyDefaults(), nil, clk, tally.NoopScope)
+
+	base := 3 * time.Second
+
+	for i := 0; i < 10; i++ {
+		l.announces.mark(clk.Now())
+	}
+	clk.Add(time.Second)
+	require.Equal(base, l.announceInterval(base))
+
+	for i := 0; i < 30; i++ {
+		l.announces.mark(clk.Now())
+	}
+	clk.Add(time.Second)
+	require.Equal(3*base, l.announceInterval(base))
+
+	for i := 0; i < 100; i++ {
+		l.announces.mark(clk.Now())
+	}
+	clk.Add(time.Second)
+	require.Equal(20*time.Second, l.announceInterval(base))
+
+	// Recovers once announces slow down.
+	clk.Add(time.Second)
+	require.Equal(base, l.announceInterval(base))
+}
+
+func TestAnnouncePeerRateLimit(t *testing.T) {
+	for _, version := range []int{announceclient.V1, announceclient.V2} {
+		t.Run(fmt.Sprintf("V%d", version), func(t *testing.T) {
+			require := require.New(t)
+
+			mocks, cleanup := newServerMocks(t, Config{
+				RateLimit: RateLimitConfig{
+					Enable:              true,
+					PeerAnnouncesPerSec: 0.01,
+					PeerAnnounceBurst:   1,
+				},
+			})
+			defer cleanup()
+
+			addr, stop := testutil.StartServer(mocks.handler())
+			defer stop()
+
+			blob := core.NewBlobFixture()
+			h := blob.MetaInfo.InfoHash()
+			peers := []*core.PeerInfo{core.PeerInfoFixture()}
+
+			// Only the first announce of each peer reaches the handler.
+			mocks.peerStore.EXPECT().UpdatePeer(h, gomock.Any()).Return(nil).Times(2)
+			mocks.peerStore.EXPECT().GetPeers(h, gomock.Any()).Return(peers, nil).Times(2)
+			mocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(nil, nil).Times(2)
+
+			pctx := core.PeerContextFixture()
+			client := newAnnounceClient(pctx, addr)
+
+			_, _, err := client.Announce(blob.Digest, h, false, version)
+			require.NoError(err)
+
+			_, interval, err := client.Announce(blob.Digest, h, false, version)
+			require.Equal(announceclient.ErrRateLimited, err)
+			require.Equal(100*time.Second, interval)
+
+			// The client backs off without contacting the tracker.
+			_, interval, err = client.Announce(blob.Digest, h, false, version)
+			require.Equal(announceclient.ErrRateLimited, err)
+			require.True(interval > 90*time.Second)
+
+			// Other peers are unaffected.
+			other := newAnnounceClient(core.PeerContextFixture(), addr)
+			_, _, err = other.Announce(blob.Digest, h, false, version)
+			require.NoError(err)
+		})
+	}
+}
+
+func TestAnnounceBatchIPRateLimit(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{
+		RateLimit: RateLimitConfig{
+			Enable:            true,
+			IPAnnouncesPerSec: 0.5,
+			IPAnnounceBurst:   1,
+		},
+	})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	mocks.peerStore.EXPECT().UpdatePeer(gomock.Any(), gomock.Any()).Return(nil)
+	mocks.peerStore.EXPECT().GetPeers(gomock.Any(), gomock.Any()).Return(nil, nil)
+	mocks.originStore.EXPECT().GetOrigins(gomock.Any()).Return(nil, nil)
+
+	torrents := []announceclient.AnnounceTorrent{{
+		Digest:   core.DigestFixture(),
+		InfoHash: core.InfoHashFixture(),
+	}}
+
+	ring := hashring.NoopPassiveRing(hostlist.Fixture(addr))
+	_, _, err := announceclient.New(core.PeerContextFixture(), ring, nil).AnnounceBatch(torrents)
+	require.NoError(err)
+
+	// A different peer on the same host is limited by IP.
+	_, interval, err := announceclient.New(core.PeerContextFixture(), ring, nil).AnnounceBatch(torrents)
+	require.Equal(announceclient.ErrRateLimited, err)
+	require.Equal(2*time.Second, interval)
+}
+
+func TestGetMetaInfoRateLimit(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{
+		RateLimit: RateLimitConfig{
+			Enable:           true,
+			IPMetaInfoPerSec: 1,
+			IPMetaInfoBurst:  1,
+		},
+	})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	namespace := core.TagFixture()
+	mi := core.MetaInfoFixture()
+
+	mocks.originCluster.EXPECT().GetMetaInfo(namespace, mi.Digest()).Return(mi, nil).Times(2)
+
+	client := newMetaInfoClient(addr)
+
+	_, err := client.Download(namespace, mi.Digest())
+	require.NoError(err)
+
+	_, err = httputil.Get(fmt.Sprintf(
+		"http://%s/namespace/%s/blobs/%s/metainfo",
+		addr, url.PathEscape(namespace), mi.Digest()))
+	require.True(httputil.IsStatus(err, http.StatusTooManyRequests))
+	retryAfter, ok := httputil.RetryAfter(err)
+	require.True(ok)
+	require.Equal(time.Second, retryAfter)
+
+	// The metainfo client retries after the requested delay.
+	result, err := client.Download(namespace, mi.Digest())
+	require.NoError(err)
+	require.Equal(mi, result)
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/tracker/scrapeclient"
+	"github.com/uber/kraken/utils/handler"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/log"
+)
+
+// scrapeHandler returns the swarm statistics of a single torrent. Origins are
+// only counted if the digest query argument is supplied.
+func (s *Server) scrapeHandler(w http.ResponseWriter, r *http.Request) error {
+	infohash, err := httputil.ParseParam(r, "infohash")
+	if err != nil {
+		return err
+	}
+	h, err := core.NewInfoHashFromHex(infohash)
+	if err != nil {
+		return handler.Errorf("parse infohash: %s", err).Status(http.StatusBadRequest)
+	}
+	var d *core.Digest
+	if raw := httputil.GetQueryArg(r, "digest", ""); raw != "" {
+		digest, err := core.ParseSHA256Digest(raw)
+		if err != nil {
+			return handler.Errorf("parse digest: %s", err).Status(http.StatusBadRequest)
+		}
+		d = &digest
+	}
+	stats, err := s.scrape(d, h)
+	if err != nil {
+		return err
+	}
+	return encodeScrapeResponse(w, []*peerstore.SwarmStats{stats})
+}
+
+// scrapeBatchHandler returns the swarm statistics of each torrent in the request.
+func (s *Server) scrapeBatchHandler(w http.ResponseWriter, r *http.Request) error {
+	req := new(scrapeclient.Request)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return handler.Errorf("json decode request: %s", err).Status(http.StatusBadRequest)
+	}
+	if len(req.Torrents) > s.config.MaxScrapeSize {
+		return handler.Errorf(
+			"scrape exceeds %d torrents", s.config.MaxScrapeSize).Status(http.StatusBadRequest)
+	}
+	result := make([]*peerstore.SwarmStats, len(req.Torrents))
+	for i, t := range req.Torrents {
+		d := t.Digest
+		stats, err := s.scrape(&d, t.InfoHash)
+		if err != nil {
+			return err
+		}
+		result[i] = stats
+	}
+	return encodeScrapeResponse(w, result)
+}
+
+// hotTorrentsHandler returns the swarm statistics of the torrents with the most
+// leechers. Origins are only counted if they announce.
+func (s *Server) hotTorrentsHandler(w http.ResponseWriter, r *http.Request) error {
+	limit, err := strconv.Atoi(httputil.GetQueryArg(r, "limit", "10"))
+	if err != nil || limit <= 0 {
+		return handler.Errorf("invalid limit").Status(http.StatusBadRequest)
+	}
+	if limit > s.config.MaxScrapeSize {
+		return handler.Errorf(
+			"limit exceeds %d torrents", s.config.MaxScrapeSize).Status(http.StatusBadRequest)
+	}
+	stats, err := s.peerStore.HotTorrents(limit)
+	if err != nil {
+		return handler.Errorf("peer store: %s", err)
+	}
+	return encodeScrapeResponse(w, stats)
+}
+
+// scrape returns the swarm statistics of h. Origins do not announce, so if the
+// peer store has no origins for h, the origins of d are counted as seeders.
+func (s *Server) scrape(d *core.Digest, h core.InfoHash) (*peerstore.SwarmStats, error) {
+	stats, err := s.peerStore.Scrape(h)
+	if err != nil {
+		return nil, handler.Errorf("peer store: %s", err)
+	}
+	if d != nil && stats.OriginSeeders+stats.OriginLeechers == 0 {
+		origins, err := s.originStore.GetOrigins(*d)
+		if err != nil {
+			log.With("hash", h).Infof("Error scraping origins: %s", err)
+		} else {
+			stats.OriginSeeders = len(origins)
+		}
+	}
+	return stats, nil
+}
+
+func encodeScrapeResponse(w http.ResponseWriter, stats []*peerstore.SwarmStats) error {
+	if stats == nil {
+		stats = []*peerstore.SwarmStats{}
+	}
+	if err := json.NewEncoder(w).Encode(&scrapeclient.Response{Torrents: stats}); err != nil {
+		return handler.Errorf("json encode response: %s", err)
+	}
+	return nil
+}
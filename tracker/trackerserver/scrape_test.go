@@ -0,0 +1,170 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/lib/hashring"
+	"github.com/uber/kraken/lib/hostlist"
+	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/tracker/scrapeclient"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newScrapeClient(addr string) scrapeclient.Client {
+	return scrapeclient.New(
+		hashring.NoopPassiveRing(hostlist.Fixture(addr)), hostlist.Fixture(addr), nil)
+}
+
+func TestScrape(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	blob := core.NewBlobFixture()
+	h := blob.MetaInfo.InfoHash()
+
+	mocks.peerStore.EXPECT().Scrape(h).Return(&peerstore.SwarmStats{
+		InfoHash:      h,
+		AgentSeeders:  2,
+		AgentLeechers: 5,
+	}, nil)
+	mocks.originStore.EXPECT().GetOrigins(blob.Digest).Return(
+		[]*core.PeerInfo{core.OriginPeerInfoFixture(), core.OriginPeerInfoFixture()}, nil)
+
+	stats, err := newScrapeClient(addr).Scrape(blob.Digest, h)
+	require.NoError(err)
+	require.Equal(&peerstore.SwarmStats{
+		InfoHash:      h,
+		OriginSeeders: 2,
+		AgentSeeders:  2,
+		AgentLeechers: 5,
+	}, stats)
+}
+
+func TestScrapeSingleWithoutDigest(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	h := core.InfoHashFixture()
+	expected := &peerstore.SwarmStats{InfoHash: h, AgentLeechers: 1}
+
+	mocks.peerStore.EXPECT().Scrape(h).Return(expected, nil)
+
+	resp, err := httputil.Get(fmt.Sprintf("http://%s/scrape/%s", addr, h.Hex()))
+	require.NoError(err)
+	defer resp.Body.Close()
+
+	var result scrapeclient.Response
+	require.NoError(json.NewDecoder(resp.Body).Decode(&result))
+	require.Equal([]*peerstore.SwarmStats{expected}, result.Torrents)
+}
+
+func TestScrapeBatch(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	var torrents []scrapeclient.Torrent
+	var expected []*peerstore.SwarmStats
+	for i := 0; i < 3; i++ {
+		blob := core.NewBlobFixture()
+		h := blob.MetaInfo.InfoHash()
+		torrents = append(torrents, scrapeclient.Torrent{Digest: blob.Digest, InfoHash: h})
+
+		stats := &peerstore.SwarmStats{InfoHash: h, OriginSeeders: 1, AgentLeechers: i}
+		expected = append(expected, stats)
+
+		// Origins are not looked up if they announce.
+		mocks.peerStore.EXPECT().Scrape(h).Return(stats, nil)
+	}
+
+	result, err := newScrapeClient(addr).ScrapeBatch(torrents)
+	require.NoError(err)
+	require.Equal(expected, result)
+}
+
+func TestScrapeBatchRejectsOversizedBatches(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{MaxScrapeSize: 1})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	torrents := []scrapeclient.Torrent{
+		{Digest: core.DigestFixture(), InfoHash: core.InfoHashFixture()},
+		{Digest: core.DigestFixture(), InfoHash: core.InfoHashFixture()},
+	}
+	_, err := newScrapeClient(addr).ScrapeBatch(torrents)
+	require.Error(err)
+	require.True(httputil.IsStatus(err, 400))
+}
+
+func TestHotTorrents(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	expected := []*peerstore.SwarmStats{
+		{InfoHash: core.InfoHashFixture(), AgentLeechers: 10},
+		{InfoHash: core.InfoHashFixture(), AgentLeechers: 5},
+	}
+	mocks.peerStore.EXPECT().HotTorrents(2).Return(expected, nil)
+
+	result, err := newScrapeClient(addr).HotTorrents(2)
+	require.NoError(err)
+	require.Equal(expected, result)
+}
+
+func TestHotTorrentsInvalidLimit(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{MaxScrapeSize: 10})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	for _, limit := range []string{"0", "abc", "11"} {
+		_, err := httputil.Get(fmt.Sprintf("http://%s/scrape/hot?limit=%s", addr, limit))
+		require.Error(err)
+		require.True(httputil.IsStatus(err, 400))
+	}
+}
@@ -17,13 +17,19 @@ import (
 	"fmt"
 	"net/http"
 	_ "net/http/pprof" // Registers /debug/pprof endpoints in http.DefaultServeMux.
+	"time"
 
+	"github.com/andres-erbsen/clock"
 	"github.com/pressly/chi"
 	chimiddleware "github.com/pressly/chi/middleware"
 	"github.com/uber-go/tally"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/uber/kraken/lib/middleware"
+	"github.com/uber/kraken/lib/peertoken"
 	"github.com/uber/kraken/origin/blobclient"
+	"github.com/uber/kraken/tracker/metainfocache"
 	"github.com/uber/kraken/tracker/originstore"
 	"github.com/uber/kraken/tracker/peerhandoutpolicy"
 	"github.com/uber/kraken/tracker/peerstore"
@@ -42,6 +48,42 @@ type Server struct {
 	policy      *peerhandoutpolicy.PriorityPolicy
 
 	originCluster blobclient.ClusterClient
+
+	peerStreams *atomic.Int64
+
+	tokens *peertoken.Verifier
+
+	// Nil if the tracker does not issue peer tokens.
+	signer *peertoken.Signer
+
+	proxies proxies
+
+	// Nil if rate limits are disabled.
+	limits *rateLimiter
+
+	// Nil if metainfo caching is disabled.
+	metaInfoCache   *metainfocache.Cache
+	metaInfoFetches singleflight.Group
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithTokenVerifier rejects announces and peer streams of peers without a token
+// accepted by v.
+func WithTokenVerifier(v *peertoken.Verifier) Option {
+	return func(s *Server) { s.tokens = v }
+}
+
+// WithTokenSigner issues peer tokens signed by s to enrolling peers.
+func WithTokenSigner(s *peertoken.Signer) Option {
+	return func(srv *Server) { srv.signer = s }
+}
+
+// WithMetaInfoCache serves metainfo from c, only fetching metainfo from origins
+// on misses and when its version changed.
+func WithMetaInfoCache(c *metainfocache.Cache) Option {
+	return func(s *Server) { s.metaInfoCache = c }
 }
 
 // New creates a new Server.
@@ -51,7 +93,8 @@ func New(
 	policy *peerhandoutpolicy.PriorityPolicy,
 	peerStore peerstore.Store,
 	originStore originstore.Store,
-	originCluster blobclient.ClusterClient) *Server {
+	originCluster blobclient.ClusterClient,
+	options ...Option) *Server {
 
 	config = config.applyDefaults()
 
@@ -59,14 +102,24 @@ func New(
 		"module": "trackerserver",
 	})
 
-	return &Server{
+	s := &Server{
 		config:        config,
 		stats:         stats,
 		peerStore:     peerStore,
 		originStore:   originStore,
 		policy:        policy,
 		originCluster: originCluster,
+		peerStreams:   atomic.NewInt64(0),
+		tokens:        peertoken.DisabledVerifier(),
+		proxies:       parseProxies(config.TrustedProxies),
+	}
+	if config.RateLimit.Enable {
+		s.limits = newRateLimiter(config.RateLimit, s.proxies, clock.New(), stats)
+	}
+	for _, opt := range options {
+		opt(s)
 	}
+	return s
 }
 
 // Handler an http handler for s.
@@ -76,10 +129,25 @@ func (s *Server) Handler() http.Handler {
 	r.Use(middleware.StatusCounter(s.stats))
 	r.Use(middleware.LatencyTimer(s.stats))
 
+	var announceLimits, metaInfoLimits []func(http.Handler) http.Handler
+	if s.limits != nil {
+		announceLimits = append(announceLimits, s.limits.announceMiddleware)
+		metaInfoLimits = append(metaInfoLimits, s.limits.metaInfoMiddleware)
+	}
+
 	r.Get("/health", handler.Wrap(s.healthHandler))
-	r.Get("/announce", handler.Wrap(s.announceHandlerV1))
-	r.Post("/announce/{infohash}", handler.Wrap(s.announceHandlerV2))
-	r.Get("/namespace/{namespace}/blobs/{digest}/metainfo", handler.Wrap(s.getMetaInfoHandler))
+	r.With(announceLimits...).Get("/announce", handler.Wrap(s.announceHandlerV1))
+	r.With(announceLimits...).Post("/announce/{infohash}", handler.Wrap(s.announceHandlerV2))
+	r.With(announceLimits...).Post("/bundles/announce", handler.Wrap(s.announceBundleHandler))
+	r.With(announceLimits...).Post("/batch/announce", handler.Wrap(s.announceBatchHandler))
+	r.Post("/peers/stream", handler.Wrap(s.streamPeersHandler))
+	r.Get("/scrape/hot", handler.Wrap(s.hotTorrentsHandler))
+	r.Get("/scrape/{infohash}", handler.Wrap(s.scrapeHandler))
+	r.Post("/scrape", handler.Wrap(s.scrapeBatchHandler))
+	r.With(metaInfoLimits...).Get(
+		"/namespace/{namespace}/blobs/{digest}/metainfo", handler.Wrap(s.getMetaInfoHandler))
+	r.Post("/peerstore/gossip", handler.Wrap(s.gossipHandler))
+	r.With(metaInfoLimits...).Post("/peertoken", handler.Wrap(s.issueTokenHandler))
 
 	r.Mount("/debug", chimiddleware.Profiler())
 
@@ -92,6 +160,15 @@ func (s *Server) ListenAndServe() error {
 	return listener.Serve(s.config.Listener, s.Handler())
 }
 
+// announceInterval returns the interval peers should wait until their next
+// announce, which grows while the tracker is overloaded.
+func (s *Server) announceInterval() time.Duration {
+	if s.limits == nil {
+		return s.config.AnnounceInterval
+	}
+	return s.limits.announceInterval(s.config.AnnounceInterval)
+}
+
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) error {
 	fmt.Fprintln(w, "OK")
 	return nil
@@ -0,0 +1,122 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/tracker/announceclient"
+	"github.com/uber/kraken/utils/handler"
+)
+
+// streamPeersHandler pushes seeders of the torrents in the request to the
+// client as server-sent events as they announce, until the client disconnects.
+// Each agent keeps a single stream open per tracker, carrying all torrents it
+// is downloading. The client itself is never sent, and seeders are filtered
+// and charged by the peer handout policy like announce handouts. If peer
+// tokens are enforced, the client must supply its token in the request.
+//
+// Streams complement announces rather than replace them: clients must still
+// announce to be discovered, and should keep polling for peers they missed.
+func (s *Server) streamPeersHandler(w http.ResponseWriter, r *http.Request) error {
+	req := new(announceclient.StreamRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return handler.Errorf("json decode request: %s", err).Status(http.StatusBadRequest)
+	}
+	if err := s.tokens.Check(req.Token, req.PeerID, s.proxies.clientIP(r)); err != nil {
+		return handler.Errorf("peer token: %s", err).Status(http.StatusForbidden)
+	}
+	if len(req.Torrents) > s.config.MaxBatchSize {
+		return handler.Errorf(
+			"stream exceeds %d torrents", s.config.MaxBatchSize).Status(http.StatusBadRequest)
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return handler.Errorf("streaming not supported")
+	}
+	if s.peerStreams.Inc() > int64(s.config.MaxPeerStreams) {
+		s.peerStreams.Dec()
+		s.stats.Counter("peer_streams_rejected").Inc(1)
+		return handler.Errorf(
+			"exceeded %d peer streams", s.config.MaxPeerStreams).
+			Status(http.StatusServiceUnavailable).
+			Header("Retry-After", strconv.Itoa(int(s.config.PeerStreamRetryAfter.Seconds())))
+	}
+	defer s.peerStreams.Dec()
+
+	hashes := make([]core.InfoHash, len(req.Torrents))
+	for i, t := range req.Torrents {
+		hashes[i] = t.InfoHash
+	}
+	events, cancel := s.peerStore.Subscribe(hashes)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stops nginx from buffering events in front of the tracker.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Keep-alive comments allow both ends to detect broken connections while
+	// no peers are announcing.
+	keepAlive := time.NewTicker(s.config.PeerStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	self := &core.PeerInfo{PeerID: req.PeerID}
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if e.Peer.PeerID == self.PeerID || !e.Peer.Complete {
+				continue
+			}
+			// Route pushed peers through the same policy as announce handouts,
+			// such that excluded peers are not pushed and loads are charged.
+			for _, p := range s.policy.SortPeers(self, []*core.PeerInfo{e.Peer}) {
+				if err := writePeerEvent(w, &announceclient.StreamedPeer{
+					InfoHash: e.InfoHash,
+					Peer:     p,
+				}); err != nil {
+					return nil
+				}
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+func writePeerEvent(w io.Writer, p *announceclient.StreamedPeer) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", b)
+	return err
+}
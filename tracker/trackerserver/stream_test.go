@@ -0,0 +1,133 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package trackerserver
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/uber/kraken/core"
+	"github.com/uber/kraken/tracker/announceclient"
+	"github.com/uber/kraken/tracker/peerstore"
+	"github.com/uber/kraken/utils/httputil"
+	"github.com/uber/kraken/utils/testutil"
+)
+
+func TestStreamPeers(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	pctx := core.PeerContextFixture()
+	client := newAnnounceClient(pctx, addr)
+
+	blob1 := core.NewBlobFixture()
+	h1 := blob1.MetaInfo.InfoHash()
+	blob2 := core.NewBlobFixture()
+	h2 := blob2.MetaInfo.InfoHash()
+
+	updates := make(chan *peerstore.PeerEvent, 3)
+	cancelled := atomic.NewBool(false)
+	mocks.peerStore.EXPECT().Subscribe([]core.InfoHash{h1, h2}).Return(
+		(<-chan *peerstore.PeerEvent)(updates), func() { cancelled.Store(true) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Both torrents share a single stream.
+	peers, err := client.StreamPeers(ctx, []announceclient.AnnounceTorrent{
+		{Digest: blob1.Digest, InfoHash: h1},
+		{Digest: blob2.Digest, InfoHash: h2},
+	})
+	require.NoError(err)
+
+	seeder := core.PeerInfoFixture()
+	seeder.Complete = true
+	leecher := core.PeerInfoFixture()
+	leecher.Complete = false
+
+	// Only seeders other than the client itself are streamed.
+	updates <- &peerstore.PeerEvent{InfoHash: h1, Peer: core.PeerInfoFromContext(pctx, true)}
+	updates <- &peerstore.PeerEvent{InfoHash: h1, Peer: leecher}
+	updates <- &peerstore.PeerEvent{InfoHash: h2, Peer: seeder}
+
+	select {
+	case p := <-peers:
+		require.Equal(&announceclient.StreamedPeer{InfoHash: h2, Peer: seeder}, p)
+	case <-time.After(5 * time.Second):
+		require.FailNow("timed out waiting for peer")
+	}
+
+	cancel()
+	for range peers {
+	}
+	require.NoError(testutil.PollUntilTrue(5*time.Second, cancelled.Load))
+}
+
+func TestStreamPeersUnsupported(t *testing.T) {
+	require := require.New(t)
+
+	addr, stop := testutil.StartServer(http.NotFoundHandler())
+	defer stop()
+
+	client := newAnnounceClient(core.PeerContextFixture(), addr)
+
+	_, err := client.StreamPeers(context.Background(), []announceclient.AnnounceTorrent{
+		{Digest: core.DigestFixture(), InfoHash: core.InfoHashFixture()},
+	})
+	require.Equal(announceclient.ErrStreamsUnsupported, err)
+}
+
+func TestStreamPeersLimit(t *testing.T) {
+	require := require.New(t)
+
+	mocks, cleanup := newServerMocks(t, Config{
+		MaxPeerStreams:       1,
+		PeerStreamRetryAfter: 30 * time.Second,
+	})
+	defer cleanup()
+
+	addr, stop := testutil.StartServer(mocks.handler())
+	defer stop()
+
+	client := newAnnounceClient(core.PeerContextFixture(), addr)
+
+	torrents := []announceclient.AnnounceTorrent{
+		{Digest: core.DigestFixture(), InfoHash: core.InfoHashFixture()},
+	}
+	mocks.peerStore.EXPECT().Subscribe([]core.InfoHash{torrents[0].InfoHash}).Return(
+		make(<-chan *peerstore.PeerEvent), func() {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := client.StreamPeers(ctx, torrents)
+	require.NoError(err)
+
+	// Rejected streams tell the agent how long to back off.
+	_, err = client.StreamPeers(ctx, torrents)
+	require.True(httputil.IsStatus(err, http.StatusServiceUnavailable))
+	retryAfter, ok := httputil.RetryAfter(err)
+	require.True(ok)
+	require.Equal(30*time.Second, retryAfter)
+}
@@ -34,6 +34,7 @@ type serverMocks struct {
 	originStore   *mockoriginstore.MockStore
 	originCluster *mockblobclient.MockClusterClient
 	stats         tally.Scope
+	options       []Option
 }
 
 func newServerMocks(t *testing.T, config Config) (*serverMocks, func()) {
@@ -55,5 +56,6 @@ func (m *serverMocks) handler() http.Handler {
 		m.policy,
 		m.peerStore,
 		m.originStore,
-		m.originCluster).Handler()
+		m.originCluster,
+		m.options...).Handler()
 }
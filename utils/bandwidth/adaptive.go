@@ -0,0 +1,354 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package bandwidth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// AdaptiveConfig defines adaptive egress control. When enabled, the host's
+// network interface counters are sampled periodically, and the egress limit
+// is adjusted AIMD style: Kraken may use whatever the target utilization of the
+// link leaves over after non-Kraken traffic. The limit backs off
+// multiplicatively whenever it exceeds this headroom, e.g. because non-Kraken
+// traffic rose, and otherwise recovers additively up to the headroom.
+type AdaptiveConfig struct {
+	Enable bool `yaml:"enable"`
+
+	// LinkBitsPerSec is the egress capacity of the sampled interfaces.
+	LinkBitsPerSec uint64 `yaml:"link_bits_per_sec"`
+
+	// TargetUtilization is the fraction of LinkBitsPerSec which total host
+	// egress should stay under. Defaults to 0.9.
+	TargetUtilization float64 `yaml:"target_utilization"`
+
+	// MinEgressBitsPerSec is the floor of the egress limit.
+	MinEgressBitsPerSec uint64 `yaml:"min_egress_bits_per_sec"`
+
+	// MaxEgressBitsPerSec is the ceiling of the egress limit. Defaults to
+	// egress_bits_per_sec.
+	MaxEgressBitsPerSec uint64 `yaml:"max_egress_bits_per_sec"`
+
+	// IncreaseBitsPerSec is added to the egress limit every interval the limit
+	// is under the headroom. Defaults to 5% of MaxEgressBitsPerSec.
+	IncreaseBitsPerSec uint64 `yaml:"increase_bits_per_sec"`
+
+	// DecreaseFactor multiplies the egress limit every interval the limit is
+	// over the headroom. Defaults to 0.5.
+	DecreaseFactor float64 `yaml:"decrease_factor"`
+
+	// Interval is how often interface counters are sampled. Defaults to 1s.
+	Interval time.Duration `yaml:"interval"`
+
+	// Interfaces lists the interfaces to sample. Defaults to all interfaces
+	// except loopback.
+	Interfaces []string `yaml:"interfaces"`
+
+	// ProcNetDev is the path of the interface counters, which may differ
+	// from the default /proc/net/dev when running in a container.
+	ProcNetDev string `yaml:"proc_net_dev"`
+}
+
+func (c AdaptiveConfig) applyDefaults(egressBitsPerSec uint64) AdaptiveConfig {
+	if c.TargetUtilization == 0 {
+		c.TargetUtilization = 0.9
+	}
+	if c.MaxEgressBitsPerSec == 0 {
+		c.MaxEgressBitsPerSec = egressBitsPerSec
+	}
+	if c.IncreaseBitsPerSec == 0 {
+		c.IncreaseBitsPerSec = c.MaxEgressBitsPerSec / 20
+	}
+	if c.DecreaseFactor == 0 {
+		c.DecreaseFactor = 0.5
+	}
+	if c.Interval == 0 {
+		c.Interval = time.Second
+	}
+	if c.ProcNetDev == "" {
+		c.ProcNetDev = "/proc/net/dev"
+	}
+	return c
+}
+
+func (c AdaptiveConfig) validate() error {
+	if c.LinkBitsPerSec == 0 {
+		return errors.New("link_bits_per_sec must be non-zero")
+	}
+	if c.MinEgressBitsPerSec == 0 {
+		return errors.New("min_egress_bits_per_sec must be non-zero")
+	}
+	if c.MinEgressBitsPerSec > c.MaxEgressBitsPerSec {
+		return errors.New("min_egress_bits_per_sec must not exceed max_egress_bits_per_sec")
+	}
+	if c.DecreaseFactor <= 0 || c.DecreaseFactor >= 1 {
+		return errors.New("decrease_factor must be between 0 and 1")
+	}
+	return nil
+}
+
+// AdaptiveSnapshot describes the latest sample of an adaptive egress limit.
+type AdaptiveSnapshot struct {
+	HostEgressBitsPerSec   uint64 `json:"host_egress_bits_per_sec"`
+	KrakenEgressBitsPerSec uint64 `json:"kraken_egress_bits_per_sec"`
+	OtherEgressBitsPerSec  uint64 `json:"other_egress_bits_per_sec"`
+	Congested              bool   `json:"congested"`
+}
+
+// adaptiveController periodically adjusts the egress limit of a Limiter. The
+// rate of queue is owned by the controller once it is created.
+type adaptiveController struct {
+	config AdaptiveConfig
+	queue  *fairQueue
+
+	// Number of bits per bucket token.
+	tokenSize uint64
+
+	// Returns the total number of bytes which Kraken reserved for egress.
+	reserved func() int64
+
+	clk    clock.Clock
+	stats  tally.Scope
+	logger *zap.SugaredLogger
+
+	mu           sync.Mutex // Protects the following fields:
+	limit        uint64
+	ceiling      uint64 // Lowered from MaxEgressBitsPerSec by setCeiling.
+	snapshot     AdaptiveSnapshot
+	lastSample   time.Time
+	lastTx       uint64
+	lastReserved int64
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func newAdaptiveController(
+	config AdaptiveConfig,
+	queue *fairQueue,
+	tokenSize uint64,
+	reserved func() int64,
+	clk clock.Clock,
+	stats tally.Scope,
+	logger *zap.SugaredLogger) *adaptiveController {
+
+	c := &adaptiveController{
+		config:    config,
+		queue:     queue,
+		tokenSize: tokenSize,
+		reserved:  reserved,
+		clk:       clk,
+		stats:     stats,
+		logger:    logger,
+		limit:     config.MaxEgressBitsPerSec,
+		ceiling:   config.MaxEgressBitsPerSec,
+		done:      make(chan struct{}),
+	}
+	c.apply()
+	return c
+}
+
+func (c *adaptiveController) start() {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		ticker := c.clk.Ticker(c.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-ticker.C:
+				if err := c.sample(); err != nil {
+					c.logger.Errorf("Error sampling interface counters: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+func (c *adaptiveController) stop() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+	})
+}
+
+// sample reads interface counters and adjusts the egress limit based on the
+// non-Kraken egress rate since the last sample.
+func (c *adaptiveController) sample() error {
+	tx, err := readTransmitBytes(c.config.ProcNetDev, c.config.Interfaces)
+	if err != nil {
+		return err
+	}
+	reserved := c.reserved()
+	now := c.clk.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	first := c.lastSample.IsZero()
+	elapsed := now.Sub(c.lastSample).Seconds()
+	prevTx, prevReserved := c.lastTx, c.lastReserved
+	c.lastSample, c.lastTx, c.lastReserved = now, tx, reserved
+	if first || elapsed <= 0 || tx < prevTx {
+		// No baseline, or counters were reset.
+		return nil
+	}
+
+	host := uint64(float64(tx-prevTx) * 8 / elapsed)
+	kraken := uint64(float64(reserved-prevReserved) * 8 / elapsed)
+	var other uint64
+	if host > kraken {
+		other = host - kraken
+	}
+	// Kraken's own egress is excluded, else Kraken would back off from its own
+	// traffic.
+	var headroom uint64
+	if target := uint64(float64(c.config.LinkBitsPerSec) * c.config.TargetUtilization); target > other {
+		headroom = target - other
+	}
+	congested := c.limit > headroom
+
+	if congested {
+		c.limit = uint64(float64(c.limit) * c.config.DecreaseFactor)
+	} else {
+		c.limit += c.config.IncreaseBitsPerSec
+		if c.limit > headroom {
+			c.limit = headroom
+		}
+	}
+	c.clamp()
+	c.snapshot = AdaptiveSnapshot{
+		HostEgressBitsPerSec:   host,
+		KrakenEgressBitsPerSec: kraken,
+		OtherEgressBitsPerSec:  other,
+		Congested:              congested,
+	}
+	c.apply()
+
+	c.stats.Gauge("host_egress_bits_per_sec").Update(float64(host))
+	c.stats.Gauge("kraken_egress_bits_per_sec").Update(float64(kraken))
+	c.stats.Gauge("other_egress_bits_per_sec").Update(float64(other))
+
+	return nil
+}
+
+// setCeiling lowers the maximum egress limit to bps, e.g. when Limiter.Adjust
+// divides the configured bandwidth. The floor is lowered along with it.
+func (c *adaptiveController) setCeiling(bps uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ceiling = bps
+	c.clamp()
+	c.apply()
+}
+
+// clamp keeps the limit between the floor and the ceiling. Must be called with
+// c.mu held.
+func (c *adaptiveController) clamp() {
+	floor := c.config.MinEgressBitsPerSec
+	if floor > c.ceiling {
+		floor = c.ceiling
+	}
+	if c.limit < floor {
+		c.limit = floor
+	}
+	if c.limit > c.ceiling {
+		c.limit = c.ceiling
+	}
+}
+
+// apply sets the rate and burst of the queue to the current limit, such that
+// a lowered limit cannot be exceeded by a burst sized for the old one. Must be
+// called with c.mu held, or before c is started.
+func (c *adaptiveController) apply() {
+	tokens := c.limit / c.tokenSize
+	if tokens == 0 {
+		tokens = 1
+	}
+	c.queue.setRate(rate.Limit(tokens), int(tokens))
+	c.stats.Gauge("egress_limit_bits_per_sec").Update(float64(c.limit))
+}
+
+func (c *adaptiveController) getSnapshot() AdaptiveSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.snapshot
+}
+
+// readTransmitBytes sums the transmitted bytes counters of interfaces in the
+// /proc/net/dev formatted file at path. If interfaces is empty, all interfaces
+// except loopback are summed.
+func readTransmitBytes(path string, interfaces []string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	include := make(map[string]bool)
+	for _, name := range interfaces {
+		include[name] = true
+	}
+
+	var total uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			// Header lines.
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		if len(include) > 0 {
+			if !include[name] {
+				continue
+			}
+		} else if name == "lo" {
+			continue
+		}
+		// Eight receive counters precede the transmitted bytes.
+		fields := strings.Fields(parts[1])
+		if len(fields) < 9 {
+			return 0, fmt.Errorf("interface %s: expected at least 9 counters, got %d", name, len(fields))
+		}
+		tx, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("interface %s: parse transmit bytes: %s", name, err)
+		}
+		total += tx
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
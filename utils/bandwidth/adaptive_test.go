@@ -0,0 +1,303 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package bandwidth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andres-erbsen/clock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const procNetDevHeader = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+`
+
+// procNetDevFixture writes /proc/net/dev formatted counters.
+type procNetDevFixture struct {
+	path string
+}
+
+func newProcNetDevFixture(t *testing.T) (*procNetDevFixture, func()) {
+	dir, err := ioutil.TempDir("", "procnetdev")
+	require.NoError(t, err)
+	return &procNetDevFixture{filepath.Join(dir, "dev")}, func() { os.RemoveAll(dir) }
+}
+
+func (f *procNetDevFixture) write(t *testing.T, lo, eth0, eth1 uint64) {
+	line := func(name string, tx uint64) string {
+		return fmt.Sprintf("%6s: 1000 10 0 0 0 0 0 0 %d 10 0 0 0 0 0 0\n", name, tx)
+	}
+	content := procNetDevHeader + line("lo", lo) + line("eth0", eth0) + line("eth1", eth1)
+	require.NoError(t, ioutil.WriteFile(f.path, []byte(content), 0644))
+}
+
+func TestReadTransmitBytes(t *testing.T) {
+	require := require.New(t)
+
+	f, cleanup := newProcNetDevFixture(t)
+	defer cleanup()
+
+	f.write(t, 100, 20, 3)
+
+	tx, err := readTransmitBytes(f.path, nil)
+	require.NoError(err)
+	require.Equal(uint64(23), tx)
+
+	tx, err = readTransmitBytes(f.path, []string{"eth1"})
+	require.NoError(err)
+	require.Equal(uint64(3), tx)
+
+	_, err = readTransmitBytes(filepath.Join(f.path, "missing"), nil)
+	require.Error(err)
+}
+
+// adaptiveControllerFixture returns a controller sampling f with a token size
+// of one bit, and step, which advances one second during which the host sent
+// host bits, of which kraken bits were sent by Kraken.
+func adaptiveControllerFixture(
+	t *testing.T, f *procNetDevFixture) (*adaptiveController, *fairQueue, func(host, kraken uint64)) {
+
+	config := AdaptiveConfig{
+		LinkBitsPerSec:      1000,
+		MinEgressBitsPerSec: 100,
+		MaxEgressBitsPerSec: 800,
+		IncreaseBitsPerSec:  50,
+		ProcNetDev:          f.path,
+		Interfaces:          []string{"eth0"},
+	}.applyDefaults(800)
+	require.NoError(t, config.validate())
+
+	clk := clock.NewMock()
+	q := newFairQueue(rate.NewLimiter(rate.Limit(800), 800))
+	var reserved int64
+	c := newAdaptiveController(
+		config, q, 1, func() int64 { return reserved },
+		clk, tally.NoopScope, zap.NewNop().Sugar())
+
+	var tx uint64
+	step := func(host, kraken uint64) {
+		tx += host / 8
+		reserved += int64(kraken / 8)
+		f.write(t, 0, tx, 0)
+		clk.Add(time.Second)
+		require.NoError(t, c.sample())
+	}
+	return c, q, step
+}
+
+func TestAdaptiveControllerAIMD(t *testing.T) {
+	require := require.New(t)
+
+	f, cleanup := newProcNetDevFixture(t)
+	defer cleanup()
+
+	c, q, step := adaptiveControllerFixture(t, f)
+
+	// First sample only establishes a baseline.
+	step(0, 0)
+	require.Equal(rate.Limit(800), q.limit())
+
+	// Kraken's own egress over target is not congestion.
+	step(960, 960)
+	require.Equal(rate.Limit(800), q.limit())
+	require.False(c.getSnapshot().Congested)
+
+	// Other traffic leaves less headroom than the limit: back off
+	// multiplicatively, shrinking the burst along with the limit.
+	step(960, 400)
+	require.Equal(rate.Limit(400), q.limit())
+	require.Equal(400, q.bucket.Burst())
+	require.Equal(AdaptiveSnapshot{
+		HostEgressBitsPerSec:   960,
+		KrakenEgressBitsPerSec: 400,
+		OtherEgressBitsPerSec:  560,
+		Congested:              true,
+	}, c.getSnapshot())
+
+	step(960, 400)
+	require.Equal(rate.Limit(200), q.limit())
+
+	// Recover additively, but never beyond the headroom.
+	step(760, 200)
+	require.Equal(rate.Limit(250), q.limit())
+	step(760, 250)
+	require.Equal(rate.Limit(300), q.limit())
+	step(900, 300)
+	require.Equal(rate.Limit(300), q.limit())
+
+	// Never backs off below the floor.
+	step(960, 100)
+	require.Equal(rate.Limit(150), q.limit())
+	step(960, 100)
+	require.Equal(rate.Limit(100), q.limit())
+	step(960, 100)
+	require.Equal(rate.Limit(100), q.limit())
+
+	// Other traffic subsides: recover additively.
+	step(200, 100)
+	require.Equal(rate.Limit(150), q.limit())
+	step(200, 150)
+	require.Equal(rate.Limit(200), q.limit())
+}
+
+func TestAdaptiveControllerCeiling(t *testing.T) {
+	require := require.New(t)
+
+	f, cleanup := newProcNetDevFixture(t)
+	defer cleanup()
+
+	c, q, step := adaptiveControllerFixture(t, f)
+
+	step(0, 0)
+
+	c.setCeiling(400)
+	require.Equal(rate.Limit(400), q.limit())
+
+	// Recovery stops at the ceiling.
+	step(0, 0)
+	require.Equal(rate.Limit(400), q.limit())
+
+	// The floor is lowered along with the ceiling.
+	c.setCeiling(50)
+	step(960, 0)
+	require.Equal(rate.Limit(50), q.limit())
+
+	c.setCeiling(800)
+	require.Equal(rate.Limit(100), q.limit())
+	step(0, 0)
+	require.Equal(rate.Limit(150), q.limit())
+}
+
+func TestLimiterAdjustLowersAdaptiveCeiling(t *testing.T) {
+	require := require.New(t)
+
+	f, cleanup := newProcNetDevFixture(t)
+	defer cleanup()
+	f.write(t, 0, 0, 0)
+
+	l, err := NewLimiter(Config{
+		EgressBitsPerSec:  800,
+		IngressBitsPerSec: 800,
+		TokenSize:         1,
+		Enable:            true,
+		Adaptive: AdaptiveConfig{
+			Enable:              true,
+			LinkBitsPerSec:      1000,
+			MinEgressBitsPerSec: 100,
+			ProcNetDev:          f.path,
+		},
+	})
+	require.NoError(err)
+	defer l.Close()
+
+	require.NoError(l.Adjust(4))
+	require.Equal(int64(200), l.EgressLimit())
+	require.Equal(int64(200), l.IngressLimit())
+
+	// Sampling an idle link does not undo the adjustment.
+	require.NoError(l.adaptive.sample())
+	require.NoError(l.adaptive.sample())
+	require.Equal(int64(200), l.EgressLimit())
+}
+
+func TestFairQueueReservesOverBurstInChunks(t *testing.T) {
+	require := require.New(t)
+
+	q := newFairQueue(rate.NewLimiter(rate.Inf, 10))
+	q.setRate(rate.Inf, 2)
+
+	done := make(chan struct{})
+	go func() {
+		q.wait(flowID{"c", 1, "k", 1}, 10)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow("reservation over burst never completed")
+	}
+}
+
+func TestLimiterAdaptiveInvalidConfig(t *testing.T) {
+	for _, ac := range []AdaptiveConfig{
+		{Enable: true, MinEgressBitsPerSec: 100},
+		{Enable: true, LinkBitsPerSec: 1000},
+		{Enable: true, LinkBitsPerSec: 1000, MinEgressBitsPerSec: 1000},
+		{Enable: true, LinkBitsPerSec: 1000, MinEgressBitsPerSec: 100, DecreaseFactor: 2},
+	} {
+		_, err := NewLimiter(Config{
+			EgressBitsPerSec:  800,
+			IngressBitsPerSec: 800,
+			TokenSize:         1,
+			Enable:            true,
+			Adaptive:          ac,
+		})
+		require.Error(t, err)
+	}
+}
+
+func TestLimiterSnapshot(t *testing.T) {
+	require := require.New(t)
+
+	f, cleanup := newProcNetDevFixture(t)
+	defer cleanup()
+	f.write(t, 0, 0, 0)
+
+	l, err := NewLimiter(Config{
+		EgressBitsPerSec:  800,
+		IngressBitsPerSec: 400,
+		TokenSize:         8,
+		Enable:            true,
+	})
+	require.NoError(err)
+	defer l.Close()
+
+	require.Equal(Snapshot{
+		Enable:            true,
+		EgressBitsPerSec:  800,
+		IngressBitsPerSec: 400,
+	}, l.Snapshot())
+
+	l, err = NewLimiter(Config{
+		EgressBitsPerSec:  800,
+		IngressBitsPerSec: 400,
+		TokenSize:         8,
+		Enable:            true,
+		Adaptive: AdaptiveConfig{
+			Enable:              true,
+			LinkBitsPerSec:      1000,
+			MinEgressBitsPerSec: 100,
+			ProcNetDev:          f.path,
+		},
+	})
+	require.NoError(err)
+	defer l.Close()
+
+	s := l.Snapshot()
+	require.Equal(uint64(800), s.EgressBitsPerSec)
+	require.NotNil(s.Adaptive)
+
+	l, err = NewLimiter(Config{Enable: false})
+	require.NoError(err)
+	require.Equal(Snapshot{}, l.Snapshot())
+}
@@ -0,0 +1,252 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package bandwidth
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
+)
+
+// flowID identifies the class and key a reservation is made for.
+type flowID struct {
+	class       string
+	classWeight float64
+	key         string
+	keyWeight   float64
+}
+
+// grantInterval is the duration of bandwidth a reservation is granted at a
+// time, which bounds how long other reservations wait behind it.
+const grantInterval = 10 * time.Millisecond
+
+type waiter struct {
+	tokens int      // Tokens not granted yet.
+	ready  chan int // Receives the number of tokens of each grant.
+}
+
+// flow is the queue of reservations waiting for a single key.
+type flow struct {
+	weight  float64
+	vtime   float64
+	waiters []*waiter
+}
+
+// classQueue schedules flows within a class.
+type classQueue struct {
+	weight  float64
+	vtime   float64
+	waiting int
+
+	// Virtual time of the last flow granted within the class.
+	flowVtime float64
+	flows     map[string]*flow
+}
+
+// fairQueue grants tokens from a bucket to waiting reservations using
+// hierarchical start-time fair queueing. Each class and flow carries a virtual
+// time which advances by tokens/weight whenever it is granted, and the
+// backlogged class and flow with the lowest virtual time is always granted
+// next. Classes and flows which become backlogged start at the current virtual
+// time, so bandwidth unused by idle classes is borrowed by busy ones without
+// later penalty.
+//
+// Only one reservation holds the bucket at a time, such that the grant order
+// is decided when tokens are actually available, not when reservations arrive.
+// Reservations are granted grantInterval worth of tokens at a time and then
+// queue again, so large reservations are interleaved with small ones rather
+// than blocking them until done.
+type fairQueue struct {
+	// Maximum tokens of a single reservation, which is the initial burst of
+	// the bucket. Reservations over the current burst are taken in chunks.
+	maxTokens int
+
+	// Total bytes reserved from bucket.
+	bytes *atomic.Int64
+
+	mu      sync.Mutex // Protects the following fields:
+	bucket  *rate.Limiter
+	busy    bool // Whether a reservation holds the bucket.
+	vtime   float64
+	classes map[string]*classQueue
+}
+
+func newFairQueue(bucket *rate.Limiter) *fairQueue {
+	return &fairQueue{
+		bucket:    bucket,
+		maxTokens: bucket.Burst(),
+		bytes:     atomic.NewInt64(0),
+		classes:   make(map[string]*classQueue),
+	}
+}
+
+// wait blocks until tokens have been taken from the bucket for id.
+func (q *fairQueue) wait(id flowID, tokens int) {
+	w := &waiter{tokens, make(chan int, 1)}
+
+	q.mu.Lock()
+	q.push(id, w)
+	if !q.busy {
+		q.busy = true
+		q.grant()
+	}
+	q.mu.Unlock()
+
+	for tokens > 0 {
+		n := <-w.ready
+		q.take(n)
+		tokens -= n
+
+		q.mu.Lock()
+		q.grant()
+		q.mu.Unlock()
+	}
+}
+
+// take blocks until n tokens have been taken from the bucket. Must be called
+// while holding the bucket.
+func (q *fairQueue) take(n int) {
+	for n > 0 {
+		q.mu.Lock()
+		bucket := q.bucket
+		q.mu.Unlock()
+
+		k := n
+		if burst := bucket.Burst(); k > burst {
+			k = burst
+		}
+		r := bucket.ReserveN(time.Now(), k)
+		time.Sleep(r.Delay())
+		n -= k
+	}
+}
+
+// quantum returns the number of tokens granted at a time. Must be called with
+// q.mu held.
+func (q *fairQueue) quantum() int {
+	n := q.bucket.Burst()
+	if limit := q.bucket.Limit(); limit != rate.Inf {
+		if t := int(float64(limit) * grantInterval.Seconds()); t < n {
+			n = t
+		}
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// limit returns the rate of the bucket.
+func (q *fairQueue) limit() rate.Limit {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.bucket.Limit()
+}
+
+// setLimit sets the rate of the bucket, keeping its burst.
+func (q *fairQueue) setLimit(limit rate.Limit) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.bucket.SetLimit(limit)
+}
+
+// setRate replaces the bucket with one of the given rate and burst, such that
+// the tokens which accumulate while idle shrink along with the rate. The new
+// bucket starts empty, so changing the rate never grants extra tokens.
+func (q *fairQueue) setRate(limit rate.Limit, burst int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.bucket.Limit() == limit && q.bucket.Burst() == burst {
+		return
+	}
+	q.bucket = rate.NewLimiter(limit, burst)
+	q.bucket.ReserveN(time.Now(), burst)
+}
+
+func (q *fairQueue) push(id flowID, w *waiter) {
+	c, ok := q.classes[id.class]
+	if !ok {
+		c = &classQueue{flows: make(map[string]*flow)}
+		q.classes[id.class] = c
+	}
+	c.weight = id.classWeight
+	if c.waiting == 0 && c.vtime < q.vtime {
+		c.vtime = q.vtime
+	}
+	f, ok := c.flows[id.key]
+	if !ok {
+		f = &flow{}
+		c.flows[id.key] = f
+	}
+	f.weight = id.keyWeight
+	if len(f.waiters) == 0 && f.vtime < c.flowVtime {
+		f.vtime = c.flowVtime
+	}
+	f.waiters = append(f.waiters, w)
+	c.waiting++
+}
+
+// grant passes the bucket to the next waiting reservation, if any.
+func (q *fairQueue) grant() {
+	var cname string
+	var c *classQueue
+	for name, cur := range q.classes {
+		if cur.waiting == 0 {
+			if cur.vtime <= q.vtime {
+				delete(q.classes, name)
+			}
+			continue
+		}
+		if c == nil || cur.vtime < c.vtime || (cur.vtime == c.vtime && name < cname) {
+			cname, c = name, cur
+		}
+	}
+	if c == nil {
+		q.busy = false
+		return
+	}
+	var fkey string
+	var f *flow
+	for key, cur := range c.flows {
+		if len(cur.waiters) == 0 {
+			if cur.vtime <= c.flowVtime {
+				delete(c.flows, key)
+			}
+			continue
+		}
+		if f == nil || cur.vtime < f.vtime || (cur.vtime == f.vtime && key < fkey) {
+			fkey, f = key, cur
+		}
+	}
+	w := f.waiters[0]
+	n := q.quantum()
+	if n >= w.tokens {
+		n = w.tokens
+		f.waiters = f.waiters[1:]
+		c.waiting--
+	}
+	w.tokens -= n
+
+	q.vtime = c.vtime
+	c.vtime += float64(n) / c.weight
+	c.flowVtime = f.vtime
+	f.vtime += float64(n) / f.weight
+
+	w.ready <- n
+}
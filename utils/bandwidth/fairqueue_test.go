@@ -0,0 +1,155 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package bandwidth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+// fairQueueFixture records the order in which a fairQueue grants waiters,
+// without touching the bucket.
+type fairQueueFixture struct {
+	q       *fairQueue
+	pending map[*waiter]string
+}
+
+func newFairQueueFixture() *fairQueueFixture {
+	q := newFairQueue(rate.NewLimiter(rate.Inf, 0))
+	// Simulate a reservation holding the bucket, so pushes do not grant.
+	q.busy = true
+	return &fairQueueFixture{q, make(map[*waiter]string)}
+}
+
+// push pushes n waiters for a single token each.
+func (f *fairQueueFixture) push(id flowID, n int, label string) {
+	for i := 0; i < n; i++ {
+		f.pushTokens(id, 1, label)
+	}
+}
+
+// pushTokens pushes a single waiter for the given tokens.
+func (f *fairQueueFixture) pushTokens(id flowID, tokens int, label string) {
+	w := &waiter{tokens, make(chan int, 1)}
+	f.q.push(id, w)
+	f.pending[w] = label
+}
+
+// grant returns the labels of the waiters of the next n grants.
+func (f *fairQueueFixture) grant(n int) []string {
+	var labels []string
+	for i := 0; i < n; i++ {
+		f.q.grant()
+		for w, label := range f.pending {
+			select {
+			case <-w.ready:
+				labels = append(labels, label)
+				if w.tokens == 0 {
+					delete(f.pending, w)
+				}
+			default:
+			}
+		}
+	}
+	return labels
+}
+
+func count(labels []string) map[string]int {
+	c := make(map[string]int)
+	for _, l := range labels {
+		c[l]++
+	}
+	return c
+}
+
+func TestFairQueueWeightedClasses(t *testing.T) {
+	require := require.New(t)
+
+	f := newFairQueueFixture()
+	f.push(flowID{"a", 3, "x", 1}, 20, "a")
+	f.push(flowID{"b", 1, "y", 1}, 20, "b")
+
+	require.Equal(map[string]int{"a": 6, "b": 2}, count(f.grant(8)))
+	require.Equal(map[string]int{"a": 6, "b": 2}, count(f.grant(8)))
+}
+
+func TestFairQueueIdleClassesAreBorrowedWithoutPenalty(t *testing.T) {
+	require := require.New(t)
+
+	f := newFairQueueFixture()
+
+	// Class a uses all bandwidth while b is idle.
+	f.push(flowID{"a", 1, "x", 1}, 100, "a")
+	require.Equal(map[string]int{"a": 50}, count(f.grant(50)))
+
+	// Once b is busy, the classes share equally, rather than b catching up on
+	// the bandwidth it did not use.
+	f.push(flowID{"b", 1, "y", 1}, 100, "b")
+	require.Equal(map[string]int{"a": 5, "b": 5}, count(f.grant(10)))
+}
+
+func TestFairQueueFairAcrossKeysWithinClass(t *testing.T) {
+	require := require.New(t)
+
+	f := newFairQueueFixture()
+	f.push(flowID{"a", 1, "x", 1}, 20, "x")
+	f.push(flowID{"a", 1, "y", 1}, 20, "y")
+	f.push(flowID{"a", 1, "z", 2}, 20, "z")
+	f.push(flowID{"b", 1, "w", 1}, 20, "w")
+
+	// Class a receives half the bandwidth, of which z receives twice the share of
+	// x and y.
+	require.Equal(map[string]int{"x": 2, "y": 2, "z": 4, "w": 8}, count(f.grant(16)))
+}
+
+func TestFairQueueInterleavesLargeReservations(t *testing.T) {
+	require := require.New(t)
+
+	f := newFairQueueFixture()
+	f.pushTokens(flowID{"a", 1, "x", 1}, 3, "x")
+	f.push(flowID{"a", 1, "y", 1}, 1, "y")
+
+	// x is granted a token at a time, so y does not wait for all of x.
+	require.Equal([]string{"x", "y", "x", "x"}, f.grant(4))
+}
+
+func TestFairQueueQuantumIsBoundedByBurst(t *testing.T) {
+	require := require.New(t)
+
+	// 10ms of 1000 tokens/sec.
+	q := newFairQueue(rate.NewLimiter(1000, 100))
+	require.Equal(10, q.quantum())
+
+	q = newFairQueue(rate.NewLimiter(1000, 5))
+	require.Equal(5, q.quantum())
+
+	q = newFairQueue(rate.NewLimiter(10, 10))
+	require.Equal(1, q.quantum())
+}
+
+func TestFairQueueReleasesBucketWhenEmpty(t *testing.T) {
+	require := require.New(t)
+
+	f := newFairQueueFixture()
+	f.push(flowID{"a", 1, "x", 1}, 1, "x")
+
+	require.Equal([]string{"x"}, f.grant(1))
+	require.True(f.q.busy)
+
+	f.q.grant()
+	require.False(f.q.busy)
+	require.Empty(f.q.classes["a"].flows["x"].waiters)
+}
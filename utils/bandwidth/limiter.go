@@ -16,11 +16,15 @@ package bandwidth
 import (
 	"errors"
 	"fmt"
-	"time"
+	"regexp"
+	"sync"
 
 	"github.com/uber/kraken/utils/log"
 	"github.com/uber/kraken/utils/memsize"
 
+	"github.com/andres-erbsen/clock"
+	"github.com/uber-go/tally"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 	"golang.org/x/time/rate"
 )
@@ -38,21 +42,73 @@ type Config struct {
 	TokenSize uint64 `yaml:"token_size"`
 
 	Enable bool `yaml:"enable"`
+
+	// Classes divides bandwidth between namespaces. While several classes are
+	// transferring at once, each receives a share of bandwidth proportional to
+	// its weight, and bandwidth left unused by idle classes is borrowed by busy
+	// ones. Within a class, bandwidth is shared fairly between torrents.
+	// Namespaces which match no class fall into a default class of weight 1.
+	Classes []ClassConfig `yaml:"classes"`
+
+	// Adaptive adjusts the egress limit to traffic on the host network.
+	Adaptive AdaptiveConfig `yaml:"adaptive"`
+}
+
+// ClassConfig defines a class of namespaces sharing bandwidth.
+type ClassConfig struct {
+	Name string `yaml:"name"`
+
+	// Namespaces is a list of regular expressions matching namespaces in the
+	// class. A namespace belongs to the first class it matches.
+	Namespaces []string `yaml:"namespaces"`
+
+	// Weight is the share of bandwidth the class receives relative to other
+	// busy classes. Defaults to 1.
+	Weight float64 `yaml:"weight"`
 }
 
 func (c Config) applyDefaults() Config {
 	if c.TokenSize == 0 {
 		c.TokenSize = 8 * memsize.Mbit
 	}
+	classes := make([]ClassConfig, len(c.Classes))
+	for i, cc := range c.Classes {
+		if cc.Weight == 0 {
+			cc.Weight = 1
+		}
+		classes[i] = cc
+	}
+	c.Classes = classes
 	return c
 }
 
+// defaultClass is the class of namespaces which match no configured class.
+const defaultClass = "default"
+
+type class struct {
+	name       string
+	namespaces []*regexp.Regexp
+	weight     float64
+}
+
 // Limiter limits egress and ingress bandwidth via token-bucket rate limiter.
+// Bucket tokens are granted to waiting reservations in weighted fair order,
+// first across namespace classes and then across keys (e.g. torrents) within
+// each class.
 type Limiter struct {
-	config  Config
-	egress  *rate.Limiter
-	ingress *rate.Limiter
-	logger  *zap.SugaredLogger
+	config   Config
+	classes  []class
+	egress   *fairQueue
+	ingress  *fairQueue
+	adaptive *adaptiveController
+	clk      clock.Clock
+	stats    tally.Scope
+	logger   *zap.SugaredLogger
+
+	mu      sync.Mutex // Protects weights.
+	weights map[string]float64
+
+	egressBytes *atomic.Int64 // Counted even if limits are disabled.
 }
 
 // Option allows setting optional parameters in Limiter.
@@ -63,13 +119,22 @@ func WithLogger(logger *zap.SugaredLogger) Option {
 	return func(l *Limiter) { l.logger = logger }
 }
 
+// WithStats configures a Limiter with stats.
+func WithStats(stats tally.Scope) Option {
+	return func(l *Limiter) { l.stats = stats }
+}
+
 // NewLimiter creates a new Limiter.
 func NewLimiter(config Config, opts ...Option) (*Limiter, error) {
 	config = config.applyDefaults()
 
 	l := &Limiter{
-		config: config,
-		logger: log.Default(),
+		config:      config,
+		clk:         clock.New(),
+		stats:       tally.NoopScope,
+		logger:      log.Default(),
+		weights:     make(map[string]float64),
+		egressBytes: atomic.NewInt64(0),
 	}
 	for _, opt := range opts {
 		opt(l)
@@ -86,6 +151,11 @@ func NewLimiter(config Config, opts ...Option) (*Limiter, error) {
 	if config.IngressBitsPerSec == 0 {
 		return nil, errors.New("invalid config: ingress_bits_per_sec must be non-zero")
 	}
+	classes, err := parseClasses(config.Classes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
+	}
+	l.classes = classes
 
 	l.logger.Infof("Setting egress bandwidth to %s/sec", memsize.BitFormat(config.EgressBitsPerSec))
 	l.logger.Infof("Setting ingress bandwidth to %s/sec", memsize.BitFormat(config.IngressBitsPerSec))
@@ -93,13 +163,87 @@ func NewLimiter(config Config, opts ...Option) (*Limiter, error) {
 	etps := config.EgressBitsPerSec / config.TokenSize
 	itps := config.IngressBitsPerSec / config.TokenSize
 
-	l.egress = rate.NewLimiter(rate.Limit(etps), int(etps))
-	l.ingress = rate.NewLimiter(rate.Limit(itps), int(itps))
+	l.egress = newFairQueue(rate.NewLimiter(rate.Limit(etps), int(etps)))
+	l.ingress = newFairQueue(rate.NewLimiter(rate.Limit(itps), int(itps)))
+
+	if config.Adaptive.Enable {
+		ac := config.Adaptive.applyDefaults(config.EgressBitsPerSec)
+		if err := ac.validate(); err != nil {
+			return nil, fmt.Errorf("invalid adaptive config: %s", err)
+		}
+		l.logger.Infof(
+			"Adapting egress bandwidth between %s/sec and %s/sec",
+			memsize.BitFormat(ac.MinEgressBitsPerSec), memsize.BitFormat(ac.MaxEgressBitsPerSec))
+		l.adaptive = newAdaptiveController(
+			ac, l.egress, config.TokenSize, l.egress.bytes.Load,
+			l.clk, l.stats.SubScope("adaptive_bandwidth"), l.logger)
+		l.adaptive.start()
+	}
 
 	return l, nil
 }
 
-func (l *Limiter) reserve(rl *rate.Limiter, nbytes int64) error {
+func parseClasses(configs []ClassConfig) ([]class, error) {
+	names := map[string]bool{defaultClass: true}
+	var classes []class
+	for _, cc := range configs {
+		if names[cc.Name] {
+			return nil, fmt.Errorf("class %q: duplicate or reserved name", cc.Name)
+		}
+		names[cc.Name] = true
+		if cc.Weight < 0 {
+			return nil, fmt.Errorf("class %q: weight must be positive", cc.Name)
+		}
+		c := class{name: cc.Name, weight: cc.Weight}
+		for _, ns := range cc.Namespaces {
+			re, err := regexp.Compile(ns)
+			if err != nil {
+				return nil, fmt.Errorf("class %q: namespace %q: %s", cc.Name, ns, err)
+			}
+			c.namespaces = append(c.namespaces, re)
+		}
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+// classify returns the name and weight of namespace's class.
+func (l *Limiter) classify(namespace string) (string, float64) {
+	for _, c := range l.classes {
+		for _, re := range c.namespaces {
+			if re.MatchString(namespace) {
+				return c.name, c.weight
+			}
+		}
+	}
+	return defaultClass, 1
+}
+
+func (l *Limiter) weight(key string) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if w, ok := l.weights[key]; ok {
+		return w
+	}
+	return 1
+}
+
+// SetWeight scales the share of bandwidth which reservations for key receive
+// relative to other keys in the same class. A weight of 1 restores the default
+// share.
+func (l *Limiter) SetWeight(key string, w float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if w == 1 || w <= 0 {
+		delete(l.weights, key)
+	} else {
+		l.weights[key] = w
+	}
+}
+
+func (l *Limiter) reserve(q *fairQueue, namespace, key string, nbytes int64) error {
 	if !l.config.Enable {
 		return nil
 	}
@@ -107,54 +251,119 @@ func (l *Limiter) reserve(rl *rate.Limiter, nbytes int64) error {
 	if tokens == 0 {
 		tokens = 1
 	}
-	r := rl.ReserveN(time.Now(), tokens)
-	if !r.OK() {
+	if tokens > q.maxTokens {
 		return fmt.Errorf(
 			"cannot reserve %s of bandwidth, max is %s",
 			memsize.Format(uint64(nbytes)),
-			memsize.BitFormat(l.config.TokenSize*uint64(rl.Burst())))
+			memsize.BitFormat(l.config.TokenSize*uint64(q.maxTokens)))
 	}
-	time.Sleep(r.Delay())
+	name, weight := l.classify(namespace)
+	q.wait(flowID{name, weight, key, l.weight(key)}, tokens)
+	q.bytes.Add(nbytes)
 	return nil
 }
 
 // ReserveEgress blocks until egress bandwidth for nbytes is available.
 // Returns error if nbytes is larger than the maximum egress bandwidth.
 func (l *Limiter) ReserveEgress(nbytes int64) error {
-	return l.reserve(l.egress, nbytes)
+	return l.reserveEgress("", "", nbytes)
 }
 
 // ReserveIngress blocks until ingress bandwidth for nbytes is available.
 // Returns error if nbytes is larger than the maximum ingress bandwidth.
 func (l *Limiter) ReserveIngress(nbytes int64) error {
-	return l.reserve(l.ingress, nbytes)
+	return l.reserve(l.ingress, "", "", nbytes)
+}
+
+// ReserveEgressFor is like ReserveEgress, but shares egress bandwidth with
+// other reservations according to namespace's class and key's weight.
+func (l *Limiter) ReserveEgressFor(namespace, key string, nbytes int64) error {
+	return l.reserveEgress(namespace, key, nbytes)
+}
+
+func (l *Limiter) reserveEgress(namespace, key string, nbytes int64) error {
+	if err := l.reserve(l.egress, namespace, key, nbytes); err != nil {
+		return err
+	}
+	l.egressBytes.Add(nbytes)
+	return nil
+}
+
+// EgressBytes returns the total number of egress bytes reserved, regardless of
+// whether limits are enabled.
+func (l *Limiter) EgressBytes() int64 {
+	return l.egressBytes.Load()
+}
+
+// ReserveIngressFor is like ReserveIngress, but shares ingress bandwidth with
+// other reservations according to namespace's class and key's weight.
+func (l *Limiter) ReserveIngressFor(namespace, key string, nbytes int64) error {
+	return l.reserve(l.ingress, namespace, key, nbytes)
 }
 
 // Adjust divides the originally configured egress and ingress bps by denominator.
 // Note, because the original configuration is always used, multiple Adjust calls
-// have no affect on each other.
+// have no affect on each other. If egress is adaptive, the maximum adaptive
+// egress limit is divided instead.
 func (l *Limiter) Adjust(denominator int) error {
 	if denominator <= 0 {
 		return errors.New("denominator must be greater than 0")
 	}
 
-	ebps := max(l.config.EgressBitsPerSec/l.config.TokenSize/uint64(denominator), 1)
 	ibps := max(l.config.IngressBitsPerSec/l.config.TokenSize/uint64(denominator), 1)
+	l.ingress.setLimit(rate.Limit(ibps))
 
-	l.egress.SetLimit(rate.Limit(ebps))
-	l.ingress.SetLimit(rate.Limit(ibps))
+	if l.adaptive != nil {
+		l.adaptive.setCeiling(l.adaptive.config.MaxEgressBitsPerSec / uint64(denominator))
+		return nil
+	}
+	ebps := max(l.config.EgressBitsPerSec/l.config.TokenSize/uint64(denominator), 1)
+	l.egress.setLimit(rate.Limit(ebps))
 
 	return nil
 }
 
 // EgressLimit returns the current egress limit.
 func (l *Limiter) EgressLimit() int64 {
-	return int64(l.egress.Limit())
+	return int64(l.egress.limit())
 }
 
 // IngressLimit returns the current ingress limit.
 func (l *Limiter) IngressLimit() int64 {
-	return int64(l.ingress.Limit())
+	return int64(l.ingress.limit())
+}
+
+// Snapshot describes the current limits of a Limiter.
+type Snapshot struct {
+	Enable            bool              `json:"enable"`
+	EgressBitsPerSec  uint64            `json:"egress_bits_per_sec"`
+	IngressBitsPerSec uint64            `json:"ingress_bits_per_sec"`
+	Adaptive          *AdaptiveSnapshot `json:"adaptive,omitempty"`
+}
+
+// Snapshot returns the current limits of l.
+func (l *Limiter) Snapshot() Snapshot {
+	if !l.config.Enable {
+		return Snapshot{}
+	}
+	s := Snapshot{
+		Enable:            true,
+		EgressBitsPerSec:  uint64(l.EgressLimit()) * l.config.TokenSize,
+		IngressBitsPerSec: uint64(l.IngressLimit()) * l.config.TokenSize,
+	}
+	if l.adaptive != nil {
+		a := l.adaptive.getSnapshot()
+		s.Adaptive = &a
+	}
+	return s
+}
+
+// Close stops adapting l's egress limit. Reservations are still limited
+// by the last adapted limit.
+func (l *Limiter) Close() {
+	if l.adaptive != nil {
+		l.adaptive.stop()
+	}
 }
 
 func max(a, b uint64) uint64 {
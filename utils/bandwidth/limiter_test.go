@@ -14,6 +14,7 @@
 package bandwidth
 
 import (
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -73,6 +74,27 @@ func TestLimiterDisabled(t *testing.T) {
 	require.NoError(reserve(l, 1, ingress))
 }
 
+func TestLimiterEgressBytes(t *testing.T) {
+	for _, enable := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enable=%t", enable), func(t *testing.T) {
+			require := require.New(t)
+
+			l, err := NewLimiter(Config{
+				EgressBitsPerSec:  8000,
+				IngressBitsPerSec: 8000,
+				TokenSize:         1,
+				Enable:            enable,
+			})
+			require.NoError(err)
+
+			require.NoError(l.ReserveEgress(10))
+			require.NoError(l.ReserveEgressFor("", "foo", 20))
+			require.NoError(l.ReserveIngress(40))
+			require.Equal(int64(30), l.EgressBytes())
+		})
+	}
+}
+
 func TestLimiterReserveConcurrency(t *testing.T) {
 	t.Parallel()
 
@@ -248,3 +270,103 @@ func TestLimiterAdjust(t *testing.T) {
 		require.Equal(c.ingress, l.IngressLimit())
 	}
 }
+
+func TestLimiterInvalidClasses(t *testing.T) {
+	for _, classes := range [][]ClassConfig{
+		{{Name: "a"}, {Name: "a"}},
+		{{Name: "default"}},
+		{{Name: "a", Weight: -1}},
+		{{Name: "a", Namespaces: []string{"("}}},
+	} {
+		_, err := NewLimiter(Config{
+			EgressBitsPerSec:  800,
+			IngressBitsPerSec: 800,
+			TokenSize:         1,
+			Enable:            true,
+			Classes:           classes,
+		})
+		require.Error(t, err)
+	}
+}
+
+func TestLimiterClassify(t *testing.T) {
+	require := require.New(t)
+
+	l, err := NewLimiter(Config{
+		EgressBitsPerSec:  800,
+		IngressBitsPerSec: 800,
+		TokenSize:         1,
+		Enable:            true,
+		Classes: []ClassConfig{
+			{Name: "models", Namespaces: []string{"^ml/.*"}, Weight: 0.5},
+			{Name: "docker", Namespaces: []string{"^docker/.*", ".*"}},
+		},
+	})
+	require.NoError(err)
+
+	name, weight := l.classify("ml/model:v1")
+	require.Equal("models", name)
+	require.Equal(0.5, weight)
+
+	name, weight = l.classify("library/ubuntu")
+	require.Equal("docker", name)
+	require.Equal(1.0, weight)
+}
+
+func TestLimiterReserveSharesBandwidthByClass(t *testing.T) {
+	t.Parallel()
+
+	require := require.New(t)
+
+	bps := uint64(8000) // 1000 bytes.
+
+	l, err := NewLimiter(Config{
+		EgressBitsPerSec:  bps,
+		IngressBitsPerSec: bps,
+		TokenSize:         8,
+		Enable:            true,
+		Classes: []ClassConfig{
+			{Name: "high", Namespaces: []string{"^high$"}, Weight: 3},
+			{Name: "low", Namespaces: []string{"^low$"}, Weight: 1},
+		},
+	})
+	require.NoError(err)
+
+	// Drain the bucket so the initial burst does not skew the shares.
+	require.NoError(l.ReserveEgress(1000))
+
+	stop := make(chan struct{})
+	time.AfterFunc(2*time.Second, func() { close(stop) })
+
+	var mu sync.Mutex
+	nbytes := make(map[string]int)
+
+	var wg sync.WaitGroup
+	for _, namespace := range []string{"high", "low"} {
+		// Many torrents in the low class should not increase its share.
+		n := 4
+		if namespace == "low" {
+			n = 12
+		}
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(namespace string, key int) {
+				defer wg.Done()
+				for {
+					require.NoError(l.ReserveEgressFor(namespace, string(rune('a'+key)), 10))
+					select {
+					case <-stop:
+						return
+					default:
+						mu.Lock()
+						nbytes[namespace] += 10
+						mu.Unlock()
+					}
+				}
+			}(namespace, i)
+		}
+	}
+	wg.Wait()
+
+	require.InDelta(3.0, float64(nbytes["high"])/float64(nbytes["low"]), 0.5)
+}
@@ -22,6 +22,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/cenkalti/backoff"
@@ -105,6 +106,26 @@ func IsForbidden(err error) bool {
 	return IsStatus(err, http.StatusForbidden)
 }
 
+// IsTooManyRequests returns true if err is a "too many requests" StatusError.
+func IsTooManyRequests(err error) bool {
+	return IsStatus(err, http.StatusTooManyRequests)
+}
+
+// RetryAfter returns the delay requested by the Retry-After header of a
+// StatusError. Only delays in seconds are supported. Returns false if err is
+// not a StatusError or has no valid Retry-After header.
+func RetryAfter(err error) (time.Duration, bool) {
+	statusErr, ok := err.(StatusError)
+	if !ok || statusErr.Header == nil {
+		return 0, false
+	}
+	sec, err := strconv.Atoi(statusErr.Header.Get("Retry-After"))
+	if err != nil || sec < 0 {
+		return 0, false
+	}
+	return time.Duration(sec) * time.Second, true
+}
+
 func isRetryable(code int) bool {
 	_, ok := retryableCodes[code]
 	return ok
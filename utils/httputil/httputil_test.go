@@ -313,3 +313,29 @@ func TestParseDigestInvalid(t *testing.T) {
 	_, err := ParseDigest(r, "digest")
 	require.Error(err)
 }
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		desc     string
+		err      error
+		expected time.Duration
+		ok       bool
+	}{
+		{"seconds", StatusError{Header: http.Header{"Retry-After": {"5"}}}, 5 * time.Second, true},
+		{"missing", StatusError{Header: http.Header{}}, 0, false},
+		{"nil header", StatusError{}, 0, false},
+		{"http date", StatusError{Header: http.Header{
+			"Retry-After": {"Wed, 21 Oct 2015 07:28:00 GMT"}}}, 0, false},
+		{"negative", StatusError{Header: http.Header{"Retry-After": {"-1"}}}, 0, false},
+		{"not status error", errors.New("some error"), 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			require := require.New(t)
+
+			d, ok := RetryAfter(test.err)
+			require.Equal(test.ok, ok)
+			require.Equal(test.expected, d)
+		})
+	}
+}
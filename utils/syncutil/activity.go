@@ -0,0 +1,104 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package syncutil
+
+import (
+	"sync"
+	"time"
+)
+
+// Activity counts outstanding units of work, such as queued messages and
+// running goroutines, such that a driver can tell when a system has gone idle.
+// Work is added before it is handed off and done once it has been handled, so
+// the count never drops to zero while work is still being passed along.
+//
+// All methods are no-ops on a nil Activity, so code can be instrumented at no
+// cost when nobody waits on it.
+type Activity struct {
+	mu      sync.Mutex
+	n       int
+	changed chan struct{}
+}
+
+// NewActivity creates a new Activity with no outstanding work.
+func NewActivity() *Activity {
+	return &Activity{changed: make(chan struct{})}
+}
+
+// Add adds delta units of outstanding work, which may be negative.
+func (a *Activity) Add(delta int) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.n += delta
+	if a.n < 0 {
+		panic("syncutil: negative Activity count")
+	}
+	if delta < 0 {
+		close(a.changed)
+		a.changed = make(chan struct{})
+	}
+}
+
+// Done marks a unit of work as done.
+func (a *Activity) Done() {
+	a.Add(-1)
+}
+
+// Go runs f in a new goroutine, counting it as outstanding work until f
+// returns.
+func (a *Activity) Go(f func()) {
+	a.Add(1)
+	go func() {
+		defer a.Done()
+		f()
+	}()
+}
+
+// Count returns the number of outstanding units of work.
+func (a *Activity) Count() int {
+	if a == nil {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.n
+}
+
+// Wait blocks until at most n units of work are outstanding, or timeout
+// elapses, and returns the number of units outstanding at that point.
+func (a *Activity) Wait(n int, timeout time.Duration) int {
+	if a == nil {
+		return 0
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		a.mu.Lock()
+		cur, changed := a.n, a.changed
+		a.mu.Unlock()
+		if cur <= n {
+			return cur
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return a.Count()
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2016-2019 Uber Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package syncutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivityWaitsForHandoffs(t *testing.T) {
+	require := require.New(t)
+
+	a := NewActivity()
+	in := make(chan int, 1)
+	out := make(chan int, 1)
+
+	a.Add(1)
+	in <- 1
+	a.Go(func() {
+		v := <-in
+		a.Add(1)
+		out <- v + 1
+		a.Done()
+	})
+	var result int
+	go func() {
+		result = <-out
+		a.Done()
+	}()
+
+	require.Equal(0, a.Wait(0, 5*time.Second))
+	require.Equal(2, result)
+}
+
+func TestActivityWaitTimesOut(t *testing.T) {
+	require := require.New(t)
+
+	a := NewActivity()
+	a.Add(2)
+	require.Equal(2, a.Wait(1, 10*time.Millisecond))
+	a.Done()
+	require.Equal(1, a.Wait(1, time.Second))
+}
+
+func TestActivityNilIsNoop(t *testing.T) {
+	require := require.New(t)
+
+	var a *Activity
+	a.Add(1)
+	a.Done()
+	done := make(chan struct{})
+	a.Go(func() { close(done) })
+	<-done
+	require.Equal(0, a.Count())
+	require.Equal(0, a.Wait(0, time.Second))
+}
+
+func TestActivityNegativeCountPanics(t *testing.T) {
+	require.Panics(t, func() { NewActivity().Done() })
+}